@@ -1,12 +1,14 @@
 module github.com/inercia/terraform-provider-kubeadm
 
 require (
+	cloud.google.com/go v0.36.0
 	github.com/DATA-DOG/go-sqlmock v1.3.3 // indirect
 	github.com/MakeNowJust/heredoc v0.0.0-20171113091838-e9091a26100e // indirect
 	github.com/Masterminds/goutils v1.1.0 // indirect
 	github.com/Masterminds/semver v1.4.2 // indirect
 	github.com/Masterminds/sprig v2.20.0+incompatible // indirect
 	github.com/armon/circbuf v0.0.0-20190214190532-5111143e8da2
+	github.com/aws/aws-sdk-go v1.20.4
 	github.com/chai2010/gettext-go v0.0.0-20170215093142-bf70f2a70fb1 // indirect
 	github.com/cyphar/filepath-securejoin v0.2.2 // indirect
 	github.com/davecgh/go-spew v1.1.1
@@ -32,6 +34,8 @@ require (
 	github.com/spf13/afero v1.2.2 // indirect
 	github.com/xlab/handysort v0.0.0-20150421192137-fb3537ed64a1 // indirect
 	github.com/ziutek/mymysql v1.5.4 // indirect
+	golang.org/x/crypto v0.0.0-20190621222207-cc06ce4a13d4
+	google.golang.org/api v0.1.0
 	gopkg.in/gorp.v1 v1.7.2 // indirect
 	k8s.io/apiextensions-apiserver v0.0.0-20190315093550-53c4693659ed // indirect
 	k8s.io/apiserver v0.0.0-20190424053242-2200fef3ea67 // indirect
@@ -44,6 +48,7 @@ require (
 	k8s.io/kubelet v0.0.0-20190314002251-f6da02f58325 // indirect
 	k8s.io/kubernetes v1.14.1
 	k8s.io/utils v0.0.0-20190308190857-21c4ce38f2a7 // indirect
+	sigs.k8s.io/yaml v1.1.0
 	vbom.ml/util v0.0.0-20180919145318-efcd4e0f9787 // indirect
 )
 
@@ -0,0 +1,254 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+const (
+	// name of the cache directory (inside the user's cache dir)
+	defCacheDirName = "terraform-provider-kubeadm"
+
+	// checksum used for artifacts without checksum
+	noChecksum = "no-checksum"
+)
+
+// Artifact is something (a binary, a tarball...) that can be downloaded
+type Artifact struct {
+	// Name is the name of the artifact (ie, "kubeadm")
+	Name string
+
+	// Version and Arch of the artifact
+	Version string
+	Arch    string
+
+	// URL where the artifact can be downloaded from
+	URL string
+
+	// Checksum is the (optional) SHA256 of the artifact
+	Checksum string
+
+	// ChecksumURL is an (optional) URL where the SHA256 can be obtained when
+	// no Checksum is provided (ie, a "sha256sum"-like file)
+	ChecksumURL string
+
+	// Verification is the (optional) signature verification for the artifact
+	Verification *Verification
+}
+
+func (a Artifact) String() string {
+	return fmt.Sprintf("%s-%s-%s", a.Name, a.Version, a.Arch)
+}
+
+// Cache is a read-through cache for artifacts, in a local directory in the
+// machine where Terraform is running. Artifacts are keyed by
+// name+version+arch+checksum, so they are only downloaded once.
+type Cache struct {
+	dir string
+}
+
+// NewCache creates a new cache in `dir` (or in the user's cache dir when empty)
+func NewCache(dir string) (Cache, error) {
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return Cache{}, fmt.Errorf("could not get the user's cache directory: %s", err)
+		}
+		dir = filepath.Join(userCacheDir, defCacheDirName)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Cache{}, fmt.Errorf("could not create cache directory %q: %s", dir, err)
+	}
+	return Cache{dir: dir}, nil
+}
+
+// Dir returns the cache directory
+func (c Cache) Dir() string {
+	return c.dir
+}
+
+// fileSHA256 returns the SHA256 (as an hex string) of a file
+func fileSHA256(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// httpGet performs a GET, returning an error for non-200 responses
+func httpGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s failed with status %q", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// getChecksum returns the expected checksum for an artifact
+func getChecksum(ctx context.Context, a Artifact) (string, error) {
+	if a.Checksum != "" {
+		return strings.ToLower(a.Checksum), nil
+	}
+	if a.ChecksumURL == "" {
+		return "", nil
+	}
+
+	resp, err := httpGet(ctx, a.ChecksumURL)
+	if err != nil {
+		return "", fmt.Errorf("could not get checksum for %s: %s", a, err)
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	// files can be "<checksum>" or "<checksum>  <filename>"
+	fields := strings.Fields(string(contents))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("empty checksum for %s", a)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// Path returns the path for an artifact (with a known checksum) in the cache
+func (c Cache) Path(a Artifact, checksum string) string {
+	if checksum == "" {
+		checksum = noChecksum
+	}
+	return filepath.Join(c.dir, a.Name, a.Version, a.Arch, checksum, path.Base(a.URL))
+}
+
+// Get returns the local path for an artifact, downloading it
+// when it is not in the cache
+func (c Cache) Get(ctx context.Context, a Artifact) (string, error) {
+	checksum, err := getChecksum(ctx, a)
+	if err != nil {
+		return "", err
+	}
+
+	local := c.Path(a, checksum)
+	if _, err := os.Stat(local); err == nil {
+		sum := ""
+		if checksum != "" {
+			sum, _ = fileSHA256(local)
+		}
+		if sum == checksum {
+			ssh.Debug("%s found in cache at %q", a, local)
+			if err := c.verify(ctx, a, local); err != nil {
+				return "", err
+			}
+			return local, nil
+		}
+		ssh.Debug("%s found in cache at %q, but with a wrong checksum: downloading it again", a, local)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(local), 0700); err != nil {
+		return "", err
+	}
+
+	ssh.Debug("downloading %s from %s", a, a.URL)
+	resp, err := httpGet(ctx, a.URL)
+	if err != nil {
+		return "", fmt.Errorf("could not download %s: %s", a, err)
+	}
+	defer resp.Body.Close()
+
+	// download to a temporary file (in the same directory) and then rename it,
+	// so other concurrent downloads never see a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(local), ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, h), resp.Body); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("could not download %s: %s", a, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	if sum := hex.EncodeToString(h.Sum(nil)); checksum != "" && sum != checksum {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", a, checksum, sum)
+	}
+
+	// verify the signature before the artifact is in the cache
+	if err := c.verify(ctx, a, tmp.Name()); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), local); err != nil {
+		return "", err
+	}
+	return local, nil
+}
+
+// verify checks the signature of a local copy of an artifact (when a verification
+// has been configured). Artifacts found in the cache are verified too, as they
+// could have been downloaded without a verification.
+func (c Cache) verify(ctx context.Context, a Artifact, local string) error {
+	if a.Verification == nil {
+		return nil
+	}
+	if err := a.Verification.verify(ctx, a, local); err != nil {
+		return err
+	}
+	ssh.Debug("signature of %s verified", a)
+	return nil
+}
+
+// DoGetArtifact gets an artifact from the cache (downloading it if needed),
+// saving the local path in `local`
+func DoGetArtifact(c Cache, a Artifact, local *string) ssh.Action {
+	return ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+		p, err := c.Get(ctx, a)
+		if err != nil {
+			return ssh.ActionError(err.Error())
+		}
+		*local = p
+		return nil
+	})
+}
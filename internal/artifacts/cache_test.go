@@ -0,0 +1,90 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCacheGet(t *testing.T) {
+	contents := []byte("some binary contents")
+	sum := sha256.Sum256(contents)
+	checksum := hex.EncodeToString(sum[:])
+
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kubeadm":
+			downloads++
+			_, _ = w.Write(contents)
+		case "/kubeadm.sha256":
+			_, _ = w.Write([]byte(fmt.Sprintf("%s  kubeadm\n", checksum)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, err := NewCache(dir)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	a := Artifact{
+		Name:        "kubeadm",
+		Version:     "v1.15.0",
+		Arch:        "amd64",
+		URL:         server.URL + "/kubeadm",
+		ChecksumURL: server.URL + "/kubeadm.sha256",
+	}
+
+	for i := 0; i < 2; i++ {
+		local, err := cache.Get(context.Background(), a)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if local != cache.Path(a, checksum) {
+			t.Fatalf("Error: unexpected path in cache: %q", local)
+		}
+		got, _ := ioutil.ReadFile(local)
+		if string(got) != string(contents) {
+			t.Fatalf("Error: unexpected contents in cache: %q", got)
+		}
+	}
+	if downloads != 1 {
+		t.Fatalf("Error: artifact downloaded %d times", downloads)
+	}
+
+	// a wrong checksum must be detected
+	a.ChecksumURL = ""
+	a.Checksum = "0123456789"
+	if _, err := cache.Get(context.Background(), a); err == nil {
+		t.Fatalf("Error: checksum mismatch not detected")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+const (
+	// default cosign binary (looked up in the $PATH)
+	defCosignPath = "cosign"
+)
+
+// Verification is the (cosign/sigstore) signature verification of an artifact.
+// Either a public Key or a CertificateURL (for keyless signatures) must be provided,
+// and keyless signatures require the CertificateIdentity and CertificateOIDCIssuer
+// (otherwise any certificate issued by sigstore would be accepted).
+type Verification struct {
+	// CosignPath is the path to the cosign binary (defaults to "cosign" in the $PATH)
+	CosignPath string
+
+	// SignatureURL is the URL of the signature (defaults to the artifact URL + ".sig")
+	SignatureURL string
+
+	// Key is the (local path, URL or KMS URI) public key used for verifying the signature
+	Key string
+
+	// CertificateURL is the URL of the signing certificate, for keyless signatures
+	CertificateURL string
+
+	// CertificateIdentity and CertificateOIDCIssuer are the identity and issuer
+	// expected in the signing certificate, for keyless signatures
+	CertificateIdentity   string
+	CertificateOIDCIssuer string
+}
+
+// downloadToTempFile downloads a URL to a temporary file in a directory
+func downloadToTempFile(ctx context.Context, url string, dir string) (string, error) {
+	resp, err := httpGet(ctx, url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := ioutil.TempFile(dir, ".verify-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), tmp.Close()
+}
+
+// verify checks the signature of a local copy of an artifact with cosign
+func (v Verification) verify(ctx context.Context, a Artifact, local string) error {
+	if v.Key == "" && v.CertificateURL == "" {
+		return fmt.Errorf("no key or certificate for verifying the signature of %s", a)
+	}
+	if v.Key == "" && (v.CertificateIdentity == "" || v.CertificateOIDCIssuer == "") {
+		return fmt.Errorf("no certificate identity or OIDC issuer for verifying the keyless signature of %s", a)
+	}
+
+	signatureURL := v.SignatureURL
+	if signatureURL == "" {
+		signatureURL = a.URL + ".sig"
+	}
+	signature, err := downloadToTempFile(ctx, signatureURL, filepath.Dir(local))
+	if err != nil {
+		return fmt.Errorf("could not get the signature of %s: %s", a, err)
+	}
+	defer os.Remove(signature)
+
+	args := []string{"verify-blob", "--signature", signature}
+	if v.Key != "" {
+		args = append(args, "--key", v.Key)
+	} else {
+		certificate, err := downloadToTempFile(ctx, v.CertificateURL, filepath.Dir(local))
+		if err != nil {
+			return fmt.Errorf("could not get the signing certificate of %s: %s", a, err)
+		}
+		defer os.Remove(certificate)
+
+		args = append(args, "--certificate", certificate,
+			"--certificate-identity", v.CertificateIdentity,
+			"--certificate-oidc-issuer", v.CertificateOIDCIssuer)
+	}
+	args = append(args, local)
+
+	cosign := v.CosignPath
+	if cosign == "" {
+		cosign = defCosignPath
+	}
+
+	ssh.Debug("verifying the signature of %s with %s %s", a, cosign, strings.Join(args, " "))
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, cosign, args...)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("signature verification failed for %s: %s: %s", a, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
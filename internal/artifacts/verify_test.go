@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package artifacts
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheGetWithVerification(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/kubeadm":
+			_, _ = w.Write([]byte("some binary contents"))
+		case "/kubeadm.sig":
+			_, _ = w.Write([]byte("good-signature"))
+		case "/kubectl":
+			_, _ = w.Write([]byte("some other contents"))
+		case "/kubectl.sig":
+			_, _ = w.Write([]byte("bad-signature"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a fake cosign that accepts only "good" signatures
+	cosign := filepath.Join(dir, "cosign")
+	script := "#!/bin/sh\n[ \"$1\" = verify-blob ] && [ \"$2\" = --signature ] && grep -q good \"$3\"\n"
+	if err := ioutil.WriteFile(cosign, []byte(script), 0755); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	cache, err := NewCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	verification := &Verification{CosignPath: cosign, Key: "cosign.pub"}
+	a := Artifact{Name: "kubeadm", Version: "v1.15.0", Arch: "amd64", URL: server.URL + "/kubeadm", Verification: verification}
+	if _, err := cache.Get(context.Background(), a); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	b := Artifact{Name: "kubectl", Version: "v1.15.0", Arch: "amd64", URL: server.URL + "/kubectl", Verification: verification}
+	if _, err := cache.Get(context.Background(), b); err == nil {
+		t.Fatalf("Error: bad signature not detected")
+	}
+	if _, err := os.Stat(cache.Path(b, "")); err == nil {
+		t.Fatalf("Error: artifact with a bad signature stored in the cache")
+	}
+
+	// artifacts in the cache are verified too
+	b.Verification = nil
+	if _, err := cache.Get(context.Background(), b); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	b.Verification = verification
+	if _, err := cache.Get(context.Background(), b); err == nil {
+		t.Fatalf("Error: bad signature not detected for an artifact in the cache")
+	}
+
+	// a key or a certificate is required
+	a.Verification = &Verification{CosignPath: cosign}
+	if _, err := cache.Get(context.Background(), a); err == nil {
+		t.Fatalf("Error: verification without key or certificate not detected")
+	}
+
+	// keyless signatures require the identity and issuer of the certificate
+	a.Verification = &Verification{CosignPath: cosign, CertificateURL: server.URL + "/kubeadm.cert", CertificateIdentity: "someone@example.com"}
+	if _, err := cache.Get(context.Background(), a); err == nil {
+		t.Fatalf("Error: keyless verification without issuer not detected")
+	}
+}
@@ -15,6 +15,7 @@
 package assets
 
 //go:generate ../../utils/generate.sh --out-var KubeadmSetupScriptCode --out-package assets  --out-file generated_kubeadm_setup.go ./static/kubeadm-setup.sh
+//go:generate ../../utils/generate.sh --out-var KubeadmUninstallScriptCode --out-package assets --out-file generated_kubeadm_uninstall.go ./static/kubeadm-uninstall.sh
 //go:generate ../../utils/generate.sh --out-var KubeletSysconfigCode --out-package assets --out-file generated_kubelet_sysconfig.go ./static/kubelet.sysconfig
 //go:generate ../../utils/generate.sh --out-var KubeadmDropinCode --out-package assets --out-file generated_kubeadm_dropin.go ./static/kubeadm-dropin.conf
 //go:generate ../../utils/generate.sh --out-var KubeletServiceCode --out-package assets --out-file generated_kubelet_service.go ./static/service.conf
@@ -22,3 +23,8 @@ package assets
 //go:generate ../../utils/generate.sh --out-var FlannelManifestCode --out-package assets --out-file generated_flannel_manifest.go ./static/kube-flannel.yml
 //go:generate ../../utils/generate.sh --out-var CloudProviderCode --out-package assets --out-file cloud_provider_manifest.go ./static/cloud-provider.yml
 //go:generate ../../utils/generate.sh --out-var WeaveManifestCode --out-package assets --out-file weave_manifest.go ./static/weave.yml
+//go:generate ../../utils/generate.sh --out-var DNSAutoscalerManifestCode --out-package assets --out-file dns_autoscaler_manifest.go ./static/dns-autoscaler.yml
+//go:generate ../../utils/generate.sh --out-var CertManagerIssuerCode --out-package assets --out-file cert_manager_issuer_manifest.go ./static/cert-manager-issuer.yml
+//go:generate ../../utils/generate.sh --out-var ConfigWatchdogScriptCode --out-package assets --out-file generated_config_watchdog.go ./static/config-watchdog.sh
+//go:generate ../../utils/generate.sh --out-var ConfigWatchdogServiceCode --out-package assets --out-file generated_config_watchdog_service.go ./static/config-watchdog.service
+//go:generate ../../utils/generate.sh --out-var ConfigWatchdogTimerCode --out-package assets --out-file generated_config_watchdog_timer.go ./static/config-watchdog.timer
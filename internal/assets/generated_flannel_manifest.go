@@ -114,6 +114,9 @@ data:
         {
           "type": "flannel",
           "delegate": {
+{{- if .cni_mtu}}
+            "mtu": {{.cni_mtu}},
+{{- end}}
             "hairpinMode": true,
             "isDefaultGateway": true
           }
@@ -0,0 +1,60 @@
+#!/bin/sh
+
+##########################################################################################
+# kubeadm configuration watchdog
+# compares the kubelet and container runtime configuration with the baseline recorded
+# by the kubeadm provisioner, reporting the drifted files in a node annotation and
+# restoring the baseline when a remediation has been requested (with another annotation)
+##########################################################################################
+
+BASELINE_DIR="${BASELINE_DIR:-/etc/kubernetes/kubeadm-baseline}"
+KUBECONFIG="${KUBECONFIG:-/etc/kubernetes/kubelet.conf}"
+KUBECTL="${KUBECTL:-kubectl}"
+
+DRIFT_ANNOTATION="kubeadm.terraform.io/config-drift"
+REMEDIATE_ANNOTATION="kubeadm.terraform.io/config-drift-remediate"
+
+##########################################################################################
+
+log()    { echo "[kubeadm configuration watchdog] $@" ; }
+warn()   { log "WARNING!!!!: $@" ; }
+abort()  { log "FATAL: $@" ; exit 1 ; }
+
+kctl()   { $KUBECTL --kubeconfig="$KUBECONFIG" "$@" ; }
+
+# print the files that are different from the baseline
+drifted() {
+    ( cd "$BASELINE_DIR/files" && find . -type f ) | sed -e 's|^\.||' | while read f ; do
+        cmp -s "$BASELINE_DIR/files$f" "$f" || echo "$f"
+    done
+}
+
+##########################################################################################
+
+[ -f "$BASELINE_DIR/nodename" ] || abort "no baseline found in $BASELINE_DIR"
+NODE=$(cat "$BASELINE_DIR/nodename")
+
+remediate=$(kctl get node "$NODE" --output=jsonpath="{.metadata.annotations.kubeadm\.terraform\.io/config-drift-remediate}")
+if [ "$remediate" = "true" ] ; then
+    restore=$(drifted)
+    for f in $restore ; do
+        log "restoring $f..."
+        mkdir -p "$(dirname "$f")"
+        cp -a "$BASELINE_DIR/files$f" "$f" || warn "could not restore $f"
+    done
+
+    if [ -n "$restore" ] ; then
+        systemctl --no-pager daemon-reload
+        if echo "$restore" | grep -q "^/etc/containerd/" ; then
+            log "restarting containerd..."
+            systemctl --no-pager restart containerd.service || warn "could not restart containerd"
+        fi
+        log "restarting the kubelet..."
+        systemctl --no-pager restart kubelet.service || warn "could not restart the kubelet"
+    fi
+    kctl annotate node "$NODE" "$REMEDIATE_ANNOTATION-" || warn "could not remove the remediation request"
+fi
+
+files=$(drifted | tr '\n' ',' | sed -e 's|,$||')
+[ -n "$files" ] && warn "configuration drift detected: $files"
+kctl annotate --overwrite node "$NODE" "$DRIFT_ANNOTATION=$files" || abort "could not annotate node $NODE"
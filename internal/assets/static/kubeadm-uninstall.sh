@@ -0,0 +1,58 @@
+#!/bin/sh
+
+##########################################################################################
+# kubeadm uninstall script
+# removes the packages and repositories installed by the setup script
+# note: the container runtime (docker) is not removed
+##########################################################################################
+
+PKG_SUSE_PACKAGES="kubernetes-kubeadm kubernetes-kubelet kubernetes-client"
+PKG_SUSE_REPO_NAME="kubernetes"
+
+PKG_APT_PACKAGES="kubeadm kubelet kubectl kubernetes-cni"
+PKG_APT_SRCLST="/etc/apt/sources.list.d/kubernetes.list"
+
+PKG_YUM_PACKAGES="kubeadm kubelet kubectl kubernetes-cni"
+PKG_YUM_REPOFILE="/etc/yum.repos.d/kubernetes.repo"
+
+# binaries installed by the generic installation
+GENERIC_BINARIES="/opt/bin/kubeadm /opt/bin/kubelet /opt/bin/kubectl"
+
+##########################################################################################
+
+log()    { echo "[kubeadm uninstall script] $@" ; }
+warn()   { log "WARNING!!!!: $@" ; }
+
+uninstall_zypper() {
+    log "uninstalling packages with zypper..."
+    zypper --non-interactive remove --clean-deps $PKG_SUSE_PACKAGES || warn "could not remove some packages"
+    zypper --non-interactive removerepo $PKG_SUSE_REPO_NAME || warn "could not remove the repository"
+}
+
+uninstall_yum() {
+    log "uninstalling packages with yum..."
+    yum remove -y $PKG_YUM_PACKAGES || warn "could not remove some packages"
+    rm -f $PKG_YUM_REPOFILE
+}
+
+uninstall_apt() {
+    log "uninstalling packages with apt..."
+    apt-get purge -y $PKG_APT_PACKAGES || warn "could not remove some packages"
+    rm -f $PKG_APT_SRCLST
+    apt-get update || warn "could not update the list of packages"
+}
+
+##########################################################################################
+
+if command -v zypper >/dev/null 2>&1 ; then
+    uninstall_zypper
+elif command -v apt-get >/dev/null 2>&1 ; then
+    uninstall_apt
+elif command -v yum >/dev/null 2>&1 ; then
+    uninstall_yum
+fi
+
+log "removing binaries from the generic installation (if present)..."
+rm -f $GENERIC_BINARIES
+
+log "... uninstall finished"
@@ -150,6 +150,10 @@ items:
                     fieldRef:
                       apiVersion: v1
                       fieldPath: spec.nodeName
+{{- if .cni_mtu}}
+                - name: WEAVE_MTU
+                  value: '{{.cni_mtu}}'
+{{- end}}
               image: 'docker.io/weaveworks/weave-kube:2.5.2'
               readinessProbe:
                 httpGet:
@@ -0,0 +1,161 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// environment variables for Vault
+	vaultAddrEnv  = "VAULT_ADDR"
+	vaultTokenEnv = "VAULT_TOKEN"
+
+	// command for reading 1Password secrets
+	onePasswordCmd = "op"
+)
+
+// Resolve obtains a secret from a source, where the source can be
+//
+//	file://<path>               a local file (a plain path is also accepted)
+//	env://<VAR>                 an environment variable
+//	vault://<path>#<field>      a field in a Vault secret (using VAULT_ADDR and VAULT_TOKEN)
+//	op://<vault>/<item>/<field> a 1Password secret reference (using the `op` CLI)
+//	cmd://<command>             the output of a local command
+func Resolve(ctx context.Context, source string) (string, error) {
+	source = strings.TrimSpace(source)
+	if source == "" {
+		return "", fmt.Errorf("empty credentials source")
+	}
+
+	scheme, rest := "file", source
+	if i := strings.Index(source, "://"); i > 0 {
+		scheme, rest = source[:i], source[i+len("://"):]
+	}
+
+	switch scheme {
+	case "file":
+		return resolveFile(rest)
+	case "env":
+		return resolveEnv(rest)
+	case "vault":
+		return resolveVault(ctx, rest)
+	case "op":
+		return resolveCommand(ctx, onePasswordCmd, "read", source)
+	case "cmd":
+		return resolveCommand(ctx, "sh", "-c", rest)
+	}
+	return "", fmt.Errorf("unknown credentials source %q", scheme)
+}
+
+// resolveFile reads a secret from a local file
+func resolveFile(path string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read credentials from %q: %s", path, err)
+	}
+	return string(contents), nil
+}
+
+// resolveEnv reads a secret from an environment variable
+func resolveEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok || value == "" {
+		return "", fmt.Errorf("environment variable %q is empty", name)
+	}
+	return value, nil
+}
+
+// resolveVault reads a field from a Vault secret, supporting both the KV v1 and v2 engines
+func resolveVault(ctx context.Context, ref string) (string, error) {
+	path, field := ref, ""
+	if i := strings.LastIndex(ref, "#"); i >= 0 {
+		path, field = ref[:i], ref[i+1:]
+	}
+	if path == "" || field == "" {
+		return "", fmt.Errorf("invalid Vault reference %q: it must be <path>#<field>", ref)
+	}
+
+	addr := os.Getenv(vaultAddrEnv)
+	if addr == "" {
+		return "", fmt.Errorf("no Vault address: %s is not set", vaultAddrEnv)
+	}
+
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-Vault-Token", os.Getenv(vaultTokenEnv))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not read Vault secret %q: %s", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not read Vault secret %q: %s", path, resp.Status)
+	}
+
+	secret := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("could not parse Vault secret %q: %s", path, err)
+	}
+
+	data := secret.Data
+	// KV v2 secrets have the data in `data.data`
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		data = inner
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("no field %q in Vault secret %q", field, path)
+	}
+	return value, nil
+}
+
+// resolveCommand obtains a secret from the output of a local command
+func resolveCommand(ctx context.Context, name string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// note: we do not show the command output, as it could contain the secret
+		return "", fmt.Errorf("could not obtain credentials with %q: %s: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	if stdout.Len() == 0 {
+		return "", fmt.Errorf("no credentials obtained with %q", name)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,108 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	keyFile := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(keyFile, []byte("key-from-file"), 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	_ = os.Setenv("TEST_CREDENTIALS_KEY", "key-from-env")
+	defer os.Unsetenv("TEST_CREDENTIALS_KEY")
+
+	testCases := []struct {
+		source   string
+		expected string
+	}{
+		{keyFile, "key-from-file"},
+		{"file://" + keyFile, "key-from-file"},
+		{"env://TEST_CREDENTIALS_KEY", "key-from-env"},
+		{"cmd://echo key-from-cmd", "key-from-cmd\n"},
+	}
+	for _, testCase := range testCases {
+		res, err := Resolve(ctx, testCase.source)
+		if err != nil {
+			t.Fatalf("Error: %q: %s", testCase.source, err)
+		}
+		if res != testCase.expected {
+			t.Fatalf("Error: %q: got %q, expected %q", testCase.source, res, testCase.expected)
+		}
+	}
+
+	for _, source := range []string{"", "env://TEST_CREDENTIALS_MISSING", "cmd://false", "unknown://something", "vault://no-field"} {
+		if _, err := Resolve(ctx, source); err == nil {
+			t.Fatalf("Error: %q: error not detected", source)
+		}
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "some-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/nodes":
+			_, _ = w.Write([]byte(`{"data": {"data": {"ssh_key": "key-from-vault-v2"}}}`))
+		case "/v1/kv/nodes":
+			_, _ = w.Write([]byte(`{"data": {"ssh_key": "key-from-vault-v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	defer os.Setenv(vaultAddrEnv, os.Getenv(vaultAddrEnv))
+	defer os.Setenv(vaultTokenEnv, os.Getenv(vaultTokenEnv))
+	_ = os.Setenv(vaultAddrEnv, server.URL)
+	_ = os.Setenv(vaultTokenEnv, "some-token")
+
+	for source, expected := range map[string]string{
+		"vault://secret/data/nodes#ssh_key": "key-from-vault-v2",
+		"vault://kv/nodes#ssh_key":          "key-from-vault-v1",
+	} {
+		res, err := Resolve(context.Background(), source)
+		if err != nil {
+			t.Fatalf("Error: %q: %s", source, err)
+		}
+		if res != expected {
+			t.Fatalf("Error: %q: got %q, expected %q", source, res, expected)
+		}
+	}
+
+	if _, err := Resolve(context.Background(), "vault://secret/data/missing#ssh_key"); err == nil {
+		t.Fatalf("Error: missing secret not detected")
+	}
+}
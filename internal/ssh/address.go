@@ -0,0 +1,140 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	// default SSH port
+	DefSSHPort = 22
+)
+
+// HostPort is a (user@)host(:port) address, where the host can be
+// a DNS name, an IPv4 or an IPv6 (with or without brackets)
+type HostPort struct {
+	User string
+	Host string
+	Port int
+}
+
+// String returns the address as "user@host:port", with brackets for IPv6 literals
+func (hp HostPort) String() string {
+	res := hp.Host
+	if hp.Port > 0 {
+		res = net.JoinHostPort(hp.Host, strconv.Itoa(hp.Port))
+	} else if strings.Contains(hp.Host, ":") {
+		res = "[" + hp.Host + "]"
+	}
+	if hp.User != "" {
+		res = hp.User + "@" + res
+	}
+	return res
+}
+
+// ParseHostPort parses a "(user@)host(:port)" address, using `defPort` when no port is
+// specified. IPv6 literals can be provided with brackets ("[fd00::1]:22") or, when
+// there is no port, without brackets ("fd00::1").
+func ParseHostPort(s string, defPort int) (HostPort, error) {
+	res := HostPort{Port: defPort}
+
+	s = strings.TrimSpace(s)
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		res.User = s[:i]
+		s = s[i+1:]
+	}
+	if s == "" {
+		return HostPort{}, fmt.Errorf("empty address")
+	}
+
+	switch {
+	case strings.HasPrefix(s, "["):
+		// "[fd00::1]" or "[fd00::1]:22"
+		end := strings.Index(s, "]")
+		if end < 0 {
+			return HostPort{}, fmt.Errorf("missing ']' in address %q", s)
+		}
+		res.Host = s[1:end]
+		rest := s[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return HostPort{}, fmt.Errorf("unexpected %q after ']' in address %q", rest, s)
+			}
+			p, err := parsePort(rest[1:])
+			if err != nil {
+				return HostPort{}, err
+			}
+			res.Port = p
+		}
+	case strings.Count(s, ":") > 1:
+		// IPv6 literal without brackets (and without port)
+		if net.ParseIP(s) == nil {
+			return HostPort{}, fmt.Errorf("invalid IPv6 address %q (use brackets when specifying a port)", s)
+		}
+		res.Host = s
+	case strings.Count(s, ":") == 1:
+		h, p, err := net.SplitHostPort(s)
+		if err != nil {
+			return HostPort{}, err
+		}
+		port, err := parsePort(p)
+		if err != nil {
+			return HostPort{}, err
+		}
+		res.Host, res.Port = h, port
+	default:
+		res.Host = s
+	}
+
+	if res.Host == "" {
+		return HostPort{}, fmt.Errorf("empty host in address %q", s)
+	}
+	return res, nil
+}
+
+func parsePort(s string) (int, error) {
+	p, err := strconv.Atoi(s)
+	if err != nil || p <= 0 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return p, nil
+}
+
+// ParseHostChain parses a ProxyJump-style chain of addresses, like
+// "user@bastion:2222,[fd00::1]", where the last element is the target host
+func ParseHostChain(s string, defPort int) ([]HostPort, error) {
+	res := []HostPort{}
+	for _, hop := range strings.Split(s, ",") {
+		hp, err := ParseHostPort(hop, defPort)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, hp)
+	}
+	return res, nil
+}
+
+// JoinHostChain returns the ProxyJump-style representation of a chain of addresses
+func JoinHostChain(chain []HostPort) string {
+	res := []string{}
+	for _, hp := range chain {
+		res = append(res, hp.String())
+	}
+	return strings.Join(res, ",")
+}
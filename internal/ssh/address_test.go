@@ -0,0 +1,72 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestParseHostPort(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected HostPort
+		str      string
+		fail     bool
+	}{
+		{"10.0.0.1", HostPort{Host: "10.0.0.1", Port: 22}, "10.0.0.1:22", false},
+		{"master:2222", HostPort{Host: "master", Port: 2222}, "master:2222", false},
+		{"root@master", HostPort{User: "root", Host: "master", Port: 22}, "root@master:22", false},
+		{"fd00::1", HostPort{Host: "fd00::1", Port: 22}, "[fd00::1]:22", false},
+		{"[fd00::1]", HostPort{Host: "fd00::1", Port: 22}, "[fd00::1]:22", false},
+		{"[fd00::1]:2222", HostPort{Host: "fd00::1", Port: 2222}, "[fd00::1]:2222", false},
+		{"core@[fd00::1]:2222", HostPort{User: "core", Host: "fd00::1", Port: 2222}, "core@[fd00::1]:2222", false},
+		{"[fd00::1", HostPort{}, "", true},
+		{"fd00::1:zz:2222", HostPort{}, "", true},
+		{"master:99999", HostPort{}, "", true},
+		{"", HostPort{}, "", true},
+	}
+
+	for _, c := range cases {
+		hp, err := ParseHostPort(c.in, DefSSHPort)
+		if c.fail {
+			if err == nil {
+				t.Fatalf("Error: %q: error not detected", c.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error: %q: %s", c.in, err)
+		}
+		if hp != c.expected {
+			t.Fatalf("Error: %q: got %+v, expected %+v", c.in, hp, c.expected)
+		}
+		if hp.String() != c.str {
+			t.Fatalf("Error: %q: got %q, expected %q", c.in, hp.String(), c.str)
+		}
+	}
+}
+
+func TestParseHostChain(t *testing.T) {
+	chain, err := ParseHostChain("jump@bastion:2222, [fd00::1]", DefSSHPort)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(chain) != 2 || chain[0].Host != "bastion" || chain[1].Host != "fd00::1" {
+		t.Fatalf("Error: unexpected chain: %+v", chain)
+	}
+	if s := JoinHostChain(chain); s != "jump@bastion:2222,[fd00::1]:22" {
+		t.Fatalf("Error: unexpected chain representation: %q", s)
+	}
+}
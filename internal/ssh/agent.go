@@ -0,0 +1,235 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSHAuthSockEnv is the environment variable with the socket of the local SSH agent
+const SSHAuthSockEnv = "SSH_AUTH_SOCK"
+
+var (
+	localAgentMutex sync.Mutex
+	localAgent      agent.ExtendedAgent
+)
+
+// HasLocalAgent returns true if there is a local SSH agent
+func HasLocalAgent() bool {
+	return os.Getenv(SSHAuthSockEnv) != ""
+}
+
+// getLocalAgent returns a client for the local SSH agent (shared by all the connections)
+func getLocalAgent() (agent.ExtendedAgent, error) {
+	localAgentMutex.Lock()
+	defer localAgentMutex.Unlock()
+
+	if localAgent != nil {
+		// check the connection to the agent is still alive
+		if _, err := localAgent.List(); err == nil {
+			return localAgent, nil
+		}
+		localAgent = nil
+	}
+
+	socket := os.Getenv(SSHAuthSockEnv)
+	if socket == "" {
+		return nil, fmt.Errorf("no SSH agent found: %s is not set", SSHAuthSockEnv)
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the SSH agent at %s: %s", socket, err)
+	}
+	localAgent = agent.NewClient(conn)
+	return localAgent, nil
+}
+
+// getAgentAuthMethod returns an authentication method with the keys in the local SSH agent
+func getAgentAuthMethod() (gossh.AuthMethod, error) {
+	a, err := getLocalAgent()
+	if err != nil {
+		return nil, err
+	}
+	return gossh.PublicKeysCallback(a.Signers), nil
+}
+
+// agentForwardingCommunicator is a communicator that runs the commands in sessions
+// where the local SSH agent is forwarded, so they can use the keys in the agent
+// (ie, for cloning private repositories). The uploads are still done by the
+// wrapped communicator.
+type agentForwardingCommunicator struct {
+	communicator.Communicator
+
+	sync.Mutex
+	host    SSHHost
+	timeout time.Duration
+	client  *gossh.Client
+}
+
+// NewAgentForwardingCommunicator wraps a communicator, running all the commands in
+// sessions (opened with its own connection to `host`) with the local SSH agent forwarded
+func NewAgentForwardingCommunicator(comm communicator.Communicator, host SSHHost, timeout time.Duration) (communicator.Communicator, error) {
+	if !HasLocalAgent() {
+		return nil, fmt.Errorf("the SSH agent cannot be forwarded: %s is not set", SSHAuthSockEnv)
+	}
+	return &agentForwardingCommunicator{
+		Communicator: comm,
+		host:         host,
+		timeout:      timeout,
+	}, nil
+}
+
+// getClient returns the SSH client, connecting to the host (and setting up the
+// forwarding of the agent) if it is not connected yet
+func (c *agentForwardingCommunicator) getClient() (*gossh.Client, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	config, err := c.host.getClientConfig(c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	address := HostPort{Host: c.host.Address.Host, Port: c.host.Address.Port}.String()
+	client, err := gossh.Dial("tcp", address, config)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to %s for forwarding the SSH agent: %s", c.host.Address, err)
+	}
+
+	a, err := getLocalAgent()
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+	if err := agent.ForwardToAgent(client, a); err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("could not forward the SSH agent to %s: %s", c.host.Address, err)
+	}
+	c.client = client
+	return client, nil
+}
+
+// Connect connects the wrapped communicator and the client with the agent forwarded
+func (c *agentForwardingCommunicator) Connect(o terraform.UIOutput) error {
+	if err := c.Communicator.Connect(o); err != nil {
+		return err
+	}
+	_, err := c.getClient()
+	return err
+}
+
+// closeClient closes the client (it will be reconnected in the next command)
+func (c *agentForwardingCommunicator) closeClient() {
+	c.Lock()
+	defer c.Unlock()
+	if c.client != nil {
+		_ = c.client.Close()
+		c.client = nil
+	}
+}
+
+// Disconnect closes the client and the wrapped communicator
+func (c *agentForwardingCommunicator) Disconnect() error {
+	c.closeClient()
+	return c.Communicator.Disconnect()
+}
+
+// SendKeepAlive sends a keepalive request in the connection with the agent forwarded
+// (the wrapped communicator sends its own keepalives)
+func (c *agentForwardingCommunicator) SendKeepAlive() error {
+	c.Lock()
+	client := c.client
+	c.Unlock()
+
+	if client == nil {
+		return nil
+	}
+	_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+	return err
+}
+
+// Start runs a command in a new session, with the agent forwarded
+func (c *agentForwardingCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+
+	client, err := c.getClient()
+	if err != nil {
+		return err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		// the connection could have been dropped: try again with a new one
+		c.closeClient()
+		if client, err = c.getClient(); err != nil {
+			return err
+		}
+		if session, err = client.NewSession(); err != nil {
+			return err
+		}
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		_ = session.Close()
+		return fmt.Errorf("could not request the forwarding of the SSH agent: %s", err)
+	}
+
+	session.Stdin = cmd.Stdin
+	session.Stdout = cmd.Stdout
+	session.Stderr = cmd.Stderr
+
+	// (request a PTY, like the communicator does)
+	termModes := gossh.TerminalModes{
+		gossh.ECHO:          0,
+		gossh.TTY_OP_ISPEED: 14400,
+		gossh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", 80, 40, termModes); err != nil {
+		_ = session.Close()
+		return err
+	}
+
+	Debug("starting remote command (with the SSH agent forwarded): %s", cmd.Command)
+	if err := session.Start(strings.TrimSpace(cmd.Command) + "\n"); err != nil {
+		_ = session.Close()
+		return err
+	}
+
+	go func() {
+		defer session.Close()
+
+		err := session.Wait()
+		exitStatus := 0
+		if err != nil {
+			if exitErr, ok := err.(*gossh.ExitError); ok {
+				exitStatus = exitErr.ExitStatus()
+			}
+		}
+		cmd.SetExitStatus(exitStatus, err)
+	}()
+	return nil
+}
@@ -0,0 +1,194 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestAgent starts a SSH agent with a new key, setting the SSH_AUTH_SOCK
+func startTestAgent(t *testing.T) (gossh.Signer, func()) {
+	dir, err := ioutil.TempDir("", "ssh-agent")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	key, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _ = agent.ServeAgent(keyring, conn) }()
+		}
+	}()
+
+	prev := os.Getenv(SSHAuthSockEnv)
+	_ = os.Setenv(SSHAuthSockEnv, socket)
+	return key, func() {
+		_ = os.Setenv(SSHAuthSockEnv, prev)
+		_ = l.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// startTestAgentServer starts a SSH server that only accepts `authorized`, and that prints the
+// number of keys in the forwarded agent in every command
+func startTestAgentServer(t *testing.T, hostKey gossh.Signer, authorized gossh.PublicKey) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := &gossh.ServerConfig{
+		PublicKeyCallback: func(_ gossh.ConnMetadata, key gossh.PublicKey) (*gossh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, fmt.Errorf("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	handle := func(sconn *gossh.ServerConn, newChan gossh.NewChannel) {
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			return
+		}
+		defer ch.Close()
+
+		forwarded := false
+		for req := range reqs {
+			switch req.Type {
+			case "auth-agent-req@openssh.com":
+				forwarded = true
+				_ = req.Reply(true, nil)
+			case "pty-req":
+				_ = req.Reply(true, nil)
+			case "exec":
+				_ = req.Reply(true, nil)
+				count := -1
+				if forwarded {
+					if agentChan, agentReqs, err := sconn.OpenChannel("auth-agent@openssh.com", nil); err == nil {
+						go gossh.DiscardRequests(agentReqs)
+						keys, _ := agent.NewClient(agentChan).List()
+						count = len(keys)
+						_ = agentChan.Close()
+					}
+				}
+				_, _ = fmt.Fprintf(ch, "keys=%d\n", count)
+				_, _ = ch.SendRequest("exit-status", false, gossh.Marshal(struct{ Status uint32 }{0}))
+				return
+			default:
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				sconn, chans, reqs, err := gossh.NewServerConn(conn, config)
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+				go gossh.DiscardRequests(reqs)
+				for newChan := range chans {
+					go handle(sconn, newChan)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { _ = l.Close() }
+}
+
+func TestAgentForwardingCommunicator(t *testing.T) {
+	clientKey, stopAgent := startTestAgent(t)
+	defer stopAgent()
+
+	hostKey := newTestHostKey(t)
+	address, stop := startTestAgentServer(t, hostKey, clientKey.PublicKey())
+	defer stop()
+
+	hp, err := ParseHostPort("someone@"+address, DefSSHPort)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	host := SSHHost{
+		Address: hp,
+		Agent:   true,
+		HostKey: string(gossh.MarshalAuthorizedKey(hostKey.PublicKey())),
+	}
+	comm, err := NewAgentForwardingCommunicator(DummyCommunicator{}, host, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer comm.Disconnect()
+
+	var stdout bytes.Buffer
+	cmd := &remote.Cmd{Command: "ssh-add -l", Stdout: &stdout}
+	if err := comm.Start(cmd); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if out := stdout.String(); out != "keys=1\n" {
+		t.Fatalf("Error: the agent was not forwarded: %q", out)
+	}
+
+	// a wrong host key is detected
+	host.HostKey = string(gossh.MarshalAuthorizedKey(newTestHostKey(t).PublicKey()))
+	comm, _ = NewAgentForwardingCommunicator(DummyCommunicator{}, host, 5*time.Second)
+	if err := comm.Start(&remote.Cmd{Command: "true"}); err == nil {
+		t.Fatalf("Error: no error with a wrong host key")
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// extractTarGz extracts a (gzipped) tar archive in a local directory.
+// Only directories and regular files are extracted: symlinks and
+// special files are ignored, as well as any entry outside `dst`.
+func extractTarGz(r io.Reader, dst string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, filepath.FromSlash(hdr.Name))
+		if target != filepath.Clean(dst) && !strings.HasPrefix(target, filepath.Clean(dst)+string(os.PathSeparator)) {
+			return fmt.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		default:
+			Debug("ignoring %q in archive: not a regular file or directory", hdr.Name)
+		}
+	}
+}
+
+// untarWriteCloser is a WriteCloser that extracts a (gzipped) tar archive
+// in a local directory while it is written. Closing it returns any error
+// found while extracting the archive.
+type untarWriteCloser struct {
+	w    *io.PipeWriter
+	done chan error
+}
+
+func newUntarWriteCloser(dst string) *untarWriteCloser {
+	r, w := io.Pipe()
+	u := &untarWriteCloser{w: w, done: make(chan error, 1)}
+	go func() {
+		err := extractTarGz(r, dst)
+		// consume anything left, so writers never block
+		_, _ = io.Copy(ioutil.Discard, r)
+		u.done <- err
+	}()
+	return u
+}
+
+func (u *untarWriteCloser) Write(p []byte) (int, error) {
+	return u.w.Write(p)
+}
+
+func (u *untarWriteCloser) Close() error {
+	_ = u.w.Close()
+	return <-u.done
+}
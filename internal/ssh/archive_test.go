@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testTarGz returns a gzipped tar archive with some entries
+func testTarGz(t *testing.T, entries []tar.Header, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range entries {
+		hdr := hdr
+		c := contents[hdr.Name]
+		hdr.Size = int64(len(c))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if _, err := tw.Write([]byte(c)); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+	}
+	_ = tw.Close()
+	_ = gz.Close()
+	return buf.Bytes()
+}
+
+func TestUntarWriteCloser(t *testing.T) {
+	dst, err := ioutil.TempDir("", "untar")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dst)
+
+	archive := testTarGz(t, []tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./pki/", Typeflag: tar.TypeDir, Mode: 0700},
+		{Name: "./pki/ca.key", Typeflag: tar.TypeReg, Mode: 0600},
+		{Name: "./admin.conf", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "./link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"},
+	}, map[string]string{
+		"./pki/ca.key": "KEY\x00\r\n",
+		"./admin.conf": "CONFIG",
+	})
+
+	w := newUntarWriteCloser(dst)
+	if _, err := w.Write(archive); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if b, err := ioutil.ReadFile(filepath.Join(dst, "pki", "ca.key")); err != nil || string(b) != "KEY\x00\r\n" {
+		t.Fatalf("Error: unexpected contents %q: %v", b, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "pki", "ca.key")); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Error: unexpected mode: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dst, "link")); !os.IsNotExist(err) {
+		t.Fatalf("Error: symlink extracted")
+	}
+
+	// entries outside the destination directory are rejected
+	w = newUntarWriteCloser(dst)
+	_, _ = w.Write(testTarGz(t, []tar.Header{{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}}, map[string]string{"../evil": "x"}))
+	if err := w.Close(); err == nil {
+		t.Fatalf("Error: no error for an entry outside the destination")
+	}
+
+	// truncated archives are detected
+	w = newUntarWriteCloser(dst)
+	_, _ = w.Write(archive[:len(archive)/2])
+	if err := w.Close(); err == nil {
+		t.Fatalf("Error: no error for a truncated archive")
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+)
+
+// AuditLog is a log file in the host where all the commands executed are
+// appended, with a timestamp and the ID of the run
+type AuditLog struct {
+	Path  string
+	RunID string
+}
+
+// WithAuditLog returns a new context where all the commands executed are
+// recorded in an audit log in the host
+func WithAuditLog(ctx context.Context, log AuditLog) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.auditLog = &log
+	})
+}
+
+// getAuditedCommand returns the command prefixed with the append of an entry
+// to the audit log (if any). Errors writing the audit log are ignored.
+func getAuditedCommand(ctx context.Context, command string) string {
+	log := getSSHContext(ctx).auditLog
+	if log == nil || log.Path == "" {
+		return command
+	}
+
+	entry := "run=" + log.RunID + " " + command
+
+	// the password for `sudo` is sent in the stdin, so the entry cannot be piped
+	if hasSudoPassword(ctx) {
+		script := `printf '%s %s\n' "$(date -u +%Y-%m-%dT%H:%M:%SZ)" "$1" >> "$2"`
+		return getEscalatedCommand(ctx, NewShellCommand(script, entry, log.Path).String()) +
+			" >/dev/null 2>&1 ; " + command
+	}
+
+	tee := getEscalatedCommand(ctx, "tee -a "+shellQuote(log.Path))
+	return `printf '%s %s\n' "$(date -u +%Y-%m-%dT%H:%M:%SZ)" ` + shellQuote(entry) +
+		" | " + tee + " >/dev/null 2>&1 ; " + command
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "audit.log")
+
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+	ctx = WithAuditLog(ctx, AuditLog{Path: logFile, RunID: "run1"})
+
+	if res := DoExec(`echo "it's done"`).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if len(commands) != 1 {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+
+	// run the audited command in a real shell
+	output, err := exec.Command("sh", "-c", commands[0]).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error: %v: %s", err, output)
+	}
+	if strings.TrimSpace(string(output)) != "it's done" {
+		t.Fatalf("Error: unexpected output: %q", output)
+	}
+
+	contents, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z run=run1 echo "it's done"\n$`)
+	if !expected.Match(contents) {
+		t.Fatalf("Error: unexpected audit log: %q", contents)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// FactLoginNoise is the fact set when the remote shell prints something
+	// (ie, a banner or a motd) in every session
+	FactLoginNoise = "login-noise"
+
+	// bannerMarker is printed before running the commands when banners are
+	// filtered out: everything printed before this line is ignored
+	bannerMarker = "-----KUBEADM-PROVISIONER-OUTPUT-----"
+)
+
+// WithBannerFilter returns a new context where the output printed by the remote
+// shell before running the commands (ie, banners or motds) is filtered out
+func WithBannerFilter(ctx context.Context) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.filterBanner = true
+	})
+}
+
+// isBannerFilterEnabled returns true if banners must be filtered out, because it
+// has been requested or because some login noise has been detected
+func isBannerFilterEnabled(ctx context.Context) bool {
+	if getSSHContext(ctx).filterBanner {
+		return true
+	}
+	noise, _ := GetFactFromContext(ctx, FactLoginNoise)
+	return noise == "true"
+}
+
+// getCommandWithBannerMarker returns the command prefixed with the printing of the
+// banner marker (both in the stdout and in the stderr)
+func getCommandWithBannerMarker(command string) string {
+	return fmt.Sprintf("echo '%s' ; echo '%s' >&2 ; %s", bannerMarker, bannerMarker, command)
+}
+
+// bannerFilter is an output that ignores all the lines until the banner marker is found
+type bannerFilter struct {
+	output UIOutput
+	found  bool
+}
+
+func newBannerFilter(output UIOutput) *bannerFilter {
+	return &bannerFilter{output: output}
+}
+
+func (f *bannerFilter) Output(s string) {
+	if f.found {
+		f.output.Output(s)
+		return
+	}
+	if strings.TrimSpace(s) == bannerMarker {
+		f.found = true
+		return
+	}
+	Debug("ignoring output before the banner marker: %q", s)
+}
+
+// DoDetectLoginNoise runs a command that does not print anything, filtering out
+// banners in all the commands run after that when some output is received
+func DoDetectLoginNoise() Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if isBannerFilterEnabled(ctx) {
+			return nil
+		}
+
+		var buf bytes.Buffer
+		if res := (ActionList{DoSendingExecOutputToWriter(DoExec("true"), &buf)}).Apply(ctx); IsError(res) {
+			return res
+		}
+		if strings.TrimSpace(buf.String()) == "" {
+			return nil
+		}
+
+		Debug("output received from a silent command: %q", buf.String())
+		return ActionList{
+			DoMessageInfo("The remote shell prints a banner in every session: filtering it out"),
+			DoSetFact(FactLoginNoise, "true"),
+		}
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+// testBannerCommunicator is a communicator that prints a banner in every session
+type testBannerCommunicator struct {
+	DummyCommunicator
+}
+
+func (testBannerCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	_, _ = cmd.Stdout.Write([]byte("Welcome to Ubuntu 18.04\nAuthorized uses only!\n"))
+	_, _ = cmd.Stderr.Write([]byte("Last login: Mon Jul 1 10:00:00 2019\n"))
+	if strings.Contains(cmd.Command, bannerMarker) {
+		_, _ = cmd.Stdout.Write([]byte(bannerMarker + "\n"))
+		_, _ = cmd.Stderr.Write([]byte(bannerMarker + "\n"))
+	}
+	if strings.Contains(cmd.Command, "hostname") {
+		_, _ = cmd.Stdout.Write([]byte("node-1\n"))
+	}
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func TestBannerFilter(t *testing.T) {
+	ctx := NewTestingContextWithCommunicator(testBannerCommunicator{})
+
+	if res := (ActionList{DoDetectLoginNoise()}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: could not detect the login noise: %s", res)
+	}
+	if !isBannerFilterEnabled(ctx) {
+		t.Fatalf("Error: login noise not detected")
+	}
+
+	var buf bytes.Buffer
+	if res := (ActionList{DoSendingExecOutputToWriter(DoExec("hostname"), &buf)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: could not run command: %s", res)
+	}
+	if out := strings.TrimSpace(buf.String()); out != "node-1" {
+		t.Fatalf("Error: banner not filtered out: %q", out)
+	}
+
+	// nothing is detected (nor filtered) when the shell is silent
+	ctx = NewTestingContextWithResponses([]string{""})
+	if res := (ActionList{DoDetectLoginNoise()}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: could not detect the login noise: %s", res)
+	}
+	if isBannerFilterEnabled(ctx) {
+		t.Fatalf("Error: login noise detected in a silent shell")
+	}
+}
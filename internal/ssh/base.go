@@ -20,6 +20,7 @@ import (
 	"io"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gookit/color"
@@ -92,6 +93,11 @@ func applyList(ctx context.Context, actions ActionList, ignoreErrors bool) Actio
 	// use a queue where we take and put things in the front
 	// note that we operate on a copy of the original actions list
 	for len(actions) > 0 {
+		// stop issuing new actions when the user interrupts the provisioning
+		if ctx.Err() != nil {
+			return getInterruptedError(ctx)
+		}
+
 		if !ignoreErrors {
 			// if some error is in the queue, just quit with that error
 			for _, action := range actions {
@@ -189,9 +195,14 @@ func DoMessageWarn(format string, args ...interface{}) Action {
 	return DoMessageWithColor(msg, color.FgRed)
 }
 
-// DoMessageInfo prints an info message
+// DoMessageInfo prints an info message (that is also used as the
+// current phase, reported if the provisioning is interrupted)
 func DoMessageInfo(format string, args ...interface{}) Action {
-	return DoMessageWithColor(fmt.Sprintf(format, args...), color.FgGreen)
+	msg := fmt.Sprintf(format, args...)
+	return ActionFunc(func(ctx context.Context) Action {
+		setCurrentPhase(ctx, msg)
+		return DoMessageWithColor(msg, color.FgGreen).Apply(ctx)
+	})
 }
 
 // DoMessageDebug prints a debug message
@@ -228,12 +239,12 @@ func (f CheckerFunc) Check(ctx context.Context) (bool, error) {
 }
 
 // DoWithCleanup runs some action(s) and
-// 1) despite the result, runs the cleanup function
+// 1) despite the result, runs the cleanup function (even when interrupted)
 // 2) returns the actions result
 func DoWithCleanup(actions Action, cleanup Action) Action {
 	return ActionFunc(func(ctx context.Context) Action {
 		res := ActionList{actions}.Apply(ctx)
-		_ = ActionList{cleanup}.Apply(ctx)
+		_ = ActionList{cleanup}.Apply(withoutCancel(ctx))
 		return res
 	})
 }
@@ -318,40 +329,135 @@ type Retry struct {
 
 	// Interval is the time between trials
 	Interval time.Duration
+
+	// Backoff multiplies the interval after each failure (when > 1)
+	Backoff float64
+
+	// MaxInterval is the maximum interval between trials (when using a Backoff)
+	MaxInterval time.Duration
 }
 
-// DoRetry runs an action `n` times until it succeedes
-func DoRetry(run Retry, actions ...Action) ActionFunc {
+// getInterval returns the interval after the n-th failure (starting at 0)
+func (r Retry) getInterval(n int) time.Duration {
 	interval := 1 * time.Second
-	if run.Interval > 0 {
-		interval = run.Interval
+	if r.Interval > 0 {
+		interval = r.Interval
+	}
+	for i := 0; i < n && r.Backoff > 1; i++ {
+		interval = time.Duration(float64(interval) * r.Backoff)
+		if r.MaxInterval > 0 && interval >= r.MaxInterval {
+			return r.MaxInterval
+		}
 	}
+	return interval
+}
 
+// Duration returns the maximum time we can wait between all the trials
+func (r Retry) Duration() time.Duration {
+	total := time.Duration(0)
+	for n := 0; n < r.Times-1; n++ {
+		total += r.getInterval(n)
+	}
+	return total
+}
+
+// DoRetry runs an action `n` times until it succeedes, waiting some
+// interval between trials (longer and longer when using a Backoff).
+// It stops retrying when the context is cancelled.
+func DoRetry(run Retry, actions ...Action) ActionFunc {
 	return ActionFunc(func(ctx context.Context) Action {
-		count := run.Times
 		var res Action
-		for count > 0 {
+		for n := 0; n < run.Times; n++ {
 			res = ActionList(actions).Apply(ctx)
-			if IsError(res) {
-				_ = DoMessageWarn("failed... retrying in %d seconds...", interval/time.Second).Apply(ctx)
-				time.Sleep(interval)
-				count--
-			} else {
+			if !IsError(res) || n == run.Times-1 || IsDryRun(ctx) {
 				return res
 			}
+
+			interval := run.getInterval(n)
+			Debug("attempt %d/%d failed: %s", n+1, run.Times, res)
+			_ = DoMessageWarn("failed (attempt %d/%d)... retrying in %s...", n+1, run.Times, interval).Apply(ctx)
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return getInterruptedError(ctx)
+			}
 		}
 		return res
 	})
 }
 
+// DoWithTimeout runs some action, failing if it does not finish before some `timeout`.
+// Any remote command running when the timeout expires is cancelled.
+func DoWithTimeout(action Action, timeout time.Duration) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		tctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		res := ActionList{action}.Apply(tctx)
+		if IsError(res) && tctx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			if current := GetCurrentPhaseFromContext(ctx); current != "" {
+				return ActionError(fmt.Sprintf("timeout: %q did not finish after %s", current, timeout))
+			}
+			return ActionError(fmt.Sprintf("timeout: not finished after %s", timeout))
+		}
+		return res
+	})
+}
+
+// DoParallel runs some actions concurrently, with at most `limit` actions running at
+// the same time (or all of them when `limit` is 0). All the actions are run, even when
+// some of them fail, and the errors are reported together.
+func DoParallel(limit int, actions ...Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if limit <= 0 || limit > len(actions) {
+			limit = len(actions)
+		}
+
+		errs := make([]string, len(actions))
+		sem := make(chan struct{}, limit)
+		var wg sync.WaitGroup
+		for i, action := range actions {
+			if action == nil {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(i int, action Action) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				if res := (ActionList{action}).Apply(ctx); IsError(res) {
+					errs[i] = res.Error()
+				}
+			}(i, action)
+		}
+		wg.Wait()
+
+		failed := []string{}
+		for _, err := range errs {
+			if err != "" {
+				failed = append(failed, err)
+			}
+		}
+		switch len(failed) {
+		case 0:
+			return nil
+		case 1:
+			return ActionError(failed[0])
+		default:
+			return ActionError(fmt.Sprintf("%d actions failed: %s", len(failed), strings.Join(failed, "; ")))
+		}
+	})
+}
+
 // DoSendingExecOutputToFunc runs some action redirecting all the Do***Exec outputs
 // to some function
 // Some notes:
 // * make sure you strip spaces in the output, as some extra spaces can be before/after
 func DoSendingExecOutputToFunc(action Action, interceptor OutputFunc) Action {
 	return ActionFunc(func(ctx context.Context) Action {
-		newCtx := WithValues(ctx, GetUserOutputFromContext(ctx), interceptor, GetCommFromContext(ctx), GetUseSudoFromContext(ctx))
-		return ActionList{action}.Apply(newCtx)
+		return ActionList{action}.Apply(WithExecOutput(ctx, interceptor))
 	})
 }
 
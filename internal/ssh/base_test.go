@@ -17,8 +17,12 @@ package ssh
 import (
 	"bytes"
 	"context"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
 )
 
 ////////////////////////////////////////////////////////////////////////////////////////////////
@@ -430,6 +434,164 @@ func TestDoRetry(t *testing.T) {
 	}
 }
 
+func TestRetryBackoff(t *testing.T) {
+	r := Retry{Times: 5, Interval: time.Second, Backoff: 2, MaxInterval: 5 * time.Second}
+	for n, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if interval := r.getInterval(n); interval != expected {
+			t.Fatalf("Error: unexpected interval after %d failures: %s, expected: %s", n+1, interval, expected)
+		}
+	}
+	if d := r.Duration(); d != 12*time.Second {
+		t.Fatalf("Error: unexpected total duration: %s", d)
+	}
+
+	// the retries stop when the context is cancelled
+	count := 0
+	ctx, cancel := context.WithCancel(NewTestingContext())
+	res := DoRetry(Retry{Times: 5, Interval: time.Hour},
+		ActionFunc(func(context.Context) Action {
+			count++
+			cancel()
+			return ActionError("an error")
+		})).Apply(ctx)
+	if !IsError(res) || count != 1 {
+		t.Fatalf("Error: retries not stopped when cancelled: %d trials", count)
+	}
+}
+
+func TestDoParallel(t *testing.T) {
+	var running, maxRunning, count int32
+	action := ActionFunc(func(context.Context) Action {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		atomic.AddInt32(&count, 1)
+		return nil
+	})
+
+	ctx := NewTestingContext()
+	res := ActionList{DoParallel(2, action, action, action, action, action)}.Apply(ctx)
+	if IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	if count != 5 {
+		t.Fatalf("Error: unexpected number of actions run: %d, expected: %d", count, 5)
+	}
+	if maxRunning != 2 {
+		t.Fatalf("Error: unexpected number of concurrent actions: %d, expected: %d", maxRunning, 2)
+	}
+
+	// all the actions are run, and the errors are aggregated
+	count = 0
+	res = ActionList{DoParallel(0, ActionError("first error"), action, ActionError("second error"))}.Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: no error detected")
+	}
+	if !strings.Contains(res.Error(), "first error") || !strings.Contains(res.Error(), "second error") {
+		t.Fatalf("Error: unexpected error: %s", res)
+	}
+	if count != 1 {
+		t.Fatalf("Error: unexpected number of actions run: %d, expected: %d", count, 1)
+	}
+}
+
 func doEcho(msg string) Action {
 	return DoLocalExec("/bin/echo", msg)
 }
+
+// testHangingCommunicator is a communicator where commands never finish,
+// until the connection is closed
+type testHangingCommunicator struct {
+	DummyCommunicator
+
+	cmds        chan *remote.Cmd
+	disconnects *int32
+}
+
+func (hc testHangingCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	hc.cmds <- cmd
+	return nil
+}
+
+func (hc testHangingCommunicator) Disconnect() error {
+	atomic.AddInt32(hc.disconnects, 1)
+	(<-hc.cmds).SetExitStatus(255, nil)
+	return nil
+}
+
+func TestDoWithTimeout(t *testing.T) {
+	defer func(d time.Duration) { interruptGracePeriod = d }(interruptGracePeriod)
+	interruptGracePeriod = 50 * time.Millisecond
+
+	var disconnects int32
+	comm := testHangingCommunicator{cmds: make(chan *remote.Cmd, 1), disconnects: &disconnects}
+	ctx := NewTestingContextWithCommunicator(comm)
+
+	res := DoWithTimeout(DoExec("kubeadm init"), 50*time.Millisecond).Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: no error after the timeout")
+	}
+	if !strings.Contains(res.(ActionError).Error(), "timeout") {
+		t.Fatalf("Error: unexpected error: %s", res)
+	}
+	if atomic.LoadInt32(&disconnects) != 1 {
+		t.Fatalf("Error: the hanging command was not cancelled: %d disconnects", disconnects)
+	}
+
+	// actions finishing before the timeout are not affected
+	counter := 0
+	res = DoWithTimeout(ActionList{
+		ActionFunc(func(context.Context) Action {
+			counter++
+			return nil
+		}),
+	}, time.Minute).Apply(ctx)
+	if IsError(res) || counter != 1 {
+		t.Fatalf("Error: unexpected result: %v (counter=%d)", res, counter)
+	}
+}
+
+// testInterruptibleCommunicator is a communicator where commands never finish,
+// until they read an interrupt in their terminal
+type testInterruptibleCommunicator struct {
+	testHangingCommunicator
+}
+
+func (ic testInterruptibleCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := cmd.Stdin.Read(buf); err != nil {
+				return
+			}
+			if buf[0] == terminalInterruptChar {
+				cmd.SetExitStatus(130, nil)
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func TestDoWithTimeoutInterrupt(t *testing.T) {
+	var disconnects int32
+	comm := testInterruptibleCommunicator{testHangingCommunicator{cmds: make(chan *remote.Cmd, 1), disconnects: &disconnects}}
+	ctx := NewTestingContextWithCommunicator(comm)
+
+	res := DoWithTimeout(DoExec("kubeadm init"), 50*time.Millisecond).Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: no error after the timeout")
+	}
+	// the command is interrupted without dropping the connection
+	if atomic.LoadInt32(&disconnects) != 0 {
+		t.Fatalf("Error: the connection was dropped for an interrupted command: %d disconnects", disconnects)
+	}
+}
@@ -0,0 +1,50 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+)
+
+const (
+	// FactUserland is the fact with the userland detected in the host
+	FactUserland = "userland"
+
+	// userlands
+	UserlandGNU     = "gnu"
+	UserlandBusyBox = "busybox"
+
+	// BusyBox applets print a "BusyBox vX.Y.Z" banner in their help
+	busyboxCheck = "mv --help 2>&1 | grep -q BusyBox"
+)
+
+// DoDetectUserland detects if the host has a BusyBox userland (ie, Alpine),
+// so some commands are adapted later on. Hosts are assumed to have GNU
+// coreutils when this detection is not done.
+func DoDetectUserland() Action {
+	return DoIfElse(
+		CheckExec(busyboxCheck),
+		ActionList{
+			DoMessageInfo("BusyBox userland detected: using compatible commands"),
+			DoSetFact(FactUserland, UserlandBusyBox),
+		},
+		DoSetFact(FactUserland, UserlandGNU))
+}
+
+// IsBusyBox returns true if a BusyBox userland has been detected in the host
+func IsBusyBox(ctx context.Context) bool {
+	userland, _ := GetFactFromContext(ctx, FactUserland)
+	return userland == UserlandBusyBox
+}
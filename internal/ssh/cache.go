@@ -18,11 +18,17 @@ import (
 	"context"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	// environmental variable that can be used for disabling the cache
 	cacheEnvVar = "TF_CACHE"
+
+	// default TTL for the values in the cache
+	defCacheTTL = 10 * time.Minute
 )
 
 const (
@@ -31,8 +37,113 @@ const (
 
 	// CacheRemoteDirExistsPrefix is the prefix for dir checks
 	CacheRemoteDirExistsPrefix = "remote-dir-exists"
+
+	// CacheRemoteFileChecksumPrefix is the prefix for the checksums of remote files
+	CacheRemoteFileChecksumPrefix = "remote-file-checksum"
+
+	// CacheRemoteUserExistsPrefix is the prefix for users (and groups) checks
+	CacheRemoteUserExistsPrefix = "remote-user-exists"
 )
 
+// cacheEntry is a value in the cache
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// cache is a concurrency-safe cache, with TTLs for values
+type cache struct {
+	sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func newCache() *cache {
+	return &cache{entries: map[string]cacheEntry{}}
+}
+
+// get gets a value (if it has not expired)
+func (c *cache) get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// set sets a value, with a TTL (or no expiration when ttl is 0)
+func (c *cache) set(key string, value interface{}, ttl time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+
+	e := cacheEntry{value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.entries[key] = e
+}
+
+// del removes a key
+func (c *cache) del(key string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.entries, key)
+}
+
+// delPrefix removes all the keys starting with some prefix
+func (c *cache) delPrefix(prefix string) {
+	c.Lock()
+	defer c.Unlock()
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// flush removes everything in the cache
+func (c *cache) flush() {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = map[string]cacheEntry{}
+}
+
+var (
+	hostCachesMutex sync.Mutex
+	hostCaches      = map[string]*cache{}
+)
+
+// getHostCache returns the (shared) cache for a host
+func getHostCache(host string) *cache {
+	hostCachesMutex.Lock()
+	defer hostCachesMutex.Unlock()
+
+	c, ok := hostCaches[host]
+	if !ok {
+		c = newCache()
+		hostCaches[host] = c
+	}
+	return c
+}
+
+// WithHostCache makes the context use the cache for `host`, so all the
+// contexts for the same host share the same cache.
+// Without a host, the cache is private to this context.
+func WithHostCache(ctx context.Context, host string) context.Context {
+	if host == "" {
+		return ctx
+	}
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.cache = getHostCache(host)
+	})
+}
+
 func isCacheDisabled() bool {
 	enabledStr := os.Getenv(cacheEnvVar)
 	if len(enabledStr) > 0 {
@@ -47,20 +158,18 @@ func getFromCacheInContext(ctx context.Context, key string) (interface{}, bool)
 	if isCacheDisabled() {
 		return nil, false
 	}
-	c := getCacheFromContext(ctx)
-	value, ok := c[key]
+	value, ok := getCacheFromContext(ctx).get(key)
 	Debug("[CACHE] getting %q [found:%t] = %v ", key, ok, value)
 	return value, ok
 }
 
 // setInCacheInContext sets a value in the cache
-func setInCacheInContext(ctx context.Context, key string, value interface{}) {
+func setInCacheInContext(ctx context.Context, key string, value interface{}, ttl time.Duration) {
 	if isCacheDisabled() {
 		return
 	}
-	c := getCacheFromContext(ctx)
-	Debug("[CACHE] setting %q = %v", key, value)
-	c[key] = value
+	Debug("[CACHE] setting %q = %v (TTL:%s)", key, value, ttl)
+	getCacheFromContext(ctx).set(key, value, ttl)
 }
 
 // delInCacheInContext removes akey in the cache
@@ -68,9 +177,8 @@ func delInCacheInContext(ctx context.Context, key string) {
 	if isCacheDisabled() {
 		return
 	}
-	c := getCacheFromContext(ctx)
 	Debug("[CACHE] deleting %q", key)
-	delete(c, key)
+	getCacheFromContext(ctx).del(key)
 }
 
 // DoOnce runs an action if it is not been saved in the cache
@@ -84,7 +192,7 @@ func DoOnce(key string, action Action) Action {
 			res := ActionList{action}.Apply(ctx)
 			if !IsError(res) {
 				// save in the cache only when no errors happen
-				setInCacheInContext(ctx, key, true)
+				setInCacheInContext(ctx, key, true, defCacheTTL)
 			}
 			return res
 		}))
@@ -100,20 +208,45 @@ func DoRemoveFromCache(key string) Action {
 
 // DoSetInCache sets some key in the cache
 func DoSetInCache(key string, value interface{}) Action {
+	return DoSetInCacheWithTTL(key, value, defCacheTTL)
+}
+
+// DoSetInCacheWithTTL sets some key in the cache, expiring after some TTL
+func DoSetInCacheWithTTL(key string, value interface{}, ttl time.Duration) Action {
 	return ActionFunc(func(ctx context.Context) Action {
-		setInCacheInContext(ctx, key, value)
+		setInCacheInContext(ctx, key, value, ttl)
 		return nil
 	})
 }
 
+// DoInvalidateCache removes all the keys with some prefix from the cache
+func DoInvalidateCache(prefix string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if isCacheDisabled() {
+			return nil
+		}
+		Debug("[CACHE] invalidating keys with prefix %q", prefix)
+		getCacheFromContext(ctx).delPrefix(prefix)
+		return nil
+	})
+}
+
+// DoInvalidateRemotePath removes any cached information about a remote file or directory
+func DoInvalidateRemotePath(path string) Action {
+	return ActionList{
+		DoRemoveFromCache(CacheRemoteFileExistsPrefix + "-" + path),
+		DoRemoveFromCache(CacheRemoteDirExistsPrefix + "-" + path),
+		DoRemoveFromCache(CacheRemoteFileChecksumPrefix + "-" + path),
+	}
+}
+
 // DoFlushCache flushes the cache
 func DoFlushCache() Action {
 	return ActionFunc(func(ctx context.Context) Action {
 		if isCacheDisabled() {
 			return nil
 		}
-		sctx := getSSHContext(ctx)
-		sctx.cache = cache{}
+		getCacheFromContext(ctx).flush()
 		return nil
 	})
 }
@@ -136,6 +269,11 @@ func CheckInCache(key string) CheckerFunc {
 // CheckOnce checks if there is a cached result for the `key`. If not,
 // runs the check, storing the result in the cache
 func CheckOnce(key string, check Checker) CheckerFunc {
+	return CheckOnceWithTTL(key, defCacheTTL, check)
+}
+
+// CheckOnceWithTTL is like CheckOnce, but the result expires after some TTL
+func CheckOnceWithTTL(key string, ttl time.Duration, check Checker) CheckerFunc {
 	return CheckerFunc(func(ctx context.Context) (bool, error) {
 		value, ok := getFromCacheInContext(ctx, key)
 		if ok {
@@ -147,7 +285,7 @@ func CheckOnce(key string, check Checker) CheckerFunc {
 			return false, err
 		}
 
-		setInCacheInContext(ctx, key, res)
+		setInCacheInContext(ctx, key, res, ttl)
 		return res, nil
 	})
 }
@@ -18,6 +18,7 @@ import (
 	"context"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestIsCacheDisabled(t *testing.T) {
@@ -180,3 +181,66 @@ func TestDoOnceWithError(t *testing.T) {
 		t.Fatalf("Error: unexpected number of increments: %d, expected: %d", count, 1)
 	}
 }
+
+func TestCacheTTLAndInvalidation(t *testing.T) {
+	if isCacheDisabled() {
+		t.Skip("cache TTLs not tested: cache is disabled.")
+		return
+	}
+
+	ctx := NewTestingContext()
+
+	count := 0
+	check := CheckerFunc(func(context.Context) (bool, error) {
+		count++
+		return true, nil
+	})
+
+	actions := ActionList{
+		DoSetInCacheWithTTL("expired", "value", time.Nanosecond),
+		DoSetInCache(CacheRemoteFileExistsPrefix+"-/tmp/a", true),
+		DoSetInCache(CacheRemoteFileExistsPrefix+"-/tmp/b", true),
+		DoSetInCache("other", true),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	time.Sleep(time.Millisecond)
+
+	if _, ok := getFromCacheInContext(ctx, "expired"); ok {
+		t.Fatalf("Error: expired value found in cache")
+	}
+
+	if res := DoInvalidateCache(CacheRemoteFileExistsPrefix).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	for _, key := range []string{CacheRemoteFileExistsPrefix + "-/tmp/a", CacheRemoteFileExistsPrefix + "-/tmp/b"} {
+		if _, ok := getFromCacheInContext(ctx, key); ok {
+			t.Fatalf("Error: invalidated key %q found in cache", key)
+		}
+	}
+	if _, ok := getFromCacheInContext(ctx, "other"); !ok {
+		t.Fatalf("Error: key not invalidated was removed from cache")
+	}
+
+	// checks with an expired TTL are run again
+	for i := 0; i < 2; i++ {
+		if _, err := CheckOnceWithTTL("check", time.Nanosecond, check).Check(ctx); err != nil {
+			t.Fatalf("Error: error detected: %s", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if count != 2 {
+		t.Fatalf("Error: unexpected number of checks: %d, expected: %d", count, 2)
+	}
+
+	// contexts for the same host share the cache
+	ctx1 := WithHostCache(NewTestingContext(), "host-1")
+	ctx2 := WithHostCache(NewTestingContext(), "host-1")
+	if res := DoSetInCache("shared", true).Apply(ctx1); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	if _, ok := getFromCacheInContext(ctx2, "shared"); !ok {
+		t.Fatalf("Error: cache not shared for the same host")
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// ParseCertificate parses an OpenSSH certificate (in authorized_keys format, like
+// in the "id_rsa-cert.pub" files or in the certificates signed by Vault)
+func ParseCertificate(certificate string) (*gossh.Certificate, error) {
+	key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(certificate))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the SSH certificate: %s", err)
+	}
+	cert, ok := key.(*gossh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("not a SSH certificate: %s", key.Type())
+	}
+	if cert.CertType != gossh.UserCert {
+		return nil, fmt.Errorf("the SSH certificate is not a user certificate")
+	}
+	return cert, nil
+}
+
+// CheckCertificate checks that an OpenSSH certificate can be used now by `user`, so
+// we fail early (and with a clear message) with expired certificates
+func CheckCertificate(certificate string, user string, now time.Time) error {
+	cert, err := ParseCertificate(certificate)
+	if err != nil {
+		return err
+	}
+
+	if cert.ValidAfter != 0 && now.Before(time.Unix(int64(cert.ValidAfter), 0)) {
+		return fmt.Errorf("the SSH certificate %q is not valid until %s",
+			cert.KeyId, time.Unix(int64(cert.ValidAfter), 0).UTC().Format(time.RFC3339))
+	}
+	if cert.ValidBefore != gossh.CertTimeInfinity && !now.Before(time.Unix(int64(cert.ValidBefore), 0)) {
+		return fmt.Errorf("the SSH certificate %q expired at %s",
+			cert.KeyId, time.Unix(int64(cert.ValidBefore), 0).UTC().Format(time.RFC3339))
+	}
+
+	if user != "" && len(cert.ValidPrincipals) > 0 {
+		found := false
+		for _, principal := range cert.ValidPrincipals {
+			if principal == user {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("the SSH certificate %q is not valid for %q (only for %s)",
+				cert.KeyId, user, strings.Join(cert.ValidPrincipals, ", "))
+		}
+	}
+	return nil
+}
+
+// getCertSigner returns a signer that presents the certificate for a private key
+func getCertSigner(signer gossh.Signer, certificate string) (gossh.Signer, error) {
+	cert, err := ParseCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+	certSigner, err := gossh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("the SSH certificate does not match the private key: %s", err)
+	}
+	return certSigner, nil
+}
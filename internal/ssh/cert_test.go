@@ -0,0 +1,139 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// newTestCertificate signs a user certificate for `key` with a CA
+func newTestCertificate(t *testing.T, ca gossh.Signer, key gossh.PublicKey, principals []string, validBefore time.Time) string {
+	cert := &gossh.Certificate{
+		Key:             key,
+		KeyId:           "test",
+		CertType:        gossh.UserCert,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return string(gossh.MarshalAuthorizedKey(cert))
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ca := newTestHostKey(t)
+	key := newTestHostKey(t)
+	now := time.Now()
+
+	valid := newTestCertificate(t, ca, key.PublicKey(), []string{"admin"}, now.Add(time.Hour))
+	if err := CheckCertificate(valid, "admin", now); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := CheckCertificate(valid, "root", now); err == nil || !strings.Contains(err.Error(), "not valid for") {
+		t.Fatalf("Error: wrong principal not detected: %v", err)
+	}
+
+	expired := newTestCertificate(t, ca, key.PublicKey(), nil, now.Add(-time.Minute))
+	if err := CheckCertificate(expired, "admin", now); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("Error: expired certificate not detected: %v", err)
+	}
+
+	if err := CheckCertificate(string(gossh.MarshalAuthorizedKey(key.PublicKey())), "admin", now); err == nil {
+		t.Fatalf("Error: a public key has been accepted as a certificate")
+	}
+}
+
+func TestSSHHostWithCertificate(t *testing.T) {
+	ca := newTestHostKey(t)
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	privPEM := string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// a server that only accepts certificates signed by the CA
+	checker := &gossh.CertChecker{
+		IsUserAuthority: func(auth gossh.PublicKey) bool {
+			return string(auth.Marshal()) == string(ca.PublicKey().Marshal())
+		},
+	}
+	config := &gossh.ServerConfig{PublicKeyCallback: checker.Authenticate}
+	config.AddHostKey(newTestHostKey(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _, _, _ = gossh.NewServerConn(conn, config)
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	hp, _ := ParseHostPort("admin@"+l.Addr().String(), DefSSHPort)
+	host := SSHHost{Address: hp, PrivateKey: privPEM}
+
+	dial := func() error {
+		config, err := host.getClientConfig(5 * time.Second)
+		if err != nil {
+			return err
+		}
+		client, err := gossh.Dial("tcp", l.Addr().String(), config)
+		if err != nil {
+			return err
+		}
+		_ = client.Close()
+		return nil
+	}
+
+	if err := dial(); err == nil {
+		t.Fatalf("Error: connected without a certificate")
+	}
+	host.Certificate = newTestCertificate(t, ca, signer.PublicKey(), []string{"admin"}, time.Now().Add(time.Hour))
+	if err := dial(); err != nil {
+		t.Fatalf("Error: could not connect with the certificate: %v", err)
+	}
+	host.Certificate = newTestCertificate(t, ca, newTestHostKey(t).PublicKey(), []string{"admin"}, time.Now().Add(time.Hour))
+	if err := dial(); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("Error: a certificate for another key has been accepted: %v", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// clusterScope is some state shared by all the nodes of the same cluster
+// that are provisioned in the same process
+type clusterScope struct {
+	sync.Mutex
+	values *cache
+	locks  map[string]*sync.Mutex
+
+	// slots for limiting the number of nodes provisioned concurrently
+	slots chan struct{}
+
+	// identity and references for the coordination locks (see DoWithClusterLock)
+	lockHolder string
+	lockRefs   map[string]int
+}
+
+func newClusterScope() *clusterScope {
+	return &clusterScope{
+		values:   newCache(),
+		locks:    map[string]*sync.Mutex{},
+		lockRefs: map[string]int{},
+	}
+}
+
+var (
+	clusterScopesMutex sync.Mutex
+	clusterScopes      = map[string]*clusterScope{}
+)
+
+// getClusterScope gets the (shared) scope for a cluster ID
+func getClusterScope(id string) *clusterScope {
+	clusterScopesMutex.Lock()
+	defer clusterScopesMutex.Unlock()
+
+	cs, ok := clusterScopes[id]
+	if !ok {
+		cs = newClusterScope()
+		clusterScopes[id] = cs
+	}
+	return cs
+}
+
+// lockFor returns the lock for a key
+func (cs *clusterScope) lockFor(key string) *sync.Mutex {
+	cs.Lock()
+	defer cs.Unlock()
+
+	l, ok := cs.locks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		cs.locks[key] = l
+	}
+	return l
+}
+
+// WithClusterID sets the cluster ID in the context, so all the contexts
+// with the same cluster ID will share the "once per cluster" state.
+// Without a cluster ID, the state is private to this context.
+func WithClusterID(ctx context.Context, id string) context.Context {
+	if id == "" {
+		return ctx
+	}
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.cluster = getClusterScope(id)
+	})
+}
+
+// GetFromClusterCache gets a value from the cache shared by the nodes in the cluster
+func GetFromClusterCache(ctx context.Context, key string) (interface{}, bool) {
+	if isCacheDisabled() {
+		return nil, false
+	}
+	value, ok := getSSHContext(ctx).cluster.values.get(key)
+	Debug("[CLUSTER CACHE] getting %q [found:%t] = %v ", key, ok, value)
+	return value, ok
+}
+
+// DoSetInClusterCache sets some key in the cache shared by the nodes in the cluster
+func DoSetInClusterCache(key string, value interface{}) Action {
+	return DoSetInClusterCacheWithTTL(key, value, 0)
+}
+
+// DoSetInClusterCacheWithTTL sets some key in the cache shared by the nodes
+// in the cluster, forgetting it after some `ttl` (or never when 0)
+func DoSetInClusterCacheWithTTL(key string, value interface{}, ttl time.Duration) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if isCacheDisabled() {
+			return nil
+		}
+		Debug("[CLUSTER CACHE] setting %q = %v (ttl=%s)", key, value, ttl)
+		getSSHContext(ctx).cluster.values.set(key, value, ttl)
+		return nil
+	})
+}
+
+// DoOncePerCluster runs an action only once in the whole cluster: the first node
+// that gets here runs it, while the other nodes wait for it and then skip it.
+// If the action fails, it will be run again by the next node.
+func DoOncePerCluster(key string, action Action) Action {
+	return DoOncePerClusterWithTTL(key, 0, action)
+}
+
+// DoOncePerClusterWithTTL is like DoOncePerCluster, but the action is run again
+// by the next node that gets here after some `ttl` (or never when 0)
+func DoOncePerClusterWithTTL(key string, ttl time.Duration, action Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if isCacheDisabled() {
+			return action
+		}
+
+		cs := getSSHContext(ctx).cluster
+		l := cs.lockFor(key)
+		l.Lock()
+		defer l.Unlock()
+
+		if _, ok := cs.values.get(key); ok {
+			Debug("[CLUSTER CACHE] %q already done in the cluster: skipping", key)
+			return nil
+		}
+
+		res := ActionList{action}.Apply(ctx)
+		if !IsError(res) {
+			cs.values.set(key, true, ttl)
+		}
+		return res
+	})
+}
+
+// getSlots returns the slots for provisioning nodes concurrently,
+// creating them (with `max` slots) if they do not exist yet
+func (cs *clusterScope) getSlots(max int) chan struct{} {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.slots == nil {
+		cs.slots = make(chan struct{}, max)
+	} else if cap(cs.slots) != max {
+		Debug("[CLUSTER] ignoring max parallel nodes=%d: already set to %d", max, cap(cs.slots))
+	}
+	return cs.slots
+}
+
+// AcquireClusterSlot waits until there are less than `max` nodes being provisioned
+// concurrently in the cluster `id`, returning a function for releasing the slot.
+// The first node that gets here sets the number of slots for the whole cluster.
+// With a `max` <= 0 there is no limit.
+func AcquireClusterSlot(ctx context.Context, id string, max int) (func(), error) {
+	if max <= 0 {
+		return func() {}, nil
+	}
+
+	slots := getClusterScope(id).getSlots(max)
+	select {
+	case slots <- struct{}{}:
+		Debug("[CLUSTER] slot acquired (%d/%d in use)", len(slots), cap(slots))
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
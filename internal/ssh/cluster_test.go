@@ -0,0 +1,155 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDoOncePerCluster(t *testing.T) {
+	if isCacheDisabled() {
+		t.Skip("DoOncePerCluster not tested: cache is disabled.")
+		return
+	}
+
+	var mutex sync.Mutex
+	count := 0
+	inc := ActionFunc(func(context.Context) Action {
+		mutex.Lock()
+		defer mutex.Unlock()
+		count++
+		return nil
+	})
+
+	// some nodes in the same cluster, provisioned in parallel
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := WithClusterID(NewTestingContext(), "cluster-1")
+			if res := DoOncePerCluster("test", inc).Apply(ctx); IsError(res) {
+				t.Errorf("Error: error detected: %s", res)
+			}
+		}()
+	}
+	wg.Wait()
+	if count != 1 {
+		t.Fatalf("Error: action run %d times in the same cluster, expected 1", count)
+	}
+
+	// nodes without a cluster ID or in other clusters must run it again
+	for _, ctx := range []context.Context{NewTestingContext(), WithClusterID(NewTestingContext(), "cluster-2")} {
+		if res := DoOncePerCluster("test", inc).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: error detected: %s", res)
+		}
+	}
+	if count != 3 {
+		t.Fatalf("Error: unexpected value in counter: %d, expected: %d", count, 3)
+	}
+
+	// values can be shared between the nodes
+	ctx1 := WithClusterID(NewTestingContext(), "cluster-1")
+	ctx2 := WithClusterID(NewTestingContext(), "cluster-1")
+	if res := DoSetInClusterCache("token", "abcdef").Apply(ctx1); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	if v, ok := GetFromClusterCache(ctx2, "token"); !ok || v.(string) != "abcdef" {
+		t.Fatalf("Error: value not shared in the cluster: %v", v)
+	}
+}
+
+func TestDoOncePerClusterWithTTL(t *testing.T) {
+	if isCacheDisabled() {
+		t.Skip("DoOncePerClusterWithTTL not tested: cache is disabled.")
+		return
+	}
+
+	count := 0
+	inc := ActionFunc(func(context.Context) Action {
+		count++
+		return nil
+	})
+
+	ctx := WithClusterID(NewTestingContext(), "cluster-ttl")
+	for i := 0; i < 2; i++ {
+		if res := DoOncePerClusterWithTTL("test", 20*time.Millisecond, inc).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: error detected: %s", res)
+		}
+	}
+	if count != 1 {
+		t.Fatalf("Error: action run %d times before the TTL, expected 1", count)
+	}
+
+	// the action must be run again after the TTL
+	time.Sleep(30 * time.Millisecond)
+	if res := DoOncePerClusterWithTTL("test", 20*time.Millisecond, inc).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	if count != 2 {
+		t.Fatalf("Error: action run %d times after the TTL, expected 2", count)
+	}
+}
+
+func TestAcquireClusterSlot(t *testing.T) {
+	const max = 2
+
+	var mutex sync.Mutex
+	current, peak := 0, 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := AcquireClusterSlot(context.Background(), "test-slots", max)
+			if err != nil {
+				t.Errorf("Error: %s", err)
+				return
+			}
+			defer release()
+
+			mutex.Lock()
+			current++
+			if current > peak {
+				peak = current
+			}
+			mutex.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mutex.Lock()
+			current--
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if peak > max {
+		t.Fatalf("Error: %d nodes provisioned concurrently (max=%d)", peak, max)
+	}
+
+	// a cancelled context must not wait forever
+	ctx, cancel := context.WithCancel(context.Background())
+	release1, _ := AcquireClusterSlot(ctx, "test-slots-cancel", 1)
+	defer release1()
+	cancel()
+	if _, err := AcquireClusterSlot(ctx, "test-slots-cancel", 1); err == nil {
+		t.Fatalf("Error: slot acquired with a cancelled context")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"strings"
+)
+
+// Command is a remote command where all the arguments are quoted when it is
+// rendered, so the paths, names, etc. provided by users can never break (or
+// inject) commands. It should be preferred over raw strings in DoExec().
+type Command struct {
+	name string
+	args []string
+}
+
+// NewCommand returns a new command, with some arguments
+func NewCommand(name string, args ...string) Command {
+	return Command{name: name, args: append([]string{}, args...)}
+}
+
+// NewShellCommand returns a command that runs a shell `script` (with `sh -c`),
+// where the `args` are available as the positional parameters ($1, $2...).
+// The script should never be built with user input: use the arguments for that.
+func NewShellCommand(script string, args ...string) Command {
+	return NewCommand("sh", append([]string{"-c", script, "sh"}, args...)...)
+}
+
+// WithArgs returns a copy of the command with some more arguments
+func (c Command) WithArgs(args ...string) Command {
+	return NewCommand(c.name, append(append([]string{}, c.args...), args...)...)
+}
+
+// String returns the command, with all the arguments quoted for the shell
+func (c Command) String() string {
+	words := []string{shellQuoteIfNeeded(c.name)}
+	for _, arg := range c.args {
+		words = append(words, shellQuoteIfNeeded(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+// DoExecCommand runs a remote Command
+func DoExecCommand(c Command) Action {
+	return DoExec(c.String()) // rawcmd: all the arguments are quoted
+}
+
+// CheckExecCommand checks if a remote Command succeeds or not
+func CheckExecCommand(c Command) CheckerFunc {
+	return CheckExec(c.String()) // rawcmd: all the arguments are quoted
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestCommand(t *testing.T) {
+	testCases := []struct {
+		cmd      Command
+		expected string
+	}{
+		{
+			NewCommand("kubeadm", "upgrade", "apply", "--yes", "v1.15.1"),
+			"kubeadm upgrade apply --yes v1.15.1",
+		},
+		{
+			NewCommand("rm", "-f", "/var/lib/my dir/$(reboot)"),
+			`rm -f '/var/lib/my dir/$(reboot)'`,
+		},
+		{
+			NewCommand("kubeadm", "token").WithArgs("create", "--description=node's token"),
+			`kubeadm token create '--description=node'"'"'s token'`,
+		},
+		{
+			NewShellCommand(`[ ! -f "$1" ] || cp -a "$1" "$2"`, "/etc/hosts", "/etc/hosts.bak"),
+			`sh -c '[ ! -f "$1" ] || cp -a "$1" "$2"' sh /etc/hosts /etc/hosts.bak`,
+		},
+		{
+			NewCommand("echo", ""),
+			`echo ''`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if s := testCase.cmd.String(); s != testCase.expected {
+			t.Fatalf("Error: unexpected command %q, expected %q", s, testCase.expected)
+		}
+	}
+
+	// WithArgs does not modify the original command
+	base := NewCommand("kubectl", "get")
+	_ = base.WithArgs("nodes")
+	if s := base.String(); s != "kubectl get" {
+		t.Fatalf("Error: original command modified: %q", s)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/armon/circbuf"
 	"github.com/hashicorp/terraform/communicator/remote"
@@ -38,8 +39,54 @@ const (
 	// invocation. This is to prevent TF memory usage from growing
 	// to an enormous amount due to a faulty process.
 	maxBufSize = 8 * 1024
+
+	// the interrupt character in the terminal (Ctrl-C)
+	terminalInterruptChar = '\x03'
 )
 
+// interruptGracePeriod is the time we wait for a remote command to finish after
+// interrupting it, before dropping the connection
+var interruptGracePeriod = 10 * time.Second
+
+// interruptibleStdin is the stdin of a remote command, where we can type a Ctrl-C for
+// interrupting it: the commands are run in a PTY, so the remote terminal sends a
+// SIGINT to the command (without closing the connection, that could be shared)
+type interruptibleStdin struct {
+	io.Reader
+	w *io.PipeWriter
+}
+
+// newInterruptibleStdin returns a stdin that reads `stdin` (if not nil) and then
+// waits for the interrupt, until it is closed
+func newInterruptibleStdin(stdin io.Reader) *interruptibleStdin {
+	r, w := io.Pipe()
+	if stdin == nil {
+		return &interruptibleStdin{Reader: r, w: w}
+	}
+	return &interruptibleStdin{Reader: io.MultiReader(stdin, r), w: w}
+}
+
+// Interrupt types the interrupt character in the stdin, waiting (at most) `timeout`
+// for the remote side to read it
+func (s *interruptibleStdin) Interrupt(timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.w.Write([]byte{terminalInterruptChar})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("interrupt not read after %s", timeout)
+	}
+}
+
+// Close closes the stdin (the remote side gets an EOF)
+func (s *interruptibleStdin) Close() error {
+	return s.w.Close()
+}
+
 func copyOutput(output terraform.UIOutput, input io.Reader, done chan<- struct{}) {
 	defer close(done)
 	lr := linereader.New(input)
@@ -48,6 +95,19 @@ func copyOutput(output terraform.UIOutput, input io.Reader, done chan<- struct{}
 	}
 }
 
+// getRemoteCommand returns the full command run in the host: the command
+// (and its entry in the audit log) with the pinned environment, wrapped
+// in a "sh -c" when the login shell is not a POSIX shell
+func getRemoteCommand(ctx context.Context, command string) string {
+	return getCommandWithShellWrapper(ctx, getCommandWithEnv(ctx, getAuditedCommand(ctx, command)))
+}
+
+// getRemoteCommandWithBannerMarker returns the full command run in the host (see
+// getRemoteCommand()), printing the banner marker before running the command
+func getRemoteCommandWithBannerMarker(ctx context.Context, command string) string {
+	return getCommandWithShellWrapper(ctx, getCommandWithBannerMarker(getCommandWithEnv(ctx, getAuditedCommand(ctx, command))))
+}
+
 // DoExec is a runner for remote Commands
 func DoExec(command string) Action {
 	return ActionFunc(func(ctx context.Context) (res Action) {
@@ -57,9 +117,16 @@ func DoExec(command string) Action {
 
 		execOutput := GetExecOutputFromContext(ctx)
 		comm := GetCommFromContext(ctx)
+		if comm == nil {
+			return ActionError(fmt.Sprintf("no communicator available for running %q", command))
+		}
 
-		if GetUseSudoFromContext(ctx) {
-			command = "sudo " + sudoArgs + " " + command
+		command = getEscalatedCommand(ctx, command)
+
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "run: %s", command)
+			recordExecution(ctx, ExecutionEntry{Kind: ExecutionKindExec, Command: command})
+			return nil
 		}
 
 		Debug("running %q", command)
@@ -69,24 +136,81 @@ func DoExec(command string) Action {
 		outDoneCh := make(chan struct{})
 		errDoneCh := make(chan struct{})
 
-		go copyOutput(execOutput, outR, outDoneCh)
-		go copyOutput(execOutput, errR, errDoneCh)
+		remoteCmd := getRemoteCommand(ctx, command)
+		var stdout, stderr UIOutput = execOutput, execOutput
+		if isBannerFilterEnabled(ctx) {
+			remoteCmd = getRemoteCommandWithBannerMarker(ctx, command)
+			stdout, stderr = newBannerFilter(execOutput), newBannerFilter(execOutput)
+		}
+
+		// collect the output for the transcript (if any)
+		var collected *transcriptOutput
+		if getSSHContext(ctx).transcript != nil {
+			collected = &transcriptOutput{}
+			stdout, stderr = collected.Tee(stdout), collected.Tee(stderr)
+		}
+
+		go copyOutput(stdout, outR, outDoneCh)
+		go copyOutput(stderr, errR, errDoneCh)
+
+		stdin := newInterruptibleStdin(getEscalationStdin(ctx))
+		defer stdin.Close()
 
 		cmd := &remote.Cmd{
-			Command: command,
+			Command: remoteCmd,
+			Stdin:   stdin,
 			Stdout:  outW,
 			Stderr:  errW,
 		}
 
-		if err := comm.Start(cmd); err != nil {
-			return ActionError(fmt.Sprintf("Error executing command %q: %v", cmd.Command, err))
+		entry := ExecutionEntry{Kind: ExecutionKindExec, Command: command}
+		defer func() { recordExecution(ctx, entry) }()
+
+		started := time.Now()
+		exitStatus := transcriptNoExitStatus
+		defer func() {
+			recordTranscript(ctx, TranscriptEntry{
+				Command:    command,
+				ExitStatus: exitStatus,
+				Duration:   time.Since(started),
+				Error:      entry.Error,
+			}, collected)
+		}()
+
+		if err := withPacing(ctx, "command", func() error { return comm.Start(cmd) }); err != nil {
+			_ = outW.Close()
+			_ = errW.Close()
+			entry.Error = err.Error()
+			return ActionError(fmt.Sprintf("Error executing command %q: %v", command, err))
+		}
+		waitCh := make(chan error, 1)
+		go func() { waitCh <- cmd.Wait() }()
+
+		var waitResult error
+		select {
+		case waitResult = <-waitCh:
+		case <-getDeadlineExceeded(ctx):
+			// the communicator cannot kill remote commands: interrupt the command in its
+			// terminal, and drop the connection only when it does not finish after that
+			// (the next command will open a new one, unless the connection is shared)
+			if !interruptCommand(stdin, waitCh) {
+				Debug("command %q not finished after the interrupt: dropping the connection", command)
+				_ = comm.Disconnect()
+			}
+			_ = outW.Close()
+			_ = errW.Close()
+			msg := fmt.Sprintf("Command %q cancelled: %s", command, ctx.Err())
+			entry.Error = msg
+			return ActionError(msg)
 		}
-		waitResult := cmd.Wait()
-		if waitResult != nil {
-			cmdError, _ := waitResult.(*remote.ExitError)
+		if waitResult == nil {
+			exitStatus = 0
+		} else if cmdError, ok := waitResult.(*remote.ExitError); ok {
+			exitStatus = cmdError.ExitStatus
 			if cmdError.ExitStatus != 0 {
-				msg := fmt.Sprintf("Command %q exited with non-zero exit status: %d", cmdError.Command, cmdError.ExitStatus)
+				msg := fmt.Sprintf("Command %q exited with non-zero exit status: %d", command, cmdError.ExitStatus)
 				Debug(msg)
+				entry.Error = msg
 				res = ActionError(msg)
 			}
 			// otherwise, it is a communicator error
@@ -107,29 +231,60 @@ func DoExec(command string) Action {
 	})
 }
 
-// DoExecScript is a runner for a script (with some random path in /tmp)
+// interruptCommand interrupts a remote command, returning true if it finishes
+// in the interruptGracePeriod
+func interruptCommand(stdin *interruptibleStdin, waitCh <-chan error) bool {
+	deadline := time.After(interruptGracePeriod)
+	if err := stdin.Interrupt(interruptGracePeriod); err != nil {
+		Debug("could not interrupt the command: %s", err)
+		return false
+	}
+	select {
+	case <-waitCh:
+		return true
+	case <-deadline:
+		return false
+	}
+}
+
+// DoExecScript is a runner for a script (with some random path in the remote temporary directory)
 func DoExecScript(contents []byte) Action {
-	path, err := GetTempFilename()
-	if err != nil {
-		return ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
+	return DoWithTempFilename(func(path string) Action {
+		return DoWithCleanup(
+			ActionList{
+				doRealUploadFile(contents, path),
+				ActionFunc(func(ctx context.Context) Action {
+					return DoExec(fmt.Sprintf("%s %s", GetShellFromContext(ctx), path)) // rawcmd: a temporary file with the configured shell
+				}),
+			},
+			ActionList{
+				DoTry(DoDeleteFile(path)),
+			})
+	})
+}
+
+// getLocalCommandLine returns a local command (and its arguments) as a (quoted) command line,
+// only for printing it: the command and the arguments are never parsed by a shell
+func getLocalCommandLine(command string, args ...string) string {
+	words := []string{shellQuoteIfNeeded(command)}
+	for _, arg := range args {
+		words = append(words, shellQuoteIfNeeded(arg))
 	}
-	return DoWithCleanup(
-		ActionList{
-			doRealUploadFile(contents, path),
-			DoExec(fmt.Sprintf("sh %s", path)),
-		},
-		ActionList{
-			DoTry(DoDeleteFile(path)),
-		})
+	return strings.Join(words, " ")
 }
 
-// DoLocalExec executes a local command
+// DoLocalExec executes a local command. The `command` is the path of the
+// executable and the `args` are passed as they are (so they can contain spaces).
 func DoLocalExec(command string, args ...string) Action {
 	return ActionFunc(func(ctx context.Context) Action {
 		userOutput := GetUserOutputFromContext(ctx)
 		execOutput := GetExecOutputFromContext(ctx)
 
-		fullCmd := fmt.Sprintf("%s %s", command, strings.Join(args, " "))
+		fullCmd := getLocalCommandLine(command, args...)
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "run local command: %s", fullCmd)
+			return nil
+		}
 		userOutput.Output(fmt.Sprintf("Running local command %q...", fullCmd))
 
 		// Setup the reader that will read the output from the command.
@@ -187,7 +342,7 @@ func DoLocalExec(command string, args ...string) Action {
 		}
 
 		if err != nil {
-			msg := fmt.Sprintf("Error running command '%s': %v", command, err)
+			msg := fmt.Sprintf("Error running command %q: %v", fullCmd, err)
 			return ActionError(msg)
 		}
 		return nil
@@ -203,7 +358,7 @@ func CheckExec(cmd string) CheckerFunc {
 	return CheckerFunc(func(ctx context.Context) (bool, error) {
 		Debug("Checking condition: '%s'", cmd)
 		var buf bytes.Buffer
-		if res := DoSendingExecOutputToWriter(DoExec(command), &buf).Apply(ctx); IsError(res) {
+		if res := DoSendingExecOutputToWriter(DoExec(command), &buf).Apply(ctx); IsError(res) { // rawcmd: the command checked
 			Debug("ERROR: when performing check %q: %s", cmd, res)
 			return false, res
 		}
@@ -224,12 +379,12 @@ func CheckExec(cmd string) CheckerFunc {
 // CheckBinaryExists checks that a binary exists in the path
 func CheckBinaryExists(cmd string) CheckerFunc {
 	// note: start 'command' in a subshell, as it doesn't mix well with 'sudo'
-	command := fmt.Sprintf("sh -c \"command -v '%s'\"", cmd)
+	command := NewShellCommand(`command -v "$1"`, cmd)
 
 	return CheckerFunc(func(ctx context.Context) (bool, error) {
 		Debug("Checking binary exists with: '%s'", cmd)
 		var buf bytes.Buffer
-		if res := DoSendingExecOutputToWriter(DoExec(command), &buf).Apply(ctx); IsError(res) {
+		if res := DoSendingExecOutputToWriter(DoExecCommand(command), &buf).Apply(ctx); IsError(res) {
 			Debug("ERROR: when performing check: %s", res)
 			return false, res
 		}
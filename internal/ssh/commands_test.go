@@ -15,6 +15,11 @@
 package ssh
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -33,3 +38,28 @@ func TestCheckBinaryExists(t *testing.T) {
 		t.Fatalf("Error: unexpected result for exists: %t", exists)
 	}
 }
+
+func TestDoLocalExecWithSpaces(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local exec")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "some dir", "kube config; $(reboot)")
+	_ = os.MkdirAll(filepath.Dir(name), 0755)
+	_ = ioutil.WriteFile(name, []byte("some contents"), 0644)
+
+	var buf bytes.Buffer
+	ctx := NewTestingContext()
+	if res := (ActionList{DoSendingExecOutputToWriter(DoLocalExec("cat", name), &buf)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: could not run local command: %s", res)
+	}
+	if s := strings.TrimSpace(buf.String()); s != "some contents" {
+		t.Fatalf("Error: unexpected output: %q", s)
+	}
+
+	if s := getLocalCommandLine("cat", name); s != "cat '"+name+"'" {
+		t.Fatalf("Error: unexpected command line: %q", s)
+	}
+}
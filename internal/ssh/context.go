@@ -16,6 +16,8 @@ package ssh
 
 import (
 	"context"
+	"fmt"
+	"sync"
 
 	"github.com/hashicorp/terraform/communicator"
 )
@@ -36,18 +38,65 @@ func (f OutputFunc) Output(s string) { f(s) }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////
 
-type cache map[string]interface{}
+// Host is the identity of the machine where the actions are run
+type Host struct {
+	// Address is the address (IP or DNS name) of the machine
+	Address string
 
-///////////////////////////////////////////////////////////////////////////////////////////////
+	// Role is the role of the machine in the cluster (ie, "master" or "worker")
+	Role string
+}
+
+func (h Host) String() string {
+	if h.Role == "" {
+		return h.Address
+	}
+	return fmt.Sprintf("%s (%s)", h.Address, h.Role)
+}
+
+// facts are some things we know about a host
+type facts struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// leftovers are some files that must be removed at the end of the provisioning
+type leftovers struct {
+	sync.Mutex
+	paths []string
+}
 
-// sshContext is the "internal" context we pass around
+// sshContext is the "internal" context we pass around.
+// It is never modified once it is in a context: new contexts are derived
+// with a copy of the sshContext, so the same actions can be run in parallel
+// for many hosts. Some state (the cache, facts, leftovers...) is shared
+// between the derived contexts.
 type sshContext struct {
+	host       Host
 	useSudo    bool
 	userOutput UIOutput
 	execOutput UIOutput
 	comm       communicator.Communicator
-	cache      cache
-	leftovers  []string
+	cache      *cache
+	cluster    *clusterScope
+	facts      *facts
+	leftovers  *leftovers
+	phase      *phase
+
+	execManifest *ExecutionManifest
+	transcript   *Transcript
+	auditLog     *AuditLog
+	execEnv      *ExecEnv
+	shellWrapper string
+	escalation   string
+	sudoPassword string
+	remoteTmp    string
+	dryRun       bool
+	filterBanner bool
+
+	maintenanceWindow *MaintenanceWindow
+
+	writablePaths []string
 }
 
 // WithValues creates a new "internal" SSH context
@@ -57,19 +106,94 @@ func WithValues(ctx context.Context, userOutput UIOutput, execOutput UIOutput, c
 		userOutput: userOutput,
 		execOutput: execOutput,
 		comm:       comm,
-		cache:      cache{},
-		leftovers:  []string{},
+		cache:      newCache(),
+		cluster:    newClusterScope(),
+		facts:      &facts{values: map[string]string{}},
+		leftovers:  &leftovers{},
+		phase:      &phase{},
+	})
+}
+
+// withDerivedSSHContext returns a new context with a copy of the current
+// sshContext, modified by `f`
+func withDerivedSSHContext(ctx context.Context, f func(*sshContext)) context.Context {
+	derived := *getSSHContext(ctx)
+	f(&derived)
+	return context.WithValue(ctx, sshContextKey, &derived)
+}
+
+// WithHost returns a new context for running actions in a host
+// (using the cache for that host)
+func WithHost(ctx context.Context, host Host) context.Context {
+	ctx = WithHostCache(ctx, host.Address)
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.host = host
+	})
+}
+
+// WithExecOutput returns a new context where the output of the
+// remote commands is sent to `execOutput`
+func WithExecOutput(ctx context.Context, execOutput UIOutput) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.execOutput = execOutput
+	})
+}
+
+// withUseSudo returns a new context where commands are run (or not) with sudo
+func withUseSudo(ctx context.Context, useSudo bool) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.useSudo = useSudo
 	})
 }
 
+// WithRemoteTmp returns a new context where temporary files are created
+// in the remote directory `dir` (instead of /tmp)
+func WithRemoteTmp(ctx context.Context, dir string) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.remoteTmp = dir
+	})
+}
+
+// HasSSHContext returns true if there is an SSH context in the context
+func HasSSHContext(ctx context.Context) bool {
+	_, ok := ctx.Value(sshContextKey).(*sshContext)
+	return ok
+}
+
 func getSSHContext(ctx context.Context) *sshContext {
 	sshc, ok := ctx.Value(sshContextKey).(*sshContext)
 	if !ok {
-		panic("could not get SSH context info info from context")
+		panic("could not get SSH context info from context: the context must be created with WithValues()")
 	}
 	return sshc
 }
 
+// GetHostFromContext gets the host the actions are run on
+func GetHostFromContext(ctx context.Context) Host {
+	return getSSHContext(ctx).host
+}
+
+// GetFactFromContext gets some fact about the current host
+func GetFactFromContext(ctx context.Context, key string) (string, bool) {
+	f := getSSHContext(ctx).facts
+	f.Lock()
+	defer f.Unlock()
+	value, ok := f.values[key]
+	return value, ok
+}
+
+// DoSetFact sets some fact about the current host
+func DoSetFact(key string, value string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		f := getSSHContext(ctx).facts
+		f.Lock()
+		defer f.Unlock()
+		Debug("[FACTS] %q = %q", key, value)
+		f.values[key] = value
+		return nil
+	})
+}
+
 // GetUseSudoFromContext gets the "should we use sudo?" value
 func GetUseSudoFromContext(ctx context.Context) bool {
 	return getSSHContext(ctx).useSudo
@@ -91,6 +215,6 @@ func GetCommFromContext(ctx context.Context) communicator.Communicator {
 }
 
 // getCacheFromContext gets the cache from the current context
-func getCacheFromContext(ctx context.Context) cache {
+func getCacheFromContext(ctx context.Context) *cache {
 	return getSSHContext(ctx).cache
 }
@@ -13,3 +13,50 @@
 // limitations under the License.
 
 package ssh
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDerivedContexts(t *testing.T) {
+	ctx := NewTestingContext()
+
+	master := WithHost(ctx, Host{Address: "10.0.0.1", Role: "master"})
+	worker := WithHost(ctx, Host{Address: "10.0.0.2", Role: "worker"})
+
+	if h := GetHostFromContext(ctx); h.Address != "" {
+		t.Fatalf("Error: parent context modified: %s", h)
+	}
+	if h := GetHostFromContext(master); h.Address != "10.0.0.1" || h.Role != "master" {
+		t.Fatalf("Error: unexpected host: %s", h)
+	}
+	if h := GetHostFromContext(worker); h.Address != "10.0.0.2" || h.Role != "worker" {
+		t.Fatalf("Error: unexpected host: %s", h)
+	}
+
+	// the exec output can be redirected while keeping the cache and the facts
+	output := ""
+	actions := ActionList{
+		DoSetFact("os", "linux"),
+		DoSetInCache("key", "value"),
+		DoSendingExecOutputToFunc(
+			ActionFunc(func(ctx context.Context) Action {
+				if _, ok := getFromCacheInContext(ctx, "key"); !ok {
+					return ActionError("cache lost when redirecting the output")
+				}
+				if os, ok := GetFactFromContext(ctx, "os"); !ok || os != "linux" {
+					return ActionError("facts lost when redirecting the output")
+				}
+				GetExecOutputFromContext(ctx).Output("something")
+				return nil
+			}),
+			func(s string) { output += s }),
+	}
+	if res := actions.Apply(master); IsError(res) {
+		t.Fatalf("Error: error detected: %s", res)
+	}
+	if output != "something" {
+		t.Fatalf("Error: output not redirected: %q", output)
+	}
+}
@@ -15,15 +15,64 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path"
+	"path/filepath"
 )
 
+// DirMode is the permissions and ownership for a remote directory
+type DirMode struct {
+	Mode  os.FileMode
+	Owner string
+	Group string
+}
+
+const (
+	// mkdirWithModeScript creates a directory ($1) with some permissions ($2)
+	mkdirWithModeScript = `mkdir -p "$1" && chmod "$2" "$1"`
+
+	// mkdirWithOwnerScript creates a directory ($1) with some permissions ($2) and owner ($3)
+	mkdirWithOwnerScript = `mkdir -p "$1" && chmod "$2" "$1" && chown "$3" "$1"`
+
+	// touchWithModeScript creates an empty file ($1) with some permissions ($2)
+	touchWithModeScript = `touch "$1" && chmod "$2" "$1"`
+)
+
+var (
+	// DefPrivateDirMode is the mode for private directories (ie, the PKI dir)
+	DefPrivateDirMode = DirMode{Mode: 0750, Owner: "root", Group: "root"}
+)
+
+// String returns a string representation of the DirMode
+func (m DirMode) String() string {
+	return fmt.Sprintf("%04o %s:%s", m.Mode, m.Owner, m.Group)
+}
+
+// getPermArg returns the argument for a `chmod` with the permissions in some mode
+func getPermArg(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// getChownArg returns the argument for a `chown`, or an empty string if no owner/group must be set
+func (m DirMode) getChownArg() string {
+	switch {
+	case m.Owner != "" && m.Group != "":
+		return m.Owner + ":" + m.Group
+	case m.Owner != "":
+		return m.Owner
+	case m.Group != "":
+		return ":" + m.Group
+	}
+	return ""
+}
+
 // DoMkdir creates a remote directory
 func DoMkdir(path string) Action {
-	mkdirCmd := fmt.Sprintf("mkdir -p %s", path)
 	return ActionList{
 		DoMessageDebug(fmt.Sprintf("Making sure directory %q exists", path)),
-		DoExec(mkdirCmd),
+		doWithoutEscalationIn(DoExecCommand(NewCommand("mkdir", "-p", path)), path),
 	}
 }
 
@@ -36,7 +85,107 @@ func DoMkdirOnce(dir string) Action {
 		DoMkdir(dir))
 }
 
+// DoMkdirWithMode creates a remote directory, setting the permissions and owner
+// explicitly (so the result does not depend on the remote umask)
+func DoMkdirWithMode(path string, mode DirMode) Action {
+	cmd := NewShellCommand(mkdirWithModeScript, path, getPermArg(mode.Mode))
+	if chown := mode.getChownArg(); chown != "" {
+		cmd = NewShellCommand(mkdirWithOwnerScript, path, getPermArg(mode.Mode), chown)
+	}
+	return ActionList{
+		DoMessageDebug(fmt.Sprintf("Making sure directory %q exists with mode %s", path, mode)),
+		DoExecCommand(cmd),
+	}
+}
+
+// DoMkdirOnceWithMode creates a remote directory with some mode (only once).
+func DoMkdirOnceWithMode(dir string, mode DirMode) Action {
+	return DoOnce(
+		fmt.Sprintf("%s-%s-%s", CacheRemoteDirExistsPrefix, dir, mode),
+		DoMkdirWithMode(dir, mode))
+}
+
 // CheckDirExists checks that a directory exists
 func CheckDirExists(path string) CheckerFunc {
-	return CheckExec(fmt.Sprintf("[ -d '%s' ]", path))
+	return CheckExecCommand(NewCommand("test", "-d", path))
+}
+
+// DoUploadDirToDir uploads a local directory (recursively) to a remote directory,
+// recreating the directories structure and preserving the permissions of files
+// and directories. Files are uploaded with DoUploadFileToFile (ie, to a temporary
+// file that is moved to the final destination). Symlinks and special files are ignored.
+func DoUploadDirToDir(local string, remote string) Action {
+	if local == "" {
+		return ActionError("empty local directory name to upload")
+	}
+	if remote == "" {
+		return ActionError("empty remote directory name to upload")
+	}
+
+	return ActionFunc(func(context.Context) Action {
+		// note: we must walk the local directory inside the ActionFunc, as
+		// the directory could be created by some previous action
+		actions := ActionList{}
+		err := filepath.Walk(local, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(local, p)
+			if err != nil {
+				return err
+			}
+			dst := path.Join(remote, filepath.ToSlash(rel))
+
+			switch {
+			case info.IsDir():
+				actions = append(actions, DoMkdirWithMode(dst, DirMode{Mode: info.Mode().Perm()}))
+			case info.Mode().IsRegular() && info.Size() == 0:
+				// (empty files cannot be uploaded)
+				actions = append(actions,
+					DoExecCommand(NewShellCommand(touchWithModeScript, dst, getPermArg(info.Mode()))),
+					DoInvalidateRemotePath(dst))
+			case info.Mode().IsRegular():
+				actions = append(actions,
+					DoUploadFileToFile(p, dst),
+					DoExecCommand(NewCommand("chmod", getPermArg(info.Mode()), dst)))
+			default:
+				Debug("ignoring %q: not a regular file or directory", p)
+			}
+			return nil
+		})
+		if err != nil {
+			return ActionError(fmt.Sprintf("could not upload local directory %q to %q: %s", local, remote, err))
+		}
+		return ActionList{
+			DoMessageInfo(fmt.Sprintf("Uploading directory %q to %q", local, remote)),
+			actions,
+		}
+	})
+}
+
+// DoDownloadDirToLocal downloads a remote directory (recursively) to a local directory.
+// The remote directory is archived in a temporary file, downloaded (encoded, so
+// it is binary-safe) and extracted locally while it is being downloaded.
+func DoDownloadDirToLocal(remote string, local string) Action {
+	if remote == "" {
+		return ActionError("empty remote directory name to download")
+	}
+	if local == "" {
+		return ActionError("empty local directory name to download to")
+	}
+
+	return DoWithTempFilename(func(remoteArchive string) Action {
+		return DoWithCleanup(
+			ActionList{
+				DoMessageInfo(fmt.Sprintf("Downloading directory %q to %q", remote, local)),
+				DoExecCommand(NewCommand("tar", "-czf", remoteArchive, "-C", remote, ".")),
+				ActionFunc(func(context.Context) Action {
+					if err := os.MkdirAll(local, 0755); err != nil {
+						return ActionError(fmt.Sprintf("could not create local directory %q: %s", local, err))
+					}
+					return DoDownloadBinaryFileToWriter(remoteArchive, newUntarWriteCloser(local))
+				}),
+			},
+			DoTry(DoDeleteFile(remoteArchive)))
+	})
 }
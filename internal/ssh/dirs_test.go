@@ -0,0 +1,144 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+type testRecordingCommunicator struct {
+	DummyCommunicator
+
+	commands *[]string
+}
+
+func (dc testRecordingCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	*dc.commands = append(*dc.commands, cmd.Command)
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func TestDoMkdirOnceWithMode(t *testing.T) {
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+
+	actions := ActionList{
+		DoMkdirOnceWithMode("/etc/kubernetes/pki", DefPrivateDirMode),
+		DoMkdirOnceWithMode("/etc/kubernetes/pki", DefPrivateDirMode),
+		DoMkdirOnceWithMode("/etc/kubernetes/pki/etcd", DirMode{Mode: 0700}),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	expected := []string{
+		`sh -c 'mkdir -p "$1" && chmod "$2" "$1" && chown "$3" "$1"' sh /etc/kubernetes/pki 0750 root:root`,
+		`sh -c 'mkdir -p "$1" && chmod "$2" "$1"' sh /etc/kubernetes/pki/etcd 0700`,
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("Error: unexpected command %q (expected %q)", commands[i], expected[i])
+		}
+	}
+}
+
+func TestDoMkdirWithModeEscalated(t *testing.T) {
+	commands := []string{}
+	ctx := WithValues(context.Background(), DummyOutput{}, DummyOutput{}, testRecordingCommunicator{commands: &commands}, true)
+
+	if res := DoMkdirWithMode("/etc/kubernetes/pki", DefPrivateDirMode).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	// the mkdir, chmod and chown must run in the same escalated shell
+	if len(commands) != 1 {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+	script := `sh -c 'mkdir -p "$1" && chmod "$2" "$1" && chown "$3" "$1"' sh /etc/kubernetes/pki 0750 root:root`
+	if !strings.HasPrefix(commands[0], "sudo ") || !strings.HasSuffix(commands[0], script) || strings.Count(commands[0], "sudo ") != 1 {
+		t.Fatalf("Error: the command is not escalated as a whole: %q", commands[0])
+	}
+}
+
+// testRecordingUploadsCommunicator records the commands while doing fake uploads
+type testRecordingUploadsCommunicator struct {
+	dummyCommunicatorWithResponses
+
+	commands *[]string
+}
+
+func (dc testRecordingUploadsCommunicator) Start(cmd *remote.Cmd) error {
+	*dc.commands = append(*dc.commands, cmd.Command)
+	return dc.dummyCommunicatorWithResponses.Start(cmd)
+}
+
+func TestDoUploadDirToDir(t *testing.T) {
+	local, err := ioutil.TempDir("", "upload-dir")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(local)
+
+	_ = os.MkdirAll(filepath.Join(local, "patches"), 0700)
+	_ = ioutil.WriteFile(filepath.Join(local, "policy.yaml"), []byte("policy"), 0644)
+	_ = ioutil.WriteFile(filepath.Join(local, "patches", "apiserver.yaml"), []byte("patch"), 0600)
+	_ = ioutil.WriteFile(filepath.Join(local, "patches", "empty"), []byte{}, 0600)
+
+	counter := 0
+	uploads := map[string]string{}
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+		&commands,
+	})
+
+	if res := (ActionList{DoUploadDirToDir(local, "/etc/kubernetes/extra")}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	contents := []string{}
+	for _, c := range uploads {
+		contents = append(contents, c)
+	}
+	sort.Strings(contents)
+	if strings.Join(contents, ",") != "patch,policy" {
+		t.Fatalf("Error: unexpected uploads: %+v", uploads)
+	}
+
+	all := strings.Join(commands, "\n")
+	for _, expected := range []string{
+		`sh /etc/kubernetes/extra/patches 0700`,
+		`mv -f "$1" "$2"' sh /tmp/`,
+		"chmod 0644 /etc/kubernetes/extra/policy.yaml",
+		"chmod 0600 /etc/kubernetes/extra/patches/apiserver.yaml",
+		`sh -c 'touch "$1" && chmod "$2" "$1"' sh /etc/kubernetes/extra/patches/empty 0600`,
+	} {
+		if !strings.Contains(all, expected) {
+			t.Fatalf("Error: %q not found in commands:\n%s", expected, all)
+		}
+	}
+}
@@ -18,15 +18,9 @@ import (
 	"bytes"
 	"context"
 	"errors"
-	"fmt"
 	"strings"
 )
 
-const (
-	// docker command for getting a container id
-	dockerGetContainer = "docker ps --filter name=^/%s -q"
-)
-
 var (
 	// ErrContainerNotFound is the container has not been found
 	ErrContainerNotFound = errors.New("container not found")
@@ -35,9 +29,9 @@ var (
 // GetContainer returns the ID of a container
 func GetContainer(ctx context.Context, pattern string) (string, error) {
 
-	cmd := fmt.Sprintf(dockerGetContainer, pattern)
+	cmd := NewCommand("docker", "ps", "--filter", "name=^/"+pattern, "-q")
 	var buf bytes.Buffer
-	if err := DoSendingExecOutputToWriter(DoExec(cmd), &buf).Apply(ctx); IsError(err) {
+	if err := DoSendingExecOutputToWriter(DoExecCommand(cmd), &buf).Apply(ctx); IsError(err) {
 		return "", err
 	}
 
@@ -62,10 +56,10 @@ func DoDockerExec(pattern string, command string) Action {
 		}
 
 		// build the full `docker exec` command to run
-		dockerCommand := fmt.Sprintf("docker exec -ti '%s' /bin/sh -c '%s'", cid, command)
+		dockerCommand := NewCommand("docker", "exec", "-ti", cid, "/bin/sh", "-c", command)
 
 		Debug("Running command in container %q: '%s'", cid, dockerCommand)
-		return DoExec(dockerCommand)
+		return DoExecCommand(dockerCommand)
 	})
 }
 
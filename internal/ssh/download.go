@@ -0,0 +1,223 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Terraform does not provide a mechanism for copying files from a remote host
+// to the local machine, so we run a remote command that dumps the file to stdout.
+// However, the communicator always runs the commands in a terminal (it requests a
+// PTY), where the output is mangled (ie, "\n" is translated to "\r\n") and the
+// input is not a clean channel, so no binary protocol (like the SCP sink) can be
+// used over it. Instead, the file is dumped encoded in base64 between some marks,
+// followed by its size and sha256 checksum, and the contents are verified after
+// decoding them. The marks cannot appear in base64 contents, and the base64
+// decoder ignores the "\r" and "\n" added by the terminal.
+
+const (
+	dumpMarkStart = "-- START --"
+
+	dumpMarkEnd = "-- END --"
+)
+
+// dumpScript prints the file in "$1" in base64 between the marks,
+// with the size and sha256 checksum of the file after the end mark
+var dumpScript = fmt.Sprintf(`f="$1" ; test -r "$f" || { echo "cannot read $f" >&2 ; exit 1 ; } ; `+
+	`echo '%s' && base64 < "$f" && echo '%s' $(wc -c < "$f") $(sha256sum < "$f" | cut -d' ' -f1)`,
+	dumpMarkStart, dumpMarkEnd)
+
+// verifyingWriter is a writer that counts the bytes and
+// computes the sha256 of the contents written on it
+type verifyingWriter struct {
+	dst  io.Writer
+	n    int64
+	hash hash.Hash
+}
+
+func newVerifyingWriter(dst io.Writer) *verifyingWriter {
+	return &verifyingWriter{dst: dst, hash: sha256.New()}
+}
+
+func (v *verifyingWriter) Write(p []byte) (int, error) {
+	n, err := v.dst.Write(p)
+	v.n += int64(n)
+	v.hash.Write(p[:n])
+	return n, err
+}
+
+// verify checks the bytes written against the expected size and checksum
+func (v *verifyingWriter) verify(size int64, checksum string) error {
+	if v.n != size {
+		return fmt.Errorf("size mismatch: %d bytes received, %d expected", v.n, size)
+	}
+	if sum := hex.EncodeToString(v.hash.Sum(nil)); sum != checksum {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", sum, checksum)
+	}
+	return nil
+}
+
+// parseEndMark parses the size and the checksum after the end mark
+func parseEndMark(s string) (int64, string, error) {
+	fields := strings.Fields(s[strings.Index(s, dumpMarkEnd)+len(dumpMarkEnd):])
+	if len(fields) != 2 {
+		return 0, "", fmt.Errorf("no size and checksum found after the end mark: %q", s)
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not parse size %q: %s", fields[0], err)
+	}
+	return size, fields[1], nil
+}
+
+// dumpSink receives the lines printed by the dumpScript, decoding the
+// contents (while they are received) to a writer
+type dumpSink struct {
+	sync.Mutex
+
+	insideBlock bool
+	endLine     string
+	extraOutput []string
+
+	encoded  *io.PipeWriter
+	verifier *verifyingWriter
+	decodeCh chan error
+}
+
+func newDumpSink(dst io.Writer) *dumpSink {
+	pr, pw := io.Pipe()
+	s := &dumpSink{
+		encoded:  pw,
+		verifier: newVerifyingWriter(dst),
+		decodeCh: make(chan error, 1),
+	}
+	go func() {
+		_, err := io.Copy(s.verifier, base64.NewDecoder(base64.StdEncoding, pr))
+		// (do not block the sink when we cannot decode or write anything else)
+		_ = pr.CloseWithError(err)
+		s.decodeCh <- err
+	}()
+	return s
+}
+
+// Output receives a line of output (from the stdout or the stderr)
+func (s *dumpSink) Output(line string) {
+	s.Lock()
+	defer s.Unlock()
+
+	line = strings.TrimSpace(line)
+	switch {
+	case s.endLine != "":
+		s.extraOutput = append(s.extraOutput, line)
+	case !s.insideBlock && strings.Contains(line, dumpMarkStart):
+		s.insideBlock = true
+	case s.insideBlock && strings.Contains(line, dumpMarkEnd):
+		s.insideBlock = false
+		s.endLine = line
+	case s.insideBlock:
+		// (errors are returned by finish())
+		_, _ = s.encoded.Write([]byte(line + "\n"))
+	default:
+		s.extraOutput = append(s.extraOutput, line)
+	}
+}
+
+// getExtraOutput returns the output received out of the marks (ie, errors)
+func (s *dumpSink) getExtraOutput() string {
+	s.Lock()
+	defer s.Unlock()
+	return strings.Join(s.extraOutput, " ")
+}
+
+// finish waits until all the contents have been decoded, verifying them
+// against the size and checksum received. It returns the number of bytes received.
+func (s *dumpSink) finish() (int64, error) {
+	_ = s.encoded.Close()
+	err := <-s.decodeCh
+
+	s.Lock()
+	defer s.Unlock()
+	if err != nil {
+		return s.verifier.n, fmt.Errorf("could not write the contents: %s", err)
+	}
+	if len(s.extraOutput) > 0 {
+		Debug("extra output when dumping a file: %s", strings.Join(s.extraOutput, "\n"))
+	}
+	if s.endLine == "" {
+		return s.verifier.n, fmt.Errorf("incomplete download: end mark not found after %d bytes", s.verifier.n)
+	}
+
+	size, checksum, err := parseEndMark(s.endLine)
+	if err != nil {
+		return s.verifier.n, err
+	}
+	if err := s.verifier.verify(size, checksum); err != nil {
+		return s.verifier.n, fmt.Errorf("incomplete download: %s", err)
+	}
+	return s.verifier.n, nil
+}
+
+// doDumpToWriter downloads a remote file to a writer, dumping it encoded
+// in base64 (so it is byte-accurate and it can be used for binary files)
+// and verifying the size and checksum of the contents received.
+func doDumpToWriter(remotePath string, contents io.WriteCloser) Action {
+	if remotePath == "" {
+		return ActionError("empty remote file name to download")
+	}
+
+	return ActionFunc(func(ctx context.Context) Action {
+		command := NewShellCommand(dumpScript, remotePath)
+
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "download %s", remotePath)
+			recordExecution(ctx, ExecutionEntry{Kind: ExecutionKindExec, Command: getEscalatedCommand(ctx, command.String())})
+			_ = contents.Close()
+			return nil
+		}
+
+		// (the contents are not recorded in the transcript, as some files contain secrets)
+		sink := newDumpSink(contents)
+		res := DoSendingExecOutputToFunc(DoExecCommand(command), sink.Output).Apply(WithTranscript(ctx, nil))
+		n, err := sink.finish()
+
+		// always close the writer, even on errors
+		closeErr := contents.Close()
+
+		switch {
+		case IsError(res):
+			if extra := sink.getExtraOutput(); extra != "" {
+				return ActionError(fmt.Sprintf("could not download %q: %s (%s)", remotePath, res, extra))
+			}
+			return ActionError(fmt.Sprintf("could not download %q: %s", remotePath, res))
+		case err != nil:
+			return ActionError(fmt.Sprintf("could not download %q: %s", remotePath, err))
+		case closeErr != nil:
+			return ActionError(fmt.Sprintf("could not write contents of %q: %s", remotePath, closeErr))
+		}
+
+		Debug("%q downloaded and verified: %d bytes", remotePath, n)
+		return nil
+	})
+}
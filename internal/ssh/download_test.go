@@ -0,0 +1,146 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+// testLocalShellCommunicator is a communicator that runs the commands in a local shell,
+// optionally in a real terminal (like the SSH communicator, that always requests a PTY)
+type testLocalShellCommunicator struct {
+	DummyCommunicator
+
+	pty bool
+}
+
+func (dc testLocalShellCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+
+	c := exec.Command("sh", "-c", cmd.Command)
+	if dc.pty {
+		c = exec.Command("script", "-qec", cmd.Command, "/dev/null")
+	}
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		err := c.Wait()
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			cmd.SetExitStatus(exitErr.ExitCode(), nil)
+			return
+		}
+		cmd.SetExitStatus(0, err)
+	}()
+	return nil
+}
+
+func TestDumpSink(t *testing.T) {
+	contents := "some contents\r\nwithout a trailing newline"
+	sum := sha256.Sum256([]byte(contents))
+	encoded := base64.StdEncoding.EncodeToString([]byte(contents))
+
+	// the output of a terminal, with a banner
+	var dst bytes.Buffer
+	sink := newDumpSink(&dst)
+	for _, line := range []string{"Welcome to Ubuntu 18.04\r", dumpMarkStart + "\r", encoded[:8] + "\r", encoded[8:] + "\r",
+		fmt.Sprintf("%s %d %s\r", dumpMarkEnd, len(contents), hex.EncodeToString(sum[:]))} {
+		sink.Output(line)
+	}
+	if n, err := sink.finish(); err != nil || n != int64(len(contents)) {
+		t.Fatalf("Error: %d bytes received: %v", n, err)
+	}
+	if dst.String() != contents {
+		t.Fatalf("Error: unexpected contents received: %q", dst.String())
+	}
+
+	for name, lines := range map[string][]string{
+		"truncated":         {dumpMarkStart, encoded[:8]},
+		"size mismatch":     {dumpMarkStart, encoded[:8], fmt.Sprintf("%s %d %s", dumpMarkEnd, len(contents), hex.EncodeToString(sum[:]))},
+		"checksum mismatch": {dumpMarkStart, encoded, fmt.Sprintf("%s %d %s", dumpMarkEnd, len(contents), hex.EncodeToString(sum[1:]))},
+		"no checksum":       {dumpMarkStart, encoded, fmt.Sprintf("%s %d", dumpMarkEnd, len(contents))},
+		"invalid contents":  {dumpMarkStart, "not base64!", fmt.Sprintf("%s %d %s", dumpMarkEnd, len(contents), hex.EncodeToString(sum[:]))},
+		"nothing":           {"cat: /tmp/something: No such file or directory"},
+	} {
+		sink := newDumpSink(ioutil.Discard)
+		for _, line := range lines {
+			sink.Output(line)
+		}
+		if _, err := sink.finish(); err == nil {
+			t.Fatalf("Error: %s: error not detected", name)
+		}
+	}
+}
+
+func TestDoDownloadWithPTY(t *testing.T) {
+	if _, err := exec.LookPath("script"); err != nil {
+		t.Skip("script(1) is not available for running commands in a terminal")
+	}
+
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	random := make([]byte, 256*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+
+	for i, contents := range [][]byte{
+		[]byte("apiVersion: v1\r\nkind: Config\r\n"),
+		[]byte(dumpMarkStart + "\n" + dumpMarkEnd + " 0 0\n"),
+		{0x1f, 0x8b, 0x00, 0xff, '\n', 0x03, 0x04, '\r'},
+		{},
+		random,
+	} {
+		remotePath := filepath.Join(dir, fmt.Sprintf("file-%d", i))
+		if err := ioutil.WriteFile(remotePath, contents, 0600); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+
+		for _, pty := range []bool{false, true} {
+			ctx := NewTestingContextWithCommunicator(testLocalShellCommunicator{pty: pty})
+			buf := testBufferCloser{}
+			if res := DoDownloadBinaryFileToWriter(remotePath, &buf).Apply(ctx); IsError(res) {
+				t.Fatalf("Error: when downloading %q (pty=%t): %s", remotePath, pty, res)
+			}
+			if !bytes.Equal(buf.Bytes(), contents) {
+				t.Fatalf("Error: contents of %q not preserved (pty=%t): %d bytes received, %d expected",
+					remotePath, pty, buf.Len(), len(contents))
+			}
+		}
+	}
+
+	// missing files are detected
+	ctx := NewTestingContextWithCommunicator(testLocalShellCommunicator{pty: true})
+	if res := DoDownloadBinaryFileToWriter(filepath.Join(dir, "missing"), &testBufferCloser{}).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: download of a missing file not detected")
+	}
+}
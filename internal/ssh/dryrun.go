@@ -0,0 +1,46 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+)
+
+// In the dry-run mode, all the actions are walked but the remote commands,
+// uploads and downloads (as well as local commands) are only printed (and
+// recorded in the execution manifest, if any). Commands produce no output, so
+// all the checks fail and the actions that depend on some remote output could
+// take a different path in a real run.
+
+// WithDryRun returns a new context where commands and transfers are not
+// really done, but just printed
+func WithDryRun(ctx context.Context) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.dryRun = true
+	})
+}
+
+// IsDryRun returns true if we are running in the dry-run mode
+func IsDryRun(ctx context.Context) bool {
+	return HasSSHContext(ctx) && getSSHContext(ctx).dryRun
+}
+
+// printDryRun prints something that would be done in a real run
+func printDryRun(ctx context.Context, format string, args ...interface{}) {
+	msg := fmt.Sprintf("[dry-run] "+format, args...)
+	Debug("%s", msg)
+	GetUserOutputFromContext(ctx).Output(msg)
+}
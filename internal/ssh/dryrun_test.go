@@ -0,0 +1,77 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+// testForbiddenCommunicator is a communicator that fails when used
+type testForbiddenCommunicator struct {
+	DummyCommunicator
+}
+
+func (testForbiddenCommunicator) Start(cmd *remote.Cmd) error {
+	return errors.New("command run in the dry-run mode: " + cmd.Command)
+}
+
+func (testForbiddenCommunicator) Upload(dst string, _ io.Reader) error {
+	return errors.New("file uploaded in the dry-run mode: " + dst)
+}
+
+func TestDryRun(t *testing.T) {
+	lines := []string{}
+	out := OutputFunc(func(s string) { lines = append(lines, s) })
+	ctx := WithDryRun(WithValues(context.Background(), out, out, testForbiddenCommunicator{}, true))
+
+	m := NewExecutionManifest(Host{Address: "10.0.0.1"})
+	ctx = WithExecutionManifest(ctx, m)
+
+	var buf testBufferCloser
+	actions := ActionList{
+		DoExec("systemctl restart kubelet"),
+		DoUploadBytesToFile([]byte("some config"), "/etc/kubernetes/kubeadm.conf"),
+		DoDownloadFileToWriter("/etc/kubernetes/admin.conf", &buf),
+		DoRetry(Retry{Times: 3}, ActionError("an error")),
+	}
+	if res := actions.Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: unexpected result: %v", res)
+	} else if strings.Contains(res.Error(), "dry-run mode") {
+		t.Fatalf("Error: something done in the dry-run mode: %s", res)
+	}
+
+	printed := strings.Join(lines, "\n")
+	for _, expected := range []string{
+		"[dry-run] run: sudo " + sudoArgs + " systemctl restart kubelet",
+		"[dry-run] upload 11 bytes to ",
+		"/etc/kubernetes/kubeadm.conf",
+		"[dry-run] download /etc/kubernetes/admin.conf",
+	} {
+		if !strings.Contains(printed, expected) {
+			t.Fatalf("Error: %q not printed in the dry-run mode:\n%s", expected, printed)
+		}
+	}
+
+	// the commands and uploads are recorded in the execution manifest
+	if len(m.Entries) == 0 {
+		t.Fatalf("Error: nothing recorded in the execution manifest")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// DefExecLocale is the default locale for the commands executed in the host,
+	// so their output can be parsed
+	DefExecLocale = "C"
+
+	// DefExecPath is the default (minimal) PATH for the commands executed in the host
+	DefExecPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin:/opt/bin"
+)
+
+// ExecEnv is the environment pinned for all the commands executed in the host
+type ExecEnv struct {
+	// Locale is used for LANG and LC_ALL (not changed when empty)
+	Locale string
+
+	// Path is the PATH (not changed when empty)
+	Path string
+}
+
+// WithExecEnv returns a new context where all the commands are executed
+// with a pinned environment
+func WithExecEnv(ctx context.Context, env ExecEnv) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.execEnv = &env
+	})
+}
+
+// getCommandWithEnv returns the command prefixed with the export of the
+// pinned environment (if any)
+func getCommandWithEnv(ctx context.Context, command string) string {
+	env := getSSHContext(ctx).execEnv
+	if env == nil {
+		return command
+	}
+
+	vars := []string{}
+	if env.Locale != "" {
+		vars = append(vars, "LANG="+shellQuote(env.Locale), "LC_ALL="+shellQuote(env.Locale))
+	}
+	if env.Path != "" {
+		vars = append(vars, "PATH="+shellQuote(env.Path))
+	}
+	if len(vars) == 0 {
+		return command
+	}
+	return "export " + strings.Join(vars, " ") + " ; " + command
+}
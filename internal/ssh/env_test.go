@@ -0,0 +1,55 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestExecEnv(t *testing.T) {
+	testCases := []struct {
+		env      ExecEnv
+		expected string
+	}{
+		{ExecEnv{Locale: DefExecLocale, Path: DefExecPath}, "C:C:" + DefExecPath},
+		// the PATH is not changed
+		{ExecEnv{Locale: "en_US.UTF-8"}, "en_US.UTF-8:en_US.UTF-8:" + os.Getenv("PATH")},
+	}
+
+	for i, testCase := range testCases {
+		commands := []string{}
+		ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+		ctx = WithExecEnv(ctx, testCase.env)
+
+		if res := DoExec(`echo "$LANG:$LC_ALL:$PATH"`).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: test case %d: when running actions: %s", i, res)
+		}
+		if len(commands) != 1 {
+			t.Fatalf("Error: test case %d: unexpected commands: %q", i, commands)
+		}
+
+		// run the command in a real shell
+		output, err := exec.Command("sh", "-c", commands[0]).CombinedOutput()
+		if err != nil {
+			t.Fatalf("Error: test case %d: %v: %s", i, err, output)
+		}
+		if res := strings.TrimSpace(string(output)); res != testCase.expected {
+			t.Fatalf("Error: test case %d: unexpected environment: %q, expected: %q", i, res, testCase.expected)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// kinds of entries in the execution manifest
+const (
+	ExecutionKindExec   = "exec"
+	ExecutionKindUpload = "upload"
+)
+
+// ExecutionEntry is a command or file upload performed in a host
+type ExecutionEntry struct {
+	Kind    string `json:"kind"`
+	Command string `json:"command,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Size    int    `json:"size,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// Contents are the contents uploaded (only when recording contents)
+	Contents []byte `json:"contents,omitempty"`
+}
+
+// ExecutionManifest is the list of all the commands and files
+// executed/uploaded in a host, in order
+type ExecutionManifest struct {
+	sync.Mutex
+
+	Host     string           `json:"host"`
+	Role     string           `json:"role,omitempty"`
+	Started  time.Time        `json:"started"`
+	Finished time.Time        `json:"finished"`
+	Entries  []ExecutionEntry `json:"entries"`
+
+	// RecordContents enables recording the contents of the files uploaded,
+	// so the manifest can be replayed
+	RecordContents bool `json:"-"`
+}
+
+// NewExecutionManifest creates a new execution manifest for a host
+func NewExecutionManifest(host Host) *ExecutionManifest {
+	return &ExecutionManifest{
+		Host:    host.Address,
+		Role:    host.Role,
+		Started: time.Now().UTC(),
+		Entries: []ExecutionEntry{},
+	}
+}
+
+// Record adds an entry to the manifest
+func (m *ExecutionManifest) Record(entry ExecutionEntry) {
+	m.Lock()
+	defer m.Unlock()
+	m.Entries = append(m.Entries, entry)
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Filename returns a (safe) file name for this manifest
+func (m *ExecutionManifest) Filename() string {
+	return unsafeFilenameChars.ReplaceAllString(m.Host, "_") + ".json"
+}
+
+// WriteToDir writes the manifest (in JSON) to a file in a local directory
+func (m *ExecutionManifest) WriteToDir(dir string) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.Finished = time.Now().UTC()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, m.Filename())
+	return filename, ioutil.WriteFile(filename, data, 0600)
+}
+
+// WithExecutionManifest returns a new context where all the commands
+// and uploads are recorded in a manifest
+func WithExecutionManifest(ctx context.Context, m *ExecutionManifest) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.execManifest = m
+	})
+}
+
+// recordExecution records an entry in the execution manifest (if any)
+func recordExecution(ctx context.Context, entry ExecutionEntry) {
+	if m := getSSHContext(ctx).execManifest; m != nil {
+		m.Record(entry)
+	}
+}
+
+// recordUpload records an upload in the execution manifest (if any)
+func recordUpload(ctx context.Context, dst string, contents []byte, err error) {
+	m := getSSHContext(ctx).execManifest
+	if m == nil {
+		return
+	}
+	sum := sha256.Sum256(contents)
+	entry := ExecutionEntry{Kind: ExecutionKindUpload, Path: dst, Size: len(contents), SHA256: hex.EncodeToString(sum[:])}
+	if m.RecordContents {
+		entry.Contents = contents
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	m.Record(entry)
+}
+
+// recordStreamedUpload records an upload of a local file that has been streamed
+// (the contents are never recorded, as they are not loaded in memory)
+func recordStreamedUpload(ctx context.Context, dst string, size int64, sum string, err error) {
+	m := getSSHContext(ctx).execManifest
+	if m == nil {
+		return
+	}
+	entry := ExecutionEntry{Kind: ExecutionKindUpload, Path: dst, Size: int(size), SHA256: sum}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	m.Record(entry)
+}
+
+// LoadExecutionManifest loads an execution manifest from a file
+func LoadExecutionManifest(filename string) (*ExecutionManifest, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &ExecutionManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("could not parse execution manifest %q: %s", filename, err)
+	}
+	return m, nil
+}
+
+// DoReplayExecutionManifest runs all the commands and uploads in an execution manifest,
+// in the same order. Entries that failed originally are allowed to fail.
+// Note that commands are recorded with the "sudo" prefix (if it was used), so the
+// context should not use "sudo".
+func DoReplayExecutionManifest(m *ExecutionManifest) Action {
+	actions := ActionList{}
+	for i, entry := range m.Entries {
+		var action Action
+		switch entry.Kind {
+		case ExecutionKindExec:
+			action = DoExec(entry.Command) // rawcmd: the command recorded
+		case ExecutionKindUpload:
+			if entry.Contents == nil {
+				return ActionError(fmt.Sprintf("no contents recorded for the upload of %q (entry %d): the manifest cannot be replayed", entry.Path, i))
+			}
+			action = doRawUploadFile(entry.Contents, entry.Path)
+		default:
+			return ActionError(fmt.Sprintf("unknown kind %q in entry %d", entry.Kind, i))
+		}
+
+		if entry.Error != "" {
+			action = DoTry(action)
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExecutionManifest(t *testing.T) {
+	commands := []string{}
+	m := NewExecutionManifest(Host{Address: "[fd00::1]:22", Role: "master"})
+	ctx := WithExecutionManifest(NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands}), m)
+
+	actions := ActionList{
+		DoExec("echo hello"),
+		doRawUploadFile([]byte("some contents"), "/tmp/some-file"),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	if len(m.Entries) == 0 || m.Entries[0].Kind != ExecutionKindExec || m.Entries[0].Command != "echo hello" {
+		t.Fatalf("Error: unexpected entries: %+v", m.Entries)
+	}
+	last := m.Entries[len(m.Entries)-1]
+	if last.Kind != ExecutionKindUpload || last.Path != "/tmp/some-file" || last.Size != 13 ||
+		last.SHA256 != "b9e6fc6474139fd230ff8a7a9699484c015cb585e1537efad21ae5edf7f79832" {
+		t.Fatalf("Error: unexpected upload entry: %+v", last)
+	}
+
+	dir, err := ioutil.TempDir("", "execmanifest")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename, err := m.WriteToDir(dir)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if filepath.Base(filename) != "_fd00_1_22.json" {
+		t.Fatalf("Error: unexpected file name: %q", filename)
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	loaded := ExecutionManifest{}
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if loaded.Host != "[fd00::1]:22" || loaded.Role != "master" || len(loaded.Entries) != len(m.Entries) {
+		t.Fatalf("Error: unexpected manifest loaded: %+v", &loaded)
+	}
+}
+
+func TestReplayExecutionManifest(t *testing.T) {
+	m := &ExecutionManifest{
+		Host: "10.0.0.1:22",
+		Entries: []ExecutionEntry{
+			{Kind: ExecutionKindExec, Command: "sudo --non-interactive -E echo hello"},
+			{Kind: ExecutionKindUpload, Path: "/tmp/some-file", Contents: []byte("some contents")},
+			{Kind: ExecutionKindExec, Command: "false", Error: "some error"},
+		},
+	}
+
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+	if res := DoReplayExecutionManifest(m).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when replaying the manifest: %s", res)
+	}
+	if len(commands) != 2 || commands[0] != m.Entries[0].Command || commands[1] != "false" {
+		t.Fatalf("Error: unexpected commands replayed: %v", commands)
+	}
+
+	// uploads without contents cannot be replayed
+	m.Entries[1].Contents = nil
+	if res := DoReplayExecutionManifest(m).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: replaying a manifest without contents should fail")
+	}
+}
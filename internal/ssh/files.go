@@ -16,8 +16,10 @@ package ssh
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -25,36 +27,129 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 const (
-	defMaxPathLength = 4096
-
 	defTemporaryFilenamePrefix = "tmpfile"
 
 	defTemporaryFilenameExt = "tmp"
 
 	defaultRemoteTmp = "/tmp"
 
-	markStart = "-- START --"
+	// files bigger than this are compressed before being uploaded
+	defUploadCompressThreshold = 256 * 1024
+
+	// local files bigger than this are streamed to the remote host, without
+	// loading them in memory (ie, the kubeadm/kubelet/kubectl binaries)
+	defUploadStreamThreshold = 4 * 1024 * 1024
+
+	// files with more trailing zeros than this are uploaded without them, and then
+	// extended (with `truncate`) to their real size, so preallocated files are sparse
+	defUploadSparseThreshold = 64 * 1024
+
+	// createEmptyFileScript creates (or truncates) an empty file
+	createEmptyFileScript = `: > "$1"`
+
+	// setModeScript sets the permissions ($2) of a file ($1), and the owner ($3) when not empty
+	setModeScript = `chmod "$2" "$1" && { [ -z "$3" ] || chown "$3" "$1" ; }`
+
+	// moveScript moves a file ($1) to its destination ($2), creating the directory ($3)
+	moveScript = `mkdir -p "$3" && mv -f "$1" "$2"`
+
+	// moveWithModeScript sets the permissions ($4) and owner ($5, when not empty) of a file ($1)
+	// and moves it to its destination ($2), creating the directory ($3)
+	moveWithModeScript = `chmod "$4" "$1" && { [ -z "$5" ] || chown "$5" "$1" ; } && mkdir -p "$3" && mv -f "$1" "$2"`
+
+	// backupScript copies a file ($1) to a backup ($2), if the file exists
+	backupScript = `[ ! -f "$1" ] || cp -a "$1" "$2"`
+
+	// appendLinesScript appends the lines ($2...) missing in a file ($1), adding the
+	// missing newline at the end of the file first
+	appendLinesScript = `f="$1" ; shift ; [ ! -s "$f" ] || [ -z "$(tail -c1 "$f")" ] || echo >> "$f" ; ` +
+		`for l in "$@" ; do grep -qxF -- "$l" "$f" 2>/dev/null || printf "%s\n" "$l" >> "$f" ; done`
+
+	// readIfExistsScript prints a file ($1), or nothing if it does not exist
+	readIfExistsScript = `cat "$1" 2>/dev/null || true`
+
+	// extendFileScript extends a file to some size, with a `dd` fallback
+	// for systems where `truncate` is not available
+	extendFileScript = `truncate -s "$2" "$1" 2>/dev/null || dd if=/dev/null of="$1" bs=1 seek="$2" 2>/dev/null`
+
+	// cache key for the availability of `gunzip` in the remote host
+	cacheRemoteGunzipKey = "remote-gunzip"
+
+	// suffix for the backups of remote files (followed by a timestamp)
+	defBackupSuffix = ".bak-"
+
+	// layout of the timestamp in the backups of remote files
+	defBackupTimestampLayout = "20060102-150405"
+
+	// remote file where the leftovers are recorded
+	defLeftoversPath = "/var/lib/terraform-kubeadm/leftovers"
+)
+
+// FileMode is the permissions and ownership for a remote file
+type FileMode DirMode
+
+var (
+	// uploadRetry is used for retrying failed uploads (ie, on network blips)
+	uploadRetry = Retry{Times: 3, Interval: 2 * time.Second, Backoff: 2}
+
+	// DefFileMode is the mode for uploaded files (the owner is not changed)
+	DefFileMode = FileMode{Mode: 0644}
+
+	// DefPrivateFileMode is the mode for private files (ie, the PKI keys)
+	DefPrivateFileMode = FileMode{Mode: 0600, Owner: "root", Group: "root"}
 
-	markEnd = "-- END --"
+	// DefPublicFileMode is the mode for public files owned by root (ie, the PKI certificates)
+	DefPublicFileMode = FileMode{Mode: 0644, Owner: "root", Group: "root"}
 )
 
+// String returns a string representation of the FileMode
+func (m FileMode) String() string {
+	return DirMode(m).String()
+}
+
+// hasOwner returns true if the owner (or group) of the file must be changed
+func (m FileMode) hasOwner() bool {
+	return DirMode(m).getChownArg() != ""
+}
+
+// getSetModeCommand returns the command for setting the mode (and owner) of a file
+func (m FileMode) getSetModeCommand(path string) Command {
+	return NewShellCommand(setModeScript, path, getPermArg(m.Mode), DirMode(m).getChownArg())
+}
+
 // LocalFileExists reports whether the named file or directory exists.
+// The maximum length of the name depends on the OS, so names that are too long
+// for this OS (ie, that cannot exist) are not rejected in advance.
 func LocalFileExists(name string) bool {
-	if len(name) > defMaxPathLength {
+	if name == "" {
 		return false
 	}
-	if _, err := os.Stat(name); err != nil {
-		if os.IsNotExist(err) {
-			return false
-		}
+	_, err := os.Stat(name)
+	switch {
+	case err == nil:
+		return true
+	case os.IsNotExist(err), isNameTooLong(err):
+		return false
 	}
+	// (something exists there, even if we cannot access it)
 	return true
 }
 
+// isNameTooLong returns true if the error is for a path that is too long for this OS
+func isNameTooLong(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.ENAMETOOLONG
+}
+
 func randBytes(length int) (string, error) {
 	b := make([]byte, length)
 	_, err := rand.Read(b)
@@ -64,8 +159,8 @@ func randBytes(length int) (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-// randomPath gets a random Path
-func randomPath(prefix, extension string) (string, error) {
+// randomPath gets a random Path in a directory
+func randomPath(dir, prefix, extension string) (string, error) {
 	r, err := randBytes(3)
 	if err != nil {
 		return "", err
@@ -73,24 +168,67 @@ func randomPath(prefix, extension string) (string, error) {
 	if len(prefix) == 0 || len(extension) == 0 {
 		return "", fmt.Errorf("can not use empty Prefix or extension")
 	}
-	return fmt.Sprintf("%s/%s-%s.%s", defaultRemoteTmp, prefix, r, extension), nil
+	return fmt.Sprintf("%s/%s-%s.%s", strings.TrimSuffix(dir, "/"), prefix, r, extension), nil
+}
+
+// GetRemoteTmpFromContext returns the remote directory for temporary files
+func GetRemoteTmpFromContext(ctx context.Context) string {
+	if HasSSHContext(ctx) {
+		if dir := getSSHContext(ctx).remoteTmp; dir != "" {
+			return dir
+		}
+	}
+	return defaultRemoteTmp
 }
 
-// GetTempFilename returns a temporary filename (but it does not create it)
-func GetTempFilename() (string, error) {
-	return randomPath(defTemporaryFilenamePrefix, defTemporaryFilenameExt)
+// GetTempFilename returns a temporary filename in the remote
+// temporary directory (but it does not create it)
+func GetTempFilename(ctx context.Context) (string, error) {
+	return randomPath(GetRemoteTmpFromContext(ctx), defTemporaryFilenamePrefix, defTemporaryFilenameExt)
 }
 
-// IsTempFilename returns true if it is a temporary filename
-func IsTempFilename(filename string) bool {
-	base := path.Base(filename)
-	if !strings.HasPrefix(base, defTemporaryFilenamePrefix) {
-		return false
+// GetRemoteTempFile creates an empty temporary file in the remote temporary
+// directory with `mktemp`, returning its path. The file is created atomically,
+// with an unpredictable name and only accessible by the SSH user, so other users
+// in the host cannot replace it (ie, with a symlink) before we use it.
+// A (client-side) random filename is returned when `mktemp` cannot be used.
+func GetRemoteTempFile(ctx context.Context) (string, error) {
+	template := fmt.Sprintf("%s/%s-XXXXXX", strings.TrimSuffix(GetRemoteTmpFromContext(ctx), "/"), defTemporaryFilenamePrefix)
+
+	// the file is created by the SSH user (not with sudo), as it is the one doing the uploads
+	var buf bytes.Buffer
+	res := DoSendingExecOutputToWriter(DoExecCommand(NewCommand("mktemp", template)), &buf).Apply(withUseSudo(ctx, false))
+	if IsError(res) {
+		Debug("could not create a temporary file with mktemp: %s", res)
+		return GetTempFilename(ctx)
 	}
-	if !strings.HasSuffix(base, defTemporaryFilenameExt) {
-		return false
+
+	path := strings.TrimSpace(buf.String())
+	if !IsTempFilename(path) {
+		Debug("unexpected temporary file created by mktemp: %q", path)
+		return GetTempFilename(ctx)
+	}
+	return path, nil
+}
+
+// DoWithTempFilename runs the action returned by `f` for a new temporary file
+// created in the remote host (see GetRemoteTempFile())
+func DoWithTempFilename(f func(string) Action) Action {
+	return ActionList{
+		ActionFunc(func(ctx context.Context) Action {
+			path, err := GetRemoteTempFile(ctx)
+			if err != nil {
+				return ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
+			}
+			return f(path)
+		}),
 	}
-	return true
+}
+
+// IsTempFilename returns true if it is a temporary filename
+// (created with GetTempFilename() or GetRemoteTempFile())
+func IsTempFilename(filename string) bool {
+	return strings.HasPrefix(path.Base(filename), defTemporaryFilenamePrefix+"-")
 }
 
 // doRealUploadFile uploads a file to a remote path
@@ -99,67 +237,331 @@ func doRealUploadFile(contents []byte, dst string) Action {
 		return DoAbort("empty destination for upload")
 	}
 
-	dstDir := filepath.Dir(dst)
+	dstDir := path.Dir(dst)
 
 	actions := ActionList{
 		DoMkdirOnce(dstDir),
 		DoMessageDebug(fmt.Sprintf("Making sure '%s' does not exist", dst)),
 		DoDeleteFile(dst),
-		ActionFunc(func(ctx context.Context) Action {
-			if len(contents) == 0 {
-				return ActionError(fmt.Sprintf("internal error: empty file to upload to %q", dst))
-			}
+		doUploadToFile(contents, dst),
+	}
+
+	return actions
+}
+
+// doUploadToFile uploads some contents to a remote path, overwriting the
+// file when it exists (ie, a temporary file created with GetRemoteTempFile())
+func doUploadToFile(contents []byte, dst string) Action {
+	return DoRetry(uploadRetry,
+		getUploadAction(contents, dst),
+		DoInvalidateRemotePath(dst),
+		doVerifyRemoteChecksum(contents, dst))
+}
+
+// getUploadAction returns the best action for uploading some contents: big files
+// are compressed and trailing zeros are not uploaded at all
+func getUploadAction(contents []byte, dst string) Action {
+	if zeros := countTrailingZeros(contents); zeros >= defUploadSparseThreshold {
+		return doSparseUploadFile(contents[:len(contents)-zeros], len(contents), dst)
+	}
+	if len(contents) >= defUploadCompressThreshold {
+		return doCompressedUploadFile(contents, dst)
+	}
+	return doRawUploadFile(contents, dst)
+}
 
-			c := bytes.NewReader(contents)
-			comm := GetCommFromContext(ctx)
+// countTrailingZeros returns the number of zero bytes at the end of some contents
+func countTrailingZeros(contents []byte) int {
+	n := 0
+	for i := len(contents) - 1; i >= 0 && contents[i] == 0; i-- {
+		n++
+	}
+	return n
+}
+
+// doSparseUploadFile uploads some `data` and then extends the file
+// to `size` bytes (filled with zeros, but without using disk space)
+func doSparseUploadFile(data []byte, size int, dst string) Action {
+	return ActionList{
+		DoMessageDebug(fmt.Sprintf("Uploading %q as a sparse file (%d -> %d bytes)", dst, size, len(data))),
+		getUploadAction(data, dst),
+		DoExecCommand(NewShellCommand(extendFileScript, dst, strconv.Itoa(size))),
+	}
+}
+
+// doCompressedUploadFile uploads some contents compressed with gzip, uncompressing
+// them in the remote host with `gunzip`. The contents are uploaded uncompressed
+// when `gunzip` is not available or when compression does not reduce the size.
+func doCompressedUploadFile(contents []byte, dst string) Action {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(contents)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil || buf.Len() >= len(contents) {
+		return doRawUploadFile(contents, dst)
+	}
+
+	compressed := buf.Bytes()
+	dstGz := dst + ".gz"
+	return DoIfElse(
+		CheckOnce(cacheRemoteGunzipKey, CheckBinaryExists("gunzip")),
+		DoWithException(
+			ActionList{
+				DoMessageDebug(fmt.Sprintf("Uploading %q compressed (%d -> %d bytes)", dst, len(contents), len(compressed))),
+				doRawUploadFile(compressed, dstGz),
+				DoExecCommand(NewCommand("gunzip", "-f", dstGz)),
+			},
+			DoTry(DoDeleteFile(dstGz))),
+		doRawUploadFile(contents, dst))
+}
+
+// getChecksum returns the (hex-encoded) sha256 checksum of some contents
+func getChecksum(contents []byte) string {
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:])
+}
 
-			Debug("Doing the real upload to %s:\n%s\n", dst, contents)
-			if err := comm.Upload(dst, c); err != nil {
-				Debug("ERROR: upload failed: %s", err)
-				return ActionError(err.Error())
+// getRemoteChecksum runs `sha256sum` in the remote host, returning the checksum of a file
+func getRemoteChecksum(ctx context.Context, path string) (string, Action) {
+	remoteSum := ""
+	res := DoSendingExecOutputToFunc(
+		DoExecCommand(NewCommand("sha256sum", path)),
+		func(s string) {
+			if fields := strings.Fields(s); remoteSum == "" && len(fields) > 0 {
+				remoteSum = fields[0]
 			}
+		}).Apply(ctx)
+	return remoteSum, res
+}
+
+// doVerifyRemoteChecksum checks that the sha256 checksum of a remote
+// file matches the checksum of the contents uploaded
+func doVerifyRemoteChecksum(contents []byte, dst string) Action {
+	return doVerifyRemoteChecksumIs(getChecksum(contents), dst)
+}
 
+// doVerifyRemoteChecksumIs checks that the sha256 checksum of a remote file is `expected`
+func doVerifyRemoteChecksumIs(expected string, dst string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
 			return nil
-		}),
-	}
+		}
 
-	return actions
+		remoteSum, res := getRemoteChecksum(ctx, dst)
+		if IsError(res) {
+			return ActionError(fmt.Sprintf("could not verify the upload of %q: %s", dst, res.Error()))
+		}
+		if remoteSum != expected {
+			return ActionError(fmt.Sprintf("checksum mismatch after uploading %q: got %q, expected %q", dst, remoteSum, expected))
+		}
+		Debug("upload of %q verified: sha256 %s", dst, expected)
+		return nil
+	})
+}
+
+// doRawUploadFile uploads some contents to a remote path with the communicator
+func doRawUploadFile(contents []byte, dst string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		// the communicator cannot upload empty files: just create the file
+		if len(contents) == 0 {
+			return DoExecCommand(NewShellCommand(createEmptyFileScript, dst))
+		}
+
+		comm := GetCommFromContext(ctx)
+		if comm == nil {
+			return ActionError(fmt.Sprintf("no communicator available for uploading %q", dst))
+		}
+
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "upload %d bytes to %s", len(contents), dst)
+			recordUpload(ctx, dst, contents, nil)
+			return nil
+		}
+
+		Debug("Doing the real upload to %s (%d bytes)", dst, len(contents))
+		upload := func() error { return comm.Upload(dst, bytes.NewReader(contents)) }
+		err := withPacing(ctx, "upload", upload)
+		recordUpload(ctx, dst, contents, err)
+		if err != nil {
+			Debug("ERROR: upload failed: %s", err)
+			return ActionError(err.Error())
+		}
+		return nil
+	})
 }
 
-// DoUploadBytesToFile uploads a file to a remote path, using a temporary file in /tmp
+// DoUploadBytesToFile uploads a file to a remote path, using a temporary file
+// (in the remote temporary directory)
 // and then moving it to the final destination with `sudo`.
 // It is important to use a temporary file as uploads are performed as a regular
 // user, while the `mv` is done with `sudo`
 func DoUploadBytesToFile(contents []byte, dst string) Action {
+	return DoUploadBytesToFileWithMode(contents, dst, DefFileMode)
+}
+
+// DoUploadBytesToFileWithMode is like DoUploadBytesToFile, but the permissions and
+// owner of the file are set before moving it to the final destination, so the file
+// never exists there with other permissions (ie, for private keys)
+func DoUploadBytesToFileWithMode(contents []byte, dst string, mode FileMode) Action {
 	if len(dst) == 0 {
 		return ActionError(fmt.Sprintf("internal error: empty remote path in DoUploadBytesToFile()"))
 	}
 
 	// do not create temporary files for files that are already in the remote temporary directory
+	// (note: they are not removed before uploading, as they can be files created with `mktemp`)
 	if IsTempFilename(dst) {
-		return doRealUploadFile(contents, dst)
+		return doUploadToFile(contents, dst)
 	}
 
 	// for regular files, upload to a temp file and then move the temp file to the final destination
 	// (uploading directly to destination could need root permissions, while we can "mv" with "sudo")
-	dstTmpPath, err := GetTempFilename()
-	if err != nil {
-		return ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
+	return DoWithTempFilename(func(dstTmpPath string) Action {
+		return DoWithCleanup(ActionList{
+			DoMessageInfo(fmt.Sprintf("Uploading to %q", dst)),
+			DoMessageDebug(fmt.Sprintf("Uploading to temporary file %q", dstTmpPath)),
+			doUploadToFile(contents, dstTmpPath),
+			DoMessageDebug(fmt.Sprintf("... and moving to final destination %s with mode %s", dst, mode)),
+			DoMoveFileWithMode(dstTmpPath, dst, mode),
+		}, ActionList{
+			DoTry(DoDeleteFile(dstTmpPath)),
+		})
+	})
+}
+
+// DoUploadBytesToFileIfChanged is like DoUploadBytesToFile, but the upload is skipped
+// when the remote file already has the same contents (ie, same sha256 checksum).
+// The remote checksum is cached, so it is only obtained once.
+func DoUploadBytesToFileIfChanged(contents []byte, dst string) Action {
+	if len(dst) == 0 {
+		return ActionError(fmt.Sprintf("internal error: empty remote path in DoUploadBytesToFileIfChanged()"))
+	}
+
+	sum := getChecksum(contents)
+	return DoIfElse(
+		CheckFileChecksum(dst, sum),
+		DoMessageDebug(fmt.Sprintf("%q has not changed: skipping upload", dst)),
+		ActionList{
+			DoUploadBytesToFile(contents, dst),
+			DoSetInCache(CacheRemoteFileChecksumPrefix+"-"+dst, sum),
+		})
+}
+
+// DoUploadBytesToFileWithModeIfChanged is like DoUploadBytesToFileWithMode, but the upload
+// is skipped when the remote file already has the same contents (the permissions and
+// owner are set anyway)
+func DoUploadBytesToFileWithModeIfChanged(contents []byte, dst string, mode FileMode) Action {
+	if len(dst) == 0 {
+		return ActionError(fmt.Sprintf("internal error: empty remote path in DoUploadBytesToFileWithModeIfChanged()"))
 	}
 
-	return DoWithCleanup(ActionList{
-		DoMessageInfo(fmt.Sprintf("Uploading to %q", dst)),
-		DoMessageDebug(fmt.Sprintf("Uploading to temporary file %q", dstTmpPath)),
-		doRealUploadFile(contents, dstTmpPath),
-		DoMessageDebug(fmt.Sprintf("... and moving to final destination %s", dst)),
-		DoMoveFile(dstTmpPath, dst),
-	}, ActionList{
-		DoTry(DoDeleteFile(dstTmpPath)),
+	sum := getChecksum(contents)
+	return DoIfElse(
+		CheckFileChecksum(dst, sum),
+		ActionList{
+			DoMessageDebug(fmt.Sprintf("%q has not changed: skipping upload", dst)),
+			DoSetFileMode(dst, mode),
+		},
+		ActionList{
+			DoUploadBytesToFileWithMode(contents, dst, mode),
+			DoSetInCache(CacheRemoteFileChecksumPrefix+"-"+dst, sum),
+		})
+}
+
+// getBackupFilename returns the name for a backup of a remote file
+func getBackupFilename(path string, now time.Time) string {
+	return path + defBackupSuffix + now.UTC().Format(defBackupTimestampLayout)
+}
+
+// DoBackupFile saves a copy of a remote file (if it exists) as `<path>.bak-<timestamp>`,
+// so the previous version can be recovered manually if something goes wrong
+func DoBackupFile(path string) Action {
+	return ActionFunc(func(context.Context) Action {
+		backup := getBackupFilename(path, time.Now())
+		return ActionList{
+			DoMessageDebug(fmt.Sprintf("Saving a backup of %q (if it exists) as %q", path, backup)),
+			DoExecCommand(NewShellCommand(backupScript, path, backup)),
+		}
 	})
 }
 
+// DoUploadBytesToFileWithBackupIfChanged is like DoUploadBytesToFileIfChanged, but the
+// current file is saved with DoBackupFile() before being replaced
+func DoUploadBytesToFileWithBackupIfChanged(contents []byte, dst string) Action {
+	if len(dst) == 0 {
+		return ActionError(fmt.Sprintf("internal error: empty remote path in DoUploadBytesToFileWithBackupIfChanged()"))
+	}
+
+	sum := getChecksum(contents)
+	return DoIfElse(
+		CheckFileChecksum(dst, sum),
+		DoMessageDebug(fmt.Sprintf("%q has not changed: skipping upload", dst)),
+		ActionList{
+			DoBackupFile(dst),
+			DoUploadBytesToFile(contents, dst),
+			DoSetInCache(CacheRemoteFileChecksumPrefix+"-"+dst, sum),
+		})
+}
+
+// DoUploadTemplate renders a text/template with some `data` (ie, `{{.nodename}}`)
+// and uploads the result to a remote file with DoUploadBytesToFile
+func DoUploadTemplate(text string, data map[string]interface{}, dst string) Action {
+	contents, err := ReplaceInTemplate(text, data)
+	if err != nil {
+		return ActionError(fmt.Sprintf("could not render the template for %q: %s", dst, err))
+	}
+	return DoUploadBytesToFile([]byte(contents), dst)
+}
+
+// DoAppendToFile appends some lines to a remote file (creating the file if it does not exist),
+// unless the file already contains all these lines: only the missing lines are appended.
+// A line break is added before the new lines when the file does not end with one.
+func DoAppendToFile(path string, text string) Action {
+	textLines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	lines := []Checker{}
+	for _, line := range textLines {
+		lines = append(lines, CheckFileContainsLine(path, line))
+	}
+
+	// note: the path and the lines are passed as arguments, so they do not need any escaping
+	command := NewShellCommand(appendLinesScript, append([]string{path}, textLines...)...)
+
+	return DoIfElse(
+		CheckAnd(lines...),
+		DoMessageDebug(fmt.Sprintf("%q already contains the lines: skipping", path)),
+		ActionList{
+			DoMessageDebug(fmt.Sprintf("Appending %d lines to %q", len(lines), path)),
+			DoExecCommand(command),
+			DoInvalidateRemotePath(path),
+		})
+}
+
+// DoEnsureLineInFile makes sure a remote file contains some line, appending it
+// when not present (ie, for adding entries to /etc/hosts or /etc/fstab)
+func DoEnsureLineInFile(path string, line string) Action {
+	if strings.Contains(line, "\n") {
+		return ActionError(fmt.Sprintf("internal error: multiple lines in DoEnsureLineInFile(): %q", line))
+	}
+	return DoAppendToFile(path, line)
+}
+
 // DoUploadFileToFile uploads a local file to a remote file (using a temporary file)
 func DoUploadFileToFile(local string, remote string) Action {
+	return doUploadLocalFile(local, remote, DoUploadBytesToFile, false)
+}
+
+// DoUploadFileToFileIfChanged uploads a local file to a remote file, but only
+// if the remote file does not have the same contents
+func DoUploadFileToFileIfChanged(local string, remote string) Action {
+	return doUploadLocalFile(local, remote, DoUploadBytesToFileIfChanged, true)
+}
+
+// doUploadLocalFile reads a local file and uploads it with some upload function.
+// Files bigger than defUploadStreamThreshold are not read in memory: they are
+// streamed with doStreamLocalFile() (skipping the upload when `onlyIfChanged` and
+// the remote file has the same checksum)
+func doUploadLocalFile(local string, remote string, upload func([]byte, string) Action, onlyIfChanged bool) Action {
 	if local == "" {
 		return ActionError("empty local file name to upload")
 	}
@@ -174,80 +576,133 @@ func DoUploadFileToFile(local string, remote string) Action {
 		if err != nil {
 			return ActionError(fmt.Sprintf("could not open local file %q for uploading to %q: %s", local, remote, err))
 		}
+		defer f.Close()
+
+		if info, err := f.Stat(); err == nil && info.Size() >= defUploadStreamThreshold {
+			return doStreamLocalFile(local, remote, onlyIfChanged)
+		}
 
 		b, err := ioutil.ReadAll(f)
 		if err != nil {
 			return ActionError(fmt.Sprintf("could not read local file %q for uploading to %q: %s", local, remote, err))
 		}
 
-		return DoUploadBytesToFile(b, remote)
+		return upload(b, remote)
 	})
 }
 
-// DoDownloadFileToWriter downloads a file to a writer
-func DoDownloadFileToWriter(remote string, contents io.WriteCloser) Action {
-	if remote == "" {
-		return ActionError("empty remote file name to download")
-	}
-
-	// Terraform does not provide a mechanism for copying file from a remote host
-	// to the local machine
-	// so we must run a remote command that dumps the file Contents to stdout
-	// hopefully it will be terminal-friendly
-	// otherwise, we could use `cat <FILE> | base64 -`
-	command := fmt.Sprintf("sh -c \"echo '%s' && cat '%s' && echo '%s'\"", markStart, remote, markEnd)
-
-	insideBlock := false
-	extraOutput := ""
-	var err error
-
-	return DoWithCleanup(ActionList{
-		DoMessageDebug(fmt.Sprintf("Dumping remote file %q", remote)),
-		DoSendingExecOutputToFunc(
-			DoExec(command),
-			func(s string) {
-				if strings.Contains(s, markStart) {
-					insideBlock = true
-					return
-				}
-				if strings.Contains(s, markEnd) {
-					insideBlock = false
-					return
-				}
-
-				if insideBlock {
-					if _, err = contents.Write([]byte(s)); err != nil {
-						return
-					}
-
-					if _, err = contents.Write([]byte{'\n'}); err != nil {
-						return
-					}
-				} else {
-					extraOutput += s
-				}
-			}),
-	}, ActionList{
-		DoMessage(extraOutput),
-		ActionFunc(func(context.Context) Action {
-			// close the file handler
-			_ = contents.Close()
+// getLocalFileChecksum returns the size and the sha256 checksum of a local file,
+// reading it in chunks
+func getLocalFileChecksum(local string) (int64, string, error) {
+	f, err := os.Open(local)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// doStreamLocalFile uploads a (big) local file to a remote path without loading it
+// in memory: the file is uploaded to a temporary file, verified and moved to the
+// final destination, like DoUploadBytesToFile() does.
+func doStreamLocalFile(local string, dst string, onlyIfChanged bool) Action {
+	return ActionFunc(func(context.Context) Action {
+		size, sum, err := getLocalFileChecksum(local)
+		if err != nil {
+			return ActionError(fmt.Sprintf("could not read local file %q for uploading to %q: %s", local, dst, err))
+		}
+
+		upload := DoWithTempFilename(func(dstTmpPath string) Action {
+			return DoWithCleanup(ActionList{
+				DoMessageInfo(fmt.Sprintf("Uploading to %q", dst)),
+				DoMessageDebug(fmt.Sprintf("Streaming %q (%d bytes) to temporary file %q", local, size, dstTmpPath)),
+				DoRetry(uploadRetry,
+					doRawStreamLocalFile(local, size, sum, dstTmpPath),
+					DoInvalidateRemotePath(dstTmpPath),
+					doVerifyRemoteChecksumIs(sum, dstTmpPath)),
+				DoMessageDebug(fmt.Sprintf("... and moving to final destination %s", dst)),
+				DoMoveFileWithMode(dstTmpPath, dst, DefFileMode),
+			}, ActionList{
+				DoTry(DoDeleteFile(dstTmpPath)),
+			})
+		})
+
+		if !onlyIfChanged {
+			return upload
+		}
+		return DoIfElse(
+			CheckFileChecksum(dst, sum),
+			DoMessageDebug(fmt.Sprintf("%q has not changed: skipping upload", dst)),
+			ActionList{
+				upload,
+				DoSetInCache(CacheRemoteFileChecksumPrefix+"-"+dst, sum),
+			})
+	})
+}
+
+// doRawStreamLocalFile uploads a local file to a remote path with the communicator,
+// reading the local file while it is being uploaded
+func doRawStreamLocalFile(local string, size int64, sum string, dst string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		comm := GetCommFromContext(ctx)
+		if comm == nil {
+			return ActionError(fmt.Sprintf("no communicator available for uploading %q", dst))
+		}
+
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "upload %d bytes to %s", size, dst)
+			recordStreamedUpload(ctx, dst, size, sum, nil)
 			return nil
-		}),
+		}
+
+		Debug("Doing the real (streamed) upload of %s to %s (%d bytes)", local, dst, size)
+		upload := func() error {
+			f, err := os.Open(local)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return comm.Upload(dst, f)
+		}
+		err := withPacing(ctx, "upload", upload)
+		recordStreamedUpload(ctx, dst, size, sum, err)
+		if err != nil {
+			Debug("ERROR: upload failed: %s", err)
+			return ActionError(err.Error())
+		}
+		return nil
 	})
 }
 
+// DoDownloadFileToWriter downloads a (text) file to a writer
+// (this is equivalent to DoDownloadBinaryFileToWriter, as downloads
+// are byte-accurate)
+func DoDownloadFileToWriter(remote string, contents io.WriteCloser) Action {
+	return doDumpToWriter(remote, contents)
+}
+
+// DoDownloadBinaryFileToWriter downloads a (possibly binary) file to a writer.
+// The contents are byte-accurate (line endings and trailing newlines are preserved).
+func DoDownloadBinaryFileToWriter(remote string, contents io.WriteCloser) Action {
+	return doDumpToWriter(remote, contents)
+}
+
 // DoWriteLocalFile writes some string in a local file
 func DoWriteLocalFile(path string, contents string) Action {
 	if path == "" {
 		return ActionError("empty local file name to create")
 	}
 	return ActionFunc(func(context.Context) Action {
-		localFile, err := os.Create(path)
-		if err != nil {
-			return ActionError(fmt.Sprintf("cannot create %q: %s", path, err.Error()))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return ActionError(fmt.Sprintf("cannot create the directory for %q: %s", path, err.Error()))
 		}
-		if _, err := localFile.WriteString(contents); err != nil {
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
 			return ActionError(fmt.Sprintf("cannot write %q: %s", path, err.Error()))
 		}
 		return nil
@@ -260,8 +715,8 @@ func DoDeleteFile(path string) Action {
 		return ActionError("empty remote file name to remove")
 	}
 	return ActionList{
-		DoExec(fmt.Sprintf("rm -f %q", path)),
-		DoRemoveFromCache(CacheRemoteFileExistsPrefix + "-" + path),
+		doWithoutEscalationIn(DoExecCommand(NewCommand("rm", "-f", path)), path),
+		DoInvalidateRemotePath(path),
 	}
 }
 
@@ -270,35 +725,144 @@ func DoDeleteLocalFile(path string) Action {
 	if path == "" {
 		return ActionError("empty local file name to remove")
 	}
-	return DoLocalExec(fmt.Sprintf("rm -f %q", path))
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "remove local file %s", path)
+			return nil
+		}
+		// (like a `rm -f`, but it works in any OS)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return ActionError(fmt.Sprintf("cannot remove %q: %s", path, err.Error()))
+		}
+		return nil
+	})
 }
 
 // DoMoveFile moves a file
 func DoMoveFile(src, dst string) Action {
-	dstDir := filepath.Dir(dst)
-	return DoExec(fmt.Sprintf("mkdir -p %q && mv -f %q %q", dstDir, src, dst))
+	dstDir := path.Dir(dst)
+	return ActionList{
+		doWithoutEscalationIn(
+			DoExecCommand(NewShellCommand(moveScript, src, dst, dstDir)),
+			src, dst),
+		DoInvalidateRemotePath(src),
+		DoInvalidateRemotePath(dst),
+	}
+}
+
+// DoMoveFileWithMode moves a remote file, setting its permissions and owner
+// before the move (so it never exists in the destination with other attributes)
+func DoMoveFileWithMode(src, dst string, mode FileMode) Action {
+	dstDir := path.Dir(dst)
+	// (run everything in a "sh -c", so all the commands are run with sudo)
+	move := DoExecCommand(NewShellCommand(moveWithModeScript, src, dst, dstDir, getPermArg(mode.Mode), DirMode(mode).getChownArg()))
+	if !mode.hasOwner() {
+		// (changing the owner always requires root privileges)
+		move = doWithoutEscalationIn(move, src, dst)
+	}
+	return ActionList{
+		move,
+		DoInvalidateRemotePath(src),
+		DoInvalidateRemotePath(dst),
+	}
+}
+
+// DoSetFileMode sets the permissions and owner of a remote file
+func DoSetFileMode(path string, mode FileMode) Action {
+	set := DoExecCommand(mode.getSetModeCommand(path))
+	if !mode.hasOwner() {
+		set = doWithoutEscalationIn(set, path)
+	}
+	return set
 }
 
 // DoMoveLocalFile moves a local file
 func DoMoveLocalFile(src, dst string) Action {
-	dstDir := filepath.Dir(dst)
-	return ActionList{
-		DoLocalExec("mkdir", dstDir),
-		DoLocalExec("mv", "-f", src, dst),
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "move local file %s to %s", src, dst)
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return ActionError(fmt.Sprintf("cannot create the directory for %q: %s", dst, err.Error()))
+		}
+		if err := moveLocalFile(src, dst); err != nil {
+			return ActionError(fmt.Sprintf("cannot move %q to %q: %s", src, dst, err.Error()))
+		}
+		return nil
+	})
+}
+
+// moveLocalFile moves a local file, overwriting the destination. Files are
+// copied (and then removed) when they cannot be renamed (ie, in different volumes).
+func moveLocalFile(src, dst string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err == nil {
+		return nil
 	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	_ = in.Close()
+	return os.Remove(src)
+}
+
+// DoDownloadBinaryFile downloads a remote (possibly binary) file to a local file
+func DoDownloadBinaryFile(remote, local string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		localFile, err := os.Create(local)
+		if err != nil {
+			return ActionError(err.Error())
+		}
+		res := ActionList{
+			DoMessageInfo(fmt.Sprintf("Downloading remote file %q -> %q", remote, local)),
+			DoDownloadBinaryFileToWriter(remote, localFile),
+		}.Apply(ctx)
+		if IsError(res) {
+			// do not leave a partial download
+			_ = os.Remove(local)
+		}
+		return res
+	})
 }
 
-// DoDownloadFile downloads a remote file to a local file
+// DoDownloadFile downloads a remote (text) file to a local file
 func DoDownloadFile(remote, local string) Action {
-	return ActionFunc(func(context.Context) Action {
+	return ActionFunc(func(ctx context.Context) Action {
 		localFile, err := os.Create(local)
 		if err != nil {
 			return ActionError(err.Error())
 		}
-		return ActionList{
+		res := ActionList{
 			DoMessageInfo(fmt.Sprintf("Downloading remote file %q -> %q", remote, local)),
 			DoDownloadFileToWriter(remote, localFile),
+		}.Apply(ctx)
+		if IsError(res) {
+			// do not leave a partial download
+			_ = os.Remove(local)
 		}
+		return res
 	})
 }
 
@@ -306,30 +870,80 @@ func DoDownloadFile(remote, local string) Action {
 // leftovers
 //
 
-// DoAddLeftover adds a leftover file
-func DoAddLeftover(path string) Action {
-	return ActionFunc(func(ctx context.Context) Action {
-		sshc := getSSHContext(ctx)
-		sshc.leftovers = append(sshc.leftovers, path)
+// DoAddLeftover adds a leftover file. Leftovers are also recorded in a remote
+// file, so they can be removed in a later run when this one is interrupted
+// before calling DoCleanupLeftovers()
+func DoAddLeftover(leftover string) Action {
+	return ActionList{
+		ActionFunc(func(ctx context.Context) Action {
+			l := getSSHContext(ctx).leftovers
+			l.Lock()
+			defer l.Unlock()
+			l.paths = append(l.paths, leftover)
+			return nil
+		}),
+		DoTry(ActionList{
+			DoMkdirOnce(path.Dir(defLeftoversPath)),
+			DoEnsureLineInFile(defLeftoversPath, leftover),
+		}),
+	}
+}
+
+// getRecordedLeftovers returns the leftovers recorded in the remote host
+// (including the ones from previous runs)
+func getRecordedLeftovers(ctx context.Context) []string {
+	leftovers := []string{}
+	res := ActionList{
+		// the output is received line by line
+		DoSendingExecOutputToFunc(DoExecCommand(NewShellCommand(readIfExistsScript, defLeftoversPath)), func(s string) {
+			s = strings.TrimSpace(s)
+			switch {
+			case s == "":
+			case !IsTempFilename(s):
+				// only temporary files are removed, just in case the file has been tampered with
+				Debug("ignoring recorded leftover %q: it is not a temporary file", s)
+			default:
+				leftovers = append(leftovers, s)
+			}
+		}),
+	}.Apply(ctx)
+	if IsError(res) {
+		Debug("could not get the leftovers recorded in %s: %s", defLeftoversPath, res)
 		return nil
-	})
+	}
+	return leftovers
 }
 
-// DoCleanupLeftovers removes all the leftovers files
+// DoCleanupLeftovers removes all the leftovers files, including the ones
+// recorded in the remote host by previous (interrupted) runs
 func DoCleanupLeftovers() Action {
 	return ActionFunc(func(ctx context.Context) Action {
-		sshc := getSSHContext(ctx)
-		if len(sshc.leftovers) == 0 {
+		l := getSSHContext(ctx).leftovers
+		l.Lock()
+		paths := l.paths
+		l.paths = []string{}
+		l.Unlock()
+
+		seen := map[string]bool{}
+		leftovers := []string{}
+		for _, p := range append(paths, getRecordedLeftovers(ctx)...) {
+			if !seen[p] {
+				seen[p] = true
+				leftovers = append(leftovers, p)
+			}
+		}
+
+		if len(leftovers) == 0 {
 			return nil
 		}
 
 		actions := ActionList{
 			DoMessageInfo("Removing leftovers..."),
 		}
-		for _, l := range sshc.leftovers {
-			actions = append(actions, DoDeleteFile(l))
+		for _, p := range leftovers {
+			actions = append(actions, DoDeleteFile(p))
 		}
-		return actions
+		return append(actions, DoTry(DoDeleteFile(defLeftoversPath)))
 	})
 }
 
@@ -339,7 +953,7 @@ func DoCleanupLeftovers() Action {
 
 // CheckFileExists checks that a remote file exists
 func CheckFileExists(path string) CheckerFunc {
-	return CheckExec(fmt.Sprintf("[ -f %s ]", path))
+	return CheckExecCommand(NewCommand("test", "-f", path))
 }
 
 // CheckFileExistsOnce checks that a remote file exists (but only once)
@@ -349,11 +963,35 @@ func CheckFileExistsOnce(path string) CheckerFunc {
 		CheckFileExists(path))
 }
 
+// CheckFileChecksum checks that a remote file exists and has some sha256 checksum.
+// The remote checksum is cached, so it is only obtained once.
+func CheckFileChecksum(path string, sum string) CheckerFunc {
+	return CheckerFunc(func(ctx context.Context) (bool, error) {
+		key := CacheRemoteFileChecksumPrefix + "-" + path
+		if value, ok := getFromCacheInContext(ctx, key); ok {
+			return value.(string) == sum, nil
+		}
+
+		remoteSum, res := getRemoteChecksum(ctx, path)
+		if IsError(res) {
+			// (the file probably does not exist)
+			return false, nil
+		}
+		setInCacheInContext(ctx, key, remoteSum, defCacheTTL)
+		return remoteSum == sum, nil
+	})
+}
+
 // CheckFileAbsent checks that a remote file does not exists
 func CheckFileAbsent(path string) CheckerFunc {
 	return CheckNot(CheckFileExists(path))
 }
 
+// CheckFileContainsLine checks that a remote file exists and contains some line (exactly)
+func CheckFileContainsLine(path string, line string) CheckerFunc {
+	return CheckExecCommand(NewCommand("grep", "-qxF", "--", line, path))
+}
+
 // CheckLocalFileExists checks that a local file exists
 // If the input file is empty, it returns false.
 func CheckLocalFileExists(path string) CheckerFunc {
@@ -15,16 +15,41 @@
 package ssh
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
 )
 
+type testBufferCloser struct {
+	bytes.Buffer
+}
+
+func (_ *testBufferCloser) Close() error {
+	return nil
+}
+
 func TestTempFilenames(t *testing.T) {
-	name1, err := GetTempFilename()
+	ctx := NewTestingContext()
+	name1, err := GetTempFilename(ctx)
 	if err != nil {
 		t.Fatalf("Error: %s", err)
 	}
-	name2, err := GetTempFilename()
+	name2, err := GetTempFilename(ctx)
 	if err != nil {
 		t.Fatalf("Error: %s", err)
 	}
@@ -54,10 +79,64 @@ func TestTempFilenames(t *testing.T) {
 	}
 }
 
+func TestTempFilenamesWithRemoteTmp(t *testing.T) {
+	name, err := GetTempFilename(WithRemoteTmp(NewTestingContext(), "/var/tmp/kubeadm/"))
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !strings.HasPrefix(name, "/var/tmp/kubeadm/"+defTemporaryFilenamePrefix) {
+		t.Fatalf("Error: temporary file %q is not in the remote temporary directory", name)
+	}
+	if !IsTempFilename(name) {
+		t.Fatalf("Error: %q not detected as a temporary file", name)
+	}
+
+	name, err = GetTempFilename(NewTestingContext())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !strings.HasPrefix(name, defaultRemoteTmp+"/") {
+		t.Fatalf("Error: temporary file %q is not in the default temporary directory", name)
+	}
+}
+
+func TestGetRemoteTempFile(t *testing.T) {
+	counter := 0
+	uploads := map[string]string{}
+	commands := []string{}
+	comm := testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+		&commands,
+	}
+	ctx := WithRemoteTmp(WithValues(context.Background(), DummyOutput{}, DummyOutput{}, comm, true), "/var/tmp")
+
+	name, err := GetRemoteTempFile(ctx)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !strings.HasPrefix(name, "/var/tmp/") || !IsTempFilename(name) {
+		t.Fatalf("Error: unexpected temporary file %q", name)
+	}
+	// the temporary file must be created by the SSH user
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "mktemp /var/tmp/") {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+
+	// without `mktemp`, a random filename is used
+	ctx = NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+	name, err = GetRemoteTempFile(ctx)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !IsTempFilename(name) {
+		t.Fatalf("Error: unexpected temporary file %q", name)
+	}
+}
+
 func TestCheckLocalFileExists(t *testing.T) {
 	ctx := NewTestingContext()
 
-	name1, err := GetTempFilename()
+	name1, err := GetTempFilename(ctx)
 	if err != nil {
 		t.Fatalf("Error: %s", err)
 	}
@@ -83,9 +162,79 @@ func TestCheckLocalFileExists(t *testing.T) {
 	}
 }
 
+func TestLocalFileExists(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local files")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "some dir", "admin.conf")
+	if LocalFileExists(name) {
+		t.Fatalf("Error: %q exists before creating it", name)
+	}
+	_ = os.MkdirAll(filepath.Dir(name), 0755)
+	_ = ioutil.WriteFile(name, []byte("some contents"), 0644)
+	if !LocalFileExists(name) {
+		t.Fatalf("Error: %q does not exist", name)
+	}
+
+	// names that are too long for this OS do not exist
+	if long := filepath.Join(dir, strings.Repeat("a", 5000)); LocalFileExists(long) {
+		t.Fatalf("Error: a name with %d characters exists", len(long))
+	}
+	if LocalFileExists("") {
+		t.Fatalf("Error: an empty name exists")
+	}
+}
+
+func TestLocalFileOperations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "local-files")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := NewTestingContext()
+	src := filepath.Join(dir, "some dir", "admin.conf")
+	dst := filepath.Join(dir, "backups", "admin.conf.bak")
+
+	// write a file (in a new directory) and then move it (overwriting an existing file)
+	_ = os.MkdirAll(filepath.Dir(dst), 0755)
+	_ = ioutil.WriteFile(dst, []byte("old contents"), 0644)
+	res := ActionList{
+		DoWriteLocalFile(src, "some contents"),
+		DoMoveLocalFile(src, dst),
+	}.Apply(ctx)
+	if IsError(res) {
+		t.Fatalf("Error: could not write and move local file: %s", res)
+	}
+	if LocalFileExists(src) {
+		t.Fatalf("Error: %q still exists after the move", src)
+	}
+	if contents, err := ioutil.ReadFile(dst); err != nil || string(contents) != "some contents" {
+		t.Fatalf("Error: unexpected contents in %q: %q (%v)", dst, contents, err)
+	}
+
+	// moving a file that does not exist fails
+	if res := (ActionList{DoMoveLocalFile(src, dst)}).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: no error when moving a file that does not exist")
+	}
+
+	// removing files that do not exist does not fail
+	for i := 0; i < 2; i++ {
+		if res := (ActionList{DoDeleteLocalFile(dst)}).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: could not remove local file: %s", res)
+		}
+		if LocalFileExists(dst) {
+			t.Fatalf("Error: %q still exists", dst)
+		}
+	}
+}
+
 func TestCheckFileExists(t *testing.T) {
 	ctx := NewTestingContext()
-	name1, err := GetTempFilename()
+	name1, err := GetTempFilename(ctx)
 	if err != nil {
 		t.Fatalf("Error: %s", err)
 	}
@@ -118,6 +267,284 @@ func TestDoUploadReaderToFile(t *testing.T) {
 	}
 }
 
+func TestDoUploadFileToFileStreamed(t *testing.T) {
+	contents := strings.Repeat("some binary contents\n", defUploadStreamThreshold/10)
+
+	dir, err := ioutil.TempDir("", "streamed")
+	if err != nil {
+		t.Fatalf("Error: could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := filepath.Join(dir, "kubelet")
+	if err := ioutil.WriteFile(local, []byte(contents), 0755); err != nil {
+		t.Fatalf("Error: could not create local file: %s", err)
+	}
+
+	ctx, uploads := NewTestingContextForUploads([]string{})
+	dst := "/usr/bin/kubelet"
+	if res := (ActionList{DoUploadFileToFileIfChanged(local, dst)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	// the file must be uploaded as it is (not compressed), to a temporary file
+	found := 0
+	for path, uploaded := range *uploads {
+		if !IsTempFilename(path) {
+			t.Fatalf("Error: uploaded to a non-temporary file %q", path)
+		}
+		if uploaded == contents {
+			found++
+		}
+	}
+	if found != 1 {
+		t.Fatalf("Error: streamed upload not found in %d uploads", len(*uploads))
+	}
+
+	// the checksum is cached: the file is not uploaded again
+	count := len(*uploads)
+	if res := (ActionList{DoUploadFileToFileIfChanged(local, dst)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if len(*uploads) != count {
+		t.Fatalf("Error: file uploaded again")
+	}
+}
+
+func TestDoUploadTemplate(t *testing.T) {
+	ctx, uploads := NewTestingContextForUploads([]string{})
+
+	tmpl := "KUBELET_EXTRA_ARGS=--node-ip={{.node_ip}}{{if .cgroup_driver}} --cgroup-driver={{.cgroup_driver}}{{end}}\n"
+	data := map[string]interface{}{"node_ip": "10.0.0.1", "cgroup_driver": "systemd"}
+	actions := ActionList{
+		DoUploadTemplate(tmpl, data, "/etc/sysconfig/kubelet"),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	found := false
+	for _, contents := range *uploads {
+		if contents == "KUBELET_EXTRA_ARGS=--node-ip=10.0.0.1 --cgroup-driver=systemd\n" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: rendered template not found in uploads: %+v", *uploads)
+	}
+
+	actions = ActionList{
+		DoUploadTemplate("{{.node_ip", data, "/etc/sysconfig/kubelet"),
+	}
+	if res := actions.Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: invalid template not detected")
+	}
+}
+
+func TestDoEnsureLineInFile(t *testing.T) {
+	line := "10.0.0.1 master-0 # it's the seeder"
+
+	// the line is already present: nothing is appended
+	counter := 0
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, responses: []string{"CONDITION_SUCCEEDED"}},
+		&commands,
+	})
+	if res := DoEnsureLineInFile("/etc/hosts", line).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if len(commands) != 1 || !strings.Contains(commands[0], `grep -qxF -- '10.0.0.1 master-0 # it'"'"'s the seeder' /etc/hosts`) {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+
+	// the line is not present: it is appended
+	counter = 0
+	commands = []string{}
+	ctx = NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, responses: []string{"CONDITION_FAILED"}},
+		&commands,
+	})
+	if res := DoEnsureLineInFile("/etc/hosts", line).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	expected := `done' sh /etc/hosts '10.0.0.1 master-0 # it'"'"'s the seeder'`
+	if len(commands) != 2 || !strings.HasSuffix(commands[1], expected) {
+		t.Fatalf("Error: line not appended: %q", commands)
+	}
+
+	if res := DoEnsureLineInFile("/etc/hosts", "a\nb").Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: multiple lines not detected")
+	}
+}
+
+func TestAppendLinesScript(t *testing.T) {
+	f, err := ioutil.TempFile("", "append")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	_, _ = f.WriteString("first\nsecond")
+	f.Close()
+
+	// only the missing lines are appended (after adding the missing line break)
+	cmd := NewShellCommand(appendLinesScript, f.Name(), "second", "third", "it's the $HOME")
+	if output, err := exec.Command("sh", "-c", cmd.String()).CombinedOutput(); err != nil {
+		t.Fatalf("Error: %v: %s", err, output)
+	}
+	contents, _ := ioutil.ReadFile(f.Name())
+	if expected := "first\nsecond\nthird\nit's the $HOME\n"; string(contents) != expected {
+		t.Fatalf("Error: unexpected contents: %q (expected %q)", contents, expected)
+	}
+}
+
+func TestDoUploadBytesToFileIfChanged(t *testing.T) {
+	counter := 0
+	uploads := map[string]string{}
+	comm := dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads}
+	ctx := NewTestingContextWithCommunicator(comm)
+
+	dst, _ := GetTempFilename(ctx)
+	s := "this is a test"
+
+	if res := DoUploadBytesToFileIfChanged([]byte(s), dst).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if uploads[dst] != s {
+		t.Fatalf("Error: upload not found in %+v", uploads)
+	}
+
+	// the checksum is cached: the file is not uploaded again
+	uploads[dst] = "modified"
+	if res := DoUploadBytesToFileIfChanged([]byte(s), dst).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if uploads[dst] != "modified" {
+		t.Fatalf("Error: file uploaded again: %+v", uploads)
+	}
+
+	// without a cache, the remote checksum does not match and the file is uploaded
+	ctx = NewTestingContextWithCommunicator(comm)
+	if res := DoUploadBytesToFileIfChanged([]byte(s), dst).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if uploads[dst] != s {
+		t.Fatalf("Error: file not uploaded again: %+v", uploads)
+	}
+}
+
+// testCorruptingCommunicator is a communicator that corrupts the files uploaded
+type testCorruptingCommunicator struct {
+	dummyCommunicatorWithResponses
+}
+
+func (dc testCorruptingCommunicator) Upload(dst string, r io.Reader) error {
+	all, _ := ioutil.ReadAll(r)
+	(*dc.uploads)[dst] = string(all[:len(all)-1])
+	return nil
+}
+
+func TestDoUploadBytesToFileWithMode(t *testing.T) {
+	counter := 0
+	uploads := map[string]string{}
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+		&commands,
+	})
+
+	dst := "/etc/kubernetes/pki/ca.key"
+	if res := DoUploadBytesToFileWithModeIfChanged([]byte("key"), dst, DefPrivateFileMode).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	// the mode and owner must be set in the same command that moves the file
+	found := false
+	for _, command := range commands {
+		if strings.Contains(command, `mv -f "$1" "$2"' sh /tmp/`+defTemporaryFilenamePrefix) {
+			if !strings.HasSuffix(command, " "+dst+" /etc/kubernetes/pki 0600 root:root") {
+				t.Fatalf("Error: mode not set when moving the file: %q", command)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: file not moved to the destination: %q", commands)
+	}
+
+	// the mode is set even when the contents have not changed
+	uploads[dst] = "key"
+	commands = []string{}
+	if res := DoUploadBytesToFileWithModeIfChanged([]byte("key"), dst, DefPrivateFileMode).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	expected := `sh -c 'chmod "$2" "$1" && { [ -z "$3" ] || chown "$3" "$1" ; }' sh /etc/kubernetes/pki/ca.key 0600 root:root`
+	if len(commands) == 0 || commands[len(commands)-1] != expected {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+}
+
+func TestDoUploadBytesToFileWithBackupIfChanged(t *testing.T) {
+	if name := getBackupFilename("/etc/kubernetes/kubeadm.conf", time.Date(2019, 7, 1, 10, 20, 30, 0, time.UTC)); name != "/etc/kubernetes/kubeadm.conf.bak-20190701-102030" {
+		t.Fatalf("Error: unexpected backup filename %q", name)
+	}
+
+	counter := 0
+	uploads := map[string]string{}
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+		&commands,
+	})
+
+	dst := "/etc/kubernetes/kubeadm.conf"
+	if res := DoUploadBytesToFileWithBackupIfChanged([]byte("config"), dst).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	backup := `sh -c '[ ! -f "$1" ] || cp -a "$1" "$2"' sh /etc/kubernetes/kubeadm.conf /etc/kubernetes/kubeadm.conf.bak-`
+	backupIdx, moveIdx := -1, -1
+	for i, command := range commands {
+		switch {
+		case strings.HasPrefix(command, backup):
+			backupIdx = i
+		case strings.Contains(command, `mv -f "$1" "$2"' sh /tmp/`) && strings.Contains(command, " "+dst+" "):
+			moveIdx = i
+		}
+	}
+	if backupIdx < 0 || moveIdx < 0 || backupIdx > moveIdx {
+		t.Fatalf("Error: backup not done before replacing the file: %q", commands)
+	}
+
+	// nothing is saved when the file has not changed
+	commands = []string{}
+	if res := DoUploadBytesToFileWithBackupIfChanged([]byte("config"), dst).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	for _, command := range commands {
+		if strings.HasPrefix(command, backup) {
+			t.Fatalf("Error: backup done for an unchanged file: %q", commands)
+		}
+	}
+}
+
+func TestDoUploadBytesToFileVerification(t *testing.T) {
+	defer func(r Retry) { uploadRetry = r }(uploadRetry)
+	uploadRetry = Retry{Times: 2, Interval: time.Millisecond}
+
+	counter := 0
+	uploads := map[string]string{}
+	ctx := NewTestingContextWithCommunicator(testCorruptingCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+	})
+
+	res := DoUploadBytesToFile([]byte("this is a test"), "/etc/kubernetes/kubeadm.conf").Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: corrupted upload not detected")
+	}
+	if !strings.Contains(res.Error(), "checksum mismatch") {
+		t.Fatalf("Error: unexpected error: %s", res)
+	}
+}
+
 func TestLeftovers(t *testing.T) {
 	ctx := NewTestingContextWithResponses([]string{})
 
@@ -130,3 +557,241 @@ func TestLeftovers(t *testing.T) {
 		t.Fatalf("Error: when running actions: %s", res)
 	}
 }
+
+func TestLeftoversFromPreviousRuns(t *testing.T) {
+	counter := 0
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingUploadsCommunicator{
+		dummyCommunicatorWithResponses{counter: &counter, responses: []string{"/tmp/tmpfile-a1b2c3\n/etc/passwd\n"}},
+		&commands,
+	})
+
+	if res := (ActionList{DoCleanupLeftovers()}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	removed := strings.Join(commands, "\n")
+	if !strings.Contains(removed, "rm -f /tmp/tmpfile-a1b2c3") {
+		t.Fatalf("Error: leftover from a previous run not removed: %q", commands)
+	}
+	if strings.Contains(removed, "rm -f /etc/passwd") {
+		t.Fatalf("Error: recorded file that is not a temporary file removed: %q", commands)
+	}
+	if !strings.Contains(removed, "rm -f "+defLeftoversPath) {
+		t.Fatalf("Error: leftovers record not removed: %q", commands)
+	}
+}
+
+func TestDoDownloadBinaryFileToWriter(t *testing.T) {
+	contents := []byte{0x1f, 0x8b, 0x00, 0xff, '\n', 0x42}
+	sum := sha256.Sum256(contents)
+	response := fmt.Sprintf("%s\n%s\n%s %d %s\n", dumpMarkStart, base64.StdEncoding.EncodeToString(contents),
+		dumpMarkEnd, len(contents), hex.EncodeToString(sum[:]))
+	ctx := NewTestingContextWithResponses([]string{response})
+
+	buf := testBufferCloser{}
+	actions := ActionList{
+		DoDownloadBinaryFileToWriter("/tmp/something.tar.gz", &buf),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if !bytes.Equal(buf.Bytes(), contents) {
+		t.Fatalf("Error: unexpected contents downloaded: %v", buf.Bytes())
+	}
+}
+
+type testFailingWriteCloser struct{}
+
+func (_ testFailingWriteCloser) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("disk full")
+}
+
+func (_ testFailingWriteCloser) Close() error {
+	return nil
+}
+
+func TestDoDownloadFileToWriterErrors(t *testing.T) {
+	contents := "some contents\n"
+	sum := sha256.Sum256([]byte(contents))
+	encoded := base64.StdEncoding.EncodeToString([]byte(contents))
+
+	cases := []struct {
+		name     string
+		response string
+		writer   io.WriteCloser
+	}{
+		{
+			"truncated",
+			fmt.Sprintf("%s\n%s", dumpMarkStart, encoded[:8]),
+			&testBufferCloser{},
+		},
+		{
+			"size mismatch",
+			fmt.Sprintf("%s\n%s\n%s %d %s\n", dumpMarkStart, encoded[:8], dumpMarkEnd, len(contents), hex.EncodeToString(sum[:])),
+			&testBufferCloser{},
+		},
+		{
+			"write error",
+			fmt.Sprintf("%s\n%s\n%s %d %s\n", dumpMarkStart, encoded, dumpMarkEnd, len(contents), hex.EncodeToString(sum[:])),
+			testFailingWriteCloser{},
+		},
+	}
+
+	for _, c := range cases {
+		ctx := NewTestingContextWithResponses([]string{c.response})
+		if res := DoDownloadFileToWriter("/tmp/something", c.writer).Apply(ctx); !IsError(res) {
+			t.Fatalf("Error: %s: error not detected", c.name)
+		}
+	}
+
+	// a valid download
+	ctx := NewTestingContextWithResponses([]string{
+		fmt.Sprintf("%s\n%s\n%s %d %s\n", dumpMarkStart, encoded, dumpMarkEnd, len(contents), hex.EncodeToString(sum[:])),
+	})
+	buf := testBufferCloser{}
+	if res := DoDownloadFileToWriter("/tmp/something", &buf).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if buf.String() != contents {
+		t.Fatalf("Error: unexpected contents downloaded: %q", buf.String())
+	}
+}
+
+// testGunzipCommunicator is a communicator that has "gunzip" available,
+// uncompressing the files uploaded when it is invoked
+type testGunzipCommunicator struct {
+	dummyCommunicatorWithResponses
+
+	gunzipAvailable bool
+	gunzipped       *int
+}
+
+func (dc testGunzipCommunicator) Start(cmd *remote.Cmd) error {
+	switch {
+	case strings.Contains(cmd.Command, `command -v "$1"' sh gunzip`):
+		cmd.Init()
+		if dc.gunzipAvailable {
+			cmd.Stdout.Write([]byte("/usr/bin/gunzip\n"))
+		}
+		cmd.SetExitStatus(0, nil)
+		return nil
+
+	case strings.Contains(cmd.Command, "test -f /usr/bin/gunzip"):
+		cmd.Init()
+		cmd.Stdout.Write([]byte("CONDITION_SUCCEEDED\n"))
+		cmd.SetExitStatus(0, nil)
+		return nil
+
+	case strings.Contains(cmd.Command, "gunzip -f "):
+		cmd.Init()
+		for p, c := range *dc.uploads {
+			if strings.HasSuffix(p, ".gz") && strings.Contains(cmd.Command, p) {
+				r, err := gzip.NewReader(strings.NewReader(c))
+				if err != nil {
+					cmd.SetExitStatus(1, nil)
+					return nil
+				}
+				all, _ := ioutil.ReadAll(r)
+				(*dc.uploads)[strings.TrimSuffix(p, ".gz")] = string(all)
+				delete(*dc.uploads, p)
+				*dc.gunzipped++
+			}
+		}
+		cmd.SetExitStatus(0, nil)
+		return nil
+	}
+	return dc.dummyCommunicatorWithResponses.Start(cmd)
+}
+
+func TestDoUploadBytesToFileCompressed(t *testing.T) {
+	contents := strings.Repeat("some compressible contents\n", defUploadCompressThreshold/10)
+
+	for _, available := range []bool{true, false} {
+		counter, gunzipped := 0, 0
+		uploads := map[string]string{}
+		ctx := NewTestingContextWithCommunicator(testGunzipCommunicator{
+			dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+			available,
+			&gunzipped,
+		})
+
+		dst, _ := GetTempFilename(ctx)
+		if res := DoUploadBytesToFile([]byte(contents), dst).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: when running actions (gunzip available: %t): %s", available, res)
+		}
+		if uploads[dst] != contents {
+			t.Fatalf("Error: upload not found (gunzip available: %t)", available)
+		}
+		if _, ok := uploads[dst+".gz"]; ok {
+			t.Fatalf("Error: compressed file left (gunzip available: %t)", available)
+		}
+		if available && gunzipped != 1 {
+			t.Fatalf("Error: contents not uploaded compressed")
+		}
+	}
+}
+
+// testTruncateCommunicator is a communicator that can create empty files and extend files
+type testTruncateCommunicator struct {
+	dummyCommunicatorWithResponses
+
+	commands *[]string
+}
+
+func (dc testTruncateCommunicator) Start(cmd *remote.Cmd) error {
+	*dc.commands = append(*dc.commands, cmd.Command)
+
+	fields := strings.Fields(cmd.Command)
+	switch {
+	case strings.Contains(cmd.Command, createEmptyFileScript):
+		cmd.Init()
+		(*dc.uploads)[fields[len(fields)-1]] = ""
+		cmd.SetExitStatus(0, nil)
+		return nil
+
+	case strings.Contains(cmd.Command, "truncate -s"):
+		cmd.Init()
+		path, size := fields[len(fields)-2], fields[len(fields)-1]
+		n, _ := strconv.Atoi(size)
+		c := (*dc.uploads)[path]
+		(*dc.uploads)[path] = c + strings.Repeat("\x00", n-len(c))
+		cmd.SetExitStatus(0, nil)
+		return nil
+	}
+	return dc.dummyCommunicatorWithResponses.Start(cmd)
+}
+
+func TestDoUploadBytesToFileEmptyAndSparse(t *testing.T) {
+	testCases := []struct {
+		contents string
+		uploaded string
+	}{
+		{"", ""},
+		{"header" + strings.Repeat("\x00", defUploadSparseThreshold), "header"},
+		{strings.Repeat("\x00", defUploadSparseThreshold), ""},
+		{"header" + strings.Repeat("\x00", 100), "header" + strings.Repeat("\x00", 100)},
+	}
+
+	for i, testCase := range testCases {
+		counter := 0
+		uploads := map[string]string{}
+		commands := []string{}
+		ctx := NewTestingContextWithCommunicator(testTruncateCommunicator{
+			dummyCommunicatorWithResponses{counter: &counter, uploads: &uploads},
+			&commands,
+		})
+
+		dst, _ := GetTempFilename(ctx)
+		if res := DoUploadBytesToFile([]byte(testCase.contents), dst).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: could not upload contents %d: %s", i, res)
+		}
+		if uploads[dst] != testCase.contents {
+			t.Fatalf("Error: unexpected contents in %d: %d bytes, expected %d", i, len(uploads[dst]), len(testCase.contents))
+		}
+
+		truncated := strings.Contains(strings.Join(commands, "\n"), "truncate -s")
+		if sparse := testCase.uploaded != testCase.contents; sparse != truncated {
+			t.Fatalf("Error: unexpected sparse upload in %d (%t): commands:\n%s", i, truncated, strings.Join(commands, "\n"))
+		}
+	}
+}
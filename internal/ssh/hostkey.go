@@ -0,0 +1,289 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// host key verification modes
+const (
+	// HostKeyStrict only accepts known host keys
+	HostKeyStrict = "strict"
+
+	// HostKeyAcceptNew accepts (and records) the keys of unknown hosts,
+	// but fails when the key of a known host has changed
+	HostKeyAcceptNew = "accept-new"
+
+	// HostKeyOff does not verify host keys
+	HostKeyOff = "off"
+)
+
+// HostKeyModes are all the valid host key verification modes
+var HostKeyModes = []string{HostKeyStrict, HostKeyAcceptNew, HostKeyOff}
+
+// DefKnownHostsFile is the default known_hosts file
+const DefKnownHostsFile = "~/.ssh/known_hosts"
+
+// errHostKeyObtained is used for aborting the handshake once we have the host key
+var errHostKeyObtained = errors.New("host key obtained")
+
+// HostKeyError is the error returned when the key of a host is unknown or when it
+// does not match the expected key (ie, because of a man-in-the-middle attack)
+type HostKeyError struct {
+	Address  string
+	Got      gossh.PublicKey
+	Expected []gossh.PublicKey
+	Source   string
+}
+
+func (e HostKeyError) Error() string {
+	if len(e.Expected) == 0 {
+		return fmt.Sprintf("host key verification failed for %s: unknown host (%s %s not found in %s)",
+			e.Address, e.Got.Type(), gossh.FingerprintSHA256(e.Got), e.Source)
+	}
+
+	expected := []string{}
+	for _, key := range e.Expected {
+		expected = append(expected, key.Type()+" "+gossh.FingerprintSHA256(key))
+	}
+	return fmt.Sprintf("host key verification failed for %s: got %s %s, expected %s (from %s). "+
+		"This could be a man-in-the-middle attack: if the host key has changed legitimately, "+
+		"replace the old key in %s",
+		e.Address, e.Got.Type(), gossh.FingerprintSHA256(e.Got), strings.Join(expected, " or "), e.Source, e.Source)
+}
+
+// HostKeyVerification is the verification of the SSH host keys
+type HostKeyVerification struct {
+	// Mode is the verification mode (strict, accept-new or off)
+	Mode string
+
+	// KnownHostsFile is the known_hosts file with the keys of the known hosts
+	KnownHostsFile string
+
+	// HostKey is the expected host key (in authorized_keys format), used
+	// instead of the known_hosts file when provided
+	HostKey string
+
+	// Dial is used for connecting to the host (ie, through some jump hosts),
+	// instead of a direct connection
+	Dial func(network, address string) (net.Conn, error)
+}
+
+// hostAddr is a net.Addr for a "host:port" address
+type hostAddr string
+
+func (a hostAddr) Network() string { return "tcp" }
+func (a hostAddr) String() string  { return string(a) }
+
+// unknownKey is a public key that is never found in a known_hosts file
+type unknownKey struct{}
+
+func (unknownKey) Type() string                          { return "unknown" }
+func (unknownKey) Marshal() []byte                       { return []byte("unknown") }
+func (unknownKey) Verify([]byte, *gossh.Signature) error { return errors.New("unknown key") }
+
+// expandHome replaces a leading "~" by the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, strings.TrimPrefix(path, "~"))
+		}
+	}
+	return path
+}
+
+// GetHostKey does a SSH handshake with a "host:port" address, returning the host key
+// (for one of the `algorithms`, or for any algorithm when no algorithms are provided)
+func GetHostKey(address string, algorithms []string, timeout time.Duration) (gossh.PublicKey, error) {
+	return getHostKeyWithDial(nil, address, algorithms, timeout)
+}
+
+// getHostKeyWithDial is like GetHostKey, but connecting with `dial` (when provided)
+func getHostKeyWithDial(dial func(string, string) (net.Conn, error), address string, algorithms []string, timeout time.Duration) (gossh.PublicKey, error) {
+	var conn net.Conn
+	var err error
+	if dial != nil {
+		conn, err = dial("tcp", address)
+	} else {
+		conn, err = net.DialTimeout("tcp", address, timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	var hostKey gossh.PublicKey
+	config := &gossh.ClientConfig{
+		User:              "kubeadm",
+		HostKeyAlgorithms: algorithms,
+		HostKeyCallback: func(_ string, _ net.Addr, key gossh.PublicKey) error {
+			hostKey = key
+			return errHostKeyObtained
+		},
+		Timeout: timeout,
+	}
+	_, _, _, err = gossh.NewClientConn(conn, address, config)
+	if hostKey == nil {
+		return nil, fmt.Errorf("could not get the host key of %s: %v", address, err)
+	}
+	return hostKey, nil
+}
+
+// Verify verifies the host key of a "host:port" address, returning the key
+// (in authorized_keys format) or an empty string when not verified
+func (v HostKeyVerification) Verify(address string, timeout time.Duration) (string, error) {
+	if v.Mode == "" || v.Mode == HostKeyOff {
+		return "", nil
+	}
+
+	// ask for a key of the same type as the key we expect
+	var algorithms []string
+	if v.HostKey != "" {
+		expected, _, _, _, err := gossh.ParseAuthorizedKey([]byte(v.HostKey))
+		if err != nil {
+			return "", fmt.Errorf("could not parse the host key %q: %s", v.HostKey, err)
+		}
+		algorithms = []string{expected.Type()}
+	} else {
+		_, callback, err := v.loadKnownHosts()
+		if err != nil {
+			return "", err
+		}
+		for _, k := range getKnownKeys(callback, address) {
+			algorithms = append(algorithms, k.Type())
+		}
+	}
+
+	got, err := getHostKeyWithDial(v.Dial, address, algorithms, timeout)
+	if err != nil {
+		return "", err
+	}
+	return v.verifyKey(address, got)
+}
+
+// HostKeyCallback returns a callback that verifies the host key in the
+// handshake of a connection (instead of in a separate connection)
+func (v HostKeyVerification) HostKeyCallback() gossh.HostKeyCallback {
+	return func(hostname string, _ net.Addr, key gossh.PublicKey) error {
+		_, err := v.verifyKey(hostname, key)
+		return err
+	}
+}
+
+// loadKnownHosts loads the known_hosts file (if it exists), returning
+// the file name and a callback for checking keys
+func (v HostKeyVerification) loadKnownHosts() (string, gossh.HostKeyCallback, error) {
+	filename := expandHome(v.KnownHostsFile)
+	if filename == "" {
+		filename = expandHome(DefKnownHostsFile)
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		if os.IsNotExist(err) {
+			return filename, nil, nil
+		}
+		return "", nil, err
+	}
+	callback, err := knownhosts.New(filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not load the known hosts from %s: %s", filename, err)
+	}
+	return filename, callback, nil
+}
+
+// getKnownKeys returns the keys we know for a "host:port" address
+func getKnownKeys(callback gossh.HostKeyCallback, address string) []gossh.PublicKey {
+	known := []gossh.PublicKey{}
+	if callback != nil {
+		var keyErr *knownhosts.KeyError
+		if err := callback(address, hostAddr(address), unknownKey{}); errors.As(err, &keyErr) {
+			for _, k := range keyErr.Want {
+				known = append(known, k.Key)
+			}
+		}
+	}
+	return known
+}
+
+// verifyKey verifies the key presented by a "host:port" address, returning the key
+// (in authorized_keys format) or an empty string when not verified
+func (v HostKeyVerification) verifyKey(address string, got gossh.PublicKey) (string, error) {
+	if v.Mode == "" || v.Mode == HostKeyOff {
+		return "", nil
+	}
+	authorized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(got)))
+
+	// verify with an explicit host key
+	if v.HostKey != "" {
+		expected, _, _, _, err := gossh.ParseAuthorizedKey([]byte(v.HostKey))
+		if err != nil {
+			return "", fmt.Errorf("could not parse the host key %q: %s", v.HostKey, err)
+		}
+		if string(got.Marshal()) != string(expected.Marshal()) {
+			return "", HostKeyError{Address: address, Got: got, Expected: []gossh.PublicKey{expected}, Source: "host_key"}
+		}
+		return authorized, nil
+	}
+
+	// verify with a known_hosts file
+	filename, callback, err := v.loadKnownHosts()
+	if err != nil {
+		return "", err
+	}
+	if known := getKnownKeys(callback, address); len(known) > 0 {
+		if err := callback(address, hostAddr(address), got); err != nil {
+			return "", HostKeyError{Address: address, Got: got, Expected: known, Source: filename}
+		}
+		Debug("host key of %s verified: %s %s", address, got.Type(), gossh.FingerprintSHA256(got))
+		return authorized, nil
+	}
+
+	// unknown host
+	if v.Mode == HostKeyStrict {
+		return "", HostKeyError{Address: address, Got: got, Source: filename}
+	}
+	if err := addKnownHost(filename, address, got); err != nil {
+		return "", fmt.Errorf("could not add the host key of %s to %s: %s", address, filename, err)
+	}
+	Debug("host key of %s (%s %s) added to %s", address, got.Type(), gossh.FingerprintSHA256(got), filename)
+	return authorized, nil
+}
+
+// addKnownHost appends the key of a host to a known_hosts file
+func addKnownHost(filename string, address string, key gossh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(knownhosts.Line([]string{address}, key) + "\n"); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,141 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) gossh.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return signer
+}
+
+// startTestSSHServer starts a SSH server (that only does handshakes) with a host key
+func startTestSSHServer(t *testing.T, hostKey gossh.Signer) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := &gossh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, _, _, _ = gossh.NewServerConn(conn, config)
+				_ = conn.Close()
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { _ = l.Close() }
+}
+
+func TestHostKeyVerification(t *testing.T) {
+	dir, err := ioutil.TempDir("", "known-hosts")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hostKey := newTestHostKey(t)
+	address, stop := startTestSSHServer(t, hostKey)
+	defer stop()
+
+	knownHosts := filepath.Join(dir, "ssh", "known_hosts")
+	timeout := 5 * time.Second
+
+	// unknown hosts are rejected in strict mode
+	_, err = HostKeyVerification{Mode: HostKeyStrict, KnownHostsFile: knownHosts}.Verify(address, timeout)
+	if e, ok := err.(HostKeyError); !ok || len(e.Expected) > 0 {
+		t.Fatalf("Error: unexpected error for an unknown host: %v", err)
+	}
+
+	// ... but they are accepted (and recorded) in accept-new mode
+	key, err := HostKeyVerification{Mode: HostKeyAcceptNew, KnownHostsFile: knownHosts}.Verify(address, timeout)
+	if err != nil {
+		t.Fatalf("Error: could not verify the host key: %v", err)
+	}
+	if expected := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(hostKey.PublicKey()))); key != expected {
+		t.Fatalf("Error: unexpected host key %q, expected %q", key, expected)
+	}
+	if _, err := (HostKeyVerification{Mode: HostKeyStrict, KnownHostsFile: knownHosts}).Verify(address, timeout); err != nil {
+		t.Fatalf("Error: host key not recorded: %v", err)
+	}
+
+	// a different key for the same host is always rejected
+	other := newTestHostKey(t)
+	_ = ioutil.WriteFile(knownHosts, []byte(knownhosts.Line([]string{address}, other.PublicKey())+"\n"), 0600)
+	for _, mode := range []string{HostKeyStrict, HostKeyAcceptNew} {
+		_, err := HostKeyVerification{Mode: mode, KnownHostsFile: knownHosts}.Verify(address, timeout)
+		if e, ok := err.(HostKeyError); !ok || len(e.Expected) != 1 {
+			t.Fatalf("Error: unexpected error for a changed key (%s): %v", mode, err)
+		}
+		if !strings.Contains(err.Error(), gossh.FingerprintSHA256(other.PublicKey())) {
+			t.Fatalf("Error: expected fingerprint not in the error: %v", err)
+		}
+	}
+
+	// explicit host keys
+	for _, testCase := range []struct {
+		key     gossh.Signer
+		success bool
+	}{
+		{hostKey, true},
+		{other, false},
+	} {
+		v := HostKeyVerification{Mode: HostKeyStrict, HostKey: string(gossh.MarshalAuthorizedKey(testCase.key.PublicKey()))}
+		if _, err := v.Verify(address, timeout); (err == nil) != testCase.success {
+			t.Fatalf("Error: unexpected result with an explicit host key: %v", err)
+		}
+	}
+
+	// the keys can be verified in the handshake of the real connection too
+	callback := HostKeyVerification{Mode: HostKeyStrict, HostKey: string(gossh.MarshalAuthorizedKey(hostKey.PublicKey()))}.HostKeyCallback()
+	if err := callback(address, hostAddr(address), hostKey.PublicKey()); err != nil {
+		t.Fatalf("Error: the expected key was rejected in the handshake: %v", err)
+	}
+	if err := callback(address, hostAddr(address), other.PublicKey()); err == nil {
+		t.Fatalf("Error: other key was accepted in the handshake")
+	}
+
+	// nothing is verified when off
+	if key, err := (HostKeyVerification{Mode: HostKeyOff}).Verify("127.0.0.1:1", timeout); err != nil || key != "" {
+		t.Fatalf("Error: host key verified when off: %q, %v", key, err)
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// placeholders in the host log path pattern
+	hostLogHostPlaceholder = "{host}"
+	hostLogRolePlaceholder = "{role}"
+)
+
+var (
+	// characters that cannot be used in a log filename
+	hostLogUnsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+	// (escape sequences used for coloring the console output)
+	hostLogColorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+)
+
+// GetHostLogPath returns the path of the log file for a host, replacing
+// the "{host}" and "{role}" placeholders in a pattern
+func GetHostLogPath(pattern string, host Host) string {
+	role := host.Role
+	if role == "" {
+		role = "node"
+	}
+	r := strings.NewReplacer(
+		hostLogHostPlaceholder, hostLogUnsafeChars.ReplaceAllString(host.Address, "_"),
+		hostLogRolePlaceholder, hostLogUnsafeChars.ReplaceAllString(role, "_"))
+	return r.Replace(pattern)
+}
+
+// HostLog is a local log file where all the output for a host is written, so
+// the output of each host can be checked when many hosts are provisioned
+// at the same time (and their output is interleaved in the console)
+type HostLog struct {
+	sync.Mutex
+
+	// Path is the path of the log file
+	Path string
+
+	file *os.File
+}
+
+// NewHostLog opens (for appending) the log file for a host
+func NewHostLog(pattern string, host Host) (*HostLog, error) {
+	path := GetHostLogPath(pattern, host)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &HostLog{Path: path, file: file}, nil
+}
+
+// Write writes a line in the log file, with a timestamp
+func (l *HostLog) Write(line string) {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return
+	}
+	line = hostLogColorCodes.ReplaceAllString(line, "")
+	if _, err := fmt.Fprintf(l.file, "%s %s\n", time.Now().UTC().Format(time.RFC3339), line); err != nil {
+		Debug("could not write in the log file %s: %s", l.Path, err)
+	}
+}
+
+// Tee returns an output that writes in the log file as well as in `out`
+func (l *HostLog) Tee(out UIOutput) UIOutput {
+	return OutputFunc(func(s string) {
+		l.Write(s)
+		out.Output(s)
+	})
+}
+
+// Close closes the log file
+func (l *HostLog) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
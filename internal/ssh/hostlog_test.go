@@ -0,0 +1,92 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGetHostLogPath(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		host     Host
+		expected string
+	}{
+		{"logs/{host}.log", Host{Address: "10.0.0.1"}, "logs/10.0.0.1.log"},
+		{"logs/{role}-{host}.log", Host{Address: "[fd00::1]:22", Role: "master"}, "logs/master-_fd00__1__22.log"},
+		{"logs/{role}.log", Host{Address: "bastion:2222,10.0.0.1:22"}, "logs/node.log"},
+	}
+	for _, test := range tests {
+		if path := GetHostLogPath(test.pattern, test.host); path != test.expected {
+			t.Fatalf("Error: unexpected path %q for %q (expected %q)", path, test.pattern, test.expected)
+		}
+	}
+}
+
+func TestHostLogConcurrentWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostlog")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pattern := filepath.Join(dir, "logs", "{host}.log")
+	hosts := []Host{{Address: "10.0.0.1"}, {Address: "10.0.0.2"}}
+	lines := 100
+
+	var wg sync.WaitGroup
+	for _, host := range hosts {
+		l, err := NewHostLog(pattern, host)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		defer l.Close()
+
+		// many goroutines writing in the same log (ie, stdout and stderr of a command)
+		out := l.Tee(DummyOutput{})
+		for g := 0; g < 2; g++ {
+			wg.Add(1)
+			go func(host Host, g int) {
+				defer wg.Done()
+				for i := 0; i < lines; i++ {
+					out.Output(fmt.Sprintf("\x1b[32m%s %d %d\x1b[0m", host.Address, g, i))
+				}
+			}(host, g)
+		}
+	}
+	wg.Wait()
+
+	for _, host := range hosts {
+		contents, err := ioutil.ReadFile(GetHostLogPath(pattern, host))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		written := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		if len(written) != 2*lines {
+			t.Fatalf("Error: unexpected number of lines for %s: %d", host, len(written))
+		}
+		for _, line := range written {
+			if !strings.Contains(line, " "+host.Address+" ") || strings.Contains(line, "\x1b") {
+				t.Fatalf("Error: unexpected line in the log for %s: %q", host, line)
+			}
+		}
+	}
+}
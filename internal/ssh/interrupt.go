@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// phase is the last phase (info message) shown to the user,
+// reported when the provisioning is interrupted
+type phase struct {
+	sync.Mutex
+	current string
+}
+
+// setCurrentPhase sets the current phase in the context
+func setCurrentPhase(ctx context.Context, current string) {
+	if !HasSSHContext(ctx) {
+		return
+	}
+	p := getSSHContext(ctx).phase
+	p.Lock()
+	defer p.Unlock()
+	p.current = current
+}
+
+// GetCurrentPhaseFromContext gets the current phase
+func GetCurrentPhaseFromContext(ctx context.Context) string {
+	if !HasSSHContext(ctx) {
+		return ""
+	}
+	p := getSSHContext(ctx).phase
+	p.Lock()
+	defer p.Unlock()
+	return p.current
+}
+
+// getInterruptedError returns the error for an interrupted context
+func getInterruptedError(ctx context.Context) Action {
+	if current := GetCurrentPhaseFromContext(ctx); current != "" {
+		return ActionError(fmt.Sprintf("interrupted during %q: %s", current, ctx.Err()))
+	}
+	return ActionError(fmt.Sprintf("interrupted: %s", ctx.Err()))
+}
+
+// detachedContext is a context with the values of its parent, but
+// that is never cancelled
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
+
+// withoutCancel returns a context that is not cancelled when the parent is,
+// so cleanups can be run after the user interrupts the provisioning
+func withoutCancel(ctx context.Context) context.Context {
+	if ctx.Err() == nil {
+		return ctx
+	}
+	return detachedContext{parent: ctx}
+}
+
+// getDeadlineExceeded returns a channel that is closed when the deadline of the context
+// is exceeded, but not when it is just cancelled (as the commands running when the user
+// interrupts the provisioning are allowed to finish)
+func getDeadlineExceeded(ctx context.Context) <-chan struct{} {
+	if _, ok := ctx.Deadline(); !ok {
+		return nil // (a nil channel is never ready)
+	}
+
+	ch := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		if ctx.Err() == context.DeadlineExceeded {
+			close(ch)
+		}
+	}()
+	return ch
+}
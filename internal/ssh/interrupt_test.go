@@ -0,0 +1,64 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestInterrupted(t *testing.T) {
+	ctx, cancel := context.WithCancel(NewTestingContext())
+	defer cancel()
+
+	ran, cleaned := 0, 0
+	actions := DoWithCleanup(
+		ActionList{
+			DoMessageInfo("Starting kubeadm..."),
+			ActionFunc(func(context.Context) Action {
+				cancel()
+				return nil
+			}),
+			ActionFunc(func(context.Context) Action {
+				ran++
+				return nil
+			}),
+		},
+		ActionList{
+			ActionFunc(func(context.Context) Action {
+				cleaned++
+				return nil
+			}),
+			ActionFunc(func(context.Context) Action {
+				cleaned++
+				return nil
+			}),
+		})
+
+	res := ActionList{actions}.Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: interruption not detected")
+	}
+	if !strings.Contains(res.Error(), "Starting kubeadm...") {
+		t.Fatalf("Error: interrupted phase not reported: %s", res)
+	}
+	if ran != 0 {
+		t.Fatalf("Error: actions run after the interruption")
+	}
+	if cleaned != 2 {
+		t.Fatalf("Error: cleanups not run after the interruption: %d", cleaned)
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// SSHHost is a host we connect to with our own SSH client (instead of with the communicator)
+type SSHHost struct {
+	// Address is the "user@host:port" of the host
+	Address HostPort
+
+	// PrivateKey is the private key used for authenticating in the host
+	PrivateKey string
+
+	// Certificate is the OpenSSH certificate presented with the PrivateKey (optional)
+	Certificate string
+
+	// Password is the password used for authenticating in the host
+	Password string
+
+	// Agent enables the authentication with the keys in the local SSH agent
+	Agent bool
+
+	// HostKey is the expected host key of the host (in authorized_keys
+	// format). The host key is verified with the Verification when not provided.
+	HostKey string
+
+	// Verification is the verification of the host key when no HostKey is provided
+	Verification HostKeyVerification
+}
+
+// JumpHost is a bastion (or jump host) used for reaching other hosts
+type JumpHost = SSHHost
+
+// getClientConfig returns the SSH client configuration for a host
+func (j SSHHost) getClientConfig(timeout time.Duration) (*gossh.ClientConfig, error) {
+	config := &gossh.ClientConfig{
+		User:            j.Address.User,
+		HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+		Timeout:         timeout,
+	}
+	if j.HostKey != "" {
+		key, _, _, _, err := gossh.ParseAuthorizedKey([]byte(j.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the host key of %s: %s", j.Address, err)
+		}
+		config.HostKeyCallback = gossh.FixedHostKey(key)
+	} else if j.Verification.Mode != "" && j.Verification.Mode != HostKeyOff {
+		config.HostKeyCallback = j.Verification.HostKeyCallback()
+	}
+	if j.PrivateKey != "" {
+		signer, err := gossh.ParsePrivateKey([]byte(j.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the private key for %s: %s", j.Address, err)
+		}
+		if j.Certificate != "" {
+			if signer, err = getCertSigner(signer, j.Certificate); err != nil {
+				return nil, fmt.Errorf("could not use the certificate for %s: %s", j.Address, err)
+			}
+		}
+		config.Auth = append(config.Auth, gossh.PublicKeys(signer))
+	}
+	if j.Agent {
+		auth, err := getAgentAuthMethod()
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, auth)
+	}
+	if j.Password != "" {
+		config.Auth = append(config.Auth, gossh.Password(j.Password))
+	}
+	if len(config.Auth) == 0 {
+		return nil, fmt.Errorf("no private key, password or SSH agent for %s", j.Address)
+	}
+	return config, nil
+}
+
+// JumpTunnel is a tunnel to a host through a chain of jump hosts: connections to a
+// local address are forwarded (through all the jump hosts) to the host
+type JumpTunnel struct {
+	sync.Mutex
+
+	target   string
+	clients  []*gossh.Client
+	listener net.Listener
+	closed   bool
+}
+
+// NewJumpTunnel connects to all the jump hosts (in order, each one through the
+// previous one), and starts forwarding connections to a local address to the
+// `target` "host:port"
+func NewJumpTunnel(hops []JumpHost, target string, timeout time.Duration) (*JumpTunnel, error) {
+	if len(hops) == 0 {
+		return nil, fmt.Errorf("no jump hosts for reaching %s", target)
+	}
+
+	t := &JumpTunnel{target: target}
+	for _, hop := range hops {
+		config, err := hop.getClientConfig(timeout)
+		if err != nil {
+			_ = t.Close()
+			return nil, err
+		}
+
+		address := HostPort{Host: hop.Address.Host, Port: hop.Address.Port}.String()
+		client, err := t.dialSSH(address, config, timeout)
+		if err != nil {
+			_ = t.Close()
+			return nil, fmt.Errorf("could not connect to the jump host %s: %s", hop.Address, err)
+		}
+		Debug("connected to jump host %s", hop.Address)
+		t.clients = append(t.clients, client)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		_ = t.Close()
+		return nil, err
+	}
+	t.listener = l
+	go t.serve()
+	return t, nil
+}
+
+// dialSSH opens a SSH connection to an address through the current chain
+func (t *JumpTunnel) dialSSH(address string, config *gossh.ClientConfig, timeout time.Duration) (*gossh.Client, error) {
+	if len(t.clients) == 0 {
+		return gossh.Dial("tcp", address, config)
+	}
+	conn, err := t.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	c, chans, reqs, err := gossh.NewClientConn(conn, address, config)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return gossh.NewClient(c, chans, reqs), nil
+}
+
+// Dial opens a connection to an address from the last jump host
+func (t *JumpTunnel) Dial(network, address string) (net.Conn, error) {
+	t.Lock()
+	if t.closed || len(t.clients) == 0 {
+		t.Unlock()
+		return nil, fmt.Errorf("the tunnel to %s is closed", t.target)
+	}
+	last := t.clients[len(t.clients)-1]
+	t.Unlock()
+	return last.Dial(network, address)
+}
+
+// Addr returns the local "host:port" address where connections are forwarded to the target
+func (t *JumpTunnel) Addr() *net.TCPAddr {
+	return t.listener.Addr().(*net.TCPAddr)
+}
+
+// serve forwards all the local connections to the target
+func (t *JumpTunnel) serve() {
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer local.Close()
+			remote, err := t.Dial("tcp", t.target)
+			if err != nil {
+				Debug("could not connect to %s through the jump hosts: %s", t.target, err)
+				return
+			}
+			defer remote.Close()
+
+			done := make(chan struct{}, 2)
+			go func() { _, _ = io.Copy(remote, local); done <- struct{}{} }()
+			go func() { _, _ = io.Copy(local, remote); done <- struct{}{} }()
+			<-done
+		}()
+	}
+}
+
+// Close closes the tunnel and all the connections to the jump hosts
+func (t *JumpTunnel) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	if t.listener != nil {
+		_ = t.listener.Close()
+	}
+	// (close the connections from the last one)
+	for i := len(t.clients) - 1; i >= 0; i-- {
+		_ = t.clients[i].Close()
+	}
+	return nil
+}
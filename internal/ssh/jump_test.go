@@ -0,0 +1,211 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// startTestJumpServer starts a SSH server that accepts any password and
+// forwards "direct-tcpip" channels
+func startTestJumpServer(t *testing.T, hostKey gossh.Signer) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(gossh.ConnMetadata, []byte) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	forward := func(newChan gossh.NewChannel) {
+		var req struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := gossh.Unmarshal(newChan.ExtraData(), &req); err != nil {
+			_ = newChan.Reject(gossh.ConnectionFailed, err.Error())
+			return
+		}
+		remote, err := net.Dial("tcp", HostPort{Host: req.Host, Port: int(req.Port)}.String())
+		if err != nil {
+			_ = newChan.Reject(gossh.ConnectionFailed, err.Error())
+			return
+		}
+		ch, reqs, err := newChan.Accept()
+		if err != nil {
+			_ = remote.Close()
+			return
+		}
+		go gossh.DiscardRequests(reqs)
+		go func() { _, _ = io.Copy(ch, remote); _ = ch.Close() }()
+		go func() { _, _ = io.Copy(remote, ch); _ = remote.Close() }()
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := gossh.NewServerConn(conn, config)
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+				go gossh.DiscardRequests(reqs)
+				for newChan := range chans {
+					if newChan.ChannelType() != "direct-tcpip" {
+						_ = newChan.Reject(gossh.UnknownChannelType, "unsupported")
+						continue
+					}
+					go forward(newChan)
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { _ = l.Close() }
+}
+
+// startTestEchoServer starts a server that echoes back every line
+func startTestEchoServer(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { _ = l.Close() }
+}
+
+func newTestJumpHost(t *testing.T, address string, hostKey gossh.Signer) JumpHost {
+	hp, err := ParseHostPort("someone@"+address, DefSSHPort)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	j := JumpHost{Address: hp, Password: "secret"}
+	if hostKey != nil {
+		j.HostKey = string(gossh.MarshalAuthorizedKey(hostKey.PublicKey()))
+	}
+	return j
+}
+
+func TestJumpTunnel(t *testing.T) {
+	target, stopEcho := startTestEchoServer(t)
+	defer stopEcho()
+
+	key1, key2 := newTestHostKey(t), newTestHostKey(t)
+	addr1, stop1 := startTestJumpServer(t, key1)
+	defer stop1()
+	addr2, stop2 := startTestJumpServer(t, key2)
+	defer stop2()
+
+	cases := map[string][]JumpHost{
+		"one hop":  {newTestJumpHost(t, addr1, key1)},
+		"two hops": {newTestJumpHost(t, addr1, key1), newTestJumpHost(t, addr2, nil)},
+	}
+	for name, hops := range cases {
+		tunnel, err := NewJumpTunnel(hops, target, 5*time.Second)
+		if err != nil {
+			t.Fatalf("%s: Error: %v", name, err)
+		}
+
+		conn, err := net.Dial("tcp", tunnel.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: Error: %v", name, err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := conn.Write([]byte("hello\n")); err != nil {
+			t.Fatalf("%s: Error: %v", name, err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: Error: %v", name, err)
+		}
+		if line != "hello\n" {
+			t.Fatalf("%s: Error: unexpected echo: %q", name, line)
+		}
+		_ = conn.Close()
+		_ = tunnel.Close()
+
+		if _, err := tunnel.Dial("tcp", target); err == nil {
+			t.Fatalf("%s: Error: could dial through a closed tunnel", name)
+		}
+	}
+
+	// the host key of the second hop does not match
+	hops := []JumpHost{newTestJumpHost(t, addr1, key1), newTestJumpHost(t, addr2, key1)}
+	if _, err := NewJumpTunnel(hops, target, 5*time.Second); err == nil {
+		t.Fatalf("Error: no error with a wrong host key")
+	} else if !strings.Contains(err.Error(), addr2) {
+		t.Fatalf("Error: the error does not mention the jump host: %v", err)
+	}
+
+	// jump hosts without a host key are verified like the node
+	dir, err := ioutil.TempDir("", "known-hosts")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	knownHosts := filepath.Join(dir, "known_hosts")
+
+	hop := newTestJumpHost(t, addr2, nil)
+	hop.Verification = HostKeyVerification{Mode: HostKeyStrict, KnownHostsFile: knownHosts}
+	if _, err := NewJumpTunnel([]JumpHost{hop}, target, 5*time.Second); err == nil {
+		t.Fatalf("Error: no error with an unknown jump host in strict mode")
+	}
+	hop.Verification.Mode = HostKeyAcceptNew
+	tunnel, err := NewJumpTunnel([]JumpHost{hop}, target, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Error: unknown jump host not accepted in accept-new mode: %v", err)
+	}
+	_ = tunnel.Close()
+	hop.Verification.Mode = HostKeyStrict
+	tunnel, err = NewJumpTunnel([]JumpHost{hop}, target, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Error: the key of the jump host was not recorded: %v", err)
+	}
+	_ = tunnel.Close()
+
+	// no credentials for the jump host
+	if _, err := NewJumpTunnel([]JumpHost{{Address: HostPort{Host: "127.0.0.1", Port: 22}}}, target, time.Second); err == nil {
+		t.Fatalf("Error: no error without credentials")
+	}
+}
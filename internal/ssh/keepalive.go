@@ -0,0 +1,198 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+const (
+	// DefKeepAliveInterval is the default interval between keepalives
+	DefKeepAliveInterval = 30 * time.Second
+
+	// DefKeepAliveMaxCount is the default number of keepalives that can
+	// fail before the connection is considered dead
+	DefKeepAliveMaxCount = 3
+
+	// command used for checking the connection is alive (when the
+	// communicator cannot send SSH keepalive requests)
+	keepAliveCommand = "true"
+
+	// SSH request used as keepalive (answered by OpenSSH servers with a failure,
+	// what is enough for knowing the connection is alive)
+	keepAliveRequest = "keepalive@openssh.com"
+)
+
+// KeepAlive is the configuration for the keepalives sent to the remote host
+type KeepAlive struct {
+	// Interval is the interval between keepalives
+	Interval time.Duration
+
+	// MaxCount is the number of consecutive keepalives without an answer
+	// before the connection is dropped (and reopened in the next command)
+	MaxCount int
+}
+
+// sessionRefresher is a communicator that can reopen its connection
+type sessionRefresher interface {
+	Refresh() error
+}
+
+// keepAliveSender is a communicator that can send SSH keepalive requests
+// in its connection, without opening a new session
+type keepAliveSender interface {
+	SendKeepAlive() error
+}
+
+// keepAliveCommunicator is a communicator that sends keepalives to the
+// remote host, so connections are not dropped by NATs or firewalls
+// while waiting for long-running commands
+type keepAliveCommunicator struct {
+	communicator.Communicator
+
+	keepAlive KeepAlive
+
+	// number of commands currently running
+	running int32
+
+	// mutex for refreshing the connection
+	sync.Mutex
+}
+
+// NewKeepAliveCommunicator wraps a communicator, sending keepalives
+// until the context is done
+func NewKeepAliveCommunicator(ctx context.Context, comm communicator.Communicator, keepAlive KeepAlive) communicator.Communicator {
+	if keepAlive.Interval <= 0 {
+		keepAlive.Interval = DefKeepAliveInterval
+	}
+	if keepAlive.MaxCount <= 0 {
+		keepAlive.MaxCount = DefKeepAliveMaxCount
+	}
+
+	c := &keepAliveCommunicator{
+		Communicator: comm,
+		keepAlive:    keepAlive,
+	}
+	go c.run(ctx)
+	return c
+}
+
+// run sends keepalives until the context is done
+func (c *keepAliveCommunicator) run(ctx context.Context) {
+	t := time.NewTicker(c.keepAlive.Interval)
+	defer t.Stop()
+
+	failed := 0
+	for {
+		select {
+		case <-t.C:
+			if err := c.ping(); err != nil {
+				failed++
+				Debug("keepalive failed (%d/%d): %s", failed, c.keepAlive.MaxCount, err)
+				if failed >= c.keepAlive.MaxCount {
+					// never drop the connection under a running command: it would be killed
+					if running := atomic.LoadInt32(&c.running); running > 0 {
+						Debug("too many keepalives failed, but %d commands are running: keeping the connection", running)
+						continue
+					}
+					// drop the connection: it will be reopened in the next session
+					Debug("too many keepalives failed: dropping the connection")
+					c.Lock()
+					_ = c.Communicator.Disconnect()
+					c.Unlock()
+					failed = 0
+				}
+				continue
+			}
+			failed = 0
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Start runs a command, keeping track of the commands running
+func (c *keepAliveCommunicator) Start(cmd *remote.Cmd) error {
+	if err := c.Communicator.Start(cmd); err != nil {
+		return err
+	}
+
+	atomic.AddInt32(&c.running, 1)
+	go func() {
+		_ = cmd.Wait()
+		atomic.AddInt32(&c.running, -1)
+	}()
+	return nil
+}
+
+// ping sends a SSH keepalive request (or runs a no-op command in the remote
+// host when that is not possible), waiting (at most) one keepalive interval
+// for the answer
+func (c *keepAliveCommunicator) ping() error {
+	c.Lock()
+	defer c.Unlock()
+
+	done := make(chan error, 1)
+	if sender, ok := c.Communicator.(keepAliveSender); ok {
+		go func() { done <- sender.SendKeepAlive() }()
+	} else {
+		cmd := &remote.Cmd{Command: keepAliveCommand}
+		if err := c.Communicator.Start(cmd); err != nil {
+			return err
+		}
+		go func() { done <- cmd.Wait() }()
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(c.keepAlive.Interval):
+		return fmt.Errorf("no answer after %s", c.keepAlive.Interval)
+	}
+}
+
+// Refresh closes the current connection and opens a new one
+func (c *keepAliveCommunicator) Refresh() error {
+	c.Lock()
+	defer c.Unlock()
+
+	_ = c.Communicator.Disconnect()
+	return c.Communicator.Connect(nil)
+}
+
+// DoRefreshSession reopens the connection to the remote host before some
+// long phase (ie, pulling images), so it does not start with a connection
+// that could be half-dead. It does nothing when keepalives are not enabled.
+func DoRefreshSession() Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		refresher, ok := GetCommFromContext(ctx).(sessionRefresher)
+		if !ok {
+			return nil
+		}
+
+		Debug("refreshing the connection to the remote host")
+		if err := withPacing(ctx, "connection", refresher.Refresh); err != nil {
+			return ActionError(fmt.Sprintf("could not refresh the connection to the remote host: %s", err))
+		}
+		return nil
+	})
+}
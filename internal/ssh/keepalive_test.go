@@ -0,0 +1,174 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+type testDeadCommunicator struct {
+	DummyCommunicator
+
+	connects    *int32
+	disconnects *int32
+}
+
+func (dc testDeadCommunicator) Start(cmd *remote.Cmd) error {
+	return errors.New("connection lost")
+}
+
+func (dc testDeadCommunicator) Connect(terraform.UIOutput) error {
+	atomic.AddInt32(dc.connects, 1)
+	return nil
+}
+
+func (dc testDeadCommunicator) Disconnect() error {
+	atomic.AddInt32(dc.disconnects, 1)
+	return nil
+}
+
+func TestKeepAliveCommunicator(t *testing.T) {
+	var connects, disconnects int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dead := testDeadCommunicator{connects: &connects, disconnects: &disconnects}
+	comm := NewKeepAliveCommunicator(ctx, dead, KeepAlive{Interval: 10 * time.Millisecond, MaxCount: 2})
+
+	// the connection must be dropped after two failed keepalives
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&disconnects) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Error: connection not dropped after failed keepalives")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// refreshing the session reopens the connection
+	actx := NewTestingContextWithCommunicator(comm)
+	if res := DoRefreshSession().Apply(actx); IsError(res) {
+		t.Fatalf("Error: when refreshing the session: %s", res)
+	}
+	if atomic.LoadInt32(&connects) != 1 {
+		t.Fatalf("Error: connection not reopened: %d connects", connects)
+	}
+
+	// nothing is done for regular communicators
+	if res := DoRefreshSession().Apply(NewTestingContextWithCommunicator(dead)); IsError(res) {
+		t.Fatalf("Error: when refreshing the session: %s", res)
+	}
+	if atomic.LoadInt32(&connects) != 1 {
+		t.Fatalf("Error: unexpected connection: %d connects", connects)
+	}
+}
+
+// testBusyCommunicator is a communicator where the keepalives fail while
+// a long-running command is running
+type testBusyCommunicator struct {
+	testDeadCommunicator
+
+	release chan struct{}
+}
+
+func (dc testBusyCommunicator) Start(cmd *remote.Cmd) error {
+	if cmd.Command == keepAliveCommand {
+		return errors.New("no answer")
+	}
+	cmd.Init()
+	go func() {
+		<-dc.release
+		cmd.SetExitStatus(0, nil)
+	}()
+	return nil
+}
+
+func TestKeepAliveCommunicatorWithRunningCommand(t *testing.T) {
+	var connects, disconnects int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	busy := testBusyCommunicator{
+		testDeadCommunicator: testDeadCommunicator{connects: &connects, disconnects: &disconnects},
+		release:              make(chan struct{}),
+	}
+	comm := NewKeepAliveCommunicator(ctx, busy, KeepAlive{Interval: 10 * time.Millisecond, MaxCount: 2})
+
+	cmd := &remote.Cmd{Command: "kubeadm upgrade apply"}
+	if err := comm.Start(cmd); err != nil {
+		t.Fatalf("Error: when starting the command: %s", err)
+	}
+
+	// the connection must not be dropped while the command is running
+	time.Sleep(100 * time.Millisecond)
+	if atomic.LoadInt32(&disconnects) != 0 {
+		t.Fatalf("Error: connection dropped under a running command")
+	}
+
+	// ... but it is dropped once the command has finished
+	close(busy.release)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Error: when running the command: %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&disconnects) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Error: connection not dropped after failed keepalives")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// testKeepAliveSenderCommunicator is a communicator that can send SSH keepalive requests
+type testKeepAliveSenderCommunicator struct {
+	testDeadCommunicator
+
+	keepAlives *int32
+}
+
+func (dc testKeepAliveSenderCommunicator) SendKeepAlive() error {
+	atomic.AddInt32(dc.keepAlives, 1)
+	return nil
+}
+
+func TestKeepAliveCommunicatorWithKeepAliveRequests(t *testing.T) {
+	var connects, disconnects, keepAlives int32
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sender := testKeepAliveSenderCommunicator{
+		testDeadCommunicator: testDeadCommunicator{connects: &connects, disconnects: &disconnects},
+		keepAlives:           &keepAlives,
+	}
+	_ = NewKeepAliveCommunicator(ctx, sender, KeepAlive{Interval: 10 * time.Millisecond, MaxCount: 2})
+
+	// keepalive requests are sent (instead of commands, that would fail)
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&keepAlives) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Error: no keepalive requests sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadInt32(&disconnects) != 0 {
+		t.Fatalf("Error: connection dropped with keepalive requests answered")
+	}
+}
@@ -16,8 +16,12 @@ package ssh
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"strings"
 )
@@ -28,6 +32,9 @@ const (
 
 	// the key in the cache for where we store the remote kubeconfig path
 	remoteKubeconfigPathKey = "remote-kubeconfig"
+
+	// script for dumping a manifest that could not be applied (the manifest is in "$1")
+	failedManifestScript = `echo 'Failed to apply kubernetes manifest:' && cat "$1"`
 )
 
 // Manifest represents a manifest, that can be a local file name, a remote URL or inlined
@@ -35,6 +42,10 @@ type Manifest struct {
 	Path   string
 	URL    string
 	Inline string
+
+	// SHA256 is the pinned checksum of the contents of the URL. When not empty,
+	// the URL is downloaded and verified locally, and the verified contents are uploaded.
+	SHA256 string
 }
 
 // NewManifest creates a new manifest
@@ -81,6 +92,33 @@ func (m *Manifest) ReplaceConfig(config map[string]interface{}) error {
 	return nil
 }
 
+// downloadManifest downloads a manifest from a URL in the local machine,
+// checking the SHA256 of the contents
+func downloadManifest(ctx context.Context, u string, checksum string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s failed with status %q", u, resp.Status)
+	}
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(contents)
+	if got := hex.EncodeToString(sum[:]); got != strings.ToLower(checksum) {
+		return nil, fmt.Errorf("checksum mismatch: expected %s, got %s", strings.ToLower(checksum), got)
+	}
+	return contents, nil
+}
+
 // isValidURL tests a string to determine if it is a url or not.
 func isValidURL(toTest string) bool {
 	_, err := url.ParseRequestURI(toTest)
@@ -149,14 +187,14 @@ func doSetupRemoteKubeconfig(kubeconfig string) Action {
 					DoMessageDebug("Using kubeconfig from %q", DefAdminKubeconfig),
 					DoSetInCache(remoteKubeconfigPathKey, DefAdminKubeconfig),
 				},
-				ActionFunc(func(context.Context) Action {
+				ActionFunc(func(ctx context.Context) Action {
 					// delay the kubeconfig check:
 					if kubeconfig == "" {
 						return ActionError("no kubeconfig provided, and no remote admin.conf found")
 					}
 
 					// upload the local kubeconfig to some temporary remote file
-					remoteKubeconfig, err := GetTempFilename()
+					remoteKubeconfig, err := GetRemoteTempFile(ctx)
 					if err != nil {
 						return ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
 					}
@@ -178,8 +216,6 @@ func doSetupRemoteKubeconfig(kubeconfig string) Action {
 // DoRemoteKubectl runs a remote kubectl command in a remote machine
 // it takes care about uploading a valid kubeconfig file if not present in the remote machine
 func DoRemoteKubectl(kubectl string, kubeconfig string, args ...string) Action {
-	argsStr := strings.Join(args, " ")
-
 	return ActionList{
 		doSetupRemoteKubeconfig(kubeconfig),
 		ActionFunc(func(ctx context.Context) Action {
@@ -187,7 +223,7 @@ func DoRemoteKubectl(kubectl string, kubeconfig string, args ...string) Action {
 			return DoRetry(
 				Retry{Times: 3},
 				ActionList{
-					DoExec(fmt.Sprintf("%s --kubeconfig=%s %s", kubectl, getKubeconfigFromCache(ctx), argsStr)),
+					DoExecCommand(NewCommand(kubectl, append([]string{"--kubeconfig=" + getKubeconfigFromCache(ctx)}, args...)...)),
 				})
 		}),
 	}
@@ -195,39 +231,55 @@ func DoRemoteKubectl(kubectl string, kubeconfig string, args ...string) Action {
 
 // DoRemoteKubectlApply applies some manifests with a remote kubectl
 // manifests can be 1) a local file 2) a URL 3) in a string
+// URLs with a pinned SHA256 are verified before being applied
 func DoRemoteKubectlApply(kubectl string, kubeconfig string, manifests []Manifest) Action {
 	actions := ActionList{}
 	for _, manifest := range manifests {
-		remoteManifest, err := GetTempFilename()
-		if err != nil {
-			return ActionError(fmt.Sprintf("Could not get a temporary filename: %s", err))
-		}
-
-		uploadAndKubectl := func(uploader Action) ActionFunc {
-			return func(context.Context) Action {
+		uploadAndKubectl := func(upload func(remoteManifest string) Action) Action {
+			return DoWithTempFilename(func(remoteManifest string) Action {
 				return DoWithCleanup(
 					ActionList{
-						uploader,
+						upload(remoteManifest),
 						DoWithException(
 							// we must use "validate=false" because we don'tt kow if the
 							// remote "kubectl" matches the API server deployed
 							DoRemoteKubectl(kubectl, kubeconfig, "apply", "--validate=false", "-f", remoteManifest),
-							DoExec(fmt.Sprintf("echo 'Failed to apply kubernetes manifest:' && cat %s", remoteManifest))),
+							DoExecCommand(NewShellCommand(failedManifestScript, remoteManifest))),
 					},
 					ActionList{
 						DoTry(DoDeleteFile(remoteManifest)),
 					})
-			}
+			})
 		}
 
 		switch {
 		case manifest.Inline != "":
+			contents := []byte(manifest.Inline)
 			actions = append(actions,
-				uploadAndKubectl(DoUploadBytesToFile([]byte(manifest.Inline), remoteManifest)))
+				uploadAndKubectl(func(remoteManifest string) Action {
+					return DoUploadBytesToFile(contents, remoteManifest)
+				}))
 
 		case manifest.Path != "":
+			path := manifest.Path
 			actions = append(actions,
-				uploadAndKubectl(DoUploadFileToFile(manifest.Path, remoteManifest)))
+				uploadAndKubectl(func(remoteManifest string) Action {
+					return DoUploadFileToFile(path, remoteManifest)
+				}))
+
+		case manifest.URL != "" && manifest.SHA256 != "":
+			// it is a pinned URL: download and verify it here, and upload the verified contents
+			u, checksum := manifest.URL, manifest.SHA256
+			actions = append(actions,
+				ActionFunc(func(ctx context.Context) Action {
+					contents, err := downloadManifest(ctx, u, checksum)
+					if err != nil {
+						return ActionError(fmt.Sprintf("could not verify manifest %q: %s", u, err))
+					}
+					return uploadAndKubectl(func(remoteManifest string) Action {
+						return DoUploadBytesToFile(contents, remoteManifest)
+					})
+				}))
 
 		case manifest.URL != "":
 			// it is an URL: just run the `kubectl apply`
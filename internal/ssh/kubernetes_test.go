@@ -0,0 +1,99 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDoRemoteKubectlApplyPinnedURL(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: test\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, manifest)
+	}))
+	defer server.Close()
+
+	sum := sha256.Sum256([]byte(manifest))
+	checksum := hex.EncodeToString(sum[:])
+
+	apply := func(m Manifest) (*ExecutionManifest, map[string]string, Action) {
+		ctx, uploads := NewTestingContextForUploads([]string{})
+		em := NewExecutionManifest(Host{Address: "10.0.0.1"})
+		ctx = WithExecutionManifest(ctx, em)
+		res := ActionList{
+			DoSetInCache(remoteKubeconfigPathKey, "/tmp/kubeconfig"),
+			DoRemoteKubectlApply("kubectl", "", []Manifest{m}),
+		}.Apply(ctx)
+		return em, *uploads, res
+	}
+
+	// the verified contents are uploaded and applied, the URL is never used in the remote machine
+	em, uploads, res := apply(Manifest{URL: server.URL, SHA256: strings.ToUpper(checksum)})
+	if IsError(res) {
+		t.Fatalf("Error: when applying a pinned manifest: %s", res)
+	}
+	found := false
+	for _, contents := range uploads {
+		if contents == manifest {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: verified manifest not uploaded: %+v", uploads)
+	}
+	for _, e := range em.Entries {
+		if strings.Contains(e.Command, server.URL) {
+			t.Fatalf("Error: URL used in a remote command: %q", e.Command)
+		}
+	}
+
+	// a checksum mismatch aborts before anything is applied
+	em, uploads, res = apply(Manifest{URL: server.URL, SHA256: strings.Repeat("0", 64)})
+	if !IsError(res) {
+		t.Fatalf("Error: manifest with a wrong checksum was applied")
+	}
+	if !strings.Contains(res.Error(), "checksum mismatch") {
+		t.Fatalf("Error: unexpected error: %s", res)
+	}
+	if len(uploads) > 0 {
+		t.Fatalf("Error: unverified manifest uploaded: %+v", uploads)
+	}
+	for _, e := range em.Entries {
+		if strings.Contains(e.Command, "apply") {
+			t.Fatalf("Error: unverified manifest applied: %q", e.Command)
+		}
+	}
+
+	// without a checksum, the URL is applied in the remote machine
+	em, _, res = apply(Manifest{URL: server.URL})
+	if IsError(res) {
+		t.Fatalf("Error: when applying a manifest: %s", res)
+	}
+	found = false
+	for _, e := range em.Entries {
+		if strings.Contains(e.Command, "-f "+server.URL) {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: URL not applied: %+v", em.Entries)
+	}
+}
@@ -0,0 +1,376 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefClusterLockName is the default name of the Lease used as a coordination lock
+	DefClusterLockName = "terraform-provider-kubeadm"
+
+	// DefClusterLockNamespace is the default namespace of the Lease used as a coordination lock
+	DefClusterLockNamespace = "kube-system"
+
+	// DefClusterLockTimeout is the default time we wait for the coordination lock
+	DefClusterLockTimeout = 30 * time.Minute
+
+	// DefClusterLockDuration is the default time after which a coordination lock
+	// is considered stale (ie, the Terraform run holding it was killed)
+	DefClusterLockDuration = 30 * time.Minute
+
+	// interval between attempts for acquiring the coordination lock
+	clusterLockInterval = 10 * time.Second
+
+	// format of the times in a Lease
+	leaseTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	// leaseRenewScript renews a Lease, but only if it is still held by some holder
+	// ($1: kubectl, $2: kubeconfig, $3: namespace, $4: name, $5: holder, $6: patch)
+	leaseRenewScript = `[ "$("$1" --kubeconfig="$2" -n "$3" get lease "$4" -o jsonpath='{.spec.holderIdentity}')" = "$5" ] && "$1" --kubeconfig="$2" -n "$3" patch lease "$4" --type=merge -p "$6"`
+
+	// leaseReleaseScript deletes a Lease, but only if it is still held by some holder
+	// ($1: kubectl, $2: kubeconfig, $3: namespace, $4: name, $5: holder)
+	leaseReleaseScript = `[ "$("$1" --kubeconfig="$2" -n "$3" get lease "$4" -o jsonpath='{.spec.holderIdentity}')" != "$5" ] || "$1" --kubeconfig="$2" -n "$3" delete lease "$4"`
+)
+
+// ClusterLock is a lock shared by all the Terraform runs that manage the same
+// cluster (ie, different workspaces for different node pools), implemented
+// with a Lease object in the API server
+type ClusterLock struct {
+	// Name and Namespace of the Lease
+	Name      string
+	Namespace string
+
+	// Holder is the identity of this run (ie, the local hostname and the run ID)
+	Holder string
+
+	// Timeout is the maximum time we wait for acquiring the lock
+	Timeout time.Duration
+
+	// Duration is the time after which the lock is considered stale
+	Duration time.Duration
+}
+
+// leaseState is the (partial) state of the Lease in the API server
+type leaseState struct {
+	ResourceVersion string
+	Holder          string
+	RenewTime       time.Time
+	Duration        time.Duration
+}
+
+// expired returns true if the Lease has not been renewed for its duration
+func (ls leaseState) expired(now time.Time) bool {
+	return !ls.RenewTime.IsZero() && ls.RenewTime.Add(ls.Duration).Before(now)
+}
+
+// parseLeaseState parses the line printed by getLeaseStateArgs
+func parseLeaseState(line string) (leaseState, error) {
+	fields := strings.SplitN(strings.TrimSpace(line), " ", 5)
+	if len(fields) != 5 || fields[0] != "lease" {
+		return leaseState{}, fmt.Errorf("unexpected Lease state: %q", line)
+	}
+
+	ls := leaseState{ResourceVersion: fields[1], Holder: fields[4]}
+	if fields[2] != "" {
+		t, err := time.Parse(leaseTimeFormat, fields[2])
+		if err != nil {
+			return leaseState{}, fmt.Errorf("unexpected renew time in Lease: %q", fields[2])
+		}
+		ls.RenewTime = t
+	}
+	if fields[3] != "" {
+		secs, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return leaseState{}, fmt.Errorf("unexpected duration in Lease: %q", fields[3])
+		}
+		ls.Duration = time.Duration(secs) * time.Second
+	}
+	return ls, nil
+}
+
+// getLeaseManifest returns the manifest of the Lease held by `holder`. With a
+// `resourceVersion`, the manifest can only replace that version of the Lease.
+func (lock ClusterLock) getLeaseManifest(holder string, resourceVersion string, now time.Time) []byte {
+	metadata := map[string]interface{}{
+		"name":      lock.Name,
+		"namespace": lock.Namespace,
+	}
+	if resourceVersion != "" {
+		metadata["resourceVersion"] = resourceVersion
+	}
+	manifest := map[string]interface{}{
+		"apiVersion": "coordination.k8s.io/v1",
+		"kind":       "Lease",
+		"metadata":   metadata,
+		"spec": map[string]interface{}{
+			"holderIdentity":       holder,
+			"leaseDurationSeconds": int(lock.Duration / time.Second),
+			"acquireTime":          now.UTC().Format(leaseTimeFormat),
+			"renewTime":            now.UTC().Format(leaseTimeFormat),
+		},
+	}
+	contents, _ := json.Marshal(manifest)
+	return contents
+}
+
+// getLeaseRenewPatch returns the (merge) patch for renewing the Lease
+func getLeaseRenewPatch(now time.Time) string {
+	patch, _ := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"renewTime": now.UTC().Format(leaseTimeFormat),
+		},
+	})
+	return string(patch)
+}
+
+// getLeaseStateArgs returns the kubectl arguments for printing the state of the Lease
+func (lock ClusterLock) getLeaseStateArgs() []string {
+	return []string{"-n", lock.Namespace, "get", "lease", lock.Name,
+		"-o", "jsonpath=lease {.metadata.resourceVersion} {.spec.renewTime} {.spec.leaseDurationSeconds} {.spec.holderIdentity}"}
+}
+
+// getLockHolder returns the identity of this cluster scope in the coordination locks: all the
+// nodes that share the scope share the locks too
+func (cs *clusterScope) getLockHolder(base string) string {
+	cs.Lock()
+	defer cs.Unlock()
+
+	if cs.lockHolder == "" {
+		b := make([]byte, 4)
+		_, _ = rand.Read(b)
+		cs.lockHolder = base + "-" + hex.EncodeToString(b)
+	}
+	return cs.lockHolder
+}
+
+// addLockRef adds `n` references to a coordination lock, returning the current number
+func (cs *clusterScope) addLockRef(key string, n int) int {
+	cs.Lock()
+	defer cs.Unlock()
+
+	cs.lockRefs[key] += n
+	return cs.lockRefs[key]
+}
+
+// doAcquireClusterLock waits until the Lease can be created (or replaced, when stale)
+func doAcquireClusterLock(kubectl string, lock ClusterLock, holder string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		kubeconfig := getKubeconfigFromCache(ctx)
+		kubectlCmd := NewCommand(kubectl, "--kubeconfig="+kubeconfig)
+		deadline := time.Now().Add(lock.Timeout)
+
+		// try to create (or replace) the Lease from a temporary file
+		tryManifest := func(verb string, manifest []byte) (bool, error) {
+			var acquired bool
+			var err error
+			res := DoWithTempFilename(func(filename string) Action {
+				return ActionList{
+					DoUploadBytesToFile(manifest, filename),
+					ActionFunc(func(ctx context.Context) Action {
+						acquired, err = CheckExecCommand(kubectlCmd.WithArgs(verb, "-f", filename)).Check(ctx)
+						return nil
+					}),
+				}
+			}).Apply(ctx)
+			if IsError(res) {
+				return false, res
+			}
+			return acquired, err
+		}
+
+		failures := 0
+		for {
+			acquired, err := tryManifest("create", lock.getLeaseManifest(holder, "", time.Now()))
+			if err != nil {
+				return ActionError(fmt.Sprintf("could not create the coordination lock: %s", err))
+			}
+			if acquired {
+				return DoMessageInfo("Coordination lock %s/%s acquired", lock.Namespace, lock.Name)
+			}
+
+			// the Lease exists: check who holds it
+			line := ""
+			res := DoSendingExecOutputToFunc(
+				DoExecCommand(kubectlCmd.WithArgs(lock.getLeaseStateArgs()...)),
+				func(s string) {
+					if i := strings.Index(s, "lease "); i >= 0 {
+						line = s[i:]
+					}
+				}).Apply(ctx)
+			if IsError(res) || line == "" {
+				// (the Lease could have been deleted right after trying to create it)
+				if failures++; failures >= 3 {
+					reason := "no Lease found"
+					if IsError(res) {
+						reason = res.Error()
+					}
+					return ActionError(fmt.Sprintf("could not create or get the coordination lock %s/%s: %s",
+						lock.Namespace, lock.Name, reason))
+				}
+			} else {
+				failures = 0
+				ls, err := parseLeaseState(line)
+				if err != nil {
+					return ActionError(err.Error())
+				}
+				switch {
+				case ls.Holder == holder:
+					return DoMessageInfo("Coordination lock %s/%s already held by this run", lock.Namespace, lock.Name)
+
+				case ls.expired(time.Now()):
+					// replace the stale Lease (only if nobody has replaced it in the meantime)
+					_ = DoMessageWarn("Coordination lock held by %q is stale (since %s): taking it over",
+						ls.Holder, ls.RenewTime.Add(ls.Duration).Format(time.RFC3339)).Apply(ctx)
+					acquired, err := tryManifest("replace", lock.getLeaseManifest(holder, ls.ResourceVersion, time.Now()))
+					if err != nil {
+						return ActionError(fmt.Sprintf("could not replace the coordination lock: %s", err))
+					}
+					if acquired {
+						return DoMessageInfo("Coordination lock %s/%s acquired", lock.Namespace, lock.Name)
+					}
+					continue
+
+				default:
+					_ = DoMessageInfo("Waiting for the coordination lock %s/%s held by %q...",
+						lock.Namespace, lock.Name, ls.Holder).Apply(ctx)
+				}
+			}
+
+			if time.Now().After(deadline) {
+				return ActionError(fmt.Sprintf("timeout: could not acquire the coordination lock %s/%s after %s",
+					lock.Namespace, lock.Name, lock.Timeout))
+			}
+			select {
+			case <-time.After(clusterLockInterval):
+			case <-ctx.Done():
+				return getInterruptedError(ctx)
+			}
+		}
+	})
+}
+
+// doReleaseClusterLock deletes the Lease (only if it is still held by `holder`)
+func doReleaseClusterLock(kubectl string, lock ClusterLock, holder string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		return ActionList{
+			DoExecCommand(NewShellCommand(leaseReleaseScript,
+				kubectl, getKubeconfigFromCache(ctx), lock.Namespace, lock.Name, holder)),
+			DoMessageInfo("Coordination lock %s/%s released", lock.Namespace, lock.Name),
+		}
+	})
+}
+
+// doRenewClusterLock renews the Lease (only if it is still held by `holder`)
+func doRenewClusterLock(kubectl string, lock ClusterLock, holder string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		return DoExecCommand(NewShellCommand(leaseRenewScript,
+			kubectl, getKubeconfigFromCache(ctx), lock.Namespace, lock.Name, holder, getLeaseRenewPatch(time.Now())))
+	})
+}
+
+// startClusterLockRenewal renews the Lease periodically (three times per Lease duration),
+// so it does not become stale while the action holding it is running. It returns a
+// function that stops the renewals.
+func startClusterLockRenewal(ctx context.Context, kubectl string, lock ClusterLock, holder string) func() {
+	interval := lock.Duration / 3
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				if res := (ActionList{doRenewClusterLock(kubectl, lock, holder)}).Apply(ctx); IsError(res) {
+					Debug("could not renew the coordination lock %s/%s: %s", lock.Namespace, lock.Name, res)
+				}
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// DoWithClusterLock runs some action while holding a coordination lock shared by all the
+// Terraform runs that manage the cluster, so simultaneous runs do not race when creating
+// tokens or upgrading nodes. The lock is a Lease in the API server, created and deleted
+// with the remote kubectl, and it is renewed while the action is running. All the nodes in
+// the same cluster scope (see WithClusterID) share the lock, so they are not serialized.
+func DoWithClusterLock(kubectl string, kubeconfig string, lock ClusterLock, action Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "acquire the coordination lock %s/%s", lock.Namespace, lock.Name)
+			return action
+		}
+
+		cs := getSSHContext(ctx).cluster
+		holder := cs.getLockHolder(lock.Holder)
+		key := "cluster-lock:" + lock.Namespace + "/" + lock.Name
+
+		// only the first node in the scope acquires the lock: the others just add a reference
+		l := cs.lockFor(key)
+		l.Lock()
+		if cs.addLockRef(key, 0) == 0 {
+			res := ActionList{
+				doSetupRemoteKubeconfig(kubeconfig),
+				doAcquireClusterLock(kubectl, lock, holder),
+			}.Apply(ctx)
+			if IsError(res) {
+				l.Unlock()
+				return res
+			}
+		}
+		cs.addLockRef(key, 1)
+		l.Unlock()
+
+		stopRenewal := startClusterLockRenewal(ctx, kubectl, lock, holder)
+		return DoWithCleanup(
+			action,
+			ActionFunc(func(ctx context.Context) Action {
+				stopRenewal()
+				l.Lock()
+				defer l.Unlock()
+				if cs.addLockRef(key, -1) > 0 {
+					return nil
+				}
+				return ActionList{
+					doSetupRemoteKubeconfig(kubeconfig),
+					doReleaseClusterLock(kubectl, lock, holder),
+				}
+			}))
+	})
+}
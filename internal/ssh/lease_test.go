@@ -0,0 +1,209 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+// testLeaseCommunicator simulates a kubectl managing a Lease
+type testLeaseCommunicator struct {
+	DummyCommunicator
+
+	sync.Mutex
+	lastUpload      string
+	resourceVersion int
+	holder          string
+	renewTime       string
+	creates         int
+	renews          int
+}
+
+func (tc *testLeaseCommunicator) Upload(dst string, r io.Reader) error {
+	all, _ := ioutil.ReadAll(r)
+	tc.Lock()
+	defer tc.Unlock()
+	tc.lastUpload = string(all)
+	return nil
+}
+
+func (tc *testLeaseCommunicator) Start(cmd *remote.Cmd) error {
+	tc.Lock()
+	defer tc.Unlock()
+	cmd.Init()
+
+	var lease struct {
+		Metadata struct {
+			ResourceVersion string `json:"resourceVersion"`
+		} `json:"metadata"`
+		Spec struct {
+			Holder    string `json:"holderIdentity"`
+			RenewTime string `json:"renewTime"`
+		} `json:"spec"`
+	}
+	result := func(ok bool) {
+		if ok {
+			cmd.Stdout.Write([]byte("CONDITION_SUCCEEDED\n"))
+		} else {
+			cmd.Stdout.Write([]byte("CONDITION_FAILED\n"))
+		}
+	}
+
+	switch {
+	case strings.Contains(cmd.Command, "mktemp "):
+		cmd.Stdout.Write([]byte("/tmp/lease.json\n"))
+	case strings.Contains(cmd.Command, "sha256sum "):
+		sum := sha256.Sum256([]byte(tc.lastUpload))
+		cmd.Stdout.Write([]byte(hex.EncodeToString(sum[:]) + "  file\n"))
+	case strings.Contains(cmd.Command, " create -f "):
+		tc.creates++
+		_ = json.Unmarshal([]byte(tc.lastUpload), &lease)
+		ok := tc.holder == ""
+		if ok {
+			tc.resourceVersion++
+			tc.holder, tc.renewTime = lease.Spec.Holder, lease.Spec.RenewTime
+		}
+		result(ok)
+	case strings.Contains(cmd.Command, " replace -f "):
+		_ = json.Unmarshal([]byte(tc.lastUpload), &lease)
+		ok := tc.holder != "" && lease.Metadata.ResourceVersion == fmt.Sprintf("%d", tc.resourceVersion)
+		if ok {
+			tc.resourceVersion++
+			tc.holder, tc.renewTime = lease.Spec.Holder, lease.Spec.RenewTime
+		}
+		result(ok)
+	case strings.Contains(cmd.Command, " delete lease "):
+		if strings.HasSuffix(cmd.Command, " "+tc.holder) {
+			tc.holder = ""
+		}
+	case strings.Contains(cmd.Command, " patch lease "):
+		if tc.holder != "" && strings.Contains(cmd.Command, tc.holder) {
+			tc.renews++
+			tc.renewTime = time.Now().UTC().Format(leaseTimeFormat)
+		}
+	case strings.Contains(cmd.Command, " get lease "):
+		if tc.holder == "" {
+			cmd.SetExitStatus(1, nil)
+			return nil
+		}
+		cmd.Stdout.Write([]byte(fmt.Sprintf("lease %d %s 60 %s\n", tc.resourceVersion, tc.renewTime, tc.holder)))
+	default:
+		result(true)
+	}
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func TestParseLeaseState(t *testing.T) {
+	ls, err := parseLeaseState("lease 123 2019-10-23T10:15:12.000000Z 60 some host/run 1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if ls.ResourceVersion != "123" || ls.Holder != "some host/run 1" || ls.Duration != time.Minute {
+		t.Fatalf("Error: unexpected state: %+v", ls)
+	}
+	if !ls.expired(time.Date(2019, 10, 23, 10, 16, 13, 0, time.UTC)) || ls.expired(time.Date(2019, 10, 23, 10, 16, 11, 0, time.UTC)) {
+		t.Fatalf("Error: unexpected expiration for %+v", ls)
+	}
+	if _, err := parseLeaseState("some noise"); err == nil {
+		t.Fatalf("Error: no error for an invalid state")
+	}
+}
+
+func TestDoWithClusterLock(t *testing.T) {
+	comm := &testLeaseCommunicator{}
+	ctx := NewTestingContextWithCommunicator(comm)
+	ctx = WithClusterID(ctx, "test-lease-cluster")
+	lock := ClusterLock{Name: "test", Namespace: "kube-system", Holder: "tester", Timeout: time.Minute, Duration: time.Minute}
+
+	// the lock is held (and shared by nodes in the same cluster scope) while running the action
+	holders := []string{}
+	inner := ActionFunc(func(ctx context.Context) Action {
+		comm.Lock()
+		holders = append(holders, comm.holder)
+		comm.Unlock()
+		return nil
+	})
+	action := DoWithClusterLock("kubectl", "", lock, ActionList{inner, DoWithClusterLock("kubectl", "", lock, inner)})
+	if res := (ActionList{action}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+	if len(holders) != 2 || !strings.HasPrefix(holders[0], "tester-") || holders[1] != holders[0] {
+		t.Fatalf("Error: unexpected holders while running the action: %q", holders)
+	}
+	if comm.creates != 1 {
+		t.Fatalf("Error: the Lease was created %d times", comm.creates)
+	}
+	if comm.holder != "" {
+		t.Fatalf("Error: the lock was not released: %q", comm.holder)
+	}
+
+	// a stale lock held by someone else is taken over
+	comm.holder = "someone-else"
+	comm.renewTime = time.Now().Add(-time.Hour).UTC().Format(leaseTimeFormat)
+	holders = []string{}
+	if res := (ActionList{DoWithClusterLock("kubectl", "", lock, inner)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+	if len(holders) != 1 || !strings.HasPrefix(holders[0], "tester-") {
+		t.Fatalf("Error: the stale lock was not taken over: %q", holders)
+	}
+}
+
+func TestDoWithClusterLockRenewal(t *testing.T) {
+	comm := &testLeaseCommunicator{}
+	ctx := NewTestingContextWithCommunicator(comm)
+	ctx = WithClusterID(ctx, "test-lease-renewal-cluster")
+	lock := ClusterLock{Name: "test", Namespace: "kube-system", Holder: "tester", Timeout: time.Minute, Duration: 30 * time.Millisecond}
+
+	// the Lease is renewed while the (long) action is running
+	inner := ActionFunc(func(ctx context.Context) Action {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+	if res := (ActionList{DoWithClusterLock("kubectl", "", lock, inner)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+
+	comm.Lock()
+	defer comm.Unlock()
+	if comm.renews == 0 {
+		t.Fatalf("Error: the Lease was not renewed")
+	}
+	if comm.holder != "" {
+		t.Fatalf("Error: the lock was not released: %q", comm.holder)
+	}
+
+	// no more renewals after releasing the lock
+	renews := comm.renews
+	comm.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	comm.Lock()
+	if comm.renews != renews {
+		t.Fatalf("Error: the Lease was renewed after releasing it")
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleField is the range of values of a field in a schedule
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var scheduleFields = []scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Schedule is a cron-like schedule ("minute hour day-of-month month day-of-week"),
+// with the usual "*", lists ("1,3"), ranges ("1-5") and steps ("*/15")
+type Schedule struct {
+	spec   string
+	fields [5]map[int]bool
+
+	// true when the day of month or the day of week is not "*": when both are
+	// restricted, a day matches when any of them matches (like in cron)
+	domRestricted bool
+	dowRestricted bool
+}
+
+// ParseSchedule parses a cron-like schedule
+func ParseSchedule(spec string) (*Schedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(scheduleFields) {
+		return nil, fmt.Errorf("invalid schedule %q: expected %d fields", spec, len(scheduleFields))
+	}
+
+	s := &Schedule{spec: spec}
+	for i, part := range parts {
+		values, err := parseScheduleField(part, scheduleFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+		}
+		s.fields[i] = values
+	}
+	// (Sunday can be 0 or 7)
+	if s.fields[4][7] {
+		s.fields[4][0] = true
+	}
+	s.domRestricted = parts[2] != "*"
+	s.dowRestricted = parts[4] != "*"
+	return s, nil
+}
+
+// parseScheduleField parses a field in a schedule, returning the values it matches
+func parseScheduleField(s string, field scheduleField) (map[int]bool, error) {
+	res := map[int]bool{}
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in the %s: %q", field.name, item)
+			}
+			step = n
+			item = item[:i]
+		}
+
+		first, last := field.min, field.max
+		if item != "*" {
+			bounds := strings.SplitN(item, "-", 2)
+			var err error
+			if first, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s: %q", field.name, item)
+			}
+			last = first
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid %s: %q", field.name, item)
+				}
+			} else if step > 1 {
+				last = field.max
+			}
+		}
+		if first < field.min || last > field.max || first > last {
+			return nil, fmt.Errorf("%s out of range (%d-%d): %q", field.name, field.min, field.max, item)
+		}
+
+		for v := first; v <= last; v += step {
+			res[v] = true
+		}
+	}
+	return res, nil
+}
+
+// Matches returns true if the schedule matches some time (with a minute resolution)
+func (s *Schedule) Matches(t time.Time) bool {
+	if !s.fields[0][t.Minute()] || !s.fields[1][t.Hour()] || !s.fields[3][int(t.Month())] {
+		return false
+	}
+	dom := s.fields[2][t.Day()]
+	dow := s.fields[4][int(t.Weekday())]
+	if s.domRestricted && s.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// MaintenanceWindow is a recurring period of time where disruptive operations
+// (ie, upgrades or reboots) can be started
+type MaintenanceWindow struct {
+	// Schedule is when the windows start
+	Schedule *Schedule
+
+	// Duration is the length of every window
+	Duration time.Duration
+
+	// Location is the timezone for the schedule
+	Location *time.Location
+
+	// MaxWait is the time we can wait for the next window (or 0 for failing immediately)
+	MaxWait time.Duration
+}
+
+// NewMaintenanceWindow creates a maintenance window from a cron-like schedule
+// and a timezone (the local time when empty)
+func NewMaintenanceWindow(schedule string, duration time.Duration, timezone string, maxWait time.Duration) (*MaintenanceWindow, error) {
+	s, err := ParseSchedule(schedule)
+	if err != nil {
+		return nil, err
+	}
+	if duration < time.Minute {
+		return nil, fmt.Errorf("the maintenance window must last one minute at least")
+	}
+	loc := time.Local
+	if timezone != "" {
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %s", timezone, err)
+		}
+	}
+	return &MaintenanceWindow{Schedule: s, Duration: duration, Location: loc, MaxWait: maxWait}, nil
+}
+
+// IsOpen returns true if some window is open at `now`
+func (w *MaintenanceWindow) IsOpen(now time.Time) bool {
+	now = now.In(w.Location)
+	start := now.Truncate(time.Minute)
+	for t := start; now.Sub(t) < w.Duration; t = t.Add(-time.Minute) {
+		if w.Schedule.Matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the start of the next window after `now`, looking
+// (at most) `limit` ahead. It returns false when there is no window then.
+func (w *MaintenanceWindow) NextOpen(now time.Time, limit time.Duration) (time.Time, bool) {
+	now = now.In(w.Location)
+	for t := now.Truncate(time.Minute).Add(time.Minute); t.Sub(now) <= limit; t = t.Add(time.Minute) {
+		if w.Schedule.Matches(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w *MaintenanceWindow) String() string {
+	return fmt.Sprintf("%q (%s, for %s)", w.Schedule, w.Location, w.Duration)
+}
+
+// WithMaintenanceWindow returns a new context where the disruptive
+// operations can only be started in a maintenance window
+func WithMaintenanceWindow(ctx context.Context, w *MaintenanceWindow) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.maintenanceWindow = w
+	})
+}
+
+// DoInMaintenanceWindow runs some disruptive operation when the maintenance window
+// (if any) is open, waiting for the next window when it is close enough (see
+// MaxWait). It fails when the operation cannot be started in a window.
+func DoInMaintenanceWindow(description string, action Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		w := getSSHContext(ctx).maintenanceWindow
+		if w == nil {
+			return action
+		}
+
+		now := time.Now()
+		if w.IsOpen(now) {
+			Debug("maintenance window %s open: %s", w, description)
+			return action
+		}
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "wait for the maintenance window %s: %s", w, description)
+			return nil
+		}
+
+		next, ok := w.NextOpen(now, w.MaxWait)
+		if !ok {
+			return ActionError(fmt.Sprintf("cannot start %s outside the maintenance window %s", description, w))
+		}
+
+		wait := next.Sub(now)
+		_ = DoMessageInfo("Waiting %s for the maintenance window %s: %s", wait.Round(time.Second), w, description).Apply(ctx)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ActionError(fmt.Sprintf("cancelled while waiting for the maintenance window: %s", ctx.Err()))
+		}
+		return action
+	})
+}
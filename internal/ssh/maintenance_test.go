@@ -0,0 +1,129 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	s, err := ParseSchedule("*/15 2-4 * * 6,7")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	cases := map[string]bool{
+		"2019-10-26T02:30:00Z": true,  // Saturday
+		"2019-10-27T04:45:00Z": true,  // Sunday (7)
+		"2019-10-26T02:31:00Z": false, // not a multiple of 15
+		"2019-10-26T05:00:00Z": false, // out of the hours range
+		"2019-10-25T02:30:00Z": false, // Friday
+	}
+	for ts, expected := range cases {
+		tm, _ := time.Parse(time.RFC3339, ts)
+		if res := s.Matches(tm); res != expected {
+			t.Fatalf("Error: %s matches=%t, expected %t", ts, res, expected)
+		}
+	}
+
+	// the day of month or the day of week must match when both are restricted
+	s, err = ParseSchedule("0 0 1 * 1")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for ts, expected := range map[string]bool{
+		"2019-10-01T00:00:00Z": true,  // 1st (Tuesday)
+		"2019-10-07T00:00:00Z": true,  // Monday
+		"2019-10-08T00:00:00Z": false, // Tuesday
+	} {
+		tm, _ := time.Parse(time.RFC3339, ts)
+		if res := s.Matches(tm); res != expected {
+			t.Fatalf("Error: %s matches=%t, expected %t", ts, res, expected)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Fatalf("Error: invalid schedule %q not detected", spec)
+		}
+	}
+}
+
+func TestMaintenanceWindow(t *testing.T) {
+	w, err := NewMaintenanceWindow("0 2 * * 6", 2*time.Hour, "Europe/Madrid", 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// (Saturday 26th of October 2019, CEST)
+	for ts, expected := range map[string]bool{
+		"2019-10-26T02:00:00+02:00": true,
+		"2019-10-26T03:59:00+02:00": true,
+		"2019-10-26T04:00:00+02:00": false,
+		"2019-10-26T01:59:00+02:00": false,
+		"2019-10-26T00:30:00Z":      true, // 02:30 in Madrid
+	} {
+		tm, _ := time.Parse(time.RFC3339, ts)
+		if res := w.IsOpen(tm); res != expected {
+			t.Fatalf("Error: %s open=%t, expected %t", ts, res, expected)
+		}
+	}
+
+	now, _ := time.Parse(time.RFC3339, "2019-10-26T01:30:00+02:00")
+	next, ok := w.NextOpen(now, time.Hour)
+	if !ok || !next.Equal(now.Add(30*time.Minute)) {
+		t.Fatalf("Error: unexpected next window: %s", next)
+	}
+	if _, ok := w.NextOpen(now, 10*time.Minute); ok {
+		t.Fatalf("Error: next window found too far in the future")
+	}
+
+	if _, err := NewMaintenanceWindow("0 2 * * 6", time.Hour, "Nowhere/Nothing", 0); err == nil {
+		t.Fatalf("Error: invalid timezone not detected")
+	}
+}
+
+func TestDoInMaintenanceWindow(t *testing.T) {
+	ran := false
+	action := ActionFunc(func(context.Context) Action {
+		ran = true
+		return nil
+	})
+
+	// always open
+	w, err := NewMaintenanceWindow("* * * * *", time.Minute, "UTC", 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ctx := WithMaintenanceWindow(NewTestingContext(), w)
+	if res := (ActionList{DoInMaintenanceWindow("testing", action)}).Apply(ctx); IsError(res) || !ran {
+		t.Fatalf("Error: action not run in an open window: %v", res)
+	}
+
+	// the next window starts in two hours
+	ran = false
+	start := time.Now().UTC().Add(2 * time.Hour)
+	w, err = NewMaintenanceWindow(fmt.Sprintf("%d %d * * *", start.Minute(), start.Hour()), time.Minute, "UTC", 0)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	ctx = WithMaintenanceWindow(NewTestingContext(), w)
+	if res := (ActionList{DoInMaintenanceWindow("testing", action)}).Apply(ctx); !IsError(res) || ran {
+		t.Fatalf("Error: action run outside the maintenance window")
+	}
+}
@@ -15,7 +15,12 @@
 package ssh
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"regexp"
+	"strconv"
+	"strings"
 )
 
 // AllMatchesIPv4 return all matches of IPs in a string
@@ -30,3 +35,42 @@ func AllMatchesIPv4(s string) (ips []string) {
 	}
 	return
 }
+
+const (
+	// timeout (in seconds) for the port checks
+	portCheckTimeout = 3
+
+	// command for getting the MTU of the interface used for the default route
+	defaultRouteMTUCmd = `cat /sys/class/net/$(ip route show default | awk '{for (i=1; i<NF; i++) if ($i == "dev") {print $(i+1); exit}}')/mtu`
+)
+
+// CheckPortOpen checks that `host:port` is reachable from the remote machine
+// (with the help of `nc`).
+// Note that UDP checks are "best effort": they only fail when the other side
+// actively rejects the connection.
+func CheckPortOpen(host string, port int, proto string) CheckerFunc {
+	args := []string{"-z"}
+	if proto == "udp" {
+		args = append(args, "-u")
+	}
+	args = append(args, "-w", strconv.Itoa(portCheckTimeout), host, strconv.Itoa(port))
+	return CheckExecCommand(NewCommand("nc", args...))
+}
+
+// DoGetDefaultRouteMTU gets the MTU of the interface used for the default route
+func DoGetDefaultRouteMTU(mtu *int) Action {
+	var buf bytes.Buffer
+	return ActionList{
+		DoSendingExecOutputToWriter(DoExec(defaultRouteMTUCmd), &buf),
+		ActionFunc(func(ctx context.Context) Action {
+			s := strings.TrimSpace(buf.String())
+			m, err := strconv.Atoi(s)
+			if err != nil {
+				return ActionError(fmt.Sprintf("could not parse MTU from %q: %s", s, err))
+			}
+			Debug("MTU for default route interface: %d", m)
+			*mtu = m
+			return nil
+		}),
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// number of times we retry an operation rejected by the remote sshd
+	defPacingRetries = 6
+
+	// initial delay between sessions after a rejection
+	defPacingMinDelay = 500 * time.Millisecond
+
+	// maximum delay between sessions
+	defPacingMaxDelay = 30 * time.Second
+)
+
+var (
+	// errors returned when the sshd rejects new connections/sessions
+	// (because of MaxStartups, MaxSessions, a rate limit in a bastion...):
+	// with MaxStartups, the connection is closed before the banner is sent
+	rateLimitErrors = []string{
+		"administratively prohibited",
+		"connection reset by peer",
+		"handshake failed: eof",
+	}
+
+	// errors in the handshake that are never fixed by waiting
+	// (ie, the credentials or the host key are wrong)
+	permanentErrors = []string{
+		"unable to authenticate",
+		"host key",
+		"knownhosts",
+	}
+)
+
+// isRateLimitError returns true if the error looks like a rejection by the sshd
+func isRateLimitError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range permanentErrors {
+		if strings.Contains(msg, s) {
+			return false
+		}
+	}
+	for _, s := range rateLimitErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// pacer adapts the delay between new sessions to a host: the delay is
+// doubled every time the remote sshd rejects a session, and halved on success
+type pacer struct {
+	sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+var (
+	pacersMutex sync.Mutex
+	pacers      = map[string]*pacer{}
+)
+
+// getPacer returns the (shared) pacer for a host
+func getPacer(address string) *pacer {
+	pacersMutex.Lock()
+	defer pacersMutex.Unlock()
+
+	p, ok := pacers[address]
+	if !ok {
+		p = &pacer{}
+		pacers[address] = p
+	}
+	return p
+}
+
+// wait waits until we can start a new session
+func (p *pacer) wait(ctx context.Context) error {
+	p.Lock()
+	wait := time.Until(p.next)
+	p.next = time.Now().Add(wait).Add(p.delay)
+	p.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// success reduces the delay after a successful session
+func (p *pacer) success() {
+	p.Lock()
+	defer p.Unlock()
+	p.delay /= 2
+	if p.delay < defPacingMinDelay {
+		p.delay = 0
+	}
+}
+
+// rejected increases the delay after a rejection, returning the new delay
+func (p *pacer) rejected() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	p.delay *= 2
+	if p.delay < defPacingMinDelay {
+		p.delay = defPacingMinDelay
+	}
+	if p.delay > defPacingMaxDelay {
+		p.delay = defPacingMaxDelay
+	}
+	p.next = time.Now().Add(p.delay)
+	return p.delay
+}
+
+// getPacerKey returns the key for the pacer of an address: for chains
+// of addresses (with bastions), this is the first hop, as all the hosts
+// behind a bastion share the limits of the bastion
+func getPacerKey(address string) string {
+	if i := strings.Index(address, ","); i >= 0 {
+		return address[:i]
+	}
+	return address
+}
+
+// withPacing runs some operation that opens a new session in the current host,
+// retrying it (with an increasing delay) when the remote sshd rejects it
+func withPacing(ctx context.Context, what string, op func() error) error {
+	p := getPacer(getPacerKey(GetHostFromContext(ctx).Address))
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = p.wait(ctx); err != nil {
+			return err
+		}
+		if err = op(); err == nil {
+			p.success()
+			return nil
+		}
+		if !isRateLimitError(err) || attempt >= defPacingRetries {
+			return err
+		}
+		delay := p.rejected()
+		Debug("%s rejected by the remote host (%s): retrying in %s", what, err, delay)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWithPacing(t *testing.T) {
+	ctx := WithHost(NewTestingContext(), Host{Address: "test-pacing"})
+
+	// the operation is rejected twice, and then it succeeds
+	attempts := 0
+	err := withPacing(ctx, "command", func() error {
+		attempts++
+		if attempts <= 2 {
+			return errors.New("ssh: rejected: administratively prohibited (open failed)")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("Error: unexpected number of attempts: %d", attempts)
+	}
+
+	// other errors are not retried
+	attempts = 0
+	err = withPacing(ctx, "command", func() error {
+		attempts++
+		return errors.New("some other error")
+	})
+	if err == nil || attempts != 1 {
+		t.Fatalf("Error: unexpected result: err=%v attempts=%d", err, attempts)
+	}
+
+	// the connection closed before the banner (MaxStartups) is retried, but
+	// failures in the authentication or the host key verification are not
+	for _, testCase := range []struct {
+		err       string
+		rateLimit bool
+	}{
+		{"ssh: handshake failed: EOF", true},
+		{"ssh: handshake failed: read tcp 10.0.0.1:22: read: connection reset by peer", true},
+		{"ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey], no supported methods remain", false},
+		{"ssh: handshake failed: knownhosts: key mismatch", false},
+		{"ssh: handshake failed: host key mismatch", false},
+	} {
+		if res := isRateLimitError(errors.New(testCase.err)); res != testCase.rateLimit {
+			t.Fatalf("Error: %q detected as rate limit=%t", testCase.err, res)
+		}
+	}
+
+	// the delay is reduced after successful sessions
+	p := getPacer("test-pacing")
+	for i := 0; i < 4; i++ {
+		p.success()
+	}
+	if p.delay != 0 {
+		t.Fatalf("Error: delay not reduced: %s", p.delay)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// max length of the output shown in errors
+	maxOutputInErrors = 256
+)
+
+// truncateOutput truncates some output for showing it in errors
+func truncateOutput(s string) string {
+	if len(s) > maxOutputInErrors {
+		return s[:maxOutputInErrors] + "..."
+	}
+	return s
+}
+
+// getJSONCandidates returns the offsets in some output where a JSON document could
+// start: the lines starting with a '{' or a '[', and the first '{' or '[' in the output
+func getJSONCandidates(output string) []int {
+	res := []int{}
+	offset := 0
+	for _, line := range strings.SplitAfter(output, "\n") {
+		trimmed := strings.TrimLeft(line, " \t\r")
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			res = append(res, offset+len(line)-len(trimmed))
+		}
+		offset += len(line)
+	}
+	if first := strings.IndexAny(output, "{["); first >= 0 && (len(res) == 0 || res[0] != first) {
+		res = append(res, first)
+	}
+	return res
+}
+
+// ParseJSON parses the JSON in some output into `obj`. Any text before
+// the JSON document (ie, some warnings, even like "[WARN] ...") is ignored.
+func ParseJSON(output string, obj interface{}) error {
+	candidates := getJSONCandidates(output)
+	if len(candidates) == 0 {
+		return fmt.Errorf("no JSON found in output %q", truncateOutput(output))
+	}
+
+	// try to decode the document at every candidate, returning the first error
+	// when none of them can be decoded (or the truncated document)
+	var firstErr error
+	for _, start := range candidates {
+		dec := json.NewDecoder(strings.NewReader(output[start:]))
+		err := dec.Decode(obj)
+		if err == nil {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated JSON in output %q", truncateOutput(output))
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return fmt.Errorf("could not parse JSON in output %q: %s", truncateOutput(output), firstErr)
+}
+
+// ParseYAML parses the YAML in some output into `obj` (using the `json` tags in `obj`)
+func ParseYAML(output string, obj interface{}) error {
+	if strings.TrimSpace(output) == "" {
+		return fmt.Errorf("no YAML found in output")
+	}
+	if err := yaml.Unmarshal([]byte(output), obj); err != nil {
+		return fmt.Errorf("could not parse YAML in output %q: %s", truncateOutput(output), err)
+	}
+	return nil
+}
+
+// Table is the output of a command that prints a table, like
+//
+//	NAME      STATUS   ROLES    AGE   VERSION
+//	master    Ready    master   1d    v1.15.0
+//
+// where every row is a map from the (upper case) column name to the value.
+type Table []map[string]string
+
+// ParseTable parses a table with a header. Columns are determined by the header:
+// values are separated by (at least) two spaces or by tabs, so values with a single
+// space are supported. Empty lines and rows with less fields than the header are ignored.
+func ParseTable(output string) (Table, error) {
+	split := func(line string) []string {
+		res := []string{}
+		for _, f := range strings.Split(strings.Replace(line, "\t", "  ", -1), "  ") {
+			if f = strings.TrimSpace(f); f != "" {
+				res = append(res, f)
+			}
+		}
+		return res
+	}
+
+	table := Table{}
+	var header []string
+	for _, line := range strings.Split(output, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := split(line)
+		if header == nil {
+			header = fields
+			continue
+		}
+		if len(fields) < len(header) {
+			Debug("ignoring row with missing fields: %q", line)
+			continue
+		}
+		row := map[string]string{}
+		for i, h := range header {
+			row[strings.ToUpper(h)] = fields[i]
+		}
+		table = append(table, row)
+	}
+
+	if header == nil {
+		return nil, fmt.Errorf("no table found in output")
+	}
+	return table, nil
+}
+
+// doSendingExecOutputToParser runs some action, collecting all the
+// Do***Exec output and parsing it at the end
+func doSendingExecOutputToParser(action Action, parser func(string) error) Action {
+	var buf bytes.Buffer
+	return ActionList{
+		DoSendingExecOutputToFunc(action, func(s string) {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}),
+		ActionFunc(func(context.Context) Action {
+			if err := parser(buf.String()); err != nil {
+				return ActionError(err.Error())
+			}
+			return nil
+		}),
+	}
+}
+
+// DoSendingExecOutputToJSON runs some action, parsing the (JSON) output in `obj`
+func DoSendingExecOutputToJSON(action Action, obj interface{}) Action {
+	return doSendingExecOutputToParser(action, func(s string) error {
+		return ParseJSON(s, obj)
+	})
+}
+
+// DoSendingExecOutputToYAML runs some action, parsing the (YAML) output in `obj`
+func DoSendingExecOutputToYAML(action Action, obj interface{}) Action {
+	return doSendingExecOutputToParser(action, func(s string) error {
+		return ParseYAML(s, obj)
+	})
+}
+
+// DoSendingExecOutputToTable runs some action, parsing the output as a table
+func DoSendingExecOutputToTable(action Action, table *Table) Action {
+	return doSendingExecOutputToParser(action, func(s string) error {
+		t, err := ParseTable(s)
+		if err != nil {
+			return err
+		}
+		*table = t
+		return nil
+	})
+}
+
+// DoExecJSON runs a remote command, parsing the (JSON) output in `obj`
+func DoExecJSON(command string, obj interface{}) Action {
+	return DoSendingExecOutputToJSON(DoExec(command), obj) // rawcmd: the command given
+}
+
+// DoExecYAML runs a remote command, parsing the (YAML) output in `obj`
+func DoExecYAML(command string, obj interface{}) Action {
+	return DoSendingExecOutputToYAML(DoExec(command), obj) // rawcmd: the command given
+}
+
+// DoExecTable runs a remote command, parsing the output as a table
+func DoExecTable(command string, table *Table) Action {
+	return DoSendingExecOutputToTable(DoExec(command), table) // rawcmd: the command given
+}
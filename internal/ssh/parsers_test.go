@@ -0,0 +1,106 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+)
+
+type testNodeList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+func TestParseJSON(t *testing.T) {
+	output := `Warning: some warning before the output
+{"items": [{"metadata": {"name": "master-0"}}, {"metadata": {"name": "worker-0"}}]}
+`
+	nodes := testNodeList{}
+	if err := ParseJSON(output, &nodes); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(nodes.Items) != 2 || nodes.Items[1].Metadata.Name != "worker-0" {
+		t.Fatalf("Error: unexpected result: %+v", nodes)
+	}
+
+	output = `[WARN] some warning with brackets
+  [INFO] and another one
+{"items": [{"metadata": {"name": "master-1"}}]}
+`
+	nodes = testNodeList{}
+	if err := ParseJSON(output, &nodes); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(nodes.Items) != 1 || nodes.Items[0].Metadata.Name != "master-1" {
+		t.Fatalf("Error: unexpected result: %+v", nodes)
+	}
+
+	if err := ParseJSON(`{"items": [{"metadata": {"na`, &nodes); err == nil {
+		t.Fatalf("Error: truncated JSON not detected")
+	}
+	if err := ParseJSON(`no json here`, &nodes); err == nil {
+		t.Fatalf("Error: missing JSON not detected")
+	}
+}
+
+func TestParseYAML(t *testing.T) {
+	output := `
+items:
+- metadata:
+    name: master-0
+`
+	nodes := testNodeList{}
+	if err := ParseYAML(output, &nodes); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(nodes.Items) != 1 || nodes.Items[0].Metadata.Name != "master-0" {
+		t.Fatalf("Error: unexpected result: %+v", nodes)
+	}
+}
+
+func TestParseTable(t *testing.T) {
+	output := `
+TOKEN                     TTL       EXPIRES                USAGES                   DESCRIPTION   EXTRA GROUPS
+5befc5.a36864a4c9cc2c7d   22h       2019-07-10T15:08:31Z   authentication,signing   <none>        system:bootstrappers:kubeadm:default-node-token
+something truncated
+`
+	table, err := ParseTable(output)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(table) != 1 {
+		t.Fatalf("Error: unexpected number of rows: %d", len(table))
+	}
+	if table[0]["TOKEN"] != "5befc5.a36864a4c9cc2c7d" || table[0]["EXTRA GROUPS"] != "system:bootstrappers:kubeadm:default-node-token" {
+		t.Fatalf("Error: unexpected row: %+v", table[0])
+	}
+}
+
+func TestDoExecJSON(t *testing.T) {
+	ctx := NewTestingContextWithResponses([]string{
+		`{"items": [{"metadata": {"name": "master-0"}}]}`,
+	})
+
+	nodes := testNodeList{}
+	if res := DoExecJSON("kubectl get nodes -o json", &nodes).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+	if len(nodes.Items) != 1 || nodes.Items[0].Metadata.Name != "master-0" {
+		t.Fatalf("Error: unexpected result: %+v", nodes)
+	}
+}
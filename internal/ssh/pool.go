@@ -0,0 +1,199 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+const (
+	// DefConnIdleTimeout is the default time connections are kept open in
+	// the pool after the last user has released them
+	DefConnIdleTimeout = 1 * time.Minute
+)
+
+// DialFunc opens a new connection, returning a function for
+// releasing any other resources used (ie, tunnels)
+type DialFunc func() (communicator.Communicator, func(), error)
+
+// pooledConn is a connection in the pool
+type pooledConn struct {
+	// mutex held while connecting
+	sync.Mutex
+
+	comm    communicator.Communicator
+	cleanup func()
+	refs    int
+	idle    *time.Timer
+}
+
+// ConnPool is a pool of authenticated connections to remote hosts, so all the
+// resources provisioning the same host in this process share one connection
+// (and every command is just a new session in that connection)
+type ConnPool struct {
+	sync.Mutex
+	conns map[string]*pooledConn
+
+	// IdleTimeout is the time connections are kept after being released
+	IdleTimeout time.Duration
+}
+
+// NewConnPool creates a new pool of connections
+func NewConnPool(idleTimeout time.Duration) *ConnPool {
+	return &ConnPool{
+		conns:       map[string]*pooledConn{},
+		IdleTimeout: idleTimeout,
+	}
+}
+
+// DefConnPool is the pool shared by all the resources in this process
+var DefConnPool = NewConnPool(DefConnIdleTimeout)
+
+// Get returns the connection for a key (ie, the host and the credentials),
+// opening it with dial when there is no connection in the pool. The returned
+// function must be called when the connection is not needed anymore.
+func (p *ConnPool) Get(key string, dial DialFunc) (communicator.Communicator, func(), error) {
+	p.Lock()
+	pc, ok := p.conns[key]
+	if !ok {
+		pc = &pooledConn{}
+		p.conns[key] = pc
+	}
+	pc.refs++
+	if pc.idle != nil {
+		pc.idle.Stop()
+		pc.idle = nil
+	}
+	p.Unlock()
+
+	pc.Lock()
+	defer pc.Unlock()
+
+	if pc.comm == nil {
+		Debug("opening a new connection for the pool")
+		comm, cleanup, err := dial()
+		if err != nil {
+			p.release(key, pc)
+			return nil, func() {}, err
+		}
+		pc.comm = comm
+		pc.cleanup = cleanup
+	} else {
+		Debug("reusing a connection from the pool")
+	}
+
+	shared := &sharedCommunicator{Communicator: pc.comm, pool: p, pc: pc}
+	once := sync.Once{}
+	return shared, func() { once.Do(func() { p.release(key, pc) }) }, nil
+}
+
+// release releases a reference to a connection, closing it (after the idle
+// timeout) when nobody else uses it
+func (p *ConnPool) release(key string, pc *pooledConn) {
+	p.Lock()
+	defer p.Unlock()
+
+	pc.refs--
+	if pc.refs > 0 {
+		return
+	}
+	if pc.comm == nil {
+		// (the connection could not be opened)
+		delete(p.conns, key)
+		return
+	}
+
+	pc.idle = time.AfterFunc(p.IdleTimeout, func() {
+		p.Lock()
+		if pc.refs > 0 || p.conns[key] != pc {
+			p.Unlock()
+			return
+		}
+		delete(p.conns, key)
+		p.Unlock()
+
+		Debug("closing idle connection from the pool")
+		pc.close()
+	})
+}
+
+// close closes the connection and releases everything used by it
+func (pc *pooledConn) close() {
+	_ = pc.comm.Disconnect()
+	if pc.cleanup != nil {
+		pc.cleanup()
+	}
+}
+
+// Close closes all the connections in the pool that are not being used
+func (p *ConnPool) Close() {
+	p.Lock()
+	idle := map[string]*pooledConn{}
+	for key, pc := range p.conns {
+		if pc.refs == 0 && pc.comm != nil {
+			if pc.idle != nil {
+				pc.idle.Stop()
+			}
+			idle[key] = pc
+			delete(p.conns, key)
+		}
+	}
+	p.Unlock()
+
+	for _, pc := range idle {
+		pc.close()
+	}
+}
+
+// isShared returns true if the connection is used by someone else
+func (p *ConnPool) isShared(pc *pooledConn) bool {
+	p.Lock()
+	defer p.Unlock()
+	return pc.refs > 1
+}
+
+// sharedCommunicator is a connection from the pool. The connection cannot
+// be closed (or reopened) while it is being used by other resources.
+type sharedCommunicator struct {
+	communicator.Communicator
+
+	pool *ConnPool
+	pc   *pooledConn
+}
+
+// Connect reopens the connection, unless it is being used by someone else
+// (the connection is alive then)
+func (c *sharedCommunicator) Connect(o terraform.UIOutput) error {
+	if c.pool.isShared(c.pc) {
+		Debug("connection shared with other resources: not reconnecting")
+		return nil
+	}
+	return c.Communicator.Connect(o)
+}
+
+// Disconnect closes the connection, unless it is being used by someone else
+// (hung commands are interrupted in their own session by DoExec, so they
+// do not need the connection to be dropped)
+func (c *sharedCommunicator) Disconnect() error {
+	if c.pool.isShared(c.pc) {
+		Debug("connection shared with other resources: not disconnecting")
+		return nil
+	}
+	return c.Communicator.Disconnect()
+}
@@ -0,0 +1,119 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator"
+)
+
+func TestConnPool(t *testing.T) {
+	var dials, connects, disconnects, cleanups int32
+
+	pool := NewConnPool(20 * time.Millisecond)
+	dial := func() (communicator.Communicator, func(), error) {
+		atomic.AddInt32(&dials, 1)
+		comm := testDeadCommunicator{connects: &connects, disconnects: &disconnects}
+		return comm, func() { atomic.AddInt32(&cleanups, 1) }, nil
+	}
+
+	comm1, release1, err := pool.Get("host-a", dial)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	comm2, release2, err := pool.Get("host-a", dial)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 1 {
+		t.Fatalf("Error: the connection has not been reused: %d dials", n)
+	}
+
+	// the connection cannot be closed (or reopened) while it is shared
+	_ = comm1.Disconnect()
+	_ = comm2.Connect(nil)
+	if atomic.LoadInt32(&disconnects) != 0 || atomic.LoadInt32(&connects) != 0 {
+		t.Fatalf("Error: the shared connection has been closed")
+	}
+
+	// other hosts get their own connection
+	_, release3, err := pool.Get("host-b", dial)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("Error: unexpected number of dials: %d", n)
+	}
+
+	release1()
+	release1() // (releasing twice must be harmless)
+	_ = comm2.Disconnect()
+	if n := atomic.LoadInt32(&disconnects); n != 1 {
+		t.Fatalf("Error: the connection has not been closed by its only user: %d", n)
+	}
+
+	// connections are closed after the idle timeout
+	release2()
+	release3()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&cleanups) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Error: idle connections have not been closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&disconnects); n != 3 {
+		t.Fatalf("Error: unexpected number of disconnects: %d", n)
+	}
+
+	// a new connection is opened after that
+	_, release4, err := pool.Get("host-a", dial)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer release4()
+	if n := atomic.LoadInt32(&dials); n != 3 {
+		t.Fatalf("Error: unexpected number of dials: %d", n)
+	}
+}
+
+func TestConnPoolDialError(t *testing.T) {
+	pool := NewConnPool(time.Hour)
+	failed := func() (communicator.Communicator, func(), error) {
+		return nil, nil, errors.New("connection refused")
+	}
+	if _, _, err := pool.Get("host-a", failed); err == nil {
+		t.Fatalf("Error: dial error not returned")
+	}
+
+	// the failed connection is not kept in the pool
+	dials := 0
+	_, release, err := pool.Get("host-a", func() (communicator.Communicator, func(), error) {
+		dials++
+		return DummyCommunicator{}, func() {}, nil
+	})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	release()
+	pool.Close()
+	if dials != 1 || len(pool.conns) != 0 {
+		t.Fatalf("Error: unexpected pool state: %d dials, %d connections", dials, len(pool.conns))
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// methods for running commands with root privileges
+const (
+	// EscalationSudo runs commands with a (passwordless) `sudo`
+	EscalationSudo = "sudo"
+
+	// EscalationDoas runs commands with a (passwordless) `doas`
+	EscalationDoas = "doas"
+
+	// EscalationSu runs commands with `su`
+	EscalationSu = "su"
+
+	// EscalationNone runs commands as the SSH user (ie, when it is already root)
+	EscalationNone = "none"
+)
+
+// EscalationMethods are all the valid privilege escalation methods
+var EscalationMethods = []string{EscalationSudo, EscalationDoas, EscalationSu, EscalationNone}
+
+// arguments for "sudo" when the password is provided in the stdin
+// (without any prompt, as it would be mixed with the output of the command).
+// Cached credentials are ignored (and not updated), so every `sudo` asks for
+// the password exactly once and no copy is left in the stdin of the command.
+const sudoPasswordArgs = "-S -k -p '' -E"
+
+// WithPrivilegeEscalation returns a new context where the commands that need root
+// privileges are run with some escalation method (`sudo` by default)
+func WithPrivilegeEscalation(ctx context.Context, method string) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.escalation = method
+		if method == EscalationNone {
+			sshc.useSudo = false
+		}
+	})
+}
+
+// WithSudoPassword returns a new context where `sudo` is run with a password. The
+// password is sent in the stdin of the commands, so it never appears in the
+// command lines (nor in the logs, the execution manifests, etc.)
+func WithSudoPassword(ctx context.Context, password string) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.sudoPassword = password
+	})
+}
+
+// hasSudoPassword returns true if `sudo` must be run with a password
+func hasSudoPassword(ctx context.Context) bool {
+	return GetUseSudoFromContext(ctx) && getEscalationMethod(ctx) == EscalationSudo &&
+		getSSHContext(ctx).sudoPassword != ""
+}
+
+// getEscalationStdin returns the stdin for an escalated command: the sudo
+// password (if any) once for every `sudo` run (the audit log and the command),
+// as every `sudo` asks for it (see sudoPasswordArgs)
+func getEscalationStdin(ctx context.Context) io.Reader {
+	if !hasSudoPassword(ctx) {
+		return nil
+	}
+	n := 1
+	if log := getSSHContext(ctx).auditLog; log != nil && log.Path != "" {
+		n++
+	}
+	return strings.NewReader(strings.Repeat(getSSHContext(ctx).sudoPassword+"\n", n))
+}
+
+// getEscalationMethod returns the privilege escalation method used in this context
+func getEscalationMethod(ctx context.Context) string {
+	if method := getSSHContext(ctx).escalation; method != "" {
+		return method
+	}
+	return EscalationSudo
+}
+
+// getEscalatedCommand returns the command prefixed with the privilege escalation
+// (when it must be run with root privileges)
+func getEscalatedCommand(ctx context.Context, command string) string {
+	if !GetUseSudoFromContext(ctx) {
+		return command
+	}
+
+	switch method := getEscalationMethod(ctx); method {
+	case EscalationSudo:
+		if hasSudoPassword(ctx) {
+			return "sudo " + sudoPasswordArgs + " " + command
+		}
+		return "sudo " + sudoArgs + " " + command
+	case EscalationDoas:
+		return "doas -n " + command
+	case EscalationSu:
+		return "su root -c " + shellQuote(command)
+	case EscalationNone:
+		return command
+	default:
+		panic(fmt.Sprintf("unknown privilege escalation method %q: should have been caught at the validation stage", method))
+	}
+}
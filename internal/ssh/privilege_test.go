@@ -0,0 +1,119 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+)
+
+func TestPrivilegeEscalation(t *testing.T) {
+	testCases := []struct {
+		method   string
+		useSudo  bool
+		expected string
+	}{
+		{"", true, "sudo " + sudoArgs + " mv -f /tmp/a /etc/b"},
+		{EscalationSudo, true, "sudo " + sudoArgs + " mv -f /tmp/a /etc/b"},
+		{EscalationDoas, true, "doas -n mv -f /tmp/a /etc/b"},
+		{EscalationSu, true, "su root -c 'mv -f /tmp/a /etc/b'"},
+		{EscalationNone, true, "mv -f /tmp/a /etc/b"},
+		{EscalationDoas, false, "mv -f /tmp/a /etc/b"},
+	}
+
+	for _, testCase := range testCases {
+		commands := []string{}
+		ctx := WithValues(context.Background(), DummyOutput{}, DummyOutput{},
+			testRecordingCommunicator{commands: &commands}, testCase.useSudo)
+		if testCase.method != "" {
+			ctx = WithPrivilegeEscalation(ctx, testCase.method)
+		}
+
+		if res := (ActionList{DoExec("mv -f /tmp/a /etc/b")}).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: could not run command with %q: %s", testCase.method, res)
+		}
+		if len(commands) != 1 || commands[0] != testCase.expected {
+			t.Fatalf("Error: unexpected commands with %q: %q, expected %q", testCase.method, commands, testCase.expected)
+		}
+	}
+}
+
+// testStdinCommunicator records the commands and their stdin (the stdin
+// is closed after the command finishes, so it is read in the background)
+type testStdinCommunicator struct {
+	DummyCommunicator
+
+	commands *[]string
+	stdins   *[]string
+	wg       *sync.WaitGroup
+}
+
+func (dc testStdinCommunicator) Start(cmd *remote.Cmd) error {
+	cmd.Init()
+	*dc.commands = append(*dc.commands, cmd.Command)
+	*dc.stdins = append(*dc.stdins, "")
+	n := len(*dc.stdins) - 1
+
+	dc.wg.Add(1)
+	go func() {
+		defer dc.wg.Done()
+		all, _ := ioutil.ReadAll(cmd.Stdin)
+		(*dc.stdins)[n] = string(all)
+	}()
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func TestSudoPassword(t *testing.T) {
+	const password = "s3cr3t pass"
+
+	for _, audited := range []bool{false, true} {
+		commands, stdins, wg := []string{}, []string{}, sync.WaitGroup{}
+		ctx := WithValues(context.Background(), DummyOutput{}, DummyOutput{},
+			testStdinCommunicator{commands: &commands, stdins: &stdins, wg: &wg}, true)
+		ctx = WithSudoPassword(ctx, password)
+		expectedStdin := password + "\n"
+		if audited {
+			ctx = WithAuditLog(ctx, AuditLog{Path: "/var/log/kubeadm-audit.log", RunID: "1234"})
+			expectedStdin += password + "\n"
+		}
+
+		if res := (ActionList{DoExec("kubeadm reset --force")}).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: could not run command: %s", res)
+		}
+		wg.Wait()
+		if len(commands) != 1 {
+			t.Fatalf("Error: unexpected commands: %q", commands)
+		}
+		if strings.Contains(commands[0], password) {
+			t.Fatalf("Error: password found in the command line: %q", commands[0])
+		}
+		if !strings.Contains(commands[0], "sudo "+sudoPasswordArgs+" kubeadm reset --force") {
+			t.Fatalf("Error: command not run with sudo -S: %q", commands[0])
+		}
+		if stdins[0] != expectedStdin {
+			t.Fatalf("Error: unexpected stdin (audited=%t): %q", audited, stdins[0])
+		}
+		// every `sudo` (that ignores the cached credentials) reads exactly one copy
+		if prompts := strings.Count(commands[0], "sudo "+sudoPasswordArgs); prompts != strings.Count(stdins[0], password) {
+			t.Fatalf("Error: %d copies of the password for %d sudo runs: %q", strings.Count(stdins[0], password), prompts, commands[0])
+		}
+	}
+}
@@ -15,22 +15,38 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 )
 
+const (
+	// script for checking if a process is running (the `ps` command is in "$1" and the process in "$2")
+	processRunningScript = `[ -n "$($1 | grep -e "$2" | grep -v grep)" ]`
+
+	// scripts for checking the status of a service (the service is in "$1")
+	serviceExistsScript   = `systemctl --no-pager status "$1" 2>/dev/null`
+	serviceInactiveScript = `systemctl --no-pager status "$1" 2>/dev/null | grep Active | grep -q inactive`
+)
+
 // CheckProcessRunning checks that a process is running with the help of `ps`
 // FIXME: this is not really reliable, as it looks for a string in tne output
-//        of `ps`, and that string can be part of some other command...
+// of `ps`, and that string can be part of some other command...
 func CheckProcessRunning(process string) CheckerFunc {
-	check := fmt.Sprintf(`[ -n "$(ps ax | grep %s | grep -v grep)" ]`, process)
-	return CheckExec(check)
+	return CheckerFunc(func(ctx context.Context) (bool, error) {
+		// BusyBox's `ps` shows all the processes, and it does not support "ax"
+		ps := "ps ax"
+		if IsBusyBox(ctx) {
+			ps = "ps"
+		}
+		return CheckExecCommand(NewShellCommand(processRunningScript, ps, process))(ctx)
+	})
 }
 
 // DoRestartService restart a systemctl service
 func DoRestartService(service string) Action {
 	return ActionList{
 		DoMessageInfo(fmt.Sprintf("Restarting service %s", service)),
-		DoExec(fmt.Sprintf("systemctl --no-pager restart '%s'", service)),
+		DoExecCommand(NewCommand("systemctl", "--no-pager", "restart", service)),
 	}
 }
 
@@ -38,21 +54,27 @@ func DoRestartService(service string) Action {
 func DoEnableService(service string) Action {
 	return ActionList{
 		DoMessageInfo(fmt.Sprintf("Enabling service %s", service)),
-		DoExec(fmt.Sprintf("systemctl --no-pager enable '%s'", service)),
+		DoExecCommand(NewCommand("systemctl", "--no-pager", "enable", service)),
+	}
+}
+
+// DoDisableService disables (and stops) a systemctl service
+func DoDisableService(service string) Action {
+	return ActionList{
+		DoMessageInfo(fmt.Sprintf("Disabling service %s", service)),
+		DoExecCommand(NewCommand("systemctl", "--no-pager", "disable", "--now", service)),
 	}
 }
 
 // CheckServiceExists checks that service exists
 func CheckServiceExists(service string) CheckerFunc {
 	Debug("will check if service '%s' exists", service)
-	exists := fmt.Sprintf("systemctl --no-pager status '%s' 2>/dev/null", service)
-	return CheckExec(exists)
+	return CheckExecCommand(NewShellCommand(serviceExistsScript, service))
 }
 
 // CheckServiceActive checks that service exists and is active
 func CheckServiceActive(service string) CheckerFunc {
-	inactive := fmt.Sprintf("systemctl --no-pager status '%s' 2>/dev/null | grep Active | grep -q inactive", service)
 	return CheckNot(
 		CheckAnd(CheckServiceExists(service),
-			CheckExec(inactive)))
+			CheckExecCommand(NewShellCommand(serviceInactiveScript, service))))
 }
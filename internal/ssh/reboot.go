@@ -0,0 +1,165 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DefRebootPollInterval is the interval between checks of the node after a reboot
+	DefRebootPollInterval = 10 * time.Second
+
+	// DefRebootPollTimeout is the maximum time for every check of the node after a reboot
+	DefRebootPollTimeout = 30 * time.Second
+
+	// rebootRequiredScript checks if the node must be rebooted: Debian/Ubuntu create
+	// a `reboot-required` file, while RHEL/CentOS/Fedora provide `needs-restarting`
+	// (that exits with 1 when a reboot is required, and with other codes on errors)
+	rebootRequiredScript = `test -f /var/run/reboot-required || ` +
+		`{ command -v needs-restarting >/dev/null 2>&1 && ` +
+		`{ needs-restarting -r >/dev/null 2>&1 ; test $? -eq 1 ; } ; }`
+
+	// the boot ID changes in every boot
+	bootIDCmd = "cat /proc/sys/kernel/random/boot_id"
+
+	// rebootScript reboots the node after a couple of seconds, so the command
+	// can return before the connection is lost
+	rebootScript = `if command -v systemd-run >/dev/null 2>&1 ; then ` +
+		`systemd-run --on-active=2 systemctl reboot ; ` +
+		`else nohup sh -c "sleep 2 ; reboot" >/dev/null 2>&1 & fi`
+)
+
+// CheckRebootRequired checks if the node must be rebooted (ie, after
+// installing a new kernel, some kernel modules or a new container runtime)
+func CheckRebootRequired() CheckerFunc {
+	return CheckExecCommand(NewShellCommand(rebootRequiredScript))
+}
+
+// DoReboot reboots the node, waiting (with some `wait` retries) until the node
+// is reachable again with a new boot ID
+func DoReboot(wait Retry) Action {
+	return doReboot(func(check Action) Action {
+		return DoRetry(wait, check)
+	})
+}
+
+// DoRebootAndWait reboots the node, waiting (at most `timeout`) until SSH is back
+// and the node has a new boot ID. The connection is re-established, so the
+// remaining actions continue in the rebooted node.
+func DoRebootAndWait(timeout time.Duration) Action {
+	return doRebootAndWait(timeout, DefRebootPollInterval)
+}
+
+func doRebootAndWait(timeout time.Duration, interval time.Duration) Action {
+	return doReboot(func(check Action) Action {
+		return doWaitUntil(timeout, interval, ActionList{
+			doReconnect(),
+			DoWithTimeout(check, DefRebootPollTimeout),
+		})
+	})
+}
+
+// doReboot reboots the node, using `wait` for waiting until the
+// `check` for a new boot ID succeeds
+func doReboot(wait func(check Action) Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			printDryRun(ctx, "reboot the node")
+			return nil
+		}
+
+		var before bytes.Buffer
+		if res := (ActionList{DoSendingExecOutputToWriter(DoExec(bootIDCmd), &before)}).Apply(ctx); IsError(res) {
+			return res
+		}
+		bootID := strings.TrimSpace(before.String())
+		Debug("current boot ID: %q", bootID)
+
+		return ActionList{
+			DoMessageInfo("Rebooting the node..."),
+			DoExecCommand(NewShellCommand(rebootScript)),
+			ActionFunc(func(ctx context.Context) Action {
+				// drop the current connection: the next command will open a new one
+				_ = GetCommFromContext(ctx).Disconnect()
+				return nil
+			}),
+			wait(ActionFunc(func(ctx context.Context) Action {
+				var after bytes.Buffer
+				res := ActionList{
+					DoRefreshSession(),
+					DoSendingExecOutputToWriter(DoExec(bootIDCmd), &after),
+				}.Apply(ctx)
+				if IsError(res) {
+					return res
+				}
+				if current := strings.TrimSpace(after.String()); current == "" || current == bootID {
+					return ActionError("the node has not been rebooted yet")
+				}
+				return nil
+			})),
+			// everything we knew about the node could be different now
+			DoFlushCache(),
+			DoMessageInfo("Node rebooted"),
+		}
+	})
+}
+
+// doReconnect re-establishes the connection to the node
+func doReconnect() Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		comm := GetCommFromContext(ctx)
+
+		var err error
+		if refresher, ok := comm.(sessionRefresher); ok {
+			err = refresher.Refresh()
+		} else {
+			_ = comm.Disconnect()
+			err = comm.Connect(nil)
+		}
+		if err != nil {
+			return ActionError(fmt.Sprintf("could not connect to the node: %s", err))
+		}
+		return nil
+	})
+}
+
+// doWaitUntil runs an action every `interval` until it succeeds,
+// failing when it has not succeeded after `timeout`
+func doWaitUntil(timeout time.Duration, interval time.Duration, action Action) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		deadline := time.Now().Add(timeout)
+		for {
+			res := ActionList{action}.Apply(ctx)
+			if !IsError(res) || IsDryRun(ctx) {
+				return res
+			}
+			if time.Now().Add(interval).After(deadline) {
+				return ActionError(fmt.Sprintf("timeout: not ready after %s: %s", timeout, res))
+			}
+
+			Debug("not ready yet (%s): retrying in %s", res, interval)
+			select {
+			case <-time.After(interval):
+			case <-ctx.Done():
+				return ActionError(fmt.Sprintf("cancelled while waiting: %s", ctx.Err()))
+			}
+		}
+	})
+}
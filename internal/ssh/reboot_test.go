@@ -0,0 +1,100 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckRebootRequired(t *testing.T) {
+	for output, expected := range map[string]bool{
+		"CONDITION_SUCCEEDED": true,
+		"CONDITION_FAILED":    false,
+	} {
+		ctx := NewTestingContextWithResponses([]string{output})
+		required, err := CheckRebootRequired().Check(ctx)
+		if err != nil {
+			t.Fatalf("Error: when checking if a reboot is required: %s", err)
+		}
+		if required != expected {
+			t.Fatalf("Error: reboot required=%t with output %q", required, output)
+		}
+	}
+}
+
+func TestRebootRequiredScript(t *testing.T) {
+	if _, err := os.Stat("/var/run/reboot-required"); err == nil {
+		t.Skip("a reboot is required in this machine")
+	}
+
+	dir, err := ioutil.TempDir("", "reboot")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// only the exit code 1 of `needs-restarting -r` means a reboot is required
+	for exitCode, expected := range map[int]bool{0: false, 1: true, 2: false} {
+		script := fmt.Sprintf("#!/bin/sh\nexit %d\n", exitCode)
+		if err := ioutil.WriteFile(filepath.Join(dir, "needs-restarting"), []byte(script), 0755); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		cmd := exec.Command("sh", "-c", NewShellCommand(rebootRequiredScript).String())
+		cmd.Env = []string{"PATH=" + dir + ":/usr/bin:/bin"}
+		if required := cmd.Run() == nil; required != expected {
+			t.Fatalf("Error: reboot required=%t when needs-restarting exits with %d", required, exitCode)
+		}
+	}
+}
+
+func TestDoReboot(t *testing.T) {
+	wait := Retry{Times: 3, Interval: time.Millisecond}
+
+	// the boot ID is the same in the first check after the reboot
+	ctx := NewTestingContextWithResponses([]string{"aaaa\n", "", "aaaa\n", "bbbb\n"})
+	if res := (ActionList{DoReboot(wait)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when rebooting: %s", res)
+	}
+
+	// the node never comes back with a new boot ID
+	ctx = NewTestingContextWithResponses([]string{"aaaa\n", "", "aaaa\n", "aaaa\n", "aaaa\n"})
+	if res := (ActionList{DoReboot(wait)}).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: no error when the node is not rebooted")
+	}
+}
+
+func TestDoRebootAndWait(t *testing.T) {
+	// the node is back (with a new boot ID) in the second check
+	ctx := NewTestingContextWithResponses([]string{"aaaa\n", "", "aaaa\n", "bbbb\n"})
+	if res := (ActionList{doRebootAndWait(time.Second, time.Millisecond)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when rebooting: %s", res)
+	}
+
+	// the node is not back before the timeout
+	responses := []string{"aaaa\n", ""}
+	for i := 0; i < 100; i++ {
+		responses = append(responses, "aaaa\n")
+	}
+	ctx = NewTestingContextWithResponses(responses)
+	if res := (ActionList{doRebootAndWait(20*time.Millisecond, 5*time.Millisecond)}).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: no error when the node is not rebooted")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+var (
+	// login shells where our commands can be run directly
+	posixShells = []string{"sh", "bash", "dash", "ash", "ksh", "mksh", "zsh", "busybox"}
+)
+
+// IsPOSIXShell returns true if the shell (ie, "/usr/bin/fish") is a POSIX shell
+func IsPOSIXShell(shell string) bool {
+	name := path.Base(strings.TrimSpace(shell))
+	for _, s := range posixShells {
+		if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+// DetectShellWrapper returns the shell that must be used for wrapping all the
+// commands executed in the host: an empty string when the login shell is a
+// POSIX shell, or "sh" for non-POSIX shells (ie, fish or csh)
+func DetectShellWrapper(ctx context.Context) (string, error) {
+	var buf bytes.Buffer
+	// "$SHELL" is expanded by the login shell in all the shells we know
+	res := DoSendingExecOutputToWriter(DoExec("echo $SHELL"), &buf).Apply(ctx)
+	if IsError(res) {
+		return "", fmt.Errorf("could not detect the remote shell: %s", res)
+	}
+
+	shell := strings.TrimSpace(buf.String())
+	Debug("remote login shell: %q", shell)
+	if shell == "" || IsPOSIXShell(shell) {
+		return "", nil
+	}
+	return "sh", nil
+}
+
+// WithShellWrapper returns a new context where all the commands are run
+// with a "<shell> -c" wrapper (or directly when the shell is empty)
+func WithShellWrapper(ctx context.Context, shell string) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.shellWrapper = shell
+	})
+}
+
+// GetShellFromContext returns the shell used for running commands and scripts
+func GetShellFromContext(ctx context.Context) string {
+	if shell := getSSHContext(ctx).shellWrapper; shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
+// getCommandWithShellWrapper returns the command wrapped with a "<shell> -c" (if needed)
+func getCommandWithShellWrapper(ctx context.Context, command string) string {
+	shell := getSSHContext(ctx).shellWrapper
+	if shell == "" {
+		return command
+	}
+	return shell + " -c " + shellQuote(command)
+}
@@ -0,0 +1,69 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestDetectShellWrapper(t *testing.T) {
+	testCases := []struct {
+		shell    string
+		expected string
+	}{
+		{"/bin/bash", ""},
+		{"/usr/bin/zsh", ""},
+		{"/bin/sh", ""},
+		{"/usr/bin/fish", "sh"},
+		{"/bin/tcsh", "sh"},
+		{"", ""},
+	}
+
+	for _, testCase := range testCases {
+		ctx := NewTestingContextWithResponses([]string{testCase.shell})
+		wrapper, err := DetectShellWrapper(ctx)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if wrapper != testCase.expected {
+			t.Fatalf("Error: unexpected wrapper for %q: %q, expected: %q", testCase.shell, wrapper, testCase.expected)
+		}
+	}
+}
+
+func TestShellWrapper(t *testing.T) {
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+	ctx = WithExecEnv(ctx, ExecEnv{Locale: DefExecLocale})
+	ctx = WithShellWrapper(ctx, "sh")
+
+	if res := DoExec(`[ "$LC_ALL" = C ] && echo "it's wrapped"`).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+	if len(commands) != 1 || !strings.HasPrefix(commands[0], "sh -c '") {
+		t.Fatalf("Error: command not wrapped: %q", commands)
+	}
+
+	// run the wrapped command in a real shell
+	output, err := exec.Command("sh", "-c", commands[0]).CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error: %v: %s", err, output)
+	}
+	if strings.TrimSpace(string(output)) != "it's wrapped" {
+		t.Fatalf("Error: unexpected output: %q", output)
+	}
+}
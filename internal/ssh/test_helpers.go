@@ -16,9 +16,12 @@ package ssh
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform/communicator"
@@ -97,6 +100,32 @@ type dummyCommunicatorWithResponses struct {
 
 func (dc dummyCommunicatorWithResponses) Start(cmd *remote.Cmd) error {
 	cmd.Init()
+
+	// the checksums do not consume responses (and only the files uploaded exist)
+	if strings.Contains(cmd.Command, "sha256sum ") && !strings.Contains(cmd.Command, dumpMarkStart) {
+		if dc.uploads != nil {
+			for path, contents := range *dc.uploads {
+				if strings.Contains(cmd.Command, "sha256sum "+shellQuoteIfNeeded(path)) {
+					sum := sha256.Sum256([]byte(contents))
+					cmd.Stdout.Write([]byte(hex.EncodeToString(sum[:]) + "  " + path + "\n"))
+					cmd.SetExitStatus(0, nil)
+					return nil
+				}
+			}
+		}
+		cmd.SetExitStatus(1, nil)
+		return nil
+	}
+
+	// temporary files do not consume responses either
+	if strings.HasPrefix(cmd.Command, "mktemp ") {
+		template := strings.Trim(strings.TrimPrefix(cmd.Command, "mktemp "), "'")
+		r, _ := randBytes(3)
+		cmd.Stdout.Write([]byte(strings.Replace(template, "XXXXXX", r, 1) + "\n"))
+		cmd.SetExitStatus(0, nil)
+		return nil
+	}
+
 	if (*dc.counter) >= len(dc.responses) {
 		cmd.Stdout.Write([]byte(""))
 	} else {
@@ -16,6 +16,7 @@ package ssh
 
 import (
 	"bytes"
+	"strings"
 	"text/template"
 )
 
@@ -32,3 +33,25 @@ func ReplaceInTemplate(text string, replacements map[string]interface{}) (string
 	}
 	return b.String(), nil
 }
+
+// shellQuote quotes a string for using it as a single argument in a shell
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
+
+// shellQuoteIfNeeded quotes a string for a shell only when it contains
+// characters that are not safe in a path
+func shellQuoteIfNeeded(s string) string {
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("/._-+:@%=,", c):
+		default:
+			return shellQuote(s)
+		}
+	}
+	if s == "" {
+		return shellQuote(s)
+	}
+	return s
+}
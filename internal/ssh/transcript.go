@@ -0,0 +1,149 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maximum number of lines of output recorded (for each command) in the transcript
+	transcriptMaxLines = 50
+
+	// exit status recorded when the command could not be run or finished
+	transcriptNoExitStatus = -1
+)
+
+// TranscriptEntry is a command run in the host, with its result
+type TranscriptEntry struct {
+	Command    string
+	ExitStatus int
+	Duration   time.Duration
+	Output     []string
+	Truncated  int
+	Error      string
+}
+
+// Transcript is a local file where all the commands run in a host are recorded,
+// with their exit status, duration and (truncated) output
+type Transcript struct {
+	sync.Mutex
+
+	// Path is the path of the transcript file
+	Path string
+
+	file *os.File
+}
+
+// NewTranscript opens (for appending) the transcript file for a host, replacing
+// the "{host}" and "{role}" placeholders in the pattern (see GetHostLogPath())
+func NewTranscript(pattern string, host Host) (*Transcript, error) {
+	path := GetHostLogPath(pattern, host)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Transcript{Path: path, file: file}, nil
+}
+
+// Record writes an entry in the transcript
+func (t *Transcript) Record(entry TranscriptEntry) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s $ %s\n", time.Now().UTC().Format(time.RFC3339), entry.Command)
+	for _, line := range entry.Output {
+		fmt.Fprintf(&sb, "  | %s\n", hostLogColorCodes.ReplaceAllString(line, ""))
+	}
+	if entry.Truncated > 0 {
+		fmt.Fprintf(&sb, "  | (%d more lines)\n", entry.Truncated)
+	}
+	fmt.Fprintf(&sb, "  exit status: %d, duration: %s\n", entry.ExitStatus, entry.Duration.Round(time.Millisecond))
+	if entry.Error != "" {
+		fmt.Fprintf(&sb, "  error: %s\n", entry.Error)
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if t.file == nil {
+		return
+	}
+	if _, err := t.file.WriteString(sb.String()); err != nil {
+		Debug("could not write in the transcript %s: %s", t.Path, err)
+	}
+}
+
+// Close closes the transcript file
+func (t *Transcript) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// WithTranscript returns a new context where all the commands are
+// recorded in a transcript
+func WithTranscript(ctx context.Context, t *Transcript) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.transcript = t
+	})
+}
+
+// transcriptOutput collects the (first lines of the) output of a command
+type transcriptOutput struct {
+	sync.Mutex
+
+	lines     []string
+	truncated int
+}
+
+// Tee returns an output that collects the lines as well as sending them to `out`
+func (o *transcriptOutput) Tee(out UIOutput) UIOutput {
+	return OutputFunc(func(s string) {
+		o.Lock()
+		if len(o.lines) < transcriptMaxLines {
+			o.lines = append(o.lines, s)
+		} else {
+			o.truncated++
+		}
+		o.Unlock()
+		out.Output(s)
+	})
+}
+
+// recordTranscript records a command in the transcript (if any)
+func recordTranscript(ctx context.Context, entry TranscriptEntry, output *transcriptOutput) {
+	t := getSSHContext(ctx).transcript
+	if t == nil {
+		return
+	}
+	if output != nil {
+		output.Lock()
+		entry.Output, entry.Truncated = output.lines, output.truncated
+		output.Unlock()
+	}
+	t.Record(entry)
+}
@@ -0,0 +1,71 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranscript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "transcript")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	pattern := filepath.Join(dir, "kubeadm", "{host}.log")
+	host := Host{Address: "10.0.0.1"}
+	transcript, err := NewTranscript(pattern, host)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	long := []string{}
+	for i := 0; i < transcriptMaxLines+10; i++ {
+		long = append(long, fmt.Sprintf("line %d", i))
+	}
+
+	ctx := WithTranscript(NewTestingContextWithResponses([]string{
+		"node-1",
+		strings.Join(long, "\n"),
+	}), transcript)
+	res := ActionList{
+		DoExec("hostname"),
+		DoExec("journalctl -u kubelet"),
+	}.Apply(ctx)
+	if IsError(res) {
+		t.Fatalf("Error: could not run commands: %s", res)
+	}
+	_ = transcript.Close()
+
+	contents, err := ioutil.ReadFile(filepath.Join(dir, "kubeadm", "10.0.0.1.log"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for _, expected := range []string{
+		"$ hostname\n  | node-1\n  exit status: 0, duration:",
+		"$ journalctl -u kubelet\n  | line 0\n",
+		fmt.Sprintf("  | line %d\n  | (10 more lines)\n", transcriptMaxLines-1),
+	} {
+		if !strings.Contains(string(contents), expected) {
+			t.Fatalf("Error: %q not found in the transcript:\n%s", expected, contents)
+		}
+	}
+}
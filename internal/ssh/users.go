@@ -0,0 +1,145 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const (
+	// shell for system users
+	defSystemUserShell = "/sbin/nologin"
+
+	// script for checking if a user exists (the user is in "$1")
+	userExistsScript = `id -u "$1" >/dev/null 2>&1`
+
+	// scripts for checking if a group exists (the group is in "$1")
+	groupExistsScript        = `getent group "$1" >/dev/null 2>&1`
+	busyboxGroupExistsScript = `grep -q "^$1:" /etc/group`
+)
+
+// SystemUser is a system user in a remote machine
+type SystemUser struct {
+	Name string
+
+	// primary group (will be created if it does not exist)
+	Group string
+
+	// home directory (it will not be created)
+	Home string
+
+	// supplementary groups (must exist)
+	Groups []string
+}
+
+// CheckUserExists checks if a user exists in the remote machine
+func CheckUserExists(name string) CheckerFunc {
+	return CheckExecCommand(NewShellCommand(userExistsScript, name))
+}
+
+// CheckGroupExists checks if a group exists in the remote machine
+// (BusyBox does not always provide `getent`)
+func CheckGroupExists(name string) CheckerFunc {
+	return CheckerFunc(func(ctx context.Context) (bool, error) {
+		if IsBusyBox(ctx) {
+			return CheckExecCommand(NewShellCommand(busyboxGroupExistsScript, name))(ctx)
+		}
+		return CheckExecCommand(NewShellCommand(groupExistsScript, name))(ctx)
+	})
+}
+
+// getCreateSystemGroupCommand returns the command for creating a system group
+func getCreateSystemGroupCommand(name string, busybox bool) Command {
+	if busybox {
+		return NewCommand("addgroup", "-S", name)
+	}
+	return NewCommand("groupadd", "--system", name)
+}
+
+// DoCreateSystemGroup creates a system group (only if it does not exist)
+func DoCreateSystemGroup(name string) Action {
+	if name == "" {
+		return ActionError("empty group name")
+	}
+	return DoOnce(
+		fmt.Sprintf("%s-group-%s", CacheRemoteUserExistsPrefix, name),
+		ActionList{
+			DoMessageDebug(fmt.Sprintf("Making sure group %q exists", name)),
+			ActionFunc(func(ctx context.Context) Action {
+				return DoIf(
+					CheckNot(CheckGroupExists(name)),
+					DoExecCommand(getCreateSystemGroupCommand(name, IsBusyBox(ctx))))
+			}),
+		})
+}
+
+// getCreateSystemUserCommands returns the commands for creating a system user
+func getCreateSystemUserCommands(user SystemUser, busybox bool) []Command {
+	if busybox {
+		// BusyBox's adduser does not support supplementary groups: they are added later
+		args := []string{"-S", "-D", "-H", "-s", defSystemUserShell}
+		if user.Group != "" {
+			args = append(args, "-G", user.Group)
+		}
+		if user.Home != "" {
+			args = append(args, "-h", user.Home)
+		}
+		cmds := []Command{NewCommand("adduser", append(args, user.Name)...)}
+		for _, group := range user.Groups {
+			cmds = append(cmds, NewCommand("addgroup", user.Name, group))
+		}
+		return cmds
+	}
+
+	args := []string{"--system", "--no-create-home", "--shell", defSystemUserShell}
+	if user.Group != "" {
+		args = append(args, "--gid", user.Group)
+	}
+	if user.Home != "" {
+		args = append(args, "--home-dir", user.Home)
+	}
+	if len(user.Groups) > 0 {
+		args = append(args, "--groups", strings.Join(user.Groups, ","))
+	}
+	return []Command{NewCommand("useradd", append(args, user.Name)...)}
+}
+
+// DoCreateSystemUser creates a system user (only if it does not exist),
+// creating the primary group too when necessary
+func DoCreateSystemUser(user SystemUser) Action {
+	if user.Name == "" {
+		return ActionError("empty user name")
+	}
+
+	actions := ActionList{}
+	if user.Group != "" {
+		actions = append(actions, DoCreateSystemGroup(user.Group))
+	}
+	return append(actions,
+		DoOnce(
+			fmt.Sprintf("%s-user-%s", CacheRemoteUserExistsPrefix, user.Name),
+			ActionList{
+				DoMessageDebug(fmt.Sprintf("Making sure user %q exists", user.Name)),
+				ActionFunc(func(ctx context.Context) Action {
+					actions := ActionList{}
+					for _, cmd := range getCreateSystemUserCommands(user, IsBusyBox(ctx)) {
+						actions = append(actions, DoExecCommand(cmd))
+					}
+					return DoIf(CheckNot(CheckUserExists(user.Name)), actions)
+				}),
+			}))
+}
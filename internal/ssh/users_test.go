@@ -0,0 +1,76 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+)
+
+func TestDoCreateSystemUser(t *testing.T) {
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+
+	actions := ActionList{
+		DoCreateSystemUser(SystemUser{Name: "etcd", Group: "etcd", Home: "/var/lib/etcd"}),
+		DoCreateSystemUser(SystemUser{Name: "etcd", Group: "etcd", Home: "/var/lib/etcd"}),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	expected := []string{
+		`sh -c 'getent group "$1" >/dev/null 2>&1' sh etcd && echo 'CONDITION_SUCCEEDED' || echo 'CONDITION_FAILED'`,
+		"groupadd --system etcd",
+		`sh -c 'id -u "$1" >/dev/null 2>&1' sh etcd && echo 'CONDITION_SUCCEEDED' || echo 'CONDITION_FAILED'`,
+		"useradd --system --no-create-home --shell /sbin/nologin --gid etcd --home-dir /var/lib/etcd etcd",
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("Error: unexpected command %q (expected %q)", commands[i], expected[i])
+		}
+	}
+}
+
+func TestDoCreateSystemUserBusyBox(t *testing.T) {
+	commands := []string{}
+	ctx := NewTestingContextWithCommunicator(testRecordingCommunicator{commands: &commands})
+
+	actions := ActionList{
+		DoSetFact(FactUserland, UserlandBusyBox),
+		DoCreateSystemUser(SystemUser{Name: "etcd", Group: "etcd", Groups: []string{"wheel"}}),
+	}
+	if res := actions.Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when running actions: %s", res)
+	}
+
+	expected := []string{
+		`sh -c 'grep -q "^$1:" /etc/group' sh etcd && echo 'CONDITION_SUCCEEDED' || echo 'CONDITION_FAILED'`,
+		"addgroup -S etcd",
+		`sh -c 'id -u "$1" >/dev/null 2>&1' sh etcd && echo 'CONDITION_SUCCEEDED' || echo 'CONDITION_FAILED'`,
+		"adduser -S -D -H -s /sbin/nologin -G etcd etcd",
+		"addgroup etcd wheel",
+	}
+	if len(commands) != len(expected) {
+		t.Fatalf("Error: unexpected commands: %q", commands)
+	}
+	for i := range expected {
+		if commands[i] != expected[i] {
+			t.Fatalf("Error: unexpected command %q (expected %q)", commands[i], expected[i])
+		}
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// DefWaitForSSHInterval is the interval between checks while waiting for SSH
+	DefWaitForSSHInterval = 5 * time.Second
+
+	// waitForSSHDialTimeout is the maximum time for every connection attempt
+	// (including reading the banner) while waiting for SSH
+	waitForSSHDialTimeout = 10 * time.Second
+)
+
+// WaitForSSH waits (at most `timeout`) until there is an SSH server
+// listening at `address` (ie, "host:22"), checking every `interval`
+// that it accepts connections and presents an SSH banner.
+// This can be used when the host is still booting (ie, when it has just
+// been created by a cloud resource in the same apply).
+func WaitForSSH(ctx context.Context, address string, timeout time.Duration, interval time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := checkSSHBanner(ctx, address)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(interval).After(deadline) {
+			return fmt.Errorf("timeout: SSH not available at %s after %s: %s", address, timeout, err)
+		}
+
+		Debug("SSH not available at %s yet (%s): retrying in %s", address, err, interval)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return fmt.Errorf("cancelled while waiting for SSH at %s: %s", address, ctx.Err())
+		}
+	}
+}
+
+// checkSSHBanner connects to `address` and checks it presents an SSH banner
+func checkSSHBanner(ctx context.Context, address string) error {
+	dialer := net.Dialer{Timeout: waitForSSHDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// the server can send other lines before the version (RFC 4253, section 4.2)
+	_ = conn.SetReadDeadline(time.Now().Add(waitForSSHDialTimeout))
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, "SSH-") {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("no SSH banner received: %s", err)
+		}
+	}
+}
+
+// DoWaitForSSH waits (at most `timeout`) until commands can be run in the node,
+// reconnecting after every failed attempt. It should be used before the first
+// command when the node could still be booting.
+func DoWaitForSSH(timeout time.Duration) Action {
+	return doWaitForSSH(timeout, DefWaitForSSHInterval)
+}
+
+func doWaitForSSH(timeout time.Duration, interval time.Duration) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			return nil
+		}
+
+		Debug("waiting (at most %s) until commands can be run in the node", timeout)
+		failed := false
+		return doWaitUntil(timeout, interval, ActionFunc(func(ctx context.Context) Action {
+			actions := ActionList{}
+			if failed {
+				actions = append(actions, doReconnect())
+			}
+			actions = append(actions, DoWithTimeout(DoExec(keepAliveCommand), DefRebootPollTimeout))
+
+			res := actions.Apply(ctx)
+			failed = IsError(res)
+			return res
+		}))
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/communicator/remote"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// unreachableCommunicator fails the first `failures` commands
+type unreachableCommunicator struct {
+	DummyCommunicator
+	failures *int
+	connects *int
+}
+
+func (c unreachableCommunicator) Connect(terraform.UIOutput) error {
+	*c.connects++
+	return nil
+}
+
+func (c unreachableCommunicator) Start(cmd *remote.Cmd) error {
+	if *c.failures > 0 {
+		*c.failures--
+		return errors.New("connection refused")
+	}
+	cmd.Init()
+	cmd.SetExitStatus(0, nil)
+	return nil
+}
+
+func TestDoWaitForSSH(t *testing.T) {
+	failures, connects := 2, 0
+	ctx := NewTestingContextWithCommunicator(unreachableCommunicator{failures: &failures, connects: &connects})
+	if res := (ActionList{doWaitForSSH(time.Second, time.Millisecond)}).Apply(ctx); IsError(res) {
+		t.Fatalf("Error: when waiting for SSH: %s", res)
+	}
+	if connects != 2 {
+		t.Fatalf("Error: %d reconnections, expected 2", connects)
+	}
+
+	failures = 1000
+	if res := (ActionList{doWaitForSSH(20*time.Millisecond, 5*time.Millisecond)}).Apply(ctx); !IsError(res) {
+		t.Fatalf("Error: no error when SSH is never available")
+	}
+}
+
+func TestWaitForSSH(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: could not listen: %s", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("Welcome\r\nSSH-2.0-OpenSSH_8.0\r\n"))
+			conn.Close()
+		}
+	}()
+
+	if err := WaitForSSH(context.Background(), l.Addr().String(), time.Second, time.Millisecond); err != nil {
+		t.Fatalf("Error: when waiting for SSH: %s", err)
+	}
+
+	// nothing listening in this address
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: could not listen: %s", err)
+	}
+	address := closed.Addr().String()
+	closed.Close()
+	if err := WaitForSSH(context.Background(), address, 20*time.Millisecond, 5*time.Millisecond); err == nil {
+		t.Fatalf("Error: no error when SSH is not available")
+	}
+}
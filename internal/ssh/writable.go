@@ -0,0 +1,90 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// WithWritablePaths returns a new context where the SSH user can write in some
+// directories (ie, with ACLs granted with `setfacl`), so the files there are
+// uploaded, moved and removed without any privilege escalation
+func WithWritablePaths(ctx context.Context, paths []string) context.Context {
+	return withDerivedSSHContext(ctx, func(sshc *sshContext) {
+		sshc.writablePaths = append([]string{}, paths...)
+	})
+}
+
+// isWritablePath returns true if the SSH user can write a path without
+// privilege escalation (temporary files are always writable)
+func isWritablePath(ctx context.Context, p string) bool {
+	if IsTempFilename(p) {
+		return true
+	}
+	p = path.Clean(p)
+	for _, dir := range getSSHContext(ctx).writablePaths {
+		dir = path.Clean(dir)
+		if p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// doWithoutEscalationIn runs an action without privilege escalation when
+// all the `paths` are writable by the SSH user
+func doWithoutEscalationIn(action Action, paths ...string) Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if len(getSSHContext(ctx).writablePaths) == 0 || !GetUseSudoFromContext(ctx) {
+			return action
+		}
+		for _, p := range paths {
+			if !isWritablePath(ctx, p) {
+				return action
+			}
+		}
+		Debug("%s writable by the SSH user: not using privilege escalation", strings.Join(paths, ", "))
+		return ActionList{action}.Apply(withUseSudo(ctx, false))
+	})
+}
+
+// DoCheckWritablePaths checks that the SSH user can write in all the writable paths
+func DoCheckWritablePaths() Action {
+	return ActionFunc(func(ctx context.Context) Action {
+		if IsDryRun(ctx) {
+			return nil
+		}
+
+		notWritable := []string{}
+		for _, dir := range getSSHContext(ctx).writablePaths {
+			cmd := NewShellCommand(`mkdir -p "$1" 2>/dev/null ; [ -w "$1" ]`, dir)
+			ok, err := CheckExecCommand(cmd).Check(withUseSudo(ctx, false))
+			if err != nil {
+				return ActionError(fmt.Sprintf("could not check if %q is writable: %s", dir, err))
+			}
+			if !ok {
+				notWritable = append(notWritable, dir)
+			}
+		}
+		if len(notWritable) > 0 {
+			return ActionError(fmt.Sprintf("%s not writable by the SSH user: grant access with "+
+				"`setfacl -R -m u:<user>:rwX -m d:u:<user>:rwX <dir>`", strings.Join(notWritable, ", ")))
+		}
+		return nil
+	})
+}
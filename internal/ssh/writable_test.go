@@ -0,0 +1,68 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWritablePaths(t *testing.T) {
+	const tmp = "/tmp/tmpfile-1234.tmp"
+
+	testCases := []struct {
+		action   Action
+		escalate bool
+	}{
+		{DoMoveFile(tmp, "/etc/kubernetes/admin.conf"), false},
+		{DoMoveFileWithMode(tmp, "/var/lib/kubelet/config.yaml", DefFileMode), false},
+		{DoMoveFileWithMode(tmp, "/etc/kubernetes/pki/ca.key", DefPrivateFileMode), true},
+		{DoMoveFile(tmp, "/etc/kubernetes-old/admin.conf"), true},
+		{DoMoveFile(tmp, "/etc/hosts"), true},
+		{DoDeleteFile("/etc/kubernetes/manifests/etcd.yaml"), false},
+		{DoMkdir("/etc/kubernetes/manifests"), false},
+		{DoMkdir("/etc"), true},
+	}
+
+	for i, testCase := range testCases {
+		commands := []string{}
+		ctx := WithValues(context.Background(), DummyOutput{}, DummyOutput{},
+			testRecordingCommunicator{commands: &commands}, true)
+		ctx = WithWritablePaths(ctx, []string{"/etc/kubernetes", "/var/lib/kubelet/"})
+
+		if res := (ActionList{testCase.action}).Apply(ctx); IsError(res) {
+			t.Fatalf("Error: could not run action %d: %s", i, res)
+		}
+		if len(commands) != 1 {
+			t.Fatalf("Error: unexpected commands in %d: %q", i, commands)
+		}
+		if escalated := strings.HasPrefix(commands[0], "sudo "); escalated != testCase.escalate {
+			t.Fatalf("Error: unexpected privilege escalation in %d: %q", i, commands[0])
+		}
+	}
+}
+
+func TestDoCheckWritablePaths(t *testing.T) {
+	ctx := WithWritablePaths(NewTestingContextWithResponses([]string{"CONDITION_SUCCEEDED", "CONDITION_FAILED"}),
+		[]string{"/etc/kubernetes", "/var/lib/kubelet"})
+	res := (ActionList{DoCheckWritablePaths()}).Apply(ctx)
+	if !IsError(res) {
+		t.Fatalf("Error: no error for a directory that is not writable")
+	}
+	if !strings.Contains(res.Error(), "/var/lib/kubelet not writable") || strings.Contains(res.Error(), "/etc/kubernetes,") {
+		t.Fatalf("Error: unexpected error: %s", res)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	// version of the Azure Storage REST API
+	azureAPIVersion = "2018-03-28"
+)
+
+// azureStorage is a storage in an Azure Blob container,
+// authenticated with a SAS token
+type azureStorage struct {
+	container string
+	prefix    string
+	endpoint  string
+	sasToken  string
+}
+
+// azureListResult is the result of a "List Blobs" operation
+type azureListResult struct {
+	Blobs struct {
+		Blob []struct {
+			Name string `xml:"Name"`
+		} `xml:"Blob"`
+	} `xml:"Blobs"`
+	NextMarker string `xml:"NextMarker"`
+}
+
+func newAzureStorage(container, prefix string, config Config) (Storage, error) {
+	account := getEnv(config.AzureAccount, "AZURE_STORAGE_ACCOUNT")
+	sasToken := strings.TrimPrefix(getEnv(config.AzureSASToken, "AZURE_STORAGE_SAS_TOKEN"), "?")
+	if sasToken == "" {
+		return nil, fmt.Errorf("no SAS token provided for Azure Blob storage")
+	}
+
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		if account == "" {
+			return nil, fmt.Errorf("no account provided for Azure Blob storage")
+		}
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net", account)
+	}
+
+	return azureStorage{
+		container: container,
+		prefix:    prefix,
+		endpoint:  strings.TrimSuffix(endpoint, "/"),
+		sasToken:  sasToken,
+	}, nil
+}
+
+// do performs a request for a blob (or for the container when `name` is empty)
+func (s azureStorage) do(ctx context.Context, method, name string, query url.Values, body io.Reader) (*http.Response, error) {
+	u := fmt.Sprintf("%s/%s", s.endpoint, s.container)
+	if name != "" {
+		u = u + "/" + joinKey(s.prefix, name)
+	}
+	q := s.sasToken
+	if len(query) > 0 {
+		q = query.Encode() + "&" + q
+	}
+
+	req, err := http.NewRequest(method, u+"?"+q, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	if method == http.MethodPut {
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return resp, fmt.Errorf("%s %s failed with status %q: %s", method, u, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+func (s azureStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	// note: the content length must be known, so we must read everything
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, name, nil, bytes.NewReader(contents))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s azureStorage) Get(ctx context.Context, name string, w io.Writer) error {
+	resp, err := s.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+func (s azureStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	res := []string{}
+	marker := ""
+	for {
+		query := url.Values{}
+		query.Set("restype", "container")
+		query.Set("comp", "list")
+		query.Set("prefix", joinKey(s.prefix, prefix))
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := azureListResult{}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse list of blobs: %s", err)
+		}
+
+		for _, b := range result.Blobs.Blob {
+			res = append(res, trimKey(s.prefix, b.Name))
+		}
+		if result.NextMarker == "" {
+			break
+		}
+		marker = result.NextMarker
+	}
+	return res, nil
+}
+
+func (s azureStorage) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, name, nil, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s azureStorage) String() string {
+	return fmt.Sprintf("azblob://%s/%s", s.container, s.prefix)
+}
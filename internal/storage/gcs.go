@@ -0,0 +1,108 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// gcsStorage is a storage in a Google Cloud Storage bucket
+type gcsStorage struct {
+	bucket string
+	prefix string
+	client *storage.Client
+}
+
+func newGCSStorage(ctx context.Context, bucket, prefix string, config Config) (Storage, error) {
+	opts := []option.ClientOption{}
+
+	// credentials can be some JSON contents or a file name. Otherwise,
+	// the default credentials (ie, GOOGLE_APPLICATION_CREDENTIALS) will be used
+	if creds := config.GCSCredentials; creds != "" {
+		if strings.HasPrefix(strings.TrimSpace(creds), "{") {
+			opts = append(opts, option.WithCredentialsJSON([]byte(creds)))
+		} else {
+			opts = append(opts, option.WithCredentialsFile(creds))
+		}
+	}
+	if config.Endpoint != "" {
+		opts = append(opts, option.WithEndpoint(config.Endpoint))
+	}
+
+	client, err := storage.NewClient(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create GCS client: %s", err)
+	}
+
+	return gcsStorage{bucket: bucket, prefix: prefix, client: client}, nil
+}
+
+func (s gcsStorage) object(name string) *storage.ObjectHandle {
+	return s.client.Bucket(s.bucket).Object(joinKey(s.prefix, name))
+}
+
+func (s gcsStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	w := s.object(name).NewWriter(ctx)
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s gcsStorage) Get(ctx context.Context, name string, w io.Writer) error {
+	r, err := s.object(name).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (s gcsStorage) List(ctx context.Context, prefix string) ([]string, error) {
+	res := []string{}
+	it := s.client.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: joinKey(s.prefix, prefix)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, trimKey(s.prefix, attrs.Name))
+	}
+	return res, nil
+}
+
+func (s gcsStorage) Delete(ctx context.Context, name string) error {
+	err := s.object(name).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return nil
+	}
+	return err
+}
+
+func (s gcsStorage) String() string {
+	return fmt.Sprintf("gs://%s/%s", s.bucket, s.prefix)
+}
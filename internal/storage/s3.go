@@ -0,0 +1,109 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// s3Storage is a storage in a S3-compatible bucket
+type s3Storage struct {
+	bucket string
+	prefix string
+	sess   *session.Session
+}
+
+func newS3Storage(bucket, prefix string, config Config) (Storage, error) {
+	awsConfig := aws.NewConfig()
+
+	if region := getEnv(config.Region, "AWS_REGION", "AWS_DEFAULT_REGION"); region != "" {
+		awsConfig = awsConfig.WithRegion(region)
+	}
+	if config.Endpoint != "" {
+		// S3-compatible services (ie, Minio) usually need path-style addressing
+		awsConfig = awsConfig.WithEndpoint(config.Endpoint).WithS3ForcePathStyle(true)
+	}
+	if config.AccessKey != "" && config.SecretKey != "" {
+		awsConfig = awsConfig.WithCredentials(credentials.NewStaticCredentials(config.AccessKey, config.SecretKey, ""))
+	}
+	// otherwise, the credentials will be obtained from the environment
+
+	sess, err := session.NewSession(awsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("could not create S3 session: %s", err)
+	}
+
+	return s3Storage{bucket: bucket, prefix: prefix, sess: sess}, nil
+}
+
+func (s s3Storage) Put(ctx context.Context, name string, r io.Reader) error {
+	_, err := s3manager.NewUploader(s.sess).UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(joinKey(s.prefix, name)),
+		Body:   r,
+	})
+	return err
+}
+
+func (s s3Storage) Get(ctx context.Context, name string, w io.Writer) error {
+	out, err := s3.New(s.sess).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(joinKey(s.prefix, name)),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+func (s s3Storage) List(ctx context.Context, prefix string) ([]string, error) {
+	res := []string{}
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(joinKey(s.prefix, prefix)),
+	}
+	err := s3.New(s.sess).ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, obj := range page.Contents {
+			res = append(res, trimKey(s.prefix, aws.StringValue(obj.Key)))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s s3Storage) Delete(ctx context.Context, name string) error {
+	_, err := s3.New(s.sess).DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(joinKey(s.prefix, name)),
+	})
+	return err
+}
+
+func (s s3Storage) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
@@ -0,0 +1,139 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// Storage is a place where artifacts (backups, snapshots...) can be pushed to and pulled from
+type Storage interface {
+	// Put stores the contents of the reader with the given name
+	Put(ctx context.Context, name string, r io.Reader) error
+
+	// Get writes the contents of the artifact with the given name to a writer
+	Get(ctx context.Context, name string, w io.Writer) error
+
+	// List returns the names of the artifacts that start with some prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// Delete removes an artifact
+	Delete(ctx context.Context, name string) error
+
+	// String returns a description of the storage
+	String() string
+}
+
+// Config is the configuration (ie, credentials) used for the storages.
+// Empty values are obtained from the environment.
+type Config struct {
+	// Endpoint is the URL of the service (for S3-compatible services or Azure Blob emulators)
+	Endpoint string
+
+	// Region is the S3 region
+	Region string
+
+	// AccessKey and SecretKey are the S3 credentials
+	AccessKey string
+	SecretKey string
+
+	// GCSCredentials is the GCS credentials (the JSON contents or a file name)
+	GCSCredentials string
+
+	// AzureAccount and AzureSASToken are the Azure Blob credentials
+	AzureAccount  string
+	AzureSASToken string
+}
+
+// getEnv returns `value` or, when empty, the first non-empty environment variable in `envs`
+func getEnv(value string, envs ...string) string {
+	if value != "" {
+		return value
+	}
+	for _, e := range envs {
+		if v := os.Getenv(e); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// IsURL returns true if the location is a storage URL (instead of a local directory)
+func IsURL(location string) bool {
+	for _, scheme := range []string{"s3://", "gs://", "azblob://", "file://"} {
+		if strings.HasPrefix(location, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// New creates a new storage for a location, where the location can be
+// a local directory or an URL like `s3://bucket/prefix`, `gs://bucket/prefix`
+// or `azblob://container/prefix`
+func New(ctx context.Context, location string, config Config) (Storage, error) {
+	if location == "" {
+		return nil, fmt.Errorf("empty storage location")
+	}
+	if !IsURL(location) {
+		return newLocalStorage(location)
+	}
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse storage location %q: %s", location, err)
+	}
+
+	bucket := u.Host
+	prefix := strings.Trim(u.Path, "/")
+	if u.Scheme != "file" && bucket == "" {
+		return nil, fmt.Errorf("no bucket/container in storage location %q", location)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return newLocalStorage(path.Join(u.Host, u.Path))
+	case "s3":
+		return newS3Storage(bucket, prefix, config)
+	case "gs":
+		return newGCSStorage(ctx, bucket, prefix, config)
+	case "azblob":
+		return newAzureStorage(bucket, prefix, config)
+	default:
+		return nil, fmt.Errorf("unknown storage scheme %q", u.Scheme)
+	}
+}
+
+// joinKey joins a prefix and a name for building an object key
+func joinKey(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "/" + name
+}
+
+// trimKey removes the prefix from an object key
+func trimKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return strings.TrimPrefix(key, prefix+"/")
+}
@@ -0,0 +1,167 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testsCases := []struct {
+		location string
+		valid    bool
+	}{
+		{dir, true},
+		{"file://" + dir, true},
+		{"s3://bucket/some/prefix", true},
+		{"s3:///prefix", false},
+		{"azblob://container", false}, // no SAS token
+		{"", false},
+	}
+
+	for _, testCase := range testsCases {
+		_, err := New(context.Background(), testCase.location, Config{Region: "us-east-1"})
+		if testCase.valid && err != nil {
+			t.Fatalf("Error: unexpected error for %q: %v", testCase.location, err)
+		}
+		if !testCase.valid && err == nil {
+			t.Fatalf("Error: no error detected for %q", testCase.location)
+		}
+	}
+}
+
+func testStorageRoundtrip(t *testing.T, s Storage) {
+	ctx := context.Background()
+	contents := []byte("some contents")
+
+	for _, name := range []string{"a-1.tar.gz", "a-2.tar.gz", "b-1.tar.gz"} {
+		if err := s.Put(ctx, name, bytes.NewReader(contents)); err != nil {
+			t.Fatalf("Error: when putting %q: %v", name, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := s.Get(ctx, "a-1.tar.gz", &buf); err != nil {
+		t.Fatalf("Error: when getting: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), contents) {
+		t.Fatalf("Error: unexpected contents: %q", buf.String())
+	}
+
+	if err := s.Delete(ctx, "a-2.tar.gz"); err != nil {
+		t.Fatalf("Error: when deleting: %v", err)
+	}
+
+	names, err := s.List(ctx, "a-")
+	if err != nil {
+		t.Fatalf("Error: when listing: %v", err)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"a-1.tar.gz"}) {
+		t.Fatalf("Error: unexpected list of artifacts: %v", names)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "storage")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(context.Background(), dir, Config{})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	testStorageRoundtrip(t, s)
+
+	// only the owner can read the backups
+	info, err := os.Stat(filepath.Join(dir, "a-1.tar.gz"))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("Error: unexpected permissions: %v", info.Mode().Perm())
+	}
+}
+
+// fakeAzureBlobServer is a (very) minimal Azure Blob service
+func fakeAzureBlobServer() *httptest.Server {
+	blobs := map[string][]byte{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/container/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("comp") == "list":
+			prefix := r.URL.Query().Get("prefix")
+			res := "<EnumerationResults><Blobs>"
+			for k := range blobs {
+				if strings.HasPrefix(k, prefix) {
+					res += "<Blob><Name>" + k + "</Name></Blob>"
+				}
+			}
+			res += "</Blobs><NextMarker/></EnumerationResults>"
+			_, _ = w.Write([]byte(res))
+		case r.Method == http.MethodPut:
+			if r.Header.Get("x-ms-blob-type") != "BlockBlob" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			blobs[name], _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		case r.Method == http.MethodGet:
+			b, ok := blobs[name]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write(b)
+		case r.Method == http.MethodDelete:
+			delete(blobs, name)
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+}
+
+func TestAzureStorage(t *testing.T) {
+	server := fakeAzureBlobServer()
+	defer server.Close()
+
+	config := Config{Endpoint: server.URL, AzureSASToken: "?sv=2018-03-28&sig=secret"}
+	s, err := New(context.Background(), "azblob://container/backups", config)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	testStorageRoundtrip(t, s)
+}
@@ -0,0 +1,134 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/kubeconfig"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+// cluster roles for the bootstrap users
+var BootstrapUserRoles = map[string]string{
+	"admin":  "cluster-admin",
+	"viewer": "view",
+}
+
+// BootstrapBinding is a ClusterRoleBinding created when bootstrapping the cluster
+type BootstrapBinding struct {
+	Name        string
+	ClusterRole string
+	Users       []string
+	Groups      []string
+
+	// service accounts, as "<namespace>:<name>"
+	ServiceAccounts []string
+}
+
+// BootstrapUser is a user created when bootstrapping the cluster
+type BootstrapUser struct {
+	Name   string
+	Role   string
+	Groups []string
+}
+
+// GetBootstrapManifest returns a manifest with the namespaces and the
+// ClusterRoleBindings (including the bindings for the users' roles)
+func GetBootstrapManifest(namespaces []string, bindings []BootstrapBinding, users []BootstrapUser) (string, error) {
+	docs := []string{}
+	for _, ns := range namespaces {
+		docs = append(docs, fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", ns))
+	}
+
+	for _, user := range users {
+		if user.Role == "" {
+			continue
+		}
+		clusterRole, ok := BootstrapUserRoles[user.Role]
+		if !ok {
+			return "", fmt.Errorf("unknown role %q for user %q", user.Role, user.Name)
+		}
+		bindings = append(bindings, BootstrapBinding{
+			Name:        fmt.Sprintf("kubeadm:bootstrap:%s:%s", user.Role, user.Name),
+			ClusterRole: clusterRole,
+			Users:       []string{user.Name},
+		})
+	}
+
+	for _, binding := range bindings {
+		var b strings.Builder
+		b.WriteString(fmt.Sprintf("apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRoleBinding\nmetadata:\n  name: %q\n", binding.Name))
+		b.WriteString(fmt.Sprintf("roleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: ClusterRole\n  name: %q\n", binding.ClusterRole))
+		b.WriteString("subjects:\n")
+		for _, user := range binding.Users {
+			b.WriteString(fmt.Sprintf("  - apiGroup: rbac.authorization.k8s.io\n    kind: User\n    name: %q\n", user))
+		}
+		for _, group := range binding.Groups {
+			b.WriteString(fmt.Sprintf("  - apiGroup: rbac.authorization.k8s.io\n    kind: Group\n    name: %q\n", group))
+		}
+		for _, sa := range binding.ServiceAccounts {
+			parts := strings.SplitN(sa, ":", 2)
+			if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return "", fmt.Errorf("invalid service account %q in binding %q: it must be '<namespace>:<name>'", sa, binding.Name)
+			}
+			b.WriteString(fmt.Sprintf("  - kind: ServiceAccount\n    namespace: %q\n    name: %q\n", parts[0], parts[1]))
+		}
+		if len(binding.Users)+len(binding.Groups)+len(binding.ServiceAccounts) == 0 {
+			return "", fmt.Errorf("no subjects in binding %q", binding.Name)
+		}
+		docs = append(docs, b.String())
+	}
+
+	return strings.Join(docs, "---\n"), nil
+}
+
+// CreateUserKubeconfig creates a kubeconfig for a user, with a client certificate
+// signed by the cluster CA
+func CreateUserKubeconfig(caCrt []byte, caKey []byte, serverURL string, clusterName string, user BootstrapUser) ([]byte, error) {
+	caCerts, err := certutil.ParseCertsPEM(caCrt)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the CA certificate: %s", err)
+	}
+	key, err := keyutil.ParsePrivateKeyPEM(caKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse the CA key: %s", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the CA key is not a RSA key")
+	}
+
+	cert, certKey, err := pkiutil.NewCertAndKey(caCerts[0], rsaKey, &certutil.Config{
+		CommonName:   user.Name,
+		Organization: user.Groups,
+		Usages:       []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create a certificate for %q: %s", user.Name, err)
+	}
+
+	config := kubeconfig.CreateWithCerts(serverURL, clusterName, user.Name,
+		caCrt, pem.EncodeToMemory(&pem.Block{Type: keyutil.RSAPrivateKeyBlockType, Bytes: x509.MarshalPKCS1PrivateKey(certKey)}),
+		pkiutil.EncodeCertPEM(cert))
+	return clientcmd.Write(*config)
+}
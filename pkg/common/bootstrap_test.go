@@ -0,0 +1,94 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/tools/clientcmd"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+)
+
+func TestGetBootstrapManifest(t *testing.T) {
+	manifest, err := GetBootstrapManifest(
+		[]string{"apps"},
+		[]BootstrapBinding{{Name: "deployers", ClusterRole: "edit", Groups: []string{"devs"}, ServiceAccounts: []string{"apps:deployer"}}},
+		[]BootstrapUser{{Name: "alice", Role: "admin"}, {Name: "bob", Role: "viewer"}, {Name: "carol"}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for _, expected := range []string{
+		"kind: Namespace\nmetadata:\n  name: apps\n",
+		"name: \"kubeadm:bootstrap:admin:alice\"\nroleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: ClusterRole\n  name: \"cluster-admin\"\n",
+		"name: \"kubeadm:bootstrap:viewer:bob\"\nroleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: ClusterRole\n  name: \"view\"\n",
+		"  - kind: ServiceAccount\n    namespace: \"apps\"\n    name: \"deployer\"\n",
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Fatalf("Error: %q not found in manifest:\n%s", expected, manifest)
+		}
+	}
+	if strings.Contains(manifest, "carol") {
+		t.Fatalf("Error: binding created for a user without role:\n%s", manifest)
+	}
+
+	if _, err := GetBootstrapManifest(nil, nil, []BootstrapUser{{Name: "alice", Role: "root"}}); err == nil {
+		t.Fatalf("Error: no error detected for an unknown role")
+	}
+	if _, err := GetBootstrapManifest(nil, []BootstrapBinding{{Name: "b", ClusterRole: "edit", ServiceAccounts: []string{"deployer"}}}, nil); err == nil {
+		t.Fatalf("Error: no error detected for an invalid service account")
+	}
+}
+
+func TestCreateUserKubeconfig(t *testing.T) {
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&certutil.Config{CommonName: "kubernetes"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	caCrtPEM := pkiutil.EncodeCertPEM(caCert)
+	caKeyPEM := pem.EncodeToMemory(&pem.Block{Type: keyutil.RSAPrivateKeyBlockType, Bytes: x509.MarshalPKCS1PrivateKey(caKey)})
+
+	data, err := CreateUserKubeconfig(caCrtPEM, caKeyPEM, "https://api.example.com:6443", "kubernetes",
+		BootstrapUser{Name: "alice", Groups: []string{"admins"}})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	config, err := clientcmd.Load(data)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	authInfo, ok := config.AuthInfos["alice"]
+	if !ok {
+		t.Fatalf("Error: no user found in kubeconfig:\n%s", data)
+	}
+	certs, err := certutil.ParseCertsPEM(authInfo.ClientCertificateData)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if certs[0].Subject.CommonName != "alice" || certs[0].Subject.Organization[0] != "admins" {
+		t.Fatalf("Error: unexpected subject: %+v", certs[0].Subject)
+	}
+	if err := certs[0].CheckSignatureFrom(caCert); err != nil {
+		t.Fatalf("Error: certificate not signed by the CA: %v", err)
+	}
+	if config.Clusters["kubernetes"].Server != "https://api.example.com:6443" {
+		t.Fatalf("Error: unexpected server: %+v", config.Clusters)
+	}
+}
@@ -15,6 +15,8 @@
 package common
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -182,6 +184,17 @@ func (c *CertsConfig) FromDisk(certsDir string) error {
 	return nil
 }
 
+// GetCACertHash returns the hash of the public key of a CA certificate (in PEM format),
+// as used in the `--discovery-token-ca-cert-hash` of `kubeadm join` ("sha256:<hex>")
+func GetCACertHash(caCrt string) (string, error) {
+	certs, err := certutil.ParseCertsPEM([]byte(caCrt))
+	if err != nil {
+		return "", fmt.Errorf("could not parse the CA certificate: %s", err)
+	}
+	sum := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // CreateCerts creates the certificates in some temporary directory,
@@ -15,10 +15,17 @@
 package common
 
 import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
 )
 
 func TestCertsSerialization(t *testing.T) {
@@ -63,3 +70,28 @@ func TestCertsSerialization(t *testing.T) {
 		t.Fatalf("Error: etcd_crt does not match")
 	}
 }
+
+func TestGetCACertHash(t *testing.T) {
+	caCert, caKey, err := pkiutil.NewCertificateAuthority(&certutil.Config{CommonName: "kubernetes"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	hash, err := GetCACertHash(string(pkiutil.EncodeCertPEM(caCert)))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&caKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	sum := sha256.Sum256(pub)
+	if expected := "sha256:" + hex.EncodeToString(sum[:]); hash != expected {
+		t.Fatalf("Error: unexpected CA cert hash: %q, expected %q", hash, expected)
+	}
+
+	caKeyPEM := pem.EncodeToMemory(&pem.Block{Type: keyutil.RSAPrivateKeyBlockType, Bytes: x509.MarshalPKCS1PrivateKey(caKey)})
+	if _, err := GetCACertHash(string(caKeyPEM)); err == nil {
+		t.Fatalf("Error: invalid CA certificate not detected")
+	}
+}
@@ -24,6 +24,10 @@ const (
 
 	DefServiceCIDR = "10.96.0.0/12"
 
+	// indexes in the services CIDR of the API server and DNS services (as used by kubeadm)
+	DefAPIServiceIPIndex = 1
+	DefDNSServiceIPIndex = 10
+
 	// kubernetes version to deploy
 	// notes: * leaving it empty leads to some instabililties
 	//        * 1.15.1 seems to be broken (some etcd problems)
@@ -33,10 +37,23 @@ const (
 
 	DefRuntimeEngine = "docker"
 
+	// DefRuntimeStorageDriver is the default snapshotter/storage driver for the runtime
+	// ("auto" detects it from the filesystem where images are stored)
+	DefRuntimeStorageDriver = "auto"
+
 	DefKubeadmInitConfPath = "/etc/kubernetes/kubeadm-init.conf"
 
 	DefKubeadmJoinConfPath = "/etc/kubernetes/kubeadm-join.conf"
 
+	// ConfigMap (in kube-system) where kubeadm stores the configuration of the cluster
+	DefKubeadmConfigMap = "kubeadm-config"
+
+	// file where kubeadm writes the flags for the kubelet
+	DefKubeletFlagsEnvPath = "/var/lib/kubelet/kubeadm-flags.env"
+
+	// file with the identity of the cluster this node belongs to
+	DefClusterIDPath = "/etc/kubernetes/kubeadm-cluster-id"
+
 	DefCniConfDir = "/etc/cni/net.d"
 
 	DefCniLookbackConfPath = "/etc/cni/net.d/99-loopback.conf"
@@ -56,14 +73,111 @@ const (
 	// Full path where we should upload the kubeadm dropin file
 	DefKubeadmDropinPath = "/usr/lib/systemd/system/kubelet.service.d/10-kubeadm.conf"
 
+	// Full path of the kubelet configuration file written by kubeadm
+	DefKubeletConfigPath = "/var/lib/kubelet/config.yaml"
+
+	// Full path where we should upload the systemd-logind configuration for the kubelet
+	DefLogindKubeletConfPath = "/etc/systemd/logind.conf.d/99-kubelet.conf"
+
 	// Default PKI dir
 	DefPKIDir = "/etc/kubernetes/pki"
 
+	// Default kubernetes configuration dir (included in backups)
+	DefKubernetesConfigDir = "/etc/kubernetes"
+
+	// Default file (in the nodes) where the completed upgrade phases are recorded
+	DefUpgradeStatePath = "/etc/kubernetes/kubeadm-upgrade.state"
+
+	// Default timeout for canary nodes being Ready after an upgrade
+	DefUpgradeCanaryTimeout = "5m"
+
+	// directory (in the nodes) where the baseline configuration for the drift watchdog is stored
+	DefDriftBaselineDir = "/etc/kubernetes/kubeadm-baseline"
+
+	// drift watchdog script and systemd units
+	DefDriftWatchdogPath        = "/usr/local/sbin/kubeadm-config-watchdog"
+	DefDriftWatchdogServicePath = "/etc/systemd/system/kubeadm-config-watchdog.service"
+	DefDriftWatchdogTimerPath   = "/etc/systemd/system/kubeadm-config-watchdog.timer"
+
+	// Default audit log (in the nodes) where all the commands executed are recorded
+	DefAuditLogPath = "/var/log/terraform-kubeadm.log"
+
+	// Default local directory for storing backups
+	DefBackupDir = "backups"
+
 	DefAPIServerPort = 6443
 
+	// default annotation keys for stamping nodes with the Terraform run information
+	DefAnnotationWorkspaceKey = "terraform.io/workspace"
+	DefAnnotationAddressKey   = "terraform.io/resource"
+	DefAnnotationTimestampKey = "terraform.io/applied-at"
+
+	// port where the kubelet API listens
+	DefKubeletPort = 10250
+
+	// annotation with the version a canary node has been validated for
+	DefAnnotationUpgradeCanaryKey = "kubeadm.terraform.io/upgrade-canary"
+
+	// annotations with the configuration files drifted from the baseline, and
+	// for requesting the remediation of the drift
+	DefAnnotationConfigDriftKey          = "kubeadm.terraform.io/config-drift"
+	DefAnnotationConfigDriftRemediateKey = "kubeadm.terraform.io/config-drift-remediate"
+
+	// annotation with the unexpected ports listening in control plane nodes
+	DefAnnotationUnexpectedPortsKey = "kubeadm.terraform.io/unexpected-ports"
+
+	// default range of ports for NodePort services
+	DefNodePortsRange = "30000-32767"
+
 	// manifest for loading the dashboard
 	DefDashboardManifest = "https://raw.githubusercontent.com/kubernetes/dashboard/v1.10.1/src/deploy/recommended/kubernetes-dashboard.yaml"
 
+	// manifests for the ingress controllers
+	DefIngressNginxManifest             = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/nginx-0.26.1/deploy/static/mandatory.yaml"
+	DefIngressNginxNodePortManifest     = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/nginx-0.26.1/deploy/static/provider/baremetal/service-nodeport.yaml"
+	DefIngressNginxLoadBalancerManifest = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/nginx-0.26.1/deploy/static/provider/cloud-generic.yaml"
+	DefIngressContourManifest           = "https://raw.githubusercontent.com/projectcontour/contour/v1.0.0/examples/render/contour.yaml"
+
+	// manifest for MetalLB, used for load balancers in bare metal clusters
+	DefMetalLBManifest = "https://raw.githubusercontent.com/google/metallb/v0.8.3/manifests/metallb.yaml"
+
+	// default ingress controller and mode
+	DefIngressController = "nginx"
+	DefIngressMode       = "hostport"
+
+	// manifest (with the version) for cert-manager
+	DefCertManagerManifest = "https://github.com/jetstack/cert-manager/releases/download/%s/cert-manager.yaml"
+
+	// default cert-manager version
+	DefCertManagerVersion = "v0.11.0"
+
+	// default ACME server for the cert-manager issuer
+	DefCertManagerACMEServer = "https://acme-v02.api.letsencrypt.org/directory"
+
+	// default ServiceAccount (and namespace) used by Vault for reviewing tokens
+	DefVaultAuthServiceAccount = "vault-auth"
+	DefVaultAuthNamespace      = "kube-system"
+
+	// image for the CoreDNS autoscaler
+	DefDNSAutoscalerImage = "k8s.gcr.io/cluster-proportional-autoscaler-amd64:1.7.1"
+
+	// default replicas policy for the CoreDNS autoscaler
+	DefDNSAutoscalerCoresPerReplica = 256
+	DefDNSAutoscalerNodesPerReplica = 16
+	DefDNSAutoscalerMin             = 1
+
+	// URL (with version, arch and binary name) for downloading the kubernetes binaries
+	DefKubernetesBinariesURL = "https://storage.googleapis.com/kubernetes-release/release/%s/bin/linux/%s/%s"
+
+	// URL (with version, arch and version) for downloading the CNI plugins tarball
+	DefCNIPluginsURL = "https://github.com/containernetworking/plugins/releases/download/%s/cni-plugins-linux-%s-%s.tgz"
+
+	// default architecture for the binaries
+	DefBinariesArch = "amd64"
+
+	// directory where the binaries are installed in the remote machine
+	DefBinariesDir = "/usr/bin"
+
 	// kubeadm executable in the machines (we assume it is in some standard path)
 	DefKubeadmPath = "kubeadm"
 
@@ -81,6 +195,15 @@ var (
 		"weave":   {Inline: assets.WeaveManifestCode},
 	}
 
+	// CNIPluginsMTUOverhead is the encapsulation overhead for some CNI plugins
+	// (the key is the plugin name, or "<plugin>/<backend>")
+	CNIPluginsMTUOverhead = map[string]int{
+		"flannel/vxlan": 50,
+		"flannel/udp":   28,
+		"flannel/ipip":  20,
+		"weave":         50,
+	}
+
 	// CNIPluginsList gets the list of supported CNI plugins (will be filled by the init())
 	CNIPluginsList = []string{}
 )
@@ -137,3 +260,8 @@ func init() {
 		CNIPluginsList = append(CNIPluginsList, k)
 	}
 }
+
+// GetVaultAuthSecretName returns the name of the token Secret for the Vault ServiceAccount
+func GetVaultAuthSecretName(serviceAccount string) string {
+	return serviceAccount + "-token"
+}
@@ -0,0 +1,299 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/version"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// variable with the kubelet flags in the file written by kubeadm
+	kubeletFlagsEnvVar = "KUBELET_KUBEADM_ARGS"
+)
+
+// components with extra args
+const (
+	ComponentAPIServer         = "kube-apiserver"
+	ComponentControllerManager = "kube-controller-manager"
+	ComponentScheduler         = "kube-scheduler"
+	ComponentKubelet           = "kubelet"
+)
+
+// RemovedFlag is a flag that has been removed from some component
+type RemovedFlag struct {
+	// Component is the component (ie, "kube-scheduler")
+	Component string
+
+	// Flag is the flag, without the dashes (ie, "port")
+	Flag string
+
+	// RemovedIn is the first version without the flag
+	RemovedIn string
+
+	// Replacement is the flag that replaces it (with the same value). The flag is
+	// translated automatically when there is a replacement.
+	Replacement string
+
+	// Ignorable flags can be dropped safely (ie, because it is the default behaviour)
+	Ignorable bool
+}
+
+// RemovedFlags is the compatibility table of flags removed in the control plane components.
+// Removed flags make the components fail at startup, so we must detect them before upgrading.
+var RemovedFlags = []RemovedFlag{
+	{Component: ComponentAPIServer, Flag: "basic-auth-file", RemovedIn: "v1.19.0"},
+	{Component: ComponentAPIServer, Flag: "kubelet-https", RemovedIn: "v1.22.0", Ignorable: true},
+	{Component: ComponentAPIServer, Flag: "insecure-port", RemovedIn: "v1.24.0", Ignorable: true},
+	{Component: ComponentAPIServer, Flag: "insecure-bind-address", RemovedIn: "v1.24.0", Ignorable: true},
+	{Component: ComponentScheduler, Flag: "port", RemovedIn: "v1.23.0", Ignorable: true},
+	{Component: ComponentScheduler, Flag: "address", RemovedIn: "v1.23.0", Replacement: "bind-address"},
+	{Component: ComponentControllerManager, Flag: "port", RemovedIn: "v1.24.0", Ignorable: true},
+	{Component: ComponentControllerManager, Flag: "address", RemovedIn: "v1.24.0", Replacement: "bind-address"},
+	{Component: ComponentKubelet, Flag: "allow-privileged", RemovedIn: "v1.15.0", Ignorable: true},
+	{Component: ComponentKubelet, Flag: "network-plugin", RemovedIn: "v1.24.0", Ignorable: true},
+	{Component: ComponentKubelet, Flag: "network-plugin-mtu", RemovedIn: "v1.24.0"},
+	{Component: ComponentKubelet, Flag: "cni-bin-dir", RemovedIn: "v1.24.0"},
+	{Component: ComponentKubelet, Flag: "cni-conf-dir", RemovedIn: "v1.24.0"},
+	{Component: ComponentKubelet, Flag: "cni-cache-dir", RemovedIn: "v1.24.0"},
+	{Component: ComponentKubelet, Flag: "docker-endpoint", RemovedIn: "v1.24.0"},
+	{Component: ComponentKubelet, Flag: "image-pull-progress-deadline", RemovedIn: "v1.24.0"},
+}
+
+// FixExtraArgsForVersion checks the extra args of a component for some version, returning
+// the args with the removed flags translated (or dropped, when they can be ignored) and a
+// warning for every change. It fails when some flag has been removed without a replacement.
+func FixExtraArgsForVersion(component string, args map[string]string, v string) (map[string]string, []string, error) {
+	if len(args) == 0 {
+		return args, nil, nil
+	}
+	target, err := version.ParseGeneric(v)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid version %q: %s", v, err)
+	}
+
+	res := map[string]string{}
+	for k, value := range args {
+		res[k] = value
+	}
+
+	warnings := []string{}
+	removed := []string{}
+	for _, flag := range RemovedFlags {
+		if flag.Component != component {
+			continue
+		}
+		value, ok := res[flag.Flag]
+		if !ok || !target.AtLeast(version.MustParseGeneric(flag.RemovedIn)) {
+			continue
+		}
+
+		switch {
+		case flag.Replacement != "":
+			if _, exists := res[flag.Replacement]; !exists {
+				res[flag.Replacement] = value
+			}
+			delete(res, flag.Flag)
+			warnings = append(warnings, fmt.Sprintf("%s: --%s has been removed in %s: replaced by --%s",
+				component, flag.Flag, flag.RemovedIn, flag.Replacement))
+		case flag.Ignorable:
+			delete(res, flag.Flag)
+			warnings = append(warnings, fmt.Sprintf("%s: --%s has been removed in %s: ignored",
+				component, flag.Flag, flag.RemovedIn))
+		default:
+			removed = append(removed, fmt.Sprintf("--%s (removed in %s)", flag.Flag, flag.RemovedIn))
+		}
+	}
+
+	if len(removed) > 0 {
+		sort.Strings(removed)
+		return nil, nil, fmt.Errorf("%s %s does not support %s: remove them from the extra args",
+			component, v, strings.Join(removed, ", "))
+	}
+	return res, warnings, nil
+}
+
+// FixInitConfigForVersion fixes the extra args of all the components in
+// an init configuration for some version (see FixExtraArgsForVersion)
+func FixInitConfigForVersion(initConfig *kubeadmapi.InitConfiguration, v string) ([]string, error) {
+	warnings := []string{}
+	for component, args := range map[string]*map[string]string{
+		ComponentAPIServer:         &initConfig.ClusterConfiguration.APIServer.ExtraArgs,
+		ComponentControllerManager: &initConfig.ClusterConfiguration.ControllerManager.ExtraArgs,
+		ComponentScheduler:         &initConfig.ClusterConfiguration.Scheduler.ExtraArgs,
+		ComponentKubelet:           &initConfig.NodeRegistration.KubeletExtraArgs,
+	} {
+		fixed, w, err := FixExtraArgsForVersion(component, *args, v)
+		if err != nil {
+			return nil, err
+		}
+		*args = fixed
+		warnings = append(warnings, w...)
+	}
+	sort.Strings(warnings)
+	return warnings, nil
+}
+
+// FixClusterConfigurationForVersion fixes the extra args of the control plane components in a
+// ClusterConfiguration document (ie, the one stored in the `kubeadm-config` ConfigMap), keeping
+// its API version and all the other fields untouched. The extra args can be a map (up to
+// v1beta3) or a list of `name`/`value` pairs (v1beta4).
+func FixClusterConfigurationForVersion(doc string, v string) (string, []string, error) {
+	cfg := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(doc), &cfg); err != nil {
+		return "", nil, fmt.Errorf("could not parse the cluster configuration: %s", err)
+	}
+
+	warnings := []string{}
+	for key, component := range map[string]string{
+		"apiServer":         ComponentAPIServer,
+		"controllerManager": ComponentControllerManager,
+		"scheduler":         ComponentScheduler,
+	} {
+		section, ok := cfg[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		args, asList := parseExtraArgs(section["extraArgs"])
+		fixed, w, err := FixExtraArgsForVersion(component, args, v)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(w) == 0 {
+			continue
+		}
+		section["extraArgs"] = formatExtraArgs(fixed, asList)
+		warnings = append(warnings, w...)
+	}
+	if len(warnings) == 0 {
+		return doc, nil, nil
+	}
+
+	out, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", nil, fmt.Errorf("could not serialize the cluster configuration: %s", err)
+	}
+	sort.Strings(warnings)
+	return string(out), warnings, nil
+}
+
+// parseExtraArgs parses the extra args in a (generic) kubeadm configuration, returning
+// true when they are a list of `name`/`value` pairs
+func parseExtraArgs(v interface{}) (map[string]string, bool) {
+	args := map[string]string{}
+	switch values := v.(type) {
+	case map[string]interface{}:
+		for k, value := range values {
+			args[k] = fmt.Sprint(value)
+		}
+	case []interface{}:
+		for _, item := range values {
+			if arg, ok := item.(map[string]interface{}); ok {
+				args[fmt.Sprint(arg["name"])] = fmt.Sprint(arg["value"])
+			}
+		}
+		return args, true
+	}
+	return args, false
+}
+
+// formatExtraArgs returns the extra args for a (generic) kubeadm configuration
+func formatExtraArgs(args map[string]string, asList bool) interface{} {
+	if !asList {
+		return args
+	}
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	res := []interface{}{}
+	for _, name := range names {
+		res = append(res, map[string]interface{}{"name": name, "value": args[name]})
+	}
+	return res
+}
+
+// FixKubeletFlagsEnvForVersion fixes the flags in the kubelet environment file written by kubeadm
+// (ie, `KUBELET_KUBEADM_ARGS="--network-plugin=cni ..."`), returning the new contents of the file.
+// The order of the flags is preserved, and flags added as replacements are appended at the end.
+func FixKubeletFlagsEnvForVersion(contents string, v string) (string, []string, error) {
+	lines := strings.Split(contents, "\n")
+	warnings := []string{}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, kubeletFlagsEnvVar+"=") {
+			continue
+		}
+
+		value := strings.Trim(strings.TrimPrefix(line, kubeletFlagsEnvVar+"="), `"`)
+		names := []string{}
+		args := map[string]string{}
+		valueless := map[string]bool{}
+		for _, field := range strings.Fields(value) {
+			kv := strings.SplitN(strings.TrimLeft(field, "-"), "=", 2)
+			names = append(names, kv[0])
+			if len(kv) == 2 {
+				args[kv[0]] = kv[1]
+			} else {
+				args[kv[0]] = ""
+				valueless[kv[0]] = true
+			}
+		}
+
+		fixed, w, err := FixExtraArgsForVersion(ComponentKubelet, args, v)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %s", DefKubeletFlagsEnvPath, err)
+		}
+		if len(w) == 0 {
+			continue
+		}
+
+		flags := []string{}
+		formatFlag := func(name string) string {
+			if valueless[name] {
+				return "--" + name
+			}
+			return fmt.Sprintf("--%s=%s", name, fixed[name])
+		}
+		for _, name := range names {
+			if _, ok := fixed[name]; ok {
+				flags = append(flags, formatFlag(name))
+			}
+		}
+		added := []string{}
+		for name := range fixed {
+			if _, ok := args[name]; !ok {
+				added = append(added, name)
+			}
+		}
+		sort.Strings(added)
+		for _, name := range added {
+			flags = append(flags, formatFlag(name))
+		}
+
+		lines[i] = fmt.Sprintf(`%s="%s"`, kubeletFlagsEnvVar, strings.Join(flags, " "))
+		warnings = append(warnings, w...)
+	}
+	if len(warnings) == 0 {
+		return contents, nil, nil
+	}
+	return strings.Join(lines, "\n"), warnings, nil
+}
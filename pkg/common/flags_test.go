@@ -0,0 +1,171 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+)
+
+func TestFixExtraArgsForVersion(t *testing.T) {
+	args := map[string]string{
+		"address": "0.0.0.0",
+		"port":    "0",
+		"v":       "2",
+	}
+
+	// flags are kept in versions where they are still supported
+	fixed, warnings, err := FixExtraArgsForVersion(ComponentScheduler, args, "v1.22.4")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(fixed, args) || len(warnings) > 0 {
+		t.Fatalf("Error: unexpected args for v1.22: %v (warnings: %v)", fixed, warnings)
+	}
+
+	fixed, warnings, err = FixExtraArgsForVersion(ComponentScheduler, args, "v1.23.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]string{
+		"bind-address": "0.0.0.0",
+		"v":            "2",
+	}
+	if !reflect.DeepEqual(fixed, expected) {
+		t.Fatalf("Error: unexpected args for v1.23: %v", fixed)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Error: unexpected warnings: %v", warnings)
+	}
+	if _, ok := args["port"]; !ok {
+		t.Fatalf("Error: the original args have been modified")
+	}
+
+	// flags without a replacement are rejected
+	if _, _, err := FixExtraArgsForVersion(ComponentKubelet, map[string]string{"cni-bin-dir": "/opt/cni/bin"}, "1.24.1"); err == nil {
+		t.Fatalf("Error: removed kubelet flag not detected")
+	}
+	if _, _, err := FixExtraArgsForVersion(ComponentKubelet, map[string]string{"cni-bin-dir": "/opt/cni/bin"}, "1.23.7"); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if _, _, err := FixExtraArgsForVersion(ComponentScheduler, args, "something"); err == nil {
+		t.Fatalf("Error: invalid version not detected")
+	}
+}
+
+func TestFixInitConfigForVersion(t *testing.T) {
+	initConfig := &kubeadmapi.InitConfiguration{
+		NodeRegistration: kubeadmapi.NodeRegistrationOptions{
+			KubeletExtraArgs: map[string]string{"network-plugin": "cni"},
+		},
+	}
+	initConfig.ClusterConfiguration.ControllerManager.ExtraArgs = map[string]string{"address": "127.0.0.1"}
+
+	warnings, err := FixInitConfigForVersion(initConfig, "v1.24.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Error: unexpected warnings: %v", warnings)
+	}
+	if len(initConfig.NodeRegistration.KubeletExtraArgs) != 0 {
+		t.Fatalf("Error: unexpected kubelet args: %v", initConfig.NodeRegistration.KubeletExtraArgs)
+	}
+	if initConfig.ClusterConfiguration.ControllerManager.ExtraArgs["bind-address"] != "127.0.0.1" {
+		t.Fatalf("Error: unexpected controller manager args: %v", initConfig.ClusterConfiguration.ControllerManager.ExtraArgs)
+	}
+}
+
+func TestFixClusterConfigurationForVersion(t *testing.T) {
+	// v1beta2: extra args are a map, and everything else must be kept
+	doc := `apiVersion: kubeadm.k8s.io/v1beta2
+kind: ClusterConfiguration
+kubernetesVersion: v1.23.5
+controlPlaneEndpoint: 10.0.0.1:6443
+scheduler:
+  extraArgs:
+    address: 0.0.0.0
+    port: "0"
+`
+	fixed, warnings, err := FixClusterConfigurationForVersion(doc, "v1.23.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("Error: unexpected warnings: %v", warnings)
+	}
+	for _, expected := range []string{"apiVersion: kubeadm.k8s.io/v1beta2", "controlPlaneEndpoint: 10.0.0.1:6443", "bind-address: 0.0.0.0"} {
+		if !strings.Contains(fixed, expected) {
+			t.Fatalf("Error: %q not found in the fixed configuration:\n%s", expected, fixed)
+		}
+	}
+	if strings.Contains(fixed, "port:") {
+		t.Fatalf("Error: removed flag found in the fixed configuration:\n%s", fixed)
+	}
+
+	// nothing is changed when there is nothing to fix
+	if same, warnings, err := FixClusterConfigurationForVersion(doc, "v1.22.0"); err != nil || same != doc || len(warnings) > 0 {
+		t.Fatalf("Error: configuration changed for v1.22: %v\n%s", err, same)
+	}
+
+	// v1beta4: extra args are a list of name/value pairs
+	doc = `apiVersion: kubeadm.k8s.io/v1beta4
+kind: ClusterConfiguration
+controllerManager:
+  extraArgs:
+  - name: address
+    value: 127.0.0.1
+`
+	fixed, _, err = FixClusterConfigurationForVersion(doc, "v1.24.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.Contains(fixed, "name: bind-address") || strings.Contains(fixed, "name: address") {
+		t.Fatalf("Error: unexpected fixed configuration:\n%s", fixed)
+	}
+
+	doc = `apiServer:
+  extraArgs:
+    basic-auth-file: /etc/kubernetes/auth.csv
+`
+	if _, _, err := FixClusterConfigurationForVersion(doc, "v1.19.0"); err == nil {
+		t.Fatalf("Error: removed API server flag not detected")
+	}
+}
+
+func TestFixKubeletFlagsEnvForVersion(t *testing.T) {
+	contents := "KUBELET_KUBEADM_ARGS=\"--network-plugin=cni --pod-infra-container-image=k8s.gcr.io/pause:3.6 --fail-swap-on\"\n"
+
+	fixed, warnings, err := FixKubeletFlagsEnvForVersion(contents, "v1.24.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := "KUBELET_KUBEADM_ARGS=\"--pod-infra-container-image=k8s.gcr.io/pause:3.6 --fail-swap-on\"\n"
+	if fixed != expected || len(warnings) != 1 {
+		t.Fatalf("Error: unexpected fixed flags: %q (warnings: %v)", fixed, warnings)
+	}
+
+	if same, _, err := FixKubeletFlagsEnvForVersion(contents, "v1.23.0"); err != nil || same != contents {
+		t.Fatalf("Error: flags changed for v1.23: %q", same)
+	}
+
+	if _, _, err := FixKubeletFlagsEnvForVersion("KUBELET_KUBEADM_ARGS=\"--cni-bin-dir=/opt/cni/bin\"\n", "v1.24.0"); err == nil {
+		t.Fatalf("Error: removed kubelet flag not detected")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefControlPlanePorts are the ports expected to be listening (in non-loopback
+// addresses) in control plane nodes: SSH, etcd, kubelet, kube-proxy healthz,
+// controller manager and scheduler (the API server port is added separately)
+var DefControlPlanePorts = []int{22, 2379, 2380, DefKubeletPort, 10256, 10257, 10259}
+
+// etcdTLSVersions maps the API server TLS versions to the etcd ones
+var etcdTLSVersions = map[string]string{
+	"VersionTLS12": "TLS1.2",
+	"VersionTLS13": "TLS1.3",
+}
+
+// TLSVersions are the TLS minimum versions supported
+var TLSVersions = []string{"VersionTLS12", "VersionTLS13"}
+
+// GetAPIServerTLSArgs returns the API server extra args for the cipher suites and min TLS version
+func GetAPIServerTLSArgs(cipherSuites []string, minVersion string) map[string]string {
+	res := map[string]string{}
+	if len(cipherSuites) > 0 {
+		res["tls-cipher-suites"] = strings.Join(cipherSuites, ",")
+	}
+	if minVersion != "" {
+		res["tls-min-version"] = minVersion
+	}
+	return res
+}
+
+// GetEtcdTLSArgs returns the etcd extra args for the cipher suites and min TLS version
+func GetEtcdTLSArgs(cipherSuites []string, minVersion string) (map[string]string, error) {
+	res := map[string]string{}
+	if len(cipherSuites) > 0 {
+		res["cipher-suites"] = strings.Join(cipherSuites, ",")
+	}
+	if minVersion != "" {
+		v, ok := etcdTLSVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS min version %q: must be one of %s", minVersion, strings.Join(TLSVersions, ", "))
+		}
+		res["tls-min-version"] = v
+	}
+	return res, nil
+}
+
+// PortsRange is a range of ports
+type PortsRange struct {
+	First int
+	Last  int
+}
+
+// ParsePortsRange parses a port or a range of ports (ie, "30000-32767")
+func ParsePortsRange(s string) (PortsRange, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), "-", 2)
+	first, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return PortsRange{}, fmt.Errorf("invalid port %q", s)
+	}
+	last := first
+	if len(parts) == 2 {
+		if last, err = strconv.Atoi(parts[1]); err != nil {
+			return PortsRange{}, fmt.Errorf("invalid ports range %q", s)
+		}
+	}
+	if first <= 0 || last > 65535 || first > last {
+		return PortsRange{}, fmt.Errorf("invalid ports range %q", s)
+	}
+	return PortsRange{First: first, Last: last}, nil
+}
+
+// Contains returns true if the port is in the range
+func (r PortsRange) Contains(port int) bool {
+	return port >= r.First && port <= r.Last
+}
+
+// GetUnexpectedPorts returns the (sorted) ports that are not in any of the allowed ranges
+func GetUnexpectedPorts(ports []int, allowed []PortsRange) []int {
+	res := []int{}
+	for _, port := range ports {
+		found := false
+		for _, r := range allowed {
+			if r.Contains(port) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			res = append(res, port)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGetEtcdTLSArgs(t *testing.T) {
+	args, err := GetEtcdTLSArgs([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}, "VersionTLS12")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]string{
+		"cipher-suites":   "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+		"tls-min-version": "TLS1.2",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Error: unexpected etcd args: %v", args)
+	}
+
+	if _, err := GetEtcdTLSArgs(nil, "VersionTLS10"); err == nil {
+		t.Fatalf("Error: unsupported TLS version not detected")
+	}
+}
+
+func TestGetUnexpectedPorts(t *testing.T) {
+	allowed := []PortsRange{}
+	for _, s := range []string{"22", "6443", "30000-32767"} {
+		r, err := ParsePortsRange(s)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		allowed = append(allowed, r)
+	}
+
+	unexpected := GetUnexpectedPorts([]int{8080, 22, 6443, 31000, 111}, allowed)
+	if !reflect.DeepEqual(unexpected, []int{111, 8080}) {
+		t.Fatalf("Error: unexpected ports: %v", unexpected)
+	}
+
+	for _, s := range []string{"", "abc", "0", "100-10", "1-70000"} {
+		if _, err := ParsePortsRange(s); err == nil {
+			t.Fatalf("Error: invalid range %q not detected", s)
+		}
+	}
+}
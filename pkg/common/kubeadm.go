@@ -17,6 +17,7 @@ package common
 import (
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
@@ -213,8 +214,13 @@ func JoinConfigFromResourceData(d *schema.ResourceData) (*kubeadmapi.JoinConfigu
 		return nil, nil, err
 	}
 
-	// ... update some things, like the seeder, the nodename, etc
-	joinConfig.Discovery.BootstrapToken.APIServerEndpoint = AddressWithPort(seeder, DefAPIServerPort)
+	// ... update some things, like the seeder (or the address used for connecting
+	// to the API server from this node, when different), the nodename, etc
+	endpoint := seeder
+	if addrOpt, ok := d.GetOk("api_connect_address"); ok && strings.TrimSpace(addrOpt.(string)) != "" {
+		endpoint = strings.TrimSpace(addrOpt.(string))
+	}
+	joinConfig.Discovery.BootstrapToken.APIServerEndpoint = AddressWithPort(endpoint, DefAPIServerPort)
 	if nodenameOpt, ok := d.GetOk("nodename"); ok {
 		joinConfig.NodeRegistration.Name = nodenameOpt.(string)
 	}
@@ -0,0 +1,247 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// TopologyManagerPolicies are the policies supported by the kubelet Topology Manager
+var TopologyManagerPolicies = []string{"none", "best-effort", "restricted", "single-numa-node"}
+
+// CPUManagerPolicies are the policies supported by the kubelet CPU Manager
+var CPUManagerPolicies = []string{"none", "static"}
+
+// MemoryManagerPolicies are the policies supported by the kubelet Memory Manager
+var MemoryManagerPolicies = []string{"None", "Static"}
+
+// kubeletResourcesFlagsMinVersions are the first kubelet versions where the
+// resources management flags can be used without enabling any feature gate
+var kubeletResourcesFlagsMinVersions = map[string]string{
+	"reserved-cpus":           "v1.17.0",
+	"topology-manager-policy": "v1.18.0",
+	"memory-manager-policy":   "v1.22.0",
+	"reserved-memory":         "v1.22.0",
+}
+
+// KubeletResources is the resources management configuration for the kubelet
+type KubeletResources struct {
+	TopologyManagerPolicy string
+	CPUManagerPolicy      string
+	MemoryManagerPolicy   string
+	ReservedSystemCPUs    string
+
+	// ReservedMemory is the memory reserved per NUMA node (ie, "0:memory=1Gi;1:memory=2Gi")
+	ReservedMemory string
+}
+
+// ParseCPUSet parses a list of CPUs in the Linux CPU list format (ie, "0-1,4")
+func ParseCPUSet(s string) ([]int, error) {
+	res := []int{}
+	for _, part := range strings.Split(strings.TrimSpace(s), ",") {
+		bounds := strings.SplitN(strings.TrimSpace(part), "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPUs range %q", part)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			res = append(res, cpu)
+		}
+	}
+	return res, nil
+}
+
+// ParseReservedMemory parses the memory reserved per NUMA node, in the format of the
+// kubelet `--reserved-memory` flag (ie, "0:memory=1Gi,hugepages-1Gi=2Gi;1:memory=2Gi")
+func ParseReservedMemory(s string) (map[int]map[string]resource.Quantity, error) {
+	res := map[int]map[string]resource.Quantity{}
+	for _, entry := range strings.Split(strings.TrimSpace(s), ";") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid reserved memory %q: expected <NUMA node>:<resource>=<quantity>", entry)
+		}
+		node, err := strconv.Atoi(parts[0])
+		if err != nil || node < 0 {
+			return nil, fmt.Errorf("invalid NUMA node %q", parts[0])
+		}
+		if _, exists := res[node]; exists {
+			return nil, fmt.Errorf("NUMA node %d reserved more than once", node)
+		}
+		res[node] = map[string]resource.Quantity{}
+		for _, r := range strings.Split(parts[1], ",") {
+			kv := strings.SplitN(r, "=", 2)
+			if len(kv) != 2 || (kv[0] != "memory" && !strings.HasPrefix(kv[0], "hugepages-")) {
+				return nil, fmt.Errorf("invalid reserved resource %q: expected memory=<quantity> or hugepages-<size>=<quantity>", r)
+			}
+			q, err := resource.ParseQuantity(kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quantity %q for %s: %s", kv[1], kv[0], err)
+			}
+			res[node][kv[0]] = q
+		}
+	}
+	return res, nil
+}
+
+// GetKubeletResourcesArgs returns the kubelet extra args for the resources management
+// configuration, for the kubelet version `v`
+func GetKubeletResourcesArgs(r KubeletResources, v string) (map[string]string, error) {
+	res := map[string]string{}
+	if r.ReservedSystemCPUs != "" {
+		if _, err := ParseCPUSet(r.ReservedSystemCPUs); err != nil {
+			return nil, fmt.Errorf("invalid reserved system CPUs %q: %s", r.ReservedSystemCPUs, err)
+		}
+		res["reserved-cpus"] = r.ReservedSystemCPUs
+	}
+	if r.CPUManagerPolicy != "" {
+		// the static policy needs some CPUs reserved for the system, as the
+		// exclusive CPUs are taken from the pool of the remaining CPUs
+		if r.CPUManagerPolicy == "static" && r.ReservedSystemCPUs == "" {
+			return nil, fmt.Errorf("the static CPU manager policy requires some reserved system CPUs")
+		}
+		res["cpu-manager-policy"] = r.CPUManagerPolicy
+	}
+	if r.TopologyManagerPolicy != "" {
+		res["topology-manager-policy"] = r.TopologyManagerPolicy
+	}
+	if r.ReservedMemory != "" {
+		if _, err := ParseReservedMemory(r.ReservedMemory); err != nil {
+			return nil, fmt.Errorf("invalid reserved memory %q: %s", r.ReservedMemory, err)
+		}
+		res["reserved-memory"] = r.ReservedMemory
+	}
+	if r.MemoryManagerPolicy != "" {
+		// the static policy refuses to start without the memory reserved in the NUMA nodes
+		if r.MemoryManagerPolicy == "Static" && r.ReservedMemory == "" {
+			return nil, fmt.Errorf("the Static memory manager policy requires some reserved memory")
+		}
+		res["memory-manager-policy"] = r.MemoryManagerPolicy
+	}
+
+	if len(res) > 0 {
+		target, err := version.ParseGeneric(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %q: %s", v, err)
+		}
+		unsupported := []string{}
+		for flag := range res {
+			if min, ok := kubeletResourcesFlagsMinVersions[flag]; ok && !target.AtLeast(version.MustParseGeneric(min)) {
+				unsupported = append(unsupported, fmt.Sprintf("--%s (requires %s)", flag, min))
+			}
+		}
+		if len(unsupported) > 0 {
+			sort.Strings(unsupported)
+			return nil, fmt.Errorf("kubelet %s does not support %s", v, strings.Join(unsupported, ", "))
+		}
+	}
+	return res, nil
+}
+
+// GetKubeletSeccompDefaultArgs returns the kubelet args for using the RuntimeDefault
+// seccomp profile for all the workloads in the kubelet version `v`, merging the
+// feature gate required before v1.25 with the current `featureGates` flag
+func GetKubeletSeccompDefaultArgs(v string, featureGates string) (map[string]string, error) {
+	target, err := version.ParseGeneric(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version %q: %s", v, err)
+	}
+	if !target.AtLeast(version.MustParseGeneric("v1.22.0")) {
+		return nil, fmt.Errorf("kubelet %s does not support --seccomp-default (requires v1.22.0)", v)
+	}
+
+	res := map[string]string{"seccomp-default": "true"}
+	if !target.AtLeast(version.MustParseGeneric("v1.25.0")) {
+		gates := []string{}
+		for _, gate := range strings.Split(featureGates, ",") {
+			if gate = strings.TrimSpace(gate); gate != "" && !strings.HasPrefix(gate, "SeccompDefault=") {
+				gates = append(gates, gate)
+			}
+		}
+		res["feature-gates"] = strings.Join(append(gates, "SeccompDefault=true"), ",")
+	}
+	return res, nil
+}
+
+// KubeletShutdown is the graceful node shutdown configuration for the kubelet
+type KubeletShutdown struct {
+	// GracePeriod is the total time the node shutdown is delayed
+	GracePeriod time.Duration
+
+	// CriticalPodsGracePeriod is the part of the GracePeriod
+	// reserved for terminating the critical pods
+	CriticalPodsGracePeriod time.Duration
+}
+
+// ParseKubeletShutdown parses the graceful node shutdown configuration
+// (an empty grace period disables the graceful shutdown)
+func ParseKubeletShutdown(gracePeriod string, criticalPodsGracePeriod string) (KubeletShutdown, error) {
+	res := KubeletShutdown{}
+	if gracePeriod == "" {
+		if criticalPodsGracePeriod != "" {
+			return res, fmt.Errorf("a grace period for critical pods requires a shutdown grace period")
+		}
+		return res, nil
+	}
+
+	var err error
+	if res.GracePeriod, err = time.ParseDuration(gracePeriod); err != nil {
+		return res, fmt.Errorf("invalid shutdown grace period %q: %s", gracePeriod, err)
+	}
+	if criticalPodsGracePeriod != "" {
+		if res.CriticalPodsGracePeriod, err = time.ParseDuration(criticalPodsGracePeriod); err != nil {
+			return res, fmt.Errorf("invalid grace period for critical pods %q: %s", criticalPodsGracePeriod, err)
+		}
+	}
+	if res.GracePeriod < 0 || res.CriticalPodsGracePeriod < 0 {
+		return res, fmt.Errorf("grace periods cannot be negative")
+	}
+	if res.CriticalPodsGracePeriod > res.GracePeriod {
+		return res, fmt.Errorf("the grace period for critical pods (%s) cannot be longer than the shutdown grace period (%s)",
+			res.CriticalPodsGracePeriod, res.GracePeriod)
+	}
+	return res, nil
+}
+
+// IsEnabled returns true if the graceful node shutdown is enabled
+func (s KubeletShutdown) IsEnabled() bool {
+	return s.GracePeriod > 0
+}
+
+// GetKubeletConfig returns the settings for the kubelet configuration file
+func (s KubeletShutdown) GetKubeletConfig() string {
+	return fmt.Sprintf("shutdownGracePeriod: %s\nshutdownGracePeriodCriticalPods: %s\n",
+		s.GracePeriod, s.CriticalPodsGracePeriod)
+}
+
+// GetLogindConfig returns the systemd-logind configuration that lets the
+// kubelet delay the shutdown (with an inhibitor lock) for the grace period
+func (s KubeletShutdown) GetLogindConfig() string {
+	return fmt.Sprintf("[Login]\nInhibitDelayMaxSec=%d\n", int(math.Ceil(s.GracePeriod.Seconds())))
+}
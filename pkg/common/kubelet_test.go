@@ -0,0 +1,126 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUSet(t *testing.T) {
+	cpus, err := ParseCPUSet("0-2,5")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(cpus, []int{0, 1, 2, 5}) {
+		t.Fatalf("Error: unexpected CPUs: %v", cpus)
+	}
+
+	for _, s := range []string{"", "a", "3-1", "0,,1", "-1"} {
+		if _, err := ParseCPUSet(s); err == nil {
+			t.Fatalf("Error: invalid CPU list %q not detected", s)
+		}
+	}
+}
+
+func TestGetKubeletResourcesArgs(t *testing.T) {
+	args, err := GetKubeletResourcesArgs(KubeletResources{
+		TopologyManagerPolicy: "single-numa-node",
+		CPUManagerPolicy:      "static",
+		ReservedSystemCPUs:    "0-1",
+	}, "v1.18.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]string{
+		"topology-manager-policy": "single-numa-node",
+		"cpu-manager-policy":      "static",
+		"reserved-cpus":           "0-1",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Error: unexpected kubelet args: %v", args)
+	}
+
+	if _, err := GetKubeletResourcesArgs(KubeletResources{CPUManagerPolicy: "static"}, "v1.18.0"); err == nil {
+		t.Fatalf("Error: static CPU manager policy without reserved CPUs not detected")
+	}
+
+	if _, err := GetKubeletResourcesArgs(KubeletResources{MemoryManagerPolicy: "Static"}, "v1.22.0"); err == nil {
+		t.Fatalf("Error: Static memory manager policy without reserved memory not detected")
+	}
+	args, err = GetKubeletResourcesArgs(KubeletResources{MemoryManagerPolicy: "Static", ReservedMemory: "0:memory=1Gi"}, "v1.22.0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if args["reserved-memory"] != "0:memory=1Gi" || args["memory-manager-policy"] != "Static" {
+		t.Fatalf("Error: unexpected kubelet args: %v", args)
+	}
+
+	// the flags are checked against the kubelet version
+	if _, err := GetKubeletResourcesArgs(KubeletResources{TopologyManagerPolicy: "best-effort"}, "v1.15.0"); err == nil {
+		t.Fatalf("Error: topology manager policy in v1.15 not detected")
+	}
+	if _, err := GetKubeletResourcesArgs(KubeletResources{MemoryManagerPolicy: "Static", ReservedMemory: "0:memory=1Gi"}, "v1.21.0"); err == nil {
+		t.Fatalf("Error: memory manager policy in v1.21 not detected")
+	}
+	if _, err := GetKubeletResourcesArgs(KubeletResources{CPUManagerPolicy: "none"}, "v1.15.0"); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+}
+
+func TestParseReservedMemory(t *testing.T) {
+	reserved, err := ParseReservedMemory("0:memory=1Gi,hugepages-1Gi=2Gi;1:memory=512Mi")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if q := reserved[0]["hugepages-1Gi"]; q.String() != "2Gi" {
+		t.Fatalf("Error: unexpected reserved memory: %v", reserved)
+	}
+	if q := reserved[1]["memory"]; q.String() != "512Mi" {
+		t.Fatalf("Error: unexpected reserved memory: %v", reserved)
+	}
+
+	for _, s := range []string{"", "memory=1Gi", "a:memory=1Gi", "0:cpu=1", "0:memory=lots", "0:memory=1Gi;0:memory=1Gi"} {
+		if _, err := ParseReservedMemory(s); err == nil {
+			t.Fatalf("Error: invalid reserved memory %q not detected", s)
+		}
+	}
+}
+
+func TestGetKubeletSeccompDefaultArgs(t *testing.T) {
+	if _, err := GetKubeletSeccompDefaultArgs("v1.21.0", ""); err == nil {
+		t.Fatalf("Error: seccomp default in v1.21 not detected")
+	}
+
+	args, err := GetKubeletSeccompDefaultArgs("v1.23.0", "RotateKubeletServerCertificate=true")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]string{
+		"seccomp-default": "true",
+		"feature-gates":   "RotateKubeletServerCertificate=true,SeccompDefault=true",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Error: unexpected kubelet args: %v", args)
+	}
+
+	args, err = GetKubeletSeccompDefaultArgs("v1.25.0", "")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(args, map[string]string{"seccomp-default": "true"}) {
+		t.Fatalf("Error: unexpected kubelet args: %v", args)
+	}
+}
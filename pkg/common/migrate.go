@@ -0,0 +1,86 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+)
+
+// MigrateInitConfig migrates a (serialized) init configuration to the
+// kubeadm API version (and defaults) used by this version of the provider
+func MigrateInitConfig(cfg string) (string, error) {
+	configBytes, err := FromTerraformSafeString(cfg)
+	if err != nil {
+		return "", err
+	}
+	initConfig, err := YAMLToInitConfig(configBytes)
+	if err != nil {
+		return "", err
+	}
+	if initConfig == nil {
+		return "", errNoInitConfigFound
+	}
+	configBytes, err = InitConfigToYAML(initConfig)
+	if err != nil {
+		return "", err
+	}
+	return ToTerraformSafeString(configBytes), nil
+}
+
+// MigrateJoinConfig migrates a (serialized) join configuration to the
+// kubeadm API version (and defaults) used by this version of the provider
+func MigrateJoinConfig(cfg string) (string, error) {
+	configBytes, err := FromTerraformSafeString(cfg)
+	if err != nil {
+		return "", err
+	}
+	joinConfig, err := YAMLToJoinConfig(configBytes)
+	if err != nil {
+		return "", err
+	}
+	if joinConfig == nil {
+		return "", errNoJoinConfigFound
+	}
+	configBytes, err = JoinConfigToYAML(joinConfig)
+	if err != nil {
+		return "", err
+	}
+	return ToTerraformSafeString(configBytes), nil
+}
+
+// MigrateProvisionerConfig migrates the kubeadm configurations in the `config`
+// passed to the provisioner, so configurations stored by previous versions of
+// the provider do not produce spurious diffs
+func MigrateProvisionerConfig(config map[string]interface{}) error {
+	migrations := []struct {
+		key     string
+		migrate func(string) (string, error)
+	}{
+		{"init", MigrateInitConfig},
+		{"join", MigrateJoinConfig},
+	}
+	for _, m := range migrations {
+		cfg, ok := config[m.key].(string)
+		if !ok || cfg == "" {
+			continue
+		}
+		migrated, err := m.migrate(cfg)
+		if err != nil {
+			return fmt.Errorf("could not migrate the %s configuration: %s", m.key, err)
+		}
+		config[m.key] = migrated
+	}
+	return nil
+}
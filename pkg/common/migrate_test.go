@@ -0,0 +1,65 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrateProvisionerConfig(t *testing.T) {
+	// a minimal configuration, as stored by an old version of the provider
+	initContents := `
+apiVersion: kubeadm.k8s.io/v1beta1
+kind: InitConfiguration
+---
+apiVersion: kubeadm.k8s.io/v1beta1
+kind: ClusterConfiguration
+kubernetesVersion: v1.14.1
+`
+	config := map[string]interface{}{
+		"init":         ToTerraformSafeString([]byte(initContents)),
+		"kube_version": "v1.14.1",
+	}
+	if err := MigrateProvisionerConfig(config); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	migrated, err := FromTerraformSafeString(config["init"].(string))
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.Contains(string(migrated), "certificatesDir: /etc/kubernetes/pki") {
+		t.Fatalf("Error: defaults not set in the migrated configuration:\n%s", migrated)
+	}
+	if config["kube_version"] != "v1.14.1" {
+		t.Fatalf("Error: unexpected change in %q", "kube_version")
+	}
+
+	// migrations must be idempotent
+	again := map[string]interface{}{"init": config["init"]}
+	if err := MigrateProvisionerConfig(again); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if again["init"] != config["init"] {
+		t.Fatalf("Error: migration is not idempotent")
+	}
+
+	// invalid configurations are reported
+	invalid := map[string]interface{}{"join": "not base64!"}
+	if err := MigrateProvisionerConfig(invalid); err == nil {
+		t.Fatalf("Error: invalid configuration not detected")
+	}
+}
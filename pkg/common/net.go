@@ -16,32 +16,190 @@ package common
 
 import (
 	"fmt"
+	"math/big"
 	"net"
 	"strconv"
 	"strings"
 )
 
-// AddressWithPort return an address as expectedHost:expectedPort (setting a default expectedPort p if there was no expectedPort specified)
+// parseHostPort parses a "host(:port)" address, using `defPort` when no port is
+// specified. IPv6 literals can be provided with brackets ("[fd00::1]:6443") or,
+// when there is no port, without brackets ("fd00::1").
+func parseHostPort(s string, defPort int) (string, int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", 0, fmt.Errorf("empty address")
+	}
+
+	host, port := s, defPort
+	switch {
+	case strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]"):
+		host = s[1 : len(s)-1]
+	case strings.HasPrefix(s, "["), strings.Count(s, ":") == 1:
+		h, p, err := net.SplitHostPort(s)
+		if err != nil {
+			return "", 0, err
+		}
+		if port, err = strconv.Atoi(p); err != nil || port <= 0 || port > 65535 {
+			return "", 0, fmt.Errorf("invalid port %q in address %q", p, s)
+		}
+		host = h
+	case strings.Count(s, ":") > 1 && net.ParseIP(s) == nil:
+		return "", 0, fmt.Errorf("invalid IPv6 address %q (use brackets when specifying a port)", s)
+	}
+
+	if host == "" {
+		return "", 0, fmt.Errorf("empty host in address %q", s)
+	}
+	return host, port, nil
+}
+
+// AddressWithPort return an address as host:port (setting a default port p if there was no port specified).
+// IPv6 literals are returned with brackets.
 func AddressWithPort(name string, p int) string {
-	if strings.IndexByte(name, ':') < 0 {
-		return net.JoinHostPort(name, fmt.Sprintf("%d", p))
+	host, port, err := parseHostPort(name, p)
+	if err != nil {
+		return name
+	}
+	if port == 0 {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
 	}
-	return name
+	return net.JoinHostPort(host, strconv.Itoa(port))
 }
 
+// SplitHostPort splits an address in host and port, using a default port when
+// no port is specified (or failing if `defaultPort` is 0). IPv6 literals can be
+// provided with brackets (mandatory when a port is specified), and they are
+// returned without brackets.
 func SplitHostPort(hp string, defaultPort int) (string, int, error) {
-	if strings.Count(hp, ":") == 0 && defaultPort > 0 {
-		hp = fmt.Sprintf("%s:%d", hp, defaultPort)
-	}
-	h, p, err := net.SplitHostPort(hp)
+	host, port, err := parseHostPort(hp, defaultPort)
 	if err != nil {
 		return "", 0, err
 	}
+	if port == 0 {
+		return "", 0, fmt.Errorf("missing port in address %q", hp)
+	}
+	return host, port, nil
+}
 
-	pi, err := strconv.Atoi(p)
+// CertSAN returns a name/IP suitable for a certificate SAN, removing the
+// "IP="/"DNS=" prefixes and the brackets from IPv6 literals
+func CertSAN(name string) string {
+	name = strings.TrimSpace(name)
+	for _, prefix := range []string{"IP=", "DNS="} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+}
+
+// CIDRContains returns true if the `inner` CIDR is completely contained in the `outer` CIDR
+func CIDRContains(outer string, inner string) (bool, error) {
+	_, outerNet, err := net.ParseCIDR(outer)
 	if err != nil {
-		return "", 0, err
+		return false, err
+	}
+	_, innerNet, err := net.ParseCIDR(inner)
+	if err != nil {
+		return false, err
+	}
+
+	outerOnes, outerBits := outerNet.Mask.Size()
+	innerOnes, innerBits := innerNet.Mask.Size()
+	if outerBits != innerBits || innerOnes < outerOnes {
+		return false, nil
 	}
+	return outerNet.Contains(innerNet.IP), nil
+}
 
-	return h, pi, nil
+// CIDRsOverlap returns true if the two CIDRs have some addresses in common
+func CIDRsOverlap(a string, b string) (bool, error) {
+	_, aNet, err := net.ParseCIDR(a)
+	if err != nil {
+		return false, err
+	}
+	_, bNet, err := net.ParseCIDR(b)
+	if err != nil {
+		return false, err
+	}
+	if len(aNet.IP) != len(bNet.IP) {
+		return false, nil
+	}
+	return aNet.Contains(bNet.IP) || bNet.Contains(aNet.IP), nil
+}
+
+// GetServiceIP returns the IP at some `index` in the services CIDR (like the `cidrhost()`
+// Terraform function), as kubeadm does for the API server (index 1) and DNS (index 10) services
+func GetServiceIP(cidr string, index int) (string, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", err
+	}
+	ones, bits := ipNet.Mask.Size()
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	if index < 0 || big.NewInt(int64(index)).Cmp(size) >= 0 {
+		return "", fmt.Errorf("index %d is out of the range of the CIDR %s", index, cidr)
+	}
+
+	ip := new(big.Int).Add(new(big.Int).SetBytes(ipNet.IP), big.NewInt(int64(index))).Bytes()
+	res := make(net.IP, len(ipNet.IP))
+	copy(res[len(res)-len(ip):], ip)
+	return res.String(), nil
+}
+
+// CheckNetworksOverlap checks that the pods and services CIDRs do not overlap
+// between them or with any of the subnets the nodes are in
+func CheckNetworksOverlap(podsCIDR string, servicesCIDR string, nodesCIDRs []string) error {
+	overlap, err := CIDRsOverlap(podsCIDR, servicesCIDR)
+	if err != nil {
+		return err
+	}
+	if overlap {
+		return fmt.Errorf("the pods CIDR %s overlaps with the services CIDR %s", podsCIDR, servicesCIDR)
+	}
+
+	for _, nodesCIDR := range nodesCIDRs {
+		for _, n := range []struct{ name, cidr string }{{"pods", podsCIDR}, {"services", servicesCIDR}} {
+			overlap, err := CIDRsOverlap(n.cidr, nodesCIDR)
+			if err != nil {
+				return err
+			}
+			if overlap {
+				return fmt.Errorf("the %s CIDR %s overlaps with the nodes subnet %s", n.name, n.cidr, nodesCIDR)
+			}
+		}
+	}
+	return nil
+}
+
+// CheckNodeCIDRMaskSize checks that a node CIDR mask size can be used for
+// splitting the cluster CIDR in per-node CIDRs
+func CheckNodeCIDRMaskSize(clusterCIDR string, maskSize int) error {
+	_, clusterNet, err := net.ParseCIDR(clusterCIDR)
+	if err != nil {
+		return err
+	}
+	ones, bits := clusterNet.Mask.Size()
+	if maskSize <= ones || maskSize > bits {
+		return fmt.Errorf("node CIDR mask size %d is not valid for the cluster CIDR %s: it must be in (%d, %d]", maskSize, clusterCIDR, ones, bits)
+	}
+	return nil
+}
+
+// GetCNIMTU returns the MTU that should be used by a CNI plugin (and backend)
+// when the MTU of the underlying interface is `ifaceMTU`
+func GetCNIMTU(plugin string, backend string, ifaceMTU int) int {
+	key := strings.ToLower(plugin)
+	if backend != "" {
+		key = key + "/" + strings.ToLower(backend)
+	}
+	if overhead, ok := CNIPluginsMTUOverhead[key]; ok {
+		return ifaceMTU - overhead
+	}
+	if overhead, ok := CNIPluginsMTUOverhead[strings.ToLower(plugin)]; ok {
+		return ifaceMTU - overhead
+	}
+	return ifaceMTU
 }
@@ -44,6 +44,18 @@ func TestSplitHostPort(t *testing.T) {
 			"some.place",
 			2525,
 		},
+		{
+			"fd00::10",
+			6443,
+			"fd00::10",
+			6443,
+		},
+		{
+			"[fd00::10]:8443",
+			6443,
+			"fd00::10",
+			8443,
+		},
 	}
 
 	for _, testCase := range testsCases {
@@ -59,3 +71,160 @@ func TestSplitHostPort(t *testing.T) {
 		}
 	}
 }
+
+func TestCIDRContains(t *testing.T) {
+	testsCases := []struct {
+		outer    string
+		inner    string
+		expected bool
+	}{
+		{"10.244.0.0/16", "10.244.3.0/24", true},
+		{"10.244.0.0/16", "10.244.0.0/16", true},
+		{"10.244.0.0/16", "10.0.0.0/8", false},
+		{"10.244.0.0/16", "192.168.1.0/24", false},
+		{"fd00::/48", "fd00:0:0:1::/64", true},
+		{"10.244.0.0/16", "fd00:0:0:1::/64", false},
+	}
+
+	for _, testCase := range testsCases {
+		res, err := CIDRContains(testCase.outer, testCase.inner)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if res != testCase.expected {
+			t.Fatalf("Error: %q in %q = %t, expected %t", testCase.inner, testCase.outer, res, testCase.expected)
+		}
+	}
+}
+
+func TestCIDRsOverlap(t *testing.T) {
+	testsCases := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{"10.244.0.0/16", "10.244.3.0/24", true},
+		{"10.244.3.0/24", "10.244.0.0/16", true},
+		{"10.244.0.0/16", "10.96.0.0/12", false},
+		{"10.0.0.0/8", "10.96.0.0/12", true},
+		{"fd00::/48", "10.0.0.0/8", false},
+	}
+
+	for _, testCase := range testsCases {
+		res, err := CIDRsOverlap(testCase.a, testCase.b)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if res != testCase.expected {
+			t.Fatalf("Error: %q overlaps %q = %t, expected %t", testCase.a, testCase.b, res, testCase.expected)
+		}
+	}
+}
+
+func TestGetServiceIP(t *testing.T) {
+	testsCases := []struct {
+		cidr     string
+		index    int
+		expected string
+	}{
+		{"10.96.0.0/12", DefAPIServiceIPIndex, "10.96.0.1"},
+		{"10.96.0.0/12", DefDNSServiceIPIndex, "10.96.0.10"},
+		{"10.25.0.0/16", 300, "10.25.1.44"},
+		{"fd00:10:96::/112", DefDNSServiceIPIndex, "fd00:10:96::a"},
+	}
+
+	for _, testCase := range testsCases {
+		res, err := GetServiceIP(testCase.cidr, testCase.index)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if res != testCase.expected {
+			t.Fatalf("Error: IP %d in %q = %q, expected %q", testCase.index, testCase.cidr, res, testCase.expected)
+		}
+	}
+
+	if _, err := GetServiceIP("10.96.0.0/28", 16); err == nil {
+		t.Fatalf("Error: index out of the CIDR not detected")
+	}
+}
+
+func TestCheckNetworksOverlap(t *testing.T) {
+	if err := CheckNetworksOverlap("10.244.0.0/16", "10.96.0.0/12", []string{"192.168.1.0/24"}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := CheckNetworksOverlap("10.0.0.0/8", "10.96.0.0/12", nil); err == nil {
+		t.Fatalf("Error: no error detected for overlapping pods and services CIDRs")
+	}
+	err := CheckNetworksOverlap("10.244.0.0/16", "10.96.0.0/12", []string{"192.168.1.0/24", "10.96.10.0/24"})
+	if err == nil || err.Error() != "the services CIDR 10.96.0.0/12 overlaps with the nodes subnet 10.96.10.0/24" {
+		t.Fatalf("Error: unexpected error: %v", err)
+	}
+}
+
+func TestCheckNodeCIDRMaskSize(t *testing.T) {
+	if err := CheckNodeCIDRMaskSize("10.244.0.0/16", 24); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := CheckNodeCIDRMaskSize("10.244.0.0/16", 16); err == nil {
+		t.Fatalf("Error: no error detected for a mask size equal to the cluster prefix")
+	}
+	if err := CheckNodeCIDRMaskSize("10.244.0.0/16", 33); err == nil {
+		t.Fatalf("Error: no error detected for a mask size bigger than the address length")
+	}
+}
+
+func TestGetCNIMTU(t *testing.T) {
+	testsCases := []struct {
+		plugin   string
+		backend  string
+		ifaceMTU int
+		expected int
+	}{
+		{"flannel", "vxlan", 1500, 1450},
+		{"flannel", "vxlan", 1460, 1410},
+		{"flannel", "host-gw", 1500, 1500},
+		{"weave", "", 1500, 1450},
+		{"weave", "", 9001, 8951},
+	}
+
+	for _, testCase := range testsCases {
+		res := GetCNIMTU(testCase.plugin, testCase.backend, testCase.ifaceMTU)
+		if res != testCase.expected {
+			t.Fatalf("Error: MTU for %s/%s with %d = %d, expected %d", testCase.plugin, testCase.backend, testCase.ifaceMTU, res, testCase.expected)
+		}
+	}
+}
+
+func TestAddressWithPort(t *testing.T) {
+	testsCases := []struct {
+		addr     string
+		expected string
+	}{
+		{"some.place", "some.place:6443"},
+		{"some.place:4545", "some.place:4545"},
+		{"10.0.0.1", "10.0.0.1:6443"},
+		{"fd00::10", "[fd00::10]:6443"},
+		{"[fd00::10]", "[fd00::10]:6443"},
+		{"[fd00::10]:8443", "[fd00::10]:8443"},
+	}
+
+	for _, testCase := range testsCases {
+		if res := AddressWithPort(testCase.addr, DefAPIServerPort); res != testCase.expected {
+			t.Fatalf("Error: %q: got %q, expected %q", testCase.addr, res, testCase.expected)
+		}
+	}
+}
+
+func TestCertSAN(t *testing.T) {
+	for in, expected := range map[string]string{
+		"some.place":      "some.place",
+		"IP=10.0.0.1":     "10.0.0.1",
+		"DNS=localhost":   "localhost",
+		"[fd00::10]":      "fd00::10",
+		" IP=[fd00::10] ": "fd00::10",
+	} {
+		if res := CertSAN(in); res != expected {
+			t.Fatalf("Error: %q: got %q, expected %q", in, res, expected)
+		}
+	}
+}
@@ -59,6 +59,11 @@ var ProvisionerConfigElements = map[string]*schema.Schema{
 		Optional:  true,
 		Sensitive: true,
 	},
+	"token_expires": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "expiration time (RFC3339) of the token",
+	},
 	"cni_plugin": {
 		Type: schema.TypeString,
 		// Computed: true,
@@ -79,6 +84,11 @@ var ProvisionerConfigElements = map[string]*schema.Schema{
 		// Computed: true,
 		Optional: true,
 	},
+	"cni_mtu": {
+		Type: schema.TypeString,
+		// Computed: true,
+		Optional: true,
+	},
 	"cni_pod_cidr": {
 		Type: schema.TypeString,
 		// Computed: true,
@@ -114,16 +124,180 @@ var ProvisionerConfigElements = map[string]*schema.Schema{
 		// Computed: true,
 		Optional: true,
 	},
+	"read_only": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "the provider is in read-only mode: the provisioners must not change the nodes",
+	},
+	"plan_only": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "the provider is in plan-only mode: the provisioners must just print what they would do",
+	},
 	"cloud_provider_flags": {
 		Type: schema.TypeString,
 		// Computed: true,
 		Optional: true,
 	},
+	"storage_endpoint": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "endpoint for the artifacts storage",
+	},
+	"storage_region": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "region for the S3 artifacts storage",
+	},
+	"storage_access_key": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "access key for the S3 artifacts storage",
+	},
+	"storage_secret_key": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "secret key for the S3 artifacts storage",
+	},
+	"storage_gcs_credentials": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "credentials for the GCS artifacts storage",
+	},
+	"storage_azure_account": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "account for the Azure Blob artifacts storage",
+	},
+	"storage_azure_sas_token": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "SAS token for the Azure Blob artifacts storage",
+	},
 	"dashboard_enabled": {
 		Type: schema.TypeBool,
 		// Computed: true,
 		Optional: true,
 	},
+	"ingress_controller": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "ingress controller to install (nginx or contour)",
+	},
+	"ingress_mode": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "how the ingress controller is exposed (hostport, nodeport or loadbalancer)",
+	},
+	"ingress_manifest": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "manifest for the ingress controller, overriding the default one",
+	},
+	"ingress_metallb_addresses": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "comma-separated list of addresses for MetalLB",
+	},
+	"bootstrap_manifest": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "manifest with the namespaces and bindings created after bootstrapping",
+	},
+	"image_pull_secrets": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Sensitive:   true,
+		Description: "serialized list of image pull secrets to create",
+	},
+	"cert_manager_version": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "cert-manager version to install",
+	},
+	"cert_manager_issuer_type": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "type of the cert-manager ClusterIssuer (acme or selfsigned)",
+	},
+	"cert_manager_issuer_name": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "name of the cert-manager ClusterIssuer",
+	},
+	"cert_manager_acme_email": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "email for the ACME account",
+	},
+	"cert_manager_acme_server": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "ACME server URL",
+	},
+	"cert_manager_acme_ingress_class": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "ingress class used for solving the ACME HTTP01 challenges",
+	},
+	"kubelet_cpu_manager_policy": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "CPU Manager policy for the kubelet",
+	},
+	"kubelet_memory_manager_policy": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "Memory Manager policy for the kubelet",
+	},
+	"kubelet_shutdown_grace_period": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "time the node shutdown is delayed by the kubelet",
+	},
+	"kubelet_shutdown_grace_period_critical_pods": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "part of the shutdown grace period reserved for the critical pods",
+	},
+	"runtime_storage_driver": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "snapshotter/storage driver for the containers runtime",
+	},
+	"api_local_bootstrap": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "point the control plane endpoint to the local API server during the first 'kubeadm init'",
+	},
+	"vault_auth_service_account": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "ServiceAccount used by Vault for reviewing tokens",
+	},
+	"vault_auth_namespace": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "namespace for the Vault ServiceAccount",
+	},
+	"dns_autoscaler_enabled": {
+		Type:     schema.TypeBool,
+		Optional: true,
+	},
+	"dns_autoscaler_linear": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "replicas policy for the CoreDNS autoscaler (in JSON)",
+	},
+	"dns_autoscaler_image": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "image for the CoreDNS autoscaler",
+	},
 	"config_path": {
 		Type: schema.TypeString,
 		// Computed: true,
@@ -161,6 +335,16 @@ var ProvisionerConfigElements = map[string]*schema.Schema{
 		Optional:  true,
 		Sensitive: true,
 	},
+	"allowed_ports": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "comma-separated list of ports (or ranges) allowed in control plane nodes (no verification when empty)",
+	},
+	"drift_watchdog": {
+		Type:        schema.TypeString,
+		Optional:    true,
+		Description: "install the configuration drift watchdog in the nodes (when \"true\")",
+	},
 	"etcd_crt": {
 		Type: schema.TypeString,
 		// Computed: true,
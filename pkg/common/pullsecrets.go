@@ -0,0 +1,73 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ImagePullSecret are the credentials for a container images registry
+type ImagePullSecret struct {
+	Name                string   `json:"name"`
+	Server              string   `json:"server"`
+	Username            string   `json:"username"`
+	Password            string   `json:"password"`
+	Email               string   `json:"email,omitempty"`
+	Namespaces          []string `json:"namespaces"`
+	PatchServiceAccount bool     `json:"patch_service_account"`
+}
+
+// DockerConfigJSON returns the contents of the `.dockerconfigjson` for this secret
+func (s ImagePullSecret) DockerConfigJSON() ([]byte, error) {
+	type authEntry struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Email    string `json:"email,omitempty"`
+		Auth     string `json:"auth"`
+	}
+	if s.Server == "" {
+		return nil, fmt.Errorf("no registry server for image pull secret %q", s.Name)
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(s.Username + ":" + s.Password))
+	return json.Marshal(map[string]interface{}{
+		"auths": map[string]authEntry{
+			s.Server: {Username: s.Username, Password: s.Password, Email: s.Email, Auth: auth},
+		},
+	})
+}
+
+// ImagePullSecretsToTerraformSafeString serializes a list of image pull secrets
+func ImagePullSecretsToTerraformSafeString(secrets []ImagePullSecret) (string, error) {
+	data, err := json.Marshal(secrets)
+	if err != nil {
+		return "", err
+	}
+	return ToTerraformSafeString(data), nil
+}
+
+// ImagePullSecretsFromTerraformSafeString deserializes a list of image pull secrets
+func ImagePullSecretsFromTerraformSafeString(s string) ([]ImagePullSecret, error) {
+	data, err := FromTerraformSafeString(s)
+	if err != nil {
+		return nil, err
+	}
+	secrets := []ImagePullSecret{}
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
@@ -0,0 +1,60 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestImagePullSecretDockerConfigJSON(t *testing.T) {
+	secret := ImagePullSecret{Name: "registry", Server: "registry.example.com", Username: "user", Password: "pass"}
+	data, err := secret.DockerConfigJSON()
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	config := struct {
+		Auths map[string]map[string]string `json:"auths"`
+	}{}
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if config.Auths["registry.example.com"]["auth"] != "dXNlcjpwYXNz" {
+		t.Fatalf("Error: unexpected auth: %s", data)
+	}
+
+	if _, err := (ImagePullSecret{Name: "registry"}).DockerConfigJSON(); err == nil {
+		t.Fatalf("Error: no error detected for a secret without server")
+	}
+}
+
+func TestImagePullSecretsSerialization(t *testing.T) {
+	secrets := []ImagePullSecret{
+		{Name: "registry", Server: "registry.example.com", Username: "user", Password: "pass", Namespaces: []string{"default", "apps"}, PatchServiceAccount: true},
+	}
+	s, err := ImagePullSecretsToTerraformSafeString(secrets)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	res, err := ImagePullSecretsFromTerraformSafeString(s)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !reflect.DeepEqual(secrets, res) {
+		t.Fatalf("Error: unexpected secrets: %+v", res)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+const (
+	// environment variable for enabling the read-only mode
+	ReadOnlyEnvVar = "KUBEADM_READ_ONLY"
+
+	// environment variable for enabling the plan-only (dry-run) mode
+	PlanOnlyEnvVar = "KUBEADM_PLAN_ONLY"
+)
+
+// IsReadOnlyFromEnv returns true if the read-only mode has been enabled in the environment
+func IsReadOnlyFromEnv() bool {
+	readOnly, err := strconv.ParseBool(os.Getenv(ReadOnlyEnvVar))
+	return err == nil && readOnly
+}
+
+// IsPlanOnlyFromEnv returns true if the plan-only mode has been enabled in the environment
+func IsPlanOnlyFromEnv() bool {
+	planOnly, err := strconv.ParseBool(os.Getenv(PlanOnlyEnvVar))
+	return err == nil && planOnly
+}
+
+// IsReadOnlyFromConfig returns true if the read-only mode has been
+// enabled in the provider (and forwarded in the provisioner `config`)
+func IsReadOnlyFromConfig(config map[string]interface{}) bool {
+	return isModeEnabledInConfig(config, "read_only")
+}
+
+// IsPlanOnlyFromConfig returns true if the plan-only mode has been
+// enabled in the provider (and forwarded in the provisioner `config`)
+func IsPlanOnlyFromConfig(config map[string]interface{}) bool {
+	return isModeEnabledInConfig(config, "plan_only")
+}
+
+// isModeEnabledInConfig returns true if some mode is enabled in the provisioner `config`
+func isModeEnabledInConfig(config map[string]interface{}, key string) bool {
+	v, ok := config[key].(string)
+	if !ok {
+		return false
+	}
+	enabled, err := strconv.ParseBool(v)
+	return err == nil && enabled
+}
+
+// ReadOnlyError returns the error for an operation that is not allowed in the read-only mode
+func ReadOnlyError(operation string) error {
+	return fmt.Errorf("read-only mode: %s is not allowed (unset %s or 'read_only' in the provider for enabling it)", operation, ReadOnlyEnvVar)
+}
+
+// PlanOnlyError returns the error for a provisioning done in the plan-only mode, so
+// the resource is not considered as created (nothing has been changed in the node)
+func PlanOnlyError() error {
+	return fmt.Errorf("plan-only mode: nothing has been changed in the node (unset %s or 'plan_only' for provisioning it)", PlanOnlyEnvVar)
+}
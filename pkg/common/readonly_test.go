@@ -0,0 +1,64 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package common
+
+import (
+	"os"
+	"testing"
+)
+
+func TestIsReadOnlyFromEnv(t *testing.T) {
+	defer os.Unsetenv(ReadOnlyEnvVar)
+
+	for value, expected := range map[string]bool{"": false, "0": false, "false": false, "1": true, "true": true, "whatever": false} {
+		os.Setenv(ReadOnlyEnvVar, value)
+		if res := IsReadOnlyFromEnv(); res != expected {
+			t.Fatalf("Error: read-only for %q = %t, expected %t", value, res, expected)
+		}
+	}
+}
+
+func TestIsReadOnlyFromConfig(t *testing.T) {
+	for value, expected := range map[string]bool{"": false, "false": false, "true": true} {
+		if res := IsReadOnlyFromConfig(map[string]interface{}{"read_only": value}); res != expected {
+			t.Fatalf("Error: read-only for %q = %t, expected %t", value, res, expected)
+		}
+	}
+	if IsReadOnlyFromConfig(map[string]interface{}{}) {
+		t.Fatalf("Error: read-only without the key in the config")
+	}
+}
+
+func TestIsPlanOnlyFromConfig(t *testing.T) {
+	for value, expected := range map[string]bool{"": false, "false": false, "true": true} {
+		if res := IsPlanOnlyFromConfig(map[string]interface{}{"plan_only": value}); res != expected {
+			t.Fatalf("Error: plan-only for %q = %t, expected %t", value, res, expected)
+		}
+	}
+	if IsPlanOnlyFromConfig(map[string]interface{}{"read_only": "true"}) {
+		t.Fatalf("Error: plan-only without the key in the config")
+	}
+}
+
+func TestIsPlanOnlyFromEnv(t *testing.T) {
+	defer os.Unsetenv(PlanOnlyEnvVar)
+
+	for value, expected := range map[string]bool{"": false, "0": false, "1": true, "true": true} {
+		os.Setenv(PlanOnlyEnvVar, value)
+		if res := IsPlanOnlyFromEnv(); res != expected {
+			t.Fatalf("Error: plan-only for %q = %t, expected %t", value, res, expected)
+		}
+	}
+}
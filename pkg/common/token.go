@@ -18,7 +18,9 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 )
 
@@ -28,6 +30,9 @@ const (
 	TokenSecretBytes = 8
 
 	TokenRegex = `[a-z0-9]{6}\.[a-z0-9]{16}`
+
+	// DefTokenTTL is the TTL of the token created in the `kubeadm init`
+	DefTokenTTL = 24 * time.Hour
 )
 
 func randBytes(length int) (string, error) {
@@ -63,6 +68,7 @@ func NewBootstrapToken(token string) (kubeadmapi.BootstrapToken, error) {
 	if err != nil {
 		return kubeadmapi.BootstrapToken{}, err
 	}
+	bto.TTL = &metav1.Duration{Duration: DefTokenTTL}
 	return bto, err
 }
 
@@ -16,10 +16,10 @@ package common
 
 import (
 	"fmt"
-	"net"
 	"net/url"
 	"path/filepath"
 	"regexp"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/validation"
 )
@@ -42,9 +42,11 @@ func ValidateAbsPath(v interface{}, k string) (ws []string, errors []error) {
 	return
 }
 
+// ValidateHostPort validates a "host[:port]" address (IPv6 literals must use brackets with ports)
 func ValidateHostPort(v interface{}, k string) (ws []string, errors []error) {
-	_, _, err := net.SplitHostPort(v.(string))
-	errors = append(errors, fmt.Errorf("%q is not an valid 'expectedHost:expectedPort': %s", k, err))
+	if _, _, err := SplitHostPort(v.(string), DefAPIServerPort); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid 'host:port': %s", k, err))
+	}
 	return
 }
 
@@ -55,3 +57,35 @@ func ValidateURL(v interface{}, k string) (ws []string, errors []error) {
 	}
 	return
 }
+
+// ValidatePortsRange validates a port or a range of ports (ie, "30000-32767")
+func ValidatePortsRange(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := ParsePortsRange(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid port or ports range: %s", k, err))
+	}
+	return
+}
+
+// ValidateCPUSet validates a list of CPUs (ie, "0-1,4")
+func ValidateCPUSet(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := ParseCPUSet(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid list of CPUs: %s", k, err))
+	}
+	return
+}
+
+// ValidateReservedMemory validates the memory reserved per NUMA node (ie, "0:memory=1Gi;1:memory=2Gi")
+func ValidateReservedMemory(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := ParseReservedMemory(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid reserved memory: %s", k, err))
+	}
+	return
+}
+
+// ValidateDuration validates a duration (ie, "30s")
+func ValidateDuration(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid duration: %s", k, err))
+	}
+	return
+}
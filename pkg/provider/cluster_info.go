@@ -0,0 +1,248 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/provisioner"
+)
+
+// knownCNIPlugins are the names of some CNI plugins, as found in the names of their DaemonSets
+var knownCNIPlugins = []string{"flannel", "weave", "calico", "cilium", "canal", "kube-router", "antrea"}
+
+// clusterInfo is some (public) information about a running cluster
+type clusterInfo struct {
+	Endpoint          string
+	CACertHash        string
+	KubernetesVersion string
+	KubeadmVersion    string
+	CNI               []string
+}
+
+// dataSourceKubeadmClusterInfo is a data source that reads some information about
+// a running cluster from the seeder, so other workspaces can join nodes to it
+// without sharing the state of the `kubeadm` resource
+func dataSourceKubeadmClusterInfo() *schema.Resource {
+	res := &schema.Resource{
+		Read: dataSourceKubeadmClusterInfoRead,
+
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "address of the seeder",
+			},
+			"port": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Default:     ssh.DefSSHPort,
+				Description: "SSH port in the seeder",
+			},
+			"user": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "root",
+				Description: "SSH user in the seeder",
+			},
+			"private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "SSH private key for the seeder",
+			},
+			"certificate": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "OpenSSH certificate presented with the private key",
+			},
+			"password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "SSH password for the seeder",
+			},
+			"agent": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				DefaultFunc: func() (interface{}, error) {
+					return ssh.HasLocalAgent(), nil
+				},
+				Description: "authenticate with the keys in the local SSH agent (defaults to true when there is an agent)",
+			},
+			"timeout": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "5m",
+				Description: "timeout for connecting to the seeder",
+			},
+			"endpoint": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "URL of the API server",
+			},
+			"ca_cert_hash": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "hash of the CA certificate, for the '--discovery-token-ca-cert-hash' in 'kubeadm join'",
+			},
+			"kubernetes_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "version of the API server",
+			},
+			"kubeadm_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "version of kubeadm in the seeder",
+			},
+			"cni": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "CNI plugins installed in the cluster",
+			},
+		},
+	}
+
+	// connect to the seeder like the provisioner does (jump hosts, host keys, sudo...)
+	for k, v := range provisioner.ConnectionSchema() {
+		res.Schema[k] = v
+	}
+	return res
+}
+
+// getCNIFromDaemonSets returns the known CNI plugins in a list of DaemonSets names
+func getCNIFromDaemonSets(names []string) []string {
+	res := []string{}
+	for _, plugin := range knownCNIPlugins {
+		for _, name := range names {
+			if strings.Contains(name, plugin) {
+				res = append(res, plugin)
+				break
+			}
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// getServerVersion returns the version of the API server from the output of `kubectl version -o json`
+func getServerVersion(output string) (string, error) {
+	var version struct {
+		ServerVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"serverVersion"`
+	}
+	if err := ssh.ParseJSON(output, &version); err != nil {
+		return "", fmt.Errorf("could not parse the output of 'kubectl version': %s", err)
+	}
+	return version.ServerVersion.GitVersion, nil
+}
+
+// doGetClusterInfo reads the cluster information from the seeder
+func doGetClusterInfo(info *clusterInfo) ssh.Action {
+	kubectl := ssh.NewCommand(common.DefKubectlPath, "--kubeconfig="+ssh.DefAdminKubeconfig)
+
+	var endpoint, caCrt, version, kubeadmVersion, daemonSets bytes.Buffer
+	return ssh.ActionList{
+		ssh.DoSendingExecOutputToWriter(
+			ssh.DoExecCommand(kubectl.WithArgs("config", "view", "--minify", "-o", "jsonpath={.clusters[0].cluster.server}")), &endpoint),
+		// (the output is received line by line, without the line breaks)
+		ssh.DoSendingExecOutputToFunc(
+			ssh.DoExecCommand(ssh.NewCommand("cat", path.Join(common.DefPKIDir, "ca.crt"))),
+			func(s string) { caCrt.WriteString(s + "\n") }),
+		ssh.DoSendingExecOutputToWriter(
+			ssh.DoExecCommand(kubectl.WithArgs("version", "-o", "json")), &version),
+		ssh.DoSendingExecOutputToWriter(
+			ssh.DoExecCommand(ssh.NewCommand(common.DefKubeadmPath, "version", "-o", "short")), &kubeadmVersion),
+		ssh.DoSendingExecOutputToWriter(
+			ssh.DoExecCommand(kubectl.WithArgs("-n", "kube-system", "get", "daemonsets", "-o", "jsonpath={.items[*].metadata.name}")), &daemonSets),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			var err error
+			info.Endpoint = strings.TrimSpace(endpoint.String())
+			if info.CACertHash, err = common.GetCACertHash(caCrt.String()); err != nil {
+				return ssh.ActionError(err.Error())
+			}
+			if info.KubernetesVersion, err = getServerVersion(version.String()); err != nil {
+				return ssh.ActionError(err.Error())
+			}
+			info.KubeadmVersion = strings.TrimSpace(kubeadmVersion.String())
+			info.CNI = getCNIFromDaemonSets(strings.Fields(daemonSets.String()))
+			return nil
+		}),
+	}
+}
+
+// getSeederConnState returns the state used for connecting to the seeder
+// (the credentials sources, jump hosts and host keys are used when dialing the seeder)
+func getSeederConnState(d *schema.ResourceData) *terraform.InstanceState {
+	return &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{
+				"type":        "ssh",
+				"host":        d.Get("host").(string),
+				"port":        strconv.Itoa(d.Get("port").(int)),
+				"user":        d.Get("user").(string),
+				"password":    d.Get("password").(string),
+				"private_key": d.Get("private_key").(string),
+				"certificate": strings.TrimSpace(d.Get("certificate").(string)),
+				"agent":       strconv.FormatBool(d.Get("agent").(bool)),
+				"timeout":     d.Get("timeout").(string),
+			},
+		},
+	}
+}
+
+// dataSourceKubeadmClusterInfoRead reads the cluster information from the seeder
+func dataSourceKubeadmClusterInfoRead(d *schema.ResourceData, meta interface{}) error {
+	s := getSeederConnState(d)
+	out := ssh.OutputFunc(func(s string) { ssh.Debug("[cluster-info] %s", s) })
+	sshCtx, closeComm, err := provisioner.DialNode(context.Background(), out, d, s)
+	if err != nil {
+		return fmt.Errorf("could not connect to the seeder %s: %s", s.Ephemeral.ConnInfo["host"], err)
+	}
+	defer closeComm()
+
+	info := clusterInfo{}
+	if res := (ssh.ActionList{doGetClusterInfo(&info)}).Apply(sshCtx); ssh.IsError(res) {
+		return fmt.Errorf("could not read the cluster information from the seeder: %s", res)
+	}
+
+	d.SetId(fmt.Sprintf("%s-%s", d.Get("host").(string), info.CACertHash))
+	for attr, value := range map[string]interface{}{
+		"endpoint":           info.Endpoint,
+		"ca_cert_hash":       info.CACertHash,
+		"kubernetes_version": info.KubernetesVersion,
+		"kubeadm_version":    info.KubeadmVersion,
+		"cni":                info.CNI,
+	} {
+		if err := d.Set(attr, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
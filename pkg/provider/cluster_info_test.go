@@ -0,0 +1,82 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/kubernetes/cmd/kubeadm/app/util/pkiutil"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestGetCNIFromDaemonSets(t *testing.T) {
+	cni := getCNIFromDaemonSets([]string{"kube-proxy", "weave-net", "kube-flannel-ds-amd64"})
+	if expected := []string{"flannel", "weave"}; !reflect.DeepEqual(cni, expected) {
+		t.Fatalf("Error: unexpected CNI plugins: %q (expected %q)", cni, expected)
+	}
+	if cni := getCNIFromDaemonSets([]string{"kube-proxy"}); len(cni) != 0 {
+		t.Fatalf("Error: unexpected CNI plugins: %q", cni)
+	}
+}
+
+func TestDoGetClusterInfo(t *testing.T) {
+	caCert, _, err := pkiutil.NewCertificateAuthority(&certutil.Config{CommonName: "kubernetes"})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	caCrt := string(pkiutil.EncodeCertPEM(caCert))
+
+	responses := []string{
+		"https://10.0.0.1:6443",
+		caCrt,
+		`{"clientVersion": {"gitVersion": "v1.15.0"}, "serverVersion": {"gitVersion": "v1.15.3"}}`,
+		"v1.15.3",
+		"kube-proxy weave-net",
+	}
+	ctx := ssh.NewTestingContextWithResponses(responses)
+
+	info := clusterInfo{}
+	if res := (ssh.ActionList{doGetClusterInfo(&info)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+
+	hash, _ := common.GetCACertHash(caCrt)
+	expected := clusterInfo{
+		Endpoint:          "https://10.0.0.1:6443",
+		CACertHash:        hash,
+		KubernetesVersion: "v1.15.3",
+		KubeadmVersion:    "v1.15.3",
+		CNI:               []string{"weave"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Fatalf("Error: unexpected cluster info: %+v (expected %+v)", info, expected)
+	}
+}
+
+func TestDataSourceKubeadmClusterInfoSchema(t *testing.T) {
+	res := dataSourceKubeadmClusterInfo()
+	if err := res.InternalValidate(nil, false); err != nil {
+		t.Fatalf("Error: invalid schema: %s", err)
+	}
+	for _, k := range []string{"host_key_checking", "host_key", "jump_host", "privilege_escalation", "sudo_password"} {
+		if _, ok := res.Schema[k]; !ok {
+			t.Fatalf("Error: %q not found in the cluster_info schema", k)
+		}
+	}
+}
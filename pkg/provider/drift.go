@@ -0,0 +1,110 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// getConfigDrift returns the drifted configuration files (comma-separated) for
+// every node, as reported by the drift watchdog in the nodes annotations
+func getConfigDrift(client kubernetes.Interface) (map[string]string, error) {
+	nodes, err := client.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	drift := map[string]string{}
+	for _, node := range nodes.Items {
+		if files := node.Annotations[common.DefAnnotationConfigDriftKey]; files != "" {
+			drift[node.Name] = files
+		}
+	}
+	return drift, nil
+}
+
+// requestDriftRemediation annotates some nodes for requesting the drift watchdog
+// to restore the baseline configuration
+func requestDriftRemediation(client kubernetes.Interface, nodes []string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				common.DefAnnotationConfigDriftRemediateKey: "true",
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		ssh.Debug("requesting the remediation of the configuration drift in %q", node)
+		if _, err := client.CoreV1().Nodes().Patch(node, types.MergePatchType, patch); err != nil {
+			return fmt.Errorf("could not request the remediation of the configuration drift in %q: %s", node, err)
+		}
+	}
+	return nil
+}
+
+// readConfigDrift sets the "config_drift" with the drift reported by the nodes.
+// The previous drift is kept when the cluster cannot be reached.
+func readConfigDrift(d *schema.ResourceData) error {
+	drift := map[string]string{}
+
+	if _, ok := d.GetOk("drift.0"); ok && d.Get("drift.0.watchdog").(bool) {
+		client, err := getKubernetesClient(d)
+		if err == nil && client != nil {
+			drift, err = getConfigDrift(client)
+		}
+		if err != nil {
+			ssh.Debug("could not get the configuration drift: %s", err)
+			return nil
+		}
+	}
+
+	return d.Set("config_drift", drift)
+}
+
+// remediateConfigDrift requests the remediation of the drift in all the drifted nodes
+func remediateConfigDrift(d *schema.ResourceData) error {
+	client, err := getKubernetesClient(d)
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		ssh.Debug("no kubeconfig available: the configuration drift cannot be remediated")
+		return nil
+	}
+
+	drift, err := getConfigDrift(client)
+	if err != nil {
+		return err
+	}
+
+	nodes := []string{}
+	for node := range drift {
+		nodes = append(nodes, node)
+	}
+	return requestDriftRemediation(client, nodes)
+}
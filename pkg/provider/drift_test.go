@@ -0,0 +1,64 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestConfigDrift(t *testing.T) {
+	newNode := func(name string, drift string) *v1.Node {
+		node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{}}}
+		if drift != "" {
+			node.Annotations[common.DefAnnotationConfigDriftKey] = drift
+		}
+		return node
+	}
+
+	client := fake.NewSimpleClientset(
+		newNode("master", ""),
+		newNode("worker1", "/var/lib/kubelet/config.yaml,/etc/containerd/config.toml"),
+		newNode("worker2", ""))
+
+	drift, err := getConfigDrift(client)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]string{"worker1": "/var/lib/kubelet/config.yaml,/etc/containerd/config.toml"}
+	if !reflect.DeepEqual(drift, expected) {
+		t.Fatalf("Error: unexpected drift: %v, expected: %v", drift, expected)
+	}
+
+	if err := requestDriftRemediation(client, []string{"worker1"}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	node, err := client.CoreV1().Nodes().Get("worker1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if node.Annotations[common.DefAnnotationConfigDriftRemediateKey] != "true" {
+		t.Fatalf("Error: remediation not requested: %v", node.Annotations)
+	}
+	if node.Annotations[common.DefAnnotationConfigDriftKey] == "" {
+		t.Fatalf("Error: drift annotation lost when requesting the remediation: %v", node.Annotations)
+	}
+}
@@ -16,9 +16,9 @@ package provider
 
 import (
 	"fmt"
-	"net"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
 	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
@@ -51,25 +51,26 @@ func dataSourceToInitConfig(d *schema.ResourceData, token string) (*kubeadmapi.I
 		}
 
 		if internal, ok := d.GetOk("api.0.internal"); ok {
-			host, port, err := net.SplitHostPort(internal.(string))
+			host, port, err := common.SplitHostPort(internal.(string), common.DefAPIServerPort)
 			if err != nil {
 				return nil, err
 			}
 
 			initConfig.LocalAPIEndpoint.AdvertiseAddress = host
-			if port != "" {
-				i, err := strconv.Atoi(port)
-				if err != nil {
-					return nil, err
-				}
-				initConfig.LocalAPIEndpoint.BindPort = int32(i)
-			}
+			initConfig.LocalAPIEndpoint.BindPort = int32(port)
 
 			initConfig.ClusterConfiguration.APIServer.CertSANs = append(initConfig.ClusterConfiguration.APIServer.CertSANs, host)
 		}
 
 		if altNames, ok := d.GetOk("api.0.alt_names"); ok {
-			initConfig.APIServer.CertSANs = append(initConfig.APIServer.CertSANs, altNames.([]string)...)
+			for _, altName := range altNames.([]interface{}) {
+				// (elements can be lists like "IP=10.0.0.1,DNS=localhost")
+				for _, name := range strings.Split(altName.(string), ",") {
+					if san := common.CertSAN(name); san != "" {
+						initConfig.APIServer.CertSANs = append(initConfig.APIServer.CertSANs, san)
+					}
+				}
+			}
 		}
 	}
 
@@ -149,6 +150,34 @@ func dataSourceToInitConfig(d *schema.ResourceData, token string) (*kubeadmapi.I
 		}
 	}
 
+	// the resources management and seccomp configuration for the kubelet
+	// (note: this must be done after processing the "extra_args", as they replace the whole map)
+	kubeletArgs, err := setKubeletArgs(d, initConfig.NodeRegistration.KubeletExtraArgs)
+	if err != nil {
+		return nil, err
+	}
+	initConfig.NodeRegistration.KubeletExtraArgs = kubeletArgs
+
+	// the controller manager will split the pods CIDR in per-node CIDRs of the given size
+	// (note: this must be done after processing the "extra_args", as they replace the whole map)
+	if maskSizeOpt, ok := d.GetOk("network.0.node_cidr_mask_size"); ok {
+		podCIDR := initConfig.Networking.PodSubnet
+		if podCIDR == "" {
+			podCIDR = common.DefPodCIDR
+		}
+		maskSize := maskSizeOpt.(int)
+		if err := common.CheckNodeCIDRMaskSize(podCIDR, maskSize); err != nil {
+			return nil, err
+		}
+
+		if initConfig.ClusterConfiguration.ControllerManager.ExtraArgs == nil {
+			initConfig.ClusterConfiguration.ControllerManager.ExtraArgs = map[string]string{}
+		}
+		initConfig.ClusterConfiguration.ControllerManager.ExtraArgs["allocate-node-cidrs"] = "true"
+		initConfig.ClusterConfiguration.ControllerManager.ExtraArgs["cluster-cidr"] = podCIDR
+		initConfig.ClusterConfiguration.ControllerManager.ExtraArgs["node-cidr-mask-size"] = strconv.Itoa(maskSize)
+	}
+
 	// check if we have some cloud-provider
 	// if that is the case, we use the "external" cloud provider.
 	// the provisioner will have to load a "manifest" for running this externla cloud provider manager
@@ -191,6 +220,51 @@ func dataSourceToInitConfig(d *schema.ResourceData, token string) (*kubeadmapi.I
 		}
 	}
 
+	// TLS hardening for the API server and the local etcd
+	// (note: this must be done after processing the "extra_args", as they replace the whole map)
+	if _, ok := d.GetOk("hardening.0"); ok {
+		cipherSuites := []string{}
+		for _, c := range d.Get("hardening.0.tls_cipher_suites").([]interface{}) {
+			cipherSuites = append(cipherSuites, c.(string))
+		}
+		minVersion := d.Get("hardening.0.tls_min_version").(string)
+
+		if initConfig.ClusterConfiguration.APIServer.ExtraArgs == nil {
+			initConfig.ClusterConfiguration.APIServer.ExtraArgs = map[string]string{}
+		}
+		for k, v := range common.GetAPIServerTLSArgs(cipherSuites, minVersion) {
+			initConfig.ClusterConfiguration.APIServer.ExtraArgs[k] = v
+		}
+
+		if initConfig.Etcd.External == nil {
+			etcdArgs, err := common.GetEtcdTLSArgs(cipherSuites, minVersion)
+			if err != nil {
+				return nil, err
+			}
+			if initConfig.Etcd.Local == nil {
+				initConfig.Etcd.Local = &kubeadmapi.LocalEtcd{}
+			}
+			if initConfig.Etcd.Local.ExtraArgs == nil {
+				initConfig.Etcd.Local.ExtraArgs = map[string]string{}
+			}
+			for k, v := range etcdArgs {
+				initConfig.Etcd.Local.ExtraArgs[k] = v
+			}
+		}
+	}
+
+	// translate (or reject) the flags that have been removed in the target version
+	// (note: this must be done after processing all the extra args)
+	if len(initConfig.KubernetesVersion) > 0 {
+		warnings, err := common.FixInitConfigForVersion(initConfig, initConfig.KubernetesVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range warnings {
+			ssh.Debug("%s", w)
+		}
+	}
+
 	if len(token) > 0 {
 		t, err := common.NewBootstrapToken(token)
 		if err != nil {
@@ -56,6 +56,13 @@ func dataSourceToJoinConfig(d *schema.ResourceData, token string) (*kubeadmapi.J
 		}
 	}
 
+	// the resources management and seccomp configuration for the kubelet
+	kubeletArgs, err := setKubeletArgs(d, joinConfig.NodeRegistration.KubeletExtraArgs)
+	if err != nil {
+		return nil, err
+	}
+	joinConfig.NodeRegistration.KubeletExtraArgs = kubeletArgs
+
 	if _, ok := d.GetOk("network.0"); ok {
 		if _, ok := d.GetOk("network.0.dns.0"); ok {
 			if dnsUpstreamOpt, ok := d.GetOk("network.0.dns.0.upstream"); ok {
@@ -72,5 +79,18 @@ func dataSourceToJoinConfig(d *schema.ResourceData, token string) (*kubeadmapi.J
 		joinConfig.NodeRegistration.KubeletExtraArgs["cloud-provider"] = "external"
 	}
 
+	// translate (or reject) the kubelet flags that have been removed in the target version
+	if versionOpt, ok := d.GetOk("version"); ok && len(versionOpt.(string)) > 0 {
+		args, warnings, err := common.FixExtraArgsForVersion(common.ComponentKubelet,
+			joinConfig.NodeRegistration.KubeletExtraArgs, versionOpt.(string))
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range warnings {
+			ssh.Debug("%s", w)
+		}
+		joinConfig.NodeRegistration.KubeletExtraArgs = args
+	}
+
 	return joinConfig, nil
 }
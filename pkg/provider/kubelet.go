@@ -0,0 +1,69 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// getKubeletResourcesFromResourceData returns the resources management
+// configuration in the "kubelet" block
+func getKubeletResourcesFromResourceData(d *schema.ResourceData) common.KubeletResources {
+	return common.KubeletResources{
+		TopologyManagerPolicy: d.Get("kubelet.0.topology_manager_policy").(string),
+		CPUManagerPolicy:      d.Get("kubelet.0.cpu_manager_policy").(string),
+		MemoryManagerPolicy:   d.Get("kubelet.0.memory_manager_policy").(string),
+		ReservedSystemCPUs:    d.Get("kubelet.0.reserved_system_cpus").(string),
+		ReservedMemory:        d.Get("kubelet.0.reserved_memory").(string),
+	}
+}
+
+// setKubeletArgs adds the kubelet args for the resources management and
+// seccomp configuration in the "kubelet" block (if present) to `args`
+func setKubeletArgs(d *schema.ResourceData, args map[string]string) (map[string]string, error) {
+	if _, ok := d.GetOk("kubelet.0"); !ok {
+		return args, nil
+	}
+
+	kubeVersion := d.Get("version").(string)
+	resourcesArgs, err := common.GetKubeletResourcesArgs(getKubeletResourcesFromResourceData(d), kubeVersion)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'kubelet' configuration: %s", err)
+	}
+
+	res := map[string]string{}
+	for k, v := range args {
+		res[k] = v
+	}
+	for k, v := range resourcesArgs {
+		res[k] = v
+	}
+
+	// use the RuntimeDefault seccomp profile for all the workloads
+	if d.Get("kubelet.0.seccomp_default").(bool) {
+		seccompArgs, err := common.GetKubeletSeccompDefaultArgs(kubeVersion, res["feature-gates"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'kubelet' configuration: %s", err)
+		}
+		for k, v := range seccompArgs {
+			res[k] = v
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,41 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"os"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// getKubernetesClient returns a client for the cluster, using the kubeconfig
+// in the "config_path" (nil when it has not been downloaded yet)
+func getKubernetesClient(d *schema.ResourceData) (kubernetes.Interface, error) {
+	kubeconfig := d.Get("config_path").(string)
+	if kubeconfig == "" {
+		return nil, nil
+	}
+	if _, err := os.Stat(kubeconfig); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	return kubernetes.NewForConfig(config)
+}
@@ -0,0 +1,56 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// resourceKubeadmSchemaVersion is the current version of the schema of the resource.
+// It must be increased (adding a new state upgrader) when the internal schema changes.
+const resourceKubeadmSchemaVersion = 1
+
+// withStateUpgraders adds the state upgraders to the resource
+func withStateUpgraders(r *schema.Resource) *schema.Resource {
+	// (the schema has not changed its shape since version 0)
+	r.SchemaVersion = resourceKubeadmSchemaVersion
+	r.StateUpgraders = []schema.StateUpgrader{
+		{
+			Version: 0,
+			Type:    r.CoreConfigSchema().ImpliedType(),
+			Upgrade: upgradeKubeadmStateV0,
+		},
+	}
+	return r
+}
+
+// upgradeKubeadmStateV0 migrates the kubeadm configurations stored in the state
+// to the kubeadm API version used by this version of the provider
+func upgradeKubeadmStateV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	config, ok := rawState["config"].(map[string]interface{})
+	if !ok {
+		return rawState, nil
+	}
+
+	ssh.Debug("migrating the kubeadm configuration in the state")
+	if err := common.MigrateProvisionerConfig(config); err != nil {
+		return nil, err
+	}
+	rawState["config"] = config
+	return rawState, nil
+}
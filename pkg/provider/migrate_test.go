@@ -0,0 +1,59 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestUpgradeKubeadmStateV0(t *testing.T) {
+	if err := dataSourceKubeadm().InternalValidate(nil, true); err != nil {
+		t.Fatalf("Error: invalid resource: %v", err)
+	}
+
+	initContents := `
+apiVersion: kubeadm.k8s.io/v1beta1
+kind: InitConfiguration
+---
+apiVersion: kubeadm.k8s.io/v1beta1
+kind: ClusterConfiguration
+kubernetesVersion: v1.14.1
+`
+	init := common.ToTerraformSafeString([]byte(initContents))
+	rawState := map[string]interface{}{
+		"config_path": "/tmp/kubeconfig",
+		"config": map[string]interface{}{
+			"init": init,
+		},
+	}
+
+	upgraded, err := upgradeKubeadmStateV0(rawState, nil)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if upgraded["config_path"] != "/tmp/kubeconfig" {
+		t.Fatalf("Error: unexpected change in the state: %v", upgraded)
+	}
+	if upgraded["config"].(map[string]interface{})["init"] == init {
+		t.Fatalf("Error: init configuration not migrated")
+	}
+
+	// states without a config are not modified
+	if _, err := upgradeKubeadmStateV0(map[string]interface{}{}, nil); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+}
@@ -19,6 +19,8 @@ import (
 
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
 var testAccProviders map[string]terraform.ResourceProvider
@@ -43,3 +45,61 @@ func TestProvider_impl(t *testing.T) {
 
 func testAccPreCheck(t *testing.T) {
 }
+
+func TestProviderReadOnly(t *testing.T) {
+	p := Provider().(*schema.Provider)
+	for readOnly, shouldFail := range map[bool]bool{true: true, false: false} {
+		meta, err := providerConfigure(schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{"read_only": readOnly}))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		err = dataSourceKubeadmDelete(schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, map[string]interface{}{}), meta)
+		if (err != nil) != shouldFail {
+			t.Fatalf("Error: unexpected result when deleting with read_only=%t: %v", readOnly, err)
+		}
+	}
+}
+
+func TestProviderReadOnlyForwardedToProvisioners(t *testing.T) {
+	p := Provider().(*schema.Provider)
+	for _, readOnly := range []bool{true, false} {
+		meta, err := providerConfigure(schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{"read_only": readOnly}))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		d := schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, map[string]interface{}{})
+		if err := d.Set("config", map[string]interface{}{"token": "abcdef.0123456789abcdef", "read_only": "true"}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := setProviderModesInConfig(d, meta); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if res := common.IsReadOnlyFromConfig(common.GetProvisionerConfig(d)); res != readOnly {
+			t.Fatalf("Error: read-only in the provisioner config = %t, expected %t", res, readOnly)
+		}
+	}
+}
+
+func TestProviderPlanOnlyForwardedToProvisioners(t *testing.T) {
+	p := Provider().(*schema.Provider)
+	for _, planOnly := range []bool{true, false} {
+		meta, err := providerConfigure(schema.TestResourceDataRaw(t, p.Schema, map[string]interface{}{"plan_only": planOnly}))
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		d := schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, map[string]interface{}{})
+		if err := d.Set("config", map[string]interface{}{"token": "abcdef.0123456789abcdef", "plan_only": "true"}); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		if err := setProviderModesInConfig(d, meta); err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		config := common.GetProvisionerConfig(d)
+		if res := common.IsPlanOnlyFromConfig(config); res != planOnly {
+			t.Fatalf("Error: plan-only in the provisioner config = %t, expected %t", res, planOnly)
+		}
+		if common.IsReadOnlyFromConfig(config) {
+			t.Fatalf("Error: read-only enabled in the provisioner config")
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// pkiOutputs are the PKI materials that can be exposed in the "pki" attribute,
+// with the key used in the attribute and the certificate in the config
+var pkiOutputs = map[string]struct {
+	key  string
+	cert func(*common.CertsConfig) string
+}{
+	"ca":             {"ca_crt", func(c *common.CertsConfig) string { return c.CaCrt }},
+	"front_proxy_ca": {"front_proxy_ca_crt", func(c *common.CertsConfig) string { return c.ProxyCrt }},
+	"etcd_ca":        {"etcd_ca_crt", func(c *common.CertsConfig) string { return c.EtcdCrt }},
+}
+
+// getPKIOutputNames returns the names of the PKI materials that can be exposed
+func getPKIOutputNames() []string {
+	return []string{"ca", "front_proxy_ca", "etcd_ca"}
+}
+
+// readPKIOutputs sets the "pki" attribute with the (PEM) certificates selected in "pki_outputs"
+func readPKIOutputs(d *schema.ResourceData) error {
+	pki := map[string]string{}
+
+	selected := d.Get("pki_outputs").([]interface{})
+	if len(selected) > 0 {
+		certsConfig := common.CertsConfig{}
+		if err := certsConfig.FromResourceDataConfig(d); err != nil {
+			return err
+		}
+		for _, name := range selected {
+			output, ok := pkiOutputs[name.(string)]
+			if !ok {
+				continue
+			}
+			if cert := output.cert(&certsConfig); cert != "" {
+				pki[output.key] = cert
+			}
+		}
+	}
+
+	return d.Set("pki", pki)
+}
+
+// readCACertHash sets the "ca_cert_hash" attribute with the hash of the CA certificate
+// (as used in the `--discovery-token-ca-cert-hash` of `kubeadm join`)
+func readCACertHash(d *schema.ResourceData) error {
+	certsConfig := common.CertsConfig{}
+	if err := certsConfig.FromResourceDataConfig(d); err != nil {
+		return err
+	}
+	if certsConfig.CaCrt == "" {
+		return d.Set("ca_cert_hash", "")
+	}
+
+	hash, err := common.GetCACertHash(certsConfig.CaCrt)
+	if err != nil {
+		return err
+	}
+	return d.Set("ca_cert_hash", hash)
+}
@@ -0,0 +1,62 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestReadPKIOutputs(t *testing.T) {
+	raw := map[string]interface{}{
+		"config_path": "/tmp/kubeconfig",
+		"pki_outputs": []interface{}{"ca", "etcd_ca"},
+	}
+	d := schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, raw)
+	config := map[string]interface{}{
+		"ca_crt":    "CA CERTIFICATE",
+		"ca_key":    "CA KEY",
+		"etcd_crt":  "ETCD CERTIFICATE",
+		"proxy_crt": "PROXY CERTIFICATE",
+	}
+	if err := d.Set("config", config); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if err := readPKIOutputs(d); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	expected := map[string]interface{}{
+		"ca_crt":      "CA CERTIFICATE",
+		"etcd_ca_crt": "ETCD CERTIFICATE",
+	}
+	if pki := d.Get("pki").(map[string]interface{}); !reflect.DeepEqual(pki, expected) {
+		t.Fatalf("Error: unexpected PKI outputs: %v, expected: %v", pki, expected)
+	}
+
+	// nothing is exposed by default
+	d = schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, map[string]interface{}{"config_path": "/tmp/kubeconfig"})
+	if err := d.Set("config", config); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if err := readPKIOutputs(d); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if pki := d.Get("pki").(map[string]interface{}); len(pki) > 0 {
+		t.Fatalf("Error: unexpected PKI outputs: %v", pki)
+	}
+}
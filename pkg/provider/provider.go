@@ -17,11 +17,17 @@ package provider
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/terraform/helper/schema"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 
 	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
@@ -31,6 +37,10 @@ import (
 func dataSourceKubeadmCreate(d *schema.ResourceData, meta interface{}) error {
 	ssh.Debug("dataSourceKubeadmRead: new resource = %v", d.IsNewResource())
 
+	if err := checkReadOnly(meta, "creating a new cluster configuration"); err != nil {
+		return err
+	}
+
 	_, ok := d.GetOk("config")
 	if !ok {
 		ssh.Debug("no previous configuration found: creating new configuration...")
@@ -50,13 +60,31 @@ func dataSourceKubeadmCreate(d *schema.ResourceData, meta interface{}) error {
 
 // dataSourceKubeadmReads is responsible for reading any resources
 func dataSourceKubeadmRead(d *schema.ResourceData, meta interface{}) error {
-	return nil
+	if err := setProviderModesInConfig(d, meta); err != nil {
+		return err
+	}
+	if err := readPKIOutputs(d); err != nil {
+		return err
+	}
+	if err := readCACertHash(d); err != nil {
+		return err
+	}
+	if err := readVaultAuth(d); err != nil {
+		return err
+	}
+	return readConfigDrift(d)
 }
 
 // dataSourceKubeadmDelete is responsible for deleting all the kubeadm resources
 func dataSourceKubeadmDelete(d *schema.ResourceData, meta interface{}) error {
+	if err := checkReadOnly(meta, "destroying the cluster configuration"); err != nil {
+		return err
+	}
+
 	kubeconfig, ok := d.GetOk("config_path")
-	if ok {
+	if ok && isPlanOnly(meta) {
+		ssh.Debug("[plan-only] would remove the kubeconfig file %q", kubeconfig.(string))
+	} else if ok {
 		kubeconfigS := kubeconfig.(string)
 		ssh.Debug("trying to remove current kubeconfig file %q", kubeconfigS)
 		err := os.Remove(kubeconfigS)
@@ -71,6 +99,96 @@ func dataSourceKubeadmDelete(d *schema.ResourceData, meta interface{}) error {
 // dataSourceKubeadmUpdate is responsible for updating things
 func dataSourceKubeadmUpdate(d *schema.ResourceData, meta interface{}) error {
 	// TODO: pass the responsability for creating the new token to the provisioner
+
+	if d.Get("drift.0.remediate").(bool) {
+		if err := checkReadOnly(meta, "remediating the configuration drift"); err != nil {
+			return err
+		}
+		if isPlanOnly(meta) {
+			ssh.Debug("[plan-only] would request the remediation of the configuration drift")
+		} else if err := remediateConfigDrift(d); err != nil {
+			return err
+		}
+	}
+
+	return dataSourceKubeadmRead(d, meta)
+}
+
+// dataSourceKubeadmCustomizeDiff checks the configuration when planning
+func dataSourceKubeadmCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	// the PKI materials exposed change with the selection
+	if d.HasChange("pki_outputs") {
+		if err := d.SetNewComputed("pki"); err != nil {
+			return err
+		}
+	}
+
+	// drifted nodes are remediated in the next apply
+	if d.Get("drift.0.remediate").(bool) && len(d.Get("config_drift").(map[string]interface{})) > 0 {
+		if err := d.SetNewComputed("config_drift"); err != nil {
+			return err
+		}
+	}
+
+	// flags removed in the target version would crash the components after an upgrade
+	if err := checkExtraArgsForVersion(d); err != nil {
+		return fmt.Errorf("invalid extra args: %s", err)
+	}
+
+	podsCIDR := common.DefPodCIDR
+	if p, ok := d.GetOk("network.0.pods"); ok && len(p.(string)) > 0 {
+		podsCIDR = p.(string)
+	}
+	servicesCIDR := common.DefServiceCIDR
+	if s, ok := d.GetOk("network.0.services"); ok && len(s.(string)) > 0 {
+		servicesCIDR = s.(string)
+	}
+
+	// values could be unknown at this point (ie, when coming from other resources)
+	if !d.NewValueKnown("network.0.pods") || !d.NewValueKnown("network.0.services") || !d.NewValueKnown("network.0.nodes") {
+		return nil
+	}
+
+	nodesCIDRs := []string{}
+	for _, n := range d.Get("network.0.nodes").([]interface{}) {
+		nodesCIDRs = append(nodesCIDRs, n.(string))
+	}
+
+	if err := common.CheckNetworksOverlap(podsCIDR, servicesCIDR, nodesCIDRs); err != nil {
+		return fmt.Errorf("invalid network configuration: %s", err)
+	}
+	return nil
+}
+
+// checkExtraArgsForVersion checks the components' extra args do not
+// contain flags that have been removed in the target version
+func checkExtraArgsForVersion(d *schema.ResourceDiff) error {
+	v, ok := d.GetOk("version")
+	if !ok || len(v.(string)) == 0 || !d.NewValueKnown("version") {
+		return nil
+	}
+
+	for key, component := range map[string]string{
+		"runtime.0.extra_args.0.api_server":         common.ComponentAPIServer,
+		"runtime.0.extra_args.0.controller_manager": common.ComponentControllerManager,
+		"runtime.0.extra_args.0.scheduler":          common.ComponentScheduler,
+		"runtime.0.extra_args.0.kubelet":            common.ComponentKubelet,
+	} {
+		if !d.NewValueKnown(key) {
+			continue
+		}
+		argsOpt, ok := d.GetOk(key)
+		if !ok {
+			continue
+		}
+		args := map[string]string{}
+		for k, value := range argsOpt.(map[string]interface{}) {
+			args[k] = value.(string)
+		}
+		if _, _, err := common.FixExtraArgsForVersion(component, args, v.(string)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -100,6 +218,30 @@ func dataSourceKubeadmExists(d *schema.ResourceData, meta interface{}) (bool, er
 	return true, nil
 }
 
+// setServiceIPs sets the IPs of the API server and DNS services in the
+// services subnet, the same way kubeadm computes them
+func setServiceIPs(d *schema.ResourceData, servicesCIDR string) error {
+	if servicesCIDR == "" {
+		servicesCIDR = common.DefServiceCIDR
+	}
+	// (in dual-stack clusters, the services are in the first subnet)
+	servicesCIDR = strings.TrimSpace(strings.Split(servicesCIDR, ",")[0])
+
+	for attr, index := range map[string]int{
+		"api_service_ip": common.DefAPIServiceIPIndex,
+		"dns_service_ip": common.DefDNSServiceIPIndex,
+	} {
+		ip, err := common.GetServiceIP(servicesCIDR, index)
+		if err != nil {
+			return fmt.Errorf("invalid services subnet %q: %s", servicesCIDR, err)
+		}
+		if err := d.Set(attr, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // createConfigForProvisioner computes and sets the config for the provisioner
 func createConfigForProvisioner(d *schema.ResourceData) error {
 	var err error
@@ -111,6 +253,9 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 	}
 	ssh.Debug("kubeadm token = %s", token)
 
+	// the token is created in the `kubeadm init`, so it will not expire before this time
+	tokenExpires := time.Now().Add(common.DefTokenTTL).UTC().Format(time.RFC3339)
+
 	ssh.Debug("creating kubeadm configuration for init and join")
 	initConfig, err := dataSourceToInitConfig(d, token)
 	if err != nil {
@@ -148,6 +293,7 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 	// NOTE: these fields must be in ProvisionerConfigElements
 	provConfig := map[string]interface{}{
 		"token":               token,
+		"token_expires":       tokenExpires,
 		"init":                common.ToTerraformSafeString(initConfigBytes[:]),
 		"join":                common.ToTerraformSafeString(joinConfigBytes[:]),
 		"config_path":         kubeconfig,
@@ -170,6 +316,10 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 		provConfig["cni_bin_dir"] = common.DefCniBinDir
 	}
 
+	if mtu, ok := d.GetOk("cni.0.mtu"); ok && mtu.(int) > 0 {
+		provConfig["cni_mtu"] = strconv.Itoa(mtu.(int))
+	}
+
 	if p, ok := d.GetOk("network.0.pods"); ok {
 		provConfig["cni_pod_cidr"] = p.(string)
 	} else {
@@ -199,12 +349,146 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 		}
 	}
 
+	// ports allowed in control plane nodes (including the API server and the NodePorts)
+	if _, ok := d.GetOk("hardening.0"); ok && d.Get("hardening.0.verify_ports").(bool) {
+		apiPort := int(initConfig.LocalAPIEndpoint.BindPort)
+		if apiPort == 0 {
+			apiPort = common.DefAPIServerPort
+		}
+		allowed := []string{strconv.Itoa(apiPort), common.DefNodePortsRange}
+		for _, port := range common.DefControlPlanePorts {
+			allowed = append(allowed, strconv.Itoa(port))
+		}
+		for _, port := range d.Get("hardening.0.allowed_ports").([]interface{}) {
+			allowed = append(allowed, port.(string))
+		}
+		provConfig["allowed_ports"] = strings.Join(allowed, ",")
+	}
+
+	if _, ok := d.GetOk("kubelet.0"); ok {
+		provConfig["kubelet_cpu_manager_policy"] = d.Get("kubelet.0.cpu_manager_policy").(string)
+		provConfig["kubelet_memory_manager_policy"] = d.Get("kubelet.0.memory_manager_policy").(string)
+
+		shutdown, err := common.ParseKubeletShutdown(d.Get("kubelet.0.shutdown_grace_period").(string),
+			d.Get("kubelet.0.shutdown_grace_period_critical_pods").(string))
+		if err != nil {
+			return fmt.Errorf("invalid 'kubelet' configuration: %s", err)
+		}
+		if shutdown.IsEnabled() {
+			provConfig["kubelet_shutdown_grace_period"] = shutdown.GracePeriod.String()
+			provConfig["kubelet_shutdown_grace_period_critical_pods"] = shutdown.CriticalPodsGracePeriod.String()
+		}
+	}
+
+	if driver, ok := d.GetOk("runtime.0.storage_driver"); ok {
+		provConfig["runtime_storage_driver"] = driver.(string)
+	} else {
+		provConfig["runtime_storage_driver"] = common.DefRuntimeStorageDriver
+	}
+
+	if _, ok := d.GetOk("drift.0"); ok && d.Get("drift.0.watchdog").(bool) {
+		provConfig["drift_watchdog"] = "true"
+	}
+
+	if secretsRaw, ok := d.GetOk("image_pull_secrets"); ok {
+		secrets := []common.ImagePullSecret{}
+		for _, secretRaw := range secretsRaw.([]interface{}) {
+			m := secretRaw.(map[string]interface{})
+			secret := common.ImagePullSecret{
+				Name:                m["name"].(string),
+				Server:              m["server"].(string),
+				Username:            m["username"].(string),
+				Password:            m["password"].(string),
+				Email:               m["email"].(string),
+				Namespaces:          []string{},
+				PatchServiceAccount: m["patch_default_service_account"].(bool),
+			}
+			for _, ns := range m["namespaces"].([]interface{}) {
+				secret.Namespaces = append(secret.Namespaces, ns.(string))
+			}
+			if len(secret.Namespaces) == 0 {
+				secret.Namespaces = []string{"default"}
+			}
+			secrets = append(secrets, secret)
+		}
+		secretsStr, err := common.ImagePullSecretsToTerraformSafeString(secrets)
+		if err != nil {
+			return err
+		}
+		provConfig["image_pull_secrets"] = secretsStr
+	}
+
+	if d.Get("cert_manager.0.install").(bool) {
+		provConfig["cert_manager_version"] = d.Get("cert_manager.0.version").(string)
+		if _, ok := d.GetOk("cert_manager.0.issuer"); ok {
+			issuerType := strings.ToLower(d.Get("cert_manager.0.issuer.0.type").(string))
+			provConfig["cert_manager_issuer_type"] = issuerType
+			provConfig["cert_manager_issuer_name"] = d.Get("cert_manager.0.issuer.0.name").(string)
+			if issuerType == "acme" {
+				email := d.Get("cert_manager.0.issuer.0.acme_email").(string)
+				if email == "" {
+					return fmt.Errorf("an 'acme_email' is required for ACME issuers")
+				}
+				provConfig["cert_manager_acme_email"] = email
+				provConfig["cert_manager_acme_server"] = d.Get("cert_manager.0.issuer.0.acme_server").(string)
+				provConfig["cert_manager_acme_ingress_class"] = d.Get("cert_manager.0.issuer.0.acme_ingress_class").(string)
+			}
+		}
+	}
+
+	if d.Get("api.0.local_bootstrap").(bool) {
+		if _, ok := d.GetOk("api.0.external"); !ok {
+			return fmt.Errorf("'local_bootstrap' can only be used when an 'external' API address is provided")
+		}
+		provConfig["api_local_bootstrap"] = "true"
+	}
+
+	if _, ok := d.GetOk("vault_auth.0"); ok {
+		provConfig["vault_auth_service_account"] = d.Get("vault_auth.0.service_account").(string)
+		provConfig["vault_auth_namespace"] = d.Get("vault_auth.0.namespace").(string)
+	}
+
+	if d.Get("ingress.0.install").(bool) {
+		provConfig["ingress_controller"] = strings.ToLower(d.Get("ingress.0.controller").(string))
+		provConfig["ingress_mode"] = strings.ToLower(d.Get("ingress.0.mode").(string))
+		if manifest, ok := d.GetOk("ingress.0.manifest"); ok && len(manifest.(string)) > 0 {
+			provConfig["ingress_manifest"] = manifest.(string)
+		}
+		if addresses, ok := d.GetOk("ingress.0.metallb_addresses"); ok {
+			if provConfig["ingress_mode"] != "loadbalancer" {
+				return fmt.Errorf("'metallb_addresses' can only be used in the 'loadbalancer' ingress mode")
+			}
+			res := []string{}
+			for _, a := range addresses.([]interface{}) {
+				res = append(res, strings.TrimSpace(a.(string)))
+			}
+			provConfig["ingress_metallb_addresses"] = strings.Join(res, ",")
+		}
+	}
+
+	if d.Get("network.0.dns.0.autoscaler.0.install").(bool) {
+		linear, err := getDNSAutoscalerLinear(d)
+		if err != nil {
+			return err
+		}
+		provConfig["dns_autoscaler_enabled"] = "true"
+		provConfig["dns_autoscaler_linear"] = linear
+		provConfig["dns_autoscaler_image"] = d.Get("network.0.dns.0.autoscaler.0.image").(string)
+	}
+
 	if version, ok := d.GetOk("version"); ok {
 		provConfig["kube_version"] = version.(string)
 	} else {
 		provConfig["kube_version"] = common.DefKubernetesVersion
 	}
 
+	// copy the credentials for the artifacts storage
+	for _, k := range []string{"endpoint", "region", "access_key", "secret_key", "gcs_credentials", "azure_account", "azure_sas_token"} {
+		if v, ok := d.GetOk("storage.0." + k); ok && len(v.(string)) > 0 {
+			provConfig["storage_"+k] = v.(string)
+		}
+	}
+
 	if cloudProviderRaw, ok := d.GetOk("cloud.0.provider"); ok && len(cloudProviderRaw.(string)) > 0 {
 		cloudProvider := cloudProviderRaw.(string)
 		provConfig["cloud_provider"] = cloudProvider
@@ -232,6 +516,28 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 		provConfig[k] = v
 	}
 
+	// create the namespaces, bindings and users in the "bootstrap" block
+	// (the "kubeconfigs" must always be set, as it is a computed attribute)
+	kubeconfigs := map[string]string{}
+	if _, ok := d.GetOk("bootstrap"); ok {
+		manifest, bootstrapKubeconfigs, err := getBootstrapFromResourceData(d, initConfig, certConfig)
+		if err != nil {
+			return err
+		}
+		if manifest != "" {
+			provConfig["bootstrap_manifest"] = common.ToTerraformSafeString([]byte(manifest))
+		}
+		kubeconfigs = bootstrapKubeconfigs
+	}
+	if err := d.Set("kubeconfigs", kubeconfigs); err != nil {
+		return err
+	}
+
+	// the IPs of the API server and DNS services, so they can be used in other resources
+	if err := setServiceIPs(d, initConfig.Networking.ServiceSubnet); err != nil {
+		return err
+	}
+
 	if err = d.Set("config", provConfig); err != nil {
 		return err
 	}
@@ -251,6 +557,103 @@ func createConfigForProvisioner(d *schema.ResourceData) error {
 	return nil
 }
 
+// toStringsList converts a list of interfaces to a list of strings
+func toStringsList(l interface{}) []string {
+	res := []string{}
+	for _, v := range l.([]interface{}) {
+		res = append(res, v.(string))
+	}
+	return res
+}
+
+// getBootstrapFromResourceData returns the manifest for the "bootstrap" block,
+// as well as the kubeconfigs for the users
+func getBootstrapFromResourceData(d *schema.ResourceData, initConfig *kubeadmapi.InitConfiguration, certConfig map[string]string) (string, map[string]string, error) {
+	namespaces := toStringsList(d.Get("bootstrap.0.namespaces"))
+
+	bindings := []common.BootstrapBinding{}
+	for _, bindingRaw := range d.Get("bootstrap.0.binding").([]interface{}) {
+		m := bindingRaw.(map[string]interface{})
+		bindings = append(bindings, common.BootstrapBinding{
+			Name:            m["name"].(string),
+			ClusterRole:     m["cluster_role"].(string),
+			Users:           toStringsList(m["users"]),
+			Groups:          toStringsList(m["groups"]),
+			ServiceAccounts: toStringsList(m["service_accounts"]),
+		})
+	}
+
+	users := []common.BootstrapUser{}
+	for _, userRaw := range d.Get("bootstrap.0.user").([]interface{}) {
+		m := userRaw.(map[string]interface{})
+		users = append(users, common.BootstrapUser{
+			Name:   m["name"].(string),
+			Role:   m["role"].(string),
+			Groups: toStringsList(m["groups"]),
+		})
+	}
+
+	manifest, err := common.GetBootstrapManifest(namespaces, bindings, users)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kubeconfigs := map[string]string{}
+	if len(users) > 0 {
+		server := ""
+		switch {
+		case initConfig.ControlPlaneEndpoint != "":
+			server = "https://" + common.AddressWithPort(initConfig.ControlPlaneEndpoint, common.DefAPIServerPort)
+		case initConfig.LocalAPIEndpoint.AdvertiseAddress != "":
+			server = "https://" + net.JoinHostPort(initConfig.LocalAPIEndpoint.AdvertiseAddress, strconv.Itoa(int(initConfig.LocalAPIEndpoint.BindPort)))
+		default:
+			return "", nil, fmt.Errorf("an 'api.external' or 'api.internal' address is required for creating kubeconfigs for users")
+		}
+
+		clusterName := initConfig.ClusterName
+		if clusterName == "" {
+			clusterName = "kubernetes"
+		}
+		for _, user := range users {
+			kubeconfig, err := common.CreateUserKubeconfig([]byte(certConfig["ca_crt"]), []byte(certConfig["ca_key"]),
+				server, clusterName, user)
+			if err != nil {
+				return "", nil, err
+			}
+			kubeconfigs[user.Name] = string(kubeconfig)
+		}
+	}
+	return manifest, kubeconfigs, nil
+}
+
+// dnsAutoscalerLinear are the parameters for the "linear" mode of the cluster-proportional-autoscaler
+type dnsAutoscalerLinear struct {
+	CoresPerReplica           int  `json:"coresPerReplica"`
+	NodesPerReplica           int  `json:"nodesPerReplica"`
+	Min                       int  `json:"min"`
+	Max                       int  `json:"max,omitempty"`
+	PreventSinglePointFailure bool `json:"preventSinglePointFailure"`
+}
+
+// getDNSAutoscalerLinear returns the replicas policy for the CoreDNS autoscaler, in JSON
+func getDNSAutoscalerLinear(d *schema.ResourceData) (string, error) {
+	linear := dnsAutoscalerLinear{
+		CoresPerReplica:           d.Get("network.0.dns.0.autoscaler.0.cores_per_replica").(int),
+		NodesPerReplica:           d.Get("network.0.dns.0.autoscaler.0.nodes_per_replica").(int),
+		Min:                       d.Get("network.0.dns.0.autoscaler.0.min").(int),
+		Max:                       d.Get("network.0.dns.0.autoscaler.0.max").(int),
+		PreventSinglePointFailure: d.Get("network.0.dns.0.autoscaler.0.prevent_single_point_failure").(bool),
+	}
+	if linear.Max > 0 && linear.Max < linear.Min {
+		return "", fmt.Errorf("the maximum number of DNS replicas (%d) is lower than the minimum (%d)", linear.Max, linear.Min)
+	}
+	res, err := json.Marshal(linear)
+	if err != nil {
+		return "", err
+	}
+	return string(res), nil
+}
+
 // dataSourceVerify verifies the config
 func dataSourceVerify(d *schema.ResourceData) error {
 	ssh.Debug("verifying configuration...")
@@ -17,6 +17,7 @@ package provider
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/resource"
@@ -59,6 +60,15 @@ func TestKubeadm_basic(t *testing.T) {
 						"config.init"),
 					resource.TestCheckResourceAttrSet("kubeadm.k8s",
 						"config.join"),
+					resource.TestCheckResourceAttr("kubeadm.k8s",
+						"api_service_ip",
+						"10.25.0.1"),
+					resource.TestCheckResourceAttr("kubeadm.k8s",
+						"dns_service_ip",
+						"10.25.0.10"),
+					resource.TestMatchResourceAttr("kubeadm.k8s",
+						"ca_cert_hash",
+						regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)),
 				),
 			},
 		},
@@ -171,3 +181,39 @@ func testAccCheckAttr(id string, attr string) resource.TestCheckFunc {
 		return nil
 	}
 }
+
+func TestKubeadm_dnsAutoscaler(t *testing.T) {
+	const testAccKubeadm_dnsAutoscaler = `
+        resource "kubeadm" "k8s" {
+        	config_path = "/tmp/kubeconfig"
+
+        	network {
+        		dns {
+        			autoscaler {
+        				install           = true
+        				nodes_per_replica = 8
+        				max               = 10
+        			}
+        		}
+        	}
+        }`
+
+	resource.UnitTest(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccKubeadm_dnsAutoscaler,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckState("kubeadm.k8s"),
+					resource.TestCheckResourceAttr("kubeadm.k8s",
+						"config.dns_autoscaler_enabled",
+						"true"),
+					resource.TestCheckResourceAttr("kubeadm.k8s",
+						"config.dns_autoscaler_linear",
+						`{"coresPerReplica":256,"nodesPerReplica":8,"min":1,"max":10,"preventSinglePointFailure":true}`),
+				),
+			},
+		},
+	})
+}
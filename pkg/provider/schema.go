@@ -26,13 +26,15 @@ import (
 )
 
 func dataSourceKubeadm() *schema.Resource {
-	return &schema.Resource{
+	return withStateUpgraders(&schema.Resource{
 		Create: dataSourceKubeadmCreate,
 		Read:   dataSourceKubeadmRead,
 		Delete: dataSourceKubeadmDelete,
-		//Update: dataSourceKubeadmUpdate,
+		Update: dataSourceKubeadmUpdate,
 		Exists: dataSourceKubeadmExists,
 
+		CustomizeDiff: dataSourceKubeadmCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"config_path": {
 				Type:        schema.TypeString,
@@ -65,6 +67,12 @@ func dataSourceKubeadm() *schema.Resource {
 							Optional:    true,
 							Description: "List of SANs to use in api-server certificate. Example: 'IP=127.0.0.1,IP=127.0.0.2,DNS=localhost', If empty, SANs will be obtained from the external and internal names/IPs",
 						},
+						"local_bootstrap": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "point the external endpoint to the local API server in the first master while it is initialized, until it answers to /healthz",
+						},
 					},
 				},
 			},
@@ -100,6 +108,332 @@ func dataSourceKubeadm() *schema.Resource {
 					},
 				},
 			},
+			"bootstrap": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"namespaces": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "namespaces to create",
+							Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: common.ValidateDNSName},
+						},
+						"binding": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "ClusterRoleBindings to create",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:        schema.TypeString,
+										Required:    true,
+										Description: "name of the ClusterRoleBinding",
+									},
+									"cluster_role": {
+										Type:        schema.TypeString,
+										Required:    true,
+										Description: "ClusterRole bound",
+									},
+									"users": {
+										Type:        schema.TypeList,
+										Optional:    true,
+										Description: "users bound",
+										Elem:        &schema.Schema{Type: schema.TypeString},
+									},
+									"groups": {
+										Type:        schema.TypeList,
+										Optional:    true,
+										Description: "groups bound",
+										Elem:        &schema.Schema{Type: schema.TypeString},
+									},
+									"service_accounts": {
+										Type:        schema.TypeList,
+										Optional:    true,
+										Description: "service accounts bound, as <namespace>:<name>",
+										Elem:        &schema.Schema{Type: schema.TypeString},
+									},
+								},
+							},
+						},
+						"user": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "users with a client certificate (and a kubeconfig in 'kubeconfigs')",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": {
+										Type:        schema.TypeString,
+										Required:    true,
+										Description: "name of the user",
+									},
+									"role": {
+										Type:         schema.TypeString,
+										Optional:     true,
+										Default:      "",
+										Description:  "role of the user in the cluster: admin, viewer or empty for no role",
+										ValidateFunc: validation.StringInSlice([]string{"", "admin", "viewer"}, false),
+									},
+									"groups": {
+										Type:        schema.TypeList,
+										Optional:    true,
+										Description: "groups of the user",
+										Elem:        &schema.Schema{Type: schema.TypeString},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"kubeconfigs": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "kubeconfigs for the users in the 'bootstrap' block",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"drift": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"watchdog": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							ForceNew:    true,
+							Description: "install a watchdog in the nodes that reports the kubelet and container runtime configuration drift",
+						},
+						"remediate": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "restore the configuration in the drifted nodes in the next apply",
+						},
+					},
+				},
+			},
+			"vault_auth": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"service_account": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefVaultAuthServiceAccount,
+							Description: "ServiceAccount used by Vault for reviewing tokens",
+						},
+						"namespace": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefVaultAuthNamespace,
+							Description: "namespace for the ServiceAccount",
+						},
+					},
+				},
+			},
+			"vault_auth_config": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "configuration for the Kubernetes auth method in Vault (token_reviewer_jwt, kubernetes_ca_cert...)",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"pki_outputs": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "PKI materials exposed in the 'pki' attribute: " + strings.Join(getPKIOutputNames(), ", "),
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice(getPKIOutputNames(), false),
+				},
+			},
+			"pki": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Sensitive:   true,
+				Description: "PEM certificates of the PKI materials selected in 'pki_outputs'",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"ca_cert_hash": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "hash of the CA certificate, for the '--discovery-token-ca-cert-hash' in 'kubeadm join'",
+			},
+			"api_service_ip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "IP of the API server service (the first IP in the services subnet)",
+			},
+			"dns_service_ip": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "IP of the cluster DNS service (the tenth IP in the services subnet)",
+			},
+			"config_drift": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "drifted configuration files (comma-separated) for every node, as reported by the drift watchdog",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"image_pull_secrets": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "name of the secret",
+							ValidateFunc: common.ValidateDNSName,
+						},
+						"server": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "registry server (ie, registry.my-company.com:5000)",
+						},
+						"username": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "username for the registry",
+						},
+						"password": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Sensitive:   true,
+							Description: "password for the registry",
+						},
+						"email": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "email for the registry",
+						},
+						"namespaces": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "namespaces where the secret is created (created if they do not exist)",
+							Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: common.ValidateDNSName},
+						},
+						"patch_default_service_account": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "add the secret to the imagePullSecrets of the default ServiceAccount in the namespaces",
+						},
+					},
+				},
+			},
+			"cert_manager": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"install": {
+							Type:        schema.TypeBool,
+							Default:     false,
+							Optional:    true,
+							Description: "install cert-manager",
+						},
+						"version": {
+							Type:        schema.TypeString,
+							Default:     common.DefCertManagerVersion,
+							Optional:    true,
+							Description: "cert-manager version",
+						},
+						"issuer": {
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"type": {
+										Type:         schema.TypeString,
+										Default:      "selfsigned",
+										Optional:     true,
+										Description:  "type of ClusterIssuer: acme or selfsigned",
+										ValidateFunc: validation.StringInSlice([]string{"acme", "selfsigned"}, true),
+									},
+									"name": {
+										Type:        schema.TypeString,
+										Default:     "default",
+										Optional:    true,
+										Description: "name of the ClusterIssuer",
+									},
+									"acme_email": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "email for the ACME account",
+									},
+									"acme_server": {
+										Type:         schema.TypeString,
+										Default:      common.DefCertManagerACMEServer,
+										Optional:     true,
+										Description:  "ACME server URL",
+										ValidateFunc: common.ValidateURL,
+									},
+									"acme_ingress_class": {
+										Type:        schema.TypeString,
+										Default:     "nginx",
+										Optional:    true,
+										Description: "ingress class used for solving the ACME HTTP01 challenges",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"ingress": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"install": {
+							Type:        schema.TypeBool,
+							Default:     false,
+							Optional:    true,
+							Description: "install an ingress controller",
+						},
+						"controller": {
+							Type:         schema.TypeString,
+							Default:      common.DefIngressController,
+							Optional:     true,
+							Description:  "ingress controller: nginx or contour",
+							ValidateFunc: validation.StringInSlice([]string{"nginx", "contour"}, true),
+						},
+						"mode": {
+							Type:         schema.TypeString,
+							Default:      common.DefIngressMode,
+							Optional:     true,
+							Description:  "how the ingress controller is exposed: hostport, nodeport or loadbalancer",
+							ValidateFunc: validation.StringInSlice([]string{"hostport", "nodeport", "loadbalancer"}, true),
+						},
+						"manifest": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "manifest for the ingress controller, overriding the default one",
+						},
+						"metallb_addresses": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "in the loadbalancer mode, install MetalLB with these addresses (ie, 192.168.1.240-192.168.1.250)",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
 			"cni": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -134,6 +468,13 @@ func dataSourceKubeadm() *schema.Resource {
 							Description:  "Configuration directory for CNI",
 							ValidateFunc: common.ValidateAbsPath,
 						},
+						"mtu": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							Description:  "MTU for the CNI plugin (0 for detecting it automatically from the MTU in the master)",
+							ValidateFunc: validation.IntBetween(0, 65536),
+						},
 						"flannel": {
 							Type:     schema.TypeList,
 							Optional: true,
@@ -195,6 +536,21 @@ func dataSourceKubeadm() *schema.Resource {
 							Description:  "subnet used by pods",
 							ValidateFunc: validation.CIDRNetwork(0, 32),
 						},
+						"nodes": {
+							Type:        schema.TypeList,
+							Optional:    true,
+							Description: "subnets the nodes are in, checked for overlaps with the pods and services subnets",
+							Elem: &schema.Schema{
+								Type:         schema.TypeString,
+								ValidateFunc: validation.CIDRNetwork(0, 128),
+							},
+						},
+						"node_cidr_mask_size": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Description:  "mask size for the pods CIDR allocated to each node by the controller manager (ie, 24 for a /24 per node)",
+							ValidateFunc: validation.IntBetween(1, 32),
+						},
 						"dns": {
 							Type:     schema.TypeList,
 							Optional: true,
@@ -215,6 +571,61 @@ func dataSourceKubeadm() *schema.Resource {
 										Description: "upstream DNS servers",
 										Elem:        &schema.Schema{Type: schema.TypeString},
 									},
+									"autoscaler": {
+										Type:     schema.TypeList,
+										Optional: true,
+										MaxItems: 1,
+										Elem: &schema.Resource{
+											Schema: map[string]*schema.Schema{
+												"install": {
+													Type:        schema.TypeBool,
+													Optional:    true,
+													Default:     false,
+													Description: "install the cluster-proportional-autoscaler for CoreDNS",
+												},
+												"cores_per_replica": {
+													Type:         schema.TypeInt,
+													Optional:     true,
+													Default:      common.DefDNSAutoscalerCoresPerReplica,
+													Description:  "number of cores in the cluster for each CoreDNS replica",
+													ValidateFunc: validation.IntAtLeast(1),
+												},
+												"nodes_per_replica": {
+													Type:         schema.TypeInt,
+													Optional:     true,
+													Default:      common.DefDNSAutoscalerNodesPerReplica,
+													Description:  "number of nodes in the cluster for each CoreDNS replica",
+													ValidateFunc: validation.IntAtLeast(1),
+												},
+												"min": {
+													Type:         schema.TypeInt,
+													Optional:     true,
+													Default:      common.DefDNSAutoscalerMin,
+													Description:  "minimum number of CoreDNS replicas",
+													ValidateFunc: validation.IntAtLeast(1),
+												},
+												"max": {
+													Type:         schema.TypeInt,
+													Optional:     true,
+													Default:      0,
+													Description:  "maximum number of CoreDNS replicas (0 for no limit)",
+													ValidateFunc: validation.IntAtLeast(0),
+												},
+												"prevent_single_point_failure": {
+													Type:        schema.TypeBool,
+													Optional:    true,
+													Default:     true,
+													Description: "run at least two replicas when there is more than one node",
+												},
+												"image": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Default:     common.DefDNSAutoscalerImage,
+													Description: "image for the autoscaler",
+												},
+											},
+										},
+									},
 								},
 							},
 						},
@@ -262,6 +673,40 @@ func dataSourceKubeadm() *schema.Resource {
 					},
 				},
 			},
+			"hardening": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"tls_cipher_suites": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Optional:    true,
+							Description: "TLS cipher suites (Go names) accepted by the API server and etcd",
+						},
+						"tls_min_version": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "minimum TLS version accepted by the API server and etcd",
+							ValidateFunc: validation.StringInSlice(common.TLSVersions, false),
+						},
+						"verify_ports": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "verify that only the expected ports are listening in control plane nodes",
+						},
+						"allowed_ports": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString, ValidateFunc: common.ValidatePortsRange},
+							Optional:    true,
+							Description: "extra ports (or ranges, ie, 8000-8080) allowed in control plane nodes",
+						},
+					},
+				},
+			},
 			"version": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -309,6 +754,13 @@ func dataSourceKubeadm() *schema.Resource {
 							Description:  "runtime engine: docker, containerd or crio",
 							ValidateFunc: validation.StringInSlice([]string{"crio", "containerd", "docker"}, true),
 						},
+						"storage_driver": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      common.DefRuntimeStorageDriver,
+							Description:  "snapshotter/storage driver for the containers runtime: auto, none, overlayfs, fuse-overlayfs, zfs, btrfs or native",
+							ValidateFunc: validation.StringInSlice([]string{"auto", "none", "overlayfs", "fuse-overlayfs", "zfs", "btrfs", "native"}, false),
+						},
 						"extra_args": {
 							Type:     schema.TypeList,
 							Optional: true,
@@ -346,6 +798,64 @@ func dataSourceKubeadm() *schema.Resource {
 					},
 				},
 			},
+			"kubelet": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"topology_manager_policy": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Topology Manager policy: none, best-effort, restricted or single-numa-node",
+							ValidateFunc: validation.StringInSlice(common.TopologyManagerPolicies, false),
+						},
+						"cpu_manager_policy": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "CPU Manager policy: none or static",
+							ValidateFunc: validation.StringInSlice(common.CPUManagerPolicies, false),
+						},
+						"memory_manager_policy": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Memory Manager policy: None or Static",
+							ValidateFunc: validation.StringInSlice(common.MemoryManagerPolicies, false),
+						},
+						"reserved_system_cpus": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "list of CPUs reserved for the system and Kubernetes daemons (ie, 0-1,4)",
+							ValidateFunc: common.ValidateCPUSet,
+						},
+						"reserved_memory": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "memory reserved per NUMA node (ie, 0:memory=1Gi;1:memory=2Gi), required by the Static memory manager policy",
+							ValidateFunc: common.ValidateReservedMemory,
+						},
+						"seccomp_default": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "use the RuntimeDefault seccomp profile for all the workloads",
+						},
+						"shutdown_grace_period": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "time the node shutdown is delayed for terminating the pods (ie, 30s)",
+							ValidateFunc: common.ValidateDuration,
+						},
+						"shutdown_grace_period_critical_pods": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "part of the shutdown grace period reserved for the critical pods (ie, 10s)",
+							ValidateFunc: common.ValidateDuration,
+						},
+					},
+				},
+			},
 			"certs": {
 				Type:     schema.TypeList,
 				Optional: true,
@@ -404,22 +914,151 @@ func dataSourceKubeadm() *schema.Resource {
 					},
 				},
 			},
+			"storage": {
+				Type:     schema.TypeList,
+				Optional: true,
+				ForceNew: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"endpoint": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "endpoint for S3-compatible services (or Azure Blob/GCS emulators)",
+						},
+						"region": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "S3 region",
+						},
+						"access_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "S3 access key (otherwise, obtained from the environment)",
+						},
+						"secret_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "S3 secret key (otherwise, obtained from the environment)",
+						},
+						"gcs_credentials": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "GCS credentials: JSON contents or file name (otherwise, obtained from the environment)",
+						},
+						"azure_account": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Azure storage account (otherwise, obtained from AZURE_STORAGE_ACCOUNT)",
+						},
+						"azure_sas_token": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "Azure SAS token (otherwise, obtained from AZURE_STORAGE_SAS_TOKEN)",
+						},
+					},
+				},
+			},
 			// the "config" must be a map of string that will be passed to the "provisioner"
+			// (it is sensitive as a whole, as Terraform ignores the sensitive elements in maps)
 			"config": {
-				Type:     schema.TypeMap,
-				Computed: true,
+				Type:      schema.TypeMap,
+				Computed:  true,
+				Sensitive: true,
 				Elem: &schema.Resource{
 					Schema: common.ProvisionerConfigElements,
 				},
 			},
 		},
-	}
+	})
 }
 
 func Provider() terraform.ResourceProvider {
 	return &schema.Provider{
+		Schema: map[string]*schema.Schema{
+			"read_only": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(common.ReadOnlyEnvVar, false),
+				Description: "fail in any operation that would create or destroy clusters (useful for audits)",
+			},
+			"plan_only": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(common.PlanOnlyEnvVar, false),
+				Description: "do not change the cluster nor the local kubeconfig (see also the provisioner 'plan_only')",
+			},
+		},
 		ResourcesMap: map[string]*schema.Resource{
 			"kubeadm": dataSourceKubeadm(),
 		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"kubeadm_cluster_info": dataSourceKubeadmClusterInfo(),
+		},
+		ConfigureFunc: providerConfigure,
+	}
+}
+
+// providerConfig is the configuration of the provider
+type providerConfig struct {
+	ReadOnly bool
+	PlanOnly bool
+}
+
+func providerConfigure(d *schema.ResourceData) (interface{}, error) {
+	return &providerConfig{
+		ReadOnly: d.Get("read_only").(bool),
+		PlanOnly: d.Get("plan_only").(bool),
+	}, nil
+}
+
+// isPlanOnly returns true when the provider is in the plan-only mode
+func isPlanOnly(meta interface{}) bool {
+	config, ok := meta.(*providerConfig)
+	return ok && config.PlanOnly
+}
+
+// checkReadOnly returns an error when the provider is in read-only mode
+func checkReadOnly(meta interface{}, operation string) error {
+	if config, ok := meta.(*providerConfig); ok && config.ReadOnly {
+		return common.ReadOnlyError(operation)
+	}
+	return nil
+}
+
+// setProviderModesInConfig forwards the read-only and plan-only modes of the
+// provider to the provisioners, in the `config` they receive
+func setProviderModesInConfig(d *schema.ResourceData, meta interface{}) error {
+	config, ok := meta.(*providerConfig)
+	if !ok {
+		return nil
+	}
+
+	provConfig := common.GetProvisionerConfig(d)
+	if len(provConfig) == 0 {
+		return nil
+	}
+
+	// (the key is removed when the mode is disabled, so there is no diff for existing clusters)
+	changed := false
+	for key, enabled := range map[string]bool{"read_only": config.ReadOnly, "plan_only": config.PlanOnly} {
+		_, current := provConfig[key]
+		if enabled == current {
+			continue
+		}
+		if enabled {
+			provConfig[key] = "true"
+		} else {
+			delete(provConfig, key)
+		}
+		changed = true
+	}
+	if !changed {
+		return nil
 	}
+	return d.Set("config", provConfig)
 }
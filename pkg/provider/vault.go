@@ -0,0 +1,66 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// getVaultAuthTokenReviewerJWT returns the JWT of the ServiceAccount used by
+// Vault for reviewing tokens, from the token Secret created by the provisioner
+func getVaultAuthTokenReviewerJWT(client kubernetes.Interface, namespace string, serviceAccount string) (string, error) {
+	secret, err := client.CoreV1().Secrets(namespace).Get(common.GetVaultAuthSecretName(serviceAccount), metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Data["token"]), nil
+}
+
+// readVaultAuth sets the "vault_auth_config" attribute with everything needed for
+// configuring the Kubernetes auth method in Vault. The token reviewer JWT is
+// only available once the cluster has been created (ie, after the next refresh).
+func readVaultAuth(d *schema.ResourceData) error {
+	config := map[string]string{}
+
+	if _, ok := d.GetOk("vault_auth.0"); ok {
+		namespace := d.Get("vault_auth.0.namespace").(string)
+		serviceAccount := d.Get("vault_auth.0.service_account").(string)
+		config["namespace"] = namespace
+		config["service_account"] = serviceAccount
+
+		certsConfig := common.CertsConfig{}
+		if err := certsConfig.FromResourceDataConfig(d); err == nil && certsConfig.CaCrt != "" {
+			config["kubernetes_ca_cert"] = certsConfig.CaCrt
+		}
+
+		client, err := getKubernetesClient(d)
+		if err == nil && client != nil {
+			var jwt string
+			if jwt, err = getVaultAuthTokenReviewerJWT(client, namespace, serviceAccount); err == nil && jwt != "" {
+				config["token_reviewer_jwt"] = jwt
+			}
+		}
+		if err != nil {
+			ssh.Debug("could not get the token reviewer JWT for Vault: %s", err)
+		}
+	}
+
+	return d.Set("vault_auth_config", config)
+}
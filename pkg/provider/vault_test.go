@@ -0,0 +1,69 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetVaultAuthTokenReviewerJWT(t *testing.T) {
+	client := fake.NewSimpleClientset(&v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "vault-auth-token", Namespace: "kube-system"},
+		Data:       map[string][]byte{"token": []byte("JWT")},
+	})
+
+	jwt, err := getVaultAuthTokenReviewerJWT(client, "kube-system", "vault-auth")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if jwt != "JWT" {
+		t.Fatalf("Error: unexpected JWT: %q", jwt)
+	}
+
+	if _, err := getVaultAuthTokenReviewerJWT(client, "vault", "vault-auth"); err == nil {
+		t.Fatalf("Error: no error for a missing secret")
+	}
+}
+
+func TestReadVaultAuth(t *testing.T) {
+	raw := map[string]interface{}{
+		"config_path": "/non/existing/kubeconfig",
+		"vault_auth":  []interface{}{map[string]interface{}{}},
+	}
+	d := schema.TestResourceDataRaw(t, dataSourceKubeadm().Schema, raw)
+	if err := d.Set("config", map[string]interface{}{"ca_crt": "CA CERTIFICATE"}); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	// the cluster does not exist yet: everything but the JWT should be available
+	if err := readVaultAuth(d); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := d.Get("vault_auth_config").(map[string]interface{})
+	if config["service_account"] != "vault-auth" || config["namespace"] != "kube-system" {
+		t.Fatalf("Error: unexpected Vault auth config: %v", config)
+	}
+	if config["kubernetes_ca_cert"] != "CA CERTIFICATE" {
+		t.Fatalf("Error: CA not found in Vault auth config: %v", config)
+	}
+	if _, ok := config["token_reviewer_jwt"]; ok {
+		t.Fatalf("Error: unexpected JWT in Vault auth config: %v", config)
+	}
+}
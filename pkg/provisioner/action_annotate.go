@@ -0,0 +1,97 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+// getNodeAnnotations returns the annotations for the node, with the
+// Terraform workspace, the resource address and the time of the apply
+func getNodeAnnotations(d *schema.ResourceData, instanceID string, now time.Time) map[string]string {
+	res := map[string]string{}
+	if _, ok := d.GetOk("annotations.0"); !ok {
+		return res
+	}
+
+	workspace := d.Get("annotations.0.workspace").(string)
+	if workspace == "" {
+		workspace = os.Getenv("TF_WORKSPACE")
+	}
+	if workspace == "" {
+		workspace = "default"
+	}
+	address := d.Get("annotations.0.address").(string)
+	if address == "" {
+		address = instanceID
+	}
+
+	for key, value := range map[string]string{
+		d.Get("annotations.0.workspace_key").(string): workspace,
+		d.Get("annotations.0.address_key").(string):   address,
+		d.Get("annotations.0.timestamp_key").(string): now.UTC().Format(time.RFC3339),
+	} {
+		if key != "" && value != "" {
+			res[key] = value
+		}
+	}
+	for key, value := range d.Get("annotations.0.extra").(map[string]interface{}) {
+		res[key] = value.(string)
+	}
+	return res
+}
+
+// getNodeAnnotateArgs returns the `kubectl annotate` arguments for some annotations,
+// with one (unquoted) `key=value` argument per annotation
+func getNodeAnnotateArgs(annotations map[string]string) []string {
+	args := []string{}
+	for key, value := range annotations {
+		args = append(args, key+"="+value)
+	}
+	sort.Strings(args)
+	return args
+}
+
+// doAnnotateNode annotates the Node object with the Terraform run information,
+// so operators can find the Terraform state that manages this machine
+func doAnnotateNode(d *schema.ResourceData, instanceID string) ssh.Action {
+	annotations := getNodeAnnotations(d, instanceID, time.Now())
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	args := getNodeAnnotateArgs(annotations)
+
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		DoGetNodename(d, &node),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return ssh.DoMessageWarn("could not find the Kubernetes nodename: node will not be annotated")
+			}
+			return ssh.ActionList{
+				ssh.DoMessageInfo("Annotating node %q...", node.Nodename),
+				doRemoteKubectl(d, append([]string{"annotate", "--overwrite", "node", node.Nodename}, args...)...),
+			}
+		}),
+	}
+}
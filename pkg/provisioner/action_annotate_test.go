@@ -0,0 +1,84 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+func TestGetNodeAnnotations(t *testing.T) {
+	now := time.Date(2019, 7, 10, 15, 8, 31, 0, time.UTC)
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{})
+	if annotations := getNodeAnnotations(d, "i-1234", now); len(annotations) != 0 {
+		t.Fatalf("Error: unexpected annotations: %v", annotations)
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"annotations": []interface{}{
+			map[string]interface{}{
+				"workspace":   "staging",
+				"address_key": "example.com/tf-resource",
+				"extra": map[string]interface{}{
+					"example.com/team": "platform",
+				},
+			},
+		},
+	})
+
+	expected := map[string]string{
+		"terraform.io/workspace":  "staging",
+		"example.com/tf-resource": "i-1234",
+		"terraform.io/applied-at": "2019-07-10T15:08:31Z",
+		"example.com/team":        "platform",
+	}
+	annotations := getNodeAnnotations(d, "i-1234", now)
+	if len(annotations) != len(expected) {
+		t.Fatalf("Error: unexpected annotations: %v", annotations)
+	}
+	for k, v := range expected {
+		if annotations[k] != v {
+			t.Fatalf("Error: annotation %q = %q, expected %q", k, annotations[k], v)
+		}
+	}
+}
+
+func TestGetNodeAnnotateArgs(t *testing.T) {
+	args := getNodeAnnotateArgs(map[string]string{
+		"example.com/team":  "platform",
+		"example.com/owner": `it's "$(whoami)"; rm -rf /`,
+	})
+
+	expected := []string{`example.com/owner=it's "$(whoami)"; rm -rf /`, "example.com/team=platform"}
+	if len(args) != len(expected) {
+		t.Fatalf("Error: unexpected arguments: %q", args)
+	}
+	for i := range expected {
+		if args[i] != expected[i] {
+			t.Fatalf("Error: unexpected argument %q (expected %q)", args[i], expected[i])
+		}
+	}
+
+	// every annotation must be a single word in the remote shell
+	cmd := ssh.NewCommand("kubectl", append([]string{"annotate", "node", "node1"}, args...)...)
+	if s := cmd.String(); s != `kubectl annotate node node1 'example.com/owner=it'"'"'s "$(whoami)"; rm -rf /' example.com/team=platform` {
+		t.Fatalf("Error: unexpected command: %s", s)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/internal/storage"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	// suffix for the backup files
+	backupSuffix = ".tar.gz"
+
+	// format used for the timestamps in the backups filenames (so they can be sorted)
+	backupTimestampFormat = "20060102-150405"
+)
+
+// getBackupFilename returns the name for a new backup file
+func getBackupFilename(host string, t time.Time) string {
+	return fmt.Sprintf("%s-%s%s", host, t.UTC().Format(backupTimestampFormat), backupSuffix)
+}
+
+// getBackupsToPrune returns the list of backups (from oldest to newest)
+// for `host` that must be removed for keeping only `retention` backups
+func getBackupsToPrune(files []string, host string, retention int) []string {
+	if retention <= 0 {
+		return []string{}
+	}
+
+	backups := []string{}
+	for _, f := range files {
+		base := filepath.Base(f)
+		if strings.HasPrefix(base, host+"-") && strings.HasSuffix(base, backupSuffix) {
+			backups = append(backups, f)
+		}
+	}
+	if len(backups) <= retention {
+		return []string{}
+	}
+
+	// timestamps are sortable, so the oldest backups come first
+	sort.Strings(backups)
+	return backups[:len(backups)-retention]
+}
+
+// doPruneBackups removes the old backups in the storage
+func doPruneBackups(st storage.Storage, host string, retention int) ssh.Action {
+	return ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+		files, err := st.List(ctx, host+"-")
+		if err != nil {
+			return ssh.ActionError(fmt.Sprintf("could not list backups in %s: %s", st, err))
+		}
+
+		for _, f := range getBackupsToPrune(files, host, retention) {
+			ssh.Debug("removing old backup %q from %s", f, st)
+			if err := st.Delete(ctx, f); err != nil {
+				return ssh.ActionError(fmt.Sprintf("could not remove old backup %q: %s", f, err))
+			}
+		}
+		return nil
+	})
+}
+
+// doPushBackup uploads a local backup file to the storage
+func doPushBackup(st storage.Storage, local string, name string) ssh.Action {
+	return ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+		f, err := os.Open(local)
+		if err != nil {
+			return ssh.ActionError(fmt.Sprintf("could not open backup %q: %s", local, err))
+		}
+		defer f.Close()
+
+		if err := st.Put(ctx, name, f); err != nil {
+			return ssh.ActionError(fmt.Sprintf("could not store backup in %s: %s", st, err))
+		}
+		return ssh.DoMessageInfo("Backup saved as %q in %s", name, st)
+	})
+}
+
+// doBackupKubernetesConfig archives the kubernetes configuration directory
+// (including the PKI and the static manifests) and downloads it to a local directory
+func doBackupKubernetesConfig(d *schema.ResourceData) ssh.Action {
+	location := getBackupDirFromResourceData(d)
+	retention := d.Get("backup.0.retention").(int)
+
+	var hostBuf bytes.Buffer
+
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Backing up %q...", common.DefKubernetesConfigDir),
+		ssh.DoSendingExecOutputToWriter(ssh.DoExec("hostname"), &hostBuf),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			host := strings.TrimSpace(hostBuf.String())
+			if host == "" {
+				return ssh.ActionError("could not get the hostname for the backup")
+			}
+
+			remoteArchive, err := ssh.GetRemoteTempFile(ctx)
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
+			}
+
+			st, err := storage.New(ctx, location, getStorageConfigFromResourceData(d))
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("could not access backups storage: %s", err))
+			}
+
+			localArchive, err := ioutil.TempFile("", "kubeadm-backup")
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("could not create temporary file: %s", err))
+			}
+			_ = localArchive.Close()
+
+			name := getBackupFilename(host, time.Now())
+			kubeDir := strings.TrimPrefix(common.DefKubernetesConfigDir, "/")
+			return ssh.DoWithCleanup(
+				ssh.ActionList{
+					ssh.DoExecCommand(ssh.NewCommand("tar", "-czf", remoteArchive, "-C", "/", kubeDir)),
+					ssh.DoDownloadBinaryFile(remoteArchive, localArchive.Name()),
+					doPushBackup(st, localArchive.Name(), name),
+					doPruneBackups(st, host, retention),
+				},
+				ssh.ActionList{
+					ssh.DoTry(ssh.DoDeleteFile(remoteArchive)),
+					ssh.DoTry(ssh.DoDeleteLocalFile(localArchive.Name())),
+				})
+		}),
+	}
+}
+
+// doBackupBeforeProvisioning backups the kubernetes configuration when a
+// backup has been requested and this node is already a control plane node
+// (ie, when re-provisioning or upgrading it)
+func doBackupBeforeProvisioning(d *schema.ResourceData) ssh.Action {
+	if _, ok := d.GetOk("backup.0"); !ok {
+		return nil
+	}
+
+	return ssh.DoIfElse(
+		ssh.CheckFileExists(path.Join(common.DefPKIDir, "ca.key")),
+		doBackupKubernetesConfig(d),
+		ssh.DoMessageDebug("no control plane configuration found: skipping backup"))
+}
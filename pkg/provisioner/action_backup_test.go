@@ -0,0 +1,45 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetBackupsToPrune(t *testing.T) {
+	now := time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC)
+	files := []string{
+		"backups/" + getBackupFilename("master-0", now.Add(2*time.Hour)),
+		"backups/" + getBackupFilename("master-0", now),
+		"backups/" + getBackupFilename("master-1", now),
+		"backups/" + getBackupFilename("master-0", now.Add(1*time.Hour)),
+		"backups/something-else.txt",
+	}
+
+	res := getBackupsToPrune(files, "master-0", 2)
+	expected := []string{"backups/master-0-20190701-100000.tar.gz"}
+	if !reflect.DeepEqual(res, expected) {
+		t.Fatalf("Error: unexpected backups to prune: %v, expected %v", res, expected)
+	}
+
+	if res := getBackupsToPrune(files, "master-0", 0); len(res) != 0 {
+		t.Fatalf("Error: no backups should be pruned with no retention: %v", res)
+	}
+	if res := getBackupsToPrune(files, "master-1", 1); len(res) != 0 {
+		t.Fatalf("Error: no backups should be pruned for master-1: %v", res)
+	}
+}
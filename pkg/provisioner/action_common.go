@@ -21,14 +21,28 @@ import (
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
 
 	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
 	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
+// maximum number of certificates uploaded concurrently
+const certsUploadParallelism = 4
+
+// kubeadmTimeouts are the maximum times the long-running kubeadm commands can take:
+// some phases (ie, pulling images) can hang forever in broken networks
+var kubeadmTimeouts = map[string]time.Duration{
+	"init":    20 * time.Minute,
+	"join":    10 * time.Minute,
+	"reset":   5 * time.Minute,
+	"upgrade": 20 * time.Minute,
+}
+
 // expectedBinaries is the list of expected binaries to be present in the remote machine
 var expectedBinaries = []struct {
 	name        string
@@ -81,7 +95,9 @@ func doExecKubeadmWithConfig(d *schema.ResourceData, command string, cfg string,
 	allArgs := []string{}
 	switch command {
 	case "init", "join":
-		allArgs = append(allArgs, getKubeadmIgnoredChecksArg(d))
+		if ignored := getKubeadmIgnoredChecksArg(d); ignored != "" {
+			allArgs = append(allArgs, ignored)
+		}
 		allArgs = append(allArgs, fmt.Sprintf("--config=%s", cfg))
 	}
 
@@ -91,7 +107,11 @@ func doExecKubeadmWithConfig(d *schema.ResourceData, command string, cfg string,
 	}
 
 	allArgs = append(allArgs, args...)
-	return ssh.DoExec(fmt.Sprintf("%s %s %s", kubeadm_path, command, strings.Join(allArgs, " ")))
+	exec := ssh.DoExecCommand(ssh.NewCommand(kubeadm_path, command).WithArgs(allArgs...))
+	if timeout, ok := kubeadmTimeouts[command]; ok {
+		return ssh.DoWithTimeout(exec, timeout)
+	}
+	return exec
 }
 
 // doKubeadm is the common kubeadm call, both for the `init` as well as well as for the `join`.
@@ -100,9 +120,12 @@ func doKubeadm(d *schema.ResourceData, kubeadmConfigFilename string, command str
 	// otherwise, back up the config file
 	actions := ssh.ActionList{
 		ssh.DoMessageInfo("Starting kubeadm..."),
+		ssh.DoRefreshSession(),
+		doSetKubeletResolvConf(d, command),
 		ssh.DoWithException(
 			ssh.ActionList{
 				doUploadKubeadmConfig(d, command, kubeadmConfigFilename),
+				doMigrateKubeadmConfig(d, kubeadmConfigFilename),
 				doExecKubeadmWithConfig(d, command, kubeadmConfigFilename, args...),
 			},
 			ssh.ActionList{
@@ -116,16 +139,115 @@ func doKubeadm(d *schema.ResourceData, kubeadmConfigFilename string, command str
 				ssh.DoTry(ssh.DoDeleteFile(kubeadmConfigFilename)),
 			}),
 		ssh.DoTry(ssh.DoMoveFile(kubeadmConfigFilename, kubeadmConfigFilename+".bak")),
+		doWriteClusterID(d),
 	}
 	return actions
 }
 
+// doKubeadmDryRun runs a `kubeadm <command> --dry-run` when "dry_run" is enabled,
+// so errors in the configuration are detected before modifying the host (besides
+// installing kubeadm, that must be done before)
+func doKubeadmDryRun(d *schema.ResourceData, command string) ssh.Action {
+	if !d.Get("dry_run").(bool) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	return ssh.DoWithTempFilename(func(cfg string) ssh.Action {
+		return ssh.DoWithCleanup(
+			ssh.ActionList{
+				ssh.DoMessageInfo("Validating the configuration with 'kubeadm %s --dry-run'...", command),
+				doUploadKubeadmConfig(d, command, cfg),
+				ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+					// note: preflight checks are ignored, as they would fail in already provisioned nodes
+					dryRun := doExecKubeadmWithConfig(d, command, cfg, "--dry-run", "--ignore-preflight-errors=all")
+					// (the output is received line by line, without the line breaks)
+					res := ssh.DoSendingExecOutputToFunc(dryRun, func(s string) {
+						buf.WriteString(s)
+						buf.WriteByte('\n')
+					}).Apply(ctx)
+					if !ssh.IsError(res) {
+						return ssh.DoMessageInfo("... configuration validated")
+					}
+
+					output := buf.String()
+					if strings.Contains(output, "unknown flag: --dry-run") {
+						return ssh.DoMessageWarn("'kubeadm %s' does not support '--dry-run': configuration not validated", command)
+					}
+
+					// show the last lines of the output
+					lines := strings.Split(strings.TrimSpace(output), "\n")
+					if len(lines) > 20 {
+						lines = lines[len(lines)-20:]
+					}
+					return ssh.ActionList{
+						ssh.DoMessageWarn("'kubeadm %s --dry-run' failed:\n%s", command, strings.Join(lines, "\n")),
+						res,
+					}
+				}),
+			},
+			ssh.DoTry(ssh.DoDeleteFile(cfg)))
+	})
+}
+
+// checkNodePodCIDR checks the per-node pods CIDR (if provided) is inside the cluster pods CIDR
+func checkNodePodCIDR(d *schema.ResourceData) error {
+	podCIDR := getPodCIDRFromResourceData(d)
+	if podCIDR == "" {
+		return nil
+	}
+
+	clusterCIDR := common.DefPodCIDR
+	if clusterCIDROpt, ok := d.GetOk("config.cni_pod_cidr"); ok && len(clusterCIDROpt.(string)) > 0 {
+		clusterCIDR = clusterCIDROpt.(string)
+	}
+	contained, err := common.CIDRContains(clusterCIDR, podCIDR)
+	if err != nil {
+		return fmt.Errorf("could not check pods CIDR %q: %s", podCIDR, err)
+	}
+	if !contained {
+		return fmt.Errorf("pods CIDR %q is not inside the cluster pods CIDR %q", podCIDR, clusterCIDR)
+	}
+	return nil
+}
+
+// setNodeAdvertiseAddress sets the IP address this node advertises to the
+// cluster (if provided) in the kubelet arguments
+func setNodeAdvertiseAddress(d *schema.ResourceData, nodeRegistration *kubeadmapi.NodeRegistrationOptions) {
+	addr := getAdvertiseAddressFromResourceData(d)
+	if addr == "" {
+		return
+	}
+	if nodeRegistration.KubeletExtraArgs == nil {
+		nodeRegistration.KubeletExtraArgs = map[string]string{}
+	}
+	nodeRegistration.KubeletExtraArgs["node-ip"] = addr
+}
+
+// appendCertSANs appends some names to a list of certSANs, skipping duplicates
+func appendCertSANs(sans []string, names ...string) []string {
+	for _, name := range names {
+		found := false
+		for _, san := range sans {
+			if san == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			sans = append(sans, name)
+		}
+	}
+	return sans
+}
+
 // doMaybeResetWorker maybe "reset"s with kubeadm if /etc/kubernetes/kubeadm-* exists
 func doMaybeResetWorker(d *schema.ResourceData, kubeadmConfigFilename string) ssh.Action {
 	return ssh.DoIf(
 		ssh.CheckFileExists(kubeadmConfigFilename),
 		ssh.ActionList{
 			ssh.DoMessageWarn("previous kubeadm config file found: resetting node"),
+			doCheckClusterID(d),
 			doExecKubeadmWithConfig(d, "reset", "", "--force"),
 			ssh.DoDeleteFile(kubeadmConfigFilename),
 			ssh.DoFlushCache(),
@@ -151,10 +273,27 @@ func doUploadKubeadmConfig(d *schema.ResourceData, command string, kubeadmConfig
 				return ssh.ActionError(fmt.Sprintf("could not get a valid 'config' for join'ing: %s", err))
 			}
 		}
-		return ssh.DoUploadBytesToFile(configBytes, kubeadmConfigFilename)
+		return ssh.DoUploadBytesToFileWithBackupIfChanged(configBytes, kubeadmConfigFilename)
 	})
 }
 
+// doMigrateKubeadmConfig migrates the kubeadm configuration file to the API version
+// supported by the kubeadm installed in the node, so configurations generated by older
+// versions of the provider can still be used with newer kubeadm releases
+func doMigrateKubeadmConfig(d *schema.ResourceData, kubeadmConfigFilename string) ssh.Action {
+	migrated := kubeadmConfigFilename + ".migrated"
+	migrate := ssh.NewCommand(getKubeadmFromResourceData(d), "config", "migrate",
+		"--old-config="+kubeadmConfigFilename, "--new-config="+migrated)
+
+	return ssh.DoIfElse(
+		ssh.CheckExecCommand(migrate),
+		ssh.DoMoveFile(migrated, kubeadmConfigFilename),
+		ssh.ActionList{
+			ssh.DoMessageWarn("could not migrate the kubeadm configuration: using it as it is"),
+			ssh.DoTry(ssh.DoDeleteFile(migrated)),
+		})
+}
+
 // doUploadCerts upload the certificates from the serialized `d.config` to the remote machine
 // we only do this on the control plane machines
 func doUploadCerts(d *schema.ResourceData) ssh.Action {
@@ -171,15 +310,35 @@ func doUploadCerts(d *schema.ResourceData) ssh.Action {
 
 	actions := ssh.ActionList{
 		ssh.DoMessageInfo("Uploading certificates..."),
+		ssh.DoMkdirOnceWithMode(certsDir, ssh.DefPrivateDirMode),
+	}
+
+	// make sure all the directories for certificates are private
+	// (regardless of the umask in the remote machine)
+	dirs := map[string]struct{}{}
+	for baseName := range certsConfig.DistributionMap() {
+		if dir := path.Dir(path.Join(certsDir, baseName)); dir != certsDir {
+			dirs[dir] = struct{}{}
+		}
+	}
+	for dir := range dirs {
+		actions = append(actions, ssh.DoMkdirOnceWithMode(dir, ssh.DefPrivateDirMode))
 	}
+
+	// the certificates are independent, so they are uploaded concurrently
+	uploads := []ssh.Action{}
 	for baseName, cert := range certsConfig.DistributionMap() {
 		fullPath := path.Join(certsDir, baseName)
 		ssh.Debug("will upload certificate to %q", fullPath)
-		upload := ssh.DoUploadBytesToFile([]byte(*cert), fullPath)
-		actions = append(actions, upload)
+		// private keys must be only readable by root
+		mode := ssh.DefPublicFileMode
+		if strings.HasSuffix(baseName, ".key") {
+			mode = ssh.DefPrivateFileMode
+		}
+		uploads = append(uploads, ssh.DoUploadBytesToFileWithModeIfChanged([]byte(*cert), fullPath, mode))
 	}
 
-	return actions
+	return append(actions, ssh.DoParallel(certsUploadParallelism, uploads...))
 }
 
 // doLoadCloudProviderManager uploads the cloud-config to /etc/kubernetes/cloud.conf if necessary
@@ -263,6 +422,16 @@ func doUploadResolvConf(d *schema.ResourceData) ssh.Action {
 
 	return ssh.ActionList{
 		ssh.DoMessageInfo("Using user-provided upstream DNS resolvers: %+v", servers),
-		ssh.DoUploadBytesToFile(buf.Bytes(), common.DefResolvUpstreamConf),
+		ssh.DoUploadBytesToFileIfChanged(buf.Bytes(), common.DefResolvUpstreamConf),
 	}
 }
+
+// doInMaintenanceWindow runs a disruptive operation in the maintenance window (if any),
+// but only in nodes that have already joined the cluster: new nodes are not running
+// any workloads, so they can be provisioned at any time
+func doInMaintenanceWindow(description string, action ssh.Action) ssh.Action {
+	return ssh.DoIfElse(
+		ssh.CheckFileExists(common.DefKubeletConfigPath),
+		ssh.DoInMaintenanceWindow(description, action),
+		action)
+}
@@ -0,0 +1,106 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestNodeAddresses(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join":                "api.example.com",
+		"advertise_address":   "10.0.0.5",
+		"api_connect_address": "10.0.0.1:6443",
+		"cert_sans":           []interface{}{"IP=1.2.3.4", "node.example.com", "[fd00::1]"},
+	})
+
+	nodeRegistration := kubeadmapi.NodeRegistrationOptions{}
+	setNodeAdvertiseAddress(d, &nodeRegistration)
+	if nodeRegistration.KubeletExtraArgs["node-ip"] != "10.0.0.5" {
+		t.Fatalf("Error: unexpected node-ip: %q", nodeRegistration.KubeletExtraArgs["node-ip"])
+	}
+
+	sans := appendCertSANs([]string{"1.2.3.4"}, getCertSANsFromResourceData(d)...)
+	expected := []string{"1.2.3.4", "node.example.com", "fd00::1"}
+	if !reflect.DeepEqual(sans, expected) {
+		t.Fatalf("Error: unexpected certSANs: %v, expected: %v", sans, expected)
+	}
+
+	probes := getConnectivityProbes(d)
+	if len(probes) != 1 || probes[0].String() != "10.0.0.1:6443/tcp (API server)" {
+		t.Fatalf("Error: unexpected probes: %+v", probes)
+	}
+}
+
+func TestUploadJoinConfigWithAPIConnectAddress(t *testing.T) {
+	joinConfig := &kubeadmapi.JoinConfiguration{
+		Discovery: kubeadmapi.Discovery{
+			BootstrapToken: &kubeadmapi.BootstrapTokenDiscovery{
+				Token:                    "abcdef.0123456789abcdef",
+				UnsafeSkipCAVerification: true,
+			},
+		},
+	}
+	joinConfigBytes, err := common.JoinConfigToYAML(joinConfig)
+	if err != nil {
+		t.Fatalf("Error: could not serialize the join configuration: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join":                "api.example.com",
+		"api_connect_address": "10.0.0.1:6443",
+		"config": map[string]interface{}{
+			"join": common.ToTerraformSafeString(joinConfigBytes),
+		},
+	})
+
+	ctx, uploads := ssh.NewTestingContextForUploads([]string{})
+	if res := (ssh.ActionList{doUploadKubeadmConfig(d, "join", common.DefKubeadmJoinConfPath)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: when uploading the join configuration: %s", res)
+	}
+	// (the configuration is uploaded to a temporary file and then moved)
+	if len(*uploads) != 1 {
+		t.Fatalf("Error: unexpected uploads: %+v", *uploads)
+	}
+	for _, uploaded := range *uploads {
+		if !strings.Contains(uploaded, "apiServerEndpoint: 10.0.0.1:6443") {
+			t.Fatalf("Error: the API connect address is not in the uploaded configuration:\n%s", uploaded)
+		}
+	}
+}
+
+func TestNodeAddressesDefaults(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join": "api.example.com",
+	})
+
+	nodeRegistration := kubeadmapi.NodeRegistrationOptions{}
+	setNodeAdvertiseAddress(d, &nodeRegistration)
+	if _, ok := nodeRegistration.KubeletExtraArgs["node-ip"]; ok {
+		t.Fatalf("Error: node-ip should not be set")
+	}
+
+	if addr := getAPIConnectAddressFromResourceData(d); addr != "api.example.com" {
+		t.Fatalf("Error: unexpected API connect address: %q", addr)
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// conflictingInstall is some other Kubernetes distribution that conflicts with kubeadm
+// (ie, it runs its own kubelet, container runtime or CNI)
+type conflictingInstall struct {
+	name string
+
+	// paths (files, directories and systemd units) left by this distribution
+	paths []string
+
+	// commands for uninstalling this distribution (when available)
+	uninstall []string
+
+	// paths that, when found, mean that this is not a conflicting installation
+	// (ie, the files left by a previous run of this provisioner)
+	unless []string
+}
+
+var conflictingInstalls = []conflictingInstall{
+	{
+		name: "k3s",
+		paths: []string{
+			"/etc/systemd/system/k3s.service",
+			"/etc/systemd/system/k3s-agent.service",
+			"/usr/local/bin/k3s",
+			"/etc/rancher/k3s",
+			"/var/lib/rancher/k3s",
+		},
+		uninstall: []string{
+			"/usr/local/bin/k3s-uninstall.sh",
+			"/usr/local/bin/k3s-agent-uninstall.sh",
+		},
+	},
+	{
+		name: "rke2",
+		paths: []string{
+			"/usr/local/lib/systemd/system/rke2-server.service",
+			"/usr/local/lib/systemd/system/rke2-agent.service",
+			"/etc/rancher/rke2",
+			"/var/lib/rancher/rke2",
+		},
+		uninstall: []string{
+			"/usr/local/bin/rke2-uninstall.sh",
+			"/usr/bin/rke2-uninstall.sh",
+		},
+	},
+	{
+		name: "rancher agent",
+		paths: []string{
+			"/etc/systemd/system/rancher-system-agent.service",
+			"/etc/rancher/agent",
+			"/var/lib/rancher/agent",
+		},
+		uninstall: []string{
+			"/usr/local/bin/rancher-system-agent-uninstall.sh",
+		},
+	},
+	{
+		name: "microk8s",
+		paths: []string{
+			"/snap/microk8s",
+			"/var/snap/microk8s",
+		},
+		uninstall: []string{
+			"snap remove --purge microk8s",
+		},
+	},
+	{
+		// a kubeadm installation not done by this provisioner (some of these directories
+		// are created empty by the kubelet package, so we look for the files kubeadm leaves)
+		name: "old kubeadm",
+		paths: []string{
+			"/etc/kubernetes/manifests/kube-apiserver.yaml",
+			"/etc/kubernetes/kubelet.conf",
+			"/var/lib/etcd/member",
+			"/var/lib/kubelet/config.yaml",
+		},
+		uninstall: []string{
+			common.DefKubeadmPath + " reset --force",
+		},
+		unless: []string{
+			common.DefClusterIDPath,
+			common.DefKubeadmInitConfPath,
+			common.DefKubeadmJoinConfPath,
+		},
+	},
+}
+
+// getConflictsDetectionCmd returns a command that prints the paths found from
+// the conflicting installations
+func getConflictsDetectionCmd() ssh.Command {
+	paths := []string{}
+	for _, install := range conflictingInstalls {
+		paths = append(paths, install.paths...)
+		paths = append(paths, install.unless...)
+	}
+	return ssh.NewShellCommand(`for p in "$@" ; do [ -e "$p" ] && echo "$p" ; done ; true`, paths...)
+}
+
+// parseConflicts returns the paths found for each conflicting installation, from
+// the output of the detection command (keeping the order in `conflictingInstalls`)
+func parseConflicts(output string) ([]conflictingInstall, map[string][]string) {
+	found := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			found[line] = true
+		}
+	}
+
+	installs := []conflictingInstall{}
+	res := map[string][]string{}
+	for _, install := range conflictingInstalls {
+		if anyFound(found, install.unless) {
+			continue
+		}
+		for _, p := range install.paths {
+			if found[p] {
+				res[install.name] = append(res[install.name], p)
+			}
+		}
+		if len(res[install.name]) > 0 {
+			installs = append(installs, install)
+		}
+	}
+	return installs, res
+}
+
+// anyFound returns true if any of the paths has been found
+func anyFound(found map[string]bool, paths []string) bool {
+	for _, p := range paths {
+		if found[p] {
+			return true
+		}
+	}
+	return false
+}
+
+// getConflictsReport returns a human-readable report with the conflicting installations found
+func getConflictsReport(installs []conflictingInstall, found map[string][]string) string {
+	lines := []string{}
+	for _, install := range installs {
+		lines = append(lines, fmt.Sprintf("%s (%s)", install.name, strings.Join(found[install.name], ", ")))
+	}
+	return strings.Join(lines, "; ")
+}
+
+// doRemoveConflictingInstall removes a conflicting installation: it runs the uninstall
+// scripts (if present), disables the services and removes the paths found
+func doRemoveConflictingInstall(install conflictingInstall, paths []string) ssh.Action {
+	actions := ssh.ActionList{
+		ssh.DoMessageWarn("Removing %s from this node", install.name),
+	}
+	for _, cmd := range install.uninstall {
+		bin := strings.Fields(cmd)[0]
+		if path.IsAbs(bin) {
+			actions = append(actions, ssh.DoIf(ssh.CheckFileExists(bin), ssh.DoTry(ssh.DoExec(cmd)))) // rawcmd: from the conflictingInstalls table
+		} else {
+			actions = append(actions, ssh.DoIf(ssh.CheckBinaryExists(bin), ssh.DoTry(ssh.DoExec(cmd)))) // rawcmd: from the conflictingInstalls table
+		}
+	}
+
+	for _, p := range paths {
+		if strings.HasSuffix(p, ".service") {
+			actions = append(actions, ssh.DoTry(ssh.DoDisableService(path.Base(p))))
+		}
+	}
+	return append(actions,
+		ssh.DoExecCommand(ssh.NewCommand("rm", "-rf").WithArgs(paths...)),
+		ssh.DoTry(ssh.DoExec("systemctl --no-pager daemon-reload")))
+}
+
+// doCheckConflictingInstalls detects remnants of other Kubernetes distributions
+// (k3s, rke2, microk8s, rancher agents, old kubeadm...) in the node, failing with a report
+// of what has been found. When "preflight.force_clean" is enabled, these
+// installations are removed instead.
+func doCheckConflictingInstalls(d *schema.ResourceData) ssh.Action {
+	forceClean := d.Get("preflight.0.force_clean").(bool)
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		// the output is received line by line, without the line breaks
+		ssh.DoSendingExecOutputToFunc(ssh.DoExecCommand(getConflictsDetectionCmd()), func(s string) {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			installs, found := parseConflicts(buf.String())
+			if len(installs) == 0 {
+				ssh.Debug("no conflicting Kubernetes installations found")
+				return nil
+			}
+
+			report := getConflictsReport(installs, found)
+			if !forceClean {
+				return ssh.ActionError(fmt.Sprintf("conflicting Kubernetes installations found in this node: %s. "+
+					"Remove them or set 'force_clean = true' in the 'preflight' block.", report))
+			}
+
+			actions := ssh.ActionList{
+				ssh.DoMessageWarn("Conflicting Kubernetes installations found in this node: %s", report),
+			}
+			for _, install := range installs {
+				actions = append(actions, doRemoveConflictingInstall(install, found[install.name]))
+			}
+			return append(actions, ssh.DoFlushCache())
+		}),
+	}
+}
@@ -25,6 +25,7 @@ import (
 func doRemoveNode(d *schema.ResourceData) ssh.Action {
 	return ssh.ActionList{
 		ssh.DoMessageInfo("Preparing to remove node from cluster..."),
+		doCheckClusterID(d),
 		ssh.DoTry(doDrainKubernetesNode(d)),
 		ssh.DoTry(doRemoveIfMember(d)),
 	}
@@ -0,0 +1,79 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"path"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	driftWatchdogTimer = "kubeadm-config-watchdog.timer"
+)
+
+// getDriftWatchedFiles returns the kubelet and container runtime configuration
+// files that are compared with the baseline by the drift watchdog
+func getDriftWatchedFiles(d *schema.ResourceData) []string {
+	return []string{
+		getSysconfigPathFromResourceData(d),
+		getDropinPathFromResourceData(d),
+		"/var/lib/kubelet/config.yaml",
+		"/var/lib/kubelet/kubeadm-flags.env",
+		"/etc/containerd/config.toml",
+	}
+}
+
+// doInstallDriftWatchdog records the current configuration as the baseline
+// and installs the watchdog that reports (and remediates) any drift from it
+func doInstallDriftWatchdog(d *schema.ResourceData) ssh.Action {
+	if enabled, _ := common.GetProvisionerConfig(d)["drift_watchdog"].(string); enabled != "true" {
+		return nil
+	}
+
+	baselineFiles := path.Join(common.DefDriftBaselineDir, "files")
+
+	node := ssh.KubeNode{}
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Recording the configuration baseline for the drift watchdog..."),
+		DoGetNodename(d, &node),
+		ssh.DoExecCommand(ssh.NewCommand("rm", "-rf", baselineFiles)),
+	}
+	for _, f := range getDriftWatchedFiles(d) {
+		dst := path.Join(baselineFiles, f)
+		actions = append(actions,
+			ssh.DoExecCommand(ssh.NewShellCommand(`[ ! -f "$1" ] || { mkdir -p "$2" && cp -a "$1" "$3" ; }`, f, path.Dir(dst), dst)))
+	}
+
+	return append(actions,
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return ssh.ActionError("could not find the Kubernetes nodename: the drift watchdog cannot be installed")
+			}
+			return ssh.DoUploadBytesToFile([]byte(node.Nodename), path.Join(common.DefDriftBaselineDir, "nodename"))
+		}),
+		ssh.DoMessageInfo("Installing the drift watchdog..."),
+		ssh.DoUploadBytesToFileIfChanged([]byte(assets.ConfigWatchdogScriptCode), common.DefDriftWatchdogPath),
+		ssh.DoExecCommand(ssh.NewCommand("chmod", "755", common.DefDriftWatchdogPath)),
+		ssh.DoUploadBytesToFileIfChanged([]byte(assets.ConfigWatchdogServiceCode), common.DefDriftWatchdogServicePath),
+		ssh.DoUploadBytesToFileIfChanged([]byte(assets.ConfigWatchdogTimerCode), common.DefDriftWatchdogTimerPath),
+		ssh.DoExec("systemctl --no-pager daemon-reload"),
+		ssh.DoExecCommand(ssh.NewCommand("systemctl", "--no-pager", "enable", "--now", driftWatchdogTimer)))
+}
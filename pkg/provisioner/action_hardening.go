@@ -0,0 +1,137 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// command for getting the TCP ports listening in this node
+const listeningPortsCmd = "ss -H -t -l -n 2>/dev/null || netstat -t -l -n"
+
+// parseListeningPorts parses the output of "ss -tln" or "netstat -tln", returning
+// the (sorted) TCP ports listening in non-loopback addresses
+func parseListeningPorts(output string) []int {
+	seen := map[int]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		// ie, "LISTEN  0  128  0.0.0.0:22  0.0.0.0:*" or "tcp  0  0  0.0.0.0:22  0.0.0.0:*  LISTEN"
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[0] != "LISTEN" && !strings.HasPrefix(fields[0], "tcp")) {
+			continue
+		}
+
+		local := fields[3]
+		i := strings.LastIndex(local, ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(local[i+1:])
+		if err != nil {
+			continue
+		}
+
+		// ie, "[::1]", "127.0.0.53%lo" or "::1"
+		host := strings.TrimSuffix(strings.TrimPrefix(local[:i], "["), "]")
+		host = strings.Split(host, "%")[0]
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			continue
+		}
+		seen[port] = true
+	}
+
+	res := []int{}
+	for port := range seen {
+		res = append(res, port)
+	}
+	sort.Ints(res)
+	return res
+}
+
+// getAllowedPortsFromResourceData returns the ports allowed in control plane nodes
+// (or nil when no verification must be done)
+func getAllowedPortsFromResourceData(d *schema.ResourceData) ([]common.PortsRange, error) {
+	allowedStr, _ := common.GetProvisionerConfig(d)["allowed_ports"].(string)
+	if allowedStr == "" {
+		return nil, nil
+	}
+
+	res := []common.PortsRange{}
+	for _, s := range strings.Split(allowedStr, ",") {
+		r, err := common.ParsePortsRange(s)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, r)
+	}
+	return res, nil
+}
+
+// doVerifyListeningPorts verifies that only the expected ports are listening in
+// this (control plane) node, reporting the unexpected ports in a node annotation
+func doVerifyListeningPorts(d *schema.ResourceData) ssh.Action {
+	allowed, err := getAllowedPortsFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(fmt.Sprintf("invalid allowed ports: %s", err))
+	}
+	if allowed == nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Verifying the ports listening in this node..."),
+		// the output is received line by line, without the line breaks
+		ssh.DoSendingExecOutputToFunc(ssh.DoExec(listeningPortsCmd), func(s string) {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}),
+		DoGetNodename(d, &node),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			listening := parseListeningPorts(buf.String())
+			ssh.Debug("ports listening in this node: %v", listening)
+
+			unexpected := common.GetUnexpectedPorts(listening, allowed)
+			ports := []string{}
+			for _, port := range unexpected {
+				ports = append(ports, strconv.Itoa(port))
+			}
+			value := strings.Join(ports, ",")
+
+			report := ssh.ActionList{}
+			if len(unexpected) == 0 {
+				report = append(report, ssh.DoMessageInfo("No unexpected ports listening in this node"))
+			} else {
+				report = append(report, ssh.DoMessageWarn("Unexpected ports listening in this node: %s", value))
+			}
+			if node.IsEmpty() {
+				return append(report, ssh.DoMessageWarn("could not find the Kubernetes nodename: ports will not be reported in the node"))
+			}
+			return append(report, doRemoteKubectl(d, "annotate", "--overwrite", "node", node.Nodename,
+				common.DefAnnotationUnexpectedPortsKey+"="+value))
+		}),
+	}
+}
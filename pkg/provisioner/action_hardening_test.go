@@ -0,0 +1,45 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseListeningPorts(t *testing.T) {
+	ss := `LISTEN 0      128          0.0.0.0:22         0.0.0.0:*
+LISTEN 0      4096   127.0.0.53%lo:53         0.0.0.0:*
+LISTEN 0      4096       127.0.0.1:10248      0.0.0.0:*
+LISTEN 0      4096               *:6443             *:*
+LISTEN 0      4096           [::1]:2381          [::]:*
+LISTEN 0      128             [::]:22            [::]:*
+LISTEN 0      4096               *:8080             *:*
+`
+	if ports := parseListeningPorts(ss); !reflect.DeepEqual(ports, []int{22, 6443, 8080}) {
+		t.Fatalf("Error: unexpected ports in ss output: %v", ports)
+	}
+
+	netstat := `Active Internet connections (only servers)
+Proto Recv-Q Send-Q Local Address           Foreign Address         State
+tcp        0      0 0.0.0.0:22              0.0.0.0:*               LISTEN
+tcp        0      0 127.0.0.1:10248         0.0.0.0:*               LISTEN
+tcp6       0      0 :::10250                :::*                    LISTEN
+tcp6       0      0 ::1:2381                :::*                    LISTEN
+`
+	if ports := parseListeningPorts(netstat); !reflect.DeepEqual(ports, []int{22, 10250}) {
+		t.Fatalf("Error: unexpected ports in netstat output: %v", ports)
+	}
+}
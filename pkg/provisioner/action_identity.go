@@ -0,0 +1,71 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// doWriteClusterID writes the identity of the cluster in the node
+// (so we can verify it before any destructive operation)
+func doWriteClusterID(d *schema.ResourceData) ssh.Action {
+	id := getClusterIDFromResourceData(d)
+	if id == "" {
+		ssh.Debug("no cluster ID available: identity file will not be written")
+		return nil
+	}
+	return ssh.ActionList{
+		ssh.DoMessageDebug(fmt.Sprintf("Writing cluster identity %s in %s", id, common.DefClusterIDPath)),
+		ssh.DoUploadBytesToFile([]byte(id+"\n"), common.DefClusterIDPath),
+	}
+}
+
+// doCheckClusterID verifies that the node belongs to the cluster we are managing,
+// failing otherwise. Nodes without an identity file (ie, provisioned with an older
+// version) are considered valid.
+func doCheckClusterID(d *schema.ResourceData) ssh.Action {
+	expected := getClusterIDFromResourceData(d)
+	if expected == "" {
+		ssh.Debug("no cluster ID available: identity will not be checked")
+		return nil
+	}
+
+	return ssh.DoIf(
+		ssh.CheckFileExists(common.DefClusterIDPath),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			var buf bytes.Buffer
+			cmd := ssh.NewCommand("cat", common.DefClusterIDPath)
+			if res := ssh.DoSendingExecOutputToWriter(ssh.DoExecCommand(cmd), &buf).Apply(ctx); ssh.IsError(res) {
+				return res
+			}
+
+			current := strings.TrimSpace(buf.String())
+			if current == "" || current == expected {
+				ssh.Debug("cluster identity verified: %s", expected)
+				return nil
+			}
+			return ssh.ActionError(fmt.Sprintf("this node belongs to a different cluster (identity %s, but %s was expected): "+
+				"refusing to continue. Remove %s in the node if you are sure this is the right node.",
+				current, expected, common.DefClusterIDPath))
+		}))
+}
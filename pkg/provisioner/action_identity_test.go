@@ -0,0 +1,50 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+func TestDoCheckClusterID(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"ca_crt": "some-ca-certificate",
+		},
+	})
+	id := getClusterIDFromResourceData(d)
+
+	cases := []struct {
+		name      string
+		responses []string
+		fail      bool
+	}{
+		{"same cluster", []string{"CONDITION_SUCCEEDED", id + "\n"}, false},
+		{"no identity file", []string{"CONDITION_FAILED"}, false},
+		{"different cluster", []string{"CONDITION_SUCCEEDED", "0123456789abcdef\n"}, true},
+	}
+
+	for _, c := range cases {
+		ctx := ssh.NewTestingContextWithResponses(c.responses)
+		res := doCheckClusterID(d).Apply(ctx)
+		if ssh.IsError(res) != c.fail {
+			t.Fatalf("Error: %s: unexpected result: %v", c.name, res)
+		}
+	}
+}
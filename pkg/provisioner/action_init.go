@@ -24,6 +24,17 @@ import (
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
+// doKubeadmInitDryRun validates the configuration with a `kubeadm init --dry-run`
+// (when "dry_run" is enabled), unless this node is already running a live cluster
+func doKubeadmInitDryRun(d *schema.ResourceData) ssh.Action {
+	if !d.Get("dry_run").(bool) {
+		return nil
+	}
+	return ssh.DoIf(
+		ssh.CheckNot(checkAdminConfAlive(d)),
+		doKubeadmDryRun(d, "init"))
+}
+
 // doKubeadmInit runs the `kubeadm init`
 func doKubeadmInit(d *schema.ResourceData) ssh.Action {
 	extraArgs := []string{"--skip-token-print"}
@@ -37,6 +48,18 @@ func doKubeadmInit(d *schema.ResourceData) ssh.Action {
 	// ... update the nodename
 	initConfig.NodeRegistration.Name = getNodenameFromResourceData(d)
 
+	// ... the pods CIDR for this node can only be set before it registers, when the cluster exists
+	if getPodCIDRFromResourceData(d) != "" {
+		return ssh.ActionError("'pod_cidr' cannot be used in the seeder: the controller manager allocates its pods CIDR while the cluster is initialized")
+	}
+
+	// ... the address advertised by this node, and the extra names in the certificates
+	setNodeAdvertiseAddress(d, &initConfig.NodeRegistration)
+	if addr := getAdvertiseAddressFromResourceData(d); addr != "" {
+		initConfig.LocalAPIEndpoint.AdvertiseAddress = addr
+	}
+	initConfig.APIServer.CertSANs = appendCertSANs(initConfig.APIServer.CertSANs, getCertSANsFromResourceData(d)...)
+
 	// ... and update the `config.join` section
 	if err := common.InitConfigToResourceData(d, initConfig); err != nil {
 		return ssh.ActionError(err.Error())
@@ -55,7 +78,7 @@ func doKubeadmInit(d *schema.ResourceData) ssh.Action {
 				ssh.DoMessageInfo("There is a 'admin.conf' in this master pointing to a live cluster: skipping any setup"),
 			},
 			ssh.ActionList{
-				ssh.DoRetry(
+				doWithLocalBootstrap(d, initConfig.ControlPlaneEndpoint, int(initConfig.LocalAPIEndpoint.BindPort), ssh.DoRetry(
 					ssh.Retry{Times: 3, Interval: 15 * time.Second},
 					ssh.ActionList{
 						doMaybeResetMaster(d, common.DefKubeadmInitConfPath),
@@ -63,15 +86,21 @@ func doKubeadmInit(d *schema.ResourceData) ssh.Action {
 						ssh.DoMessageInfo("Initializing the cluster with 'kubadm init'..."),
 						doKubeadm(d, common.DefKubeadmInitConfPath, "init", extraArgs...),
 					},
-				),
+				)),
 			},
 		),
 		// we always download the kubeconfig and try to do a "kubeactl apply -f" of manifests
 		doDownloadKubeconfig(d),
 		doLoadCNI(d),
+		doLoadBootstrapManifest(d),
+		doCreateImagePullSecrets(d),
+		doCreateVaultAuth(d),
 		doLoadDashboard(d),
+		doLoadDNSAutoscaler(d),
 		doLoadHelm(d),
 		doLoadCloudProviderManager(d),
+		doLoadIngress(d),
+		doLoadCertManager(d),
 		doLoadExtraManifests(d),
 	}
 	return actions
@@ -90,6 +119,7 @@ func doMaybeResetMaster(d *schema.ResourceData, kubeadmConfigFilename string) ss
 		),
 		ssh.ActionList{
 			ssh.DoMessageWarn("previous kubeadm config file found: resetting node"),
+			doCheckClusterID(d),
 			doExecKubeadmWithConfig(d, "reset", "", "--force"),
 			ssh.DoDeleteFile(kubeadmConfigFilename),
 			ssh.DoFlushCache(),
@@ -20,6 +20,7 @@ import (
 
 	"github.com/hashicorp/terraform/helper/schema"
 
+	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
 	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
@@ -46,6 +47,46 @@ func doLoadDashboard(d *schema.ResourceData) ssh.Action {
 	}
 }
 
+// doLoadDNSAutoscaler loads the cluster-proportional-autoscaler for CoreDNS (if enabled)
+func doLoadDNSAutoscaler(d *schema.ResourceData) ssh.Action {
+	opt, ok := d.GetOk("config.dns_autoscaler_enabled")
+	if !ok {
+		return nil
+	}
+	enabled, err := strconv.ParseBool(opt.(string))
+	if err != nil {
+		return ssh.ActionError("could not parse dns_autoscaler_enabled in provisioner")
+	}
+	if !enabled {
+		return nil
+	}
+
+	manifest := ssh.Manifest{Inline: assets.DNSAutoscalerManifestCode}
+	if err := manifest.ReplaceConfig(common.GetProvisionerConfig(d)); err != nil {
+		return ssh.ActionError(fmt.Sprintf("could not replace variables in DNS autoscaler manifest: %s", err))
+	}
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Loading the DNS autoscaler"),
+		doRemoteKubectlApply(d, []ssh.Manifest{manifest}),
+	}
+}
+
+// doLoadBootstrapManifest creates the namespaces and bindings in the "bootstrap" block
+func doLoadBootstrapManifest(d *schema.ResourceData) ssh.Action {
+	manifestStr, _ := common.GetProvisionerConfig(d)["bootstrap_manifest"].(string)
+	if manifestStr == "" {
+		return nil
+	}
+	manifest, err := common.FromTerraformSafeString(manifestStr)
+	if err != nil {
+		return ssh.ActionError(fmt.Sprintf("could not parse the bootstrap manifest: %s", err))
+	}
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Creating namespaces and bindings"),
+		doRemoteKubectlApply(d, []ssh.Manifest{{Inline: string(manifest)}}),
+	}
+}
+
 // doLoadExtraManifests loads some extra manifests
 func doLoadExtraManifests(d *schema.ResourceData) ssh.Action {
 	manifestsOpt, ok := d.GetOk("manifests")
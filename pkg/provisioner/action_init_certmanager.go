@@ -0,0 +1,95 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	certManagerNamespace = "cert-manager"
+
+	// time we wait for the nodes network, the CRDs and the webhook to be ready
+	certManagerReadyTimeout = "300s"
+
+	// the webhook can take some time to serve requests after being ready
+	certManagerIssuerRetryTimes    = 6
+	certManagerIssuerRetryInterval = 10 * time.Second
+)
+
+// deployments in cert-manager (they must run in the masters too, as there
+// could be no workers when bootstrapping the cluster)
+var certManagerDeployments = []string{"cert-manager", "cert-manager-cainjector", "cert-manager-webhook"}
+
+// getCertManagerIssuer returns the manifest for the ClusterIssuer (if any)
+func getCertManagerIssuer(d *schema.ResourceData) (ssh.Manifest, error) {
+	config := common.GetProvisionerConfig(d)
+	if issuerType, _ := config["cert_manager_issuer_type"].(string); issuerType == "" {
+		return ssh.Manifest{}, nil
+	}
+	manifest := ssh.Manifest{Inline: assets.CertManagerIssuerCode}
+	if err := manifest.ReplaceConfig(config); err != nil {
+		return ssh.Manifest{}, fmt.Errorf("could not replace variables in the cert-manager issuer manifest: %s", err)
+	}
+	return manifest, nil
+}
+
+// doLoadCertManager loads cert-manager (if enabled) and creates a ClusterIssuer
+func doLoadCertManager(d *schema.ResourceData) ssh.Action {
+	version, _ := common.GetProvisionerConfig(d)["cert_manager_version"].(string)
+	if version == "" {
+		return nil
+	}
+
+	issuer, err := getCertManagerIssuer(d)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Waiting for the nodes network to be ready..."),
+		doRemoteKubectl(d, "wait", "--for=condition=Ready", "nodes", "--all", "--timeout="+certManagerReadyTimeout),
+		ssh.DoMessageInfo("Loading cert-manager %s", version),
+		doRemoteKubectlApply(d, []ssh.Manifest{{URL: fmt.Sprintf(common.DefCertManagerManifest, version)}}),
+	}
+	for _, deployment := range certManagerDeployments {
+		actions = append(actions,
+			doRemoteKubectl(d, "patch", "deployment", deployment, "-n", certManagerNamespace, "--type=merge",
+				`--patch={"spec":{"template":{"spec":{"tolerations":[{"key":"node-role.kubernetes.io/master","effect":"NoSchedule"}]}}}}`))
+	}
+
+	if issuer.IsEmpty() {
+		return actions
+	}
+
+	// the ClusterIssuer can only be created once the CRDs are established
+	// and the webhook is up and running
+	return append(actions,
+		ssh.DoMessageInfo("Waiting for cert-manager to be ready..."),
+		doRemoteKubectl(d, "wait", "--for=condition=Established", "crd/clusterissuers.cert-manager.io", "--timeout="+certManagerReadyTimeout),
+		doRemoteKubectl(d, "rollout", "status", "deployment/cert-manager-webhook", "-n", certManagerNamespace, "--timeout="+certManagerReadyTimeout),
+		ssh.DoMessageInfo("Creating the cert-manager ClusterIssuer"),
+		ssh.DoRetry(
+			ssh.Retry{Times: certManagerIssuerRetryTimes, Interval: certManagerIssuerRetryInterval},
+			doRemoteKubectlApply(d, []ssh.Manifest{issuer})),
+	)
+}
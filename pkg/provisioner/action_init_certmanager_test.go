@@ -0,0 +1,68 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestGetCertManagerIssuer(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"cert_manager_version":            "v0.11.0",
+			"cert_manager_issuer_type":        "acme",
+			"cert_manager_issuer_name":        "letsencrypt",
+			"cert_manager_acme_email":         "admin@example.com",
+			"cert_manager_acme_server":        "https://acme.example.com/directory",
+			"cert_manager_acme_ingress_class": "contour",
+		},
+	})
+	issuer, err := getCertManagerIssuer(d)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	for _, expected := range []string{"name: letsencrypt", "email: admin@example.com", "class: contour", "name: letsencrypt-account-key"} {
+		if !strings.Contains(issuer.Inline, expected) {
+			t.Fatalf("Error: %q not found in issuer:\n%s", expected, issuer.Inline)
+		}
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"cert_manager_version":     "v0.11.0",
+			"cert_manager_issuer_type": "selfsigned",
+			"cert_manager_issuer_name": "default",
+		},
+	})
+	issuer, err = getCertManagerIssuer(d)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.Contains(issuer.Inline, "selfSigned: {}") || strings.Contains(issuer.Inline, "acme:") {
+		t.Fatalf("Error: unexpected self-signed issuer:\n%s", issuer.Inline)
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"cert_manager_version": "v0.11.0",
+		},
+	})
+	if issuer, err := getCertManagerIssuer(d); err != nil || !issuer.IsEmpty() {
+		t.Fatalf("Error: unexpected issuer: %v, %+v", err, issuer)
+	}
+}
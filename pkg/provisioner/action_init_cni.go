@@ -15,7 +15,9 @@
 package provisioner
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -27,6 +29,7 @@ import (
 // doLoadCNI loads the CNI driver
 func doLoadCNI(d *schema.ResourceData) ssh.Action {
 	manifest := ssh.Manifest{}
+	cniPlugin := ""
 	var message ssh.Action
 
 	if cniPluginManifestOpt, ok := d.GetOk("config.cni_plugin_manifest"); ok {
@@ -40,7 +43,7 @@ func doLoadCNI(d *schema.ResourceData) ssh.Action {
 		}
 	} else {
 		if cniPluginOpt, ok := d.GetOk("config.cni_plugin"); ok {
-			cniPlugin := strings.TrimSpace(strings.ToLower(cniPluginOpt.(string)))
+			cniPlugin = strings.TrimSpace(strings.ToLower(cniPluginOpt.(string)))
 			if len(cniPlugin) > 0 {
 				ssh.Debug("verifying CNI plugin: %s", cniPlugin)
 				if m, ok := common.CNIPluginsManifestsTemplates[cniPlugin]; ok {
@@ -58,13 +61,47 @@ func doLoadCNI(d *schema.ResourceData) ssh.Action {
 		return ssh.DoMessageWarn("no CNI driver is going to be loaded")
 	}
 
-	err := manifest.ReplaceConfig(common.GetProvisionerConfig(d))
-	if err != nil {
-		return ssh.ActionError(fmt.Sprintf("could not replace variables in manifest: %s", err))
+	// copy the config, as we could need to add some values (ie, the MTU)
+	config := map[string]interface{}{}
+	for k, v := range common.GetProvisionerConfig(d) {
+		config[k] = v
+	}
+
+	applyManifest := ssh.ActionFunc(func(context.Context) ssh.Action {
+		if err := manifest.ReplaceConfig(config); err != nil {
+			return ssh.ActionError(fmt.Sprintf("could not replace variables in manifest: %s", err))
+		}
+		return doRemoteKubectlApply(d, []ssh.Manifest{manifest})
+	})
+
+	// detect the MTU when it has not been provided (only for the built-in plugins)
+	if mtu, _ := config["cni_mtu"].(string); mtu == "" && cniPlugin != "" {
+		ifaceMTU := 0
+		detectMTU := ssh.ActionList{
+			ssh.DoGetDefaultRouteMTU(&ifaceMTU),
+			ssh.ActionFunc(func(context.Context) ssh.Action {
+				if ifaceMTU <= 0 {
+					return ssh.DoMessageWarn("could not detect the MTU: using the CNI plugin defaults")
+				}
+				backend := ""
+				if cniPlugin == "flannel" {
+					backend, _ = config["flannel_backend"].(string)
+				}
+				cniMTU := common.GetCNIMTU(cniPlugin, backend, ifaceMTU)
+				config["cni_mtu"] = strconv.Itoa(cniMTU)
+				return ssh.DoMessageInfo("Using MTU %d for CNI plugin %q (interface MTU: %d)", cniMTU, cniPlugin, ifaceMTU)
+			}),
+		}
+
+		return ssh.ActionList{
+			message,
+			ssh.DoTry(detectMTU),
+			applyManifest,
+		}
 	}
 
 	return ssh.ActionList{
 		message,
-		doRemoteKubectlApply(d, []ssh.Manifest{manifest}),
+		applyManifest,
 	}
 }
@@ -23,13 +23,13 @@ import (
 // doPrepareCRI preparse the CRI in the target node
 func doPrepareCRI() ssh.Action {
 	return ssh.ActionList{
-		ssh.DoUploadBytesToFile([]byte(assets.CNIDefConfCode), common.DefCniLookbackConfPath),
+		ssh.DoUploadBytesToFileIfChanged([]byte(assets.CNIDefConfCode), common.DefCniLookbackConfPath),
 		// we must reload the containers runtime engine after changing the CNI configuration
 		ssh.DoIf(
 			ssh.CheckServiceExists("crio.service"),
-			ssh.DoRestartService("crio.service")),
+			doInMaintenanceWindow("restarting crio", ssh.DoRestartService("crio.service"))),
 		ssh.DoIf(
 			ssh.CheckServiceExists("docker.service"),
-			ssh.DoRestartService("docker.service")),
+			doInMaintenanceWindow("restarting docker", ssh.DoRestartService("docker.service"))),
 	}
 }
@@ -0,0 +1,223 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	// time we wait for the ingress controller to be ready
+	ingressReadyTimeout = "300s"
+
+	// time we wait for a load balancer to get an address
+	ingressLoadBalancerRetryTimes    = 10
+	ingressLoadBalancerRetryInterval = 15 * time.Second
+
+	// key in the cluster cache for the ingress controller readiness
+	clusterCacheIngressReady = "ingress-ready"
+)
+
+// ingressController describes how an ingress controller is deployed and exposed
+type ingressController struct {
+	manifest  string
+	namespace string
+
+	// workload we wait for with "kubectl rollout status"
+	workload string
+
+	// service and pods selector used for obtaining the endpoint
+	service  string
+	selector string
+
+	// extra manifests for each mode
+	manifests map[string][]string
+
+	// "kubectl" arguments for adapting the controller to each mode
+	patches map[string][][]string
+}
+
+var ingressControllers = map[string]ingressController{
+	"nginx": {
+		manifest:  common.DefIngressNginxManifest,
+		namespace: "ingress-nginx",
+		workload:  "deployment/nginx-ingress-controller",
+		service:   "ingress-nginx",
+		selector:  "app.kubernetes.io/name=ingress-nginx",
+		manifests: map[string][]string{
+			"nodeport":     {common.DefIngressNginxNodePortManifest},
+			"loadbalancer": {common.DefIngressNginxLoadBalancerManifest},
+		},
+		patches: map[string][][]string{
+			"hostport": {{"patch", "deployment", "nginx-ingress-controller", "--type=merge",
+				`--patch={"spec":{"template":{"spec":{"hostNetwork":true,"dnsPolicy":"ClusterFirstWithHostNet"}}}}`}},
+		},
+	},
+	"contour": {
+		manifest:  common.DefIngressContourManifest,
+		namespace: "projectcontour",
+		workload:  "daemonset/envoy",
+		service:   "envoy",
+		selector:  "app=envoy",
+		patches: map[string][][]string{
+			"nodeport": {{"patch", "service", "envoy", "--type=merge",
+				`--patch={"spec":{"type":"NodePort"}}`}},
+		},
+	},
+}
+
+// getMetalLBConfigManifest returns the configuration for MetalLB, with a layer2 pool with some addresses
+func getMetalLBConfigManifest(addresses []string) string {
+	var b strings.Builder
+	b.WriteString("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  namespace: metallb-system\n  name: config\ndata:\n  config: |\n")
+	b.WriteString("    address-pools:\n    - name: default\n      protocol: layer2\n      addresses:\n")
+	for _, address := range addresses {
+		b.WriteString(fmt.Sprintf("      - %s\n", address))
+	}
+	return b.String()
+}
+
+// getIngressFromResourceData returns the ingress controller, the mode and the manifests to load
+func getIngressFromResourceData(d *schema.ResourceData) (ingressController, string, []ssh.Manifest, error) {
+	config := common.GetProvisionerConfig(d)
+	name, _ := config["ingress_controller"].(string)
+	if name == "" {
+		return ingressController{}, "", nil, nil
+	}
+	controller, ok := ingressControllers[name]
+	if !ok {
+		return ingressController{}, "", nil, fmt.Errorf("unknown ingress controller %q", name)
+	}
+	mode, _ := config["ingress_mode"].(string)
+	if mode == "" {
+		mode = common.DefIngressMode
+	}
+
+	manifests := []ssh.Manifest{}
+	if addressesStr, _ := config["ingress_metallb_addresses"].(string); addressesStr != "" {
+		manifests = append(manifests,
+			ssh.Manifest{URL: common.DefMetalLBManifest},
+			ssh.Manifest{Inline: getMetalLBConfigManifest(strings.Split(addressesStr, ","))})
+	}
+
+	if m, _ := config["ingress_manifest"].(string); m != "" {
+		manifests = append(manifests, ssh.NewManifest(m))
+	} else {
+		manifests = append(manifests, ssh.Manifest{URL: controller.manifest})
+	}
+	for _, m := range controller.manifests[mode] {
+		manifests = append(manifests, ssh.Manifest{URL: m})
+	}
+	return controller, mode, manifests, nil
+}
+
+// doGetIngressEndpoint prints the endpoint where the ingress controller can be reached,
+// failing if it is not known yet
+func doGetIngressEndpoint(d *schema.ResourceData, controller ingressController, mode string) ssh.Action {
+	var buf bytes.Buffer
+	args := []string{}
+	switch mode {
+	case "loadbalancer":
+		args = []string{"get", "service", controller.service, "-n", controller.namespace,
+			"-o", "jsonpath={.status.loadBalancer.ingress[*].ip}{.status.loadBalancer.ingress[*].hostname}"}
+	case "nodeport":
+		args = []string{"get", "service", controller.service, "-n", controller.namespace,
+			"-o", "jsonpath={.spec.ports[*].nodePort}"}
+	default:
+		args = []string{"get", "pods", "-l", controller.selector, "-n", controller.namespace,
+			"-o", "jsonpath={.items[*].status.hostIP}"}
+	}
+
+	return ssh.ActionList{
+		ssh.DoSendingExecOutputToWriter(doRemoteKubectl(d, args...), &buf),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			endpoint := strings.TrimSpace(buf.String())
+			buf.Reset()
+			if endpoint == "" {
+				return ssh.ActionError("no endpoint for the ingress controller yet")
+			}
+			switch mode {
+			case "nodeport":
+				return ssh.DoMessageInfo("Ingress endpoint: any node, ports %s", endpoint)
+			case "hostport":
+				return ssh.DoMessageInfo("Ingress endpoint: %s (ports 80 and 443)", endpoint)
+			default:
+				return ssh.DoMessageInfo("Ingress endpoint: %s", endpoint)
+			}
+		}),
+	}
+}
+
+// doLoadIngress loads the ingress controller (if enabled) when the first master is
+// initialized. The controller cannot run until some workers join the cluster,
+// so its readiness is checked by the workers (see doWaitIngress)
+func doLoadIngress(d *schema.ResourceData) ssh.Action {
+	controller, mode, manifests, err := getIngressFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Loading the ingress controller (mode: %s)", mode),
+		doRemoteKubectlApply(d, manifests),
+	}
+	for _, patch := range controller.patches[mode] {
+		actions = append(actions, doRemoteKubectl(d, append(patch, "-n", controller.namespace)...))
+	}
+	return append(actions,
+		ssh.DoMessageInfo("The ingress controller will start when some workers join the cluster"))
+}
+
+// doWaitIngress waits until the ingress controller (if enabled) is ready, printing
+// the endpoint where it can be reached. It is run after a worker joins the cluster,
+// but only until one of them finds the controller ready.
+func doWaitIngress(d *schema.ResourceData) ssh.Action {
+	controller, mode, manifests, err := getIngressFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+	if len(manifests) == 0 {
+		return nil
+	}
+
+	endpoint := doGetIngressEndpoint(d, controller, mode)
+	if mode == "loadbalancer" {
+		endpoint = ssh.DoRetry(
+			ssh.Retry{Times: ingressLoadBalancerRetryTimes, Interval: ingressLoadBalancerRetryInterval},
+			endpoint)
+	}
+
+	return ssh.DoTry(
+		ssh.DoWithException(
+			ssh.DoOncePerCluster(clusterCacheIngressReady,
+				ssh.ActionList{
+					ssh.DoMessageInfo("Waiting for the ingress controller to be ready..."),
+					doRemoteKubectl(d, "rollout", "status", controller.workload, "-n", controller.namespace, "--timeout="+ingressReadyTimeout),
+					endpoint,
+				}),
+			ssh.DoMessageWarn("the ingress controller is not ready yet")))
+}
@@ -0,0 +1,67 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestGetIngressFromResourceData(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"ingress_controller":        "nginx",
+			"ingress_mode":              "loadbalancer",
+			"ingress_metallb_addresses": "192.168.1.240-192.168.1.250,192.168.2.0/28",
+		},
+	})
+
+	controller, mode, manifests, err := getIngressFromResourceData(d)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if mode != "loadbalancer" || controller.namespace != "ingress-nginx" {
+		t.Fatalf("Error: unexpected controller/mode: %+v / %q", controller, mode)
+	}
+	if len(manifests) != 4 {
+		t.Fatalf("Error: unexpected manifests: %+v", manifests)
+	}
+	if manifests[0].URL != common.DefMetalLBManifest || !strings.Contains(manifests[1].Inline, "- 192.168.2.0/28") {
+		t.Fatalf("Error: unexpected MetalLB manifests: %+v", manifests[:2])
+	}
+	if manifests[3].URL != common.DefIngressNginxLoadBalancerManifest {
+		t.Fatalf("Error: unexpected service manifest: %+v", manifests[3])
+	}
+}
+
+func TestGetIngressFromResourceDataDisabled(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{})
+	if _, _, manifests, err := getIngressFromResourceData(d); err != nil || len(manifests) != 0 {
+		t.Fatalf("Error: unexpected result: %v, %+v", err, manifests)
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"ingress_controller": "traefik",
+		},
+	})
+	if _, _, _, err := getIngressFromResourceData(d); err == nil {
+		t.Fatalf("Error: no error detected for an unknown controller")
+	}
+}
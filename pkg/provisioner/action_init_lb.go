@@ -0,0 +1,111 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	// marker added to the temporary entries in /etc/hosts
+	localBootstrapMarker = "# kubeadm-local-bootstrap"
+)
+
+var (
+	// number of times (and interval) we check the API server is reachable through the load balancer
+	lbHealthzRetry = ssh.Retry{Times: 30, Interval: 10 * time.Second}
+)
+
+// getLocalBootstrapCommands returns the commands for redirecting the control plane
+// endpoint to the local API server, and for removing this redirection.
+// DNS names are redirected with an entry in /etc/hosts (so the port in the endpoint
+// must be the same as the local port), while IPs are redirected with an iptables DNAT rule.
+func getLocalBootstrapCommands(endpoint string, localPort int) (string, string, error) {
+	host, port, err := common.SplitHostPort(endpoint, common.DefAPIServerPort)
+	if err != nil {
+		return "", "", err
+	}
+
+	if net.ParseIP(host) != nil {
+		if net.ParseIP(host).To4() == nil {
+			return "", "", fmt.Errorf("IPv6 control plane endpoints are not supported for the local bootstrap")
+		}
+		rule := fmt.Sprintf("OUTPUT -p tcp -d %s --dport %d -j DNAT --to-destination 127.0.0.1:%d", host, port, localPort)
+		add := fmt.Sprintf("sh -c \"iptables -t nat -C %s 2>/dev/null || iptables -t nat -A %s\"", rule, rule)
+		del := fmt.Sprintf("sh -c \"while iptables -t nat -D %s 2>/dev/null ; do : ; done\"", rule)
+		return add, del, nil
+	}
+
+	if port != localPort {
+		return "", "", fmt.Errorf("the port in the control plane endpoint (%d) must be the local API server port (%d) for the local bootstrap", port, localPort)
+	}
+	del := fmt.Sprintf("sed -i '/%s$/d' /etc/hosts", localBootstrapMarker)
+	add := fmt.Sprintf("sh -c \"%s && echo '127.0.0.1 %s %s' >> /etc/hosts\"", del, host, localBootstrapMarker)
+	return add, del, nil
+}
+
+// doWithLocalBootstrap runs some actions with the control plane endpoint pointing to
+// the local API server, so "kubeadm init" does not depend on a load balancer that
+// will not consider this node healthy until the API server answers to "/healthz".
+// After that, it waits until the API server is reachable through the load balancer.
+func doWithLocalBootstrap(d *schema.ResourceData, endpoint string, localPort int, actions ssh.Action) ssh.Action {
+	if enabled, _ := common.GetProvisionerConfig(d)["api_local_bootstrap"].(string); enabled != "true" || endpoint == "" {
+		return actions
+	}
+
+	if localPort == 0 {
+		localPort = common.DefAPIServerPort
+	}
+	add, del, err := getLocalBootstrapCommands(endpoint, localPort)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	return ssh.ActionList{
+		ssh.DoWithCleanup(
+			ssh.ActionList{
+				ssh.DoMessageInfo("Pointing %s to the local API server while initializing the cluster", endpoint),
+				ssh.DoExec(add), // rawcmd: built from a validated hostname and IP in getLocalBootstrapCommands()
+				actions,
+			},
+			ssh.ActionList{
+				ssh.DoMessageInfo("Restoring the control plane endpoint %s", endpoint),
+				ssh.DoExec(del), // rawcmd: built from a validated hostname and IP in getLocalBootstrapCommands()
+			}),
+		doWaitAPIServerHealthz(endpoint),
+	}
+}
+
+// doWaitAPIServerHealthz waits until the API server answers to "/healthz" in some endpoint,
+// just printing a warning if it is not reachable after some time
+func doWaitAPIServerHealthz(endpoint string) ssh.Action {
+	url := fmt.Sprintf("https://%s/healthz", common.AddressWithPort(endpoint, common.DefAPIServerPort))
+	return ssh.DoIf(
+		ssh.CheckBinaryExists("curl"),
+		ssh.DoTry(ssh.DoWithException(
+			ssh.ActionList{
+				ssh.DoMessageInfo("Waiting for the API server to be reachable at %s", url),
+				ssh.DoRetry(lbHealthzRetry,
+					ssh.DoExecCommand(ssh.NewCommand("curl", "--silent", "--insecure", "--fail", "--max-time", "5", url))),
+			},
+			ssh.DoMessageWarn("the API server is not reachable at %s: check the load balancer health checks", url))))
+}
@@ -0,0 +1,50 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetLocalBootstrapCommands(t *testing.T) {
+	add, del, err := getLocalBootstrapCommands("api.my-cluster.com:6443", 6443)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	if !strings.Contains(add, "echo '127.0.0.1 api.my-cluster.com "+localBootstrapMarker+"' >> /etc/hosts") {
+		t.Fatalf("Error: unexpected command for adding the redirection: %s", add)
+	}
+	if !strings.Contains(del, "/etc/hosts") {
+		t.Fatalf("Error: unexpected command for removing the redirection: %s", del)
+	}
+
+	add, del, err = getLocalBootstrapCommands("10.0.0.1:443", 6443)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	rule := "OUTPUT -p tcp -d 10.0.0.1 --dport 443 -j DNAT --to-destination 127.0.0.1:6443"
+	if !strings.Contains(add, "iptables -t nat -A "+rule) {
+		t.Fatalf("Error: unexpected command for adding the redirection: %s", add)
+	}
+	if !strings.Contains(del, "iptables -t nat -D "+rule) {
+		t.Fatalf("Error: unexpected command for removing the redirection: %s", del)
+	}
+
+	// names cannot be redirected to a different port
+	if _, _, err := getLocalBootstrapCommands("api.my-cluster.com:443", 6443); err == nil {
+		t.Fatalf("Error: no error when using a different port")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// getImagePullSecretsManifest returns a manifest with the namespaces and secrets,
+// as well as the secrets that must be added to the default ServiceAccount in each namespace
+func getImagePullSecretsManifest(secrets []common.ImagePullSecret) (string, []string, map[string][]string, error) {
+	docs := []string{}
+	namespaces := []string{}
+	seen := map[string]bool{}
+	patches := map[string][]string{}
+
+	for _, secret := range secrets {
+		config, err := secret.DockerConfigJSON()
+		if err != nil {
+			return "", nil, nil, err
+		}
+		for _, ns := range secret.Namespaces {
+			if !seen[ns] {
+				seen[ns] = true
+				namespaces = append(namespaces, ns)
+				docs = append(docs, fmt.Sprintf("apiVersion: v1\nkind: Namespace\nmetadata:\n  name: %s\n", ns))
+			}
+			docs = append(docs, fmt.Sprintf("apiVersion: v1\nkind: Secret\nmetadata:\n  name: %s\n  namespace: %s\ntype: kubernetes.io/dockerconfigjson\ndata:\n  .dockerconfigjson: %s\n",
+				secret.Name, ns, base64.StdEncoding.EncodeToString(config)))
+			if secret.PatchServiceAccount {
+				patches[ns] = append(patches[ns], secret.Name)
+			}
+		}
+	}
+	return strings.Join(docs, "---\n"), namespaces, patches, nil
+}
+
+// doCreateImagePullSecrets creates the image pull secrets (if any) in the namespaces
+// requested, adding them to the default ServiceAccounts when requested
+func doCreateImagePullSecrets(d *schema.ResourceData) ssh.Action {
+	secretsStr, _ := common.GetProvisionerConfig(d)["image_pull_secrets"].(string)
+	if secretsStr == "" {
+		return nil
+	}
+	secrets, err := common.ImagePullSecretsFromTerraformSafeString(secretsStr)
+	if err != nil {
+		return ssh.ActionError(fmt.Sprintf("could not parse the image pull secrets: %s", err))
+	}
+	manifest, namespaces, patches, err := getImagePullSecretsManifest(secrets)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	// note: we do not use doRemoteKubectlApply, as it dumps the manifest on errors
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Creating %d image pull secrets", len(secrets)),
+		ssh.DoWithTempFilename(func(remoteManifest string) ssh.Action {
+			return ssh.DoWithCleanup(
+				ssh.ActionList{
+					ssh.DoUploadBytesToFile([]byte(manifest), remoteManifest),
+					doRemoteKubectl(d, "apply", "--validate=false", "-f", remoteManifest),
+				},
+				ssh.DoTry(ssh.DoDeleteFile(remoteManifest)))
+		}),
+	}
+
+	for _, ns := range namespaces {
+		names, ok := patches[ns]
+		if !ok {
+			continue
+		}
+		refs := []map[string]string{}
+		for _, name := range names {
+			refs = append(refs, map[string]string{"name": name})
+		}
+		patch, _ := json.Marshal(map[string]interface{}{"imagePullSecrets": refs})
+
+		// (the default ServiceAccount is created asynchronously in new namespaces)
+		actions = append(actions,
+			ssh.DoRetry(
+				ssh.Retry{Times: 5, Interval: 5 * time.Second},
+				doRemoteKubectl(d, "patch", "serviceaccount", "default", "-n", ns, "--patch="+string(patch))))
+	}
+	return actions
+}
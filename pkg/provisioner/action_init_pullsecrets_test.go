@@ -0,0 +1,50 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestGetImagePullSecretsManifest(t *testing.T) {
+	secrets := []common.ImagePullSecret{
+		{Name: "registry", Server: "registry.example.com", Username: "user", Password: "pass", Namespaces: []string{"default", "apps"}, PatchServiceAccount: true},
+		{Name: "other", Server: "other.example.com", Username: "user", Password: "pass", Namespaces: []string{"apps"}},
+	}
+	manifest, namespaces, patches, err := getImagePullSecretsManifest(secrets)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	if !reflect.DeepEqual(namespaces, []string{"default", "apps"}) {
+		t.Fatalf("Error: unexpected namespaces: %v", namespaces)
+	}
+	if !reflect.DeepEqual(patches, map[string][]string{"default": {"registry"}, "apps": {"registry"}}) {
+		t.Fatalf("Error: unexpected patches: %v", patches)
+	}
+	if n := strings.Count(manifest, "kind: Secret\n"); n != 3 {
+		t.Fatalf("Error: unexpected number of secrets (%d) in manifest:\n%s", n, manifest)
+	}
+	if n := strings.Count(manifest, "kind: Namespace\n"); n != 2 {
+		t.Fatalf("Error: unexpected number of namespaces (%d) in manifest:\n%s", n, manifest)
+	}
+	if strings.Contains(manifest, "pass") {
+		t.Fatalf("Error: password found in clear in manifest:\n%s", manifest)
+	}
+}
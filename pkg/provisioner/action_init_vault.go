@@ -0,0 +1,78 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	vaultAuthManifestTemplate = `apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: %[1]s
+  namespace: %[2]s
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: %[3]s
+  namespace: %[2]s
+  annotations:
+    kubernetes.io/service-account.name: %[1]s
+type: kubernetes.io/service-account-token
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRoleBinding
+metadata:
+  name: %[1]s-tokenreview-binding
+roleRef:
+  apiGroup: rbac.authorization.k8s.io
+  kind: ClusterRole
+  name: system:auth-delegator
+subjects:
+- kind: ServiceAccount
+  name: %[1]s
+  namespace: %[2]s
+`
+)
+
+// getVaultAuthManifest returns the manifest with the ServiceAccount (and its token)
+// that Vault will use for reviewing tokens with the TokenReview API
+func getVaultAuthManifest(serviceAccount string, namespace string) string {
+	return fmt.Sprintf(vaultAuthManifestTemplate, serviceAccount, namespace, common.GetVaultAuthSecretName(serviceAccount))
+}
+
+// doCreateVaultAuth creates the ServiceAccount used by the Vault Kubernetes auth backend
+func doCreateVaultAuth(d *schema.ResourceData) ssh.Action {
+	serviceAccount, _ := common.GetProvisionerConfig(d)["vault_auth_service_account"].(string)
+	if serviceAccount == "" {
+		return nil
+	}
+	namespace, _ := common.GetProvisionerConfig(d)["vault_auth_namespace"].(string)
+	if namespace == "" {
+		namespace = common.DefVaultAuthNamespace
+	}
+
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Creating the ServiceAccount %s/%s for Vault", namespace, serviceAccount),
+		doRemoteKubectlApply(d, []ssh.Manifest{{Inline: getVaultAuthManifest(serviceAccount, namespace)}}),
+	}
+}
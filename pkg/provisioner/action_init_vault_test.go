@@ -0,0 +1,37 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetVaultAuthManifest(t *testing.T) {
+	manifest := getVaultAuthManifest("vault-auth", "vault")
+
+	expected := []string{
+		"kind: ServiceAccount\nmetadata:\n  name: vault-auth\n  namespace: vault\n",
+		"  name: vault-auth-token\n  namespace: vault\n",
+		"kubernetes.io/service-account.name: vault-auth\n",
+		"type: kubernetes.io/service-account-token\n",
+		"name: system:auth-delegator\n",
+	}
+	for _, e := range expected {
+		if !strings.Contains(manifest, e) {
+			t.Fatalf("Error: %q not found in manifest:\n%s", e, manifest)
+		}
+	}
+}
@@ -26,14 +26,25 @@ import (
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
-const (
-	// retry 6 times to join
-	joinRetryTimes = 6
-
-	// ... waiting 30 seconds between each try
-	joinRetryInterval = 30 * time.Second
+var (
+	// retry 6 times to join, waiting 15 seconds after the first failure
+	// and longer and longer after that (up to a minute)
+	joinRetry = ssh.Retry{Times: 6, Interval: 15 * time.Second, Backoff: 1.5, MaxInterval: time.Minute}
+
+	// the time a join can take (with all its attempts, and the time between them):
+	// tokens expiring before this deadline are replaced before trying to join
+	joinDeadline = time.Duration(joinRetry.Times)*kubeadmTimeouts["join"] + joinRetry.Duration()
 )
 
+// doKubeadmJoinDryRun validates the configuration with a `kubeadm join --dry-run`
+// (when "dry_run" is enabled), with a valid token
+func doKubeadmJoinDryRun(d *schema.ResourceData) ssh.Action {
+	if !d.Get("dry_run").(bool) {
+		return nil
+	}
+	return doWithJoinToken(d, doKubeadmDryRun(d, "join"))
+}
+
 // doKubeadmJoinWorker runs the `kubeadm join`
 func doKubeadmJoinWorker(d *schema.ResourceData) ssh.Action {
 	// get the join configuration
@@ -45,6 +56,15 @@ func doKubeadmJoinWorker(d *schema.ResourceData) ssh.Action {
 	// ... update the nodename
 	joinConfig.NodeRegistration.Name = getNodenameFromResourceData(d)
 
+	// ... check the pods CIDR for this node
+	if err := checkNodePodCIDR(d); err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	// ... and the address for advertising this node
+	// (the address for connecting to the API server is set when the config is uploaded)
+	setNodeAdvertiseAddress(d, &joinConfig.NodeRegistration)
+
 	// ... and update the `config.join` section
 	if err := common.JoinConfigToResourceData(d, joinConfig); err != nil {
 		return ssh.ActionError(err.Error())
@@ -52,19 +72,14 @@ func doKubeadmJoinWorker(d *schema.ResourceData) ssh.Action {
 
 	actions := ssh.ActionList{
 		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
+			joinRetry,
 			ssh.ActionList{
 				doCheckLocalKubeconfigExists(d),
 			}),
-		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
-			ssh.ActionList{
-				doRefreshToken(d),
-			}),
-		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
+		doJoinWithToken(d,
 			ssh.ActionList{
 				doMaybeResetWorker(d, common.DefKubeadmJoinConfPath),
+				doCreateNodeWithPodCIDR(d),
 				ssh.DoMessageInfo("Trying to join the cluster as a worker with 'kubadm join'..."),
 				doKubeadm(d, common.DefKubeadmJoinConfPath, "join"),
 			}),
@@ -100,35 +115,47 @@ func doKubeadmJoinControlPlane(d *schema.ResourceData) ssh.Action {
 	} else {
 		endpoint = kubeadmapi.APIEndpoint{AdvertiseAddress: "", BindPort: common.DefAPIServerPort}
 	}
+	if addr := getAdvertiseAddressFromResourceData(d); addr != "" {
+		endpoint.AdvertiseAddress = addr
+	}
 	joinConfig.ControlPlane = &kubeadmapi.JoinControlPlane{LocalAPIEndpoint: endpoint}
 
 	joinConfig.NodeRegistration.Name = getNodenameFromResourceData(d)
 
+	if err := checkNodePodCIDR(d); err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	setNodeAdvertiseAddress(d, &joinConfig.NodeRegistration)
+
 	// ... and update the `config.join` section in the ResourceData
 	if err := common.JoinConfigToResourceData(d, joinConfig); err != nil {
 		return ssh.ActionError(err.Error())
 	}
 
-	actions := ssh.ActionList{
+	actions := ssh.ActionList{}
+
+	// the API server certificate is generated with the certSANs in the cluster configuration
+	if len(getCertSANsFromResourceData(d)) > 0 {
+		actions = append(actions,
+			ssh.DoMessageWarn("'cert_sans' are ignored in additional masters: use the 'api.alt_names' in the provider"))
+	}
+
+	actions = append(actions,
 		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
+			joinRetry,
 			ssh.ActionList{
 				doCheckLocalKubeconfigExists(d),
 			}),
-		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
-			ssh.ActionList{
-				doRefreshToken(d),
-			}),
-		ssh.DoRetry(
-			ssh.Retry{Times: joinRetryTimes, Interval: joinRetryInterval},
+		doJoinWithToken(d,
 			ssh.ActionList{
 				ssh.DoMessageInfo("Trying to join the cluster control-plane with 'kubadm join'..."),
 				doMaybeResetMaster(d, common.DefKubeadmJoinConfPath),
 				doUploadCerts(d), // (we must upload certs because a "kubeadm reset" wipes them...)
+				doCreateNodeWithPodCIDR(d),
 				doKubeadm(d, common.DefKubeadmJoinConfPath, "join"),
 			}),
-	}
+	)
 	return actions
 }
 
@@ -0,0 +1,115 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// kubeletPolicyState is a kubelet resources manager that records the policy
+// in use in a state file: the kubelet refuses to start when this policy does
+// not match the policy in its configuration
+type kubeletPolicyState struct {
+	name string
+
+	// the state file
+	path string
+
+	// the key with the policy in the provisioner config
+	configKey string
+
+	// the policy used by the kubelet when none is provided
+	defPolicy string
+}
+
+var kubeletPolicyStates = []kubeletPolicyState{
+	{
+		name:      "CPU Manager",
+		path:      "/var/lib/kubelet/cpu_manager_state",
+		configKey: "kubelet_cpu_manager_policy",
+		defPolicy: "none",
+	},
+	{
+		name:      "Memory Manager",
+		path:      "/var/lib/kubelet/memory_manager_state",
+		configKey: "kubelet_memory_manager_policy",
+		defPolicy: "None",
+	},
+}
+
+// parsePolicyState returns the policy recorded in a kubelet state file
+// (or an empty string when there is no state file)
+func parsePolicyState(contents string) (string, error) {
+	if strings.TrimSpace(contents) == "" {
+		return "", nil
+	}
+	state := struct {
+		PolicyName string `json:"policyName"`
+	}{}
+	if err := json.Unmarshal([]byte(contents), &state); err != nil {
+		return "", err
+	}
+	return state.PolicyName, nil
+}
+
+// doCleanupKubeletPolicyState removes the state file of a kubelet resources manager
+// when it was recorded with a different policy, stopping the kubelet before
+// (`kubeadm` starts it again with the new policy)
+func doCleanupKubeletPolicyState(state kubeletPolicyState, policy string) ssh.Action {
+	if policy == "" {
+		policy = state.defPolicy
+	}
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		doReadRemoteConfig(ssh.NewShellCommand(`cat "$1" 2>/dev/null || true`, state.path), &buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			current, err := parsePolicyState(buf.String())
+			if err != nil {
+				ssh.Debug("could not parse %s: %s", state.path, err)
+			} else if current == "" || current == policy {
+				return nil
+			}
+
+			return doInMaintenanceWindow(fmt.Sprintf("removing the kubelet %s state", state.name), ssh.ActionList{
+				ssh.DoMessageWarn("The kubelet %s policy has changed from %q to %q: removing its state", state.name, current, policy),
+				ssh.DoExec("systemctl --no-pager stop kubelet.service"),
+				ssh.DoExecCommand(ssh.NewCommand("rm", "-f", state.path)),
+			})
+		}),
+	}
+}
+
+// doCleanupKubeletPolicyStates removes the state of the kubelet CPU and Memory managers
+// when their policies change, as the kubelet would not start otherwise
+func doCleanupKubeletPolicyStates(d *schema.ResourceData) ssh.Action {
+	config := common.GetProvisionerConfig(d)
+
+	actions := ssh.ActionList{}
+	for _, state := range kubeletPolicyStates {
+		policy, _ := config[state.configKey].(string)
+		actions = append(actions, doCleanupKubeletPolicyState(state, policy))
+	}
+	return actions
+}
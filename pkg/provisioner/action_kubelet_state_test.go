@@ -0,0 +1,56 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+)
+
+func TestParsePolicyState(t *testing.T) {
+	cases := []struct {
+		contents string
+		expected string
+		err      bool
+	}{
+		{
+			contents: `{"policyName":"static","defaultCpuSet":"2-7","checksum":1353318690}`,
+			expected: "static",
+		},
+		{
+			contents: "\n",
+			expected: "",
+		},
+		{
+			contents: "{ not json",
+			err:      true,
+		},
+	}
+
+	for _, c := range cases {
+		policy, err := parsePolicyState(c.contents)
+		if c.err {
+			if err == nil {
+				t.Fatalf("Error: invalid state %q not detected", c.contents)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if policy != c.expected {
+			t.Fatalf("Error: unexpected policy for %q: %q (expected %q)", c.contents, policy, c.expected)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// connectivityProbe is a port that must be reachable from this node
+type connectivityProbe struct {
+	host  string
+	port  int
+	proto string
+	descr string
+}
+
+func (p connectivityProbe) String() string {
+	return fmt.Sprintf("%s/%s (%s)", net.JoinHostPort(p.host, strconv.Itoa(p.port)), p.proto, p.descr)
+}
+
+// cniPeerPorts are the ports that must be reachable between nodes for some CNI plugins
+// (the key is the plugin name, or "<plugin>/<backend>")
+var cniPeerPorts = map[string][]connectivityProbe{
+	"flannel/vxlan": {{port: 8472, proto: "udp", descr: "flannel vxlan"}},
+	"flannel/udp":   {{port: 8285, proto: "udp", descr: "flannel udp"}},
+	"weave": {
+		{port: 6783, proto: "tcp", descr: "weave control"},
+		{port: 6783, proto: "udp", descr: "weave data"},
+		{port: 6784, proto: "udp", descr: "weave data"},
+	},
+}
+
+// getConnectivityProbes returns the list of ports that must be reachable from this node
+func getConnectivityProbes(d *schema.ResourceData) []connectivityProbe {
+	probes := []connectivityProbe{}
+
+	// nodes joining the cluster must be able to reach the API server
+	if join := getAPIConnectAddressFromResourceData(d); join != "" {
+		host, port, err := common.SplitHostPort(join, common.DefAPIServerPort)
+		if err == nil {
+			probes = append(probes, connectivityProbe{host: host, port: port, proto: "tcp", descr: "API server"})
+		}
+	}
+
+	// get the ports required by the CNI plugin
+	cniKeys := []string{}
+	if cniPluginOpt, ok := d.GetOk("config.cni_plugin"); ok {
+		cniPlugin := strings.TrimSpace(strings.ToLower(cniPluginOpt.(string)))
+		cniKeys = append(cniKeys, cniPlugin)
+		if backendOpt, ok := d.GetOk("config.flannel_backend"); ok {
+			cniKeys = append(cniKeys, fmt.Sprintf("%s/%s", cniPlugin, backendOpt.(string)))
+		}
+	}
+
+	for _, peerRaw := range d.Get("preflight.0.peers").([]interface{}) {
+		peer := strings.TrimSpace(peerRaw.(string))
+		if peer == "" {
+			continue
+		}
+		probes = append(probes, connectivityProbe{host: peer, port: common.DefKubeletPort, proto: "tcp", descr: "kubelet"})
+		for _, key := range cniKeys {
+			for _, p := range cniPeerPorts[key] {
+				probes = append(probes, connectivityProbe{host: peer, port: p.port, proto: p.proto, descr: p.descr})
+			}
+		}
+	}
+
+	return probes
+}
+
+// doCheckConnectivity checks that this node can reach the API server and the
+// other nodes in the cluster, printing a report with the results.
+// When "preflight.strict" is enabled, any failure aborts the provisioning.
+func doCheckConnectivity(d *schema.ResourceData) ssh.Action {
+	probes := getConnectivityProbes(d)
+	if len(probes) == 0 {
+		return nil
+	}
+	strict := d.Get("preflight.0.strict").(bool)
+
+	return ssh.DoIfElse(
+		ssh.CheckBinaryExists("nc"),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			report := ssh.ActionList{ssh.DoMessageInfo("Connectivity matrix from this node:")}
+			failed := 0
+			for _, probe := range probes {
+				ok, err := ssh.CheckPortOpen(probe.host, probe.port, probe.proto).Check(ctx)
+				if err != nil {
+					return ssh.ActionError(fmt.Sprintf("could not check connectivity to %s: %s", probe, err))
+				}
+				if ok {
+					report = append(report, ssh.DoMessageInfo("- %s: OK", probe))
+				} else {
+					report = append(report, ssh.DoMessageWarn("- %s: FAILED", probe))
+					failed++
+				}
+			}
+
+			if failed > 0 && strict {
+				report = append(report, ssh.DoAbort("%d connectivity checks failed", failed))
+			}
+			return report
+		}),
+		ssh.DoMessageWarn("'nc' not found in this node: connectivity checks will be skipped"),
+	)
+}
+
+// command for getting the addresses in this node
+const nodeAddressesCmd = "ip -o addr show scope global"
+
+// interfaces created by Kubernetes or the CNI plugins, with addresses in the pods
+// or services subnets, that must be ignored when checking overlaps
+var nodeSubnetsIgnoredIfaces = []string{"cni", "flannel", "cali", "weave", "vxlan", "tunl", "kube-ipvs", "cilium"}
+
+// parseNodeSubnets parses the output of "ip -o addr", returning the subnets
+// this node is in
+func parseNodeSubnets(output string) []string {
+	res := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		// ie, "2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\       valid_lft forever"
+		fields := strings.Fields(line)
+		if len(fields) < 4 || (fields[2] != "inet" && fields[2] != "inet6") {
+			continue
+		}
+
+		iface := strings.Split(fields[1], "@")[0]
+		ignored := false
+		for _, prefix := range nodeSubnetsIgnoredIfaces {
+			if strings.HasPrefix(iface, prefix) {
+				ignored = true
+				break
+			}
+		}
+		if ignored {
+			continue
+		}
+
+		if _, subnet, err := net.ParseCIDR(fields[3]); err == nil {
+			res = append(res, subnet.String())
+		}
+	}
+	return res
+}
+
+// doCheckNodeSubnets checks that the pods and services subnets do not overlap
+// with the subnets this node is in
+func doCheckNodeSubnets(d *schema.ResourceData) ssh.Action {
+	initConfig, _, err := common.InitConfigFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(fmt.Sprintf("could not get a valid 'config' for checking the network: %s", err))
+	}
+	podsCIDR := initConfig.Networking.PodSubnet
+	servicesCIDR := initConfig.Networking.ServiceSubnet
+	if podsCIDR == "" || servicesCIDR == "" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		// the output is received line by line, without the line breaks
+		ssh.DoSendingExecOutputToFunc(ssh.DoExec(nodeAddressesCmd), func(s string) {
+			buf.WriteString(s)
+			buf.WriteByte('\n')
+		}),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			subnets := parseNodeSubnets(buf.String())
+			ssh.Debug("subnets in this node: %v", subnets)
+			if err := common.CheckNetworksOverlap(podsCIDR, servicesCIDR, subnets); err != nil {
+				return ssh.ActionError(fmt.Sprintf("invalid network configuration for this node: %s", err))
+			}
+			return nil
+		}),
+	}
+}
+
+const (
+	// default minimum entropy available (note that newer kernels always report 256)
+	defMinEntropy = 200
+
+	// file with the entropy available in the node
+	entropyAvailPath = "/proc/sys/kernel/random/entropy_avail"
+)
+
+// command for installing (and starting) an entropy daemon with the package manager available
+const installRNGCmd = `sh -c "if command -v apt-get >/dev/null ; then apt-get install -y haveged ; ` +
+	`elif command -v zypper >/dev/null ; then zypper -n install haveged ; ` +
+	`elif command -v dnf >/dev/null ; then dnf install -y rng-tools && systemctl enable --now rngd ; ` +
+	`elif command -v yum >/dev/null ; then yum install -y rng-tools && systemctl enable --now rngd ; ` +
+	`else exit 1 ; fi ; systemctl enable --now haveged 2>/dev/null || true"`
+
+// parseEntropyAvail parses the contents of /proc/sys/kernel/random/entropy_avail
+func parseEntropyAvail(output string) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(output))
+}
+
+// doCheckEntropy checks that /dev/urandom is available and that there is enough
+// entropy in the node, as the generation of keys in "kubeadm init" can hang for
+// minutes otherwise. When "preflight.install_rng" is enabled, an entropy daemon
+// is installed when the entropy is too low.
+func doCheckEntropy(d *schema.ResourceData) ssh.Action {
+	// (note: there are no defaults when the "preflight" block is not present,
+	// and a "min_entropy = 0" in the block disables the check)
+	minEntropy := defMinEntropy
+	if len(d.Get("preflight").([]interface{})) > 0 {
+		minEntropy = d.Get("preflight.0.min_entropy").(int)
+	}
+	strict := d.Get("preflight.0.strict").(bool)
+	installRNG := d.Get("preflight.0.install_rng").(bool)
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		ssh.DoIf(
+			ssh.CheckNot(ssh.CheckExec("[ -c /dev/urandom ]")),
+			ssh.ActionError("/dev/urandom is not available in this node")),
+		ssh.DoSendingExecOutputToWriter(ssh.DoExecCommand(ssh.NewCommand("cat", entropyAvailPath)), &buf),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			entropy, err := parseEntropyAvail(buf.String())
+			if err != nil {
+				return ssh.DoMessageWarn("could not get the entropy available in this node: %s", err)
+			}
+			ssh.Debug("entropy available in this node: %d", entropy)
+			if minEntropy <= 0 || entropy >= minEntropy {
+				return nil
+			}
+
+			msg := fmt.Sprintf("low entropy in this node (%d < %d): key generation could hang for minutes", entropy, minEntropy)
+			switch {
+			case installRNG:
+				return ssh.ActionList{
+					ssh.DoMessageWarn("%s: installing an entropy daemon", msg),
+					ssh.DoWithException(
+						ssh.DoExec(installRNGCmd),
+						ssh.DoMessageWarn("could not install an entropy daemon: consider installing haveged or rng-tools")),
+				}
+			case strict:
+				return ssh.DoAbort("%s", msg)
+			default:
+				return ssh.DoMessageWarn("%s (consider installing haveged or rng-tools)", msg)
+			}
+		}),
+	}
+}
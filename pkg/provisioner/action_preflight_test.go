@@ -0,0 +1,132 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestGetConnectivityProbes(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join": "10.0.0.1",
+		"config": map[string]interface{}{
+			"cni_plugin":      "flannel",
+			"flannel_backend": "vxlan",
+		},
+		"preflight": []interface{}{
+			map[string]interface{}{
+				"peers": []interface{}{"10.0.0.2", "10.0.0.3"},
+			},
+		},
+	})
+
+	expected := []string{
+		"10.0.0.1:6443/tcp (API server)",
+		"10.0.0.2:10250/tcp (kubelet)",
+		"10.0.0.2:8472/udp (flannel vxlan)",
+		"10.0.0.3:10250/tcp (kubelet)",
+		"10.0.0.3:8472/udp (flannel vxlan)",
+	}
+
+	probes := getConnectivityProbes(d)
+	if len(probes) != len(expected) {
+		t.Fatalf("Error: unexpected number of probes: %d, expected: %d (%+v)", len(probes), len(expected), probes)
+	}
+	for i, probe := range probes {
+		if probe.String() != expected[i] {
+			t.Fatalf("Error: unexpected probe %q, expected %q", probe.String(), expected[i])
+		}
+	}
+}
+
+func TestParseNodeSubnets(t *testing.T) {
+	output := `2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\       valid_lft forever preferred_lft forever
+2: eth0    inet6 fd00::5/64 scope global \       valid_lft forever preferred_lft forever
+3: docker0    inet 172.17.0.1/16 brd 172.17.255.255 scope global docker0\       valid_lft forever preferred_lft forever
+4: flannel.1    inet 10.244.0.0/32 scope global flannel.1\       valid_lft forever preferred_lft forever
+5: cni0    inet 10.244.0.1/24 brd 10.244.0.255 scope global cni0\       valid_lft forever preferred_lft forever
+`
+	expected := []string{"10.0.0.0/24", "fd00::/64", "172.17.0.0/16"}
+	subnets := parseNodeSubnets(output)
+	if !reflect.DeepEqual(subnets, expected) {
+		t.Fatalf("Error: unexpected subnets: %v, expected: %v", subnets, expected)
+	}
+}
+
+func TestDoCheckEntropy(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"preflight": []interface{}{
+			map[string]interface{}{"strict": true, "min_entropy": 1000},
+		},
+	})
+
+	// responses for the "/dev/urandom" check and the entropy available
+	ctx := ssh.NewTestingContextWithResponses([]string{"CONDITION_SUCCEEDED", "3000\n"})
+	if res := (ssh.ActionList{doCheckEntropy(d)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: unexpected error with enough entropy: %s", res)
+	}
+
+	ctx = ssh.NewTestingContextWithResponses([]string{"CONDITION_SUCCEEDED", "12\n"})
+	if res := (ssh.ActionList{doCheckEntropy(d)}).Apply(ctx); !ssh.IsError(res) {
+		t.Fatalf("Error: no error with low entropy in strict mode")
+	}
+
+	// a "min_entropy = 0" disables the check
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"preflight": []interface{}{
+			map[string]interface{}{"strict": true, "min_entropy": 0},
+		},
+	})
+	ctx = ssh.NewTestingContextWithResponses([]string{"CONDITION_SUCCEEDED", "12\n"})
+	if res := (ssh.ActionList{doCheckEntropy(d)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: entropy checked with min_entropy=0: %s", res)
+	}
+}
+
+func TestParseConflicts(t *testing.T) {
+	output := "/usr/local/bin/k3s\n/var/lib/rancher/k3s\n/var/snap/microk8s\n"
+	installs, found := parseConflicts(output)
+	if len(installs) != 2 || installs[0].name != "k3s" || installs[1].name != "microk8s" {
+		t.Fatalf("Error: unexpected conflicting installs: %+v", installs)
+	}
+	if !reflect.DeepEqual(found["k3s"], []string{"/usr/local/bin/k3s", "/var/lib/rancher/k3s"}) {
+		t.Fatalf("Error: unexpected paths for k3s: %v", found["k3s"])
+	}
+	expected := "k3s (/usr/local/bin/k3s, /var/lib/rancher/k3s); microk8s (/var/snap/microk8s)"
+	if report := getConflictsReport(installs, found); report != expected {
+		t.Fatalf("Error: unexpected report: %q", report)
+	}
+
+	// kubeadm remnants are only a conflict when they were not left by the provisioner
+	output = "/etc/kubernetes/kubelet.conf\n/var/lib/etcd/member\n"
+	if installs, found := parseConflicts(output); len(installs) != 1 || installs[0].name != "old kubeadm" ||
+		!reflect.DeepEqual(found["old kubeadm"], []string{"/etc/kubernetes/kubelet.conf", "/var/lib/etcd/member"}) {
+		t.Fatalf("Error: unexpected conflicting installs: %+v (%v)", installs, found)
+	}
+	output += common.DefKubeadmJoinConfPath + "\n"
+	if installs, _ := parseConflicts(output); len(installs) != 0 {
+		t.Fatalf("Error: unexpected conflicting installs: %+v", installs)
+	}
+
+	if installs, _ := parseConflicts(""); len(installs) != 0 {
+		t.Fatalf("Error: unexpected conflicting installs: %+v", installs)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+const (
+	// rebootRequiredAnnotation is the annotation set in the Node when it must be rebooted
+	rebootRequiredAnnotation = "kubeadm.terraform.io/reboot-required"
+)
+
+const (
+	// defRebootTimeout is the default time we wait for the node after a reboot
+	defRebootTimeout = 5 * time.Minute
+)
+
+// getRebootTimeoutFromResourceData returns the maximum time we wait for the node after a reboot
+func getRebootTimeoutFromResourceData(d *schema.ResourceData) time.Duration {
+	timeout, err := time.ParseDuration(d.Get("reboot_timeout").(string))
+	if err != nil || timeout <= 0 {
+		return defRebootTimeout
+	}
+	return timeout
+}
+
+// doRebootIfRequired checks if the node must be rebooted after installing packages,
+// kernel modules or container runtimes. The node is rebooted when "reboot_if_required"
+// is enabled, otherwise a warning is shown.
+func doRebootIfRequired(d *schema.ResourceData) ssh.Action {
+	if !d.Get("reboot_if_required").(bool) {
+		return ssh.DoIf(
+			ssh.CheckRebootRequired(),
+			ssh.DoMessageWarn("this node must be rebooted: set 'reboot_if_required' for rebooting it automatically"))
+	}
+	return ssh.DoIf(
+		ssh.CheckRebootRequired(),
+		doCoordinatedReboot(d))
+}
+
+// doCoordinatedReboot reboots the node, draining it before when it is already
+// part of the cluster (and uncordoning it once it is back)
+func doCoordinatedReboot(d *schema.ResourceData) ssh.Action {
+	timeout := getRebootTimeoutFromResourceData(d)
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		ssh.DoMessageInfo("A reboot is required in this node"),
+		ssh.DoTry(DoGetNodename(d, &node)),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return ssh.DoRebootAndWait(timeout)
+			}
+			return ssh.DoInMaintenanceWindow("rebooting the node", ssh.ActionList{
+				// (the node could not be registered in the cluster yet)
+				ssh.DoTry(doKubectlDrainNode(d, node.Nodename)),
+				ssh.DoRebootAndWait(timeout),
+				ssh.DoTry(doRemoteKubectl(d, "uncordon", node.Nodename)),
+			})
+		}),
+	}
+}
+
+// doSignalRebootRequired annotates the Node object when the node must be rebooted,
+// removing the annotation otherwise
+func doSignalRebootRequired(d *schema.ResourceData) ssh.Action {
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		DoGetNodename(d, &node),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return nil
+			}
+			return ssh.DoIfElse(
+				ssh.CheckRebootRequired(),
+				ssh.ActionList{
+					ssh.DoMessageWarn("node %q must be rebooted: annotating it with %q", node.Nodename, rebootRequiredAnnotation),
+					doRemoteKubectl(d, "annotate", "--overwrite", "node", node.Nodename, rebootRequiredAnnotation+"=true"),
+				},
+				doRemoteKubectl(d, "annotate", "node", node.Nodename, rebootRequiredAnnotation+"-"))
+		}),
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	// the address of the stub resolver of systemd-resolved
+	systemdResolvedStubAddress = "127.0.0.53"
+
+	// the resolv.conf maintained by systemd-resolved with the real upstream servers
+	systemdResolvedResolvConf = "/run/systemd/resolve/resolv.conf"
+)
+
+// checkSystemdResolvedStub checks if /etc/resolv.conf points to the stub resolver of systemd-resolved
+func checkSystemdResolvedStub() ssh.CheckerFunc {
+	return ssh.CheckExecCommand(ssh.NewCommand("grep", "-q", "^nameserver "+systemdResolvedStubAddress, "/etc/resolv.conf"))
+}
+
+// setKubeletResolvConf sets the "resolv-conf" kubelet argument, unless it has
+// already been set (ie, when some upstream DNS servers have been provided).
+// It returns true if the argument has been set.
+func setKubeletResolvConf(nodeRegistration *kubeadmapi.NodeRegistrationOptions, resolvConf string) bool {
+	if nodeRegistration.KubeletExtraArgs == nil {
+		nodeRegistration.KubeletExtraArgs = map[string]string{}
+	}
+	if _, ok := nodeRegistration.KubeletExtraArgs["resolv-conf"]; ok {
+		return false
+	}
+	nodeRegistration.KubeletExtraArgs["resolv-conf"] = resolvConf
+	return true
+}
+
+// doSetKubeletResolvConf makes the kubelet use the resolv.conf with the real upstream
+// servers when the systemd-resolved stub resolver is used in /etc/resolv.conf.
+// Otherwise, CoreDNS would forward queries to 127.0.0.53 in the pod (a DNS loop).
+func doSetKubeletResolvConf(d *schema.ResourceData, command string) ssh.Action {
+	return ssh.DoIf(
+		checkSystemdResolvedStub(),
+		ssh.DoIfElse(
+			ssh.CheckFileExists(systemdResolvedResolvConf),
+			ssh.ActionFunc(func(context.Context) ssh.Action {
+				switch command {
+				case "init":
+					initConfig, _, err := common.InitConfigFromResourceData(d)
+					if err != nil {
+						return ssh.ActionError(fmt.Sprintf("could not get a valid 'config' for init'ing: %s", err))
+					}
+					if !setKubeletResolvConf(&initConfig.NodeRegistration, systemdResolvedResolvConf) {
+						return nil
+					}
+					if err := common.InitConfigToResourceData(d, initConfig); err != nil {
+						return ssh.ActionError(err.Error())
+					}
+
+				case "join":
+					joinConfig, _, err := common.JoinConfigFromResourceData(d)
+					if err != nil {
+						return ssh.ActionError(fmt.Sprintf("could not get a valid 'config' for join'ing: %s", err))
+					}
+					if !setKubeletResolvConf(&joinConfig.NodeRegistration, systemdResolvedResolvConf) {
+						return nil
+					}
+					if err := common.JoinConfigToResourceData(d, joinConfig); err != nil {
+						return ssh.ActionError(err.Error())
+					}
+				}
+				return ssh.DoMessageInfo("systemd-resolved stub resolver detected: the kubelet will use %q", systemdResolvedResolvConf)
+			}),
+			ssh.DoMessageWarn("/etc/resolv.conf points to %s but %s does not exist: CoreDNS could loop (consider setting some upstream DNS servers)",
+				systemdResolvedStubAddress, systemdResolvedResolvConf)))
+}
@@ -0,0 +1,44 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestSetKubeletResolvConf(t *testing.T) {
+	nodeRegistration := kubeadmapi.NodeRegistrationOptions{}
+	if !setKubeletResolvConf(&nodeRegistration, systemdResolvedResolvConf) {
+		t.Fatalf("Error: resolv-conf not set")
+	}
+	if nodeRegistration.KubeletExtraArgs["resolv-conf"] != systemdResolvedResolvConf {
+		t.Fatalf("Error: unexpected resolv-conf: %q", nodeRegistration.KubeletExtraArgs["resolv-conf"])
+	}
+
+	// the resolv.conf with the user-provided upstream servers has priority
+	nodeRegistration = kubeadmapi.NodeRegistrationOptions{
+		KubeletExtraArgs: map[string]string{"resolv-conf": common.DefResolvUpstreamConf},
+	}
+	if setKubeletResolvConf(&nodeRegistration, systemdResolvedResolvConf) {
+		t.Fatalf("Error: resolv-conf overwritten")
+	}
+	if nodeRegistration.KubeletExtraArgs["resolv-conf"] != common.DefResolvUpstreamConf {
+		t.Fatalf("Error: unexpected resolv-conf: %q", nodeRegistration.KubeletExtraArgs["resolv-conf"])
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+const (
+	// label set in the nodes where a runtime class is available
+	// (used by the RuntimeClass for scheduling pods in these nodes)
+	runtimeClassLabelPrefix = "runtimeclass.kubeadm.io/"
+
+	runtimeClassManifestTemplate = `apiVersion: %[4]s
+kind: RuntimeClass
+metadata:
+  name: %[1]s
+handler: %[2]s
+scheduling:
+  nodeSelector:
+    %[3]s%[1]s: "true"
+`
+)
+
+// runtimeClass is an additional runtime that can be used by pods with a RuntimeClass
+type runtimeClass struct {
+	// the name of the RuntimeClass
+	name string
+
+	// the containerd runtime handler
+	handler string
+
+	// the containerd runtime type
+	runtimeType string
+
+	// the containerd shim binary that must be installed in the node
+	shim string
+}
+
+var runtimeClasses = map[string]runtimeClass{
+	"gvisor": {
+		name:        "gvisor",
+		handler:     "runsc",
+		runtimeType: "io.containerd.runsc.v1",
+		shim:        "containerd-shim-runsc-v1",
+	},
+	"kata": {
+		name:        "kata",
+		handler:     "kata",
+		runtimeType: "io.containerd.kata.v2",
+		shim:        "containerd-shim-kata-v2",
+	},
+}
+
+// getRuntimeClassesFromResourceData returns the runtime classes to install in this node
+func getRuntimeClassesFromResourceData(d *schema.ResourceData) ([]runtimeClass, error) {
+	res := []runtimeClass{}
+	for _, name := range d.Get("runtime_classes").([]interface{}) {
+		rc, ok := runtimeClasses[name.(string)]
+		if !ok {
+			return nil, fmt.Errorf("unknown runtime class %q", name)
+		}
+		res = append(res, rc)
+	}
+	return res, nil
+}
+
+// getContainerdRuntimeSection returns the section for a runtime handler in the containerd configuration
+func getContainerdRuntimeSection(rc runtimeClass) string {
+	return fmt.Sprintf(`[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.%s]`, rc.handler)
+}
+
+// addContainerdRuntimeHandler adds the runtime handler to the containerd configuration,
+// returning the new configuration and true if it has been changed
+func addContainerdRuntimeHandler(config string, rc runtimeClass) (string, bool) {
+	section := getContainerdRuntimeSection(rc)
+	if strings.Contains(config, section) {
+		return config, false
+	}
+	return fmt.Sprintf("%s\n\n%s\n  runtime_type = %q\n", strings.TrimRight(config, "\n"), section, rc.runtimeType), true
+}
+
+// getRuntimeClassAPIVersion returns the RuntimeClass API for a Kubernetes version
+// (node.k8s.io/v1 is available since v1.20, and node.k8s.io/v1beta1 has been removed in v1.25)
+func getRuntimeClassAPIVersion(v string) string {
+	target, err := version.ParseGeneric(v)
+	if err == nil && target.AtLeast(version.MustParseGeneric("v1.20.0")) {
+		return "node.k8s.io/v1"
+	}
+	return "node.k8s.io/v1beta1"
+}
+
+// getRuntimeClassManifest returns the RuntimeClass object for a runtime class,
+// for a Kubernetes version
+func getRuntimeClassManifest(rc runtimeClass, kubeVersion string) string {
+	return fmt.Sprintf(runtimeClassManifestTemplate, rc.name, rc.handler, runtimeClassLabelPrefix,
+		getRuntimeClassAPIVersion(kubeVersion))
+}
+
+// doSetupRuntimeClasses configures the runtime handlers in containerd for
+// the runtime classes requested (ie, gVisor or Kata containers) in this node
+func doSetupRuntimeClasses(d *schema.ResourceData) ssh.Action {
+	rcs, err := getRuntimeClassesFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+	if len(rcs) == 0 {
+		return nil
+	}
+
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Setting up the runtime classes..."),
+		ssh.DoIf(
+			ssh.CheckNot(ssh.CheckBinaryExists("containerd")),
+			ssh.ActionError("runtime classes can only be used with containerd")),
+	}
+	for _, rc := range rcs {
+		rc := rc
+		actions = append(actions,
+			ssh.DoIf(
+				ssh.CheckNot(ssh.CheckBinaryExists(rc.shim)),
+				ssh.ActionError(fmt.Sprintf("%s not found: the %q runtime must be installed in this node", rc.shim, rc.name))),
+			doUpdateContainerdConfig(fmt.Sprintf("adding the %q runtime handler", rc.handler), func(config string) (string, bool) {
+				return addContainerdRuntimeHandler(config, rc)
+			}))
+	}
+	return actions
+}
+
+// doRegisterRuntimeClasses creates the RuntimeClass objects for the runtime classes
+// in this node, labeling the node so pods with these RuntimeClasses are scheduled here
+func doRegisterRuntimeClasses(d *schema.ResourceData) ssh.Action {
+	rcs, err := getRuntimeClassesFromResourceData(d)
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+	if len(rcs) == 0 {
+		return nil
+	}
+
+	labels := []string{}
+	manifests := []ssh.Manifest{}
+	for _, rc := range rcs {
+		labels = append(labels, fmt.Sprintf("%s%s=true", runtimeClassLabelPrefix, rc.name))
+		manifests = append(manifests, ssh.Manifest{Inline: getRuntimeClassManifest(rc, getKubeVersionFromResourceData(d))})
+	}
+
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		DoGetNodename(d, &node),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return ssh.ActionError("could not find the Kubernetes nodename: the runtime classes cannot be registered")
+			}
+			return ssh.ActionList{
+				ssh.DoMessageInfo("Registering the runtime classes in node %q...", node.Nodename),
+				doRemoteKubectl(d, append([]string{"label", "--overwrite", "node", node.Nodename}, labels...)...),
+				doRemoteKubectlApply(d, manifests),
+			}
+		}),
+	}
+}
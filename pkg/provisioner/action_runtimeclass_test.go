@@ -0,0 +1,61 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddContainerdRuntimeHandler(t *testing.T) {
+	config := `version = 2
+
+[plugins]
+  [plugins."io.containerd.grpc.v1.cri".containerd]
+    snapshotter = "overlayfs"
+`
+	res, changed := addContainerdRuntimeHandler(config, runtimeClasses["gvisor"])
+	if !changed {
+		t.Fatalf("Error: runtime handler not added")
+	}
+	expected := `[plugins."io.containerd.grpc.v1.cri".containerd.runtimes.runsc]
+  runtime_type = "io.containerd.runsc.v1"`
+	if !strings.HasPrefix(res, config) || !strings.Contains(res, expected) {
+		t.Fatalf("Error: unexpected configuration:\n%s", res)
+	}
+
+	if _, changed := addContainerdRuntimeHandler(res, runtimeClasses["gvisor"]); changed {
+		t.Fatalf("Error: runtime handler added twice")
+	}
+}
+
+func TestGetRuntimeClassManifest(t *testing.T) {
+	manifest := getRuntimeClassManifest(runtimeClasses["kata"], "v1.25.0")
+	for _, expected := range []string{
+		"apiVersion: node.k8s.io/v1\n",
+		"kind: RuntimeClass",
+		"name: kata",
+		"handler: kata",
+		`runtimeclass.kubeadm.io/kata: "true"`,
+	} {
+		if !strings.Contains(manifest, expected) {
+			t.Fatalf("Error: %q not found in manifest:\n%s", expected, manifest)
+		}
+	}
+
+	if manifest := getRuntimeClassManifest(runtimeClasses["kata"], "v1.15.0"); !strings.Contains(manifest, "apiVersion: node.k8s.io/v1beta1\n") {
+		t.Fatalf("Error: unexpected API version in manifest:\n%s", manifest)
+	}
+}
@@ -15,53 +15,207 @@
 package provisioner
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
+	"path"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 
+	"github.com/inercia/terraform-provider-kubeadm/internal/artifacts"
 	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
 	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
+// installRetry is used for retrying the installation scripts, as package managers fail
+// frequently with transient errors (ie, network blips or apt/yum locks)
+var installRetry = ssh.Retry{Times: 3, Interval: 10 * time.Second, Backoff: 2}
+
 // doKubeadmSetup tries to install kubeadm in the remote machine
 // the auto-installation can be
 // 1) our built-in auto-installation script
 // 2) a user-provided script in some path
 // 3) an inlined user-provided script
+// and/or the binaries can be downloaded (and cached) in the local machine and then uploaded.
 func doKubeadmSetup(d *schema.ResourceData) ssh.Action {
+	if _, ok := d.GetOk("install.0.binaries.0"); ok {
+		return ssh.ActionList{
+			doInstallBinaries(d),
+			doKubeadmSetupScript(d),
+		}
+	}
 	if _, ok := d.GetOk("install"); ok {
-		code := ""
-		descr := ""
-		auto := d.Get("install.0.auto").(bool)
-		inline := d.Get("install.0.inline").(string)
-		script := d.Get("install.0.script").(string)
-
-		if auto {
-			ssh.Debug("will upload the builtin auto-installation script")
-			descr = "Uploading and running built-in kubeadm installation script..."
-			code = assets.KubeadmSetupScriptCode
-		} else if len(inline) > 0 {
-			ssh.Debug("will upload auto-installation script from inlined script: %d bytes", len(inline))
-			descr = "Uploading and running inlined installation script..."
-			code = "#!/bin/sh\n" + inline
-		} else if len(script) > 0 {
-			ssh.Debug("will upload auto-installation from custom script from %q", script)
-			descr = fmt.Sprintf("Uploading and running custom kubeadm script from %s...", script)
-			contents, err := ioutil.ReadFile(script)
-			if err != nil {
-				errMsg := fmt.Sprintf("when reading kubeadm setup script %q: %s", script, err.Error())
-				return ssh.ActionError(errMsg)
+		return doKubeadmSetupScript(d)
+	}
+	return ssh.ActionList{
+		ssh.DoMessageWarn("no auto-installation: assuming kubeadm is installed in the target node."),
+	}
+}
+
+// doKubeadmSetupScript runs the installation script
+func doKubeadmSetupScript(d *schema.ResourceData) ssh.Action {
+	code := ""
+	descr := ""
+	auto := d.Get("install.0.auto").(bool)
+	inline := d.Get("install.0.inline").(string)
+	script := d.Get("install.0.script").(string)
+
+	if auto {
+		ssh.Debug("will upload the builtin auto-installation script")
+		descr = "Uploading and running built-in kubeadm installation script..."
+		code = assets.KubeadmSetupScriptCode
+	} else if len(inline) > 0 {
+		ssh.Debug("will upload auto-installation script from inlined script: %d bytes", len(inline))
+		descr = "Uploading and running inlined installation script..."
+		code = "#!/bin/sh\n" + inline
+	} else if len(script) > 0 {
+		ssh.Debug("will upload auto-installation from custom script from %q", script)
+		descr = fmt.Sprintf("Uploading and running custom kubeadm script from %s...", script)
+		contents, err := ioutil.ReadFile(script)
+		if err != nil {
+			errMsg := fmt.Sprintf("when reading kubeadm setup script %q: %s", script, err.Error())
+			return ssh.ActionError(errMsg)
+		}
+		code = string(contents)
+	}
+
+	if len(code) == 0 {
+		return nil
+	}
+
+	return ssh.ActionList{
+		ssh.DoMessage(descr),
+		ssh.DoRetry(installRetry, ssh.DoExecScript([]byte(code))),
+	}
+}
+
+// getBinariesArtifacts returns the list of artifacts to download for the "binaries" installation
+func getBinariesArtifacts(d *schema.ResourceData) []artifacts.Artifact {
+	return getBinariesArtifactsForVersion(d, d.Get("install.0.binaries.0.version").(string))
+}
+
+// getBinariesArtifactsForVersion returns the list of artifacts to download for
+// the "binaries" installation of a specific version (or the kubernetes version when empty)
+func getBinariesArtifactsForVersion(d *schema.ResourceData, version string) []artifacts.Artifact {
+	if version == "" {
+		version = getKubeVersionFromResourceData(d)
+	}
+	arch := d.Get("install.0.binaries.0.arch").(string)
+	if arch == "" {
+		arch = common.DefBinariesArch
+	}
+
+	res := []artifacts.Artifact{}
+	for _, bin := range []string{"kubeadm", "kubelet", "kubectl"} {
+		url := fmt.Sprintf(common.DefKubernetesBinariesURL, version, arch, bin)
+		res = append(res, artifacts.Artifact{
+			Name:        bin,
+			Version:     version,
+			Arch:        arch,
+			URL:         url,
+			ChecksumURL: url + ".sha256",
+		})
+	}
+
+	if cniVersion := d.Get("install.0.binaries.0.cni_version").(string); cniVersion != "" {
+		url := fmt.Sprintf(common.DefCNIPluginsURL, cniVersion, arch, cniVersion)
+		res = append(res, artifacts.Artifact{
+			Name:        "cni-plugins",
+			Version:     cniVersion,
+			Arch:        arch,
+			URL:         url,
+			ChecksumURL: url + ".sha256",
+		})
+	}
+
+	// apply the checksums and signature verifications configured for some artifacts
+	cosignPath := d.Get("install.0.binaries.0.cosign_path").(string)
+	for _, artifactRaw := range d.Get("install.0.binaries.0.artifact").([]interface{}) {
+		m := artifactRaw.(map[string]interface{})
+		for i := range res {
+			if res[i].Name != m["name"].(string) {
+				continue
+			}
+			if checksum := m["checksum"].(string); checksum != "" {
+				res[i].Checksum = checksum
+			}
+			if m["key"].(string) != "" || m["certificate_url"].(string) != "" {
+				res[i].Verification = &artifacts.Verification{
+					CosignPath:            cosignPath,
+					SignatureURL:          m["signature_url"].(string),
+					Key:                   m["key"].(string),
+					CertificateURL:        m["certificate_url"].(string),
+					CertificateIdentity:   m["certificate_identity"].(string),
+					CertificateOIDCIssuer: m["certificate_oidc_issuer"].(string),
+				}
 			}
-			code = string(contents)
 		}
+	}
+	return res
+}
 
-		return ssh.ActionList{
-			ssh.DoMessage(descr),
-			ssh.DoExecScript([]byte(code)),
+// doInstallBinaries downloads the kubernetes binaries (and the CNI plugins) in
+// the local machine, using a local cache, and uploads them to the remote machine
+func doInstallBinaries(d *schema.ResourceData) ssh.Action {
+	return doInstallBinariesForVersion(d, d.Get("install.0.binaries.0.version").(string))
+}
+
+// doInstallBinariesForVersion installs the binaries of a specific version
+// (or the kubernetes version when empty)
+func doInstallBinariesForVersion(d *schema.ResourceData, version string) ssh.Action {
+	cache, err := artifacts.NewCache(d.Get("install.0.binaries.0.cache_dir").(string))
+	if err != nil {
+		return ssh.ActionError(err.Error())
+	}
+
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Installing binaries (using cache at %q)...", cache.Dir()),
+	}
+
+	requireSignatures := d.Get("install.0.binaries.0.require_signatures").(bool)
+	binaries := getBinariesArtifactsForVersion(d, version)
+	for _, a := range binaries {
+		if requireSignatures && a.Verification == nil {
+			return ssh.ActionError(fmt.Sprintf("signatures are required but no 'key' or 'certificate_url' has been provided for %q", a.Name))
 		}
 	}
-	return ssh.ActionList{
-		ssh.DoMessageWarn("no auto-installation: assuming kubeadm is installed in the target node."),
+
+	for _, a := range binaries {
+		a := a
+		local := ""
+		actions = append(actions,
+			artifacts.DoGetArtifact(cache, a, &local),
+			ssh.ActionFunc(func(context.Context) ssh.Action {
+				if a.Name == "cni-plugins" {
+					return doInstallCNIPluginsTarball(d, local)
+				}
+				remote := path.Join(common.DefBinariesDir, a.Name)
+				return ssh.ActionList{
+					ssh.DoMessageInfo("Uploading %s to %q", a, remote),
+					ssh.DoUploadFileToFileIfChanged(local, remote),
+					ssh.DoExecCommand(ssh.NewCommand("chmod", "755", remote)),
+				}
+			}))
 	}
+	return actions
+}
+
+// doInstallCNIPluginsTarball uploads the CNI plugins tarball and extracts it in the CNI bin dir
+func doInstallCNIPluginsTarball(d *schema.ResourceData, local string) ssh.Action {
+	binDir := common.DefCniBinDir
+	if dir, ok := common.GetProvisionerConfig(d)["cni_bin_dir"].(string); ok && dir != "" {
+		binDir = dir
+	}
+
+	return ssh.DoWithTempFilename(func(remote string) ssh.Action {
+		return ssh.DoWithCleanup(
+			ssh.ActionList{
+				ssh.DoMessageInfo("Installing CNI plugins in %q", binDir),
+				ssh.DoUploadFileToFile(local, remote),
+				ssh.DoExecCommand(ssh.NewShellCommand(`mkdir -p "$1" && tar -xzf "$2" -C "$1"`, binDir, remote)),
+			},
+			ssh.DoTry(ssh.DoDeleteFile(remote)))
+	})
 }
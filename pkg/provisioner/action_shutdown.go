@@ -0,0 +1,122 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// getKubeletShutdownFromResourceData returns the graceful node shutdown
+// configuration passed from the provider
+func getKubeletShutdownFromResourceData(d *schema.ResourceData) common.KubeletShutdown {
+	config := common.GetProvisionerConfig(d)
+	gracePeriod, _ := config["kubelet_shutdown_grace_period"].(string)
+	criticalPods, _ := config["kubelet_shutdown_grace_period_critical_pods"].(string)
+
+	shutdown, err := common.ParseKubeletShutdown(gracePeriod, criticalPods)
+	if err != nil {
+		ssh.Debug("ignoring the graceful node shutdown configuration: %s", err)
+		return common.KubeletShutdown{}
+	}
+	return shutdown
+}
+
+// setKubeletShutdownConfig sets the graceful node shutdown settings in the
+// kubelet configuration file, returning true if the configuration has changed
+func setKubeletShutdownConfig(config string, shutdown common.KubeletShutdown) (string, bool) {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimRight(config, "\n"), "\n") {
+		if strings.HasPrefix(line, "shutdownGracePeriod:") || strings.HasPrefix(line, "shutdownGracePeriodCriticalPods:") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	res := strings.Join(lines, "\n") + "\n" + shutdown.GetKubeletConfig()
+	return res, res != config
+}
+
+// doConfigureShutdownInhibitor raises the maximum delay systemd-logind allows for the
+// shutdown inhibitor locks, as the kubelet cannot delay the node shutdown longer than that
+func doConfigureShutdownInhibitor(d *schema.ResourceData) ssh.Action {
+	shutdown := getKubeletShutdownFromResourceData(d)
+	if !shutdown.IsEnabled() {
+		return nil
+	}
+
+	config := shutdown.GetLogindConfig()
+	var buf bytes.Buffer
+	return ssh.DoIfElse(
+		ssh.CheckServiceExists("systemd-logind.service"),
+		ssh.ActionList{
+			doReadRemoteConfig(ssh.NewShellCommand(`cat "$1" 2>/dev/null || true`, common.DefLogindKubeletConfPath), &buf),
+			ssh.ActionFunc(func(context.Context) ssh.Action {
+				if buf.String() == config {
+					ssh.Debug("systemd-logind is already configured for the graceful node shutdown")
+					return nil
+				}
+				return ssh.ActionList{
+					ssh.DoMessageInfo("Configuring systemd-logind for the graceful node shutdown..."),
+					ssh.DoMkdirOnce(path.Dir(common.DefLogindKubeletConfPath)),
+					ssh.DoUploadBytesToFile([]byte(config), common.DefLogindKubeletConfPath),
+					ssh.DoRestartService("systemd-logind.service"),
+				}
+			}),
+		},
+		ssh.DoMessageWarn("systemd-logind not found: the kubelet will not be able to delay the node shutdown"))
+}
+
+// doConfigureKubeletShutdown sets the graceful node shutdown settings in the kubelet
+// configuration written by kubeadm (these settings cannot be passed as flags),
+// restarting the kubelet when it has changed (if requested)
+func doConfigureKubeletShutdown(d *schema.ResourceData, restart bool) ssh.Action {
+	shutdown := getKubeletShutdownFromResourceData(d)
+	if !shutdown.IsEnabled() {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		doReadRemoteConfig(ssh.NewShellCommand(`cat "$1" 2>/dev/null || true`, common.DefKubeletConfigPath), &buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if strings.TrimSpace(buf.String()) == "" {
+				return ssh.DoMessageWarn("no kubelet configuration found at %s: the graceful node shutdown cannot be configured",
+					common.DefKubeletConfigPath)
+			}
+			config, changed := setKubeletShutdownConfig(buf.String(), shutdown)
+			if !changed {
+				ssh.Debug("the kubelet is already configured for the graceful node shutdown")
+				return nil
+			}
+
+			actions := ssh.ActionList{
+				ssh.DoMessageInfo("Setting a shutdown grace period of %s in the kubelet...", shutdown.GracePeriod),
+				ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(config), common.DefKubeletConfigPath),
+			}
+			if restart {
+				return doInMaintenanceWindow("restarting the kubelet",
+					append(actions, ssh.DoRestartService("kubelet.service")))
+			}
+			return actions
+		}),
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+func TestSetKubeletShutdownConfig(t *testing.T) {
+	shutdown := common.KubeletShutdown{GracePeriod: 30 * time.Second, CriticalPodsGracePeriod: 10 * time.Second}
+
+	config := "apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\nshutdownGracePeriod: 0s\n"
+	res, changed := setKubeletShutdownConfig(config, shutdown)
+	if !changed {
+		t.Fatalf("Error: the configuration has not been changed")
+	}
+	expected := "apiVersion: kubelet.config.k8s.io/v1beta1\nkind: KubeletConfiguration\n" +
+		"shutdownGracePeriod: 30s\nshutdownGracePeriodCriticalPods: 10s\n"
+	if res != expected {
+		t.Fatalf("Error: unexpected configuration:\n%s", res)
+	}
+
+	if _, changed := setKubeletShutdownConfig(res, shutdown); changed {
+		t.Fatalf("Error: the configuration has been changed twice")
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+const (
+	containerdConfigPath = "/etc/containerd/config.toml"
+
+	dockerDaemonConfigPath = "/etc/docker/daemon.json"
+
+	// the section where the snapshotter is set when there is no `snapshotter` in the containerd config
+	containerdCRISection = `[plugins."io.containerd.grpc.v1.cri".containerd]`
+
+	// script for detecting the filesystem where the images will be stored, if we are running
+	// in a user namespace (ie, an unprivileged LXC container) and if `fuse-overlayfs` is available
+	storageDetectionScript = `for d in /var/lib/containerd /var/lib/docker /var/lib ; do [ -d $d ] && break ; done ; ` +
+		`echo "fstype=$(stat -f -c %T $d)" ; ` +
+		`echo "uidmap=$(head -n1 /proc/self/uid_map)" ; ` +
+		`command -v fuse-overlayfs >/dev/null && echo "fuse=yes" ; true`
+)
+
+var (
+	// storage drivers used by docker for each containerd snapshotter
+	dockerStorageDrivers = map[string]string{
+		"overlayfs":      "overlay2",
+		"fuse-overlayfs": "fuse-overlayfs",
+		"zfs":            "zfs",
+		"btrfs":          "btrfs",
+		"native":         "vfs",
+	}
+
+	containerdSnapshotterRe = regexp.MustCompile(`(?m)^(\s*snapshotter\s*=\s*)"[^"]*"`)
+)
+
+// storageInfo is the information about the storage in the node
+type storageInfo struct {
+	// the filesystem type (as reported by `stat -f`)
+	fsType string
+
+	// we are running in a user namespace (ie, in an unprivileged container)
+	userNS bool
+
+	// `fuse-overlayfs` is available
+	fuseOverlayfs bool
+}
+
+// parseStorageInfo parses the output of the storage detection command
+func parseStorageInfo(output string) storageInfo {
+	info := storageInfo{}
+	for _, line := range strings.Split(output, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "fstype":
+			info.fsType = strings.TrimSpace(kv[1])
+		case "uidmap":
+			// the initial user namespace maps the full range of UIDs
+			fields := strings.Fields(kv[1])
+			info.userNS = len(fields) == 3 && (fields[0] != "0" || fields[1] != "0" || fields[2] != "4294967295")
+		case "fuse":
+			info.fuseOverlayfs = strings.TrimSpace(kv[1]) == "yes"
+		}
+	}
+	return info
+}
+
+// getSnapshotter returns the containerd snapshotter for the storage in the node
+func getSnapshotter(info storageInfo) string {
+	switch info.fsType {
+	case "zfs":
+		return "zfs"
+	case "btrfs":
+		return "btrfs"
+	}
+
+	// overlayfs cannot be used in unprivileged containers, nor on top of another overlayfs
+	if info.userNS || strings.HasPrefix(info.fsType, "overlay") {
+		if info.fuseOverlayfs {
+			return "fuse-overlayfs"
+		}
+		return "native"
+	}
+	return "overlayfs"
+}
+
+// setContainerdSnapshotter sets the snapshotter in the containerd configuration,
+// returning the new configuration and true if it has been changed
+func setContainerdSnapshotter(config string, snapshotter string) (string, bool) {
+	var res string
+	value := fmt.Sprintf("%q", snapshotter)
+	if containerdSnapshotterRe.MatchString(config) {
+		res = containerdSnapshotterRe.ReplaceAllString(config, "${1}"+value)
+	} else if strings.Contains(config, containerdCRISection) {
+		res = strings.Replace(config, containerdCRISection,
+			fmt.Sprintf("%s\n  snapshotter = %s", containerdCRISection, value), 1)
+	} else {
+		res = fmt.Sprintf("%s\n%s\n  snapshotter = %s\n", strings.TrimRight(config, "\n"), containerdCRISection, value)
+	}
+	return res, res != config
+}
+
+// setDockerStorageDriver sets the storage driver in the docker daemon configuration,
+// returning the new configuration and true if it has been changed
+func setDockerStorageDriver(config string, driver string) (string, bool, error) {
+	daemon := map[string]interface{}{}
+	if strings.TrimSpace(config) != "" {
+		if err := json.Unmarshal([]byte(config), &daemon); err != nil {
+			return "", false, fmt.Errorf("could not parse %s: %s", dockerDaemonConfigPath, err)
+		}
+	}
+	if current, ok := daemon["storage-driver"].(string); ok && current == driver {
+		return config, false, nil
+	}
+
+	daemon["storage-driver"] = driver
+	res, err := json.MarshalIndent(daemon, "", "  ")
+	if err != nil {
+		return "", false, err
+	}
+	return string(res) + "\n", true, nil
+}
+
+// doReadRemoteConfig runs a command that prints some configuration file, collecting the output
+func doReadRemoteConfig(command ssh.Command, buf *bytes.Buffer) ssh.Action {
+	// the output is received line by line, without the line breaks
+	return ssh.DoSendingExecOutputToFunc(ssh.DoExecCommand(command), func(s string) {
+		buf.WriteString(s)
+		buf.WriteByte('\n')
+	})
+}
+
+// doUpdateContainerdConfig updates the containerd configuration with `update`
+// (generating the default configuration when it does not exist), restarting
+// containerd when it has been changed
+func doUpdateContainerdConfig(description string, update func(string) (string, bool)) ssh.Action {
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		doReadRemoteConfig(ssh.NewShellCommand(`cat "$1" 2>/dev/null || containerd config default`, containerdConfigPath), &buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			config, changed := update(buf.String())
+			if !changed {
+				ssh.Debug("containerd configuration unchanged: %s", description)
+				return nil
+			}
+			return doInMaintenanceWindow("updating the containerd configuration", ssh.ActionList{
+				ssh.DoMessageInfo("Updating the containerd configuration: %s", description),
+				ssh.DoMkdir("/etc/containerd"),
+				ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(config), containerdConfigPath),
+				ssh.DoIf(
+					ssh.CheckServiceExists("containerd.service"),
+					ssh.DoRestartService("containerd.service")),
+			})
+		}),
+	}
+}
+
+// doSetContainerdSnapshotter sets the snapshotter in the containerd configuration
+func doSetContainerdSnapshotter(snapshotter string) ssh.Action {
+	return doUpdateContainerdConfig(fmt.Sprintf("using the %q snapshotter", snapshotter), func(config string) (string, bool) {
+		return setContainerdSnapshotter(config, snapshotter)
+	})
+}
+
+// doSetDockerStorageDriver sets the storage driver in the docker daemon configuration,
+// restarting docker when it has been changed
+func doSetDockerStorageDriver(driver string) ssh.Action {
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		doReadRemoteConfig(ssh.NewShellCommand(`cat "$1" 2>/dev/null || true`, dockerDaemonConfigPath), &buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			config, changed, err := setDockerStorageDriver(buf.String(), driver)
+			if err != nil {
+				return ssh.ActionError(err.Error())
+			}
+			if !changed {
+				ssh.Debug("docker is already using the %q storage driver", driver)
+				return nil
+			}
+			return doInMaintenanceWindow("changing the docker storage driver", ssh.ActionList{
+				ssh.DoMessageInfo("Setting the docker storage driver to %q", driver),
+				ssh.DoMkdir("/etc/docker"),
+				ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(config), dockerDaemonConfigPath),
+				ssh.DoRestartService("docker.service"),
+			})
+		}),
+	}
+}
+
+// doConfigureStorageDriver configures the snapshotter in containerd (and the storage driver
+// in docker) for the filesystem where images are stored (ie, zfs, btrfs, or fuse-overlayfs
+// in unprivileged containers), as the default overlayfs leads to cryptic errors when
+// unpacking images in these hosts. A specific driver can be forced with "runtime.storage_driver".
+func doConfigureStorageDriver(d *schema.ResourceData) ssh.Action {
+	driver, _ := common.GetProvisionerConfig(d)["runtime_storage_driver"].(string)
+	if driver == "" || driver == "none" {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	return ssh.ActionList{
+		doReadRemoteConfig(ssh.NewShellCommand(storageDetectionScript), &buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			snapshotter := driver
+			if driver == "auto" {
+				info := parseStorageInfo(buf.String())
+				snapshotter = getSnapshotter(info)
+				ssh.Debug("storage detected: %+v: using the %q snapshotter", info, snapshotter)
+			}
+
+			actions := ssh.ActionList{}
+			if snapshotter == "native" {
+				actions = append(actions,
+					ssh.DoMessageWarn("overlayfs cannot be used in this node and fuse-overlayfs is not installed: "+
+						"using the (slow) native snapshotter"))
+			}
+			return append(actions,
+				ssh.DoIf(
+					ssh.CheckBinaryExists("containerd"),
+					doSetContainerdSnapshotter(snapshotter)),
+				ssh.DoIf(
+					ssh.CheckServiceExists("docker.service"),
+					doSetDockerStorageDriver(dockerStorageDrivers[snapshotter])))
+		}),
+	}
+}
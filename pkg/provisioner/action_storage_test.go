@@ -0,0 +1,105 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGetSnapshotter(t *testing.T) {
+	cases := []struct {
+		output   string
+		expected string
+	}{
+		{
+			output:   "fstype=ext2/ext3\nuidmap=         0          0 4294967295\n",
+			expected: "overlayfs",
+		},
+		{
+			output:   "fstype=zfs\nuidmap=         0          0 4294967295\n",
+			expected: "zfs",
+		},
+		{
+			output:   "fstype=btrfs\nuidmap=         0          0 4294967295\nfuse=yes\n",
+			expected: "btrfs",
+		},
+		{
+			// unprivileged LXC container
+			output:   "fstype=ext2/ext3\nuidmap=         0     100000      65536\nfuse=yes\n",
+			expected: "fuse-overlayfs",
+		},
+		{
+			// nested overlayfs, without fuse-overlayfs
+			output:   "fstype=overlayfs\nuidmap=         0          0 4294967295\n",
+			expected: "native",
+		},
+	}
+
+	for _, c := range cases {
+		if res := getSnapshotter(parseStorageInfo(c.output)); res != c.expected {
+			t.Fatalf("Error: unexpected snapshotter for %q: %q (expected %q)", c.output, res, c.expected)
+		}
+	}
+}
+
+func TestSetContainerdSnapshotter(t *testing.T) {
+	config := `version = 2
+
+[plugins]
+  [plugins."io.containerd.grpc.v1.cri"]
+    [plugins."io.containerd.grpc.v1.cri".containerd]
+      snapshotter = "overlayfs"
+      default_runtime_name = "runc"
+`
+	res, changed := setContainerdSnapshotter(config, "zfs")
+	if !changed {
+		t.Fatalf("Error: snapshotter not changed")
+	}
+	if !strings.Contains(res, `      snapshotter = "zfs"`) || strings.Contains(res, "overlayfs") {
+		t.Fatalf("Error: unexpected configuration:\n%s", res)
+	}
+
+	if _, changed := setContainerdSnapshotter(res, "zfs"); changed {
+		t.Fatalf("Error: configuration changed when the snapshotter was already set")
+	}
+
+	// no snapshotter in the configuration
+	res, changed = setContainerdSnapshotter("version = 2\n", "fuse-overlayfs")
+	if !changed {
+		t.Fatalf("Error: snapshotter not set")
+	}
+	if !strings.Contains(res, containerdCRISection+"\n  snapshotter = \"fuse-overlayfs\"") {
+		t.Fatalf("Error: unexpected configuration:\n%s", res)
+	}
+}
+
+func TestSetDockerStorageDriver(t *testing.T) {
+	res, changed, err := setDockerStorageDriver(`{"log-driver": "journald"}`, "zfs")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if !changed || !strings.Contains(res, `"storage-driver": "zfs"`) || !strings.Contains(res, `"log-driver": "journald"`) {
+		t.Fatalf("Error: unexpected configuration:\n%s", res)
+	}
+
+	if _, changed, _ := setDockerStorageDriver(res, "zfs"); changed {
+		t.Fatalf("Error: configuration changed when the storage driver was already set")
+	}
+
+	if _, _, err := setDockerStorageDriver("{ invalid", "zfs"); err == nil {
+		t.Fatalf("Error: invalid configuration not detected")
+	}
+}
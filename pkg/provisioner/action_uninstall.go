@@ -0,0 +1,101 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+var (
+	// directories with state that must be removed when uninstalling
+	uninstallStateDirs = []string{
+		common.DefKubernetesConfigDir,
+		"/var/lib/kubelet",
+		"/var/lib/etcd",
+	}
+)
+
+// getUninstallPaths returns the list of files and directories that must be removed when uninstalling
+func getUninstallPaths(d *schema.ResourceData) []string {
+	paths := append([]string{}, uninstallStateDirs...)
+	paths = append(paths,
+		getSysconfigPathFromResourceData(d),
+		getServicePathFromResourceData(d),
+		getDropinPathFromResourceData(d),
+		common.DefResolvUpstreamConf,
+		common.DefDriftWatchdogPath,
+		common.DefDriftWatchdogServicePath,
+		common.DefDriftWatchdogTimerPath)
+
+	config := common.GetProvisionerConfig(d)
+	if dir, ok := config["cni_conf_dir"].(string); ok && dir != "" {
+		paths = append(paths, dir)
+	} else {
+		paths = append(paths, common.DefCniConfDir)
+	}
+
+	// binaries uploaded by us
+	if _, ok := d.GetOk("install.0.binaries.0"); ok {
+		for _, a := range getBinariesArtifacts(d) {
+			if a.Name == "cni-plugins" {
+				if dir, ok := config["cni_bin_dir"].(string); ok && dir != "" {
+					paths = append(paths, dir)
+				} else {
+					paths = append(paths, common.DefCniBinDir)
+				}
+				continue
+			}
+			paths = append(paths, path.Join(common.DefBinariesDir, a.Name))
+		}
+	}
+	return paths
+}
+
+// doUninstall removes everything installed in the node: packages (and repositories)
+// installed by the setup script, binaries, systemd units and configuration directories,
+// leaving the machine ready for being reused outside Kubernetes.
+// Errors are ignored, as some of these things could have not been installed.
+func doUninstall(d *schema.ResourceData) ssh.Action {
+	actions := ssh.ActionList{
+		ssh.DoMessageInfo("Uninstalling Kubernetes components from this node..."),
+		doCheckClusterID(d),
+		ssh.DoTry(doExecKubeadmWithConfig(d, "reset", "", "--force")),
+		ssh.DoTry(ssh.DoDisableService("kubelet.service")),
+		ssh.DoTry(ssh.DoDisableService(driftWatchdogTimer)),
+	}
+
+	if d.Get("install.0.auto").(bool) {
+		actions = append(actions,
+			ssh.DoMessageInfo("Removing packages and repositories..."),
+			ssh.DoTry(ssh.DoExecScript([]byte(assets.KubeadmUninstallScriptCode))))
+	}
+
+	paths := getUninstallPaths(d)
+	ssh.Debug("will remove: %s", strings.Join(paths, ", "))
+
+	return append(actions,
+		ssh.DoMessageInfo("Removing binaries, services and configuration..."),
+		ssh.DoTry(ssh.DoExecCommand(ssh.NewCommand("rm", "-rf").WithArgs(paths...))),
+		ssh.DoTry(ssh.DoExec("systemctl --no-pager daemon-reload")),
+		ssh.DoFlushCache(),
+		ssh.DoMessageInfo("Node uninstalled"))
+}
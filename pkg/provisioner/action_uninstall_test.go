@@ -0,0 +1,58 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestGetUninstallPaths(t *testing.T) {
+	raw := map[string]interface{}{
+		"install": []interface{}{
+			map[string]interface{}{
+				"binaries": []interface{}{
+					map[string]interface{}{
+						"version":     "v1.15.0",
+						"cni_version": "v0.8.2",
+					},
+				},
+			},
+		},
+	}
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	paths := getUninstallPaths(d)
+	for _, expected := range []string{
+		"/etc/kubernetes",
+		"/var/lib/kubelet",
+		"/usr/bin/kubeadm",
+		"/usr/bin/kubelet",
+		"/opt/cni/bin",
+		"/etc/cni/net.d",
+	} {
+		found := false
+		for _, p := range paths {
+			if p == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("Error: %q not found in %v", expected, paths)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
+)
+
+// upgradePhase is a step in the upgrade of a node. Completed phases are recorded
+// in the node, so an interrupted upgrade can be resumed.
+type upgradePhase struct {
+	name   string
+	action ssh.Action
+}
+
+// getUpgradeStateFromResourceData returns the file where completed phases are recorded
+func getUpgradeStateFromResourceData(d *schema.ResourceData) string {
+	if state := d.Get("upgrade.0.state_file").(string); state != "" {
+		return state
+	}
+	return common.DefUpgradeStatePath
+}
+
+// getUpgradePhaseMarker returns the line recorded for a completed phase
+func getUpgradePhaseMarker(version string, phase string) string {
+	return fmt.Sprintf("%s %s", version, phase)
+}
+
+// getUpgradePhases returns the phases for upgrading a node, in the same order
+// recommended by the kubeadm documentation: the first control plane node runs
+// `kubeadm upgrade apply`, while the other nodes run `kubeadm upgrade node`.
+func getUpgradePhases(d *schema.ResourceData, version string, nodename string) []upgradePhase {
+	phases := []upgradePhase{}
+
+	if _, ok := d.GetOk("install.0.binaries.0"); ok {
+		phases = append(phases, upgradePhase{"binaries", doInstallBinariesForVersion(d, version)})
+	} else {
+		phases = append(phases, upgradePhase{"binaries",
+			ssh.DoMessageWarn("no 'binaries' installation: assuming kubeadm and kubelet %s are installed in the node", version)})
+	}
+
+	kubeadm := getKubeadmFromResourceData(d)
+	if len(getJoinFromResourceData(d)) == 0 {
+		phases = append(phases, upgradePhase{"kubeadm",
+			ssh.DoWithTimeout(ssh.DoExecCommand(ssh.NewCommand(kubeadm, "upgrade", "apply", "--yes", version)), kubeadmTimeouts["upgrade"])})
+	} else {
+		phases = append(phases, upgradePhase{"kubeadm",
+			ssh.DoWithTimeout(ssh.DoExecCommand(ssh.NewCommand(kubeadm, "upgrade", "node")), kubeadmTimeouts["upgrade"])})
+	}
+
+	return append(phases,
+		upgradePhase{"drain", doRemoteKubectl(d, "drain", "--delete-local-data=true", "--force=true", "--ignore-daemonsets=true", nodename)},
+		upgradePhase{"kubelet", ssh.ActionList{
+			// (kubeadm rewrites the kubelet configuration when upgrading)
+			doConfigureKubeletShutdown(d, false),
+			doFixRemovedKubeletFlags(version),
+			ssh.DoExec("systemctl daemon-reload && systemctl restart kubelet"),
+		}},
+		upgradePhase{"uncordon", doRemoteKubectl(d, "uncordon", nodename)},
+	)
+}
+
+// kubeadmConfigMap is the (partial) `kubeadm-config` ConfigMap returned by kubectl
+type kubeadmConfigMap struct {
+	Data map[string]string `json:"data"`
+}
+
+// doFixRemovedFlags checks the components' flags in the configuration before upgrading
+// to some version. Flags without a replacement abort the upgrade before touching the node.
+// In the seeder, the removed flags are also fixed in the configuration stored in the
+// cluster (keeping everything else as it is), as `kubeadm upgrade apply` regenerates the
+// control plane manifests from it.
+func doFixRemovedFlags(d *schema.ResourceData, version string) ssh.Action {
+	return ssh.ActionFunc(func(context.Context) ssh.Action {
+		actions := ssh.ActionList{}
+
+		initConfig, _, err := common.InitConfigFromResourceData(d)
+		if err != nil {
+			actions = append(actions, ssh.DoMessageWarn("no valid 'config': flags removed in %s cannot be checked", version))
+		} else {
+			warnings, err := common.FixInitConfigForVersion(initConfig, version)
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("cannot upgrade to %s: %s", version, err))
+			}
+			for _, w := range warnings {
+				actions = append(actions, ssh.DoMessageWarn("%s", w))
+			}
+		}
+
+		if len(getJoinFromResourceData(d)) == 0 {
+			actions = append(actions, doFixRemovedFlagsInCluster(d, version))
+		}
+		return actions
+	})
+}
+
+// doFixRemovedFlagsInCluster fixes the removed flags in the ClusterConfiguration
+// stored in the `kubeadm-config` ConfigMap
+func doFixRemovedFlagsInCluster(d *schema.ResourceData, version string) ssh.Action {
+	cm := kubeadmConfigMap{}
+	return ssh.ActionList{
+		ssh.DoSendingExecOutputToJSON(
+			doRemoteKubectl(d, "get", "configmap", common.DefKubeadmConfigMap, "--namespace=kube-system", "--output=json"),
+			&cm),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			current, ok := cm.Data["ClusterConfiguration"]
+			if !ok {
+				return ssh.ActionError(fmt.Sprintf("no ClusterConfiguration found in the %q ConfigMap", common.DefKubeadmConfigMap))
+			}
+			fixed, warnings, err := common.FixClusterConfigurationForVersion(current, version)
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("cannot upgrade to %s: %s", version, err))
+			}
+			if len(warnings) == 0 {
+				return nil
+			}
+
+			data := map[string]string{}
+			for k, v := range cm.Data {
+				data[k] = v
+			}
+			data["ClusterConfiguration"] = fixed
+			manifest, err := yaml.Marshal(map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":      common.DefKubeadmConfigMap,
+					"namespace": "kube-system",
+				},
+				"data": data,
+			})
+			if err != nil {
+				return ssh.ActionError(fmt.Sprintf("could not serialize the %q ConfigMap: %s", common.DefKubeadmConfigMap, err))
+			}
+
+			actions := ssh.ActionList{}
+			for _, w := range warnings {
+				actions = append(actions, ssh.DoMessageWarn("%s", w))
+			}
+			return append(actions,
+				ssh.DoMessageInfo("Fixing the removed flags in the configuration stored in the cluster..."),
+				doRemoteKubectlApply(d, []ssh.Manifest{{Inline: string(manifest)}}))
+		}),
+	}
+}
+
+// doFixRemovedKubeletFlags fixes the removed flags in the kubelet flags written by kubeadm
+// in the node, so the new kubelet can start
+func doFixRemovedKubeletFlags(version string) ssh.Action {
+	var buf bytes.Buffer
+	return ssh.DoIf(
+		ssh.CheckFileExists(common.DefKubeletFlagsEnvPath),
+		ssh.ActionList{
+			ssh.DoSendingExecOutputToWriter(ssh.DoExecCommand(ssh.NewCommand("cat", common.DefKubeletFlagsEnvPath)), &buf),
+			ssh.ActionFunc(func(context.Context) ssh.Action {
+				fixed, warnings, err := common.FixKubeletFlagsEnvForVersion(buf.String(), version)
+				if err != nil {
+					return ssh.ActionError(fmt.Sprintf("cannot upgrade to %s: %s", version, err))
+				}
+				if len(warnings) == 0 {
+					return nil
+				}
+
+				actions := ssh.ActionList{}
+				for _, w := range warnings {
+					actions = append(actions, ssh.DoMessageWarn("%s", w))
+				}
+				return append(actions,
+					ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(fixed), common.DefKubeletFlagsEnvPath))
+			}),
+		})
+}
+
+// doUpgradePhase runs an upgrade phase, unless it has been already completed
+func doUpgradePhase(stateFile string, version string, phase upgradePhase) ssh.Action {
+	marker := getUpgradePhaseMarker(version, phase.name)
+	return ssh.DoIfElse(
+		ssh.CheckExecCommand(ssh.NewCommand("grep", "-q", "-x", "--", marker, stateFile)),
+		ssh.DoMessageInfo("Upgrade phase %q already completed: skipping", phase.name),
+		ssh.ActionList{
+			ssh.DoMessageInfo("Upgrade phase %q...", phase.name),
+			ssh.DoRefreshSession(),
+			phase.action,
+			ssh.DoExecCommand(ssh.NewShellCommand(`echo "$1" >> "$2"`, marker, stateFile)),
+		})
+}
+
+// checkNodeMatchesSelector checks if a node matches a label selector (always true for an empty selector)
+func checkNodeMatchesSelector(d *schema.ResourceData, nodename string, selector string) ssh.CheckerFunc {
+	return ssh.CheckerFunc(func(ctx context.Context) (bool, error) {
+		if selector == "" {
+			return true, nil
+		}
+		var buf bytes.Buffer
+		res := ssh.DoSendingExecOutputToWriter(
+			doRemoteKubectl(d, "get", "node", nodename, "--selector="+selector, "--output=name"),
+			&buf).Apply(ctx)
+		if ssh.IsError(res) {
+			return false, res
+		}
+		return strings.Contains(buf.String(), "node/"+nodename), nil
+	})
+}
+
+// canaryNodes is the (partial) list of canary nodes returned by kubectl
+type canaryNodes struct {
+	Items []struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	} `json:"items"`
+}
+
+// checkCanariesValidated checks that all the canary nodes have been validated for a version
+func checkCanariesValidated(canaries canaryNodes, version string) error {
+	if len(canaries.Items) == 0 {
+		return fmt.Errorf("no canary nodes found")
+	}
+	for _, canary := range canaries.Items {
+		if validated := canary.Metadata.Annotations[common.DefAnnotationUpgradeCanaryKey]; validated != version {
+			return fmt.Errorf("canary node %q has not been validated for %s", canary.Metadata.Name, version)
+		}
+	}
+	return nil
+}
+
+// doCheckCanariesValidated checks that all the canary nodes have been
+// upgraded and validated for this version
+func doCheckCanariesValidated(d *schema.ResourceData, version string, selector string) ssh.Action {
+	canaries := canaryNodes{}
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Checking the canary nodes (%q) have been validated...", selector),
+		ssh.DoSendingExecOutputToJSON(
+			doRemoteKubectl(d, "get", "nodes", "--selector="+selector, "--output=json"),
+			&canaries),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if err := checkCanariesValidated(canaries, version); err != nil {
+				return ssh.ActionError(fmt.Sprintf("%s: the node will not be upgraded", err))
+			}
+			return nil
+		}),
+	}
+}
+
+// doValidateCanary validates an upgraded canary node with some built-in health
+// checks (the node is Ready and running the new kubelet) and the user-provided
+// check, recording the validation in a node annotation
+func doValidateCanary(d *schema.ResourceData, nodename string, version string) ssh.Action {
+	timeout := d.Get("upgrade_canary.0.timeout").(string)
+	check := d.Get("upgrade_canary.0.check").(string)
+
+	var buf bytes.Buffer
+	validation := ssh.ActionList{
+		ssh.DoMessageInfo("Validating canary node %q...", nodename),
+		doRemoteKubectl(d, "wait", "--for=condition=Ready", fmt.Sprintf("--timeout=%s", timeout), "node/"+nodename),
+		ssh.DoSendingExecOutputToWriter(
+			doRemoteKubectl(d, "get", "node", nodename, "--output=jsonpath={.status.nodeInfo.kubeletVersion}"),
+			&buf),
+		ssh.ActionFunc(func(context.Context) ssh.Action {
+			if current := strings.TrimSpace(buf.String()); current != version {
+				return ssh.ActionError(fmt.Sprintf("canary node %q is running kubelet %q instead of %s", nodename, current, version))
+			}
+			return nil
+		}),
+	}
+	if check != "" {
+		validation = append(validation,
+			ssh.DoMessageInfo("Running the canary check..."),
+			ssh.DoExec(check)) // rawcmd: the canary check is a shell command provided by the user
+	}
+
+	return ssh.ActionList{
+		ssh.DoWithException(
+			validation,
+			ssh.DoMessageWarn("canary node %q failed the validation: the upgrade of the other nodes will not continue", nodename)),
+		doRemoteKubectl(d, "annotate", "--overwrite", "node", nodename,
+			common.DefAnnotationUpgradeCanaryKey+"="+version),
+		ssh.DoMessageInfo("Canary node %q validated", nodename),
+	}
+}
+
+// doUpgradeNode runs all the upgrade phases in the node. When canaries are
+// used, workers are either canaries (validated after the upgrade) or they
+// wait for all the canaries being validated.
+func doUpgradeNode(d *schema.ResourceData, nodename string, version string) ssh.Action {
+	stateFile := getUpgradeStateFromResourceData(d)
+
+	upgrade := ssh.ActionList{
+		ssh.DoMessageInfo("Upgrading node %q to %s...", nodename, version),
+		ssh.DoMkdirOnce(path.Dir(stateFile)),
+		doFixRemovedFlags(d, version),
+	}
+	for _, phase := range getUpgradePhases(d, version, nodename) {
+		upgrade = append(upgrade, doUpgradePhase(stateFile, version, phase))
+	}
+	upgrade = append(upgrade, ssh.DoMessageInfo("Node %q upgraded to %s", nodename, version))
+
+	canarySelector := d.Get("upgrade_canary.0.selector").(string)
+	isWorker := len(getJoinFromResourceData(d)) > 0 && getRoleFromResourceData(d) != "master"
+	if canarySelector == "" || !isWorker {
+		return upgrade
+	}
+
+	return ssh.DoIfElse(
+		checkNodeMatchesSelector(d, nodename, canarySelector),
+		ssh.ActionList{
+			upgrade,
+			doValidateCanary(d, nodename, version),
+		},
+		ssh.ActionList{
+			doCheckCanariesValidated(d, version, canarySelector),
+			upgrade,
+		})
+}
+
+// doUpgrade upgrades the node to the version in the "upgrade" block. Nodes that
+// do not match the (optional) node selector are skipped, so big clusters can be
+// upgraded in batches of labeled nodes.
+func doUpgrade(d *schema.ResourceData) ssh.Action {
+	version := d.Get("upgrade.0.version").(string)
+	selector := d.Get("upgrade.0.node_selector").(string)
+
+	node := ssh.KubeNode{}
+	return ssh.ActionList{
+		doCheckClusterID(d),
+		DoGetNodename(d, &node),
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			if node.IsEmpty() {
+				return ssh.ActionError("could not find the Kubernetes nodename: the node cannot be upgraded")
+			}
+			return ssh.DoIfElse(
+				checkNodeMatchesSelector(d, node.Nodename, selector),
+				ssh.DoInMaintenanceWindow(fmt.Sprintf("upgrading node %q", node.Nodename),
+					doUpgradeNode(d, node.Nodename, version)),
+				ssh.DoMessageInfo("Node %q does not match %q: it will not be upgraded", node.Nodename, selector))
+		}),
+	}
+}
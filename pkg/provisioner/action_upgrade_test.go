@@ -0,0 +1,87 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+func TestGetUpgradePhases(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"upgrade": []interface{}{
+			map[string]interface{}{
+				"version": "v1.16.0",
+			},
+		},
+	})
+
+	names := []string{}
+	for _, phase := range getUpgradePhases(d, "v1.16.0", "node1") {
+		names = append(names, phase.name)
+	}
+	expected := []string{"binaries", "kubeadm", "drain", "kubelet", "uncordon"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("Error: unexpected phases: %v, expected: %v", names, expected)
+	}
+}
+
+func TestDoUpgradePhase(t *testing.T) {
+	phase := upgradePhase{"kubelet", ssh.ActionError("phase must not run")}
+
+	// completed phases are skipped
+	ctx := ssh.NewTestingContextWithResponses([]string{"CONDITION_SUCCEEDED"})
+	if res := doUpgradePhase("/tmp/state", "v1.16.0", phase).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: completed phase not skipped: %v", res)
+	}
+
+	ctx = ssh.NewTestingContextWithResponses([]string{"CONDITION_FAILED"})
+	if res := doUpgradePhase("/tmp/state", "v1.16.0", phase).Apply(ctx); !ssh.IsError(res) {
+		t.Fatalf("Error: pending phase not run")
+	}
+}
+
+func TestCheckCanariesValidated(t *testing.T) {
+	testCases := []struct {
+		output   string
+		expError bool
+	}{
+		{`{"items": [
+			{"metadata": {"name": "canary1", "annotations": {"kubeadm.terraform.io/upgrade-canary": "v1.16.0"}}},
+			{"metadata": {"name": "canary2", "annotations": {"kubeadm.terraform.io/upgrade-canary": "v1.16.0"}}}]}`, false},
+		{`{"items": [
+			{"metadata": {"name": "canary1", "annotations": {"kubeadm.terraform.io/upgrade-canary": "v1.16.0"}}},
+			{"metadata": {"name": "canary2", "annotations": {"kubeadm.terraform.io/upgrade-canary": "v1.15.0"}}}]}`, true},
+		{`{"items": [
+			{"metadata": {"name": "canary1", "annotations": {"kubeadm.terraform.io/upgrade-canary": "v1.16.0"}}},
+			{"metadata": {"name": "canary2"}}]}`, true},
+		{`{"items": []}`, true},
+	}
+
+	for i, testCase := range testCases {
+		canaries := canaryNodes{}
+		if err := ssh.ParseJSON(testCase.output, &canaries); err != nil {
+			t.Fatalf("Error: test case %d: could not parse output: %s", i, err)
+		}
+		err := checkCanariesValidated(canaries, "v1.16.0")
+		if (err != nil) != testCase.expError {
+			t.Fatalf("Error: test case %d: unexpected result: %v", i, err)
+		}
+	}
+}
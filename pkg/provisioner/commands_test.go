@@ -0,0 +1,138 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"strings"
+	"testing"
+)
+
+// the comment that must be present in the line of a raw command that
+// cannot be built with ssh.NewCommand()
+const rawCommandMarker = "rawcmd:"
+
+// rawCommandFuncs are the functions that run commands given as strings
+var rawCommandFuncs = map[string]bool{
+	"DoExec":    true,
+	"CheckExec": true,
+}
+
+// isConstantCommand returns true if the expression is a string literal,
+// a constant or a concatenation of them
+func isConstantCommand(expr ast.Expr, consts map[string]bool) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return e.Kind == token.STRING
+	case *ast.Ident:
+		return consts[e.Name]
+	case *ast.ParenExpr:
+		return isConstantCommand(e.X, consts)
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && isConstantCommand(e.X, consts) && isConstantCommand(e.Y, consts)
+	}
+	return false
+}
+
+// rawCommandDirs are the packages checked for raw commands, with the name used
+// for calling DoExec() and CheckExec() in them (empty in the ssh package itself)
+var rawCommandDirs = map[string]string{
+	".":                  "ssh",
+	"../../internal/ssh": "",
+}
+
+// isRawCommandFunc returns true if the function called is DoExec() or CheckExec()
+// in the `ssh` package (or in the current package, when `pkgName` is empty)
+func isRawCommandFunc(fun ast.Expr, pkgName string) (string, bool) {
+	switch f := fun.(type) {
+	case *ast.SelectorExpr:
+		pkgIdent, ok := f.X.(*ast.Ident)
+		return f.Sel.Name, ok && pkgName != "" && pkgIdent.Name == pkgName && rawCommandFuncs[f.Sel.Name]
+	case *ast.Ident:
+		return f.Name, pkgName == "" && rawCommandFuncs[f.Name]
+	}
+	return "", false
+}
+
+// TestRemoteCommandsAreSafe checks that the commands run with ssh.DoExec() and
+// ssh.CheckExec() are constants, so all the commands with some user input
+// are built with ssh.NewCommand() (where all the arguments are quoted)
+func TestRemoteCommandsAreSafe(t *testing.T) {
+	for dir, pkgName := range rawCommandDirs {
+		checkRemoteCommandsAreSafe(t, dir, pkgName)
+	}
+}
+
+// checkRemoteCommandsAreSafe checks the commands run in the package in `dir`
+func checkRemoteCommandsAreSafe(t *testing.T, dir string, pkgName string) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		t.Fatalf("Error: could not parse the package in %q: %s", dir, err)
+	}
+
+	for _, pkg := range pkgs {
+		// collect all the constants defined at the package level
+		consts := map[string]bool{}
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.CONST {
+					for _, spec := range gd.Specs {
+						for _, name := range spec.(*ast.ValueSpec).Names {
+							consts[name.Name] = true
+						}
+					}
+				}
+			}
+		}
+
+		for filename, file := range pkg.Files {
+			// lines with a raw command marker
+			allowed := map[int]bool{}
+			for _, group := range file.Comments {
+				for _, c := range group.List {
+					if strings.Contains(c.Text, rawCommandMarker) {
+						line := fset.Position(c.Pos()).Line
+						allowed[line] = true
+						allowed[line+1] = true // (for comments in the line before the call)
+					}
+				}
+			}
+
+			ast.Inspect(file, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok || len(call.Args) != 1 {
+					return true
+				}
+				name, ok := isRawCommandFunc(call.Fun, pkgName)
+				if !ok {
+					return true
+				}
+
+				pos := fset.Position(call.Pos())
+				if !isConstantCommand(call.Args[0], consts) && !allowed[pos.Line] {
+					t.Errorf("Error: %s:%d: non-constant command in %s(): use ssh.NewCommand() or add a %q comment",
+						filename, pos.Line, name, rawCommandMarker)
+				}
+				return true
+			})
+		}
+	}
+}
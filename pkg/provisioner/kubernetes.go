@@ -17,6 +17,7 @@ package provisioner
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io/ioutil"
 	"strings"
 
@@ -30,10 +31,30 @@ const (
 	// command for getting the machine-id
 	machineIDCmd = `cat /etc/machine-id`
 
-	// command for getting a map of "machine-id <-> nodename"
-	kubectlGetNodenameCmd = `get nodes -o yaml -o=jsonpath='{range .items[*]}{.status.nodeInfo.machineID}{"\t"}{.metadata.name}{"\n"}{end}'`
+	// a Node with a pods CIDR (the kubelet completes it when it registers the node)
+	nodeWithPodCIDRManifest = `apiVersion: v1
+kind: Node
+metadata:
+  name: %s
+spec:
+  podCIDR: %s
+`
 )
 
+// kubeNodesList is the (partial) output of `kubectl get nodes -o json`
+type kubeNodesList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			NodeInfo struct {
+				MachineID string `json:"machineID"`
+			} `json:"nodeInfo"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
 // doRemoteKubectl runs a remote kubectl with the kubeconfig specified in the schema
 func doRemoteKubectl(d *schema.ResourceData, args ...string) ssh.Action {
 	kubeconfig := getKubeconfigFromResourceData(d)
@@ -42,12 +63,37 @@ func doRemoteKubectl(d *schema.ResourceData, args ...string) ssh.Action {
 }
 
 // DoRemoteKubectlApply applies some manifests with a remote kubectl, uploading the kubeconfig specified in the schema
+// (manifests from URLs are verified with the checksums pinned in the schema)
 func doRemoteKubectlApply(d *schema.ResourceData, manifests []ssh.Manifest) ssh.Action {
 	kubeconfig := getKubeconfigFromResourceData(d)
 	if kubeconfig == "" {
 		return ssh.ActionError("no 'config_path' has been specified")
 	}
-	return ssh.DoRemoteKubectlApply(getKubectlFromResourceData(d), kubeconfig, manifests)
+
+	checksums := d.Get("manifest_checksums").(map[string]interface{})
+	required := d.Get("require_manifest_checksums").(bool)
+	pinned := make([]ssh.Manifest, 0, len(manifests))
+	for _, m := range manifests {
+		if m.URL != "" && m.SHA256 == "" {
+			if checksum, ok := checksums[m.URL]; ok {
+				m.SHA256 = checksum.(string)
+			} else if required {
+				return ssh.ActionError(fmt.Sprintf("no checksum in 'manifest_checksums' for manifest %q", m.URL))
+			}
+		}
+		pinned = append(pinned, m)
+	}
+	return ssh.DoRemoteKubectlApply(getKubectlFromResourceData(d), kubeconfig, pinned)
+}
+
+// doWithClusterLock runs some action while holding the coordination lock of
+// the cluster (if enabled), so other Terraform runs do not touch it meanwhile
+func doWithClusterLock(d *schema.ResourceData, action ssh.Action) ssh.Action {
+	lock := getClusterLockFromResourceData(d)
+	if lock == nil {
+		return action
+	}
+	return ssh.DoWithClusterLock(getKubectlFromResourceData(d), getKubeconfigFromResourceData(d), *lock, action)
 }
 
 // doKubectlDrainNode runs a kubectl for draining a node
@@ -74,6 +120,37 @@ func doKubectlDeleteNode(d *schema.ResourceData, nodename string) ssh.Action {
 	}
 }
 
+// doCreateNodeWithPodCIDR creates the Node object for this node with the per-node pods
+// CIDR (if provided) before the kubelet registers it: the `--pod-cidr` of the kubelet is
+// ignored in a cluster, but the controller manager does not allocate a CIDR for nodes
+// that already have one in their `spec.podCIDR`.
+func doCreateNodeWithPodCIDR(d *schema.ResourceData) ssh.Action {
+	podCIDR := getPodCIDRFromResourceData(d)
+	if podCIDR == "" {
+		return nil
+	}
+
+	return ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+		// the kubelet registers the node with its (lowercase) hostname when no nodename is provided
+		nodename := getNodenameFromResourceData(d)
+		if nodename == "" {
+			var buf bytes.Buffer
+			res := ssh.DoSendingExecOutputToWriter(ssh.DoExecCommand(ssh.NewCommand("hostname")), &buf).Apply(ctx)
+			if ssh.IsError(res) {
+				return res
+			}
+			nodename = strings.ToLower(strings.TrimSpace(buf.String()))
+		}
+
+		return ssh.ActionList{
+			ssh.DoMessageInfo("Registering node %q with the pods CIDR %s", nodename, podCIDR),
+			doRemoteKubectlApply(d, []ssh.Manifest{
+				{Inline: fmt.Sprintf(nodeWithPodCIDRManifest, nodename, podCIDR)},
+			}),
+		}
+	})
+}
+
 // DoGetNodename tries to get the nodename
 func DoGetNodename(d *schema.ResourceData, node *ssh.KubeNode) ssh.Action {
 	// maybe we can get it just from the `ResourceData`
@@ -100,25 +177,21 @@ func DoGetNodename(d *schema.ResourceData, node *ssh.KubeNode) ssh.Action {
 		machineID := strings.TrimSpace(buf.String())
 		ssh.Debug("... machineID: %q", machineID)
 
-		res = ssh.DoSendingExecOutputToFunc(
-			ssh.DoRemoteKubectl(kubectl, kubeconfig, kubectlGetNodenameCmd),
-			func(s string) {
-				if len(s) == 0 {
-					return
-				}
-				ssh.Debug("trying to find nodename in %q", s)
-				if strings.Contains(s, machineID) {
-					// parse:
-					// bf38f8ac633e4f64a4924b0ed7b25946        kubeadm-master-0
-					fields := strings.Fields(s)
-					if len(fields) < 2 {
-						ssh.Debug("could not get the nodename from fields: %+v", fields)
-						return
-					}
-					node.Nodename = strings.TrimSpace(fields[1])
-					ssh.Debug("... detected nodename %q", node.Nodename)
-				}
-			}).Apply(ctx)
+		nodes := kubeNodesList{}
+		res = ssh.DoSendingExecOutputToJSON(
+			ssh.DoRemoteKubectl(kubectl, kubeconfig, "get", "nodes", "-o", "json"),
+			&nodes).Apply(ctx)
+		if ssh.IsError(res) {
+			return res
+		}
+
+		for _, item := range nodes.Items {
+			if item.Status.NodeInfo.MachineID == machineID {
+				node.Nodename = item.Metadata.Name
+				ssh.Debug("... detected nodename %q", node.Nodename)
+				break
+			}
+		}
 
 		return res
 	})
@@ -130,11 +203,12 @@ func DoGetNodename(d *schema.ResourceData, node *ssh.KubeNode) ssh.Action {
 
 // doDownloadKubeconfig downloads the "admin.conf" from the remote master
 // to the local file specified in the "config_path" attribute
+// (using a byte-accurate transfer, so line endings are preserved)
 func doDownloadKubeconfig(d *schema.ResourceData) ssh.Action {
 	kubeconfig := getKubeconfigFromResourceData(d)
 
 	return ssh.ActionList{
-		ssh.DoDownloadFile(ssh.DefAdminKubeconfig, kubeconfig),
+		ssh.DoDownloadBinaryFile(ssh.DefAdminKubeconfig, kubeconfig),
 		ssh.ActionFunc(func(context.Context) ssh.Action {
 			// load the kubeconfig data and set it in the provisioner ResourceData
 			cont, err := ioutil.ReadFile(kubeconfig)
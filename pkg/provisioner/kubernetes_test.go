@@ -15,6 +15,14 @@
 package provisioner
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -24,10 +32,12 @@ import (
 
 func TestDoGetNodename(t *testing.T) {
 	machineID := "  bf38f8ac633e4f64a4924b0ed7b25946\r"
-	output := `
-bf38f8ac633e4f64a4924b0ed7b25946        kubeadm-master-0
-0b44fe52491e401181c4ef5607b70e96        kubeadm-worker-0
-`
+	output := `{
+  "items": [
+    {"metadata": {"name": "kubeadm-master-0"}, "status": {"nodeInfo": {"machineID": "bf38f8ac633e4f64a4924b0ed7b25946"}}},
+    {"metadata": {"name": "kubeadm-worker-0"}, "status": {"nodeInfo": {"machineID": "0b44fe52491e401181c4ef5607b70e96"}}}
+  ]
+}`
 
 	// responses from the fake remote machine
 	responses := []string{
@@ -52,3 +62,105 @@ bf38f8ac633e4f64a4924b0ed7b25946        kubeadm-master-0
 		t.Fatalf("Error: wrong nodename %q", node.Nodename)
 	}
 }
+
+func TestDoCreateNodeWithPodCIDR(t *testing.T) {
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.Remove(kubeconfig.Name())
+	_, _ = kubeconfig.WriteString("apiVersion: v1\nkind: Config\n")
+	_ = kubeconfig.Close()
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join":     "api.example.com",
+		"pod_cidr": "10.244.3.0/24",
+		"config": map[string]interface{}{
+			"config_path": kubeconfig.Name(),
+		},
+	})
+	if err := checkNodePodCIDR(d); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	// the node is registered with the hostname
+	ctx, uploads := ssh.NewTestingContextForUploads([]string{"Worker-0\r\n"})
+	if res := (ssh.ActionList{doCreateNodeWithPodCIDR(d)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+	found := false
+	for _, manifest := range *uploads {
+		if strings.Contains(manifest, "kind: Node\n") {
+			if !strings.Contains(manifest, "name: worker-0\n") || !strings.Contains(manifest, "podCIDR: 10.244.3.0/24\n") {
+				t.Fatalf("Error: unexpected Node manifest:\n%s", manifest)
+			}
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: no Node manifest uploaded: %+v", *uploads)
+	}
+
+	// the pods CIDR must be inside the cluster pods CIDR
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join":     "api.example.com",
+		"pod_cidr": "192.168.0.0/24",
+	})
+	if err := checkNodePodCIDR(d); err == nil {
+		t.Fatalf("Error: pods CIDR outside the cluster pods CIDR not detected")
+	}
+}
+
+func TestDoRemoteKubectlApplyWithChecksums(t *testing.T) {
+	const manifest = "apiVersion: v1\nkind: Namespace\nmetadata:\n  name: addon\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, manifest)
+	}))
+	defer server.Close()
+	pinnedURL := server.URL + "/addon.yaml"
+	unpinnedURL := server.URL + "/other.yaml"
+	sum := sha256.Sum256([]byte(manifest))
+
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.Remove(kubeconfig.Name())
+	_, _ = kubeconfig.WriteString("apiVersion: v1\nkind: Config\n")
+	_ = kubeconfig.Close()
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"config_path": kubeconfig.Name(),
+		},
+		"manifest_checksums": map[string]interface{}{
+			pinnedURL: hex.EncodeToString(sum[:]),
+		},
+		"require_manifest_checksums": true,
+	})
+
+	// the pinned manifest is verified and uploaded
+	ctx, uploads := ssh.NewTestingContextForUploads([]string{})
+	if res := (ssh.ActionList{doRemoteKubectlApply(d, []ssh.Manifest{ssh.NewManifest(pinnedURL)})}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: %s", res)
+	}
+	found := false
+	for _, contents := range *uploads {
+		if contents == manifest {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Error: verified manifest not uploaded: %+v", *uploads)
+	}
+
+	// manifests without a checksum are rejected
+	ctx, uploads = ssh.NewTestingContextForUploads([]string{})
+	res := (ssh.ActionList{doRemoteKubectlApply(d, []ssh.Manifest{ssh.NewManifest(unpinnedURL)})}).Apply(ctx)
+	if !ssh.IsError(res) {
+		t.Fatalf("Error: manifest without a checksum was applied")
+	}
+	if len(*uploads) > 0 {
+		t.Fatalf("Error: something was uploaded: %+v", *uploads)
+	}
+}
@@ -16,8 +16,13 @@ package provisioner
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"path"
+	"sync"
+	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/hashicorp/terraform/helper/schema"
@@ -25,21 +30,38 @@ import (
 
 	"github.com/inercia/terraform-provider-kubeadm/internal/assets"
 	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
 var (
 	ErrUnknownProvisioningProfile = errors.New("unknown provisioning profile")
 )
 
+var (
+	// the ID of this run, shared by all the nodes provisioned in the same run
+	runID     string
+	runIDOnce sync.Once
+)
+
 func init() {
 	spew.Config.Indent = "\t"
 }
 
+// getRunID returns the ID of this Terraform run (ie, "20191023T101512Z-9f86d081")
+func getRunID() string {
+	runIDOnce.Do(func() {
+		b := make([]byte, 4)
+		_, _ = rand.Read(b)
+		runID = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+	})
+	return runID
+}
+
 // runActions runs the provisioner on a specific resource and returns the new
 // resource state along with an error. Instead of a diff, the ResourceConfig
 // is provided since provisioners only run after a resource has been
 // newly created.
-func applyFn(ctx context.Context) error {
+func applyFn(ctx context.Context) (err error) {
 	connData := ctx.Value(schema.ProvConnDataKey).(*schema.ResourceData)
 	d := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
 	s := ctx.Value(schema.ProvRawStateKey).(*terraform.InstanceState)
@@ -49,23 +71,196 @@ func applyFn(ctx context.Context) error {
 	ssh.Debug("connection:\n%s\n", spew.Sdump(connData))
 	ssh.Debug("instance state:\n%s\n", spew.Sdump(s))
 
+	// nothing can be done in the read-only mode (in the environment or in the provider)
+	if common.IsReadOnlyFromEnv() || common.IsReadOnlyFromConfig(common.GetProvisionerConfig(d)) {
+		return common.ReadOnlyError("provisioning (or draining) nodes")
+	}
+
+	// nothing is run in the plan-only mode (in the resource, the environment or the provider),
+	// and the provisioning fails at the end, so the resource is not considered as created
+	planOnly := d.Get("plan_only").(bool) || common.IsPlanOnlyFromEnv() || common.IsPlanOnlyFromConfig(common.GetProvisionerConfig(d))
+	if planOnly {
+		defer func() {
+			if err == nil {
+				err = common.PlanOnlyError()
+			}
+		}()
+	}
+
 	// ensure that this is a linux machine
 	if s.Ephemeral.ConnInfo["type"] != "ssh" {
 		return fmt.Errorf("Unsupported connection type: %s. This provisioner currently only supports linux", s.Ephemeral.ConnInfo["type"])
 	}
 
-	preventSudo := d.Get("prevent_sudo").(bool)
-	useSudo := !preventSudo && s.Ephemeral.ConnInfo["user"] != "root"
+	useSudo := getUseSudoFromResourceData(d, s.Ephemeral.ConnInfo["user"])
+
+	// load the execution manifest to replay (if requested)
+	var replay *ssh.ExecutionManifest
+	if filename := getReplayExecutionManifestFromResourceData(d); filename != "" {
+		m, err := ssh.LoadExecutionManifest(filename)
+		if err != nil {
+			return err
+		}
+		replay = m
+		// commands in the manifest already include the "sudo" prefix (when it was used)
+		useSudo = false
+	}
+
+	// limit the number of nodes (and SSH sessions) being provisioned at the same time
+	if max := getMaxParallelNodesFromResourceData(d); max > 0 {
+		ssh.Debug("waiting for a slot (max parallel nodes=%d)", max)
+		release, err := ssh.AcquireClusterSlot(ctx, getClusterIDFromResourceData(d), max)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
 
 	// build a communicator for the provisioner to use
-	comm, err := getCommunicator(ctx, o, s)
+	// (we disconnect, or release the shared connection, when we are done, but not when the
+	// user interrupts the provisioning: the current command is finished and the cleanups
+	// are run before returning)
+	comm, closeComm, err := getCommunicatorForResource(ctx, o, d, s)
 	if err != nil {
 		o.Output("Error when creating communicator")
 		return err
 	}
+	defer closeComm()
+
+	// send keepalives, so long-running commands are not killed by NATs or firewalls
+	if keepAlive := getKeepAliveFromResourceData(d); keepAlive != nil {
+		comm = ssh.NewKeepAliveCommunicator(ctx, comm, *keepAlive)
+	}
+
+	host := ssh.Host{
+		Address: getHostAddressFromConnInfo(s.Ephemeral.ConnInfo),
+		Role:    getRoleFromResourceData(d),
+	}
+
+	// write all the output for this host in its own log file too (if requested)
+	var out ssh.UIOutput = o
+	if pattern := getLogFileFromResourceData(d); pattern != "" {
+		hostLog, err := ssh.NewHostLog(pattern, host)
+		if err != nil {
+			return fmt.Errorf("could not open the log file for %s: %s", host, err)
+		}
+		defer hostLog.Close()
+		out = hostLog.Tee(o)
+	}
 
 	// add some extra things to the context
-	newCtx := ssh.WithValues(ctx, o, o, comm, useSudo)
+	newCtx := ssh.WithValues(ctx, out, out, comm, useSudo)
+
+	// run the commands as root with `sudo`, `doas`, `su`...
+	newCtx = withPrivilegeEscalationFromResourceData(newCtx, d)
+
+	// disruptive operations can only be started in the maintenance window (if any)
+	window, err := getMaintenanceWindowFromResourceData(d)
+	if err != nil {
+		return err
+	}
+	if window != nil {
+		newCtx = ssh.WithMaintenanceWindow(newCtx, window)
+	}
+
+	// just print the commands and uploads (if requested)
+	if planOnly {
+		out.Output("Plan-only mode: nothing will be changed in this node")
+		newCtx = ssh.WithDryRun(newCtx)
+	}
+
+	// set the identity of this host (contexts for the same host share the same cache)
+	newCtx = ssh.WithHost(newCtx, host)
+
+	// all the nodes in the same cluster (the same CA) share some "once per cluster" actions
+	newCtx = ssh.WithClusterID(newCtx, getClusterIDFromResourceData(d))
+
+	// the node could be still booting: wait until commands can be run (if requested)
+	if timeout := getWaitForConnectionTimeoutFromResourceData(d); timeout > 0 {
+		if res := (ssh.ActionList{ssh.DoWaitForSSH(timeout)}).Apply(newCtx); ssh.IsError(res) {
+			return res
+		}
+	}
+
+	// use a different directory for temporary files in the remote host (if requested)
+	if dir := d.Get("remote_tmp").(string); dir != "" {
+		newCtx = ssh.WithRemoteTmp(newCtx, dir)
+	}
+
+	// record all the commands and uploads in this host (if requested)
+	if dir := getExecutionManifestDirFromResourceData(d); dir != "" {
+		m := ssh.NewExecutionManifest(ssh.GetHostFromContext(newCtx))
+		m.RecordContents = d.Get("execution_manifest_contents").(bool)
+		newCtx = ssh.WithExecutionManifest(newCtx, m)
+		defer func() {
+			filename, err := m.WriteToDir(dir)
+			if err != nil {
+				o.Output(fmt.Sprintf("Could not write the execution manifest: %s", err))
+				return
+			}
+			o.Output(fmt.Sprintf("Execution manifest written to %s", filename))
+		}()
+	}
+
+	// record all the commands (with their results) in a local transcript (if requested)
+	if pattern := getTranscriptFileFromResourceData(d); pattern != "" {
+		transcript, err := ssh.NewTranscript(pattern, host)
+		if err != nil {
+			return fmt.Errorf("could not open the transcript file for %s: %s", host, err)
+		}
+		defer transcript.Close()
+		newCtx = ssh.WithTranscript(newCtx, transcript)
+	}
+
+	// run all the commands with a POSIX shell, even when the login shell is not
+	shell, err := getShellWrapper(newCtx, d)
+	if err != nil {
+		return err
+	}
+	newCtx = ssh.WithShellWrapper(newCtx, shell)
+
+	// pin the locale and the PATH, so the output of the commands can be parsed
+	if env := getExecEnvFromResourceData(d); env != nil {
+		newCtx = ssh.WithExecEnv(newCtx, *env)
+	}
+
+	// record all the commands executed in an audit log in the node (if requested)
+	if log := getAuditLogFromResourceData(d); log != nil {
+		newCtx = ssh.WithAuditLog(newCtx, *log)
+	}
+
+	// manage the files in some directories without privilege escalation (if requested)
+	if paths := getWritablePathsFromResourceData(d); len(paths) > 0 {
+		newCtx = ssh.WithWritablePaths(newCtx, paths)
+		if res := ssh.DoCheckWritablePaths().Apply(newCtx); ssh.IsError(res) {
+			return res
+		}
+	}
+
+	// ignore the banners printed by the remote shell (if requested or detected)
+	if d.Get("filter_banner").(bool) {
+		newCtx = ssh.WithBannerFilter(newCtx)
+	}
+	if res := ssh.DoDetectLoginNoise().Apply(newCtx); ssh.IsError(res) {
+		return res
+	}
+
+	// detect minimal userlands (ie, BusyBox in Alpine), where some commands must be adapted
+	if res := ssh.DoDetectUserland().Apply(newCtx); ssh.IsError(res) {
+		return res
+	}
+
+	//
+	// replay of a previous execution manifest
+	//
+
+	if replay != nil {
+		ssh.Debug("replaying %d entries from the execution manifest of %q", len(replay.Entries), replay.Host)
+		return ssh.ActionList{
+			ssh.DoMessageInfo(fmt.Sprintf("Replaying the execution manifest recorded for %s", replay.Host)),
+			ssh.DoReplayExecutionManifest(replay),
+		}.Apply(newCtx)
+	}
 
 	//
 	// resource destruction
@@ -74,8 +269,41 @@ func applyFn(ctx context.Context) error {
 	drain := d.Get("drain").(bool)
 	if drain {
 		ssh.Debug("node will be drained")
-		action := doRemoveNode(d)
-		return action.Apply(newCtx)
+		actions := ssh.ActionList{doWithClusterLock(d, doRemoveNode(d))}
+		if d.Get("uninstall_on_destroy").(bool) {
+			ssh.Debug("node will be cleaned up")
+			actions = append(actions, doUninstall(d))
+		}
+		return ssh.ActionList{
+			ssh.DoWithCleanup(
+				actions,
+				ssh.DoCleanupLeftovers()),
+		}.Apply(newCtx)
+	}
+
+	//
+	// on-demand backups
+	//
+
+	if d.Get("backup.0.only").(bool) {
+		ssh.Debug("node will be backed up")
+		return ssh.DoIfElse(
+			ssh.CheckFileExists(path.Join(common.DefPKIDir, "ca.key")),
+			doBackupKubernetesConfig(d),
+			ssh.DoMessageWarn("no control plane configuration found in this node: nothing to backup")).Apply(newCtx)
+	}
+
+	//
+	// upgrades
+	//
+
+	if _, ok := d.GetOk("upgrade.0"); ok {
+		ssh.Debug("node will be upgraded")
+		return ssh.ActionList{
+			ssh.DoWithCleanup(
+				doWithClusterLock(d, doUpgrade(d)),
+				ssh.DoCleanupLeftovers()),
+		}.Apply(newCtx)
 	}
 
 	//
@@ -92,6 +320,9 @@ func applyFn(ctx context.Context) error {
 		actions = append(actions, ssh.DoMessageInfo("New resource: provisioning"))
 	}
 
+	// backup the current configuration (if we are re-provisioning a control plane node)
+	actions = append(actions, doBackupBeforeProvisioning(d))
+
 	// add the actions for installing kubeadm
 	actions = append(actions, doKubeadmSetup(d))
 
@@ -99,16 +330,34 @@ func applyFn(ctx context.Context) error {
 	join := getJoinFromResourceData(d)
 	role := getRoleFromResourceData(d)
 
+	// validate the configuration with kubeadm (when enabled) before doing anything else in the node
+	// (note: provisioners are not run when planning, so this can only be done when applying)
+	switch {
+	case len(join) > 0:
+		actions = append(actions, doKubeadmJoinDryRun(d))
+	case role != "worker":
+		actions = append(actions, doKubeadmInitDryRun(d))
+	}
+
 	// some common actions to do BEFORE doing initting/joining
 	actions = append(actions,
 		ssh.DoMessageInfo("Checking we have the required binaries..."),
 		doCheckCommonBinaries(d),
+		doCheckConnectivity(d),
+		doCheckNodeSubnets(d),
+		doCheckEntropy(d),
+		doCheckConflictingInstalls(d),
+		doConfigureStorageDriver(d),
+		doCleanupKubeletPolicyStates(d),
+		doConfigureShutdownInhibitor(d),
+		doSetupRuntimeClasses(d),
 		doPrepareCRI(),
 		doUploadResolvConf(d),
 		ssh.DoEnableService("kubelet.service"),
-		ssh.DoUploadBytesToFile([]byte(assets.KubeletSysconfigCode), getSysconfigPathFromResourceData(d)),
-		ssh.DoUploadBytesToFile([]byte(assets.KubeletServiceCode), getServicePathFromResourceData(d)),
-		ssh.DoUploadBytesToFile([]byte(assets.KubeadmDropinCode), getDropinPathFromResourceData(d)),
+		ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(assets.KubeletSysconfigCode), getSysconfigPathFromResourceData(d)),
+		ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(assets.KubeletServiceCode), getServicePathFromResourceData(d)),
+		ssh.DoUploadBytesToFileWithBackupIfChanged([]byte(assets.KubeadmDropinCode), getDropinPathFromResourceData(d)),
+		doRebootIfRequired(d),
 	)
 
 	if len(join) == 0 {
@@ -121,11 +370,11 @@ func applyFn(ctx context.Context) error {
 	} else {
 		switch role {
 		case "master":
-			actions = append(actions, doKubeadmJoinControlPlane(d))
+			actions = append(actions, doWithClusterLock(d, doKubeadmJoinControlPlane(d)))
 		case "worker":
-			actions = append(actions, doKubeadmJoinWorker(d))
+			actions = append(actions, doWithClusterLock(d, doKubeadmJoinWorker(d)), doWaitIngress(d))
 		case "":
-			actions = append(actions, doKubeadmJoinWorker(d))
+			actions = append(actions, doWithClusterLock(d, doKubeadmJoinWorker(d)), doWaitIngress(d))
 		default:
 			actions = append(actions, ssh.ActionError(fmt.Sprintf("unknown provisioning profile: join is %q and role is %q", join, role)))
 		}
@@ -133,11 +382,21 @@ func applyFn(ctx context.Context) error {
 
 	// ... and some common actions to do AFTER initting/joining
 	actions = append(actions,
+		doConfigureKubeletShutdown(d, true),
 		ssh.DoMessageInfo("Gathering some info about this node..."),
 		doCheckLocalKubeconfigIsAlive(d),
+		ssh.DoTry(doAnnotateNode(d, s.ID)),
+		ssh.DoTry(doSignalRebootRequired(d)),
+		doRegisterRuntimeClasses(d),
 		doPrintEtcdStatus(d),
+		doInstallDriftWatchdog(d),
 	)
 
+	// verify the isolation of control plane nodes
+	if len(join) == 0 || role == "master" {
+		actions = append(actions, doVerifyListeningPorts(d))
+	}
+
 	return ssh.ActionList{
 		ssh.DoWithCleanup(
 			actions,
@@ -15,11 +15,18 @@
 package provisioner
 
 import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/config"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
 func TestResourceProvisioner_impl(t *testing.T) {
@@ -59,6 +66,59 @@ func TestResourceProvider_Validate_bad(t *testing.T) {
 	}
 }
 
+func TestResourceProvider_Validate_removedFlags(t *testing.T) {
+	testConfigWithArgs := func(args map[string]string) *terraform.ResourceConfig {
+		initConfig := &kubeadmapi.InitConfiguration{}
+		initConfig.ClusterConfiguration.Scheduler.ExtraArgs = map[string]string{"port": "0"}
+		initConfig.NodeRegistration.KubeletExtraArgs = args
+		configBytes, err := common.InitConfigToYAML(initConfig)
+		if err != nil {
+			t.Fatalf("Error: %v", err)
+		}
+		return testConfig(t, map[string]interface{}{
+			"config": map[string]interface{}{
+				"init": common.ToTerraformSafeString(configBytes),
+			},
+			"upgrade": []interface{}{
+				map[string]interface{}{"version": "v1.24.0"},
+			},
+		})
+	}
+
+	warn, errs := Provisioner().Validate(testConfigWithArgs(map[string]string{"network-plugin": "cni"}))
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %v", errs)
+	}
+	if len(warn) != 2 {
+		t.Fatalf("Warnings: %v", warn)
+	}
+
+	_, errs = Provisioner().Validate(testConfigWithArgs(map[string]string{"cni-bin-dir": "/opt/cni/bin"}))
+	if len(errs) == 0 {
+		t.Fatalf("Error: removed kubelet flag not detected")
+	}
+}
+
+func TestResourceProvider_Validate_autoWithBinaries(t *testing.T) {
+	testConfigWithInstall := func(install map[string]interface{}) *terraform.ResourceConfig {
+		install["binaries"] = []interface{}{map[string]interface{}{"version": "v1.15.0"}}
+		return testConfig(t, map[string]interface{}{
+			"config":  map[string]interface{}{"cni_plugin": "flannel"},
+			"install": []interface{}{install},
+		})
+	}
+
+	_, errs := Provisioner().Validate(testConfigWithInstall(map[string]interface{}{"auto": true}))
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "install.binaries") {
+		t.Fatalf("Error: 'auto' with 'binaries' not detected: %v", errs)
+	}
+
+	_, errs = Provisioner().Validate(testConfigWithInstall(map[string]interface{}{"inline": "apt-get install -y docker.io"}))
+	if len(errs) > 0 {
+		t.Fatalf("Errors: %v", errs)
+	}
+}
+
 func testConfig(t *testing.T, c map[string]interface{}) *terraform.ResourceConfig {
 	r, err := config.NewRawConfig(c)
 	if err != nil {
@@ -67,3 +127,146 @@ func testConfig(t *testing.T, c map[string]interface{}) *terraform.ResourceConfi
 
 	return terraform.NewResourceConfig(r)
 }
+
+func TestGetMaxParallelNodes(t *testing.T) {
+	defer os.Setenv("TF_CLI_ARGS_apply", os.Getenv("TF_CLI_ARGS_apply"))
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{})
+
+	_ = os.Setenv("TF_CLI_ARGS_apply", "")
+	if n := getMaxParallelNodesFromResourceData(d); n != 0 {
+		t.Fatalf("Error: unexpected max parallel nodes: %d", n)
+	}
+
+	_ = os.Setenv("TF_CLI_ARGS_apply", "-auto-approve -parallelism=4")
+	if n := getMaxParallelNodesFromResourceData(d); n != 4 {
+		t.Fatalf("Error: parallelism not obtained from the environment: %d", n)
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"max_parallel_nodes": 2,
+	})
+	if n := getMaxParallelNodesFromResourceData(d); n != 2 {
+		t.Fatalf("Error: unexpected max parallel nodes: %d", n)
+	}
+}
+
+func TestGetHostAddressFromConnInfo(t *testing.T) {
+	testCases := []struct {
+		connInfo map[string]string
+		expected string
+	}{
+		{map[string]string{"host": "10.0.0.1"}, "10.0.0.1:22"},
+		{map[string]string{"host": "fd00::1", "port": "2222"}, "[fd00::1]:2222"},
+		{map[string]string{"host": "10.0.0.1", "bastion_host": "bastion", "bastion_port": "2200"}, "bastion:2200,10.0.0.1:22"},
+	}
+
+	for _, testCase := range testCases {
+		if res := getHostAddressFromConnInfo(testCase.connInfo); res != testCase.expected {
+			t.Fatalf("Error: got %q, expected %q", res, testCase.expected)
+		}
+	}
+}
+
+func TestGetConnStateWithCredentials(t *testing.T) {
+	defer os.Unsetenv("TEST_PROVISIONER_SSH_KEY")
+	_ = os.Setenv("TEST_PROVISIONER_SSH_KEY", "some-private-key")
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"private_key_source": "env://TEST_PROVISIONER_SSH_KEY",
+	})
+	s := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{"type": "ssh", "host": "10.0.0.1"},
+		},
+	}
+
+	res, err := getConnStateWithCredentials(context.Background(), d, s)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if res.Ephemeral.ConnInfo["private_key"] != "some-private-key" {
+		t.Fatalf("Error: private key not set: %+v", res.Ephemeral.ConnInfo)
+	}
+	if _, ok := s.Ephemeral.ConnInfo["private_key"]; ok {
+		t.Fatalf("Error: the original state has been modified")
+	}
+}
+
+func TestGetConnStateWithCertificate(t *testing.T) {
+	defer os.Unsetenv("TEST_PROVISIONER_SSH_CERT")
+	_ = os.Setenv("TEST_PROVISIONER_SSH_CERT", "not-a-certificate")
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"certificate_source": "env://TEST_PROVISIONER_SSH_CERT",
+	})
+	s := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{"type": "ssh", "host": "10.0.0.1"},
+		},
+	}
+
+	if _, err := getConnStateWithCertificate(context.Background(), d, s); err == nil {
+		t.Fatalf("Error: invalid certificate not detected")
+	}
+
+	// without a certificate, the state is not changed
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{})
+	res, err := getConnStateWithCertificate(context.Background(), d, s)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, ok := res.Ephemeral.ConnInfo["certificate"]; ok {
+		t.Fatalf("Error: unexpected certificate: %+v", res.Ephemeral.ConnInfo)
+	}
+}
+
+func TestGetConnStateWithKeySource(t *testing.T) {
+	defer os.Unsetenv("TEST_PROVISIONER_SSH_KEY")
+	_ = os.Setenv("TEST_PROVISIONER_SSH_KEY", "some-private-key")
+
+	s := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{"type": "ssh", "host": "10.0.0.1", "private_key": "other-private-key"},
+		},
+	}
+
+	res, err := getConnStateWithKeySource(context.Background(), s, "env://TEST_PROVISIONER_SSH_KEY")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if res.Ephemeral.ConnInfo["private_key"] != "some-private-key" || res.Ephemeral.ConnInfo["agent"] != "false" {
+		t.Fatalf("Error: private key not set: %+v", res.Ephemeral.ConnInfo)
+	}
+
+	res, err = getConnStateWithKeySource(context.Background(), s, agentKeySource)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, ok := res.Ephemeral.ConnInfo["private_key"]; ok || res.Ephemeral.ConnInfo["agent"] != "true" {
+		t.Fatalf("Error: agent not set: %+v", res.Ephemeral.ConnInfo)
+	}
+	if s.Ephemeral.ConnInfo["private_key"] != "other-private-key" {
+		t.Fatalf("Error: the original state has been modified")
+	}
+}
+
+func TestSortKeySourcesForHost(t *testing.T) {
+	sources := []string{"file://rack1.key", "file://rack2.key", "agent"}
+
+	if res := sortKeySourcesForHost("10.0.0.100", sources); !reflect.DeepEqual(res, sources) {
+		t.Fatalf("Error: unexpected order without a working key: %v", res)
+	}
+
+	setWorkingKeySourceForHost("10.0.0.100", "file://rack2.key")
+	expected := []string{"file://rack2.key", "file://rack1.key", "agent"}
+	if res := sortKeySourcesForHost("10.0.0.100", sources); !reflect.DeepEqual(res, expected) {
+		t.Fatalf("Error: unexpected order: %v, expected: %v", res, expected)
+	}
+
+	// the working key is ignored when it is not a candidate anymore
+	setWorkingKeySourceForHost("10.0.0.101", "file://old.key")
+	if res := sortKeySourcesForHost("10.0.0.101", sources); !reflect.DeepEqual(res, sources) {
+		t.Fatalf("Error: unexpected order with an old working key: %v", res)
+	}
+}
@@ -15,17 +15,54 @@
 package provisioner
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/hashicorp/terraform/terraform"
 
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/internal/storage"
 	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
+// connectionSchemaKeys are the elements of the provisioner schema used for
+// connecting to (and running commands as root in) a node
+var connectionSchemaKeys = []string{
+	"private_key_source",
+	"certificate_source",
+	"private_key_sources",
+	"jump_host",
+	"wait_for_connection_timeout",
+	"host_key_checking",
+	"known_hosts_file",
+	"host_key",
+	"ssh_agent_forwarding",
+	"prevent_sudo",
+	"privilege_escalation",
+	"sudo_password",
+}
+
+// ConnectionSchema returns the elements of the provisioner schema used for connecting
+// to a node, so other resources (like data sources) can connect to nodes like the
+// provisioner does, with DialNode()
+func ConnectionSchema() map[string]*schema.Schema {
+	all := Provisioner().(*schema.Provisioner).Schema
+	res := map[string]*schema.Schema{}
+	for _, k := range connectionSchemaKeys {
+		res[k] = all[k]
+	}
+	return res
+}
+
 func Provisioner() terraform.ResourceProvisioner {
 	return &schema.Provisioner{
 		Schema: map[string]*schema.Schema{
@@ -61,6 +98,286 @@ func Provisioner() terraform.ResourceProvisioner {
 				Default:     false,
 				Description: "when true, remove this node from the cluster instead of adding it",
 			},
+			"private_key_source": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "source for the SSH private key, resolved at apply time: a file path, file://<path>, env://<VAR>, vault://<path>#<field>, op://<vault>/<item>/<field> or cmd://<command>",
+			},
+			"certificate_source": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "source for the OpenSSH certificate presented with the private key, resolved at apply time (like the private_key_source)",
+			},
+			"private_key_sources": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"private_key_source"},
+				Description:   "candidate sources for the SSH private key (or \"agent\" for the SSH agent), tried in order until one is accepted by the node",
+			},
+			"jump_host": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "chain of jump hosts (in order) for reaching the node",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"host": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "address of the jump host, as user@host:port (the user and port are optional)",
+						},
+						"private_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "private key for the jump host",
+						},
+						"private_key_source": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "source for the private key for the jump host (like the private_key_source)",
+						},
+						"certificate": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "OpenSSH certificate presented with the private key in the jump host",
+						},
+						"certificate_source": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "source for the OpenSSH certificate for the jump host (like the private_key_source)",
+						},
+						"password": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "password for the jump host",
+						},
+						"agent": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "authenticate in the jump host with the keys in the local SSH agent",
+						},
+						"host_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "expected host key of the jump host",
+						},
+					},
+				},
+			},
+			"dry_run": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "validate the configuration with a 'kubeadm init|join --dry-run' right after installing kubeadm, before modifying anything else in the node (when applying, as provisioners are not run when planning)",
+			},
+			"ephemeral_token": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "when joining, use a new short-lived token that is deleted right after the join",
+			},
+			"max_parallel_nodes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "maximum number of nodes of the cluster provisioned concurrently (defaults to the Terraform parallelism when set with TF_CLI_ARGS, or no limit)",
+			},
+			"uninstall_on_destroy": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "when true (and draining the node), uninstall all the packages, binaries and configuration installed",
+			},
+			"execution_manifest_dir": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "local directory where a JSON manifest with all the commands and files executed/uploaded in the node is written",
+			},
+			"log_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "local file where all the output for the node is written ('{host}' and '{role}' are replaced by the address and role of the node)",
+			},
+			"transcript_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "local file where all the commands run in the node are recorded, with their exit status, duration and output ('{host}' and '{role}' are replaced by the address and role of the node)",
+			},
+			"execution_manifest_contents": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "when true, include the contents of the files uploaded in the execution manifest, so it can be replayed",
+			},
+			"remote_shell": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "auto",
+				ValidateFunc: validation.StringInSlice([]string{"auto", "sh", "bash", "none"}, false),
+				Description:  "shell for wrapping all the commands: auto (only for non-POSIX login shells), sh, bash or none",
+			},
+			"exec_env": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"sanitize": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     true,
+							Description: "pin the locale and the PATH for all the commands executed in the node",
+						},
+						"locale": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     ssh.DefExecLocale,
+							Description: "locale (LANG and LC_ALL) for the commands executed in the node",
+						},
+						"path": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     ssh.DefExecPath,
+							Description: "PATH for the commands executed in the node",
+						},
+					},
+				},
+			},
+			"audit_log": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      common.DefAuditLogPath,
+							ValidateFunc: common.ValidateAbsPath,
+							Description:  "file in the node where all the commands executed are appended",
+						},
+						"run_id": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "ID of this run in the audit log (defaults to a new ID for every Terraform run)",
+						},
+					},
+				},
+			},
+			"connection_reuse": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "share the SSH connection with other resources provisioning the same host",
+			},
+			"wait_for_connection_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "0s",
+				Description:  "maximum time to wait for SSH in the node before provisioning it (ie, while it is booting)",
+				ValidateFunc: common.ValidateDuration,
+			},
+			"ssh_keepalive": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"interval": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      int(ssh.DefKeepAliveInterval / time.Second),
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "seconds between keepalives sent to the node",
+						},
+						"max_count": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      ssh.DefKeepAliveMaxCount,
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "number of keepalives without an answer before the connection is reopened",
+						},
+					},
+				},
+			},
+			"coordination_lock": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     ssh.DefClusterLockName,
+							Description: "name of the Lease used as a lock by all the Terraform runs managing the cluster",
+						},
+						"namespace": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     ssh.DefClusterLockNamespace,
+							Description: "namespace of the Lease",
+						},
+						"timeout": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      int(ssh.DefClusterLockTimeout / time.Second),
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "seconds to wait for the lock",
+						},
+						"lease_duration": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      int(ssh.DefClusterLockDuration / time.Second),
+							ValidateFunc: validation.IntAtLeast(1),
+							Description:  "seconds after which a lock is considered stale and can be taken over",
+						},
+					},
+				},
+			},
+			"maintenance_window": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"schedule": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "cron-like schedule for the start of the windows (ie, '0 2 * * 6')",
+							ValidateFunc: validateSchedule,
+						},
+						"duration": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "length of every window (ie, 4h)",
+							ValidateFunc: common.ValidateDuration,
+						},
+						"timezone": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     "UTC",
+							Description: "timezone for the schedule (ie, Europe/Madrid)",
+						},
+						"max_wait": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "0s",
+							Description:  "maximum time to wait for the next window, instead of failing",
+							ValidateFunc: common.ValidateDuration,
+						},
+					},
+				},
+			},
+			"replay_execution_manifest": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "execution manifest (recorded with contents) to replay in the node, instead of the regular provisioning",
+			},
 			"nodename": {
 				Type:        schema.TypeString,
 				Optional:    true,
@@ -73,18 +390,300 @@ func Provisioner() terraform.ResourceProvisioner {
 				Description:  "for masters, IP/DNS:port to listen at",
 				ValidateFunc: common.ValidateHostPort,
 			},
+			"advertise_address": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "IP address advertised to the cluster by this node (kubelet node IP and, for masters, the API server advertise address)",
+				ValidateFunc: validation.SingleIP(),
+			},
+			"api_connect_address": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "IP/DNS[:port] this node uses for connecting to the API server when joining (defaults to the 'join' address)",
+				ValidateFunc: common.ValidateHostPort,
+			},
+			"cert_sans": {
+				Type:        schema.TypeList,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "for the seeder, extra names and IPs for the API server certificate (ie, a public IP in a NAT)",
+			},
+			"pod_cidr": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "CIDR for the pods in this node (set in the Node before it joins), instead of the one allocated by the controller manager (must be inside the cluster pods CIDR)",
+				ValidateFunc: validation.CIDRNetwork(0, 32),
+			},
+			"runtime_classes": {
+				Type: schema.TypeList,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice([]string{"gvisor", "kata"}, false),
+				},
+				Optional:    true,
+				Description: "additional runtime classes available in this node: gvisor and/or kata",
+			},
+			"plan_only": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(common.PlanOnlyEnvVar, false),
+				Description: "do not run anything in the node: just print the commands and uploads that would be done",
+			},
+			"filter_banner": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "ignore anything printed by the remote shell (ie, banners) before the output of the commands",
+			},
+			"reboot_if_required": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "reboot the node when it is required after installing packages, kernel modules or runtimes",
+			},
+			"reboot_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      defRebootTimeout.String(),
+				Description:  "maximum time to wait for the node to be reachable again after a reboot",
+				ValidateFunc: common.ValidateDuration,
+			},
 			"prevent_sudo": {
 				Type:        schema.TypeBool,
 				Optional:    true,
 				Default:     false,
 				Description: "prevent the use of sudo",
 			},
+			"privilege_escalation": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      ssh.EscalationSudo,
+				ValidateFunc: validation.StringInSlice(ssh.EscalationMethods, false),
+				Description:  "method for running commands as root: sudo, doas, su or none (when the user is already root)",
+			},
+			"writable_paths": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Description: "directories where the SSH user can write (ie, with ACLs), so files there are managed without sudo",
+			},
+			"host_key_checking": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      ssh.HostKeyOff,
+				ValidateFunc: validation.StringInSlice(ssh.HostKeyModes, false),
+				Description:  "verification of the host key of the node: strict, accept-new or off",
+			},
+			"known_hosts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     ssh.DefKnownHostsFile,
+				Description: "known_hosts file used for verifying the host key of the node",
+			},
+			"host_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "expected host key of the node (instead of the keys in the known_hosts file)",
+			},
+			"ssh_agent_forwarding": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "forward the local SSH agent to the node, so the commands can use the keys in the agent",
+			},
+			"sudo_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Default:     "",
+				Description: "password for sudo (sent in the stdin of the commands)",
+			},
+			"remote_tmp": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Default:     "",
+				Description: "remote directory for temporary files (defaults to /tmp)",
+			},
 			"manifests": {
 				Type:        schema.TypeList,
 				Elem:        &schema.Schema{Type: schema.TypeString},
 				Optional:    true,
 				Description: "list of manifests to load in the API server once the master is setup",
 			},
+			"manifest_checksums": {
+				Type:        schema.TypeMap,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Optional:    true,
+				Description: "pinned SHA256 checksums (by URL) of the manifests loaded from URLs: they are downloaded and verified in the local machine",
+			},
+			"require_manifest_checksums": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "refuse to load manifests from URLs without a pinned checksum in manifest_checksums",
+			},
+			"preflight": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"peers": {
+							Type:        schema.TypeList,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Optional:    true,
+							Description: "list of addresses of other nodes in the cluster that must be reachable from this node",
+						},
+						"strict": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "abort the provisioning when some connectivity check fails",
+						},
+						"min_entropy": {
+							Type:        schema.TypeInt,
+							Optional:    true,
+							Default:     defMinEntropy,
+							Description: "minimum entropy available in the node (a warning is printed otherwise)",
+						},
+						"install_rng": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "install haveged (or rng-tools) when the entropy available is too low",
+						},
+						"force_clean": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "remove other Kubernetes distributions (k3s, microk8s...) found in the node",
+						},
+					},
+				},
+			},
+			"annotations": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"workspace": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Terraform workspace (defaults to the TF_WORKSPACE environment variable, or \"default\")",
+						},
+						"address": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "address of the resource that manages this node (defaults to the instance ID)",
+						},
+						"workspace_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefAnnotationWorkspaceKey,
+							Description: "annotation key for the workspace",
+						},
+						"address_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefAnnotationAddressKey,
+							Description: "annotation key for the resource address",
+						},
+						"timestamp_key": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefAnnotationTimestampKey,
+							Description: "annotation key for the time of the apply",
+						},
+						"extra": {
+							Type:        schema.TypeMap,
+							Optional:    true,
+							Elem:        &schema.Schema{Type: schema.TypeString},
+							Description: "extra annotations for the node",
+						},
+					},
+				},
+			},
+			"backup": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"dir": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefBackupDir,
+							Description: "local directory (or storage URL, like s3://bucket/prefix) where the backups will be stored",
+						},
+						"retention": {
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							Description:  "number of backups to keep for each node (0 for keeping all of them)",
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+						"only": {
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Default:     false,
+							Description: "when true, just backup the node, without provisioning it",
+						},
+					},
+				},
+			},
+			"upgrade": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"version": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "kubernetes version the node will be upgraded to",
+						},
+						"node_selector": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "label selector: only the nodes matching it are upgraded",
+						},
+						"state_file": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      common.DefUpgradeStatePath,
+							Description:  "file in the node where the completed upgrade phases are recorded",
+							ValidateFunc: common.ValidateAbsPath,
+						},
+					},
+				},
+			},
+			"upgrade_canary": {
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"selector": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "label selector for the canary workers, upgraded and validated before the other workers",
+						},
+						"check": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "command run in the canary nodes after the upgrade for validating them",
+						},
+						"timeout": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Default:     common.DefUpgradeCanaryTimeout,
+							Description: "timeout for the canary nodes being Ready after the upgrade",
+						},
+					},
+				},
+			},
 			"install": {
 				// NOTE: default values for nested blocks are not available if the "install" block
 				// has not been provided at all.
@@ -115,6 +714,92 @@ func Provisioner() terraform.ResourceProvisioner {
 							Optional:    true,
 							Description: "kubeadm version to install.",
 						},
+						"binaries": {
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"version": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "version of the kubeadm/kubelet/kubectl binaries (defaults to the kubernetes version)",
+									},
+									"arch": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Default:     common.DefBinariesArch,
+										Description: "architecture of the binaries",
+									},
+									"cni_version": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "version of the CNI plugins to install (not installed when empty)",
+									},
+									"cache_dir": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "local directory for caching the downloaded binaries (defaults to the user's cache directory)",
+									},
+									"cosign_path": {
+										Type:        schema.TypeString,
+										Optional:    true,
+										Description: "path to the cosign binary used for verifying signatures (defaults to cosign in the $PATH)",
+									},
+									"require_signatures": {
+										Type:        schema.TypeBool,
+										Optional:    true,
+										Default:     false,
+										Description: "when true, fail if some binary has no signature verification configured",
+									},
+									"artifact": {
+										Type:        schema.TypeList,
+										Optional:    true,
+										Description: "checksum pinning and signature verification for a specific binary",
+										Elem: &schema.Resource{
+											Schema: map[string]*schema.Schema{
+												"name": {
+													Type:         schema.TypeString,
+													Required:     true,
+													Description:  "name of the binary: kubeadm, kubelet, kubectl or cni-plugins",
+													ValidateFunc: validation.StringInSlice([]string{"kubeadm", "kubelet", "kubectl", "cni-plugins"}, false),
+												},
+												"checksum": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "pinned SHA256 of the binary (instead of the published checksum)",
+												},
+												"signature_url": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "URL of the cosign signature (defaults to the binary URL + .sig)",
+												},
+												"key": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "public key (path, URL or KMS URI) for verifying the signature",
+												},
+												"certificate_url": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "URL of the signing certificate, for keyless signatures",
+												},
+												"certificate_identity": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "identity expected in the signing certificate (required for keyless signatures)",
+												},
+												"certificate_oidc_issuer": {
+													Type:        schema.TypeString,
+													Optional:    true,
+													Description: "OIDC issuer expected in the signing certificate (required for keyless signatures)",
+												},
+											},
+										},
+									},
+								},
+							},
+						},
 						"sysconfig_path": {
 							Type:        schema.TypeString,
 							Default:     common.DefKubeletSysconfigPath,
@@ -150,11 +835,59 @@ func Provisioner() terraform.ResourceProvisioner {
 			},
 		},
 
-		ApplyFunc: applyFn,
+		ApplyFunc:    applyFn,
+		ValidateFunc: validateFn,
+
+		// note: we cannot "validate" most of the config passed from the provisioner,
+		// as the validation is done before that config is created
+	}
+}
+
+// validateSchedule validates a cron-like schedule (ie, "0 2 * * 6")
+func validateSchedule(v interface{}, k string) (ws []string, errors []error) {
+	if _, err := ssh.ParseSchedule(v.(string)); err != nil {
+		errors = append(errors, fmt.Errorf("%q is not a valid schedule: %s", k, err))
+	}
+	return
+}
+
+// validateFn checks the installation methods can be used together, and the flags in
+// the configuration are supported by the version in the "upgrade" block. This is only
+// possible when both values are known when validating (ie, the config is not computed).
+func validateFn(c *terraform.ResourceConfig) ([]string, []error) {
+	// the packages installed by the auto-installation script would replace the binaries uploaded
+	if auto, ok := c.Get("install.0.auto"); ok && auto == true {
+		if _, ok := c.Get("install.0.binaries"); ok {
+			return nil, []error{fmt.Errorf("'install.auto' cannot be used with 'install.binaries': use an 'inline' or 'script' for installing the rest of the requirements")}
+		}
+	}
+
+	if c.IsComputed("upgrade.0.version") || c.IsComputed("config.init") {
+		return nil, nil
+	}
+	version, ok := c.Get("upgrade.0.version")
+	if !ok {
+		return nil, nil
+	}
+	cfg, ok := c.Get("config.init")
+	if !ok {
+		return nil, nil
+	}
+
+	configBytes, err := common.FromTerraformSafeString(cfg.(string))
+	if err != nil {
+		return nil, nil
+	}
+	initConfig, err := common.YAMLToInitConfig(configBytes)
+	if err != nil {
+		return nil, nil
+	}
 
-		// note: we cannot "validate" config passed from the provisioner, as the
-		// validation is done before that config is created
+	warnings, err := common.FixInitConfigForVersion(initConfig, version.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf("cannot upgrade to %s: %s", version, err)}
 	}
+	return warnings, nil
 }
 
 //
@@ -235,6 +968,21 @@ func getTokenFromResourceData(d *schema.ResourceData) string {
 	return ""
 }
 
+// getTokenExpiresFromResourceData returns the expiration time of the token in the ResourceData
+// (and false if it is unknown, ie, for configurations created by older versions of the provider)
+func getTokenExpiresFromResourceData(d *schema.ResourceData) (time.Time, bool) {
+	s, _ := common.GetProvisionerConfig(d)["token_expires"].(string)
+	if s == "" {
+		return time.Time{}, false
+	}
+	expires, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		ssh.Debug("could not parse the token expiration time %q: %s", s, err)
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
 // getKubectlFromResourceData returns the kubectl binary path from the config
 func getKubectlFromResourceData(d *schema.ResourceData) string {
 	if kubectlPathOpt, ok := d.GetOk("install.0.kubectl_path"); ok {
@@ -243,6 +991,242 @@ func getKubectlFromResourceData(d *schema.ResourceData) string {
 	return common.DefKubectlPath
 }
 
+// getAdvertiseAddressFromResourceData returns the address this node advertises to the cluster
+func getAdvertiseAddressFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("advertise_address"); ok {
+		return strings.TrimSpace(opt.(string))
+	}
+	return ""
+}
+
+// getAPIConnectAddressFromResourceData returns the address used for connecting
+// to the API server, defaulting to the "join" address
+func getAPIConnectAddressFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("api_connect_address"); ok && strings.TrimSpace(opt.(string)) != "" {
+		return strings.TrimSpace(opt.(string))
+	}
+	return getJoinFromResourceData(d)
+}
+
+// getCertSANsFromResourceData returns the extra certSANs for this node
+func getCertSANsFromResourceData(d *schema.ResourceData) []string {
+	res := []string{}
+	for _, sanRaw := range d.Get("cert_sans").([]interface{}) {
+		if san := common.CertSAN(sanRaw.(string)); san != "" {
+			res = append(res, san)
+		}
+	}
+	return res
+}
+
+// getExecutionManifestDirFromResourceData returns the directory for the execution manifests
+func getExecutionManifestDirFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("execution_manifest_dir"); ok {
+		return strings.TrimSpace(opt.(string))
+	}
+	return ""
+}
+
+// getShellWrapper returns the shell for wrapping all the commands executed
+// in the node, detecting the login shell when needed
+func getShellWrapper(ctx context.Context, d *schema.ResourceData) (string, error) {
+	switch shell := d.Get("remote_shell").(string); shell {
+	case "none":
+		return "", nil
+	case "sh", "bash":
+		return shell, nil
+	}
+	return ssh.DetectShellWrapper(ctx)
+}
+
+// getExecEnvFromResourceData returns the environment pinned for the commands
+// executed in the node (nil when the environment must not be sanitized)
+func getExecEnvFromResourceData(d *schema.ResourceData) *ssh.ExecEnv {
+	if _, ok := d.GetOk("exec_env.0"); !ok {
+		return &ssh.ExecEnv{Locale: ssh.DefExecLocale, Path: ssh.DefExecPath}
+	}
+	if !d.Get("exec_env.0.sanitize").(bool) {
+		return nil
+	}
+	return &ssh.ExecEnv{
+		Locale: d.Get("exec_env.0.locale").(string),
+		Path:   d.Get("exec_env.0.path").(string),
+	}
+}
+
+// getAuditLogFromResourceData returns the audit log for the node (nil when not enabled)
+func getAuditLogFromResourceData(d *schema.ResourceData) *ssh.AuditLog {
+	if _, ok := d.GetOk("audit_log.0"); !ok {
+		return nil
+	}
+	runID := d.Get("audit_log.0.run_id").(string)
+	if runID == "" {
+		runID = getRunID()
+	}
+	return &ssh.AuditLog{Path: d.Get("audit_log.0.path").(string), RunID: runID}
+}
+
+// getKeepAliveFromResourceData returns the keepalives configuration (or nil if not enabled)
+func getKeepAliveFromResourceData(d *schema.ResourceData) *ssh.KeepAlive {
+	if _, ok := d.GetOk("ssh_keepalive.0"); !ok {
+		return nil
+	}
+	return &ssh.KeepAlive{
+		Interval: time.Duration(d.Get("ssh_keepalive.0.interval").(int)) * time.Second,
+		MaxCount: d.Get("ssh_keepalive.0.max_count").(int),
+	}
+}
+
+// getClusterLockFromResourceData returns the coordination lock for the cluster (if enabled)
+func getClusterLockFromResourceData(d *schema.ResourceData) *ssh.ClusterLock {
+	if _, ok := d.GetOk("coordination_lock.0"); !ok {
+		return nil
+	}
+	hostname, _ := os.Hostname()
+	return &ssh.ClusterLock{
+		Name:      d.Get("coordination_lock.0.name").(string),
+		Namespace: d.Get("coordination_lock.0.namespace").(string),
+		Holder:    hostname + "/" + getRunID(),
+		Timeout:   time.Duration(d.Get("coordination_lock.0.timeout").(int)) * time.Second,
+		Duration:  time.Duration(d.Get("coordination_lock.0.lease_duration").(int)) * time.Second,
+	}
+}
+
+// getMaintenanceWindowFromResourceData returns the maintenance window (or nil if not enabled)
+func getMaintenanceWindowFromResourceData(d *schema.ResourceData) (*ssh.MaintenanceWindow, error) {
+	if _, ok := d.GetOk("maintenance_window.0"); !ok {
+		return nil, nil
+	}
+	duration, err := time.ParseDuration(d.Get("maintenance_window.0.duration").(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window duration: %s", err)
+	}
+	maxWait, err := time.ParseDuration(d.Get("maintenance_window.0.max_wait").(string))
+	if err != nil {
+		return nil, fmt.Errorf("invalid maintenance window max_wait: %s", err)
+	}
+	return ssh.NewMaintenanceWindow(
+		d.Get("maintenance_window.0.schedule").(string),
+		duration,
+		d.Get("maintenance_window.0.timezone").(string),
+		maxWait)
+}
+
+// getLogFileFromResourceData returns the path pattern for the log file of the node
+func getLogFileFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("log_file"); ok {
+		return strings.TrimSpace(opt.(string))
+	}
+	return ""
+}
+
+// getWritablePathsFromResourceData returns the directories where the SSH user can write
+func getWritablePathsFromResourceData(d *schema.ResourceData) []string {
+	res := []string{}
+	for _, p := range d.Get("writable_paths").([]interface{}) {
+		if s := strings.TrimSpace(p.(string)); s != "" {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// getTranscriptFileFromResourceData returns the pattern for the transcript file of the node
+func getTranscriptFileFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("transcript_file"); ok {
+		return strings.TrimSpace(opt.(string))
+	}
+	return ""
+}
+
+// getReplayExecutionManifestFromResourceData returns the execution manifest file to replay
+func getReplayExecutionManifestFromResourceData(d *schema.ResourceData) string {
+	if opt, ok := d.GetOk("replay_execution_manifest"); ok {
+		return strings.TrimSpace(opt.(string))
+	}
+	return ""
+}
+
+// getPodCIDRFromResourceData returns the per-node pods CIDR specified in the ResourceData
+func getPodCIDRFromResourceData(d *schema.ResourceData) string {
+	if podCIDROpt, ok := d.GetOk("pod_cidr"); ok {
+		return strings.TrimSpace(podCIDROpt.(string))
+	}
+	return ""
+}
+
+// getBackupDirFromResourceData returns the local directory for backups
+func getBackupDirFromResourceData(d *schema.ResourceData) string {
+	if dirOpt, ok := d.GetOk("backup.0.dir"); ok {
+		return dirOpt.(string)
+	}
+	return common.DefBackupDir
+}
+
+// getStorageConfigFromResourceData returns the configuration for the artifacts storage
+func getStorageConfigFromResourceData(d *schema.ResourceData) storage.Config {
+	config := common.GetProvisionerConfig(d)
+	get := func(k string) string {
+		if v, ok := config["storage_"+k]; ok {
+			return v.(string)
+		}
+		return ""
+	}
+
+	return storage.Config{
+		Endpoint:       get("endpoint"),
+		Region:         get("region"),
+		AccessKey:      get("access_key"),
+		SecretKey:      get("secret_key"),
+		GCSCredentials: get("gcs_credentials"),
+		AzureAccount:   get("azure_account"),
+		AzureSASToken:  get("azure_sas_token"),
+	}
+}
+
+// getClusterIDFromResourceData returns an ID for the cluster (from the CA certificate)
+func getClusterIDFromResourceData(d *schema.ResourceData) string {
+	caCrt, ok := common.GetProvisionerConfig(d)["ca_crt"].(string)
+	if !ok || caCrt == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(caCrt))
+	return hex.EncodeToString(sum[:])
+}
+
+// getParallelismFromEnv returns the "-parallelism" passed to Terraform
+// in the TF_CLI_ARGS* environment variables (or 0 if not found)
+func getParallelismFromEnv() int {
+	for _, envVar := range []string{"TF_CLI_ARGS_apply", "TF_CLI_ARGS"} {
+		for _, arg := range strings.Fields(os.Getenv(envVar)) {
+			if !strings.HasPrefix(arg, "-parallelism=") {
+				continue
+			}
+			if n, err := strconv.Atoi(strings.TrimPrefix(arg, "-parallelism=")); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
+// getMaxParallelNodesFromResourceData returns the maximum number of nodes
+// that can be provisioned concurrently (0 means no limit)
+func getMaxParallelNodesFromResourceData(d *schema.ResourceData) int {
+	if n, ok := d.Get("max_parallel_nodes").(int); ok && n > 0 {
+		return n
+	}
+	return getParallelismFromEnv()
+}
+
+// getKubeVersionFromResourceData returns the kubernetes version in the config
+func getKubeVersionFromResourceData(d *schema.ResourceData) string {
+	if v, ok := common.GetProvisionerConfig(d)["kube_version"].(string); ok && v != "" {
+		return v
+	}
+	return common.DefKubernetesVersion
+}
+
 // getNodenameFromResourceData returns the nodename specified in the ResourceData
 func getNodenameFromResourceData(d *schema.ResourceData) string {
 	if nodenameOpt, ok := d.GetOk("nodename"); ok {
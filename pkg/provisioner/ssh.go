@@ -16,11 +16,212 @@ package provisioner
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/credentials"
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
 )
 
+// getConnStateWithCredentials returns a copy of the instance state with the private key
+// obtained from the "private_key_source" (if provided). The key is only kept in memory
+// in the copy, so it is never stored in the Terraform state.
+func getConnStateWithCredentials(ctx context.Context, d *schema.ResourceData, s *terraform.InstanceState) (*terraform.InstanceState, error) {
+	source := d.Get("private_key_source").(string)
+	if source == "" {
+		return s, nil
+	}
+
+	ssh.Debug("obtaining private key from the credentials source")
+	key, err := credentials.Resolve(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("could not obtain the private key: %s", err)
+	}
+
+	res := s.DeepCopy()
+	if res.Ephemeral.ConnInfo == nil {
+		res.Ephemeral.ConnInfo = map[string]string{}
+	}
+	res.Ephemeral.ConnInfo["private_key"] = key
+	return res, nil
+}
+
+// getConnStateWithCertificate returns a copy of the instance state with the OpenSSH
+// certificate obtained from the "certificate_source" (if provided), checking that
+// the certificate (from the source or from the connection) can be used now
+func getConnStateWithCertificate(ctx context.Context, d *schema.ResourceData, s *terraform.InstanceState) (*terraform.InstanceState, error) {
+	res := s
+	if source := d.Get("certificate_source").(string); source != "" {
+		ssh.Debug("obtaining the SSH certificate from the credentials source")
+		cert, err := credentials.Resolve(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("could not obtain the SSH certificate: %s", err)
+		}
+		res = s.DeepCopy()
+		if res.Ephemeral.ConnInfo == nil {
+			res.Ephemeral.ConnInfo = map[string]string{}
+		}
+		res.Ephemeral.ConnInfo["certificate"] = strings.TrimSpace(cert)
+	}
+
+	if cert := res.Ephemeral.ConnInfo["certificate"]; cert != "" {
+		if err := ssh.CheckCertificate(cert, res.Ephemeral.ConnInfo["user"], time.Now()); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+const (
+	// candidate source for using the SSH agent instead of a private key
+	agentKeySource = "agent"
+)
+
+var (
+	// the candidate key source that worked for every host
+	workingKeySources      = map[string]string{}
+	workingKeySourcesMutex sync.Mutex
+)
+
+// fatalConnError is a connection error that should not be retried
+type fatalConnError struct {
+	err error
+}
+
+func (e fatalConnError) Error() string     { return e.err.Error() }
+func (e fatalConnError) FatalError() error { return e.err }
+
+// getPrivateKeySourcesFromResourceData returns the candidate sources for the private key
+func getPrivateKeySourcesFromResourceData(d *schema.ResourceData) []string {
+	sources := []string{}
+	for _, source := range d.Get("private_key_sources").([]interface{}) {
+		if s, ok := source.(string); ok && s != "" {
+			sources = append(sources, s)
+		}
+	}
+	return sources
+}
+
+// sortKeySourcesForHost returns the candidate key sources with the one that
+// worked for this host (if any) in the first place
+func sortKeySourcesForHost(host string, sources []string) []string {
+	workingKeySourcesMutex.Lock()
+	working, ok := workingKeySources[host]
+	workingKeySourcesMutex.Unlock()
+
+	if !ok {
+		return sources
+	}
+	res := []string{working}
+	for _, source := range sources {
+		if source != working {
+			res = append(res, source)
+		}
+	}
+	if len(res) > len(sources) {
+		// the working source is not a candidate anymore
+		return sources
+	}
+	return res
+}
+
+// setWorkingKeySourceForHost remembers the key source that worked for a host
+func setWorkingKeySourceForHost(host string, source string) {
+	workingKeySourcesMutex.Lock()
+	defer workingKeySourcesMutex.Unlock()
+	workingKeySources[host] = source
+}
+
+// getConnStateWithKeySource returns a copy of the instance state for connecting
+// with a candidate key source (or with the SSH agent)
+func getConnStateWithKeySource(ctx context.Context, s *terraform.InstanceState, source string) (*terraform.InstanceState, error) {
+	res := s.DeepCopy()
+	if res.Ephemeral.ConnInfo == nil {
+		res.Ephemeral.ConnInfo = map[string]string{}
+	}
+
+	if source == agentKeySource {
+		delete(res.Ephemeral.ConnInfo, "private_key")
+		res.Ephemeral.ConnInfo["agent"] = "true"
+		return res, nil
+	}
+
+	key, err := credentials.Resolve(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	res.Ephemeral.ConnInfo["private_key"] = key
+	res.Ephemeral.ConnInfo["agent"] = "false"
+	return res, nil
+}
+
+// isAuthError returns true if the connection error is an authentication error
+func isAuthError(err error) bool {
+	return strings.Contains(err.Error(), "unable to authenticate")
+}
+
+// getCommunicatorWithCandidateKeys gets a new communicator for the remote machine,
+// trying the candidate key sources in order until one is accepted. The source
+// that worked is remembered, so it is tried first in other connections to the same host.
+func getCommunicatorWithCandidateKeys(ctx context.Context, o terraform.UIOutput, s *terraform.InstanceState, sources []string) (communicator.Communicator, error) {
+	host := getHostAddressFromConnInfo(s.Ephemeral.ConnInfo)
+
+	// (only used for getting the connection timeout)
+	base, err := communicator.New(s)
+	if err != nil {
+		return nil, err
+	}
+
+	retryCtx, cancel := context.WithTimeout(ctx, base.Timeout())
+	defer cancel()
+
+	var comm communicator.Communicator
+	err = communicator.Retry(retryCtx, func() error {
+		errs := []string{}
+		for _, source := range sortKeySourcesForHost(host, sources) {
+			connState, err := getConnStateWithKeySource(ctx, s, source)
+			if err != nil {
+				ssh.Debug("skipping candidate key: %s", err)
+				errs = append(errs, err.Error())
+				continue
+			}
+			c, err := communicator.New(connState)
+			if err != nil {
+				return err
+			}
+			if err := c.Connect(o); err != nil {
+				if !isAuthError(err) {
+					// the host is probably not ready yet: retry later
+					return err
+				}
+				ssh.Debug("candidate key not accepted by %s", host)
+				errs = append(errs, err.Error())
+				continue
+			}
+
+			setWorkingKeySourceForHost(host, source)
+			comm = c
+			return nil
+		}
+		return fatalConnError{fmt.Errorf("none of the candidate keys was accepted by %s: %s", host, strings.Join(errs, "; "))}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return comm, nil
+}
+
 // getCommunicator gets a new communicator for the remote machine
 func getCommunicator(ctx context.Context, o terraform.UIOutput, s *terraform.InstanceState) (communicator.Communicator, error) {
 	// Get a new communicator
@@ -40,11 +241,447 @@ func getCommunicator(ctx context.Context, o terraform.UIOutput, s *terraform.Ins
 		return nil, err
 	}
 
-	// Wait for the context to end and then disconnect
-	go func() {
-		<-ctx.Done()
+	return comm, err
+}
+
+// getHostKeyVerificationFromResourceData returns the verification of host keys
+func getHostKeyVerificationFromResourceData(d *schema.ResourceData) ssh.HostKeyVerification {
+	return ssh.HostKeyVerification{
+		Mode:           d.Get("host_key_checking").(string),
+		KnownHostsFile: strings.TrimSpace(d.Get("known_hosts_file").(string)),
+		HostKey:        strings.TrimSpace(d.Get("host_key").(string)),
+	}
+}
+
+// getConnStateWithVerifiedHostKey verifies the host key of the node, returning a copy
+// of the instance state where the verified key is pinned, so the real connection
+// fails if it does not present the same key (ie, when a man-in-the-middle answers
+// the verification honestly). The provisioning fails when the key cannot be pinned.
+func getConnStateWithVerifiedHostKey(ctx context.Context, o terraform.UIOutput, v ssh.HostKeyVerification, s *terraform.InstanceState) (*terraform.InstanceState, error) {
+	if v.Mode == "" || v.Mode == ssh.HostKeyOff {
+		warnHostKeyNotVerified(o, s)
+		return s, nil
+	}
+
+	// the communicator checks the bastion with the `host_key` of the node (and ignores
+	// the `bastion_host_key`), so we cannot pin the key of the bastion
+	if s.Ephemeral.ConnInfo["bastion_host"] != "" {
+		return nil, fmt.Errorf("the host key cannot be verified through the bastion host in the connection: use a 'jump_host' instead")
+	}
+
+	hp, hostKey, err := verifyHostKey(ctx, v, s)
+	if err != nil {
+		return nil, err
+	}
+	return getConnStateWithPinnedHostKey(s, hp.Host, hostKey)
+}
+
+// warnHostKeyNotVerified warns about connections where the host key is not verified
+func warnHostKeyNotVerified(o terraform.UIOutput, s *terraform.InstanceState) {
+	if s.Ephemeral.ConnInfo["host_key"] == "" {
+		o.Output("WARNING: the host key of the node is not verified: set 'host_key_checking' (or the 'host_key' in the connection) for production environments")
+	}
+}
+
+// verifyHostKey verifies the host key of the node in the connection, returning its
+// address and the key verified (in authorized_keys format)
+func verifyHostKey(ctx context.Context, v ssh.HostKeyVerification, s *terraform.InstanceState) (ssh.HostPort, string, error) {
+	hp, err := ssh.ParseHostPort(s.Ephemeral.ConnInfo["host"], ssh.DefSSHPort)
+	if err != nil {
+		return hp, "", err
+	}
+	if port, err := strconv.Atoi(s.Ephemeral.ConnInfo["port"]); err == nil && port > 0 {
+		hp.Port = port
+	}
+	hp.User = ""
+	address := hp.String()
+
+	// (only used for getting the connection timeout)
+	base, err := communicator.New(s)
+	if err != nil {
+		return hp, "", err
+	}
+
+	retryCtx, cancel := context.WithTimeout(ctx, base.Timeout())
+	defer cancel()
+
+	var hostKey string
+	err = communicator.Retry(retryCtx, func() error {
+		key, err := v.Verify(address, base.Timeout())
+		if _, ok := err.(ssh.HostKeyError); ok {
+			return fatalConnError{err}
+		}
+		hostKey = key
+		return err
+	})
+	if err != nil {
+		return hp, "", err
+	}
+	return hp, hostKey, nil
+}
+
+// getConnStateWithPinnedHostKey returns a copy of the instance state where the
+// communicator only accepts the `hostKey` for the `host` (unless the connection
+// already has its own `host_key`, that the communicator checks anyway)
+func getConnStateWithPinnedHostKey(s *terraform.InstanceState, host string, hostKey string) (*terraform.InstanceState, error) {
+	res := s.DeepCopy()
+	if res.Ephemeral.ConnInfo["host_key"] != "" {
+		return res, nil
+	}
+	if hostKey == "" {
+		return nil, fmt.Errorf("the host key of %s could not be obtained", host)
+	}
+
+	// the communicator matches the key with a "host:port" pattern, that does not work
+	// for IPv6 addresses
+	if strings.Contains(host, ":") {
+		return nil, fmt.Errorf("the host key cannot be pinned for the IPv6 address %s: set the 'host_key' in the connection", host)
+	}
+	res.Ephemeral.ConnInfo["host_key"] = hostKey
+	return res, nil
+}
+
+// getJumpHostsFromResourceData returns the chain of jump hosts for reaching the node,
+// using the user in the connection when no user is specified
+func getJumpHostsFromResourceData(ctx context.Context, d *schema.ResourceData, user string) ([]ssh.JumpHost, error) {
+	// jump hosts without a `host_key` are verified like the node
+	// (but the `host_key` in the provisioner is the key of the node)
+	v := getHostKeyVerificationFromResourceData(d)
+	v.HostKey = ""
+
+	res := []ssh.JumpHost{}
+	for i := range d.Get("jump_host").([]interface{}) {
+		prefix := fmt.Sprintf("jump_host.%d.", i)
+		hp, err := ssh.ParseHostPort(d.Get(prefix+"host").(string), ssh.DefSSHPort)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jump host: %s", err)
+		}
+		if hp.User == "" {
+			hp.User = user
+		}
+		hop := ssh.JumpHost{
+			Address:      hp,
+			PrivateKey:   d.Get(prefix + "private_key").(string),
+			Password:     d.Get(prefix + "password").(string),
+			Agent:        d.Get(prefix + "agent").(bool),
+			HostKey:      strings.TrimSpace(d.Get(prefix + "host_key").(string)),
+			Verification: v,
+		}
+		if source := d.Get(prefix + "private_key_source").(string); source != "" {
+			key, err := credentials.Resolve(ctx, source)
+			if err != nil {
+				return nil, fmt.Errorf("could not obtain the private key for the jump host %s: %s", hp, err)
+			}
+			hop.PrivateKey = key
+		}
+		hop.Certificate = strings.TrimSpace(d.Get(prefix + "certificate").(string))
+		if source := d.Get(prefix + "certificate_source").(string); source != "" {
+			cert, err := credentials.Resolve(ctx, source)
+			if err != nil {
+				return nil, fmt.Errorf("could not obtain the certificate for the jump host %s: %s", hp, err)
+			}
+			hop.Certificate = strings.TrimSpace(cert)
+		}
+		if hop.Certificate != "" {
+			if err := ssh.CheckCertificate(hop.Certificate, hp.User, time.Now()); err != nil {
+				return nil, fmt.Errorf("invalid certificate for the jump host %s: %s", hp, err)
+			}
+		}
+		// (use the SSH agent when no other credentials are provided, like in the connection)
+		if hop.PrivateKey == "" && hop.Password == "" && ssh.HasLocalAgent() {
+			hop.Agent = true
+		}
+		res = append(res, hop)
+	}
+	return res, nil
+}
+
+// getCommunicatorWithAgentForwarding wraps a communicator, so the commands are run
+// with the local SSH agent forwarded to the node
+func getCommunicatorWithAgentForwarding(comm communicator.Communicator, s *terraform.InstanceState) (communicator.Communicator, error) {
+	connInfo := s.Ephemeral.ConnInfo
+	if connInfo["bastion_host"] != "" {
+		return nil, fmt.Errorf("the SSH agent cannot be forwarded through the bastion host in the connection: use a jump_host")
+	}
+
+	// the key of the node (verified or provided, and pinned in the connection) is checked
+	// in the connection with the agent forwarded: never forward it to an unverified node
+	if connInfo["host_key"] == "" {
+		return nil, fmt.Errorf("the SSH agent is only forwarded to nodes with a verified host key: set the 'host_key_checking' (or the 'host_key' in the connection)")
+	}
+
+	hp, err := ssh.ParseHostPort(connInfo["host"], ssh.DefSSHPort)
+	if err != nil {
+		return nil, err
+	}
+	if port, err := strconv.Atoi(connInfo["port"]); err == nil && port > 0 {
+		hp.Port = port
+	}
+	hp.User = connInfo["user"]
+
+	host := ssh.SSHHost{
+		Address:     hp,
+		PrivateKey:  connInfo["private_key"],
+		Certificate: connInfo["certificate"],
+		Password:    connInfo["password"],
+		Agent:       true,
+		HostKey:     connInfo["host_key"],
+	}
+	return ssh.NewAgentForwardingCommunicator(comm, host, comm.Timeout())
+}
+
+// getConnStateThroughJumpHosts opens a tunnel to the node through a chain of jump
+// hosts, returning a copy of the instance state for connecting through the tunnel.
+// The host key of the node is verified through the tunnel too.
+func getConnStateThroughJumpHosts(ctx context.Context, o terraform.UIOutput, hops []ssh.JumpHost, v ssh.HostKeyVerification, s *terraform.InstanceState) (*ssh.JumpTunnel, *terraform.InstanceState, error) {
+	if s.Ephemeral.ConnInfo["bastion_host"] != "" {
+		return nil, nil, fmt.Errorf("the bastion host in the connection cannot be used with jump hosts: add it as the first jump host")
+	}
+
+	hp, err := ssh.ParseHostPort(s.Ephemeral.ConnInfo["host"], ssh.DefSSHPort)
+	if err != nil {
+		return nil, nil, err
+	}
+	if port, err := strconv.Atoi(s.Ephemeral.ConnInfo["port"]); err == nil && port > 0 {
+		hp.Port = port
+	}
+	hp.User = ""
+	target := hp.String()
+
+	// (only used for getting the connection timeout)
+	base, err := communicator.New(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var tunnel *ssh.JumpTunnel
+	retryCtx, cancel := context.WithTimeout(ctx, base.Timeout())
+	defer cancel()
+	err = communicator.Retry(retryCtx, func() error {
+		tunnel, err = ssh.NewJumpTunnel(hops, target, base.Timeout())
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ssh.Debug("tunnel to %s through %d jump hosts listening at %s", target, len(hops), tunnel.Addr())
+
+	// the key in the connection would be checked against the local address of the tunnel,
+	// so we verify it ourselves (through the tunnel)...
+	res := s.DeepCopy()
+	if key := res.Ephemeral.ConnInfo["host_key"]; key != "" && v.HostKey == "" {
+		v.HostKey = key
+		if v.Mode == "" || v.Mode == ssh.HostKeyOff {
+			v.Mode = ssh.HostKeyStrict
+		}
+	}
+	delete(res.Ephemeral.ConnInfo, "host_key")
+	res.Ephemeral.ConnInfo["host"] = tunnel.Addr().IP.String()
+	res.Ephemeral.ConnInfo["port"] = strconv.Itoa(tunnel.Addr().Port)
+
+	if v.Mode == "" || v.Mode == ssh.HostKeyOff {
+		warnHostKeyNotVerified(o, res)
+		return tunnel, res, nil
+	}
+
+	v.Dial = tunnel.Dial
+	_, hostKey, err := verifyHostKey(ctx, v, s)
+	if err != nil {
+		_ = tunnel.Close()
+		return nil, nil, err
+	}
+
+	// ... and then we pin it for the local address of the tunnel, so the real
+	// connection (through the tunnel) must present the same key
+	res, err = getConnStateWithPinnedHostKey(res, res.Ephemeral.ConnInfo["host"], hostKey)
+	if err != nil {
+		_ = tunnel.Close()
+		return nil, nil, err
+	}
+	return tunnel, res, nil
+}
+
+// getConnKeyForResource returns the key for the connection in the pool: resources
+// connecting to the same host with the same settings (all the connectionSchemaKeys)
+// share the connection
+func getConnKeyForResource(d *schema.ResourceData, s *terraform.InstanceState) string {
+	keys := []string{}
+	for k := range s.Ephemeral.ConnInfo {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%s\n", k, s.Ephemeral.ConnInfo[k])
+	}
+	for _, k := range connectionSchemaKeys {
+		fmt.Fprintf(h, "%s=%v\n", k, d.Get(k))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getCommunicatorForResource gets a communicator for the remote machine. Resources
+// connecting to the same host share the connection (unless "connection_reuse" is
+// disabled). The returned function must be called when the communicator is not
+// needed anymore.
+func getCommunicatorForResource(ctx context.Context, o terraform.UIOutput, d *schema.ResourceData, s *terraform.InstanceState) (communicator.Communicator, func(), error) {
+	dial := func() (communicator.Communicator, func(), error) {
+		return dialCommunicatorForResource(ctx, o, d, s)
+	}
+
+	if d.Get("connection_reuse").(bool) {
+		return ssh.DefConnPool.Get(getConnKeyForResource(d, s), dial)
+	}
+
+	comm, cleanup, err := dial()
+	if err != nil {
+		return nil, func() {}, err
+	}
+	return comm, func() {
 		_ = comm.Disconnect()
-	}()
+		cleanup()
+	}, nil
+}
 
-	return comm, err
+// dialCommunicatorForResource gets a new communicator for the remote machine, using
+// the candidate key sources or the private key source (if provided). The returned
+// function releases the resources used by the connection (ie, the jump hosts' tunnel).
+func dialCommunicatorForResource(ctx context.Context, o terraform.UIOutput, d *schema.ResourceData, s *terraform.InstanceState) (communicator.Communicator, func(), error) {
+	cleanup := func() {}
+	v := getHostKeyVerificationFromResourceData(d)
+
+	s, err := getConnStateWithCertificate(ctx, d, s)
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	hops, err := getJumpHostsFromResourceData(ctx, d, s.Ephemeral.ConnInfo["user"])
+	if err != nil {
+		return nil, cleanup, err
+	}
+
+	// the host could be still booting: wait until SSH is available in the first hop
+	if timeout := getWaitForConnectionTimeoutFromResourceData(d); timeout > 0 {
+		address := getFirstHopAddress(hops, s.Ephemeral.ConnInfo)
+		o.Output(fmt.Sprintf("Waiting (at most %s) for SSH at %s...", timeout, address))
+		if err := ssh.WaitForSSH(ctx, address, timeout, ssh.DefWaitForSSHInterval); err != nil {
+			return nil, cleanup, err
+		}
+	}
+
+	if len(hops) > 0 {
+		tunnel, connState, err := getConnStateThroughJumpHosts(ctx, o, hops, v, s)
+		if err != nil {
+			return nil, cleanup, err
+		}
+		cleanup = func() { _ = tunnel.Close() }
+		s = connState
+	} else {
+		s, err = getConnStateWithVerifiedHostKey(ctx, o, v, s)
+		if err != nil {
+			return nil, cleanup, err
+		}
+	}
+
+	var comm communicator.Communicator
+	if sources := getPrivateKeySourcesFromResourceData(d); len(sources) > 0 {
+		comm, err = getCommunicatorWithCandidateKeys(ctx, o, s, sources)
+	} else if s, err = getConnStateWithCredentials(ctx, d, s); err == nil {
+		comm, err = getCommunicator(ctx, o, s)
+	}
+
+	// (the session with the agent forwarded uses the same credentials, plus the agent)
+	if err == nil && d.Get("ssh_agent_forwarding").(bool) {
+		comm, err = getCommunicatorWithAgentForwarding(comm, s)
+	}
+	if err != nil {
+		cleanup()
+		return nil, func() {}, err
+	}
+	return comm, cleanup, nil
+}
+
+// DialNode connects to a node with the elements in the ConnectionSchema(), returning a
+// context for running actions in the node (with the privilege escalation requested) and
+// a function for closing the connection
+func DialNode(ctx context.Context, o terraform.UIOutput, d *schema.ResourceData, s *terraform.InstanceState) (context.Context, func(), error) {
+	comm, cleanup, err := dialCommunicatorForResource(ctx, o, d, s)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	closeComm := func() {
+		_ = comm.Disconnect()
+		cleanup()
+	}
+
+	useSudo := getUseSudoFromResourceData(d, s.Ephemeral.ConnInfo["user"])
+	nodeCtx := ssh.WithValues(ctx, o, o, comm, useSudo)
+	return withPrivilegeEscalationFromResourceData(nodeCtx, d), closeComm, nil
+}
+
+// getUseSudoFromResourceData returns true when we must escalate privileges
+// for running commands as root in the node
+func getUseSudoFromResourceData(d *schema.ResourceData, user string) bool {
+	escalation := d.Get("privilege_escalation").(string)
+	preventSudo := d.Get("prevent_sudo").(bool) || escalation == ssh.EscalationNone
+	return !preventSudo && user != "root"
+}
+
+// withPrivilegeEscalationFromResourceData returns a context where commands are run
+// as root with the `sudo`, `doas`, `su`... and the password requested
+func withPrivilegeEscalationFromResourceData(ctx context.Context, d *schema.ResourceData) context.Context {
+	ctx = ssh.WithPrivilegeEscalation(ctx, d.Get("privilege_escalation").(string))
+	if password := d.Get("sudo_password").(string); password != "" {
+		ctx = ssh.WithSudoPassword(ctx, password)
+	}
+	return ctx
+}
+
+// getWaitForConnectionTimeoutFromResourceData returns the maximum time we wait
+// for SSH in the node before provisioning it (0 when we should not wait)
+func getWaitForConnectionTimeoutFromResourceData(d *schema.ResourceData) time.Duration {
+	timeout, err := time.ParseDuration(d.Get("wait_for_connection_timeout").(string))
+	if err != nil || timeout <= 0 {
+		return 0
+	}
+	return timeout
+}
+
+// getFirstHopAddress returns the "host:port" we connect to first for reaching
+// the node: the first jump host, the bastion host or the node itself
+func getFirstHopAddress(hops []ssh.JumpHost, connInfo map[string]string) string {
+	if len(hops) > 0 {
+		return ssh.HostPort{Host: hops[0].Address.Host, Port: hops[0].Address.Port}.String()
+	}
+
+	address := getHostAddressFromConnInfo(connInfo)
+	chain, err := ssh.ParseHostChain(address, ssh.DefSSHPort)
+	if err != nil || len(chain) == 0 {
+		return address
+	}
+	return ssh.HostPort{Host: chain[0].Host, Port: chain[0].Port}.String()
+}
+
+// getHostAddressFromConnInfo returns the address of the host in the connection info,
+// as a ProxyJump-style chain of addresses when a bastion host is used
+// (ie, "bastion:2222,[fd00::1]:22")
+func getHostAddressFromConnInfo(connInfo map[string]string) string {
+	chain := []ssh.HostPort{}
+	for _, prefix := range []string{"bastion_", ""} {
+		host := connInfo[prefix+"host"]
+		if host == "" {
+			continue
+		}
+		hp, err := ssh.ParseHostPort(host, ssh.DefSSHPort)
+		if err != nil {
+			ssh.Debug("could not parse address %q: %s", host, err)
+			return connInfo["host"]
+		}
+		if port, err := strconv.Atoi(connInfo[prefix+"port"]); err == nil && port > 0 {
+			hp.Port = port
+		}
+		chain = append(chain, hp)
+	}
+	return ssh.JoinHostChain(chain)
 }
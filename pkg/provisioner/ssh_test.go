@@ -0,0 +1,179 @@
+// Copyright © 2019 Alvaro Saurin
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provisioner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/communicator"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	gossh "golang.org/x/crypto/ssh"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+)
+
+// newTestHostKey generates a new host key
+func newTestHostKey(t *testing.T) gossh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	signer, err := gossh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	return signer
+}
+
+// startTestSSHServer starts a SSH server (that accepts any password) in a random port
+func startTestSSHServer(t *testing.T, hostKey gossh.Signer) (string, int, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	config := &gossh.ServerConfig{
+		PasswordCallback: func(gossh.ConnMetadata, []byte) (*gossh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := gossh.NewServerConn(conn, config)
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+				go gossh.DiscardRequests(reqs)
+				for newChan := range chans {
+					_ = newChan.Reject(gossh.UnknownChannelType, "unsupported")
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, func() { _ = l.Close() }
+}
+
+func newTestConnState(host string, port int) *terraform.InstanceState {
+	return &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{
+				"type":     "ssh",
+				"host":     host,
+				"port":     strconv.Itoa(port),
+				"user":     "someone",
+				"password": "secret",
+				"timeout":  "5s",
+			},
+		},
+	}
+}
+
+func testConnect(t *testing.T, s *terraform.InstanceState) error {
+	comm, err := communicator.New(s)
+	if err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+	defer comm.Disconnect()
+	return comm.Connect(nil)
+}
+
+func TestGetConnStateWithVerifiedHostKey(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	host, port, stop := startTestSSHServer(t, hostKey)
+	defer stop()
+
+	authorized := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(hostKey.PublicKey())))
+	v := ssh.HostKeyVerification{Mode: ssh.HostKeyStrict, HostKey: authorized}
+	s := newTestConnState(host, port)
+
+	// the verified key is pinned for the real connection (in a port other than 22)
+	res, err := getConnStateWithVerifiedHostKey(context.Background(), ssh.DummyOutput{}, v, s)
+	if err != nil {
+		t.Fatalf("Error: when verifying the host key: %s", err)
+	}
+	if res.Ephemeral.ConnInfo["host_key"] != authorized {
+		t.Fatalf("Error: the host key has not been pinned: %+v", res.Ephemeral.ConnInfo)
+	}
+	if err := testConnect(t, res); err != nil {
+		t.Fatalf("Error: could not connect with the pinned host key: %s", err)
+	}
+
+	// a server presenting other key in the real connection is rejected
+	other := strings.TrimSpace(string(gossh.MarshalAuthorizedKey(newTestHostKey(t).PublicKey())))
+	res, err = getConnStateWithPinnedHostKey(s, host, other)
+	if err != nil {
+		t.Fatalf("Error: when pinning the host key: %s", err)
+	}
+	if err := testConnect(t, res); err == nil {
+		t.Fatalf("Error: connected to a host with a different key")
+	}
+
+	// the key cannot be pinned for the bastion
+	s = newTestConnState(host, port)
+	s.Ephemeral.ConnInfo["bastion_host"] = "bastion.example.com"
+	if _, err := getConnStateWithVerifiedHostKey(context.Background(), ssh.DummyOutput{}, v, s); err == nil {
+		t.Fatalf("Error: no error when verifying the host key through a bastion")
+	}
+}
+
+func TestGetCommunicatorWithAgentForwarding(t *testing.T) {
+	// the agent is never forwarded to a node without a verified host key
+	s := newTestConnState("127.0.0.1", 2222)
+	if _, err := getCommunicatorWithAgentForwarding(ssh.DummyCommunicator{}, s); err == nil {
+		t.Fatalf("Error: the agent can be forwarded to a node without a verified host key")
+	}
+}
+
+func TestGetConnKeyForResource(t *testing.T) {
+	s := &terraform.InstanceState{
+		Ephemeral: terraform.EphemeralState{
+			ConnInfo: map[string]string{"type": "ssh", "host": "10.0.0.1", "user": "root"},
+		},
+	}
+	getKey := func(raw map[string]interface{}) string {
+		d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+		return getConnKeyForResource(d, s)
+	}
+
+	key := getKey(map[string]interface{}{"private_key_sources": []interface{}{"file:///tmp/id_a"}})
+	if key != getKey(map[string]interface{}{"private_key_sources": []interface{}{"file:///tmp/id_a"}}) {
+		t.Fatalf("Error: resources with the same connection settings do not share the connection")
+	}
+	for _, raw := range []map[string]interface{}{
+		{"private_key_sources": []interface{}{"file:///tmp/id_b"}},
+		{"private_key_sources": []interface{}{"file:///tmp/id_a"}, "privilege_escalation": "doas"},
+		{"private_key_sources": []interface{}{"file:///tmp/id_a"}, "sudo_password": "secret"},
+	} {
+		if getKey(raw) == key {
+			t.Fatalf("Error: connection shared with different settings: %v", raw)
+		}
+	}
+}
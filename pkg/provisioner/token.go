@@ -33,10 +33,24 @@ import (
 
 const (
 	// TTL for tokens created for a new join, when no previous token is available
-	newJoinTokenTTL = "1h"
+	// (it must be longer than the joinDeadline, or the token would be replaced right away)
+	newJoinTokenTTL = 2 * time.Hour
+
+	// key in the cluster cache for the token refresh
+	clusterCacheTokenRefreshed = "token-refreshed"
+
+	// key in the cluster cache for the new token created in the refresh
+	clusterCacheNewToken = "token-new"
+
+	// time the token is not checked again after a refresh
+	// (it must be much shorter than the newJoinTokenTTL)
+	tokenRefreshTTL = 10 * time.Minute
 )
 
 var (
+	// TTL for ephemeral tokens (created just for one join, and deleted after that)
+	ephemeralJoinTokenTTL = joinDeadline + 15*time.Minute
+
 	errKubeadmParse = errors.New("error parsing kubeadm output")
 )
 
@@ -50,6 +64,10 @@ type KubeadmToken struct {
 }
 
 func (token KubeadmToken) IsExpired(now time.Time) bool {
+	// tokens created with `--ttl=0` never expire (with an EXPIRES "<never>")
+	if token.Expires.IsZero() {
+		return token.TTL != "<forever>"
+	}
 	return now.After(token.Expires)
 }
 
@@ -105,25 +123,22 @@ func (kt KubeadmTokensSet) FromString(s string) error {
 }
 
 // DoExecKubeadmToken runs a "kubeadm token" command, with a auto-uploaded kubeconfig file
-func DoExecKubeadmToken(d *schema.ResourceData, cmd string) ssh.Action {
-	// upload the local kubeconfig to some temporary remote file
-	remoteKubeconfig, err := ssh.GetTempFilename()
-	if err != nil {
-		return ssh.ActionError(fmt.Sprintf("Could not create temporary file: %s", err))
-	}
-
+func DoExecKubeadmToken(d *schema.ResourceData, args ...string) ssh.Action {
 	kubeconfig := getKubeconfigFromResourceData(d)
 	if kubeconfig == "" {
-		return ssh.ActionError(fmt.Sprintf("Could not get the local kubeconfig: %s", err))
+		return ssh.ActionError("Could not get the local kubeconfig")
 	}
 
 	kubeadm := getKubeadmFromResourceData(d)
 
-	return ssh.DoWithCleanup(ssh.ActionList{
-		ssh.DoUploadFileToFile(kubeconfig, remoteKubeconfig),
-		ssh.DoExec(fmt.Sprintf("%s token --kubeconfig=%s %s", kubeadm, remoteKubeconfig, cmd)),
-	}, ssh.ActionList{
-		ssh.DoTry(ssh.DoDeleteFile(remoteKubeconfig)),
+	// upload the local kubeconfig to some temporary remote file
+	return ssh.DoWithTempFilename(func(remoteKubeconfig string) ssh.Action {
+		return ssh.DoWithCleanup(ssh.ActionList{
+			ssh.DoUploadFileToFile(kubeconfig, remoteKubeconfig),
+			ssh.DoExecCommand(ssh.NewCommand(kubeadm, "token", "--kubeconfig="+remoteKubeconfig).WithArgs(args...)),
+		}, ssh.ActionList{
+			ssh.DoTry(ssh.DoDeleteFile(remoteKubeconfig)),
+		})
 	})
 }
 
@@ -146,8 +161,8 @@ func DoGetCurrentRemoteTokens(d *schema.ResourceData, kts KubeadmTokensSet) ssh.
 	}
 }
 
-// SetNewToken sets a new token in the configuration in the ResourceData
-func DoSetNewToken(d *schema.ResourceData, newToken string) ssh.Action {
+// DoSetNewToken sets a new token (and the time it expires) in the configuration in the ResourceData
+func DoSetNewToken(d *schema.ResourceData, newToken string, expires time.Time) ssh.Action {
 	return ssh.ActionFunc(func(ctx context.Context) ssh.Action {
 		// update the token in "config.join"
 		ssh.Debug("getting current join configuration")
@@ -165,6 +180,15 @@ func DoSetNewToken(d *schema.ResourceData, newToken string) ssh.Action {
 			return ssh.ActionError(err.Error())
 		}
 
+		// ... and the token (and when it expires) in the "config", so the
+		// token is checked against the join deadline in the next refresh
+		config := common.GetProvisionerConfig(d)
+		config["token"] = newToken
+		config["token_expires"] = expires.UTC().Format(time.RFC3339)
+		if err := d.Set("config", config); err != nil {
+			return ssh.ActionError(fmt.Sprintf("cannot update the token in the config: %s", err))
+		}
+
 		return nil
 	})
 }
@@ -172,8 +196,17 @@ func DoSetNewToken(d *schema.ResourceData, newToken string) ssh.Action {
 // checkTokenIsValid checks that the current token is still valid
 func checkTokenIsValid(d *schema.ResourceData, tokens KubeadmTokensSet) ssh.CheckerFunc {
 	currentToken := getTokenFromResourceData(d)
+	expires, expiresKnown := getTokenExpiresFromResourceData(d)
 
 	return ssh.CheckerFunc(func(ctx context.Context) (bool, error) {
+		// the join would happen after the token in the state expires: do not
+		// bother asking the API server, as it will be gone by then
+		deadline := time.Now().Add(joinDeadline)
+		if expiresKnown && deadline.After(expires) {
+			ssh.Debug("token %q expires at %s, before the join deadline (%s)", currentToken, expires, deadline)
+			return false, nil
+		}
+
 		action := DoGetCurrentRemoteTokens(d, tokens)
 		if err := action.Apply(ctx); ssh.IsError(err) {
 			_ = ssh.DoMessageWarn("could not check if token is still valid: will try to create a new token").Apply(ctx)
@@ -189,8 +222,8 @@ func checkTokenIsValid(d *schema.ResourceData, tokens KubeadmTokensSet) ssh.Chec
 			if token.Token == currentToken {
 				ssh.Debug("current token, %q, found in the list of tokens", currentToken)
 
-				if token.IsExpired(time.Now()) {
-					ssh.Debug("token %q seems to be expired", currentToken)
+				if token.IsExpired(deadline) {
+					ssh.Debug("token %q seems to be expired (or it will expire before the join deadline)", currentToken)
 					return false, nil
 				}
 				return true, nil
@@ -213,16 +246,97 @@ func doRefreshToken(d *schema.ResourceData) ssh.Action {
 		return ssh.ActionError(fmt.Sprintf("cannot create new random token: %s", err))
 	}
 
+	// the token created by this node (if any): it is used for the join even
+	// when the cluster cache is disabled
+	var created *KubeadmToken
+
 	return ssh.ActionList{
-		ssh.DoMessageInfo("Checking if current token is still valid..."),
-		ssh.DoIfElse(
-			checkTokenIsValid(d, curTokens),
-			ssh.DoMessageInfo("%q is still a valid token", curTokenInJoinConfig),
+		// the token is checked (and maybe created) only once in the cluster (for a while)...
+		ssh.DoOncePerClusterWithTTL(clusterCacheTokenRefreshed, tokenRefreshTTL,
 			ssh.ActionList{
-				ssh.DoMessageWarn("%q is not valid token anymore: will create a new token %q...", curTokenInJoinConfig, newToken),
-				ssh.DoSendingExecOutputToDevNull(DoExecKubeadmToken(d, fmt.Sprintf("create --ttl=%s %s", newJoinTokenTTL, newToken))),
-				DoSetNewToken(d, newToken),
-				ssh.DoMessageInfo("New token %q created successfully.", newToken),
+				ssh.DoMessageInfo("Checking if current token is still valid..."),
+				ssh.DoIfElse(
+					checkTokenIsValid(d, curTokens),
+					ssh.DoMessageInfo("%q is still a valid token", curTokenInJoinConfig),
+					ssh.ActionList{
+						ssh.DoMessageWarn("%q is not valid token anymore: will create a new token %q...", curTokenInJoinConfig, newToken),
+						ssh.ActionFunc(func(context.Context) ssh.Action {
+							// (the expiration time is taken before creating the token, so it is never too late)
+							token := KubeadmToken{Token: newToken, Expires: time.Now().Add(newJoinTokenTTL)}
+							return ssh.ActionList{
+								ssh.DoSendingExecOutputToDevNull(DoExecKubeadmToken(d, "create", "--ttl="+newJoinTokenTTL.String(), newToken)),
+								ssh.ActionFunc(func(context.Context) ssh.Action {
+									created = &token
+									return nil
+								}),
+								ssh.DoSetInClusterCacheWithTTL(clusterCacheNewToken, token, tokenRefreshTTL),
+							}
+						}),
+						ssh.DoMessageInfo("New token %q created successfully.", newToken),
+					}),
 			}),
+		// ... and then all the nodes use the new token (if some token was created)
+		ssh.ActionFunc(func(ctx context.Context) ssh.Action {
+			if created != nil {
+				return DoSetNewToken(d, created.Token, created.Expires)
+			}
+			if t, ok := ssh.GetFromClusterCache(ctx, clusterCacheNewToken); ok {
+				token := t.(KubeadmToken)
+				return DoSetNewToken(d, token.Token, token.Expires)
+			}
+			return nil
+		}),
+	}
+}
+
+// doCreateEphemeralToken creates a new token, with a short TTL, that will be used
+// only by this node (and deleted with doDeleteToken after the join)
+func doCreateEphemeralToken(d *schema.ResourceData, token string) ssh.Action {
+	description := fmt.Sprintf("ephemeral token for %s", getNodenameFromResourceData(d))
+	return ssh.ActionFunc(func(context.Context) ssh.Action {
+		expires := time.Now().Add(ephemeralJoinTokenTTL)
+		return ssh.ActionList{
+			ssh.DoMessageInfo("Creating ephemeral token for joining the cluster..."),
+			ssh.DoSendingExecOutputToDevNull(DoExecKubeadmToken(d,
+				"create", "--ttl="+ephemeralJoinTokenTTL.String(), "--description="+description, token)),
+			DoSetNewToken(d, token, expires),
+		}
+	})
+}
+
+// doDeleteToken deletes a token
+func doDeleteToken(d *schema.ResourceData, token string) ssh.Action {
+	return ssh.ActionList{
+		ssh.DoMessageInfo("Deleting ephemeral token..."),
+		ssh.DoSendingExecOutputToDevNull(DoExecKubeadmToken(d, "delete", token)),
+	}
+}
+
+// doWithJoinToken runs an action with a valid token: either the current
+// token (refreshed if expired) or, when "ephemeral_token" is enabled,
+// a new token that is deleted right after the action (despite the result).
+func doWithJoinToken(d *schema.ResourceData, action ssh.Action) ssh.Action {
+	if !d.Get("ephemeral_token").(bool) {
+		return ssh.ActionList{
+			ssh.DoRetry(joinRetry, ssh.ActionList{doRefreshToken(d)}),
+			action,
+		}
+	}
+
+	token, err := common.GetRandomToken()
+	if err != nil {
+		return ssh.ActionError(fmt.Sprintf("cannot create new random token: %s", err))
 	}
+
+	return ssh.DoWithCleanup(
+		ssh.ActionList{
+			ssh.DoRetry(joinRetry, ssh.ActionList{doCreateEphemeralToken(d, token)}),
+			action,
+		},
+		ssh.DoTry(doDeleteToken(d, token)))
+}
+
+// doJoinWithToken runs the `join` action (retrying it) with a valid token (see doWithJoinToken())
+func doJoinWithToken(d *schema.ResourceData, join ssh.Action) ssh.Action {
+	return doWithJoinToken(d, ssh.DoRetry(joinRetry, ssh.ActionList{join}))
 }
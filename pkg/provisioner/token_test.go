@@ -15,8 +15,17 @@
 package provisioner
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	kubeadmapi "k8s.io/kubernetes/cmd/kubeadm/app/apis/kubeadm"
+
+	"github.com/inercia/terraform-provider-kubeadm/internal/ssh"
+	"github.com/inercia/terraform-provider-kubeadm/pkg/common"
 )
 
 func TestGetKubeadmTokensFromString(t *testing.T) {
@@ -60,3 +69,160 @@ TOKEN                     TTL       EXPIRES                USAGES
 		}
 	}
 }
+
+func TestKubeadmTokenExpiresBeforeDeadline(t *testing.T) {
+	s := `
+TOKEN                     TTL         EXPIRES                USAGES                   DESCRIPTION   EXTRA GROUPS
+5befc5.a36864a4c9cc2c7d   2m          2020-01-01T20:02:00Z   authentication,signing   <none>        system:bootstrappers:kubeadm:default-node-token
+9befc8.a36864a4c9cc2c7d   <forever>   <never>                authentication,signing   <none>        system:bootstrappers:kubeadm:default-node-token
+`
+	tokens := KubeadmTokensSet{}
+	if err := tokens.FromString(s); err != nil {
+		t.Fatalf("Error: %v", err)
+	}
+
+	now, _ := time.Parse(time.RFC822, "01 Jan 20 20:00 UTC")
+	if tokens["5befc5.a36864a4c9cc2c7d"].IsExpired(now) {
+		t.Fatalf("Error: token reported as expired before its expiration time")
+	}
+	if !tokens["5befc5.a36864a4c9cc2c7d"].IsExpired(now.Add(joinDeadline)) {
+		t.Fatalf("Error: token expiring before the join deadline not detected")
+	}
+	if tokens["9befc8.a36864a4c9cc2c7d"].IsExpired(now.Add(joinDeadline)) {
+		t.Fatalf("Error: token that never expires reported as expired")
+	}
+}
+
+func TestGetTokenExpiresFromResourceData(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{})
+	if _, ok := getTokenExpiresFromResourceData(d); ok {
+		t.Fatalf("Error: token expiration time obtained from an empty config")
+	}
+
+	d = schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"config": map[string]interface{}{
+			"token_expires": "2020-01-02T20:00:00Z",
+		},
+	})
+	expires, ok := getTokenExpiresFromResourceData(d)
+	if !ok {
+		t.Fatalf("Error: token expiration time not obtained")
+	}
+	if expected, _ := time.Parse(time.RFC3339, "2020-01-02T20:00:00Z"); !expires.Equal(expected) {
+		t.Fatalf("Error: unexpected token expiration time: %s", expires)
+	}
+}
+
+func TestDoRefreshTokenWithoutCache(t *testing.T) {
+	// the new token must be used even when the cluster cache is disabled
+	os.Setenv("TF_CACHE", "false")
+	defer os.Unsetenv("TF_CACHE")
+
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("Error: could not create a kubeconfig: %s", err)
+	}
+	defer os.Remove(kubeconfig.Name())
+	_, _ = kubeconfig.WriteString("apiVersion: v1\nkind: Config\n")
+	kubeconfig.Close()
+
+	joinConfigBytes, err := common.JoinConfigToYAML(&kubeadmapi.JoinConfiguration{
+		Discovery: kubeadmapi.Discovery{
+			BootstrapToken: &kubeadmapi.BootstrapTokenDiscovery{Token: "abcdef.0123456789abcdef"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error: could not serialize the join configuration: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join": "api.example.com",
+		"config": map[string]interface{}{
+			"config_path": kubeconfig.Name(),
+			"token":       "abcdef.0123456789abcdef",
+			// (expired: the API server is not even asked)
+			"token_expires": "2020-01-02T20:00:00Z",
+			"join":          common.ToTerraformSafeString(joinConfigBytes),
+		},
+	})
+
+	ctx, _ := ssh.NewTestingContextForUploads([]string{})
+	if res := (ssh.ActionList{doRefreshToken(d)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: when refreshing the token: %s", res)
+	}
+
+	joinConfig, _, err := common.JoinConfigFromResourceData(d)
+	if err != nil {
+		t.Fatalf("Error: could not get the join configuration: %s", err)
+	}
+	if token := joinConfig.Discovery.BootstrapToken.Token; token == "abcdef.0123456789abcdef" || token == "" {
+		t.Fatalf("Error: the new token is not used for the join: %q", token)
+	}
+
+	// the expiration time of the new token must be recorded too
+	if token := getTokenFromResourceData(d); token != joinConfig.Discovery.BootstrapToken.Token {
+		t.Fatalf("Error: the new token is not in the config: %q", token)
+	}
+	expires, ok := getTokenExpiresFromResourceData(d)
+	if !ok || expires.Before(time.Now().Add(joinDeadline)) {
+		t.Fatalf("Error: unexpected expiration time for the new token: %s", expires)
+	}
+}
+
+func TestJoinTokensTTL(t *testing.T) {
+	// new tokens must be valid for a whole join (with all its attempts)
+	if newJoinTokenTTL <= joinDeadline {
+		t.Fatalf("Error: new tokens TTL (%s) is shorter than the join deadline (%s)", newJoinTokenTTL, joinDeadline)
+	}
+	if ephemeralJoinTokenTTL <= joinDeadline {
+		t.Fatalf("Error: ephemeral tokens TTL (%s) is shorter than the join deadline (%s)", ephemeralJoinTokenTTL, joinDeadline)
+	}
+}
+
+func TestDoKubeadmJoinDryRunWithEphemeralToken(t *testing.T) {
+	kubeconfig, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatalf("Error: could not create a kubeconfig: %s", err)
+	}
+	defer os.Remove(kubeconfig.Name())
+	_, _ = kubeconfig.WriteString("apiVersion: v1\nkind: Config\n")
+	kubeconfig.Close()
+
+	joinConfigBytes, err := common.JoinConfigToYAML(&kubeadmapi.JoinConfiguration{
+		Discovery: kubeadmapi.Discovery{
+			BootstrapToken: &kubeadmapi.BootstrapTokenDiscovery{Token: "abcdef.0123456789abcdef"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Error: could not serialize the join configuration: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, map[string]interface{}{
+		"join":            "api.example.com",
+		"dry_run":         true,
+		"ephemeral_token": true,
+		"config": map[string]interface{}{
+			"config_path": kubeconfig.Name(),
+			"join":        common.ToTerraformSafeString(joinConfigBytes),
+		},
+	})
+
+	m := ssh.NewExecutionManifest(ssh.Host{Address: "10.0.0.1"})
+	ctx, _ := ssh.NewTestingContextForUploads([]string{})
+	ctx = ssh.WithExecutionManifest(ctx, m)
+	if res := (ssh.ActionList{doKubeadmJoinDryRun(d)}).Apply(ctx); ssh.IsError(res) {
+		t.Fatalf("Error: when validating the join: %s", res)
+	}
+
+	// the dry run is done with a new token, deleted right after it
+	expected := []string{" token --kubeconfig=", " create ", "--dry-run", " token --kubeconfig=", " delete "}
+	i := 0
+	for _, entry := range m.Entries {
+		for i < len(expected) && strings.Contains(entry.Command, expected[i]) {
+			i++
+		}
+	}
+	if i != len(expected) {
+		t.Fatalf("Error: unexpected commands for the dry run: %+v", m.Entries)
+	}
+}